@@ -125,6 +125,9 @@ const (
 	// Allowed raw datastore access
 	DATASTORE_ACCESS
 
+	// Allows AI enrichment artifacts to add and remove client labels.
+	AI_LABEL_CLIENT
+
 	// When adding new permission - update CheckAccess,
 	// GetRolePermissions and acl.proto
 )
@@ -185,6 +188,8 @@ func (self ACL_PERMISSION) String() string {
 		return "DELETE_RESULTS"
 	case DATASTORE_ACCESS:
 		return "DATASTORE_ACCESS"
+	case AI_LABEL_CLIENT:
+		return "AI_LABEL_CLIENT"
 
 	}
 	return fmt.Sprintf("%d", self)
@@ -239,6 +244,8 @@ func GetPermission(name string) ACL_PERMISSION {
 		return DELETE_RESULTS
 	case "DATASTORE_ACCESS":
 		return DATASTORE_ACCESS
+	case "AI_LABEL_CLIENT":
+		return AI_LABEL_CLIENT
 
 	}
 	return NO_PERMISSIONS
@@ -36,6 +36,7 @@ var (
 		"PREPARE_RESULTS",
 		"DELETE_RESULTS",
 		"DATASTORE_ACCESS",
+		"AI_LABEL_CLIENT",
 	}
 )
 
@@ -114,6 +115,10 @@ func DescribePermissions(token *acl_proto.ApiClientACL) []string {
 		result = append(result, "DATASTORE_ACCESS")
 	}
 
+	if token.AiLabelClients {
+		result = append(result, "AI_LABEL_CLIENT")
+	}
+
 	return result
 }
 
@@ -163,6 +168,8 @@ func SetTokenPermission(
 			token.DeleteResults = true
 		case "DATASTORE_ACCESS":
 			token.DatastoreAccess = true
+		case "AI_LABEL_CLIENT":
+			token.AiLabelClients = true
 
 		default:
 			return errors.New("Unknown permission")
@@ -203,6 +210,7 @@ func GetRolePermissions(
 			result.MachineState = true
 			result.PrepareResults = true
 			result.DeleteResults = true
+			result.AiLabelClients = true
 
 			// An administrator for the root org is allowed to
 			// manipulate orgs.
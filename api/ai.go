@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+
+	"github.com/Velocidex/ordereddict"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/config"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/vql/tools/ollama"
+)
+
+func (self *ApiServer) GetAISettings(
+	ctx context.Context,
+	in *emptypb.Empty) (*config_proto.AIConfig, error) {
+
+	defer Instrument("GetAISettings")()
+
+	users := services.GetUserManager()
+	user_record, org_config_obj, err := users.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, Status(self.verbose, err)
+	}
+	principal := user_record.Name
+
+	permissions := acls.READ_RESULTS
+	perm, err := services.CheckAccess(org_config_obj, principal, permissions)
+	if !perm || err != nil {
+		return nil, PermissionDenied(err,
+			"User is not allowed to view AI settings.")
+	}
+
+	settings, err := ollama.GetAISettings(org_config_obj)
+	if err != nil {
+		return nil, Status(self.verbose, err)
+	}
+	return settings, nil
+}
+
+func (self *ApiServer) SetAISettings(
+	ctx context.Context,
+	in *config_proto.AIConfig) (*emptypb.Empty, error) {
+
+	defer Instrument("SetAISettings")()
+
+	users := services.GetUserManager()
+	user_record, org_config_obj, err := users.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, Status(self.verbose, err)
+	}
+	principal := user_record.Name
+
+	permissions := acls.SERVER_ADMIN
+	perm, err := services.CheckAccess(org_config_obj, principal, permissions)
+	if !perm || err != nil {
+		return nil, PermissionDenied(err,
+			"User is not allowed to manage AI settings.")
+	}
+
+	err = config.ValidateAIConfig(in)
+	if err != nil {
+		return nil, Status(self.verbose, InvalidStatus(err.Error()))
+	}
+
+	err = ollama.SetAISettings(org_config_obj, in)
+	if err == nil {
+		services.LogAudit(ctx,
+			org_config_obj, principal, "User Set AI Settings",
+			ordereddict.NewDict().
+				Set("principal", principal).
+				Set("base_url", in.BaseUrl).
+				Set("default_model", in.DefaultModel).
+				Set("allowed_models", in.AllowedModels))
+	}
+
+	return &emptypb.Empty{}, Status(self.verbose, err)
+}
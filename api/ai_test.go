@@ -0,0 +1,96 @@
+package api_test
+
+import (
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/types/known/emptypb"
+	acl_proto "www.velocidex.com/golang/velociraptor/acls/proto"
+	api_proto "www.velocidex.com/golang/velociraptor/api/proto"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/file_store/test_utils"
+	"www.velocidex.com/golang/velociraptor/grpc_client"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/velociraptor/vtesting"
+	"www.velocidex.com/golang/velociraptor/vtesting/assert"
+)
+
+func (self *GeneralAPITest) TestAISettings() {
+	user_manager := services.GetUserManager()
+	err := user_manager.SetUser(self.Ctx, &api_proto.VelociraptorUser{
+		Name: self.username,
+	})
+	assert.NoError(self.T(), err)
+
+	grant := func(roles ...string) {
+		err := services.GrantUserToOrg(self.Ctx,
+			utils.GetSuperuserName(self.ConfigObj),
+			self.username,
+			[]string{"root"}, &acl_proto.ApiClientACL{
+				Roles: roles,
+			})
+		assert.NoError(self.T(), err)
+	}
+
+	client, closer, err := grpc_client.Factory.GetAPIClient(
+		self.Ctx, grpc_client.API_User, self.ConfigObj)
+	assert.NoError(self.T(), err)
+	defer closer()
+
+	settings := &config_proto.AIConfig{
+		BaseUrl:       "http://localhost:11434",
+		DefaultModel:  "llama3",
+		AllowedModels: []string{"llama3"},
+	}
+
+	// Reading the settings requires READ_RESULTS.
+	grant()
+	_, err = client.GetAISettings(self.Ctx, &emptypb.Empty{})
+	assert.Error(self.T(), err)
+	assert.Contains(self.T(), err.Error(),
+		"PermissionDenied desc = User is not allowed to view AI settings.")
+
+	// Readers may see but not change them.
+	grant("reader")
+	_, err = client.GetAISettings(self.Ctx, &emptypb.Empty{})
+	assert.NoError(self.T(), err)
+
+	_, err = client.SetAISettings(self.Ctx, settings)
+	assert.Error(self.T(), err)
+	assert.Contains(self.T(), err.Error(),
+		"PermissionDenied desc = User is not allowed to manage AI settings.")
+
+	// Changing them requires SERVER_ADMIN.
+	grant("administrator")
+
+	// Invalid settings are rejected and not stored.
+	_, err = client.SetAISettings(self.Ctx, &config_proto.AIConfig{
+		DefaultModel:  "mistral",
+		AllowedModels: []string{"llama3"},
+	})
+	assert.Error(self.T(), err)
+	assert.Contains(self.T(), err.Error(),
+		"AI.default_model mistral is not in AI.allowed_models")
+
+	stored, err := client.GetAISettings(self.Ctx, &emptypb.Empty{})
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), "", stored.DefaultModel)
+
+	_, err = client.SetAISettings(self.Ctx, settings)
+	assert.NoError(self.T(), err)
+
+	stored, err = client.GetAISettings(self.Ctx, &emptypb.Empty{})
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), "llama3", stored.DefaultModel)
+	assert.Equal(self.T(), []string{"llama3"}, stored.AllowedModels)
+
+	// The change is audited.
+	mem_file_store := test_utils.GetMemoryFileStore(self.T(), self.ConfigObj)
+	vtesting.WaitUntil(2*time.Second, self.T(), func() bool {
+		audit := mem_file_store.DebugString()
+		return strings.Contains(audit, `"operation":"User Set AI Settings"`) &&
+			strings.Contains(audit, `"principal":"TestApiUser"`) &&
+			strings.Contains(audit, `"default_model":"llama3"`)
+	})
+}
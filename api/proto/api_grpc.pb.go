@@ -8,8 +8,9 @@ import (
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
-	proto2 "www.velocidex.com/golang/velociraptor/actions/proto"
+	proto3 "www.velocidex.com/golang/velociraptor/actions/proto"
 	proto1 "www.velocidex.com/golang/velociraptor/artifacts/proto"
+	proto2 "www.velocidex.com/golang/velociraptor/config/proto"
 	proto "www.velocidex.com/golang/velociraptor/flows/proto"
 )
 
@@ -122,16 +123,19 @@ type APIClient interface {
 	ModifySecret(ctx context.Context, in *ModifySecretRequest, opts ...grpc.CallOption) (*empty.Empty, error)
 	// Returns a redacted version of the secret.
 	GetSecret(ctx context.Context, in *Secret, opts ...grpc.CallOption) (*Secret, error)
+	// AI settings for the org.
+	GetAISettings(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*proto2.AIConfig, error)
+	SetAISettings(ctx context.Context, in *proto2.AIConfig, opts ...grpc.CallOption) (*empty.Empty, error)
 	// This can be used by API clients to fetch file content.
 	VFSGetBuffer(ctx context.Context, in *VFSFileBuffer, opts ...grpc.CallOption) (*VFSFileBuffer, error)
 	// Streaming free form VQL.
-	Query(ctx context.Context, in *proto2.VQLCollectorArgs, opts ...grpc.CallOption) (API_QueryClient, error)
+	Query(ctx context.Context, in *proto3.VQLCollectorArgs, opts ...grpc.CallOption) (API_QueryClient, error)
 	// Watch for events from the master.
 	WatchEvent(ctx context.Context, in *EventRequest, opts ...grpc.CallOption) (API_WatchEventClient, error)
 	// Push the events to the master
 	PushEvents(ctx context.Context, in *PushEventRequest, opts ...grpc.CallOption) (*empty.Empty, error)
 	// Push monitoring event to the server.
-	WriteEvent(ctx context.Context, in *proto2.VQLResponse, opts ...grpc.CallOption) (*empty.Empty, error)
+	WriteEvent(ctx context.Context, in *proto3.VQLResponse, opts ...grpc.CallOption) (*empty.Empty, error)
 	// Scheduler endpoint for minion scheduling
 	Scheduler(ctx context.Context, opts ...grpc.CallOption) (API_SchedulerClient, error)
 	// Remote data store access.
@@ -799,6 +803,24 @@ func (c *aPIClient) GetSecret(ctx context.Context, in *Secret, opts ...grpc.Call
 	return out, nil
 }
 
+func (c *aPIClient) GetAISettings(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*proto2.AIConfig, error) {
+	out := new(proto2.AIConfig)
+	err := c.cc.Invoke(ctx, "/proto.API/GetAISettings", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *aPIClient) SetAISettings(ctx context.Context, in *proto2.AIConfig, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/proto.API/SetAISettings", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *aPIClient) VFSGetBuffer(ctx context.Context, in *VFSFileBuffer, opts ...grpc.CallOption) (*VFSFileBuffer, error) {
 	out := new(VFSFileBuffer)
 	err := c.cc.Invoke(ctx, "/proto.API/VFSGetBuffer", in, out, opts...)
@@ -808,7 +830,7 @@ func (c *aPIClient) VFSGetBuffer(ctx context.Context, in *VFSFileBuffer, opts ..
 	return out, nil
 }
 
-func (c *aPIClient) Query(ctx context.Context, in *proto2.VQLCollectorArgs, opts ...grpc.CallOption) (API_QueryClient, error) {
+func (c *aPIClient) Query(ctx context.Context, in *proto3.VQLCollectorArgs, opts ...grpc.CallOption) (API_QueryClient, error) {
 	stream, err := c.cc.NewStream(ctx, &API_ServiceDesc.Streams[0], "/proto.API/Query", opts...)
 	if err != nil {
 		return nil, err
@@ -824,7 +846,7 @@ func (c *aPIClient) Query(ctx context.Context, in *proto2.VQLCollectorArgs, opts
 }
 
 type API_QueryClient interface {
-	Recv() (*proto2.VQLResponse, error)
+	Recv() (*proto3.VQLResponse, error)
 	grpc.ClientStream
 }
 
@@ -832,8 +854,8 @@ type aPIQueryClient struct {
 	grpc.ClientStream
 }
 
-func (x *aPIQueryClient) Recv() (*proto2.VQLResponse, error) {
-	m := new(proto2.VQLResponse)
+func (x *aPIQueryClient) Recv() (*proto3.VQLResponse, error) {
+	m := new(proto3.VQLResponse)
 	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
@@ -881,7 +903,7 @@ func (c *aPIClient) PushEvents(ctx context.Context, in *PushEventRequest, opts .
 	return out, nil
 }
 
-func (c *aPIClient) WriteEvent(ctx context.Context, in *proto2.VQLResponse, opts ...grpc.CallOption) (*empty.Empty, error) {
+func (c *aPIClient) WriteEvent(ctx context.Context, in *proto3.VQLResponse, opts ...grpc.CallOption) (*empty.Empty, error) {
 	out := new(empty.Empty)
 	err := c.cc.Invoke(ctx, "/proto.API/WriteEvent", in, out, opts...)
 	if err != nil {
@@ -1070,16 +1092,19 @@ type APIServer interface {
 	ModifySecret(context.Context, *ModifySecretRequest) (*empty.Empty, error)
 	// Returns a redacted version of the secret.
 	GetSecret(context.Context, *Secret) (*Secret, error)
+	// AI settings for the org.
+	GetAISettings(context.Context, *empty.Empty) (*proto2.AIConfig, error)
+	SetAISettings(context.Context, *proto2.AIConfig) (*empty.Empty, error)
 	// This can be used by API clients to fetch file content.
 	VFSGetBuffer(context.Context, *VFSFileBuffer) (*VFSFileBuffer, error)
 	// Streaming free form VQL.
-	Query(*proto2.VQLCollectorArgs, API_QueryServer) error
+	Query(*proto3.VQLCollectorArgs, API_QueryServer) error
 	// Watch for events from the master.
 	WatchEvent(*EventRequest, API_WatchEventServer) error
 	// Push the events to the master
 	PushEvents(context.Context, *PushEventRequest) (*empty.Empty, error)
 	// Push monitoring event to the server.
-	WriteEvent(context.Context, *proto2.VQLResponse) (*empty.Empty, error)
+	WriteEvent(context.Context, *proto3.VQLResponse) (*empty.Empty, error)
 	// Scheduler endpoint for minion scheduling
 	Scheduler(API_SchedulerServer) error
 	// Remote data store access.
@@ -1312,10 +1337,16 @@ func (UnimplementedAPIServer) ModifySecret(context.Context, *ModifySecretRequest
 func (UnimplementedAPIServer) GetSecret(context.Context, *Secret) (*Secret, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetSecret not implemented")
 }
+func (UnimplementedAPIServer) GetAISettings(context.Context, *empty.Empty) (*proto2.AIConfig, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAISettings not implemented")
+}
+func (UnimplementedAPIServer) SetAISettings(context.Context, *proto2.AIConfig) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetAISettings not implemented")
+}
 func (UnimplementedAPIServer) VFSGetBuffer(context.Context, *VFSFileBuffer) (*VFSFileBuffer, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method VFSGetBuffer not implemented")
 }
-func (UnimplementedAPIServer) Query(*proto2.VQLCollectorArgs, API_QueryServer) error {
+func (UnimplementedAPIServer) Query(*proto3.VQLCollectorArgs, API_QueryServer) error {
 	return status.Errorf(codes.Unimplemented, "method Query not implemented")
 }
 func (UnimplementedAPIServer) WatchEvent(*EventRequest, API_WatchEventServer) error {
@@ -1324,7 +1355,7 @@ func (UnimplementedAPIServer) WatchEvent(*EventRequest, API_WatchEventServer) er
 func (UnimplementedAPIServer) PushEvents(context.Context, *PushEventRequest) (*empty.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method PushEvents not implemented")
 }
-func (UnimplementedAPIServer) WriteEvent(context.Context, *proto2.VQLResponse) (*empty.Empty, error) {
+func (UnimplementedAPIServer) WriteEvent(context.Context, *proto3.VQLResponse) (*empty.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method WriteEvent not implemented")
 }
 func (UnimplementedAPIServer) Scheduler(API_SchedulerServer) error {
@@ -2654,6 +2685,42 @@ func _API_GetSecret_Handler(srv interface{}, ctx context.Context, dec func(inter
 	return interceptor(ctx, in, info, handler)
 }
 
+func _API_GetAISettings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(empty.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(APIServer).GetAISettings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.API/GetAISettings",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(APIServer).GetAISettings(ctx, req.(*empty.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _API_SetAISettings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(proto2.AIConfig)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(APIServer).SetAISettings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.API/SetAISettings",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(APIServer).SetAISettings(ctx, req.(*proto2.AIConfig))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _API_VFSGetBuffer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(VFSFileBuffer)
 	if err := dec(in); err != nil {
@@ -2673,7 +2740,7 @@ func _API_VFSGetBuffer_Handler(srv interface{}, ctx context.Context, dec func(in
 }
 
 func _API_Query_Handler(srv interface{}, stream grpc.ServerStream) error {
-	m := new(proto2.VQLCollectorArgs)
+	m := new(proto3.VQLCollectorArgs)
 	if err := stream.RecvMsg(m); err != nil {
 		return err
 	}
@@ -2681,7 +2748,7 @@ func _API_Query_Handler(srv interface{}, stream grpc.ServerStream) error {
 }
 
 type API_QueryServer interface {
-	Send(*proto2.VQLResponse) error
+	Send(*proto3.VQLResponse) error
 	grpc.ServerStream
 }
 
@@ -2689,7 +2756,7 @@ type aPIQueryServer struct {
 	grpc.ServerStream
 }
 
-func (x *aPIQueryServer) Send(m *proto2.VQLResponse) error {
+func (x *aPIQueryServer) Send(m *proto3.VQLResponse) error {
 	return x.ServerStream.SendMsg(m)
 }
 
@@ -2733,7 +2800,7 @@ func _API_PushEvents_Handler(srv interface{}, ctx context.Context, dec func(inte
 }
 
 func _API_WriteEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(proto2.VQLResponse)
+	in := new(proto3.VQLResponse)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
@@ -2745,7 +2812,7 @@ func _API_WriteEvent_Handler(srv interface{}, ctx context.Context, dec func(inte
 		FullMethod: "/proto.API/WriteEvent",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(APIServer).WriteEvent(ctx, req.(*proto2.VQLResponse))
+		return srv.(APIServer).WriteEvent(ctx, req.(*proto3.VQLResponse))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -3161,6 +3228,14 @@ var API_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetSecret",
 			Handler:    _API_GetSecret_Handler,
 		},
+		{
+			MethodName: "GetAISettings",
+			Handler:    _API_GetAISettings_Handler,
+		},
+		{
+			MethodName: "SetAISettings",
+			Handler:    _API_SetAISettings_Handler,
+		},
 		{
 			MethodName: "VFSGetBuffer",
 			Handler:    _API_VFSGetBuffer_Handler,
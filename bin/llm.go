@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	logging "www.velocidex.com/golang/velociraptor/logging"
+	"www.velocidex.com/golang/velociraptor/reporting"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/startup"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/velociraptor/vql/acl_managers"
+	"www.velocidex.com/golang/vfilter"
+)
+
+var (
+	llm_command = app.Command(
+		"llm", "Work with large language model servers.")
+
+	llm_bench_command = llm_command.Command(
+		"bench", "Measure the throughput and latency of a model at increasing concurrency.")
+
+	llm_bench_model = llm_bench_command.Flag(
+		"model", "The model to benchmark").Required().String()
+
+	llm_bench_base_url = llm_bench_command.Flag(
+		"base_url", "The URL of the Ollama server").
+		Default("http://localhost:11434").String()
+
+	llm_bench_concurrency = llm_bench_command.Flag(
+		"concurrency", "Comma separated numbers of concurrent requests").
+		Default("1,2,4,8").String()
+
+	llm_bench_requests = llm_bench_command.Flag(
+		"requests", "Requests sent at each concurrency").Default("20").Int64()
+
+	llm_bench_prompt_tokens = llm_bench_command.Flag(
+		"prompt_tokens", "Approximate size of each prompt in tokens").
+		Default("256").Int64()
+
+	llm_bench_max_tokens = llm_bench_command.Flag(
+		"max_tokens", "The most tokens in each response").Default("128").Int64()
+
+	llm_bench_format = llm_bench_command.Flag("format", "Output format").
+				Default("text").Enum("text", "json", "jsonl")
+
+	llm_query_command = llm_command.Command(
+		"query", "Send a single prompt to a model.")
+
+	llm_query_prompt = llm_query_command.Arg(
+		"prompt", "The prompt to send").Required().String()
+
+	llm_query_model = llm_query_command.Flag(
+		"model", "The model to use").Required().String()
+
+	llm_query_base_url = llm_query_command.Flag(
+		"base_url", "The URL of the Ollama server").
+		Default("http://localhost:11434").String()
+
+	llm_query_secret = llm_query_command.Flag(
+		"secret", "Read the URL and headers from this HTTP Secret (needs --config and --runas)").
+		String()
+
+	llm_query_system = llm_query_command.Flag(
+		"system", "A system prompt").String()
+
+	llm_query_vql = llm_query_command.Flag(
+		"query", "A VQL query whose rows are sent with the prompt").String()
+
+	llm_query_max_tokens = llm_query_command.Flag(
+		"max_tokens", "The most tokens in the response").Int64()
+
+	llm_query_format = llm_query_command.Flag("format", "Output format").
+				Default("text").Enum("text", "json", "jsonl")
+)
+
+func doLLMBench() error {
+	logging.DisableLogging()
+
+	config_obj, err := makeDefaultConfigLoader().
+		WithNullLoader().LoadAndValidate()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := install_sig_handler()
+	defer cancel()
+
+	config_obj.Services = services.GenericToolServices()
+	sm, err := startup.StartToolServices(ctx, config_obj)
+	defer sm.Close()
+
+	if err != nil {
+		return err
+	}
+
+	var concurrency []string
+	for _, level := range strings.Split(*llm_bench_concurrency, ",") {
+		level = strings.TrimSpace(level)
+		if level != "" {
+			concurrency = append(concurrency, level)
+		}
+	}
+
+	logger := &LogWriter{config_obj: config_obj}
+	builder := services.ScopeBuilder{
+		Config:     config_obj,
+		ACLManager: acl_managers.NullACLManager{},
+		Logger:     log.New(logger, "", 0),
+		Env: ordereddict.NewDict().
+			Set(vql_subsystem.ACL_MANAGER_VAR,
+				acl_managers.NewRoleACLManager(config_obj, "administrator")).
+			Set("Model", *llm_bench_model).
+			Set("BaseUrl", *llm_bench_base_url).
+			Set("Concurrency", concurrency).
+			Set("Requests", *llm_bench_requests).
+			Set("PromptTokens", *llm_bench_prompt_tokens).
+			Set("MaxTokens", *llm_bench_max_tokens),
+	}
+
+	manager, err := services.GetRepositoryManager(config_obj)
+	if err != nil {
+		return err
+	}
+
+	scope := manager.BuildScope(builder)
+	defer scope.Close()
+
+	vql, err := vfilter.Parse(`
+SELECT * FROM ollama_bench(model=Model, base_url=BaseUrl,
+   concurrency=Concurrency, requests=Requests,
+   prompt_tokens=PromptTokens, max_tokens=MaxTokens)`)
+	if err != nil {
+		return err
+	}
+
+	switch *llm_bench_format {
+	case "text":
+		table := reporting.EvalQueryToTable(ctx, scope, vql, os.Stdout)
+		table.Render()
+
+	case "jsonl":
+		err = outputJSONL(ctx, scope, vql, os.Stdout)
+		if err != nil {
+			return err
+		}
+
+	case "json":
+		err = outputJSON(ctx, scope, vql, os.Stdout)
+		if err != nil {
+			return err
+		}
+	}
+	return logger.Error
+}
+
+func doLLMQuery() error {
+	logging.DisableLogging()
+
+	config_obj, err := makeDefaultConfigLoader().
+		WithNullLoader().LoadAndValidate()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := install_sig_handler()
+	defer cancel()
+
+	config_obj.Services = services.GenericToolServices()
+	sm, err := startup.StartToolServices(ctx, config_obj)
+	defer sm.Close()
+
+	if err != nil {
+		return err
+	}
+
+	base_url := *llm_query_base_url
+	if *llm_query_secret != "" {
+		base_url = "secret://" + *llm_query_secret
+	}
+
+	// Secrets are only shared with users so the query must run as
+	// one of them.
+	var acl_manager vql_subsystem.ACLManager = acl_managers.NewRoleACLManager(
+		config_obj, "administrator")
+	if *run_as != "" {
+		acl_manager = acl_managers.NewServerACLManager(config_obj, *run_as)
+	}
+
+	logger := &LogWriter{config_obj: config_obj}
+	builder := services.ScopeBuilder{
+		Config:     config_obj,
+		ACLManager: acl_managers.NullACLManager{},
+		Logger:     log.New(logger, "", 0),
+		Env: ordereddict.NewDict().
+			Set(vql_subsystem.ACL_MANAGER_VAR, acl_manager).
+			Set("Model", *llm_query_model).
+			Set("BaseUrl", base_url).
+			Set("Prompt", *llm_query_prompt).
+			Set("System", *llm_query_system).
+			Set("MaxTokens", *llm_query_max_tokens),
+	}
+
+	manager, err := services.GetRepositoryManager(config_obj)
+	if err != nil {
+		return err
+	}
+
+	scope := manager.BuildScope(builder)
+	defer scope.Close()
+
+	query := `
+SELECT * FROM ollama(model=Model, base_url=BaseUrl, prompt=Prompt,
+   system=System, max_tokens=MaxTokens, cache_bypass=TRUE)`
+	if *llm_query_vql != "" {
+		query = fmt.Sprintf(`
+LET Input = %s
+
+SELECT * FROM ollama(model=Model, base_url=BaseUrl, prompt=Prompt,
+   system=System, max_tokens=MaxTokens, cache_bypass=TRUE, query=Input)`,
+			*llm_query_vql)
+	}
+
+	vqls, err := vfilter.MultiParse(query)
+	if err != nil {
+		return err
+	}
+
+	switch *llm_query_format {
+	case "text":
+		// Only print the response so it can be piped to other tools.
+		for _, vql := range vqls {
+			for row := range vql.Eval(ctx, scope) {
+				response, _ := scope.Associative(row, "Response")
+				fmt.Println(utils.ToString(response))
+			}
+		}
+
+	case "jsonl":
+		for _, vql := range vqls {
+			err = outputJSONL(ctx, scope, vql, os.Stdout)
+			if err != nil {
+				return err
+			}
+		}
+
+	case "json":
+		for _, vql := range vqls {
+			err = outputJSON(ctx, scope, vql, os.Stdout)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return logger.Error
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case llm_bench_command.FullCommand():
+			FatalIfError(llm_bench_command, doLLMBench)
+
+		case llm_query_command.FullCommand():
+			FatalIfError(llm_query_command, doLLMQuery)
+
+		default:
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Velocidex/ordereddict"
+	logging "www.velocidex.com/golang/velociraptor/logging"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/startup"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/velociraptor/vql/acl_managers"
+	"www.velocidex.com/golang/velociraptor/vql/tools/ollama"
+)
+
+var (
+	llm_bundle_command = llm_command.Command(
+		"bundle", "Move models, prompts and AI artifacts to an offline server.")
+
+	llm_bundle_export_command = llm_bundle_command.Command(
+		"export", "Export models, prompts and AI artifacts into a bundle signed with the server's key.")
+
+	llm_bundle_export_output = llm_bundle_export_command.Arg(
+		"output", "The bundle file to write").Required().String()
+
+	llm_bundle_export_models = llm_bundle_export_command.Flag(
+		"model", "A model to export (may be given more than once)").Strings()
+
+	llm_bundle_export_models_dir = llm_bundle_export_command.Flag(
+		"models_dir", "The Ollama model store (default $OLLAMA_MODELS or ~/.ollama/models)").
+		String()
+
+	llm_bundle_export_prompts = llm_bundle_export_command.Flag(
+		"prompts", "A directory of prompt files to export").String()
+
+	llm_bundle_export_artifacts = llm_bundle_export_command.Flag(
+		"artifact", "An artifact to export (may be given more than once)").Strings()
+
+	llm_bundle_import_command = llm_bundle_command.Command(
+		"import", "Verify a bundle and import it on an offline server.")
+
+	llm_bundle_import_file = llm_bundle_import_command.Arg(
+		"bundle", "The bundle file to import").Required().ExistingFile()
+
+	llm_bundle_import_base_url = llm_bundle_import_command.Flag(
+		"base_url", "The URL of the Ollama server the models are created on").
+		Default("http://localhost:11434").String()
+
+	llm_bundle_import_signer = llm_bundle_import_command.Flag(
+		"signer", "The certificate the bundle must be signed with (default the server certificate issued by the config's CA with the pinned server name)").
+		ExistingFile()
+
+	llm_bundle_import_prompts = llm_bundle_import_command.Flag(
+		"prompts", "Write the prompts to this directory").String()
+
+	llm_bundle_import_artifacts = llm_bundle_import_command.Flag(
+		"artifacts", "Write the artifacts to this directory, which the server loads with --definitions").
+		String()
+
+	llm_bundle_import_verify = llm_bundle_import_command.Flag(
+		"verify", "Only verify the bundle and list what it holds").Bool()
+)
+
+func doLLMBundleExport() error {
+	logging.DisableLogging()
+
+	config_obj, err := makeDefaultConfigLoader().
+		WithRequiredFrontend().LoadAndValidate()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := install_sig_handler()
+	defer cancel()
+
+	config_obj.Services = services.GenericToolServices()
+	sm, err := startup.StartToolServices(ctx, config_obj)
+	defer sm.Close()
+
+	if err != nil {
+		return err
+	}
+
+	manager, err := services.GetRepositoryManager(config_obj)
+	if err != nil {
+		return err
+	}
+
+	repository, err := manager.GetGlobalRepository(config_obj)
+	if err != nil {
+		return err
+	}
+
+	options := &ollama.BundleExportOptions{
+		Models:     *llm_bundle_export_models,
+		ModelsDir:  *llm_bundle_export_models_dir,
+		PromptsDir: *llm_bundle_export_prompts,
+	}
+
+	for _, name := range *llm_bundle_export_artifacts {
+		artifact, pres := repository.Get(ctx, config_obj, name)
+		if !pres {
+			return fmt.Errorf("Artifact %v not found", name)
+		}
+		options.Artifacts = append(options.Artifacts, artifact)
+	}
+
+	fd, err := os.OpenFile(*llm_bundle_export_output,
+		os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	bundle_manifest, err := ollama.ExportBundle(ctx, config_obj, fd, options)
+	if err != nil {
+		fd.Close()
+		os.Remove(*llm_bundle_export_output)
+		return err
+	}
+
+	err = fd.Close()
+	if err != nil {
+		return err
+	}
+
+	printBundleManifest(bundle_manifest)
+	return nil
+}
+
+func doLLMBundleImport() error {
+	logging.DisableLogging()
+
+	config_obj, err := makeDefaultConfigLoader().
+		WithRequiredFrontend().LoadAndValidate()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := install_sig_handler()
+	defer cancel()
+
+	config_obj.Services = services.GenericToolServices()
+	sm, err := startup.StartToolServices(ctx, config_obj)
+	defer sm.Close()
+
+	if err != nil {
+		return err
+	}
+
+	var trusted_pem []byte
+	if *llm_bundle_import_signer != "" {
+		trusted_pem, err = os.ReadFile(*llm_bundle_import_signer)
+		if err != nil {
+			return err
+		}
+	}
+
+	bundle, err := ollama.OpenBundle(config_obj,
+		*llm_bundle_import_file, trusted_pem)
+	if err != nil {
+		return err
+	}
+	defer bundle.Close()
+
+	fmt.Printf("Bundle signed by %v on %v\n", bundle.Signer,
+		bundle.Manifest.Created)
+	printBundleManifest(bundle.Manifest)
+
+	if *llm_bundle_import_verify {
+		return nil
+	}
+
+	manager, err := services.GetRepositoryManager(config_obj)
+	if err != nil {
+		return err
+	}
+
+	// Check the artifacts before anything is imported.
+	repository := manager.NewRepository()
+	for _, file := range bundle.Manifest.Artifacts {
+		data, err := bundle.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		_, err = repository.LoadYaml(string(data), services.ArtifactOptions{
+			ValidateArtifact:  true,
+			ArtifactIsBuiltIn: true,
+		})
+		if err != nil {
+			return fmt.Errorf("Invalid artifact %v: %w", file.Name, err)
+		}
+	}
+
+	if len(bundle.Manifest.Models) > 0 {
+		logger := &LogWriter{config_obj: config_obj}
+		scope := manager.BuildScope(services.ScopeBuilder{
+			Config:     config_obj,
+			ACLManager: acl_managers.NullACLManager{},
+			Logger:     log.New(logger, "", 0),
+			Env: ordereddict.NewDict().
+				Set(vql_subsystem.ACL_MANAGER_VAR,
+					acl_managers.NewRoleACLManager(config_obj, "administrator")),
+		})
+		defer scope.Close()
+
+		client, err := ollama.NewClient(ctx, scope, *llm_bundle_import_base_url)
+		if err != nil {
+			return err
+		}
+
+		for _, model := range bundle.Manifest.Models {
+			err = bundle.ImportModel(ctx, client, model,
+				func(resp *ollama.ProgressResponse) error {
+					fmt.Printf("%v: %v\n", model.Name, resp.Status)
+					return nil
+				})
+			if err != nil {
+				return fmt.Errorf("Unable to import %v: %w", model.Name, err)
+			}
+		}
+	}
+
+	err = extractBundleFiles(bundle, bundle.Manifest.Prompts,
+		*llm_bundle_import_prompts, "--prompts")
+	if err != nil {
+		return err
+	}
+
+	return extractBundleFiles(bundle, bundle.Manifest.Artifacts,
+		*llm_bundle_import_artifacts, "--artifacts")
+}
+
+func extractBundleFiles(bundle *ollama.Bundle,
+	files []*ollama.BundleFile, dir, flag string) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	if dir == "" {
+		fmt.Printf("Skipped %v files in the bundle since %v was not given\n",
+			len(files), flag)
+		return nil
+	}
+
+	for _, file := range files {
+		target, err := bundle.Extract(file, dir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %v\n", target)
+	}
+	return nil
+}
+
+func printBundleManifest(manifest *ollama.BundleManifest) {
+	for _, model := range manifest.Models {
+		fmt.Printf("model     %v (%v bytes)\n", model.Name, model.Weights.Size)
+	}
+	for _, file := range manifest.Prompts {
+		fmt.Printf("prompt    %v\n", file.Name)
+	}
+	for _, file := range manifest.Artifacts {
+		fmt.Printf("artifact  %v\n", file.Name)
+	}
+}
+
+func init() {
+	command_handlers = append(command_handlers, func(command string) bool {
+		switch command {
+		case llm_bundle_export_command.FullCommand():
+			FatalIfError(llm_bundle_export_command, doLLMBundleExport)
+
+		case llm_bundle_import_command.FullCommand():
+			FatalIfError(llm_bundle_import_command, doLLMBundleImport)
+
+		default:
+			return false
+		}
+		return true
+	})
+}
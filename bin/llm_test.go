@@ -0,0 +1,30 @@
+package main_test
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLLMQuery(t *testing.T) {
+	binary, _ := SetupTest(t)
+
+	// Only the response is printed so it can be piped.
+	cmd := exec.Command(binary, "llm", "query", "--model", "llama3",
+		"--base_url", "mock://", "Hi")
+	out, err := cmd.Output()
+	require.NoError(t, err, string(out))
+	assert.Equal(t, "Mock response from llama3\n", string(out))
+
+	// The rows of the query are sent after the prompt.
+	cmd = exec.Command(binary, "llm", "query", "--model", "llama3",
+		"--base_url", "mock://?response={{.Prompt}}", "--format", "jsonl",
+		"--query", "SELECT 'Hostname' AS Name FROM scope()",
+		"Summarize the rows")
+	out, err = cmd.Output()
+	require.NoError(t, err, string(out))
+	assert.Contains(t, string(out), "Summarize the rows")
+	assert.Contains(t, string(out), "Hostname")
+}
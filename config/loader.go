@@ -457,6 +457,13 @@ func (self *Loader) Validate(config_obj *config_proto.Config) error {
 		}
 	}
 
+	if config_obj.AI != nil {
+		err = ValidateAIConfig(config_obj.AI)
+		if err != nil {
+			return err
+		}
+	}
+
 	if config_obj.Client != nil {
 		// We only use the writeback for certain cases where is it
 		// needed:
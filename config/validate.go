@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"regexp"
 	"strings"
 
@@ -216,3 +217,106 @@ func ValidateDatastoreConfig(config_obj *config_proto.Config) error {
 
 	return nil
 }
+
+// Checks the AI settings so mistakes are found when the config is
+// loaded rather than on the first model call.
+func ValidateAIConfig(ai_config *config_proto.AIConfig) error {
+	if ai_config.BaseUrl != "" {
+		err := validateAIUrl("AI.base_url", ai_config.BaseUrl)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, endpoint := range ai_config.Endpoints {
+		err := validateAIUrl("AI.endpoints", endpoint)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch ai_config.LoadBalancing {
+	case "", "least_busy", "round_robin":
+	default:
+		return fmt.Errorf(
+			"AI.load_balancing should be least_busy or round_robin not %v",
+			ai_config.LoadBalancing)
+	}
+
+	for _, model := range ai_config.AllowedModels {
+		if strings.TrimSpace(model) == "" {
+			return errors.New("AI.allowed_models can not contain empty names")
+		}
+	}
+
+	if ai_config.DefaultModel != "" && len(ai_config.AllowedModels) > 0 &&
+		!utils.InString(ai_config.AllowedModels, ai_config.DefaultModel) {
+		return fmt.Errorf("AI.default_model %v is not in AI.allowed_models",
+			ai_config.DefaultModel)
+	}
+
+	if ai_config.MaxPromptTokens < 0 {
+		return errors.New("AI.max_prompt_tokens can not be negative")
+	}
+
+	if ai_config.MaxResponseTokens < 0 {
+		return errors.New("AI.max_response_tokens can not be negative")
+	}
+
+	for _, artifact := range ai_config.SummarizeArtifacts {
+		if strings.TrimSpace(artifact) == "" {
+			return errors.New("AI.summarize_artifacts can not contain empty names")
+		}
+	}
+
+	for _, model := range ai_config.ModelPool {
+		if strings.TrimSpace(model.Name) == "" {
+			return errors.New("AI.model_pool can not contain empty names")
+		}
+
+		if model.ContextWindow < 0 {
+			return fmt.Errorf("AI.model_pool %v: context_window can not be negative",
+				model.Name)
+		}
+
+		if len(ai_config.AllowedModels) > 0 &&
+			!utils.InString(ai_config.AllowedModels, model.Name) {
+			return fmt.Errorf("AI.model_pool %v is not in AI.allowed_models",
+				model.Name)
+		}
+	}
+
+	for _, price := range ai_config.Pricing {
+		if strings.TrimSpace(price.Model) == "" {
+			return errors.New("AI.pricing can not contain empty models")
+		}
+
+		if price.PromptPrice < 0 || price.CompletionPrice < 0 {
+			return fmt.Errorf("AI.pricing %v: prices can not be negative",
+				price.Model)
+		}
+	}
+
+	return nil
+}
+
+func validateAIUrl(field, value string) error {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("%v is invalid: %w", field, err)
+	}
+
+	switch parsed.Scheme {
+	case "http", "https", "openai+http", "openai+https":
+		if parsed.Host == "" {
+			return fmt.Errorf("%v has no host: %v", field, value)
+		}
+
+	case "secret", "mock", "srv", "azure", "bedrock", "vertex":
+	default:
+		return fmt.Errorf(
+			"%v should be an http, https, openai+http, openai+https, secret, srv, azure, bedrock, vertex or mock URL not %v",
+			field, value)
+	}
+	return nil
+}
@@ -178,6 +178,11 @@ const (
 	SPLUNK_CREDS    = "Splunk Creds"
 	ELASTIC_CREDS   = "Elastic Creds"
 	SMTP_CREDS      = "SMTP Creds"
+	WEBHOOK_SECRETS = "Webhook Secrets"
+
+	AZURE_OPENAI_CREDS = "Azure OpenAI Creds"
+	AWS_BEDROCK_CREDS  = "AWS Bedrock Creds"
+	GCP_VERTEX_CREDS   = "GCP Vertex Creds"
 
 	// The name of the annotation timeline
 	TIMELINE_ANNOTATION      = "Annotation"
@@ -233,6 +233,7 @@ const English = {
     "Perm_PREPARE_RESULTS" : "Prepare Results",
     "Perm_DELETE_RESULTS" : "Delete Results",
     "Perm_DATASTORE_ACCESS" : "Datastore Access",
+    "Perm_AI_LABEL_CLIENT" : "AI Label Clients",
 
 
     "ToolPerm_ALL_QUERY" : "Issue all queries without restriction",
@@ -257,6 +258,7 @@ const English = {
     "ToolPerm_PREPARE_RESULTS" : "Allowed to create zip files",
     "ToolPerm_DELETE_RESULTS" : "Allowed to delete clients, flows and other data",
     "ToolPerm_DATASTORE_ACCESS" : " Allowed raw datastore access",
+    "ToolPerm_AI_LABEL_CLIENT" : "Allows AI enrichment artifacts to add and remove client labels",
 
     "ToolUsernamePasswordless" :
     <>
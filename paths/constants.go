@@ -91,4 +91,9 @@ var (
 
 	ThirdPartyInventory = path_specs.NewSafeDatastorePath(
 		"config", "inventory").SetType(api.PATH_TYPE_DATASTORE_JSON)
+
+	// AI settings changed through the GUI. These override the AI
+	// section of the config file.
+	AISettings = path_specs.NewSafeDatastorePath(
+		"config", "ai").SetType(api.PATH_TYPE_DATASTORE_JSON)
 )
@@ -92,6 +92,11 @@ func (self FlowPathManager) UploadMetadata() api.FSPathSpec {
 	return self.Path().AddChild("uploads").AsFilestorePath()
 }
 
+// AI analyses attached to the flow.
+func (self FlowPathManager) LLMAnalyses() api.FSPathSpec {
+	return self.Path().AddChild("llm_analyses").AsFilestorePath()
+}
+
 func (self FlowPathManager) UploadContainer() api.FSPathSpec {
 	return self.Path().AddUnsafeChild("uploads").
 		AsFilestorePath().
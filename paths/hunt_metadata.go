@@ -76,6 +76,12 @@ func (self HuntPathManager) EnrichedClients() api.FSPathSpec {
 	return HUNTS_ROOT.AddChild(self.hunt_id, "enriched").AsFilestorePath()
 }
 
+// AI analyses attached to the hunt.
+func (self HuntPathManager) LLMAnalyses() api.FSPathSpec {
+	return HUNTS_ROOT.AddChild(self.hunt_id, "llm_analyses").
+		AsFilestorePath()
+}
+
 // Where to store client errors.
 func (self HuntPathManager) ClientErrors() api.FSPathSpec {
 	return HUNTS_ROOT.AddChild(self.hunt_id + "_errors").
@@ -0,0 +1,49 @@
+package paths
+
+import (
+	"www.velocidex.com/golang/velociraptor/file_store/api"
+	"www.velocidex.com/golang/velociraptor/file_store/path_specs"
+)
+
+var (
+	LLM_ROOT = path_specs.NewUnsafeFilestorePath("llm").
+		SetType(api.PATH_TYPE_FILESTORE_JSON)
+)
+
+type LLMPathManager struct{}
+
+// The message history of a named conversation. Sessions are stored
+// per user so users can not continue each other's conversations.
+func (self LLMPathManager) Session(principal, name string) api.FSPathSpec {
+	return LLM_ROOT.AddUnsafeChild("sessions", principal, name)
+}
+
+// The full conversation of an agent run so it may be resumed. Like
+// sessions, investigations are stored per user.
+func (self LLMPathManager) Transcript(principal, investigation string) api.FSPathSpec {
+	return LLM_ROOT.AddUnsafeChild("investigations", principal,
+		investigation, "transcript")
+}
+
+// The plan and findings recorded by the agent.
+func (self LLMPathManager) Scratchpad(principal, investigation string) api.FSPathSpec {
+	return LLM_ROOT.AddUnsafeChild("investigations", principal,
+		investigation, "scratchpad")
+}
+
+// Partial output of a long running generation, saved under a name
+// chosen by the user so a later query may continue it.
+func (self LLMPathManager) Checkpoint(principal, name string) api.FSPathSpec {
+	return LLM_ROOT.AddUnsafeChild("checkpoints", principal, name)
+}
+
+// An approval request of an agent waiting for a decision.
+func (self LLMPathManager) PendingApproval(id string) api.FSPathSpec {
+	return LLM_ROOT.AddUnsafeChild("approvals", id)
+}
+
+// Follow up collections recommended by the model, waiting for a user
+// to approve them.
+func (self LLMPathManager) Recommendations() api.FSPathSpec {
+	return LLM_ROOT.AddChild("recommendations")
+}
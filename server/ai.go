@@ -0,0 +1,95 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	api_utils "www.velocidex.com/golang/velociraptor/api/utils"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	crypto_proto "www.velocidex.com/golang/velociraptor/crypto/proto"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/velociraptor/vql/tools/ollama"
+)
+
+// This handler answers the AI requests clients send through the
+// server (base_url=server://) so they do not need access to the
+// model server. Requests are encrypted like the other messages of
+// the client so only enrolled clients are answered, and the answer
+// is encrypted for the client.
+func ai_proxy(
+	config_obj *config_proto.Config, server_obj *Server) http.Handler {
+	return api_utils.HandlerFunc(nil,
+		func(w http.ResponseWriter, req *http.Request) {
+			err := checkHealthy(config_obj)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error: %v", err), http.StatusServiceUnavailable)
+				return
+			}
+
+			// Models may take a long time to answer. These requests
+			// do not take a concurrency slot so they do not hold up
+			// the comms of other clients. Instead they are limited
+			// for each client and wait for a slot of a separate pool
+			// (see ollama.ProxyClientRequest).
+			ctx, cancel := utils.WithTimeoutCause(
+				req.Context(), 600*time.Second,
+				errors.New("ai_proxy: deadline reached processing request"))
+			defer cancel()
+
+			message_info, err := readWithLimits(ctx, config_obj, server_obj, req)
+			if err != nil || !message_info.Authenticated ||
+				len(message_info.RawCompressed) != 1 {
+				http.Error(w, "", http.StatusForbidden)
+				return
+			}
+
+			request := message_info.RawCompressed[0]
+			if message_info.Compression == crypto_proto.PackedMessageList_ZCOMPRESSION {
+				request, err = utils.Uncompress(ctx, request)
+				if err != nil {
+					http.Error(w, "", http.StatusBadRequest)
+					return
+				}
+			}
+
+			org_manager, err := services.GetOrgManager()
+			if err != nil {
+				http.Error(w, "", http.StatusServiceUnavailable)
+				return
+			}
+
+			org_config_obj, err := org_manager.GetOrgConfig(message_info.OrgId)
+			if err != nil {
+				http.Error(w, "", http.StatusServiceUnavailable)
+				return
+			}
+
+			server_obj.Debug("AI request from %v (%v)",
+				message_info.Source, message_info.RemoteAddr)
+
+			response := ollama.ProxyClientRequest(ctx, org_config_obj,
+				message_info.Source, request)
+
+			nonce := ""
+			if org_config_obj.Client != nil {
+				nonce = org_config_obj.Client.Nonce
+			}
+
+			cipher_text, err := server_obj.manager.Encrypt(
+				[][]byte{response},
+				crypto_proto.PackedMessageList_UNCOMPRESSED,
+				nonce, message_info.Source)
+			if err != nil {
+				server_obj.Error("ai_proxy: %v", err)
+				http.Error(w, "", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(cipher_text)
+		})
+}
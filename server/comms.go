@@ -156,6 +156,10 @@ func PrepareFrontendMux(
 	router.Handle(base+"/receive_messages",
 		RecordHTTPStats(send_client_messages(config_obj, server_obj)))
 
+	// Answer AI requests clients send through the server.
+	router.Handle(base+"/ai",
+		RecordHTTPStats(ai_proxy(config_obj, server_obj)))
+
 	// Publicly accessible part of the filestore. NOTE: this
 	// does not have to be a physical directory - it is served
 	// from the filestore.
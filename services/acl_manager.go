@@ -171,6 +171,9 @@ func CheckAccessWithToken(
 
 	case acls.DATASTORE_ACCESS:
 		return token.DatastoreAccess, nil
+
+	case acls.AI_LABEL_CLIENT:
+		return token.AiLabelClients, nil
 	}
 
 	return false, nil
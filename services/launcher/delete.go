@@ -120,6 +120,14 @@ func (self *FlowStorageManager) DeleteFlow(
 	r.emit_fs("Log", flow_path_manager.Log())
 	r.emit_fs("LogIndex", flow_path_manager.Log().
 		SetType(api.PATH_TYPE_FILESTORE_JSON_INDEX))
+
+	analyses_path := flow_path_manager.LLMAnalyses()
+	_, err = file_store_factory.StatFile(analyses_path)
+	if err == nil {
+		r.emit_fs("LLMAnalyses", analyses_path)
+		r.emit_fs("LLMAnalysesIndex", analyses_path.
+			SetType(api.PATH_TYPE_FILESTORE_JSON_INDEX))
+	}
 	r.emit_ds("CollectionContext", flow_path_manager.Path())
 	r.emit_ds("Task", flow_path_manager.Task())
 	r.emit_ds("Stats", flow_path_manager.Stats())
@@ -286,7 +286,13 @@ func NewNotebookManagerService(
 		NewAttachmentManager(config_obj, store),
 	)
 
-	return notebook_service, notebook_service.Start(ctx, config_obj, wg)
+	err = notebook_service.Start(ctx, config_obj, wg)
+	if err != nil {
+		return nil, err
+	}
+
+	return notebook_service, notebook_service.startSummarizer(
+		ctx, config_obj, wg)
 }
 
 func (self *NotebookManager) ReformatVQL(
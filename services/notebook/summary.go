@@ -0,0 +1,225 @@
+package notebook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Velocidex/ordereddict"
+	api_proto "www.velocidex.com/golang/velociraptor/api/proto"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/constants"
+	flows_proto "www.velocidex.com/golang/velociraptor/flows/proto"
+	"www.velocidex.com/golang/velociraptor/logging"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/services/journal"
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/velociraptor/vql/acl_managers"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	DEFAULT_SUMMARY_PROMPT = `Summarize the notable findings in these results for an incident responder. Point out anything suspicious and why.`
+
+	// The model is called through the ollama() plugin so the summary
+	// uses the same settings, cache and usage accounting as queries.
+	summaryQuery = `
+SELECT * FROM ollama(
+   query={
+     SELECT * FROM source(client_id=ClientId, flow_id=FlowId,
+                          artifact=Artifact)
+   },
+   prompt=Prompt, response_format="markdown")
+`
+
+	// Completed flows waiting to be summarized.
+	SUMMARY_QUEUE_SIZE = 100
+)
+
+type summaryRequest struct {
+	flow     *flows_proto.ArtifactCollectorContext
+	artifact string
+}
+
+// Watch for completed flows collecting the artifacts in
+// AI.summarize_artifacts and add a summary of their results to the
+// flow's notebook. Only the master summarizes flows, otherwise each
+// frontend would add its own summary.
+func (self *NotebookManager) startSummarizer(
+	ctx context.Context,
+	config_obj *config_proto.Config,
+	wg *sync.WaitGroup) error {
+
+	if config_obj.AI == nil || len(config_obj.AI.SummarizeArtifacts) == 0 ||
+		!services.IsMaster(config_obj) {
+		return nil
+	}
+
+	logger := logging.GetLogger(config_obj, &logging.FrontendComponent)
+	logger.Info("<green>Starting</> AI summaries for %v in %v",
+		strings.Join(config_obj.AI.SummarizeArtifacts, ", "),
+		services.GetOrgName(config_obj))
+
+	// The model may take minutes to answer so summaries are made in
+	// the background instead of holding up the completion queue.
+	requests := make(chan *summaryRequest, SUMMARY_QUEUE_SIZE)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case request := <-requests:
+				err := self.summarizeFlow(ctx, config_obj,
+					request.flow, request.artifact)
+				if err != nil {
+					logger.Error("NotebookSummarizer: %v/%v: %v",
+						request.flow.SessionId, request.artifact, err)
+				}
+			}
+		}
+	}()
+
+	return journal.WatchQueueWithCB(ctx, config_obj, wg,
+		"System.Flow.Completion", "NotebookSummarizer",
+		func(ctx context.Context, config_obj *config_proto.Config,
+			row *ordereddict.Dict) error {
+
+			flow, err := journal.GetFlowFromQueue(ctx, config_obj, row)
+			if err != nil {
+				return err
+			}
+
+			for _, artifact := range summaryArtifacts(
+				flow, config_obj.AI.SummarizeArtifacts) {
+				select {
+				case requests <- &summaryRequest{
+					flow: flow, artifact: artifact}:
+				default:
+					logger.Error("NotebookSummarizer: %v/%v: Too many summaries pending, skipping",
+						flow.SessionId, artifact)
+				}
+			}
+			return nil
+		})
+}
+
+// Returns the artifact sources of the flow which should be
+// summarized. Configured artifacts also match their sources and
+// custom overrides.
+func summaryArtifacts(flow *flows_proto.ArtifactCollectorContext,
+	configured []string) (result []string) {
+	for _, name := range flow.ArtifactsWithResults {
+		base := strings.TrimPrefix(name, constants.ARTIFACT_CUSTOM_NAME_PREFIX)
+		base = strings.SplitN(base, "/", 2)[0]
+
+		if utils.InString(configured, name) ||
+			utils.InString(configured, base) {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func (self *NotebookManager) summarizeFlow(
+	ctx context.Context,
+	config_obj *config_proto.Config,
+	flow *flows_proto.ArtifactCollectorContext,
+	artifact string) error {
+
+	prompt := config_obj.AI.SummaryPrompt
+	if prompt == "" {
+		prompt = DEFAULT_SUMMARY_PROMPT
+	}
+
+	summary, err := self.generateSummary(ctx, config_obj,
+		flow.ClientId, flow.SessionId, artifact, prompt)
+	if err != nil {
+		return err
+	}
+
+	// Summaries are attributed to the user who launched the flow.
+	principal := ""
+	if flow.Request != nil {
+		principal = flow.Request.Creator
+	}
+
+	notebook_id := fmt.Sprintf("N.%v-%v", flow.SessionId, flow.ClientId)
+	_, err = self.Store.GetNotebook(notebook_id)
+	if err != nil {
+		// Create the notebook the same way as the GUI does when the
+		// notebook tab is first opened.
+		_, err = self.NewNotebook(ctx, principal, &api_proto.NotebookMetadata{
+			Name:       "Notebook for Collection " + flow.SessionId,
+			NotebookId: notebook_id,
+			// Flow notebooks are all public.
+			Public: true,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = self.NewNotebookCell(ctx, &api_proto.NotebookCellRequest{
+		NotebookId: notebook_id,
+		Type:       "markdown",
+		Input:      fmt.Sprintf("## AI summary of %v\n\n%v\n", artifact, summary),
+		Sync:       true,
+	}, principal)
+	return err
+}
+
+func (self *NotebookManager) generateSummary(
+	ctx context.Context,
+	config_obj *config_proto.Config,
+	client_id, flow_id, artifact, prompt string) (string, error) {
+
+	manager, err := services.GetRepositoryManager(config_obj)
+	if err != nil {
+		return "", err
+	}
+
+	scope := manager.BuildScope(services.ScopeBuilder{
+		Config:     config_obj,
+		ACLManager: acl_managers.NewRoleACLManager(config_obj, "administrator"),
+		Env: ordereddict.NewDict().
+			Set("ClientId", client_id).
+			Set("FlowId", flow_id).
+			Set("Artifact", artifact).
+			Set("Prompt", prompt),
+		Logger: logging.NewPlainLogger(config_obj,
+			&logging.FrontendComponent),
+	})
+	defer scope.Close()
+
+	vql, err := vfilter.Parse(summaryQuery)
+	if err != nil {
+		return "", err
+	}
+
+	var summary, summary_err string
+	for row := range vql.Eval(ctx, scope) {
+		row_dict := vfilter.RowToDict(ctx, scope, row)
+		if error_str, pres := row_dict.GetString("Error"); pres && error_str != "" {
+			summary_err = error_str
+			continue
+		}
+
+		response, _ := row_dict.GetString("Response")
+		summary += response
+	}
+
+	if summary == "" {
+		if summary_err != "" {
+			return "", fmt.Errorf("Model call failed: %v", summary_err)
+		}
+		return "", fmt.Errorf("No summary for %v", artifact)
+	}
+
+	return summary, nil
+}
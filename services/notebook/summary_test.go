@@ -0,0 +1,114 @@
+package notebook_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"github.com/stretchr/testify/suite"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/file_store"
+	"www.velocidex.com/golang/velociraptor/file_store/test_utils"
+	flows_proto "www.velocidex.com/golang/velociraptor/flows/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/paths/artifacts"
+	"www.velocidex.com/golang/velociraptor/result_sets"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/velociraptor/vtesting"
+	"www.velocidex.com/golang/velociraptor/vtesting/assert"
+
+	_ "www.velocidex.com/golang/velociraptor/result_sets/simple"
+	_ "www.velocidex.com/golang/velociraptor/vql/server/flows"
+	_ "www.velocidex.com/golang/velociraptor/vql/tools/ollama"
+)
+
+type NotebookSummaryTestSuite struct {
+	test_utils.TestSuite
+}
+
+func (self *NotebookSummaryTestSuite) SetupTest() {
+	self.ConfigObj = self.TestSuite.LoadConfig()
+	self.ConfigObj.Services.NotebookService = true
+	self.ConfigObj.Services.SchedulerService = true
+	self.ConfigObj.AI = &config_proto.AIConfig{
+		BaseUrl:            "mock://?response=Nothing+suspicious+found",
+		DefaultModel:       "mock",
+		SummarizeArtifacts: []string{"Generic.Client.Info"},
+	}
+
+	self.LoadArtifactsIntoConfig(mock_definitions)
+
+	self.TestSuite.SetupTest()
+}
+
+func (self *NotebookSummaryTestSuite) TestSummarizeFlow() {
+	launcher, err := services.GetLauncher(self.ConfigObj)
+	assert.NoError(self.T(), err)
+
+	err = launcher.Storage().WriteFlow(self.Ctx, self.ConfigObj,
+		&flows_proto.ArtifactCollectorContext{
+			ClientId:  "C.123",
+			SessionId: "F.1234",
+			Request: &flows_proto.ArtifactCollectorArgs{
+				Creator:   "admin",
+				ClientId:  "C.123",
+				Artifacts: []string{"Generic.Client.Info"},
+			},
+			ArtifactsWithResults: []string{"Generic.Client.Info"},
+		}, utils.SyncCompleter)
+	assert.NoError(self.T(), err)
+
+	path_manager, err := artifacts.NewArtifactPathManager(self.Ctx,
+		self.ConfigObj, "C.123", "F.1234", "Generic.Client.Info")
+	assert.NoError(self.T(), err)
+
+	file_store_factory := file_store.GetFileStore(self.ConfigObj)
+	rs_writer, err := result_sets.NewResultSetWriter(file_store_factory,
+		path_manager.Path(), json.DefaultEncOpts(),
+		utils.SyncCompleter, result_sets.TruncateMode)
+	assert.NoError(self.T(), err)
+
+	rs_writer.Write(ordereddict.NewDict().Set("Hostname", "workstation"))
+	rs_writer.Close()
+
+	journal, err := services.GetJournal(self.ConfigObj)
+	assert.NoError(self.T(), err)
+
+	err = journal.PushRowsToArtifact(self.Ctx, self.ConfigObj,
+		[]*ordereddict.Dict{ordereddict.NewDict().
+			Set("ClientId", "C.123").
+			Set("FlowId", "F.1234")},
+		"System.Flow.Completion", "server", "")
+	assert.NoError(self.T(), err)
+
+	notebook_manager, err := services.GetNotebookManager(self.ConfigObj)
+	assert.NoError(self.T(), err)
+
+	// The flow's notebook is created with the summary as the last
+	// cell.
+	var input string
+	vtesting.WaitUntil(10*time.Second, self.T(), func() bool {
+		notebook, err := notebook_manager.GetNotebook(self.Ctx,
+			"N.F.1234-C.123", services.DO_NOT_INCLUDE_UPLOADS)
+		if err != nil || len(notebook.CellMetadata) == 0 {
+			return false
+		}
+
+		last := notebook.CellMetadata[len(notebook.CellMetadata)-1]
+		cell, err := notebook_manager.GetNotebookCell(self.Ctx,
+			notebook.NotebookId, last.CellId, last.CurrentVersion)
+		if err != nil {
+			return false
+		}
+		input = cell.Input
+		return strings.Contains(input, "Nothing suspicious found")
+	})
+
+	assert.Contains(self.T(), input, "## AI summary of Generic.Client.Info")
+}
+
+func TestNotebookSummary(t *testing.T) {
+	suite.Run(t, &NotebookSummaryTestSuite{})
+}
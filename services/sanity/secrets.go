@@ -90,6 +90,57 @@ var built_in_definitions = []string{`{
      "skip_verify": "FALSE"
   },
   "verifier": "x=>x.server && x.server_port"
+}`, `{
+  "typeName":"Webhook Secrets",
+  "description": "Endpoints and signing keys to be used in ollama_webhook() calls.",
+  "template": {
+     "url": "",
+     "url_regex": "",
+     "hmac_key": "",
+     "extra_headers": "# Add extra headers as YAML strings\n#Authorization: Value\n"
+  },
+  "verifier": "x=>x.url"
+}`, `{
+  "typeName":"Azure OpenAI Creds",
+  "description": "Azure OpenAI resources called by the AI plugins with base_url=azure://name. Set auth to managed_identity, client_credentials or api_key.",
+  "template": {
+     "url": "https://example.openai.azure.com/openai/v1",
+     "api": "chat",
+     "auth": "managed_identity",
+     "tenant_id": "",
+     "client_id": "",
+     "client_secret": "",
+     "api_key": "",
+     "authority_host": ""
+  },
+  "verifier": "x=>x.url AND (x.auth = 'managed_identity' OR (x.auth = 'api_key' AND x.api_key) OR (x.auth = 'client_credentials' AND x.tenant_id AND x.client_id AND x.client_secret))"
+}`, `{
+  "typeName":"AWS Bedrock Creds",
+  "description": "Bedrock endpoints called by the AI plugins with base_url=bedrock://name. Leave the keys empty to use the server's AWS credential chain (e.g. an instance role).",
+  "template": {
+     "region": "us-east-1",
+     "url": "",
+     "api": "chat",
+     "profile": "",
+     "credentials_key": "",
+     "credentials_secret": "",
+     "credentials_token": "",
+     "role_arn": "",
+     "external_id": "",
+     "session_name": ""
+  },
+  "verifier": "x=>x.region OR x.url"
+}`, `{
+  "typeName":"GCP Vertex Creds",
+  "description": "Vertex AI projects called by the AI plugins with base_url=vertex://name. Leave credentials_json empty to use the server's application default credentials (e.g. GKE workload identity).",
+  "template": {
+     "project": "",
+     "location": "global",
+     "url": "",
+     "api": "chat",
+     "credentials_json": ""
+  },
+  "verifier": "x=>x.project OR x.url"
 }`,
 }
 
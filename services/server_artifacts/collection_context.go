@@ -382,6 +382,10 @@ func (self *contextManager) RunQuery(
 		// subject to ACL checks
 		ACLManager: acl_managers.NewServerACLManager(self.config_obj, effective_principal),
 		Logger:     log.New(query_context.Logger(), "", 0),
+
+		// Make the session id available in the query.
+		Env: ordereddict.NewDict().
+			Set("_SessionId", self.session_id),
 	})
 	defer scope.Close()
 
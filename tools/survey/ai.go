@@ -0,0 +1,155 @@
+package survey
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/huh"
+	"www.velocidex.com/golang/velociraptor/config"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+var (
+	// Added to the allow lists when the AI subsystem is configured.
+	ai_plugins   = []string{"ollama", "ollama_chat", "ollama_embed"}
+	ai_functions = []string{"ollama", "ollama_health", "count_tokens"}
+)
+
+func configAI(config *ConfigSurvey) error {
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title("Do you want to configure the AI subsystem?").
+				Description(`
+The ollama() plugins send query results to a large language model
+server for summarization and triage. The model server is usually an
+Ollama server or a gateway in front of it.
+
+You can skip this and add the AI section to the config file later.
+`).
+				Value(&config.AIEnabled),
+		),
+	).WithTheme(getTheme())
+
+	err := form.Run()
+	if err != nil || !config.AIEnabled {
+		return err
+	}
+
+	form = huh.NewForm(
+		huh.NewGroup(
+			huh.NewInput().
+				Title("Model server URL").
+				Description(`Use secret://name to read the URL and an API key from an HTTP Secret.`).
+				Placeholder("http://localhost:11434").
+				Validate(func(in string) error {
+					return config.validateAI(func(ai *config_proto.AIConfig) {
+						ai.BaseUrl = in
+					})
+				}).
+				Value(&config.AIBaseUrl),
+
+			huh.NewInput().
+				Title("Default model").
+				Description("The model used when a query does not name one (e.g. llama3).").
+				Value(&config.AIDefaultModel),
+
+			huh.NewInput().
+				Title("Allowed models").
+				Description("A comma separated list of models users may call. Leave empty to allow all models.").
+				Validate(func(in string) error {
+					return config.validateAI(func(ai *config_proto.AIConfig) {
+						ai.AllowedModels = splitList(in)
+					})
+				}).
+				Value(&config.AIAllowedModels),
+		),
+		huh.NewGroup(
+			huh.NewNote().
+				Title("Quotas").
+				Description("Limits on each model call. Leave empty for unlimited."),
+
+			huh.NewInput().
+				Title("Maximum prompt tokens").
+				Description("Calls with larger prompts are refused.").
+				Validate(optional_int("Maximum prompt tokens")).
+				Value(&config.AIMaxPromptTokens),
+
+			huh.NewInput().
+				Title("Maximum response tokens").
+				Description("Responses are cut off after this many tokens.").
+				Validate(optional_int("Maximum response tokens")).
+				Value(&config.AIMaxResponseTokens),
+		),
+	).WithTheme(getTheme())
+
+	return form.Run()
+}
+
+func optional_int(message string) func(in string) error {
+	validator := validate_int(message)
+	return func(in string) error {
+		if in == "" {
+			return nil
+		}
+		return validator(in)
+	}
+}
+
+func splitList(in string) []string {
+	var result []string
+	for _, item := range strings.Split(in, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// The AI section built from the answers so far.
+func (self *ConfigSurvey) aiConfig() *config_proto.AIConfig {
+	result := &config_proto.AIConfig{
+		BaseUrl:       self.AIBaseUrl,
+		DefaultModel:  self.AIDefaultModel,
+		AllowedModels: splitList(self.AIAllowedModels),
+	}
+	result.MaxPromptTokens, _ = utils.ToInt64(self.AIMaxPromptTokens)
+	result.MaxResponseTokens, _ = utils.ToInt64(self.AIMaxResponseTokens)
+	return result
+}
+
+// Validate the AI section with a change applied, so a field can be
+// checked before it is stored.
+func (self *ConfigSurvey) validateAI(change func(ai *config_proto.AIConfig)) error {
+	ai := self.aiConfig()
+	change(ai)
+
+	// The default model is checked once the allowed models are known.
+	if len(ai.AllowedModels) == 0 {
+		ai.DefaultModel = ""
+	}
+	return config.ValidateAIConfig(ai)
+}
+
+func (self *ConfigSurvey) compileAI(config_obj *config_proto.Config) error {
+	if !self.AIEnabled {
+		return nil
+	}
+
+	ai := self.aiConfig()
+	err := config.ValidateAIConfig(ai)
+	if err != nil {
+		return fmt.Errorf("AI settings: %w", err)
+	}
+	config_obj.AI = ai
+
+	if self.ImplementAllowList {
+		config_obj.Defaults.AllowedPlugins = append(
+			config_obj.Defaults.AllowedPlugins, ai_plugins...)
+		config_obj.Defaults.AllowedFunctions = append(
+			config_obj.Defaults.AllowedFunctions, ai_functions...)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package survey
+
+import (
+	"testing"
+
+	"www.velocidex.com/golang/velociraptor/config"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/vtesting/assert"
+)
+
+func TestCompileAI(t *testing.T) {
+	survey := &ConfigSurvey{
+		AIEnabled:          true,
+		AIBaseUrl:          "http://localhost:11434",
+		AIDefaultModel:     "llama3",
+		AIAllowedModels:    "llama3, mistral,",
+		AIMaxPromptTokens:  "4096",
+		ImplementAllowList: true,
+	}
+
+	config_obj := config.GetDefaultConfig()
+	err := survey.compileAI(config_obj)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "http://localhost:11434", config_obj.AI.BaseUrl)
+	assert.Equal(t, "llama3", config_obj.AI.DefaultModel)
+	assert.Equal(t, []string{"llama3", "mistral"}, config_obj.AI.AllowedModels)
+	assert.Equal(t, int64(4096), config_obj.AI.MaxPromptTokens)
+	assert.Equal(t, int64(0), config_obj.AI.MaxResponseTokens)
+
+	// The plugins are added to the allow lists.
+	assert.Contains(t, config_obj.Defaults.AllowedPlugins, "ollama")
+	assert.Contains(t, config_obj.Defaults.AllowedFunctions, "ollama_health")
+
+	// Answers which would not load are refused.
+	survey.AIDefaultModel = "phi3"
+	err = survey.compileAI(config.GetDefaultConfig())
+	assert.ErrorContains(t, err,
+		"AI settings: AI.default_model phi3 is not in AI.allowed_models")
+
+	// Without the AI subsystem there is no AI section.
+	survey.AIEnabled = false
+	config_obj = config.GetDefaultConfig()
+	err = survey.compileAI(config_obj)
+	assert.NoError(t, err)
+	assert.Nil(t, config_obj.AI)
+}
+
+func TestValidateAIAnswers(t *testing.T) {
+	survey := &ConfigSurvey{AIDefaultModel: "llama3"}
+
+	err := survey.validateAI(func(ai *config_proto.AIConfig) {
+		ai.BaseUrl = "ftp://localhost"
+	})
+	assert.ErrorContains(t, err, "AI.base_url should be an http")
+
+	err = survey.validateAI(func(ai *config_proto.AIConfig) {
+		ai.BaseUrl = "secret://ollama"
+	})
+	assert.NoError(t, err)
+
+	// The default model is only checked against the allowed models
+	// once they are given.
+	err = survey.validateAI(func(ai *config_proto.AIConfig) {
+		ai.AllowedModels = splitList("mistral")
+	})
+	assert.ErrorContains(t, err, "AI.default_model llama3 is not in AI.allowed_models")
+
+	err = survey.validateAI(func(ai *config_proto.AIConfig) {
+		ai.AllowedModels = nil
+	})
+	assert.NoError(t, err)
+}
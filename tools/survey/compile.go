@@ -144,6 +144,11 @@ func (self *ConfigSurvey) Compile() (*config_proto.Config, error) {
 		config_obj.Defaults.AllowedAccessors = allowed_accessors
 	}
 
+	err = self.compileAI(config_obj)
+	if err != nil {
+		return nil, err
+	}
+
 	return config_obj, nil
 }
 
@@ -55,6 +55,14 @@ type ConfigSurvey struct {
 	// For frontend configurations
 	MinionHostname string `json:"MinionHostname,omitempty"`
 	MinionBindPort string `json:"MinionBindPort,omitempty"`
+
+	// The AI subsystem
+	AIEnabled           bool   `json:"AIEnabled,omitempty"`
+	AIBaseUrl           string `json:"AIBaseUrl,omitempty"`
+	AIDefaultModel      string `json:"AIDefaultModel,omitempty"`
+	AIAllowedModels     string `json:"AIAllowedModels,omitempty"`
+	AIMaxPromptTokens   string `json:"AIMaxPromptTokens,omitempty"`
+	AIMaxResponseTokens string `json:"AIMaxResponseTokens,omitempty"`
 }
 
 func GetInteractiveConfig() (*config_proto.Config, error) {
@@ -81,6 +89,11 @@ func GetInteractiveConfig() (*config_proto.Config, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	err = configAI(config)
+	if err != nil {
+		return nil, err
+	}
 	return config.Compile()
 }
 
@@ -0,0 +1,164 @@
+// Reads server sent event streams as described in
+// https://html.spec.whatwg.org/multipage/server-sent-events.html
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	DEFAULT_RETRY = time.Second
+)
+
+var (
+	ErrMessageTooLarge = errors.New("message too large")
+)
+
+// An event did not fit in the limit. The start of it is kept for the
+// error message.
+type MessageTooLargeError struct {
+	Data  []byte
+	Limit int
+}
+
+func (self *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("%v (the limit is %v bytes)",
+		ErrMessageTooLarge, self.Limit)
+}
+
+func (self *MessageTooLargeError) Unwrap() error {
+	return ErrMessageTooLarge
+}
+
+// A server sent event. Data holds the data lines of the event joined
+// by newlines.
+type Event struct {
+	Event string
+	Data  []byte
+	Id    string
+}
+
+// Each event is limited to max_message bytes so a misbehaving server
+// can not exhaust memory.
+type Reader struct {
+	buf         *bufio.Reader
+	max_message int
+
+	// The id of the last event, sent as Last-Event-ID when
+	// reconnecting so the server continues after it.
+	last_id string
+
+	// How long to wait before reconnecting, as set by the server.
+	retry time.Duration
+}
+
+func NewReader(reader io.Reader, max_message int) *Reader {
+	return &Reader{
+		buf:         bufio.NewReader(reader),
+		max_message: max_message,
+		retry:       DEFAULT_RETRY,
+	}
+}
+
+// Continue with the stream of a new connection.
+func (self *Reader) Reset(reader io.Reader) {
+	self.buf = bufio.NewReader(reader)
+}
+
+func (self *Reader) LastId() string {
+	return self.last_id
+}
+
+func (self *Reader) Retry() time.Duration {
+	return self.retry
+}
+
+// Returns the next event or io.EOF at the end of the stream. An event
+// cut off by the end of the stream is dropped.
+func (self *Reader) Next() (*Event, error) {
+	event := &Event{}
+	var data []byte
+	var has_data bool
+
+	for {
+		line, err := ReadLine(self.buf, self.max_message-len(data))
+		if errors.Is(err, ErrMessageTooLarge) {
+			return nil, &MessageTooLargeError{
+				Data: append(data, line...), Limit: self.max_message}
+		}
+
+		if len(line) == 0 && err != nil {
+			return nil, err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+
+		// A blank line dispatches the event.
+		if len(line) == 0 {
+			if has_data {
+				event.Data = data
+				event.Id = self.last_id
+				return event, nil
+			}
+			event = &Event{}
+			continue
+		}
+
+		// Comments keep the connection open.
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value, _ := bytes.Cut(line, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+
+		switch string(field) {
+		case "event":
+			event.Event = string(value)
+
+		case "data":
+			if has_data {
+				data = append(data, '\n')
+			}
+			data = append(data, value...)
+			has_data = true
+
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				self.last_id = string(value)
+			}
+
+		case "retry":
+			ms, err := strconv.ParseUint(string(value), 10, 32)
+			if err == nil {
+				self.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// Read a line of at most limit bytes. The line is returned up to the
+// limit with ErrMessageTooLarge when it is longer.
+func ReadLine(buf *bufio.Reader, limit int) ([]byte, error) {
+	if limit < 0 {
+		limit = 0
+	}
+
+	var line []byte
+	for {
+		fragment, err := buf.ReadSlice('\n')
+		if len(line)+len(fragment) > limit {
+			return append(line, fragment[:limit-len(line)]...), ErrMessageTooLarge
+		}
+		line = append(line, fragment...)
+
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return line, err
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package sse
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"www.velocidex.com/golang/velociraptor/vtesting/assert"
+)
+
+func TestReader(t *testing.T) {
+	reader := NewReader(strings.NewReader(
+		": keep alive\n\n"+
+			"event: message\nid: 1\ndata: {\"a\":\ndata: 1}\n\n"+
+			"retry: 500\ndata:2\r\n\r\n"+
+			"data: cut off"), 64)
+
+	event, err := reader.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "message", event.Event)
+	assert.Equal(t, "{\"a\":\n1}", string(event.Data))
+	assert.Equal(t, "1", event.Id)
+
+	event, err = reader.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "", event.Event)
+	assert.Equal(t, "2", string(event.Data))
+	assert.Equal(t, "1", reader.LastId())
+	assert.Equal(t, 500*time.Millisecond, reader.Retry())
+
+	// An event cut off by the end of the stream is dropped.
+	_, err = reader.Next()
+	assert.True(t, errors.Is(err, io.EOF))
+}
+
+func TestReaderTooLarge(t *testing.T) {
+	reader := NewReader(strings.NewReader(
+		"data: "+strings.Repeat("x", 10)+"\ndata: "+
+			strings.Repeat("y", 10)+"\n\n"), 20)
+
+	_, err := reader.Next()
+	assert.True(t, errors.Is(err, ErrMessageTooLarge))
+
+	var too_large *MessageTooLargeError
+	assert.True(t, errors.As(err, &too_large))
+	assert.Equal(t, "x", string(too_large.Data[:1]))
+	assert.Equal(t, "message too large (the limit is 20 bytes)", err.Error())
+}
@@ -360,6 +360,17 @@ func downloadFlowToZip(
 		return err
 	}
 
+	// Copy the AI analyses attached to the flow, if any.
+	analyses_path := flow_path_manager.LLMAnalyses()
+	_, err = file_store.GetFileStore(config_obj).StatFile(analyses_path)
+	if err == nil {
+		err = copyResultSetIntoContainer(ctx, config_obj, zip_writer, format,
+			analyses_path, prefix.AddChild("llm_analyses"))
+		if err != nil {
+			return err
+		}
+	}
+
 	// Copy artifact results
 	if flow_details != nil && flow_details.Context != nil {
 		for _, name := range flow_details.Context.ArtifactsWithResults {
@@ -823,6 +834,17 @@ func createHuntDownloadFile(
 			return
 		}
 
+		analyses_path := hunt_path_manager.LLMAnalyses()
+		_, err = file_store_factory.StatFile(analyses_path)
+		if err == nil {
+			err = copyResultSetIntoContainer(sub_ctx, config_obj, zip_writer,
+				format, analyses_path,
+				path_specs.NewUnsafeFilestorePath().AddChild("llm_analyses"))
+			if err != nil {
+				return
+			}
+		}
+
 		err = generateCombinedResults(
 			sub_ctx, config_obj, scope,
 			hunt_details, format, zip_writer)
@@ -19,7 +19,9 @@ import (
 	flows_proto "www.velocidex.com/golang/velociraptor/flows/proto"
 	"www.velocidex.com/golang/velociraptor/json"
 	"www.velocidex.com/golang/velociraptor/logging"
+	"www.velocidex.com/golang/velociraptor/paths"
 	"www.velocidex.com/golang/velociraptor/reporting"
+	"www.velocidex.com/golang/velociraptor/result_sets"
 	"www.velocidex.com/golang/velociraptor/services"
 	"www.velocidex.com/golang/velociraptor/services/hunt_dispatcher"
 	"www.velocidex.com/golang/velociraptor/third_party/zip"
@@ -252,6 +254,60 @@ func (self *TestSuite) TestExportCollection1() {
 		json.MustMarshalIndent(uploads_json))
 }
 
+// AI analyses attached to the flow are exported with it.
+func (self *TestSuite) TestExportCollectionAnalyses() {
+	manager, _ := services.GetRepositoryManager(self.ConfigObj)
+
+	builder := services.ScopeBuilder{
+		Config:     self.ConfigObj,
+		ACLManager: self.acl_manager,
+		Logger:     logging.NewPlainLogger(self.ConfigObj, &logging.FrontendComponent),
+		Env:        ordereddict.NewDict(),
+	}
+
+	ctx := self.Ctx
+	scope := manager.BuildScope(builder)
+
+	import_file_path, err := filepath.Abs("fixtures/export.zip")
+	assert.NoError(self.T(), err)
+
+	result := collector.ImportCollectionFunction{}.Call(ctx, scope,
+		ordereddict.NewDict().
+			Set("client_id", self.client_id).
+			Set("hostname", "MyNewHost").
+			Set("filename", import_file_path))
+	context, ok := result.(*flows_proto.ArtifactCollectorContext)
+	assert.True(self.T(), ok)
+
+	rs_writer, err := result_sets.NewResultSetWriter(
+		file_store.GetFileStore(self.ConfigObj),
+		paths.NewFlowPathManager(context.ClientId, context.SessionId).LLMAnalyses(),
+		json.DefaultEncOpts(), utils.SyncCompleter, result_sets.TruncateMode)
+	assert.NoError(self.T(), err)
+	rs_writer.Write(ordereddict.NewDict().
+		Set("Verdict", "benign").
+		Set("Summary", "Nothing found"))
+	rs_writer.Close()
+
+	result = (&CreateFlowDownload{}).Call(ctx, scope,
+		ordereddict.NewDict().
+			Set("client_id", context.ClientId).
+			Set("flow_id", context.SessionId).
+			Set("wait", true).
+			Set("name", "Test"))
+
+	path_spec, ok := result.(path_specs.FSPathSpec)
+	assert.True(self.T(), ok)
+
+	file_details, err := openZipFile(self.ConfigObj, scope, path_spec)
+	assert.NoError(self.T(), err)
+
+	analyses, pres := file_details.Get("llm_analyses.json")
+	assert.True(self.T(), pres)
+	assert.Equal(self.T(), `[{"Verdict":"benign","Summary":"Nothing found"}]`,
+		json.MustMarshalString(analyses))
+}
+
 func (self *TestSuite) TestExportHunt() {
 	closer := utils.MockTime(utils.NewMockClock(time.Unix(10, 10)))
 	defer closer()
@@ -0,0 +1,351 @@
+package mcp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/constants"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	MCP_CLIENT_CACHE_TAG = "$mcp_client_cache"
+)
+
+var (
+	errCacheClosed = errors.New("MCP: query is shutting down")
+)
+
+// A transport delivers JSON-RPC messages to the server.
+type transport interface {
+	// Send a request and wait for the response with the same id.
+	RoundTrip(ctx context.Context, req *jsonRPCRequest) (*jsonRPCResponse, error)
+
+	// Send a notification - no response is expected.
+	Notify(ctx context.Context, req *jsonRPCRequest) error
+
+	Close() error
+}
+
+// Describes how to reach an MCP server. Either Url (streamable HTTP)
+// or Command (stdio) must be specified.
+type ServerSpec struct {
+	Url        string
+	Headers    *ordereddict.Dict
+	RootCerts  string
+	SkipVerify bool
+
+	Command []string
+	Env     *ordereddict.Dict
+	Cwd     string
+}
+
+// The cache key covers every field of the spec. Two specs which
+// differ only in credentials, TLS settings or environment must never
+// share a client.
+func (self *ServerSpec) Key() string {
+	h := sha256.New()
+	write := func(field, value string) {
+		fmt.Fprintf(h, "%s=%d:%s;", field, len(value), value)
+	}
+
+	write("url", self.Url)
+	write("root_ca", self.RootCerts)
+	write("skip_verify", fmt.Sprintf("%v", self.SkipVerify))
+	write("cwd", self.Cwd)
+	for _, arg := range self.Command {
+		write("command", arg)
+	}
+	writeSortedDict(write, "headers", self.Headers)
+	writeSortedDict(write, "env", self.Env)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeSortedDict(write func(field, value string),
+	field string, dict *ordereddict.Dict) {
+	if dict == nil {
+		return
+	}
+
+	keys := dict.Keys()
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, _ := dict.Get(k)
+		write(field, k)
+		write(field, utils.ToString(v))
+	}
+}
+
+type Client struct {
+	mu sync.Mutex
+
+	transport   transport
+	next_id     int64
+	server_info *InitializeResult
+
+	// Cache the tool list since it rarely changes within a query.
+	tools []*Tool
+}
+
+func (self *Client) ServerInfo() *InitializeResult {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.server_info
+}
+
+func (self *Client) call(ctx context.Context,
+	method string, params interface{}, result interface{}) error {
+	self.mu.Lock()
+	self.next_id++
+	id := self.next_id
+	self.mu.Unlock()
+
+	resp, err := self.transport.RoundTrip(ctx, &jsonRPCRequest{
+		JSONRPC: JSONRPC_VERSION,
+		Id:      &id,
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp.Error != nil {
+		return resp.Error
+	}
+
+	if result == nil || len(resp.Result) == 0 {
+		return nil
+	}
+
+	err = json.Unmarshal(resp.Result, result)
+	if err != nil {
+		return fmt.Errorf("MCP %v: decoding result: %w", method, err)
+	}
+	return nil
+}
+
+func (self *Client) initialize(ctx context.Context) error {
+	result := &InitializeResult{}
+	err := self.call(ctx, "initialize", &initializeParams{
+		ProtocolVersion: PROTOCOL_VERSION,
+		Capabilities:    ordereddict.NewDict(),
+		ClientInfo: clientInfo{
+			Name:    CLIENT_NAME,
+			Version: constants.VERSION,
+		},
+	}, result)
+	if err != nil {
+		return err
+	}
+
+	self.mu.Lock()
+	self.server_info = result
+	self.mu.Unlock()
+
+	return self.transport.Notify(ctx, &jsonRPCRequest{
+		JSONRPC: JSONRPC_VERSION,
+		Method:  "notifications/initialized",
+	})
+}
+
+// List all the tools on the server, following pagination cursors.
+func (self *Client) ListTools(ctx context.Context) ([]*Tool, error) {
+	self.mu.Lock()
+	tools := self.tools
+	self.mu.Unlock()
+
+	if tools != nil {
+		return tools, nil
+	}
+
+	cursor := ""
+	for {
+		params := ordereddict.NewDict()
+		if cursor != "" {
+			params.Set("cursor", cursor)
+		}
+
+		result := &listToolsResult{}
+		err := self.call(ctx, "tools/list", params, result)
+		if err != nil {
+			return nil, err
+		}
+
+		tools = append(tools, result.Tools...)
+		if result.NextCursor == "" || result.NextCursor == cursor {
+			break
+		}
+		cursor = result.NextCursor
+	}
+
+	self.mu.Lock()
+	self.tools = tools
+	self.mu.Unlock()
+
+	return tools, nil
+}
+
+func (self *Client) CallTool(ctx context.Context,
+	name string, args *ordereddict.Dict) (*CallToolResult, error) {
+	if args == nil {
+		args = ordereddict.NewDict()
+	}
+
+	result := &CallToolResult{}
+	err := self.call(ctx, "tools/call", &callToolParams{
+		Name:      name,
+		Arguments: args,
+	}, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (self *Client) Close() error {
+	return self.transport.Close()
+}
+
+func newClient(ctx context.Context, scope vfilter.Scope,
+	spec *ServerSpec) (*Client, error) {
+	var t transport
+	var err error
+
+	switch {
+	case spec.Url != "":
+		t, err = newHTTPTransport(ctx, scope, spec)
+	case len(spec.Command) > 0:
+		t, err = newStdioTransport(ctx, scope, spec)
+	default:
+		err = errors.New("MCP server must specify either url or command")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{transport: t}
+	err = client.initialize(ctx)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("MCP initialize: %w", err)
+	}
+
+	return client, nil
+}
+
+// Get an MCP client for the server. Clients are cached in the scope
+// so multiple calls within the same query reuse the same session
+// (and the same subprocess for stdio servers). Clients are closed
+// when the scope is destroyed.
+func GetClient(ctx context.Context, scope vfilter.Scope,
+	spec *ServerSpec) (*Client, error) {
+
+	cache, ok := vql_subsystem.CacheGet(scope, MCP_CLIENT_CACHE_TAG).(*clientCache)
+	if !ok {
+		cache = &clientCache{entries: make(map[string]*cacheEntry)}
+
+		// Only publish the cache once we know it will be closed,
+		// otherwise subprocesses started through it would leak.
+		err := vql_subsystem.GetRootScope(scope).AddDestructor(cache.Close)
+		if err != nil {
+			return nil, err
+		}
+		vql_subsystem.CacheSet(scope, MCP_CLIENT_CACHE_TAG, cache)
+	}
+
+	return cache.Get(ctx, scope, spec)
+}
+
+// A cache entry is created before the client is initialized so
+// concurrent callers for the same server wait for the same
+// initialization. The ready channel is closed when client or err is
+// set.
+type cacheEntry struct {
+	ready  chan struct{}
+	client *Client
+	err    error
+}
+
+type clientCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	closed  bool
+}
+
+func (self *clientCache) Get(ctx context.Context, scope vfilter.Scope,
+	spec *ServerSpec) (*Client, error) {
+	key := spec.Key()
+
+	self.mu.Lock()
+	if self.closed {
+		self.mu.Unlock()
+		return nil, errCacheClosed
+	}
+
+	entry, pres := self.entries[key]
+	if !pres {
+		entry = &cacheEntry{ready: make(chan struct{})}
+		self.entries[key] = entry
+	}
+	self.mu.Unlock()
+
+	if pres {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-entry.ready:
+			return entry.client, entry.err
+		}
+	}
+
+	// Initialize without holding the lock so a slow server does not
+	// block calls to other servers.
+	client, err := newClient(ctx, scope, spec)
+
+	self.mu.Lock()
+	if err != nil {
+		// Do not cache failures - the next call will retry.
+		delete(self.entries, key)
+
+	} else if self.closed {
+		// The scope was destroyed while we were connecting.
+		client.Close()
+		client, err = nil, errCacheClosed
+	}
+	entry.client, entry.err = client, err
+	self.mu.Unlock()
+
+	close(entry.ready)
+	return client, err
+}
+
+func (self *clientCache) Close() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.closed = true
+	for _, entry := range self.entries {
+		// Entries still initializing are closed by Get() when it
+		// observes the closed flag.
+		select {
+		case <-entry.ready:
+			if entry.client != nil {
+				entry.client.Close()
+			}
+		default:
+		}
+	}
+	self.entries = make(map[string]*cacheEntry)
+}
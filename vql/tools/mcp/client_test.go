@@ -0,0 +1,322 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.velocidex.com/golang/velociraptor/acls"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/constants"
+	"www.velocidex.com/golang/velociraptor/json"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/velociraptor/vql/acl_managers"
+	"www.velocidex.com/golang/vfilter"
+)
+
+// A minimal MCP server. Tool calls are answered with an event
+// stream to exercise the SSE path while everything else is plain
+// JSON.
+func newTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "DELETE" {
+				return
+			}
+
+			body, _ := io.ReadAll(r.Body)
+			req := &jsonRPCRequest{}
+			require.NoError(t, json.Unmarshal(body, req))
+
+			var result interface{}
+			switch req.Method {
+			case "initialize":
+				w.Header().Set(SESSION_HEADER, "session1")
+				result = &InitializeResult{
+					ProtocolVersion: PROTOCOL_VERSION,
+					ServerInfo:      clientInfo{Name: "test", Version: "1"},
+				}
+
+			case "notifications/initialized":
+				w.WriteHeader(http.StatusAccepted)
+				return
+
+			case "tools/list":
+				assert.Equal(t, "session1", r.Header.Get(SESSION_HEADER))
+				result = &listToolsResult{Tools: []*Tool{{
+					Name:        "lookup",
+					Description: "Lookup an IP",
+				}}}
+
+			case "tools/call":
+				serialized, _ := json.Marshal(&jsonRPCResponse{
+					JSONRPC: JSONRPC_VERSION,
+					Id:      req.Id,
+					Result: json.RawMessage(
+						`{"content":[{"type":"text","text":"malicious"}]}`),
+				})
+				w.Header().Set("Content-Type", "text/event-stream")
+				fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n")
+				fmt.Fprintf(w, "event: message\ndata: %s\n\n", serialized)
+				return
+			}
+
+			serialized, _ := json.Marshal(result)
+			w.Header().Set("Content-Type", "application/json")
+			out, _ := json.Marshal(&jsonRPCResponse{
+				JSONRPC: JSONRPC_VERSION,
+				Id:      req.Id,
+				Result:  serialized,
+			})
+			w.Write(out)
+		}))
+}
+
+func TestHTTPClient(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	ctx := context.Background()
+	scope := vql_subsystem.MakeScope()
+	defer scope.Close()
+
+	client, err := GetClient(ctx, scope, &ServerSpec{Url: server.URL})
+	require.NoError(t, err)
+
+	assert.Equal(t, "test", client.ServerInfo().ServerInfo.Name)
+
+	tools, err := client.ListTools(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(tools))
+	assert.Equal(t, "lookup", tools[0].Name)
+
+	result, err := client.CallTool(ctx, "lookup",
+		ordereddict.NewDict().Set("ip", "10.1.1.1"))
+	require.NoError(t, err)
+	assert.Equal(t, "malicious", result.Text())
+
+	// A second request for the same server returns the cached client.
+	client2, err := GetClient(ctx, scope, &ServerSpec{Url: server.URL})
+	require.NoError(t, err)
+	assert.True(t, client == client2)
+}
+
+// A server which misbehaves in different ways depending on the tool
+// called.
+func newBrokenServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			req := &testServerRequest{}
+			require.NoError(t, json.Unmarshal(body, req))
+
+			resp := &jsonRPCResponse{JSONRPC: JSONRPC_VERSION, Id: req.Id}
+			switch req.Method {
+			case "initialize":
+				resp.Result = json.RawMessage(`{"protocolVersion":"2025-03-26"}`)
+
+			case "notifications/initialized":
+				w.WriteHeader(http.StatusAccepted)
+				return
+
+			case "tools/call":
+				name, _ := req.Params.GetString("name")
+				switch name {
+				case "rpc_error":
+					resp.Error = &jsonRPCError{Code: -32602, Message: "Invalid params"}
+
+				case "wrong_id":
+					wrong_id := *req.Id + 100
+					resp.Id = &wrong_id
+					resp.Result = json.RawMessage(`{"content":[]}`)
+
+				case "http_error":
+					http.Error(w, "internal failure", http.StatusInternalServerError)
+					return
+				}
+			}
+
+			out, _ := json.Marshal(resp)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(out)
+		}))
+}
+
+func TestHTTPClientErrors(t *testing.T) {
+	server := newBrokenServer(t)
+	defer server.Close()
+
+	ctx := context.Background()
+	scope := vql_subsystem.MakeScope()
+	defer scope.Close()
+
+	client, err := GetClient(ctx, scope, &ServerSpec{Url: server.URL})
+	require.NoError(t, err)
+
+	_, err = client.CallTool(ctx, "rpc_error", nil)
+	rpc_err := &jsonRPCError{}
+	require.True(t, errors.As(err, &rpc_err))
+	assert.Equal(t, int64(-32602), rpc_err.Code)
+
+	_, err = client.CallTool(ctx, "wrong_id", nil)
+	assert.ErrorIs(t, err, errMismatchedResponse)
+
+	_, err = client.CallTool(ctx, "http_error", nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "internal failure")
+}
+
+func TestClientCacheKey(t *testing.T) {
+	server := newTestServer(t)
+	defer server.Close()
+
+	ctx := context.Background()
+	scope := vql_subsystem.MakeScope()
+	defer scope.Close()
+
+	spec := func(token string) *ServerSpec {
+		return &ServerSpec{
+			Url:     server.URL,
+			Headers: ordereddict.NewDict().Set("Authorization", token),
+		}
+	}
+
+	client1, err := GetClient(ctx, scope, spec("Bearer A"))
+	require.NoError(t, err)
+
+	client2, err := GetClient(ctx, scope, spec("Bearer B"))
+	require.NoError(t, err)
+
+	// Different credentials must not share a session.
+	assert.True(t, client1 != client2)
+
+	client3, err := GetClient(ctx, scope, spec("Bearer A"))
+	require.NoError(t, err)
+	assert.True(t, client1 == client3)
+
+	// Key order of dicts does not matter but every field does.
+	assert.Equal(t,
+		(&ServerSpec{Env: ordereddict.NewDict().Set("A", "1").Set("B", "2")}).Key(),
+		(&ServerSpec{Env: ordereddict.NewDict().Set("B", "2").Set("A", "1")}).Key())
+
+	assert.NotEqual(t,
+		(&ServerSpec{Url: server.URL}).Key(),
+		(&ServerSpec{Url: server.URL, SkipVerify: true}).Key())
+}
+
+// Grants only the listed permissions.
+type testACLManager struct {
+	allowed []acls.ACL_PERMISSION
+}
+
+func (self testACLManager) CheckAccess(
+	permissions ...acls.ACL_PERMISSION) (bool, error) {
+	for _, perm := range permissions {
+		found := false
+		for _, allowed := range self.allowed {
+			if perm == allowed {
+				found = true
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (self testACLManager) CheckAccessWithArgs(
+	permission acls.ACL_PERMISSION, args ...string) (bool, error) {
+	return self.CheckAccess(permission)
+}
+
+func TestCheckServerAccess(t *testing.T) {
+	makeScope := func(client_config *config_proto.ClientConfig,
+		allowed ...acls.ACL_PERMISSION) vfilter.Scope {
+		scope := vql_subsystem.MakeScope()
+		env := ordereddict.NewDict().
+			Set(vql_subsystem.ACL_MANAGER_VAR, testACLManager{allowed: allowed})
+		if client_config != nil {
+			env.Set(constants.SCOPE_CONFIG, client_config)
+		}
+		scope.AppendVars(env)
+		return scope
+	}
+
+	url_spec := &ServerSpec{Url: "https://mcp.example.com/"}
+	cmd_spec := &ServerSpec{Command: []string{"mcp-server"}}
+
+	// COLLECT_SERVER allows connecting to remote servers but not
+	// launching local ones.
+	scope := makeScope(nil, acls.COLLECT_SERVER)
+	assert.NoError(t, CheckServerAccess(scope, url_spec))
+	assert.ErrorIs(t, CheckServerAccess(scope, cmd_spec), acls.PermissionDenied)
+	scope.Close()
+
+	// No permissions - neither is allowed.
+	scope = makeScope(nil)
+	assert.ErrorIs(t, CheckServerAccess(scope, url_spec), acls.PermissionDenied)
+	assert.ErrorIs(t, CheckServerAccess(scope, cmd_spec), acls.PermissionDenied)
+	scope.Close()
+
+	scope = makeScope(nil, acls.EXECVE)
+	assert.NoError(t, CheckServerAccess(scope, cmd_spec))
+	scope.Close()
+
+	// EXECVE is not enough when execve is disabled by the
+	// configuration.
+	scope = makeScope(&config_proto.ClientConfig{PreventExecve: true},
+		acls.COLLECT_SERVER, acls.EXECVE)
+	assert.NoError(t, CheckServerAccess(scope, url_spec))
+	assert.ErrorIs(t, CheckServerAccess(scope, cmd_spec), errExecveDenied)
+	scope.Close()
+}
+
+func TestPlugins(t *testing.T) {
+	ctx := context.Background()
+	scope := vql_subsystem.MakeScope()
+	defer scope.Close()
+
+	// Clients are cached for the life of the root scope, which
+	// outlives each query.
+	spec := testServerSpec(t)
+	scope.AppendVars(ordereddict.NewDict().
+		Set(constants.SCOPE_ROOT, scope).
+		Set(vql_subsystem.ACL_MANAGER_VAR, acl_managers.NullACLManager{}).
+		Set("Command", spec.Command).
+		Set("Env", spec.Env))
+
+	run := func(query string) []*ordereddict.Dict {
+		vql, err := vfilter.Parse(query)
+		require.NoError(t, err)
+
+		rows := []*ordereddict.Dict{}
+		for row := range vql.Eval(ctx, scope) {
+			rows = append(rows, vfilter.RowToDict(ctx, scope, row))
+		}
+		return rows
+	}
+
+	rows := run("SELECT Name FROM mcp_tools(command=Command, env=Env)")
+	require.Equal(t, 4, len(rows))
+	name, _ := rows[0].GetString("Name")
+	assert.Equal(t, "echo", name)
+
+	rows = run(`SELECT * FROM mcp_call(command=Command, env=Env,
+                    tool="echo", args=dict(text="from VQL"))`)
+	require.Equal(t, 1, len(rows))
+	text, _ := rows[0].GetString("Text")
+	assert.Equal(t, "echo: from VQL", text)
+
+	// Unknown args are still rejected after splitting.
+	rows = run(`SELECT * FROM mcp_call(command=Command, env=Env,
+                    tool="echo", bogus=1)`)
+	assert.Equal(t, 0, len(rows))
+}
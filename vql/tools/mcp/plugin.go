@@ -0,0 +1,224 @@
+package mcp
+
+import (
+	"context"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/artifacts"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	vfilter "www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+// Arguments common to all MCP plugins describing how to connect to
+// the server.
+type MCPServerArgs struct {
+	Url        string            `vfilter:"optional,field=url,doc=The URL of a streamable HTTP MCP server."`
+	Headers    *ordereddict.Dict `vfilter:"optional,field=headers,doc=A dict of headers to send (e.g. Authorization)."`
+	RootCerts  string            `vfilter:"optional,field=root_ca,doc=Extra root CA certs to trust for the server."`
+	SkipVerify bool              `vfilter:"optional,field=skip_verify,doc=Disable ssl certificate verifications."`
+	Command    []string          `vfilter:"optional,field=command,doc=An argv list to launch a stdio MCP server."`
+	Env        *ordereddict.Dict `vfilter:"optional,field=env,doc=Environment variables for the stdio server."`
+	Cwd        string            `vfilter:"optional,field=cwd,doc=Working directory for the stdio server."`
+}
+
+func (self *MCPServerArgs) Spec() *ServerSpec {
+	return &ServerSpec{
+		Url:        self.Url,
+		Headers:    self.Headers,
+		RootCerts:  self.RootCerts,
+		SkipVerify: self.SkipVerify,
+		Command:    self.Command,
+		Env:        self.Env,
+		Cwd:        self.Cwd,
+	}
+}
+
+// Talking to a remote server requires the same permission as
+// http_client() while launching a local server is equivalent to
+// execve().
+func CheckServerAccess(scope vfilter.Scope, spec *ServerSpec) error {
+	if len(spec.Command) > 0 {
+		err := vql_subsystem.CheckAccess(scope, acls.EXECVE)
+		if err != nil {
+			return err
+		}
+
+		config_obj, ok := artifacts.GetConfig(scope)
+		if ok && config_obj.PreventExecve {
+			return errExecveDenied
+		}
+		return nil
+	}
+
+	return vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+}
+
+type MCPToolsPlugin struct{}
+
+func (self MCPToolsPlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("mcp_tools", args)()
+
+		arg := &MCPServerArgs{}
+		err := arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+		if err != nil {
+			scope.Log("mcp_tools: %v", err)
+			return
+		}
+
+		spec := arg.Spec()
+		err = CheckServerAccess(scope, spec)
+		if err != nil {
+			scope.Log("mcp_tools: %v", err)
+			return
+		}
+
+		client, err := GetClient(ctx, scope, spec)
+		if err != nil {
+			scope.Log("mcp_tools: %v", err)
+			return
+		}
+
+		tools, err := client.ListTools(ctx)
+		if err != nil {
+			scope.Log("mcp_tools: %v", err)
+			return
+		}
+
+		for _, tool := range tools {
+			select {
+			case <-ctx.Done():
+				return
+			case output_chan <- ordereddict.NewDict().
+				Set("Name", tool.Name).
+				Set("Description", tool.Description).
+				Set("InputSchema", tool.InputSchema):
+			}
+		}
+	}()
+
+	return output_chan
+}
+
+func (self MCPToolsPlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name:    "mcp_tools",
+		Doc:     "List the tools offered by an MCP server.",
+		ArgType: type_map.AddType(scope, &MCPServerArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().
+			Permissions(acls.COLLECT_SERVER, acls.EXECVE).Build(),
+	}
+}
+
+// The arg parser does not descend into embedded structs so the server
+// args are extracted separately (see splitArgs). Embedding still
+// documents them as part of this plugin's args.
+type MCPCallPluginArgs struct {
+	MCPServerArgs
+	Tool      string            `vfilter:"required,field=tool,doc=The name of the tool to call."`
+	Arguments *ordereddict.Dict `vfilter:"optional,field=args,doc=A dict of arguments to pass to the tool."`
+}
+
+// Split args into those belonging to the embedded server args and
+// the rest.
+func splitArgs(args *ordereddict.Dict, fields ...string) (
+	server_args *ordereddict.Dict, other_args *ordereddict.Dict) {
+	server_args = ordereddict.NewDict()
+	other_args = ordereddict.NewDict()
+
+	for _, k := range args.Keys() {
+		v, _ := args.Get(k)
+		if utils.InString(fields, k) {
+			other_args.Set(k, v)
+		} else {
+			server_args.Set(k, v)
+		}
+	}
+	return server_args, other_args
+}
+
+type MCPCallPlugin struct{}
+
+func (self MCPCallPlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("mcp_call", args)()
+
+		server_args, call_args := splitArgs(args, "tool", "args")
+
+		arg := &MCPCallPluginArgs{}
+		err := arg_parser.ExtractArgsWithContext(ctx, scope, call_args, arg)
+		if err != nil {
+			scope.Log("mcp_call: %v", err)
+			return
+		}
+
+		err = arg_parser.ExtractArgsWithContext(
+			ctx, scope, server_args, &arg.MCPServerArgs)
+		if err != nil {
+			scope.Log("mcp_call: %v", err)
+			return
+		}
+
+		spec := arg.Spec()
+		err = CheckServerAccess(scope, spec)
+		if err != nil {
+			scope.Log("mcp_call: %v", err)
+			return
+		}
+
+		client, err := GetClient(ctx, scope, spec)
+		if err != nil {
+			scope.Log("mcp_call: %v", err)
+			return
+		}
+
+		result, err := client.CallTool(ctx, arg.Tool, arg.Arguments)
+		if err != nil {
+			scope.Log("mcp_call: %v", err)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+		case output_chan <- ordereddict.NewDict().
+			Set("Tool", arg.Tool).
+			Set("IsError", result.IsError).
+			Set("Text", result.Text()).
+			Set("Content", result.Content):
+		}
+	}()
+
+	return output_chan
+}
+
+func (self MCPCallPlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name:    "mcp_call",
+		Doc:     "Call a tool on an MCP server.",
+		ArgType: type_map.AddType(scope, &MCPCallPluginArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().
+			Permissions(acls.COLLECT_SERVER, acls.EXECVE).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterPlugin(&MCPToolsPlugin{})
+	vql_subsystem.RegisterPlugin(&MCPCallPlugin{})
+}
@@ -0,0 +1,125 @@
+/*
+  The Model Context Protocol (MCP) is a JSON-RPC 2.0 based protocol
+  which allows language model applications to discover and call tools
+  hosted by external servers.
+
+  This package implements the client side of the protocol so that
+  tools exposed by MCP servers (e.g. threat intel lookups, ticketing
+  systems) can be called from VQL and offered to a model.
+
+  Two transports are supported:
+
+  1. Streamable HTTP - JSON-RPC messages are POSTed to a single
+     endpoint. The server may reply with a plain JSON body or a
+     text/event-stream carrying the response.
+
+  2. Stdio - the server is launched as a subprocess and messages are
+     exchanged as newline delimited JSON over its stdin/stdout.
+*/
+
+package mcp
+
+import (
+	"fmt"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+const (
+	PROTOCOL_VERSION = "2025-03-26"
+	CLIENT_NAME      = "velociraptor"
+
+	JSONRPC_VERSION = "2.0"
+)
+
+type jsonRPCRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Id      *int64      `json:"id,omitempty"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+type jsonRPCError struct {
+	Code    int64           `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (self *jsonRPCError) Error() string {
+	return fmt.Sprintf("MCP error %v: %v", self.Code, self.Message)
+}
+
+type jsonRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Id      *int64          `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonRPCError   `json:"error,omitempty"`
+}
+
+type clientInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type initializeParams struct {
+	ProtocolVersion string            `json:"protocolVersion"`
+	Capabilities    *ordereddict.Dict `json:"capabilities"`
+	ClientInfo      clientInfo        `json:"clientInfo"`
+}
+
+type InitializeResult struct {
+	ProtocolVersion string            `json:"protocolVersion"`
+	Capabilities    *ordereddict.Dict `json:"capabilities,omitempty"`
+	ServerInfo      clientInfo        `json:"serverInfo"`
+	Instructions    string            `json:"instructions,omitempty"`
+}
+
+// A tool advertised by the MCP server. The InputSchema is a JSON
+// schema describing the arguments the tool accepts.
+type Tool struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	InputSchema *ordereddict.Dict `json:"inputSchema,omitempty"`
+}
+
+type listToolsResult struct {
+	Tools      []*Tool `json:"tools"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+}
+
+type callToolParams struct {
+	Name      string            `json:"name"`
+	Arguments *ordereddict.Dict `json:"arguments"`
+}
+
+// Tool results are a list of typed content items. We only interpret
+// text content - other content types (image, resource) are passed
+// through as is.
+type Content struct {
+	Type     string            `json:"type"`
+	Text     string            `json:"text,omitempty"`
+	Data     string            `json:"data,omitempty"`
+	MimeType string            `json:"mimeType,omitempty"`
+	Resource *ordereddict.Dict `json:"resource,omitempty"`
+}
+
+type CallToolResult struct {
+	Content []*Content `json:"content"`
+	IsError bool       `json:"isError,omitempty"`
+}
+
+// Concatenate all the text content in the result.
+func (self *CallToolResult) Text() string {
+	result := ""
+	for _, c := range self.Content {
+		if c.Type == "text" {
+			if result != "" {
+				result += "\n"
+			}
+			result += c.Text
+		}
+	}
+	return result
+}
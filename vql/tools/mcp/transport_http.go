@@ -0,0 +1,219 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"www.velocidex.com/golang/velociraptor/artifacts"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/velociraptor/utils/sse"
+	"www.velocidex.com/golang/velociraptor/vql/networking"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	SESSION_HEADER = "Mcp-Session-Id"
+
+	// Do not read unreasonably large responses into memory.
+	MAX_RESPONSE_SIZE = 10 * 1024 * 1024
+)
+
+var (
+	errMismatchedResponse = errors.New("MCP: response id does not match request")
+)
+
+// Implements the streamable HTTP transport.
+type httpTransport struct {
+	mu         sync.Mutex
+	url        string
+	headers    map[string]string
+	client     *http.Client
+	session_id string
+}
+
+func newHTTPTransport(ctx context.Context, scope vfilter.Scope,
+	spec *ServerSpec) (*httpTransport, error) {
+	config_obj, _ := artifacts.GetConfig(scope)
+
+	transport, err := networking.GetHttpTransport(config_obj, spec.RootCerts)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.SkipVerify {
+		err = networking.EnableSkipVerify(transport.TLSClientConfig, config_obj)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	headers := make(map[string]string)
+	if spec.Headers != nil {
+		for _, k := range spec.Headers.Keys() {
+			v, _ := spec.Headers.Get(k)
+			headers[k] = utils.ToString(v)
+		}
+	}
+
+	return &httpTransport{
+		url:     spec.Url,
+		headers: headers,
+		client: &http.Client{
+			Timeout:   time.Second * 600,
+			Transport: transport,
+		},
+	}, nil
+}
+
+func (self *httpTransport) post(ctx context.Context,
+	req *jsonRPCRequest) (*http.Response, error) {
+	serialized, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	http_req, err := http.NewRequestWithContext(
+		ctx, "POST", self.url, bytes.NewReader(serialized))
+	if err != nil {
+		return nil, err
+	}
+
+	http_req.Header.Set("Content-Type", "application/json")
+	http_req.Header.Set("Accept", "application/json, text/event-stream")
+	for k, v := range self.headers {
+		http_req.Header.Set(k, v)
+	}
+
+	self.mu.Lock()
+	session_id := self.session_id
+	self.mu.Unlock()
+
+	if session_id != "" {
+		http_req.Header.Set(SESSION_HEADER, session_id)
+	}
+
+	resp, err := self.client.Do(http_req)
+	if err != nil {
+		return nil, err
+	}
+
+	// The server assigns a session id during initialization which
+	// we must present on all subsequent requests.
+	new_session_id := resp.Header.Get(SESSION_HEADER)
+	if new_session_id != "" {
+		self.mu.Lock()
+		self.session_id = new_session_id
+		self.mu.Unlock()
+	}
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("MCP server returned %v: %v",
+			resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return resp, nil
+}
+
+func (self *httpTransport) Notify(ctx context.Context, req *jsonRPCRequest) error {
+	resp, err := self.post(ctx, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (self *httpTransport) RoundTrip(
+	ctx context.Context, req *jsonRPCRequest) (*jsonRPCResponse, error) {
+	resp, err := self.post(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	reader := io.LimitReader(resp.Body, MAX_RESPONSE_SIZE)
+
+	media_type, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if media_type == "text/event-stream" {
+		return readSSEResponse(reader, req.Id)
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &jsonRPCResponse{}
+	err = json.Unmarshal(body, result)
+	if err != nil {
+		return nil, fmt.Errorf("MCP: invalid response: %w", err)
+	}
+
+	if result.Id == nil || req.Id == nil || *result.Id != *req.Id {
+		return nil, errMismatchedResponse
+	}
+	return result, nil
+}
+
+// Close the session. Servers may not support explicit termination
+// so errors are ignored.
+func (self *httpTransport) Close() error {
+	self.mu.Lock()
+	session_id := self.session_id
+	self.mu.Unlock()
+
+	if session_id == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", self.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(SESSION_HEADER, session_id)
+	for k, v := range self.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := self.client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// Read server sent events until we find the response matching our
+// request id. Other messages (server notifications, progress) are
+// skipped.
+func readSSEResponse(reader io.Reader, id *int64) (*jsonRPCResponse, error) {
+	events := sse.NewReader(reader, MAX_RESPONSE_SIZE)
+	for {
+		event, err := events.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("MCP: event stream ended without a response")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		result := &jsonRPCResponse{}
+		err = json.Unmarshal(event.Data, result)
+		if err == nil && result.Id != nil && id != nil && *result.Id == *id {
+			return result, nil
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/vfilter"
+)
+
+var (
+	errTransportClosed = errors.New("MCP: server process exited")
+	errExecveDenied    = errors.New("MCP: Not allowed to execve by configuration.")
+)
+
+// Implements the stdio transport. The server is a subprocess which
+// reads newline delimited JSON-RPC messages on stdin and writes
+// responses to stdout.
+type stdioTransport struct {
+	// Serialize writes to stdin.
+	write_mu sync.Mutex
+	stdin    io.WriteCloser
+
+	mu      sync.Mutex
+	pending map[int64]chan *jsonRPCResponse
+	closed  bool
+
+	cancel func()
+	cmd    *exec.Cmd
+
+	// Tracks the goroutines reading stdout and stderr. These must
+	// finish before cmd.Wait() is called.
+	wg         sync.WaitGroup
+	close_once sync.Once
+}
+
+func newStdioTransport(ctx context.Context, scope vfilter.Scope,
+	spec *ServerSpec) (*stdioTransport, error) {
+
+	// The subprocess lives as long as the client is cached, not just
+	// for the duration of the call that created it. It is killed
+	// when the transport is closed.
+	sub_ctx, cancel := context.WithCancel(context.Background())
+
+	cmd := exec.CommandContext(sub_ctx, spec.Command[0], spec.Command[1:]...)
+	cmd.Dir = spec.Cwd
+	if spec.Env != nil {
+		cmd.Env = os.Environ()
+		for _, k := range spec.Env.Keys() {
+			v, _ := spec.Env.Get(k)
+			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, utils.ToString(v)))
+		}
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// Report the command we ran for auditing purposes.
+	scope.Log("mcp: Running MCP server %v", spec.Command)
+
+	err = cmd.Start()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	self := &stdioTransport{
+		stdin:   stdin,
+		pending: make(map[int64]chan *jsonRPCResponse),
+		cancel:  cancel,
+		cmd:     cmd,
+	}
+
+	// Servers log to stderr - forward these to the query log.
+	self.wg.Add(2)
+	go func() {
+		defer self.wg.Done()
+
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			scope.Log("mcp: %v: %v", spec.Command[0], scanner.Text())
+		}
+	}()
+
+	go self.readLoop(stdout)
+
+	return self, nil
+}
+
+func (self *stdioTransport) readLoop(stdout io.Reader) {
+	defer self.wg.Done()
+	defer self.shutdown()
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), MAX_RESPONSE_SIZE)
+
+	for scanner.Scan() {
+		resp := &jsonRPCResponse{}
+		err := json.Unmarshal(scanner.Bytes(), resp)
+		if err != nil || resp.Id == nil {
+			// Not a response - could be a notification or
+			// garbage.
+			continue
+		}
+
+		self.mu.Lock()
+		response_chan, pres := self.pending[*resp.Id]
+		if pres {
+			delete(self.pending, *resp.Id)
+		}
+		self.mu.Unlock()
+
+		if pres {
+			response_chan <- resp
+		}
+	}
+}
+
+// Fail all pending requests when the process goes away.
+func (self *stdioTransport) shutdown() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.closed = true
+	for id, c := range self.pending {
+		close(c)
+		delete(self.pending, id)
+	}
+}
+
+func (self *stdioTransport) write(req *jsonRPCRequest) error {
+	serialized, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	self.write_mu.Lock()
+	defer self.write_mu.Unlock()
+
+	_, err = self.stdin.Write(append(serialized, '\n'))
+	return err
+}
+
+func (self *stdioTransport) Notify(ctx context.Context, req *jsonRPCRequest) error {
+	return self.write(req)
+}
+
+func (self *stdioTransport) RoundTrip(
+	ctx context.Context, req *jsonRPCRequest) (*jsonRPCResponse, error) {
+	if req.Id == nil {
+		return nil, errors.New("MCP: request must have an id")
+	}
+
+	// Buffered so the read loop never blocks on an abandoned
+	// request.
+	response_chan := make(chan *jsonRPCResponse, 1)
+
+	self.mu.Lock()
+	if self.closed {
+		self.mu.Unlock()
+		return nil, errTransportClosed
+	}
+	self.pending[*req.Id] = response_chan
+	self.mu.Unlock()
+
+	err := self.write(req)
+	if err != nil {
+		self.mu.Lock()
+		delete(self.pending, *req.Id)
+		self.mu.Unlock()
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		self.mu.Lock()
+		delete(self.pending, *req.Id)
+		self.mu.Unlock()
+		return nil, ctx.Err()
+
+	case resp, ok := <-response_chan:
+		if !ok {
+			return nil, errTransportClosed
+		}
+		return resp, nil
+	}
+}
+
+func (self *stdioTransport) Close() error {
+	self.close_once.Do(func() {
+		// Closing stdin is the polite way to ask the server to
+		// exit.
+		self.stdin.Close()
+		self.cancel()
+
+		// Killing the process closes its end of the pipes so the
+		// readers will see EOF. Only then is it safe to reap the
+		// process.
+		self.wg.Wait()
+		_ = self.cmd.Wait()
+	})
+	return nil
+}
@@ -0,0 +1,200 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"www.velocidex.com/golang/velociraptor/json"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+)
+
+const (
+	TEST_SERVER_ENV = "VELOCIRAPTOR_MCP_TEST_SERVER"
+)
+
+// When the test binary is launched with the env var set it acts as
+// a stdio MCP server instead of running the tests.
+func TestMain(m *testing.M) {
+	if os.Getenv(TEST_SERVER_ENV) != "" {
+		runTestServer()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+type testServerRequest struct {
+	Id     *int64            `json:"id"`
+	Method string            `json:"method"`
+	Params *ordereddict.Dict `json:"params"`
+}
+
+func runTestServer() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		req := &testServerRequest{}
+		err := json.Unmarshal(scanner.Bytes(), req)
+		if err != nil || req.Id == nil {
+			continue
+		}
+
+		var result interface{}
+		var rpc_err *jsonRPCError
+
+		switch req.Method {
+		case "initialize":
+			result = &InitializeResult{
+				ProtocolVersion: PROTOCOL_VERSION,
+				ServerInfo:      clientInfo{Name: "stdio_test", Version: "1"},
+			}
+
+		// Tools are returned over two pages.
+		case "tools/list":
+			cursor, _ := req.Params.GetString("cursor")
+			if cursor == "" {
+				result = &listToolsResult{
+					Tools:      []*Tool{{Name: "echo"}},
+					NextCursor: "page2",
+				}
+			} else {
+				result = &listToolsResult{
+					Tools: []*Tool{{Name: "fail"}, {Name: "crash"}, {Name: "hang"}},
+				}
+			}
+
+		case "tools/call":
+			name, _ := req.Params.GetString("name")
+			switch name {
+			case "echo":
+				args, _ := ordereddict.GetMap(req.Params, "arguments")
+				text, _ := args.GetString("text")
+				result = &CallToolResult{Content: []*Content{{
+					Type: "text", Text: "echo: " + text}}}
+
+			case "fail":
+				rpc_err = &jsonRPCError{Code: -32000, Message: "boom"}
+
+			case "crash":
+				os.Exit(1)
+
+			case "hang":
+				continue
+			}
+
+		default:
+			rpc_err = &jsonRPCError{Code: -32601, Message: "Method not found"}
+		}
+
+		resp := &jsonRPCResponse{JSONRPC: JSONRPC_VERSION, Id: req.Id, Error: rpc_err}
+		if result != nil {
+			resp.Result, _ = json.Marshal(result)
+		}
+		serialized, _ := json.Marshal(resp)
+		fmt.Println(string(serialized))
+	}
+}
+
+func testServerSpec(t *testing.T) *ServerSpec {
+	binary, err := os.Executable()
+	require.NoError(t, err)
+
+	return &ServerSpec{
+		Command: []string{binary},
+		Env:     ordereddict.NewDict().Set(TEST_SERVER_ENV, "1"),
+	}
+}
+
+func TestStdioClient(t *testing.T) {
+	ctx := context.Background()
+	scope := vql_subsystem.MakeScope()
+	defer scope.Close()
+
+	client, err := GetClient(ctx, scope, testServerSpec(t))
+	require.NoError(t, err)
+	assert.Equal(t, "stdio_test", client.ServerInfo().ServerInfo.Name)
+
+	// Both pages are returned.
+	tools, err := client.ListTools(ctx)
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	assert.Equal(t, []string{"echo", "fail", "crash", "hang"}, names)
+
+	result, err := client.CallTool(ctx, "echo",
+		ordereddict.NewDict().Set("text", "hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "echo: hello", result.Text())
+
+	// JSON-RPC errors are returned as errors.
+	_, err = client.CallTool(ctx, "fail", nil)
+	rpc_err := &jsonRPCError{}
+	require.True(t, errors.As(err, &rpc_err))
+	assert.Equal(t, int64(-32000), rpc_err.Code)
+	assert.Equal(t, "boom", rpc_err.Message)
+
+	// The session is still usable after an error.
+	result, err = client.CallTool(ctx, "echo",
+		ordereddict.NewDict().Set("text", "again"))
+	require.NoError(t, err)
+	assert.Equal(t, "echo: again", result.Text())
+}
+
+func TestStdioServerExits(t *testing.T) {
+	ctx := context.Background()
+	scope := vql_subsystem.MakeScope()
+	defer scope.Close()
+
+	client, err := GetClient(ctx, scope, testServerSpec(t))
+	require.NoError(t, err)
+
+	// The pending call fails when the server dies under it.
+	_, err = client.CallTool(ctx, "crash", nil)
+	assert.ErrorIs(t, err, errTransportClosed)
+
+	// As do all subsequent calls.
+	_, err = client.CallTool(ctx, "echo", nil)
+	assert.ErrorIs(t, err, errTransportClosed)
+}
+
+func TestStdioCancellation(t *testing.T) {
+	scope := vql_subsystem.MakeScope()
+	defer scope.Close()
+
+	client, err := GetClient(context.Background(), scope, testServerSpec(t))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	_, err = client.CallTool(ctx, "hang", nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The abandoned request does not break the session.
+	result, err := client.CallTool(context.Background(), "echo",
+		ordereddict.NewDict().Set("text", "still here"))
+	require.NoError(t, err)
+	assert.Equal(t, "echo: still here", result.Text())
+
+	// Closing kills the server and reaps it.
+	done := make(chan bool)
+	go func() {
+		client.Close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out closing the stdio transport")
+	}
+}
@@ -0,0 +1,440 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+type OllamaAgentPluginArgs struct {
+	Model           string              `vfilter:"optional,field=model,doc=The model to use. It must support tool calling. Defaults to AI.default_model in the config."`
+	Prompt          string              `vfilter:"required,field=prompt,doc=The task for the agent."`
+	System          string              `vfilter:"optional,field=system,doc=A system prompt."`
+	Tools           []*ordereddict.Dict `vfilter:"optional,field=tools,doc=A list of VQL tools. Each is a dict with name, description, parameters (a JSON schema), query (a VQL string) and risk (low, medium or high)."`
+	MCPServers      []*ordereddict.Dict `vfilter:"optional,field=mcp_servers,doc=A list of MCP servers whose tools are offered to the model. Each is a dict of mcp_tools() args with an optional risk (default medium)."`
+	MaxSteps        int64               `vfilter:"optional,field=max_steps,doc=The maximum number of model calls (default 10)."`
+	ApprovalLevel   string              `vfilter:"optional,field=approval_level,doc=Tools at or above this risk level require approval (low, medium, high or none - default high)."`
+	ApprovalTimeout int64               `vfilter:"optional,field=approval_timeout,doc=Seconds to wait for an approval before treating it as denied (default 3600)."`
+	MaxTotalTokens  int64               `vfilter:"optional,field=max_total_tokens,doc=Stop once the prompt and completion tokens of all model calls reach this many."`
+	MaxCalls        int64               `vfilter:"optional,field=max_calls,doc=The maximum number of tool calls."`
+	MaxWallTime     int64               `vfilter:"optional,field=max_wall_time,doc=Stop the run after this many seconds."`
+	ResultStrategy  string              `vfilter:"optional,field=tool_result_strategy,doc=How to shrink large tool results: truncate (default), head_tail, summarize or none."`
+	ResultMaxRows   int64               `vfilter:"optional,field=tool_result_max_rows,doc=Tool results with more rows are reduced (default 100)."`
+	ResultMaxBytes  int64               `vfilter:"optional,field=tool_result_max_bytes,doc=Tool results larger than this are reduced (default 16kb)."`
+	Guardrails      []*ordereddict.Dict `vfilter:"optional,field=guardrails,doc=Rules flagging dangerous content in the final response. Each is a dict with name, regex and action (annotate, block or ignore). Built in rules: shell_command, secret and url."`
+	Investigation   string              `vfilter:"optional,field=investigation,doc=An id (e.g. the NotebookId) under which the transcript, plan and findings are stored on the server. A later run with the same id resumes the investigation."`
+	BaseUrl         string              `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server. Several comma separated URLs, or srv://name for the servers of a DNS SRV record, spread the calls over the servers."`
+	Options         *ordereddict.Dict   `vfilter:"optional,field=options,doc=Model options (e.g. temperature, num_ctx)."`
+	KeepAlive       string              `vfilter:"optional,field=keep_alive,doc=How long the model stays loaded after the call (e.g. 5m)."`
+}
+
+// The state of a single agent run.
+type agentRun struct {
+	arg              *OllamaAgentPluginArgs
+	client           *Client
+	tools            *toolSet
+	approval_level   RiskLevel
+	approval_timeout time.Duration
+	messages         []*Message
+	budget           *Budget
+	reducer          *resultReducer
+	guardrails       *Guardrails
+
+	// Set when the run is persisted.
+	investigation *Investigation
+}
+
+type OllamaAgentPlugin struct{}
+
+func (self OllamaAgentPlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("ollama_agent", args)()
+
+		err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+		if err != nil {
+			scope.Log("ollama_agent: %v", err)
+			return
+		}
+
+		arg := &OllamaAgentPluginArgs{}
+		err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+		if err != nil {
+			scope.Log("ollama_agent: %v", err)
+			return
+		}
+
+		run, err := newAgentRun(ctx, scope, arg)
+		if err != nil {
+			scope.Log("ollama_agent: %v", err)
+			return
+		}
+
+		err = run.Run(ctx, scope, output_chan)
+		if err != nil {
+			scope.Log("ollama_agent: %v", err)
+		}
+	}()
+
+	return output_chan
+}
+
+func newAgentRun(ctx context.Context, scope vfilter.Scope,
+	arg *OllamaAgentPluginArgs) (*agentRun, error) {
+	if arg.MaxSteps == 0 {
+		arg.MaxSteps = 10
+	}
+
+	if arg.ApprovalTimeout == 0 {
+		arg.ApprovalTimeout = 3600
+	}
+
+	approval_level, err := parseApprovalLevel(arg.ApprovalLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	tools, err := parseVQLTools(ctx, scope, arg.Tools)
+	if err != nil {
+		return nil, err
+	}
+
+	mcp_tools, err := parseMCPTools(ctx, scope, arg.MCPServers)
+	if err != nil {
+		return nil, err
+	}
+	tools = append(tools, mcp_tools...)
+
+	var investigation *Investigation
+	var transcript []*Message
+	if arg.Investigation != "" {
+		investigation, err = OpenInvestigation(scope, arg.Investigation)
+		if err != nil {
+			return nil, err
+		}
+
+		transcript, err = investigation.LoadTranscript(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tools = append(tools, scratchpadTools(investigation)...)
+	}
+
+	tool_set, err := newToolSet(tools)
+	if err != nil {
+		return nil, err
+	}
+
+	reducer, err := newResultReducer(arg.ResultStrategy,
+		arg.ResultMaxRows, arg.ResultMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	guardrails, err := parseGuardrails(ctx, scope, arg.Guardrails)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := NewClient(ctx, scope, arg.BaseUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	arg.Model, err = client.Model(arg.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every step resends the transcript so is best served by the
+	// server which handled the previous one.
+	session := arg.Investigation
+	if session == "" {
+		session = arg.System + "\n" + arg.Prompt
+	}
+	client = client.WithSession("agent:" + session)
+
+	result := &agentRun{
+		arg:              arg,
+		client:           client,
+		tools:            tool_set,
+		approval_level:   approval_level,
+		approval_timeout: time.Duration(arg.ApprovalTimeout) * time.Second,
+		budget: NewBudget(arg.MaxTotalTokens, arg.MaxCalls,
+			time.Duration(arg.MaxWallTime)*time.Second),
+		reducer:       reducer,
+		guardrails:    guardrails,
+		investigation: investigation,
+	}
+	reducer.summarize = result.summarizeResult
+
+	// Resuming an investigation.
+	result.messages = trimIncompleteStep(transcript)
+	if len(result.messages) < len(transcript) {
+		err = investigation.SetTranscript(result.messages)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(result.messages) > 0 {
+		// The previous run finished so the prompt is a follow up
+		// question. Otherwise we just continue where it stopped.
+		last := result.messages[len(result.messages)-1]
+		if last.Role == "assistant" {
+			err = result.addMessage(&Message{Role: "user", Content: arg.Prompt})
+		}
+		return result, err
+	}
+
+	if arg.System != "" {
+		err = result.addMessage(&Message{Role: "system", Content: arg.System})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = result.addMessage(&Message{Role: "user", Content: arg.Prompt})
+	return result, err
+}
+
+// Add a message to the conversation, persisting it if required.
+func (self *agentRun) addMessage(message *Message) error {
+	self.messages = append(self.messages, message)
+	if self.investigation != nil {
+		return self.investigation.AppendMessage(message)
+	}
+	return nil
+}
+
+// Run the agent until it produces a final answer or exhausts its
+// budget. An accounting row is always emitted at the end.
+func (self *agentRun) Run(ctx context.Context, scope vfilter.Scope,
+	output_chan chan vfilter.Row) error {
+	sub_ctx := ctx
+	if self.arg.MaxWallTime > 0 {
+		var cancel func()
+		sub_ctx, cancel = context.WithTimeout(ctx,
+			time.Duration(self.arg.MaxWallTime)*time.Second)
+		defer cancel()
+	}
+
+	err := self.loop(sub_ctx, scope, output_chan)
+	if err != nil && ctx.Err() == nil && sub_ctx.Err() != nil {
+		self.budget.WallTimeExceeded()
+		err = self.budget.Check()
+	}
+
+	select {
+	case <-ctx.Done():
+	case output_chan <- self.budget.Row():
+	}
+
+	return err
+}
+
+func (self *agentRun) loop(ctx context.Context, scope vfilter.Scope,
+	output_chan chan vfilter.Row) error {
+	for step := int64(1); step <= self.arg.MaxSteps; step++ {
+		message, err := self.chat(ctx)
+		if err != nil {
+			return err
+		}
+		err = self.addMessage(message)
+		if err != nil {
+			return err
+		}
+
+		// No more tool calls means the model has its final answer.
+		if len(message.ToolCalls) == 0 {
+			row := ordereddict.NewDict().
+				Set("Step", step).
+				Set("Type", "Response").
+				Set("Content", message.Content)
+			self.guardrails.Filter(scope, "ollama_agent", row, "Content")
+
+			select {
+			case <-ctx.Done():
+			case output_chan <- row:
+			}
+			return nil
+		}
+
+		// Do not act on the response if it took us over budget.
+		err = self.budget.Check()
+		if err != nil {
+			return err
+		}
+
+		for _, call := range message.ToolCalls {
+			err = self.budget.StartToolCall()
+			if err != nil {
+				return err
+			}
+
+			row := self.callTool(ctx, scope, step, call)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case output_chan <- row:
+			}
+		}
+	}
+
+	scope.Log("ollama_agent: Reached max_steps (%v) without a final answer",
+		self.arg.MaxSteps)
+	return nil
+}
+
+func (self *agentRun) chat(ctx context.Context) (*Message, error) {
+	req := &ChatRequest{
+		Model:     self.arg.Model,
+		Messages:  self.messages,
+		Tools:     self.tools.specs,
+		Options:   self.arg.Options,
+		KeepAlive: self.arg.KeepAlive,
+	}
+
+	result := &Message{Role: "assistant"}
+	var stats *Stats
+	err := self.client.Chat(ctx, req, func(resp *ChatResponse) error {
+		if resp.Message != nil {
+			result = resp.Message
+		}
+		if resp.Done {
+			stats = &resp.Stats
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	self.budget.AddModelCall(stats)
+	return result, nil
+}
+
+// Run a single tool call, gated by approval if required, and add the
+// result to the conversation. Errors are reported back to the model
+// so it can try something else.
+func (self *agentRun) callTool(ctx context.Context, scope vfilter.Scope,
+	step int64, call *ToolCall) *ordereddict.Dict {
+	name := call.Function.Name
+	args := call.Function.Arguments
+
+	row := ordereddict.NewDict().
+		Set("Step", step).
+		Set("Type", "ToolCall").
+		Set("Tool", name).
+		Set("Arguments", args)
+
+	var content string
+	tool, pres := self.tools.Get(name)
+	if !pres {
+		content = "Error: unknown tool " + name
+
+	} else if tool.Risk() >= self.approval_level {
+		approved, reason, err := requestApproval(ctx, scope,
+			name, args, tool.Risk(), self.approval_timeout)
+		row.Set("Approved", approved)
+
+		switch {
+		case err != nil:
+			content = "Error: " + err.Error()
+		case !approved:
+			content = "The action was not approved by the user. " + reason
+		default:
+			content = self.runTool(ctx, scope, tool, args)
+		}
+
+	} else {
+		content = self.runTool(ctx, scope, tool, args)
+	}
+
+	err := self.addMessage(&Message{
+		Role:     "tool",
+		Content:  content,
+		ToolName: name,
+	})
+	if err != nil {
+		scope.Log("ollama_agent: %v", err)
+	}
+
+	return row.Set("Result", content)
+}
+
+func (self *agentRun) runTool(ctx context.Context, scope vfilter.Scope,
+	tool AgentTool, args *ordereddict.Dict) string {
+	result, err := tool.Call(ctx, scope, args)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+
+	name := tool.Spec().Function.Name
+	reduced, err := self.reducer.Reduce(ctx, name, result)
+	if err != nil {
+		scope.Log("ollama_agent: reducing result of %v: %v", name, err)
+		return self.reducer.truncate(strings.Split(result, "\n"))
+	}
+	return reduced
+}
+
+// Used by the summarize strategy. Summaries count against the budget
+// like any other model call.
+func (self *agentRun) summarizeResult(ctx context.Context,
+	tool, chunk string) (string, error) {
+	req := &GenerateRequest{
+		Model: self.arg.Model,
+		Prompt: fmt.Sprintf("The following is part of the output of the %v "+
+			"tool, called while working on this task:\n%v\n\n"+
+			"Summarize the output, keeping all details relevant to the task.\n\n%v",
+			tool, self.arg.Prompt, chunk),
+		Options:   self.arg.Options,
+		KeepAlive: self.arg.KeepAlive,
+	}
+
+	result := &strings.Builder{}
+	var stats *Stats
+	err := self.client.Generate(ctx, req, func(resp *GenerateResponse) error {
+		result.WriteString(resp.Response)
+		if resp.Done {
+			stats = &resp.Stats
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	self.budget.AddModelCall(stats)
+	return result.String(), nil
+}
+
+func (self OllamaAgentPlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name: "ollama_agent",
+		Doc: "Run an agent loop where the model may call VQL or MCP tools. Tools " +
+			"above the approval level wait for agent_approve().",
+		ArgType: type_map.AddType(scope, &OllamaAgentPluginArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().
+			Permissions(acls.COLLECT_SERVER, acls.EXECVE).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterPlugin(&OllamaAgentPlugin{})
+}
@@ -0,0 +1,343 @@
+package ollama
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/velociraptor/vql/acl_managers"
+	"www.velocidex.com/golang/velociraptor/vtesting/assert"
+	"www.velocidex.com/golang/vfilter"
+)
+
+var (
+	approvalArtifact = `
+name: Server.Internal.AgentApprovals
+type: SERVER_EVENT
+`
+)
+
+func (self *OllamaTestSuite) TestCheckpoint() {
+	query := `
+LET _SessionId = %q
+SELECT * FROM ollama(model="llama3", prompt="Long task",
+   checkpoint=%q, cache_bypass=TRUE, base_url=URL)`
+
+	rows := self.run(fmt.Sprintf(query, "F.1234", "summary"))
+	assert.Equal(self.T(), 1, len(rows))
+
+	// A completed response is reused by a later collection without
+	// calling the model.
+	rows = self.run(fmt.Sprintf(query, "F.1235", "summary"))
+	assert.Equal(self.T(), 1, len(rows))
+	assert.Equal(self.T(), 1, len(self.requests))
+
+	response, _ := rows[0].GetString("Response")
+	assert.Equal(self.T(), "Hello world", response)
+
+	// A partial response is continued.
+	manager, err := services.GetRepositoryManager(self.ConfigObj)
+	assert.NoError(self.T(), err)
+
+	scope := manager.BuildScope(services.ScopeBuilder{
+		Config:     self.ConfigObj,
+		ACLManager: acl_managers.NullACLManager{},
+	})
+	defer scope.Close()
+
+	checkpoint, err := OpenCheckpoint(scope, "partial", &GenerateRequest{
+		Model: "llama3", Prompt: "Long task", Stream: true}, 0)
+	assert.NoError(self.T(), err)
+	assert.NoError(self.T(), checkpoint.save(&checkpointState{Response: "Partial"}))
+
+	self.chat_responses = []string{fmt.Sprintf(finalResponse, " response")}
+	rows = self.run(fmt.Sprintf(query, "F.1236", "partial"))
+	assert.Equal(self.T(), 1, len(rows))
+
+	response, _ = rows[0].GetString("Response")
+	assert.Equal(self.T(), "Partial response", response)
+
+	messages := self.chat_requests[0].Messages
+	assert.Equal(self.T(), "assistant", messages[1].Role)
+	assert.Equal(self.T(), "Partial", messages[1].Content)
+
+	// Other users do not see the checkpoints.
+	err = services.GrantRoles(self.ConfigObj, "analyst",
+		[]string{"administrator"})
+	assert.NoError(self.T(), err)
+
+	rows = self.runAs("analyst", fmt.Sprintf(query, "F.1237", "summary"))
+	assert.Equal(self.T(), 1, len(rows))
+	assert.Equal(self.T(), 2, len(self.requests))
+}
+
+func (self *OllamaTestSuite) TestAgent() {
+	self.chat_responses = []string{
+		fmt.Sprintf(toolCallResponse, "lookup", 42),
+		fmt.Sprintf(finalResponse, "Process 42 is fine"),
+	}
+
+	rows := self.run(`
+LET Tools = (dict(name="lookup", description="Lookup a process",
+   parameters=dict(type="object",
+                   properties=dict(pid=dict(type="integer"))),
+   query="SELECT pid * 2 AS Doubled FROM scope()"),)
+
+SELECT * FROM ollama_agent(model="llama3", prompt="Check process 42",
+   tools=Tools, base_url=URL)`)
+
+	// Tool call, response and accounting rows.
+	assert.Equal(self.T(), 3, len(rows))
+
+	// The tool ran with the model's arguments as scope variables.
+	result, _ := rows[0].GetString("Result")
+	assert.Equal(self.T(), "{\"Doubled\":84}\n", result)
+
+	content, _ := rows[1].GetString("Content")
+	assert.Equal(self.T(), "Process 42 is fine", content)
+
+	// The tool result was sent back to the model.
+	assert.Equal(self.T(), 2, len(self.chat_requests))
+	messages := self.chat_requests[1].Messages
+	assert.Equal(self.T(), 3, len(messages))
+	assert.Equal(self.T(), "tool", messages[2].Role)
+	assert.Equal(self.T(), "lookup", messages[2].ToolName)
+}
+
+func (self *OllamaTestSuite) TestAgentApproval() {
+	self.LoadArtifacts(approvalArtifact)
+
+	journal, err := services.GetJournal(self.ConfigObj)
+	assert.NoError(self.T(), err)
+
+	err = services.GrantRoles(self.ConfigObj, "reviewer", []string{"administrator"})
+	assert.NoError(self.T(), err)
+
+	err = services.GrantRoles(self.ConfigObj, "analyst", []string{"investigator"})
+	assert.NoError(self.T(), err)
+
+	// Deny the first request and approve the second.
+	events, cancel := journal.Watch(self.Ctx, APPROVAL_ARTIFACT, "test")
+	defer cancel()
+
+	refused := make(chan vfilter.Any, 2)
+	pending := make(chan string, 2)
+	go func() {
+		count := 0
+		for event := range events {
+			action, _ := event.GetString("Action")
+			if action != APPROVAL_REQUEST {
+				continue
+			}
+			id, _ := event.GetString("ApprovalId")
+
+			// The request is stored so any frontend can decide it.
+			stored, err := getPendingApproval(self.ConfigObj, id)
+			if err == nil {
+				pending <- id + " " + stored.Tool
+			} else {
+				pending <- id + " " + err.Error()
+			}
+			deny := "FALSE"
+			count++
+			if count == 1 {
+				deny = "TRUE"
+
+				// Neither the user who started the agent nor a
+				// user without SERVER_ADMIN may approve.
+				query := fmt.Sprintf(
+					`SELECT agent_approve(id=%q) AS Decision FROM scope()`, id)
+				for _, rows := range [][]*ordereddict.Dict{
+					self.run(query), self.runAs("analyst", query)} {
+					decision, _ := rows[0].Get("Decision")
+					refused <- decision
+				}
+			}
+			self.runAs("reviewer", fmt.Sprintf(
+				`SELECT agent_approve(id=%q, deny=%v, reason="test") FROM scope()`,
+				id, deny))
+		}
+	}()
+
+	self.chat_responses = []string{
+		fmt.Sprintf(toolCallResponse, "kill", 1),
+		fmt.Sprintf(toolCallResponse, "kill", 2),
+		fmt.Sprintf(finalResponse, "Done"),
+	}
+
+	rows := self.run(`
+LET Tools = (dict(name="kill", risk="high",
+   query="SELECT 'killed ' + str(str=pid) AS Result FROM scope()"),)
+
+SELECT * FROM ollama_agent(model="llama3", prompt="Kill processes",
+   tools=Tools, base_url=URL)`)
+
+	assert.Equal(self.T(), 4, len(rows))
+
+	approved, _ := rows[0].Get("Approved")
+	assert.Equal(self.T(), false, approved)
+	result, _ := rows[0].GetString("Result")
+	assert.Contains(self.T(), result, "not approved")
+
+	approved, _ = rows[1].Get("Approved")
+	assert.Equal(self.T(), true, approved)
+	result, _ = rows[1].GetString("Result")
+	assert.Contains(self.T(), result, "killed 2")
+
+	for i := 0; i < 2; i++ {
+		assert.True(self.T(), utils.IsNil(<-refused))
+	}
+
+	// Decided requests are no longer stored.
+	for i := 0; i < 2; i++ {
+		id, stored, _ := strings.Cut(<-pending, " ")
+		assert.Equal(self.T(), "kill", stored)
+
+		_, err = getPendingApproval(self.ConfigObj, id)
+		assert.Error(self.T(), err)
+	}
+
+	// Requests which are no longer pending can not be decided.
+	rows = self.runAs("reviewer",
+		`SELECT agent_approve(id="A.unknown") AS Decision FROM scope()`)
+	decision, _ := rows[0].Get("Decision")
+	assert.True(self.T(), utils.IsNil(decision))
+}
+
+func (self *OllamaTestSuite) TestAgentBudget() {
+	self.chat_responses = []string{
+		fmt.Sprintf(toolCallResponse, "lookup", 1),
+		fmt.Sprintf(toolCallResponse, "lookup", 2),
+		fmt.Sprintf(finalResponse, "Done"),
+	}
+
+	rows := self.run(`
+LET Tools = (dict(name="lookup", query="SELECT pid FROM scope()"),)
+
+SELECT * FROM ollama_agent(model="llama3", prompt="Check",
+   tools=Tools, max_calls=1, base_url=URL)`)
+
+	// The second tool call is refused and the run stops.
+	assert.Equal(self.T(), 2, len(rows))
+
+	row_type, _ := rows[1].GetString("Type")
+	assert.Equal(self.T(), "Accounting", row_type)
+
+	tool_calls, _ := rows[1].GetInt64("ToolCalls")
+	assert.Equal(self.T(), int64(1), tool_calls)
+
+	exceeded, _ := rows[1].GetString("BudgetExceeded")
+	assert.Equal(self.T(), "max_calls (1) reached", exceeded)
+}
+
+// Just enough of an MCP server to list and call a single tool.
+func (self *OllamaTestSuite) TestAgentInvestigation() {
+	self.chat_responses = []string{
+		`{"message":{"role":"assistant","content":"","tool_calls":[` +
+			`{"function":{"name":"update_plan","arguments":{"text":"Check pslist"}}}]},"done":true}`,
+		fmt.Sprintf(finalResponse, "Nothing found"),
+		fmt.Sprintf(finalResponse, "Still nothing"),
+	}
+
+	self.run(`
+SELECT * FROM ollama_agent(model="llama3", prompt="Investigate",
+   investigation="N.1", base_url=URL)`)
+
+	rows := self.run(`SELECT * FROM agent_scratchpad(investigation="N.1")`)
+	assert.Equal(self.T(), 1, len(rows))
+
+	content, _ := rows[0].GetString("Content")
+	assert.Equal(self.T(), "Check pslist", content)
+
+	// A second run resumes the conversation with a follow up prompt.
+	self.run(`
+SELECT * FROM ollama_agent(model="llama3", prompt="Look again",
+   investigation="N.1", base_url=URL)`)
+
+	messages := self.chat_requests[2].Messages
+	assert.Equal(self.T(), 5, len(messages))
+	assert.Equal(self.T(), "Nothing found", messages[3].Content)
+	assert.Equal(self.T(), "Look again", messages[4].Content)
+
+	// Other users do not see the investigation.
+	err := services.GrantRoles(self.ConfigObj, "analyst",
+		[]string{"investigator"})
+	assert.NoError(self.T(), err)
+
+	rows = self.runAs("analyst",
+		`SELECT * FROM agent_scratchpad(investigation="N.1")`)
+	assert.Equal(self.T(), 0, len(rows))
+}
+
+func TestTrimIncompleteStep(t *testing.T) {
+	messages := []*Message{
+		{Role: "user", Content: "Investigate"},
+		{Role: "assistant", ToolCalls: []*ToolCall{{}, {}}},
+		{Role: "tool", Content: "Result"},
+	}
+
+	// The second tool result was never recorded.
+	assert.Equal(t, messages[:1], trimIncompleteStep(messages))
+
+	messages = append(messages, &Message{Role: "tool", Content: "Result"})
+	assert.Equal(t, messages, trimIncompleteStep(messages))
+}
+
+func newMCPServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			req := ordereddict.NewDict()
+			body, _ := io.ReadAll(r.Body)
+			_ = json.Unmarshal(body, req)
+
+			method, _ := req.GetString("method")
+			id, _ := req.Get("id")
+
+			var result string
+			switch method {
+			case "initialize":
+				result = `{"protocolVersion":"2025-03-26","serverInfo":{"name":"test"}}`
+			case "tools/list":
+				result = `{"tools":[{"name":"reputation","description":"IP reputation"}]}`
+			case "tools/call":
+				result = `{"content":[{"type":"text","text":"malicious"}]}`
+			default:
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%v,"result":%s}`,
+				json.MustMarshalString(id), result)
+		}))
+}
+
+func (self *OllamaTestSuite) TestAgentMCPTools() {
+	mcp_server := newMCPServer()
+	defer mcp_server.Close()
+
+	self.chat_responses = []string{
+		fmt.Sprintf(toolCallResponse, "reputation", 1),
+		fmt.Sprintf(finalResponse, "The IP is malicious"),
+	}
+
+	rows := self.run(fmt.Sprintf(`
+SELECT * FROM ollama_agent(model="llama3", prompt="Check the IP",
+   mcp_servers=(dict(url=%q),), base_url=URL)`, mcp_server.URL))
+
+	assert.Equal(self.T(), 3, len(rows))
+
+	// The MCP tool was offered to the model and called.
+	assert.Equal(self.T(), "reputation",
+		self.chat_requests[0].Tools[0].Function.Name)
+
+	result, _ := rows[0].GetString("Result")
+	assert.Equal(self.T(), "malicious", result)
+}
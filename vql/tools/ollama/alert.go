@@ -0,0 +1,224 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/logging"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	DEFAULT_ALERT_NAME     = "AI Verdict"
+	DEFAULT_ALERT_SEVERITY = "high"
+	DEFAULT_ALERT_DEDUP    = 7200
+)
+
+// Severities from least to most severe.
+var alert_severities = []string{"info", "low", "medium", "high", "critical"}
+
+// The severity of verdicts when no severity is given.
+var verdict_severities = map[string]string{
+	"benign":     "info",
+	"clean":      "info",
+	"unknown":    "low",
+	"suspicious": "medium",
+	"malicious":  "high",
+}
+
+// Alerts are deduplicated across queries within an org so a finding
+// reported by every run of a scheduled artifact is only raised once.
+var (
+	alert_dedup_mu sync.Mutex
+
+	// Key to the time the alert may be raised again.
+	alert_dedup = make(map[string]time.Time)
+)
+
+type OllamaAlertFunctionArgs struct {
+	Name        string      `vfilter:"optional,field=name,doc=The name of the alert (default AI Verdict)."`
+	Verdict     string      `vfilter:"optional,field=verdict,doc=The model's verdict (e.g. malicious, suspicious or benign)."`
+	Severity    string      `vfilter:"optional,field=severity,doc=The severity: info, low, medium, high or critical (default from the verdict)."`
+	MinSeverity string      `vfilter:"optional,field=min_severity,doc=Only alert at or above this severity (default high)."`
+	Summary     string      `vfilter:"optional,field=summary,doc=The summary shown in the alert."`
+	Model       string      `vfilter:"optional,field=model,doc=The model which made the verdict."`
+	ClientId    string      `vfilter:"optional,field=client_id,doc=The client the finding is about."`
+	FlowId      string      `vfilter:"optional,field=flow_id,doc=The flow the finding came from."`
+	Details     vfilter.Any `vfilter:"optional,field=details,doc=Any other details to include (e.g. the parsed response)."`
+	DedupKey    string      `vfilter:"optional,field=dedup_key,doc=Alerts with the same name and key are only raised once within the dedup time (default the client, flow and summary)."`
+	DedupTime   int64       `vfilter:"optional,field=dedup,doc=Suppress the same alert for this many seconds (default 7200, -1 to disable)."`
+}
+
+// Raises a server alert from an AI verdict so it is routed to
+// Server.Internal.Alerts and shown in the GUI like alerts from
+// alert(). Returns the alert, or NULL if it was below the minimum
+// severity or suppressed as a duplicate.
+type OllamaAlertFunction struct{}
+
+func (self OllamaAlertFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("ollama_alert", args)()
+
+	err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+	if err != nil {
+		scope.Log("ollama_alert: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg := &OllamaAlertFunctionArgs{}
+	err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("ollama_alert: %v", err)
+		return vfilter.Null{}
+	}
+
+	if arg.Name == "" {
+		arg.Name = DEFAULT_ALERT_NAME
+	}
+
+	if arg.MinSeverity == "" {
+		arg.MinSeverity = DEFAULT_ALERT_SEVERITY
+	}
+
+	if arg.DedupTime == 0 {
+		arg.DedupTime = DEFAULT_ALERT_DEDUP
+	}
+
+	severity := strings.ToLower(arg.Severity)
+	if severity == "" {
+		severity = verdict_severities[strings.ToLower(arg.Verdict)]
+		if severity == "" {
+			scope.Log("ollama_alert: No severity given for verdict %q",
+				arg.Verdict)
+			return vfilter.Null{}
+		}
+	}
+
+	rank, err := severityRank(severity)
+	if err != nil {
+		scope.Log("ollama_alert: severity: %v", err)
+		return vfilter.Null{}
+	}
+
+	min_rank, err := severityRank(arg.MinSeverity)
+	if err != nil {
+		scope.Log("ollama_alert: min_severity: %v", err)
+		return vfilter.Null{}
+	}
+
+	if rank < min_rank {
+		return vfilter.Null{}
+	}
+
+	if arg.DedupKey == "" {
+		arg.DedupKey = strings.Join(
+			[]string{arg.ClientId, arg.FlowId, arg.Summary}, "\x00")
+	}
+
+	config_obj, _ := vql_subsystem.GetServerConfig(scope)
+	org_id := ""
+	if config_obj != nil {
+		org_id = config_obj.OrgId
+	}
+
+	if arg.DedupTime > 0 && alertSeen(
+		org_id+"\x00"+arg.Name+"\x00"+arg.DedupKey,
+		time.Duration(arg.DedupTime)*time.Second) {
+		return vfilter.Null{}
+	}
+
+	var details vfilter.Any = vfilter.Null{}
+	if !utils.IsNil(arg.Details) {
+		details = normalizeNested(ctx, scope, arg.Details, 0)
+	}
+
+	event_data := ordereddict.NewDict().
+		Set("Severity", severity).
+		Set("Verdict", arg.Verdict).
+		Set("Summary", arg.Summary).
+		Set("Model", arg.Model).
+		Set("ClientId", arg.ClientId).
+		Set("FlowId", arg.FlowId).
+		Set("DedupKey", arg.DedupKey).
+		Set("Details", details)
+
+	alert := services.AlertMessage{
+		Timestamp: utils.GetTime().Now(),
+		AlertName: arg.Name,
+		EventData: event_data,
+		FlowId:    arg.FlowId,
+	}
+
+	serialized, err := json.Marshal(alert)
+	if err != nil {
+		scope.Log("ollama_alert: %v", err)
+		return vfilter.Null{}
+	}
+
+	// Alerts are routed by the log level, the same as alert().
+	scope.Log("%v", logging.ALERT+":"+string(serialized))
+
+	return ordereddict.NewDict().
+		Set("Name", arg.Name).
+		Set("Timestamp", alert.Timestamp.UTC()).
+		Set("EventData", event_data)
+}
+
+func severityRank(severity string) (int, error) {
+	severity = strings.ToLower(severity)
+	for idx, item := range alert_severities {
+		if item == severity {
+			return idx, nil
+		}
+	}
+	return 0, fmt.Errorf("should be one of %v not %q",
+		strings.Join(alert_severities, ", "), severity)
+}
+
+// Returns true if the alert was raised within the dedup time,
+// otherwise records it as raised now.
+func alertSeen(key string, dedup time.Duration) bool {
+	alert_dedup_mu.Lock()
+	defer alert_dedup_mu.Unlock()
+
+	now := utils.GetTime().Now()
+	expires, pres := alert_dedup[key]
+	if pres && now.Before(expires) {
+		return true
+	}
+
+	// Drop expired entries so the map does not grow forever.
+	for k, v := range alert_dedup {
+		if !now.Before(v) {
+			delete(alert_dedup, k)
+		}
+	}
+
+	alert_dedup[key] = now.Add(dedup)
+	return false
+}
+
+func (self OllamaAlertFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:     "ollama_alert",
+		Doc:      "Raise a server alert with a severity and dedup key from an AI verdict.",
+		ArgType:  type_map.AddType(scope, &OllamaAlertFunctionArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&OllamaAlertFunction{})
+}
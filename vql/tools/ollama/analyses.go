@@ -0,0 +1,225 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/file_store"
+	"www.velocidex.com/golang/velociraptor/file_store/api"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/paths"
+	"www.velocidex.com/golang/velociraptor/result_sets"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+type SaveAnalysisFunctionArgs struct {
+	ClientId string      `vfilter:"optional,field=client_id,doc=The client the flow was collected from (default server)."`
+	FlowId   string      `vfilter:"optional,field=flow_id,doc=The flow to attach the analysis to."`
+	HuntId   string      `vfilter:"optional,field=hunt_id,doc=The hunt to attach the analysis to."`
+	Model    string      `vfilter:"optional,field=model,doc=The model which made the analysis."`
+	Verdict  string      `vfilter:"optional,field=verdict,doc=The verdict (e.g. malicious, suspicious or benign)."`
+	Summary  string      `vfilter:"required,field=summary,doc=The summary of the analysis."`
+	Details  vfilter.Any `vfilter:"optional,field=details,doc=Any other details to keep (e.g. the parsed response)."`
+}
+
+// Attaches an AI analysis to a flow or hunt. Analyses are stored in
+// the collection so they are exported and deleted with it.
+type SaveAnalysisFunction struct{}
+
+func (self SaveAnalysisFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("ollama_save_analysis", args)()
+
+	err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+	if err != nil {
+		scope.Log("ollama_save_analysis: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg := &SaveAnalysisFunctionArgs{}
+	err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("ollama_save_analysis: %v", err)
+		return vfilter.Null{}
+	}
+
+	config_obj, ok := vql_subsystem.GetServerConfig(scope)
+	if !ok {
+		scope.Log("ollama_save_analysis: Command can only run on the server")
+		return vfilter.Null{}
+	}
+
+	path, err := analysesPath(ctx, config_obj,
+		arg.ClientId, arg.FlowId, arg.HuntId)
+	if err != nil {
+		scope.Log("ollama_save_analysis: %v", err)
+		return vfilter.Null{}
+	}
+
+	var details vfilter.Any = vfilter.Null{}
+	if !utils.IsNil(arg.Details) {
+		details = normalizeNested(ctx, scope, arg.Details, 0)
+	}
+
+	row := ordereddict.NewDict().
+		Set("Timestamp", utils.GetTime().Now().UTC()).
+		Set("Principal", vql_subsystem.GetPrincipal(scope)).
+		Set("Model", arg.Model).
+		Set("Verdict", arg.Verdict).
+		Set("Summary", arg.Summary).
+		Set("Details", details)
+
+	rs_writer, err := result_sets.NewResultSetWriter(
+		file_store.GetFileStore(config_obj), path,
+		json.DefaultEncOpts(), utils.SyncCompleter, result_sets.AppendMode)
+	if err != nil {
+		scope.Log("ollama_save_analysis: %v", err)
+		return vfilter.Null{}
+	}
+	rs_writer.Write(row)
+	rs_writer.Close()
+
+	return row
+}
+
+func (self SaveAnalysisFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:     "ollama_save_analysis",
+		Doc:      "Attach an AI summary or verdict to a flow or hunt so it is kept and exported with the collection.",
+		ArgType:  type_map.AddType(scope, &SaveAnalysisFunctionArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+type AnalysesPluginArgs struct {
+	ClientId string `vfilter:"optional,field=client_id,doc=The client the flow was collected from (default server)."`
+	FlowId   string `vfilter:"optional,field=flow_id,doc=The flow to show the analyses of."`
+	HuntId   string `vfilter:"optional,field=hunt_id,doc=The hunt to show the analyses of."`
+}
+
+// Shows the analyses attached to a flow or hunt, for example in the
+// collection's notebook.
+type AnalysesPlugin struct{}
+
+func (self AnalysesPlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("ollama_analyses", args)()
+
+		err := vql_subsystem.CheckAccess(scope, acls.READ_RESULTS)
+		if err != nil {
+			scope.Log("ollama_analyses: %v", err)
+			return
+		}
+
+		arg := &AnalysesPluginArgs{}
+		err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+		if err != nil {
+			scope.Log("ollama_analyses: %v", err)
+			return
+		}
+
+		config_obj, ok := vql_subsystem.GetServerConfig(scope)
+		if !ok {
+			scope.Log("ollama_analyses: Command can only run on the server")
+			return
+		}
+
+		path, err := analysesPath(ctx, config_obj,
+			arg.ClientId, arg.FlowId, arg.HuntId)
+		if err != nil {
+			scope.Log("ollama_analyses: %v", err)
+			return
+		}
+
+		reader, err := result_sets.NewResultSetReader(
+			file_store.GetFileStore(config_obj), path)
+		if err != nil {
+			// No analyses were saved.
+			return
+		}
+		defer reader.Close()
+
+		for row := range reader.Rows(ctx) {
+			select {
+			case <-ctx.Done():
+				return
+			case output_chan <- row:
+			}
+		}
+	}()
+
+	return output_chan
+}
+
+func (self AnalysesPlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name:     "ollama_analyses",
+		Doc:      "Show the AI analyses attached to a flow or hunt with ollama_save_analysis().",
+		ArgType:  type_map.AddType(scope, &AnalysesPluginArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.READ_RESULTS).Build(),
+	}
+}
+
+// Where the analyses of the flow or hunt are stored. The flow or
+// hunt must exist.
+func analysesPath(ctx context.Context, config_obj *config_proto.Config,
+	client_id, flow_id, hunt_id string) (api.FSPathSpec, error) {
+	switch {
+	case flow_id != "" && hunt_id != "":
+		return nil, errors.New("Only one of flow_id or hunt_id may be given")
+
+	case flow_id != "":
+		if client_id == "" {
+			client_id = "server"
+		}
+
+		launcher, err := services.GetLauncher(config_obj)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = launcher.GetFlowDetails(ctx, config_obj,
+			services.GetFlowOptions{}, client_id, flow_id)
+		if err != nil {
+			return nil, fmt.Errorf("Flow %v not found for %v: %w",
+				flow_id, client_id, err)
+		}
+		return paths.NewFlowPathManager(client_id, flow_id).LLMAnalyses(), nil
+
+	case hunt_id != "":
+		hunt_dispatcher, err := services.GetHuntDispatcher(config_obj)
+		if err != nil {
+			return nil, err
+		}
+
+		_, pres := hunt_dispatcher.GetHunt(ctx, hunt_id)
+		if !pres {
+			return nil, fmt.Errorf("Hunt %v not found", hunt_id)
+		}
+		return paths.NewHuntPathManager(hunt_id).LLMAnalyses(), nil
+	}
+
+	return nil, errors.New("One of flow_id or hunt_id must be given")
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&SaveAnalysisFunction{})
+	vql_subsystem.RegisterPlugin(&AnalysesPlugin{})
+}
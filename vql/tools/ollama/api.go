@@ -0,0 +1,209 @@
+/*
+  Plugins for querying large language models served by Ollama.
+
+  See https://github.com/ollama/ollama/blob/main/docs/api.md
+*/
+
+package ollama
+
+import (
+	"github.com/Velocidex/ordereddict"
+)
+
+const (
+	DEFAULT_BASE_URL = "http://localhost:11434"
+)
+
+type Message struct {
+	Role      string      `json:"role"`
+	Content   string      `json:"content"`
+	Images    []string    `json:"images,omitempty"`
+	ToolCalls []*ToolCall `json:"tool_calls,omitempty"`
+
+	// Set on role=tool messages to identify the tool which produced
+	// the content.
+	ToolName string `json:"tool_name,omitempty"`
+}
+
+// Describes a tool the model may call.
+type ToolSpec struct {
+	Type     string        `json:"type"`
+	Function *ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Parameters  *ordereddict.Dict `json:"parameters,omitempty"`
+}
+
+type ToolCall struct {
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string            `json:"name"`
+	Arguments *ordereddict.Dict `json:"arguments"`
+}
+
+type GenerateRequest struct {
+	Model     string            `json:"model"`
+	Prompt    string            `json:"prompt"`
+	System    string            `json:"system,omitempty"`
+	Format    interface{}       `json:"format,omitempty"`
+	Options   *ordereddict.Dict `json:"options,omitempty"`
+	Context   []int64           `json:"context,omitempty"`
+	Images    []string          `json:"images,omitempty"`
+	Stream    bool              `json:"stream"`
+	KeepAlive string            `json:"keep_alive,omitempty"`
+	Logprobs  bool              `json:"logprobs,omitempty"`
+}
+
+// Timing and token statistics returned with the final response.
+type Stats struct {
+	TotalDuration      int64 `json:"total_duration,omitempty"`
+	LoadDuration       int64 `json:"load_duration,omitempty"`
+	PromptEvalCount    int64 `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration int64 `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int64 `json:"eval_count,omitempty"`
+	EvalDuration       int64 `json:"eval_duration,omitempty"`
+
+	// The estimated cost of the call when AI.pricing has a price for
+	// the model.
+	Cost *float64 `json:"-"`
+}
+
+// Add the statistics to an output row. Durations are in seconds.
+func (self *Stats) SetColumns(row *ordereddict.Dict) {
+	row.Set("PromptEvalCount", self.PromptEvalCount).
+		Set("EvalCount", self.EvalCount).
+		Set("TotalDuration", float64(self.TotalDuration)/1e9).
+		Set("LoadDuration", float64(self.LoadDuration)/1e9)
+
+	if self.Cost != nil {
+		row.Set("Cost", *self.Cost)
+	}
+}
+
+// When streaming, each chunk carries a fragment of the response and
+// the final chunk (Done = true) carries the context and statistics.
+type GenerateResponse struct {
+	Model      string  `json:"model"`
+	CreatedAt  string  `json:"created_at"`
+	Response   string  `json:"response"`
+	Done       bool    `json:"done"`
+	DoneReason string  `json:"done_reason,omitempty"`
+	Context    []int64 `json:"context,omitempty"`
+	Error      string  `json:"error,omitempty"`
+
+	// Only returned when the request asks for logprobs and the
+	// server supports them.
+	Logprobs []*TokenLogprob `json:"logprobs,omitempty"`
+
+	Stats
+}
+
+// The log probability of a generated token.
+type TokenLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+}
+
+type ChatRequest struct {
+	Model     string            `json:"model"`
+	Messages  []*Message        `json:"messages"`
+	Tools     []*ToolSpec       `json:"tools,omitempty"`
+	Format    interface{}       `json:"format,omitempty"`
+	Options   *ordereddict.Dict `json:"options,omitempty"`
+	Stream    bool              `json:"stream"`
+	KeepAlive string            `json:"keep_alive,omitempty"`
+}
+
+type ChatResponse struct {
+	Model      string   `json:"model"`
+	CreatedAt  string   `json:"created_at"`
+	Message    *Message `json:"message"`
+	Done       bool     `json:"done"`
+	DoneReason string   `json:"done_reason,omitempty"`
+	Error      string   `json:"error,omitempty"`
+
+	Stats
+}
+
+type ShowRequest struct {
+	Model string `json:"model"`
+}
+
+type ShowResponse struct {
+	Modelfile  string            `json:"modelfile,omitempty"`
+	Parameters string            `json:"parameters,omitempty"`
+	Template   string            `json:"template,omitempty"`
+	Details    *ordereddict.Dict `json:"details,omitempty"`
+	ModelInfo  *ordereddict.Dict `json:"model_info,omitempty"`
+
+	// What the model can do (e.g. completion, vision, tools).
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// Input may hold many strings which are embedded in one call.
+type EmbedRequest struct {
+	Model     string            `json:"model"`
+	Input     []string          `json:"input"`
+	Truncate  *bool             `json:"truncate,omitempty"`
+	Options   *ordereddict.Dict `json:"options,omitempty"`
+	KeepAlive string            `json:"keep_alive,omitempty"`
+}
+
+type EmbedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float64 `json:"embeddings"`
+	TotalDuration   int64       `json:"total_duration,omitempty"`
+	LoadDuration    int64       `json:"load_duration,omitempty"`
+	PromptEvalCount int64       `json:"prompt_eval_count,omitempty"`
+}
+
+type ListResponse struct {
+	Models []*ModelSummary `json:"models"`
+}
+
+// A locally available model. The digest identifies the exact weights.
+type ModelSummary struct {
+	Name       string            `json:"name"`
+	Model      string            `json:"model"`
+	ModifiedAt string            `json:"modified_at,omitempty"`
+	Size       int64             `json:"size,omitempty"`
+	Digest     string            `json:"digest"`
+	Details    *ordereddict.Dict `json:"details,omitempty"`
+}
+
+type PsResponse struct {
+	Models []*RunningModel `json:"models"`
+}
+
+// A model loaded in memory, and when it will be unloaded.
+type RunningModel struct {
+	ModelSummary
+	ExpiresAt string `json:"expires_at,omitempty"`
+	SizeVram  int64  `json:"size_vram,omitempty"`
+}
+
+type VersionResponse struct {
+	Version string `json:"version"`
+}
+
+// Creates a model from blobs already pushed to the server. Files
+// maps the file names to their blob digests (sha256:...).
+type CreateRequest struct {
+	Model      string            `json:"model"`
+	Files      map[string]string `json:"files,omitempty"`
+	Template   string            `json:"template,omitempty"`
+	System     string            `json:"system,omitempty"`
+	Parameters *ordereddict.Dict `json:"parameters,omitempty"`
+	Stream     bool              `json:"stream"`
+}
+
+// Progress of a long running request such as a create.
+type ProgressResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
@@ -0,0 +1,320 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/file_store"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/paths"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	// Approval requests and decisions are published on this queue
+	// so they are visible in the GUI.
+	APPROVAL_ARTIFACT = "Server.Internal.AgentApprovals"
+
+	APPROVAL_REQUEST  = "Request"
+	APPROVAL_APPROVED = "Approved"
+	APPROVAL_DENIED   = "Denied"
+)
+
+// An approval request still waiting for a decision. Pending requests
+// are kept in the file store so agent_approve() finds them on any
+// frontend.
+type pendingApproval struct {
+	// The principal who started the agent run.
+	Principal string `json:"principal"`
+	Tool      string `json:"tool"`
+}
+
+func setPendingApproval(config_obj *config_proto.Config,
+	id string, pending *pendingApproval) error {
+	serialized, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+
+	writer, err := file_store.GetFileStore(config_obj).WriteFileWithCompletion(
+		paths.LLMPathManager{}.PendingApproval(id), utils.SyncCompleter)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	err = writer.Truncate()
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(serialized)
+	return err
+}
+
+func getPendingApproval(config_obj *config_proto.Config,
+	id string) (*pendingApproval, error) {
+	reader, err := file_store.GetFileStore(config_obj).ReadFile(
+		paths.LLMPathManager{}.PendingApproval(id))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	serialized, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &pendingApproval{}
+	err = json.Unmarshal(serialized, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func deletePendingApproval(config_obj *config_proto.Config, id string) error {
+	return file_store.GetFileStore(config_obj).Delete(
+		paths.LLMPathManager{}.PendingApproval(id))
+}
+
+type RiskLevel int
+
+const (
+	RISK_LOW RiskLevel = iota + 1
+	RISK_MEDIUM
+	RISK_HIGH
+
+	// An approval threshold that no tool reaches.
+	RISK_NEVER
+)
+
+func (self RiskLevel) String() string {
+	switch self {
+	case RISK_LOW:
+		return "low"
+	case RISK_MEDIUM:
+		return "medium"
+	case RISK_HIGH:
+		return "high"
+	case RISK_NEVER:
+		return "none"
+	}
+	return fmt.Sprintf("RiskLevel(%d)", int(self))
+}
+
+func parseRiskLevel(level string, default_level RiskLevel) (RiskLevel, error) {
+	switch strings.ToLower(level) {
+	case "":
+		return default_level, nil
+	case "low":
+		return RISK_LOW, nil
+	case "medium":
+		return RISK_MEDIUM, nil
+	case "high":
+		return RISK_HIGH, nil
+	}
+	return 0, fmt.Errorf("invalid risk level %v", level)
+}
+
+// The approval threshold may also be "none" to disable approvals.
+func parseApprovalLevel(level string) (RiskLevel, error) {
+	if strings.ToLower(level) == "none" {
+		return RISK_NEVER, nil
+	}
+	return parseRiskLevel(level, RISK_HIGH)
+}
+
+// Publish an approval request and block until an authorized user
+// approves or denies it with agent_approve().
+func requestApproval(ctx context.Context, scope vfilter.Scope,
+	tool string, args *ordereddict.Dict, risk RiskLevel,
+	timeout time.Duration) (approved bool, reason string, err error) {
+	config_obj, ok := vql_subsystem.GetServerConfig(scope)
+	if !ok {
+		return false, "", errors.New("Approvals are only available on the server")
+	}
+
+	journal, err := services.GetJournal(config_obj)
+	if err != nil {
+		return false, "", err
+	}
+
+	id := "A." + utils.NextId()
+	principal := vql_subsystem.GetPrincipal(scope)
+
+	err = setPendingApproval(config_obj, id, &pendingApproval{
+		Principal: principal,
+		Tool:      tool,
+	})
+	if err != nil {
+		return false, "", err
+	}
+
+	defer func() {
+		err := deletePendingApproval(config_obj, id)
+		if err != nil {
+			scope.Log("ollama_agent: %v", err)
+		}
+	}()
+
+	// Start watching before publishing the request so we can not
+	// miss a quick decision.
+	sub_ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	events, closer := journal.Watch(sub_ctx, APPROVAL_ARTIFACT, "ollama_agent "+id)
+	defer closer()
+
+	err = journal.PushRowsToArtifact(ctx, config_obj,
+		[]*ordereddict.Dict{ordereddict.NewDict().
+			Set("ApprovalId", id).
+			Set("Action", APPROVAL_REQUEST).
+			Set("Principal", principal).
+			Set("Tool", tool).
+			Set("Arguments", args).
+			Set("Risk", risk.String())},
+		APPROVAL_ARTIFACT, "server", "")
+	if err != nil {
+		return false, "", err
+	}
+
+	scope.Log("ollama_agent: Waiting for approval %v to call %v", id, tool)
+
+	for {
+		select {
+		case <-sub_ctx.Done():
+			if ctx.Err() != nil {
+				return false, "", ctx.Err()
+			}
+			return false, "Approval timed out", nil
+
+		case event, ok := <-events:
+			if !ok {
+				return false, "Approval timed out", nil
+			}
+
+			event_id, _ := event.GetString("ApprovalId")
+			if event_id != id {
+				continue
+			}
+
+			action, _ := event.GetString("Action")
+			event_reason, _ := event.GetString("Reason")
+			switch action {
+			case APPROVAL_APPROVED:
+				return true, event_reason, nil
+			case APPROVAL_DENIED:
+				return false, event_reason, nil
+			}
+		}
+	}
+}
+
+type AgentApproveFunctionArgs struct {
+	ApprovalId string `vfilter:"required,field=id,doc=The approval request to decide on."`
+	Deny       bool   `vfilter:"optional,field=deny,doc=Deny the request instead of approving it."`
+	Reason     string `vfilter:"optional,field=reason,doc=A reason passed back to the agent."`
+}
+
+type AgentApproveFunction struct{}
+
+func (self AgentApproveFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("agent_approve", args)()
+
+	// Tools run with the permissions of the user who started the
+	// agent. Approving is a review of its risky actions by someone
+	// else so is left to administrators.
+	err := vql_subsystem.CheckAccess(scope, acls.SERVER_ADMIN)
+	if err != nil {
+		scope.Log("agent_approve: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg := &AgentApproveFunctionArgs{}
+	err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("agent_approve: %v", err)
+		return vfilter.Null{}
+	}
+
+	config_obj, ok := vql_subsystem.GetServerConfig(scope)
+	if !ok {
+		scope.Log("agent_approve: Command can only run on the server")
+		return vfilter.Null{}
+	}
+
+	journal, err := services.GetJournal(config_obj)
+	if err != nil {
+		scope.Log("agent_approve: %v", err)
+		return vfilter.Null{}
+	}
+
+	pending, err := getPendingApproval(config_obj, arg.ApprovalId)
+	if err != nil {
+		scope.Log("agent_approve: No pending approval request %v", arg.ApprovalId)
+		return vfilter.Null{}
+	}
+
+	// Anyone may stop their own agent but its actions must be
+	// approved by someone else.
+	principal := vql_subsystem.GetPrincipal(scope)
+	if !arg.Deny && principal == pending.Principal {
+		scope.Log("agent_approve: %v started the agent so can not approve its actions",
+			principal)
+		return vfilter.Null{}
+	}
+
+	action := APPROVAL_APPROVED
+	if arg.Deny {
+		action = APPROVAL_DENIED
+	}
+
+	row := ordereddict.NewDict().
+		Set("ApprovalId", arg.ApprovalId).
+		Set("Action", action).
+		Set("Principal", principal).
+		Set("Reason", arg.Reason)
+
+	err = journal.PushRowsToArtifact(ctx, config_obj,
+		[]*ordereddict.Dict{row}, APPROVAL_ARTIFACT, "server", "")
+	if err != nil {
+		scope.Log("agent_approve: %v", err)
+		return vfilter.Null{}
+	}
+
+	err = services.LogAudit(ctx, config_obj, principal, "agent_approve", row)
+	if err != nil {
+		scope.Log("agent_approve: %v", err)
+	}
+
+	return row
+}
+
+func (self AgentApproveFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:     "agent_approve",
+		Doc:      "Approve or deny an action proposed by ollama_agent().",
+		ArgType:  type_map.AddType(scope, &AgentApproveFunctionArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.SERVER_ADMIN).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&AgentApproveFunction{})
+}
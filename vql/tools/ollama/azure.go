@@ -0,0 +1,276 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"www.velocidex.com/golang/velociraptor/constants"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	AZURE_SCHEME = "azure://"
+
+	// Tokens for Azure OpenAI are issued for this resource.
+	AZURE_OPENAI_RESOURCE = "https://cognitiveservices.azure.com"
+
+	DEFAULT_AZURE_AUTHORITY = "https://login.microsoftonline.com"
+
+	AZURE_TOKEN_TIMEOUT = 30 * time.Second
+)
+
+// How the server authenticates to Azure OpenAI.
+const (
+	AZURE_AUTH_API_KEY            = "api_key"
+	AZURE_AUTH_CLIENT_CREDENTIALS = "client_credentials"
+	AZURE_AUTH_MANAGED_IDENTITY   = "managed_identity"
+)
+
+var (
+	// The instance metadata service of Azure VMs, which issues the
+	// tokens of their managed identities.
+	azure_imds_endpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+)
+
+// A base_url of azure://name calls the Azure OpenAI resource stored
+// in the Azure OpenAI Creds secret called name. Many enterprises
+// disable API keys so the server can sign in with Entra ID instead,
+// either as an app registration (client credentials) or with the
+// managed identity of the VM or App Service it runs on.
+func isAzureUrl(base_url string) bool {
+	return strings.HasPrefix(base_url, AZURE_SCHEME)
+}
+
+type azureConfig struct {
+	// The endpoint of the resource, e.g.
+	// https://name.openai.azure.com/openai/v1
+	url string
+
+	// chat or responses
+	api string
+
+	auth           string
+	tenant_id      string
+	client_id      string
+	client_secret  string
+	api_key        string
+	authority_host string
+}
+
+func resolveAzureUrl(ctx context.Context, scope vfilter.Scope,
+	base_url string, transport *http.Transport) (*cloudEndpoint, error) {
+	name := strings.TrimSuffix(strings.TrimPrefix(base_url, AZURE_SCHEME), "/")
+	secret, err := getSecret(ctx, scope, constants.AZURE_OPENAI_CREDS, name)
+	if err != nil {
+		return nil, err
+	}
+
+	get := func(field string) string {
+		return strings.TrimSpace(
+			vql_subsystem.GetStringFromRow(scope, secret.Data, field))
+	}
+
+	return newAzureEndpoint(name, &azureConfig{
+		url:            get("url"),
+		api:            get("api"),
+		auth:           get("auth"),
+		tenant_id:      get("tenant_id"),
+		client_id:      get("client_id"),
+		client_secret:  get("client_secret"),
+		api_key:        get("api_key"),
+		authority_host: get("authority_host"),
+	}, transport)
+}
+
+func newAzureEndpoint(name string, config *azureConfig,
+	transport *http.Transport) (*cloudEndpoint, error) {
+	if config.url == "" {
+		return nil, fmt.Errorf("Secret %v has no url", name)
+	}
+
+	result := &cloudEndpoint{
+		base_url: cloudBaseUrl(config.url, config.api),
+		headers:  http.Header{},
+	}
+
+	auth := config.auth
+	if auth == "" && config.api_key != "" {
+		auth = AZURE_AUTH_API_KEY
+	}
+
+	switch auth {
+	case AZURE_AUTH_API_KEY:
+		if config.api_key == "" {
+			return nil, fmt.Errorf("Secret %v has no api_key", name)
+		}
+		result.headers.Set("api-key", config.api_key)
+
+	case AZURE_AUTH_CLIENT_CREDENTIALS:
+		if config.tenant_id == "" || config.client_id == "" ||
+			config.client_secret == "" {
+			return nil, fmt.Errorf(
+				"Secret %v needs a tenant_id, client_id and client_secret", name)
+		}
+
+		authority := config.authority_host
+		if authority == "" {
+			authority = DEFAULT_AZURE_AUTHORITY
+		}
+		authority = strings.TrimSuffix(authority, "/")
+
+		client := &http.Client{Transport: transport}
+		result.credentials = getTokenCredentials(
+			fmt.Sprintf("azure/%v/%v/%v", authority, config.tenant_id,
+				config.client_id),
+			func(ctx context.Context) (*accessToken, error) {
+				return azureClientCredentialsToken(ctx, client, authority, config)
+			})
+
+	case AZURE_AUTH_MANAGED_IDENTITY, "":
+		// The metadata service must not be reached through a proxy.
+		direct := transport.Clone()
+		direct.Proxy = nil
+
+		client := &http.Client{Transport: direct}
+		result.credentials = getTokenCredentials(
+			"azure/managed_identity/"+config.client_id,
+			func(ctx context.Context) (*accessToken, error) {
+				return azureManagedIdentityToken(ctx, client, config.client_id)
+			})
+
+	default:
+		return nil, fmt.Errorf(
+			"Secret %v: auth should be api_key, client_credentials or managed_identity not %v",
+			name, auth)
+	}
+
+	return result, nil
+}
+
+// Entra ID returns expiry times as numbers, the managed identity
+// endpoints as strings.
+type azureSeconds int64
+
+func (self *azureSeconds) UnmarshalJSON(data []byte) error {
+	value, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return err
+	}
+	*self = azureSeconds(value)
+	return nil
+}
+
+type azureTokenResponse struct {
+	AccessToken string       `json:"access_token"`
+	ExpiresIn   azureSeconds `json:"expires_in"`
+	ExpiresOn   azureSeconds `json:"expires_on"`
+
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func azureClientCredentialsToken(ctx context.Context, client *http.Client,
+	authority string, config *azureConfig) (*accessToken, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", config.client_id)
+	form.Set("client_secret", config.client_secret)
+	form.Set("scope", AZURE_OPENAI_RESOURCE+"/.default")
+
+	ctx, cancel := context.WithTimeout(ctx, AZURE_TOKEN_TIMEOUT)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST",
+		authority+"/"+url.PathEscape(config.tenant_id)+"/oauth2/v2.0/token",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return azureToken(client, req)
+}
+
+func azureManagedIdentityToken(ctx context.Context, client *http.Client,
+	client_id string) (*accessToken, error) {
+	params := url.Values{}
+	params.Set("resource", AZURE_OPENAI_RESOURCE)
+	if client_id != "" {
+		params.Set("client_id", client_id)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, AZURE_TOKEN_TIMEOUT)
+	defer cancel()
+
+	// App Service and Functions run their own identity endpoint,
+	// VMs use the instance metadata service.
+	endpoint := os.Getenv("IDENTITY_ENDPOINT")
+	header := os.Getenv("IDENTITY_HEADER")
+	headers := http.Header{}
+	if endpoint != "" && header != "" {
+		params.Set("api-version", "2019-08-01")
+		headers.Set("X-IDENTITY-HEADER", header)
+
+	} else {
+		endpoint = azure_imds_endpoint
+		params.Set("api-version", "2018-02-01")
+		headers.Set("Metadata", "true")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET",
+		endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = headers
+
+	return azureToken(client, req)
+}
+
+func azureToken(client *http.Client, req *http.Request) (*accessToken, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return nil, err
+	}
+
+	token := &azureTokenResponse{}
+	err = json.Unmarshal(body, token)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		message := token.ErrorDescription
+		if message == "" {
+			message = token.Error
+		}
+		if message == "" {
+			message = elideBody(body)
+		}
+		return nil, fmt.Errorf("Entra ID token request to %v failed: %v: %v",
+			redactUrl(req.URL.Scheme+"://"+req.URL.Host+req.URL.Path),
+			resp.Status, message)
+	}
+
+	result := &accessToken{token: token.AccessToken}
+	switch {
+	case token.ExpiresOn > 0:
+		result.expires = time.Unix(int64(token.ExpiresOn), 0)
+	default:
+		result.expires = utils.GetTime().Now().Add(
+			time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return result, nil
+}
@@ -0,0 +1,435 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	POOL_BASE_URL = "pool://endpoints"
+
+	BALANCE_LEAST_BUSY  = "least_busy"
+	BALANCE_ROUND_ROBIN = "round_robin"
+)
+
+// Calls may be spread over several servers, e.g. GPU hosts sharing
+// the enrichment of a hunt, by giving base_url as a comma separated
+// list or setting AI.endpoints. Each call goes to the endpoint with
+// the fewest calls in flight (or to each in turn with round_robin),
+// skipping endpoints whose circuit breaker is open. A call which can
+// not connect, or which the server is too overloaded to take, is
+// sent to the next endpoint.
+//
+// An endpoint whose circuit was open is on probation once it answers
+// again: it takes one call at a time until it has worked for the
+// cooldown, and a single failure takes it out of service again.
+type endpointPool struct {
+	scope     vfilter.Scope
+	strategy  string
+	endpoints []*poolEndpoint
+
+	// Used to take turns and to break ties between equally busy
+	// endpoints.
+	next uint64
+}
+
+type poolEndpoint struct {
+	*endpoint
+	stats *endpointStats
+}
+
+// Kept for each server across all queries so the load of concurrent
+// queries is balanced.
+type endpointStats struct {
+	in_flight int64
+	requests  int64
+	failures  int64
+
+	mu              sync.Mutex
+	probation_until time.Time
+}
+
+func (self *endpointStats) onProbation() bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return !self.probation_until.IsZero()
+}
+
+func (self *endpointStats) startProbation(period time.Duration) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.probation_until = utils.GetTime().Now().Add(period)
+}
+
+func (self *endpointStats) stopProbation() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.probation_until = time.Time{}
+}
+
+// Returns true when the probation ended with this call.
+func (self *endpointStats) passProbation() bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.probation_until.IsZero() ||
+		utils.GetTime().Now().Before(self.probation_until) {
+		return false
+	}
+	self.probation_until = time.Time{}
+	return true
+}
+
+var (
+	endpoint_stats_mu sync.Mutex
+	endpoint_stats    = make(map[string]*endpointStats)
+)
+
+func getEndpointStats(base_url string) *endpointStats {
+	endpoint_stats_mu.Lock()
+	defer endpoint_stats_mu.Unlock()
+
+	stats, pres := endpoint_stats[base_url]
+	if !pres {
+		stats = &endpointStats{}
+		endpoint_stats[base_url] = stats
+	}
+	return stats
+}
+
+// Split a list of base urls. Commas may also appear within a url
+// (e.g. the models of a mock:// url) so only a comma followed by
+// another url starts the next one.
+func splitBaseUrls(base_url string) []string {
+	result := []string{}
+	for _, part := range strings.Split(base_url, ",") {
+		trimmed := strings.TrimSpace(part)
+		if len(result) > 0 && !strings.Contains(trimmed, "://") {
+			result[len(result)-1] += "," + part
+			continue
+		}
+		if trimmed != "" {
+			result = append(result, trimmed)
+		}
+	}
+
+	// A bare mock:// would lose its scheme.
+	for idx, item := range result {
+		if !isMockUrl(item) {
+			result[idx] = strings.TrimSuffix(item, "/")
+		}
+	}
+	return result
+}
+
+func newEndpointPool(ctx context.Context, scope vfilter.Scope,
+	base_urls []string, strategy string) (*endpointPool, error) {
+	if strategy == "" {
+		strategy = BALANCE_LEAST_BUSY
+	}
+
+	if strategy != BALANCE_LEAST_BUSY && strategy != BALANCE_ROUND_ROBIN {
+		return nil, fmt.Errorf(
+			"Load balancing should be least_busy or round_robin not %v", strategy)
+	}
+
+	result := &endpointPool{scope: scope, strategy: strategy}
+	for _, base_url := range base_urls {
+		endpoint, err := newEndpoint(ctx, scope, base_url)
+		if err != nil {
+			return nil, err
+		}
+		result.endpoints = append(result.endpoints, &poolEndpoint{
+			endpoint: endpoint,
+			stats:    getEndpointStats(endpoint.base_url),
+		})
+	}
+	return result, nil
+}
+
+type sessionKey struct{}
+
+// Calls of the same conversation are sent to the same endpoint so
+// the model stays loaded there and the server can reuse its cache of
+// the conversation so far instead of processing the whole prompt
+// again.
+func withSession(ctx context.Context, session string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+func getSession(ctx context.Context) string {
+	session, _ := ctx.Value(sessionKey{}).(string)
+	return session
+}
+
+// Each session prefers the endpoint with the highest score so it
+// only moves when that endpoint fails, and adding an endpoint only
+// moves the sessions it now scores highest for.
+func sessionScore(session string, item *poolEndpoint) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(session))
+	h.Write([]byte{0})
+	h.Write([]byte(item.base_url))
+	return h.Sum64()
+}
+
+// Whether the endpoint should take calls while others are left.
+func (self *poolEndpoint) healthy() bool {
+	if self.circuit_state.Allow(CircuitBreaker{}, self.base_url) != nil {
+		return false
+	}
+
+	return !self.stats.onProbation() ||
+		atomic.LoadInt64(&self.stats.in_flight) == 0
+}
+
+// Record the outcome of a call and log when the endpoint is taken out
+// of or returned to service.
+func (self *endpointPool) record(item *poolEndpoint, err error) {
+	breaker := CircuitBreaker{}
+	on_probation := item.stats.onProbation()
+	if on_probation {
+		breaker.Failures = 1
+	}
+
+	was_tripped := item.circuit_state.Tripped()
+	item.circuit_state.Record(breaker, err)
+	tripped := item.circuit_state.Tripped()
+
+	_, cooldown := breaker.settings()
+	endpoint := redactUrl(item.base_url)
+
+	switch {
+	case tripped && !was_tripped:
+		item.stats.stopProbation()
+		self.scope.Log("ollama: %v is failing, sending its calls to the other endpoints for %v",
+			endpoint, cooldown)
+
+	case was_tripped && !tripped:
+		item.stats.startProbation(cooldown)
+		self.scope.Log("ollama: %v answered again, on probation for %v",
+			endpoint, cooldown)
+
+	case on_probation && err == nil && item.stats.passProbation():
+		self.scope.Log("ollama: %v passed its probation", endpoint)
+	}
+}
+
+// Pick an endpoint not yet tried for the call. Unhealthy endpoints
+// are only used when no others are left. Returns nil when all were
+// tried.
+func (self *endpointPool) pick(
+	tried map[*poolEndpoint]bool, session string) *poolEndpoint {
+	if session != "" {
+		return self.pickForSession(tried, session)
+	}
+
+	count := uint64(len(self.endpoints))
+	start := atomic.AddUint64(&self.next, 1) - 1
+
+	for _, healthy_only := range []bool{true, false} {
+		var result *poolEndpoint
+		for i := uint64(0); i < count; i++ {
+			item := self.endpoints[(start+i)%count]
+			if tried[item] {
+				continue
+			}
+
+			if healthy_only && !item.healthy() {
+				continue
+			}
+
+			if self.strategy == BALANCE_ROUND_ROBIN {
+				return item
+			}
+
+			if result == nil || atomic.LoadInt64(&item.stats.in_flight) <
+				atomic.LoadInt64(&result.stats.in_flight) {
+				result = item
+			}
+		}
+
+		if result != nil {
+			return result
+		}
+	}
+	return nil
+}
+
+func (self *endpointPool) pickForSession(
+	tried map[*poolEndpoint]bool, session string) *poolEndpoint {
+	for _, healthy_only := range []bool{true, false} {
+		var result *poolEndpoint
+		var result_score uint64
+		for _, item := range self.endpoints {
+			if tried[item] {
+				continue
+			}
+
+			if healthy_only && !item.healthy() {
+				continue
+			}
+
+			score := sessionScore(session, item)
+			if result == nil || score > result_score {
+				result = item
+				result_score = score
+			}
+		}
+
+		if result != nil {
+			return result
+		}
+	}
+	return nil
+}
+
+func (self *endpointPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	tried := make(map[*poolEndpoint]bool)
+	session := getSession(req.Context())
+
+	// Returned when no other endpoint is left to try.
+	var last_err error
+	var last_resp *http.Response
+
+	for {
+		item := self.pick(tried, session)
+		if item == nil || (len(tried) > 0 && req.GetBody == nil) {
+			if last_resp != nil {
+				return last_resp, nil
+			}
+			return nil, last_err
+		}
+
+		out := req.Clone(req.Context())
+		if len(tried) > 0 {
+			// The body was sent to the failed endpoint.
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			out.Body = body
+
+			if last_resp != nil {
+				last_resp.Body.Close()
+				last_resp = nil
+			}
+			self.scope.Log("ollama: %v, trying %v", last_err,
+				redactUrl(item.base_url))
+		}
+		tried[item] = true
+
+		target, err := url.Parse(item.base_url + req.URL.Path)
+		if err != nil {
+			return nil, err
+		}
+		target.RawQuery = req.URL.RawQuery
+		out.URL = target
+		out.Host = ""
+
+		for k, v := range item.headers {
+			out.Header[k] = v
+		}
+
+		atomic.AddInt64(&item.stats.in_flight, 1)
+		atomic.AddInt64(&item.stats.requests, 1)
+
+		resp, err := item.transport.RoundTrip(out)
+		if err != nil {
+			atomic.AddInt64(&item.stats.in_flight, -1)
+
+			// A cancelled query says nothing about the endpoint.
+			if req.Context().Err() != nil {
+				return nil, err
+			}
+
+			atomic.AddInt64(&item.stats.failures, 1)
+			self.record(item, newConnectionError(item.base_url, err))
+			last_err = fmt.Errorf("%v: %w", redactUrl(item.base_url), err)
+			continue
+		}
+
+		// Streamed calls are in flight until the response is read.
+		resp.Body = &inFlightBody{ReadCloser: resp.Body, stats: item.stats}
+
+		if resp.StatusCode < http.StatusInternalServerError {
+			self.record(item, nil)
+			return resp, nil
+		}
+
+		atomic.AddInt64(&item.stats.failures, 1)
+		self.record(item, newHttpError(item.base_url, resp.StatusCode, nil))
+
+		// Other errors would be the same on every endpoint.
+		switch resp.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+		default:
+			return resp, nil
+		}
+
+		last_resp = resp
+		last_err = fmt.Errorf("%v: %v", redactUrl(item.base_url), resp.Status)
+	}
+}
+
+type inFlightBody struct {
+	io.ReadCloser
+	stats *endpointStats
+	once  sync.Once
+}
+
+func (self *inFlightBody) Close() error {
+	self.once.Do(func() {
+		atomic.AddInt64(&self.stats.in_flight, -1)
+	})
+	return self.ReadCloser.Close()
+}
+
+// Check each endpoint of the pool and report the load it was given.
+func (self *endpointPool) Status(ctx context.Context,
+	client *Client) []*ordereddict.Dict {
+	result := make([]*ordereddict.Dict, 0, len(self.endpoints))
+	for _, item := range self.endpoints {
+		sub_client := *client
+		sub_client.base_url = item.base_url
+		sub_client.client = &http.Client{Transport: item.transport}
+		sub_client.extra_headers = item.headers
+		sub_client.circuit_state = item.circuit_state
+		sub_client.pool = nil
+
+		status := ordereddict.NewDict().
+			Set("Endpoint", redactUrl(item.base_url)).
+			Set("Healthy", false).
+			Set("Version", "").
+			Set("InFlight", atomic.LoadInt64(&item.stats.in_flight)).
+			Set("Requests", atomic.LoadInt64(&item.stats.requests)).
+			Set("Failures", atomic.LoadInt64(&item.stats.failures)).
+			Set("OnProbation", item.stats.onProbation())
+
+		version, err := sub_client.Version(ctx)
+		if err != nil {
+			setHealthError(status, err)
+		} else {
+			status.Update("Healthy", true).Update("Version", version)
+		}
+
+		result = append(result, status)
+	}
+	return result
+}
@@ -0,0 +1,197 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/velociraptor/vtesting/assert"
+)
+
+func (self *OllamaTestSuite) TestLoadBalancing() {
+	err := SetAISettings(self.ConfigObj, &config_proto.AIConfig{
+		Endpoints: []string{
+			"mock://a?response=A", "mock://b?response=B"},
+		LoadBalancing: BALANCE_ROUND_ROBIN,
+	})
+	assert.NoError(self.T(), err)
+	defer SetAISettings(self.ConfigObj, &config_proto.AIConfig{})
+
+	// Calls without a base_url take turns on the configured endpoints.
+	rows := self.run(`
+SELECT * FROM foreach(row={ SELECT * FROM range(end=4) },
+query={
+  SELECT Response FROM ollama(model="llama3", prompt="Hi", cache_bypass=TRUE)
+})`)
+
+	responses := []string{}
+	for _, row := range rows {
+		response, _ := row.GetString("Response")
+		responses = append(responses, response)
+	}
+	assert.Equal(self.T(), []string{"A", "B", "A", "B"}, responses)
+
+	// Calls which can not connect go to the next endpoint.
+	rows = self.run(`
+LET URLS = "http://127.0.0.1:1,mock://b?response=B&models=llama3,mistral"
+SELECT * FROM foreach(row={ SELECT * FROM range(end=2) },
+query={
+  SELECT Response FROM ollama(model="llama3", prompt="Hi",
+     cache_bypass=TRUE, base_url=URLS)
+})`)
+	assert.Equal(self.T(), 2, len(rows))
+	for _, row := range rows {
+		response, _ := row.GetString("Response")
+		assert.Equal(self.T(), "B", response)
+	}
+
+	// Each endpoint's health is reported.
+	rows = self.run(`
+SELECT Healthy, Failures FROM foreach(row=ollama_health(
+   base_url="http://127.0.0.1:1,mock://b?response=B&models=llama3,mistral").Endpoints)`)
+	assert.Equal(self.T(), 2, len(rows))
+
+	healthy, _ := rows[0].GetBool("Healthy")
+	assert.False(self.T(), healthy)
+
+	failures, _ := rows[0].GetInt64("Failures")
+	assert.True(self.T(), failures > 0)
+
+	healthy, _ = rows[1].GetBool("Healthy")
+	assert.True(self.T(), healthy)
+}
+
+func (self *OllamaTestSuite) TestSessionRouting() {
+	err := SetAISettings(self.ConfigObj, &config_proto.AIConfig{
+		Endpoints: []string{
+			"mock://a?response=A", "mock://b?response=B"},
+		LoadBalancing: BALANCE_ROUND_ROBIN,
+	})
+	assert.NoError(self.T(), err)
+	defer SetAISettings(self.ConfigObj, &config_proto.AIConfig{})
+
+	// Every turn of a conversation goes to the same endpoint.
+	for _, query := range []string{`
+SELECT * FROM foreach(row={ SELECT * FROM range(end=4) },
+query={
+  SELECT Response FROM ollama(model="llama3", prompt="Hi", session="s1")
+})`, `
+SELECT * FROM foreach(row={ SELECT * FROM range(end=4) },
+query={
+  SELECT Content AS Response FROM ollama_chat(model="llama3",
+     messages=dict(role="user", content="Hi"))
+})`} {
+		rows := self.run(query)
+		assert.Equal(self.T(), 4, len(rows))
+
+		first, _ := rows[0].GetString("Response")
+		for _, row := range rows {
+			response, _ := row.GetString("Response")
+			assert.Equal(self.T(), first, response)
+		}
+	}
+}
+
+func (self *OllamaTestSuite) TestFailover() {
+	clock := utils.NewMockClock(time.Unix(1700000000, 0))
+	defer utils.MockTime(clock)()
+
+	var down int32 = 1
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if atomic.LoadInt32(&down) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintf(w, `{"model":"llama3","response":"A","done":true}`+"\n")
+		}))
+	defer server.Close()
+
+	query := fmt.Sprintf(`
+SELECT * FROM foreach(row={ SELECT * FROM range(end=10) },
+query={
+  SELECT Response FROM ollama(model="llama3", prompt="Hi",
+     cache_bypass=TRUE, base_url=%q)
+})`, server.URL+",mock://b?response=B")
+
+	state := getCircuitState(server.URL)
+	stats := getEndpointStats(server.URL)
+
+	// Calls to the failing endpoint go to the other one until its
+	// circuit opens.
+	responses := map[string]int{}
+	for _, row := range self.run(query) {
+		response, _ := row.GetString("Response")
+		responses[response]++
+	}
+	assert.Equal(self.T(), map[string]int{"B": 10}, responses)
+	assert.True(self.T(), state.Tripped())
+
+	// Once it answers again it is on probation.
+	atomic.StoreInt32(&down, 0)
+	clock.Set(clock.Now().Add(DEFAULT_CIRCUIT_COOLDOWN + time.Second))
+
+	responses = map[string]int{}
+	for _, row := range self.run(query) {
+		response, _ := row.GetString("Response")
+		responses[response]++
+	}
+	assert.True(self.T(), responses["A"] > 0)
+	assert.False(self.T(), state.Tripped())
+	assert.True(self.T(), stats.onProbation())
+
+	// A call after the probation period returns it to full service.
+	clock.Set(clock.Now().Add(DEFAULT_CIRCUIT_COOLDOWN + time.Second))
+	self.run(query)
+	assert.False(self.T(), stats.onProbation())
+}
+
+func (self *OllamaTestSuite) TestSrvDiscovery() {
+	host, port, err := net.SplitHostPort(self.server.Listener.Addr().String())
+	assert.NoError(self.T(), err)
+
+	port_number, err := strconv.Atoi(port)
+	assert.NoError(self.T(), err)
+
+	old_lookup := lookupSRV
+	defer func() { lookupSRV = old_lookup }()
+
+	lookupSRV = func(ctx context.Context,
+		service, proto, name string) (string, []*net.SRV, error) {
+		if name != "_ollama._tcp.example.com" {
+			return "", nil, fmt.Errorf("no such host %v", name)
+		}
+
+		// The backup server is not used.
+		return name, []*net.SRV{
+			{Target: host + ".", Port: uint16(port_number), Priority: 10},
+			{Target: host, Port: uint16(port_number), Priority: 10},
+			{Target: "backup.", Port: 1, Priority: 20},
+		}, nil
+	}
+
+	rows := self.run(`
+SELECT Endpoint, Healthy FROM foreach(row=ollama_health(
+   base_url="srv://_ollama._tcp.example.com").Endpoints)`)
+	assert.Equal(self.T(), 2, len(rows))
+	for _, row := range rows {
+		healthy, _ := row.GetBool("Healthy")
+		assert.True(self.T(), healthy)
+	}
+
+	endpoint, _ := rows[0].GetString("Endpoint")
+	assert.Equal(self.T(), self.server.URL, endpoint)
+
+	// Unknown names are reported.
+	rows = self.run(`
+SELECT * FROM ollama(model="llama3", prompt="Hi",
+   base_url="srv://_ollama._tcp.missing.com")`)
+	assert.Equal(self.T(), 0, len(rows))
+}
@@ -0,0 +1,211 @@
+package ollama
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+	aws_credentials "github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	"www.velocidex.com/golang/velociraptor/constants"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	BEDROCK_SCHEME = "bedrock://"
+
+	// Requests to Bedrock are signed for this service.
+	BEDROCK_SERVICE = "bedrock"
+
+	DEFAULT_BEDROCK_SESSION_NAME = "velociraptor"
+)
+
+// A base_url of bedrock://name calls the Bedrock endpoint stored in
+// the AWS Bedrock Creds secret called name. Without keys in the
+// secret the standard AWS credential chain is used (environment,
+// shared config, web identity, container and instance roles) so the
+// server need not hold long lived keys. A role may be assumed on top
+// of these credentials, with an external id for cross account
+// access.
+func isBedrockUrl(base_url string) bool {
+	return strings.HasPrefix(base_url, BEDROCK_SCHEME)
+}
+
+type bedrockConfig struct {
+	// Defaults to the OpenAI compatible endpoint of the region.
+	url string
+
+	// chat or responses
+	api string
+
+	region  string
+	profile string
+
+	credentials_key    string
+	credentials_secret string
+	credentials_token  string
+
+	role_arn     string
+	external_id  string
+	session_name string
+}
+
+func resolveBedrockUrl(ctx context.Context, scope vfilter.Scope,
+	base_url string, transport *http.Transport) (*cloudEndpoint, error) {
+	name := strings.TrimSuffix(strings.TrimPrefix(base_url, BEDROCK_SCHEME), "/")
+	secret, err := getSecret(ctx, scope, constants.AWS_BEDROCK_CREDS, name)
+	if err != nil {
+		return nil, err
+	}
+
+	get := func(field string) string {
+		return strings.TrimSpace(
+			vql_subsystem.GetStringFromRow(scope, secret.Data, field))
+	}
+
+	return newBedrockEndpoint(ctx, name, &bedrockConfig{
+		url:                get("url"),
+		api:                get("api"),
+		region:             get("region"),
+		profile:            get("profile"),
+		credentials_key:    get("credentials_key"),
+		credentials_secret: get("credentials_secret"),
+		credentials_token:  get("credentials_token"),
+		role_arn:           get("role_arn"),
+		external_id:        get("external_id"),
+		session_name:       get("session_name"),
+	}, transport)
+}
+
+func newBedrockEndpoint(ctx context.Context, name string,
+	bedrock *bedrockConfig, transport *http.Transport) (*cloudEndpoint, error) {
+	conf := []func(*config.LoadOptions) error{
+		config.WithHTTPClient(&http.Client{Transport: transport}),
+	}
+
+	if bedrock.region != "" {
+		conf = append(conf, config.WithRegion(bedrock.region))
+	}
+
+	if bedrock.profile != "" {
+		conf = append(conf, config.WithSharedConfigProfile(bedrock.profile))
+	}
+
+	// Keys in the secret take precedence over the credential chain.
+	if bedrock.credentials_key != "" && bedrock.credentials_secret != "" {
+		conf = append(conf, config.WithCredentialsProvider(
+			aws_credentials.NewStaticCredentialsProvider(
+				bedrock.credentials_key, bedrock.credentials_secret,
+				bedrock.credentials_token)))
+	}
+
+	sess, err := config.LoadDefaultConfig(ctx, conf...)
+	if err != nil {
+		return nil, fmt.Errorf("Secret %v: %w", name, err)
+	}
+
+	if sess.Region == "" {
+		return nil, fmt.Errorf("Secret %v has no region", name)
+	}
+
+	if sess.Credentials == nil {
+		return nil, fmt.Errorf("Secret %v: no AWS credentials found", name)
+	}
+
+	provider := sess.Credentials
+	if bedrock.role_arn != "" {
+		session_name := bedrock.session_name
+		if session_name == "" {
+			session_name = DEFAULT_BEDROCK_SESSION_NAME
+		}
+
+		provider = aws.NewCredentialsCache(
+			stscreds.NewAssumeRoleProvider(sts.NewFromConfig(sess),
+				bedrock.role_arn, func(o *stscreds.AssumeRoleOptions) {
+					o.RoleSessionName = session_name
+					if bedrock.external_id != "" {
+						o.ExternalID = aws.String(bedrock.external_id)
+					}
+				}),
+			func(o *aws.CredentialsCacheOptions) {
+				o.ExpiryWindow = TOKEN_REFRESH_MARGIN
+			})
+	}
+
+	endpoint := bedrock.url
+	if endpoint == "" {
+		endpoint = fmt.Sprintf(
+			"https://bedrock-runtime.%v.amazonaws.com/openai/v1", sess.Region)
+	}
+
+	return &cloudEndpoint{
+		base_url: cloudBaseUrl(endpoint, bedrock.api),
+		headers:  http.Header{},
+		credentials: &awsCredentials{
+			provider: provider,
+			signer:   v4.NewSigner(),
+			region:   sess.Region,
+			service:  BEDROCK_SERVICE,
+		},
+	}, nil
+}
+
+// Signs requests with SigV4. The provider caches the credentials
+// and refreshes them before they expire.
+type awsCredentials struct {
+	provider aws.CredentialsProvider
+	signer   *v4.Signer
+	region   string
+	service  string
+}
+
+func (self *awsCredentials) Authorize(req *http.Request) error {
+	creds, err := self.provider.Retrieve(req.Context())
+	if err != nil {
+		return err
+	}
+
+	payload_hash, err := payloadHash(req)
+	if err != nil {
+		return err
+	}
+
+	return self.signer.SignHTTP(req.Context(), creds, req, payload_hash,
+		self.service, self.region, utils.GetTime().Now())
+}
+
+func (self *awsCredentials) Invalidate() {
+	cache, ok := self.provider.(*aws.CredentialsCache)
+	if ok {
+		cache.Invalidate()
+	}
+}
+
+// The signature covers the body so it is read from a copy.
+func payloadHash(req *http.Request) (string, error) {
+	hash := sha256.New()
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+
+		_, err = io.Copy(hash, body)
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,266 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+var (
+	DEFAULT_BENCH_CONCURRENCY = []int64{1, 2, 4, 8}
+)
+
+type OllamaBenchPluginArgs struct {
+	Model        string        `vfilter:"required,field=model,doc=The model to benchmark."`
+	BaseUrl      string        `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server. Several comma separated URLs, or srv://name for the servers of a DNS SRV record, spread the calls over the servers."`
+	Concurrency  []vfilter.Any `vfilter:"optional,field=concurrency,doc=The numbers of concurrent requests to measure (default 1, 2, 4 and 8)."`
+	Requests     int64         `vfilter:"optional,field=requests,doc=The number of requests sent at each concurrency (default 20, at least the concurrency)."`
+	PromptTokens int64         `vfilter:"optional,field=prompt_tokens,doc=The approximate size of each synthetic prompt in tokens (default 256)."`
+	MaxTokens    int64         `vfilter:"optional,field=max_tokens,doc=The most tokens in each response (default 128)."`
+	Timeout      int64         `vfilter:"optional,field=timeout,doc=Seconds each request may take (default 300)."`
+}
+
+// Load tests a server with synthetic prompts so admins can size a
+// shared server before enriching the results of a hunt. Each
+// concurrency is measured separately and reported in a row.
+type OllamaBenchPlugin struct{}
+
+func (self OllamaBenchPlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("ollama_bench", args)()
+
+		err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+		if err != nil {
+			scope.Log("ollama_bench: %v", err)
+			return
+		}
+
+		arg := &OllamaBenchPluginArgs{}
+		err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+		if err != nil {
+			scope.Log("ollama_bench: %v", err)
+			return
+		}
+
+		levels := DEFAULT_BENCH_CONCURRENCY
+		if len(arg.Concurrency) > 0 {
+			levels = nil
+			for _, item := range arg.Concurrency {
+				level, ok := utils.ToInt64(item)
+				if !ok || level <= 0 {
+					scope.Log("ollama_bench: concurrency should be positive numbers not %v",
+						item)
+					return
+				}
+				levels = append(levels, level)
+			}
+		}
+
+		if arg.Requests <= 0 {
+			arg.Requests = 20
+		}
+
+		if arg.PromptTokens <= 0 {
+			arg.PromptTokens = 256
+		}
+
+		if arg.MaxTokens <= 0 {
+			arg.MaxTokens = 128
+		}
+
+		if arg.Timeout <= 0 {
+			arg.Timeout = 300
+		}
+
+		client, err := NewClient(ctx, scope, arg.BaseUrl)
+		if err != nil {
+			scope.Log("ollama_bench: %v", err)
+			return
+		}
+		client = client.WithTimeouts(Timeouts{
+			Total: time.Duration(arg.Timeout) * time.Second,
+		})
+
+		// The first request loads the model, which would make the
+		// first concurrency look slow.
+		scope.Log("INFO:ollama_bench: Loading %v", arg.Model)
+		sample := benchRequest(arg, 0)
+		err = sample.run(ctx, client)
+		if err != nil {
+			scope.Log("ollama_bench: %v", err)
+			return
+		}
+
+		for _, level := range levels {
+			requests := arg.Requests
+			if requests < level {
+				requests = level
+			}
+
+			scope.Log("INFO:ollama_bench: Sending %v requests, %v at a time",
+				requests, level)
+			row := runBenchLevel(ctx, client, arg, level, requests)
+
+			select {
+			case <-ctx.Done():
+				return
+			case output_chan <- row:
+			}
+		}
+	}()
+
+	return output_chan
+}
+
+type benchCall struct {
+	req      *GenerateRequest
+	latency  time.Duration
+	first    time.Duration
+	prompt   int64
+	response int64
+	err      error
+}
+
+// Each prompt is different so the server can not reuse an earlier
+// evaluation of it.
+func benchRequest(arg *OllamaBenchPluginArgs, idx int64) *benchCall {
+	prompt := &strings.Builder{}
+	fmt.Fprintf(prompt, "Request %d. Summarize these log lines in one paragraph.\n", idx)
+	for line := int64(0); EstimateTokens(arg.Model, prompt.String()) < arg.PromptTokens; line++ {
+		fmt.Fprintf(prompt,
+			"2024-05-01T10:%02d:%02d host-%d sshd[%d]: Failed password for invalid user test%d from 10.0.%d.%d port %d ssh2\n",
+			line/60%60, line%60, idx%16, 1000+line, line, idx%256, line%256, 40000+line)
+	}
+
+	return &benchCall{
+		req: &GenerateRequest{
+			Model:   arg.Model,
+			Prompt:  prompt.String(),
+			Options: ordereddict.NewDict().Set("num_predict", arg.MaxTokens),
+			Stream:  true,
+		},
+	}
+}
+
+func (self *benchCall) run(ctx context.Context, client *Client) error {
+	start := time.Now()
+	self.err = client.Generate(ctx, self.req, func(chunk *GenerateResponse) error {
+		if self.first == 0 && chunk.Response != "" {
+			self.first = time.Since(start)
+		}
+		if chunk.Done {
+			self.prompt = chunk.PromptEvalCount
+			self.response = chunk.EvalCount
+		}
+		return nil
+	})
+	self.latency = time.Since(start)
+	return self.err
+}
+
+func runBenchLevel(ctx context.Context, client *Client,
+	arg *OllamaBenchPluginArgs, level, requests int64) *ordereddict.Dict {
+	calls := make([]*benchCall, 0, requests)
+	for idx := int64(0); idx < requests; idx++ {
+		calls = append(calls, benchRequest(arg, level*requests+idx+1))
+	}
+
+	work := make(chan *benchCall)
+	wg := &sync.WaitGroup{}
+	start := time.Now()
+	for i := int64(0); i < level; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for call := range work {
+				_ = call.run(ctx, client)
+			}
+		}()
+	}
+
+	for _, call := range calls {
+		work <- call
+	}
+	close(work)
+	wg.Wait()
+	duration := time.Since(start).Seconds()
+
+	var errors, prompt_tokens, response_tokens int64
+	var latencies, first_tokens []float64
+	var last_error string
+	for _, call := range calls {
+		if call.err != nil {
+			errors++
+			last_error = call.err.Error()
+			continue
+		}
+		latencies = append(latencies, call.latency.Seconds())
+		first_tokens = append(first_tokens, call.first.Seconds())
+		prompt_tokens += call.prompt
+		response_tokens += call.response
+	}
+
+	return ordereddict.NewDict().
+		Set("Model", arg.Model).
+		Set("Concurrency", level).
+		Set("Requests", requests).
+		Set("Errors", errors).
+		Set("Duration", duration).
+		Set("RequestsPerSecond", float64(requests-errors)/duration).
+		Set("LatencyP50", percentile(latencies, 0.5)).
+		Set("LatencyP95", percentile(latencies, 0.95)).
+		Set("FirstTokenP50", percentile(first_tokens, 0.5)).
+		Set("FirstTokenP95", percentile(first_tokens, 0.95)).
+		Set("PromptTokens", prompt_tokens).
+		Set("ResponseTokens", response_tokens).
+		Set("PromptTokensPerSecond", float64(prompt_tokens)/duration).
+		Set("ResponseTokensPerSecond", float64(response_tokens)/duration).
+		Set("LastError", last_error)
+}
+
+// The nearest rank percentile, in seconds.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+func (self OllamaBenchPlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name:     "ollama_bench",
+		Doc:      "Measure the throughput and latency of a model at increasing concurrency.",
+		ArgType:  type_map.AddType(scope, &OllamaBenchPluginArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterPlugin(&OllamaBenchPlugin{})
+}
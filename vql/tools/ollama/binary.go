@@ -0,0 +1,183 @@
+package ollama
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+const (
+	BINARY_HEX    = "hex"
+	BINARY_BASE64 = "base64"
+	BINARY_OMIT   = "omit"
+
+	DEFAULT_MAX_BINARY = 256
+)
+
+func validateBinaryEncoding(encoding string) error {
+	switch encoding {
+	case "", BINARY_HEX, BINARY_BASE64, BINARY_OMIT:
+		return nil
+	}
+	return fmt.Errorf("Unknown binary_encoding %v: should be one of hex, base64 or omit",
+		encoding)
+}
+
+// Binary data does not survive the trip through JSON into a prompt:
+// invalid UTF8 is replaced and raw bytes become meaningless base64.
+// Such values are encoded explicitly with a note of their size so
+// the model knows what it is looking at.
+type binaryEncoder struct {
+	encoding  string
+	max_bytes int
+
+	// Strings longer than this are truncated so a single script
+	// block or blob can not use up the context window.
+	max_field_len int
+}
+
+func (self binaryEncoder) encode(data []byte) string {
+	max_bytes := self.max_bytes
+	if max_bytes <= 0 {
+		max_bytes = DEFAULT_MAX_BINARY
+	}
+
+	encoded := data
+	suffix := ""
+	if len(encoded) > max_bytes {
+		encoded = encoded[:max_bytes]
+		suffix = " ..."
+	}
+
+	switch self.encoding {
+	case BINARY_OMIT:
+		return fmt.Sprintf("[binary %d bytes]", len(data))
+	case BINARY_BASE64:
+		return fmt.Sprintf("[binary %d bytes, base64] %s%s", len(data),
+			base64.StdEncoding.EncodeToString(encoded), suffix)
+	default:
+		return fmt.Sprintf("[binary %d bytes, hex] %s%s", len(data),
+			hex.EncodeToString(encoded), suffix)
+	}
+}
+
+// Truncate long strings with a marker so the model knows the value
+// is incomplete.
+func (self binaryEncoder) truncate(value string) string {
+	if self.max_field_len <= 0 || len(value) <= self.max_field_len {
+		return value
+	}
+
+	kept := strings.ToValidUTF8(value[:self.max_field_len], "")
+	return fmt.Sprintf("%s ...[%d bytes truncated]", kept,
+		len(value)-len(kept))
+}
+
+// Replace binary values in the row. Rows without binary values are
+// returned unchanged.
+func (self binaryEncoder) Sanitize(value interface{}) interface{} {
+	result, _ := self.sanitize(value)
+	return result
+}
+
+// Returns the sanitized value and if it differs from the original.
+// Containers are only copied if they hold binary values.
+func (self binaryEncoder) sanitize(value interface{}) (interface{}, bool) {
+	switch t := value.(type) {
+	case string:
+		cleaned := cleanText(t)
+		if isBinary(cleaned) {
+			return self.encode([]byte(t)), true
+		}
+		cleaned = self.truncate(cleaned)
+		return cleaned, cleaned != t
+
+	case []byte:
+		return self.encode(t), true
+
+	case *ordereddict.Dict:
+		if t == nil {
+			return t, false
+		}
+
+		var result *ordereddict.Dict
+		keys := t.Keys()
+		for idx, k := range keys {
+			v, _ := t.Get(k)
+			sanitized, changed := self.sanitize(v)
+			if result == nil {
+				if !changed {
+					continue
+				}
+				result = ordereddict.NewDict()
+				for _, prev := range keys[:idx] {
+					prev_v, _ := t.Get(prev)
+					result.Set(prev, prev_v)
+				}
+			}
+			result.Set(k, sanitized)
+		}
+		if result == nil {
+			return t, false
+		}
+		return result, true
+
+	case map[string]interface{}:
+		var result map[string]interface{}
+		for k, v := range t {
+			sanitized, changed := self.sanitize(v)
+			if !changed {
+				continue
+			}
+			if result == nil {
+				result = make(map[string]interface{}, len(t))
+				for prev_k, prev_v := range t {
+					result[prev_k] = prev_v
+				}
+			}
+			result[k] = sanitized
+		}
+		if result == nil {
+			return t, false
+		}
+		return result, true
+
+	case []interface{}:
+		var result []interface{}
+		for idx, v := range t {
+			sanitized, changed := self.sanitize(v)
+			if !changed {
+				continue
+			}
+			if result == nil {
+				result = append([]interface{}{}, t...)
+			}
+			result[idx] = sanitized
+		}
+		if result == nil {
+			return t, false
+		}
+		return result, true
+	}
+
+	return value, false
+}
+
+// Text with invalid UTF8 or control characters other than whitespace
+// is treated as binary.
+func isBinary(value string) bool {
+	if !utf8.ValidString(value) {
+		return true
+	}
+
+	for _, c := range value {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' {
+			return true
+		}
+	}
+	return false
+}
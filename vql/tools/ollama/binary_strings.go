@@ -0,0 +1,258 @@
+package ollama
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	pe "www.velocidex.com/golang/go-pe"
+)
+
+const (
+	DEFAULT_MIN_STRING_LENGTH = 6
+	DEFAULT_MAX_STRINGS       = 100
+	DEFAULT_MAX_IMPORTS       = 100
+
+	// Very long strings are usually data rather than anything
+	// meaningful.
+	MAX_STRING_LENGTH = 1024
+)
+
+var (
+	binary_url_regex      = regexp.MustCompile(`(?i)\b(https?|ftp)://[^\s"'<>]+`)
+	binary_ip_regex       = regexp.MustCompile(`\b\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}(:\d+)?\b`)
+	binary_registry_regex = regexp.MustCompile(`(?i)\b(HKEY_[A-Z_]+|HK(LM|CU|CR|U)|SOFTWARE|SYSTEM)\\`)
+	binary_path_regex     = regexp.MustCompile(`(?i)(\b[a-z]:\\|%[a-z]+%\\|\\\\[^\\]+\\)`)
+	binary_command_regex  = regexp.MustCompile(`(?i)\b(powershell|cmd(\.exe)?\s+/c|rundll32|regsvr32|schtasks|bitsadmin|certutil|vssadmin|wmic|mshta|net\s+user)\b`)
+	binary_crypto_regex   = regexp.MustCompile(`(?i)\b(bitcoin|ransom|decrypt|encrypted|\.onion)\b`)
+)
+
+// The kinds of strings, most interesting first.
+var string_kind_scores = map[string]int{
+	"url":      6,
+	"command":  5,
+	"ip":       4,
+	"crypto":   4,
+	"registry": 3,
+	"path":     2,
+	"text":     0,
+}
+
+type binaryString struct {
+	String   string
+	Kind     string
+	Encoding string
+	Offset   int64
+}
+
+// Extract printable ASCII and UTF16 strings and rank them so the
+// strings most likely to show what the binary does are first. Only
+// the first max_strings are kept.
+func extractStrings(data []byte, min_length, max_strings int) []*binaryString {
+	var result []*binaryString
+	seen := make(map[string]bool)
+
+	add := func(value string, encoding string, offset int) {
+		if len(value) > MAX_STRING_LENGTH {
+			value = value[:MAX_STRING_LENGTH]
+		}
+		if seen[value] {
+			return
+		}
+		seen[value] = true
+		result = append(result, &binaryString{
+			String:   value,
+			Kind:     stringKind(value),
+			Encoding: encoding,
+			Offset:   int64(offset),
+		})
+	}
+
+	// ASCII strings.
+	start := -1
+	for i := 0; i <= len(data); i++ {
+		if i < len(data) && isPrintable(data[i]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && i-start >= min_length {
+			add(string(data[start:i]), "ascii", start)
+		}
+		start = -1
+	}
+
+	// UTF16 strings are printable characters separated by zero
+	// bytes. Both alignments are searched.
+	for align := 0; align < 2; align++ {
+		var units []uint16
+		start = -1
+		for i := align; ; i += 2 {
+			if i+1 < len(data) && isPrintable(data[i]) && data[i+1] == 0 {
+				if start < 0 {
+					start = i
+				}
+				units = append(units, uint16(data[i]))
+				continue
+			}
+			if start >= 0 && len(units) >= min_length {
+				add(string(utf16.Decode(units)), "utf16", start)
+			}
+			start = -1
+			units = units[:0]
+
+			if i+1 >= len(data) {
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		score_i := string_kind_scores[result[i].Kind]
+		score_j := string_kind_scores[result[j].Kind]
+		if score_i != score_j {
+			return score_i > score_j
+		}
+		return result[i].Offset < result[j].Offset
+	})
+
+	if len(result) > max_strings {
+		result = result[:max_strings]
+	}
+	return result
+}
+
+func isPrintable(c byte) bool {
+	return c == '\t' || (c >= 0x20 && c < 0x7f)
+}
+
+func stringKind(value string) string {
+	switch {
+	case binary_url_regex.MatchString(value):
+		return "url"
+	case binary_command_regex.MatchString(value):
+		return "command"
+	case binary_ip_regex.MatchString(value):
+		return "ip"
+	case binary_crypto_regex.MatchString(value):
+		return "crypto"
+	case binary_registry_regex.MatchString(value):
+		return "registry"
+	case binary_path_regex.MatchString(value):
+		return "path"
+	}
+	return "text"
+}
+
+// Imports which suggest a capability. Functions are matched by
+// prefix so the A, W and Ex variants are included.
+var import_capabilities = []struct {
+	capability string
+	functions  []string
+}{
+	{"injection", []string{"VirtualAllocEx", "WriteProcessMemory",
+		"CreateRemoteThread", "NtUnmapViewOfSection", "QueueUserAPC",
+		"SetThreadContext", "NtWriteVirtualMemory", "RtlCreateUserThread"}},
+	{"credentials", []string{"MiniDumpWriteDump", "LsaRetrievePrivateData",
+		"CredEnumerate", "CryptUnprotectData", "SamConnect"}},
+	{"keylogging", []string{"SetWindowsHookEx", "GetAsyncKeyState",
+		"GetKeyState", "GetForegroundWindow"}},
+	{"anti_analysis", []string{"IsDebuggerPresent",
+		"CheckRemoteDebuggerPresent", "NtQueryInformationProcess",
+		"OutputDebugString"}},
+	{"persistence", []string{"RegSetValue", "RegCreateKey", "CreateService",
+		"ChangeServiceConfig", "StartService"}},
+	{"privilege", []string{"AdjustTokenPrivileges", "OpenProcessToken",
+		"LookupPrivilegeValue", "ImpersonateLoggedOnUser", "DuplicateToken"}},
+	{"network", []string{"URLDownloadToFile", "InternetOpen",
+		"InternetConnect", "HttpSendRequest", "HttpOpenRequest", "WinHttp",
+		"WSAStartup", "connect", "send", "recv", "gethostbyname",
+		"getaddrinfo", "DnsQuery"}},
+	{"execution", []string{"CreateProcess", "ShellExecute", "WinExec",
+		"LoadLibrary", "GetProcAddress"}},
+	{"crypto", []string{"CryptEncrypt", "CryptDecrypt", "CryptGenKey",
+		"CryptAcquireContext", "BCryptEncrypt", "BCryptDecrypt"}},
+	{"discovery", []string{"CreateToolhelp32Snapshot", "Process32First",
+		"EnumProcesses", "GetComputerName", "GetUserName", "NetShareEnum",
+		"FindFirstFile"}},
+	{"registry", []string{"RegOpenKey", "RegQueryValue", "RegEnumKey",
+		"RegDeleteKey", "RegDeleteValue"}},
+}
+
+type binaryImport struct {
+	Import     string
+	Capability string
+}
+
+// Rank the PE's imports so those suggesting a capability come first,
+// in the order of import_capabilities.
+func rankImports(pe_file *pe.PEFile, max_imports int) []*binaryImport {
+	rank := func(capability string) int {
+		for idx, item := range import_capabilities {
+			if item.capability == capability {
+				return idx
+			}
+		}
+		return len(import_capabilities)
+	}
+
+	var result []*binaryImport
+	for _, name := range pe_file.Imports() {
+		result = append(result, &binaryImport{
+			Import:     name,
+			Capability: importCapability(name),
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return rank(result[i].Capability) < rank(result[j].Capability)
+	})
+
+	if len(result) > max_imports {
+		result = result[:max_imports]
+	}
+	return result
+}
+
+// Imports are named dll!function.
+func importCapability(name string) string {
+	idx := strings.LastIndex(name, "!")
+	function := name[idx+1:]
+
+	for _, item := range import_capabilities {
+		for _, prefix := range item.functions {
+			if !strings.HasPrefix(function, prefix) {
+				continue
+			}
+
+			// Short names like send must match exactly to avoid
+			// matching SendMessage etc.
+			rest := function[len(prefix):]
+			if rest == "" || rest == "A" || rest == "W" ||
+				strings.HasPrefix(rest, "Ex") ||
+				(len(prefix) > 8 && isUpper(rest[0])) {
+				return item.capability
+			}
+		}
+	}
+	return ""
+}
+
+func isUpper(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
+
+// Malformed files may panic the parser.
+func parsePE(data []byte) (result *pe.PEFile, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Unable to parse PE: %v", r)
+		}
+	}()
+
+	return pe.NewPEFileWithSize(bytes.NewReader(data), int64(len(data)))
+}
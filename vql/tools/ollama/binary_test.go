@@ -0,0 +1,100 @@
+package ollama
+
+import (
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinaryEncoder(t *testing.T) {
+	row := ordereddict.NewDict().
+		Set("Name", "ntuser.dat").
+		Set("Header", "regf\x00\x01\xff").
+		Set("Data", []byte{0x4d, 0x5a, 0x90, 0x00}).
+		Set("Nested", []interface{}{"ok", "\xfe\xff"})
+
+	encoder := &rowEncoder{binary: binaryEncoder{max_bytes: 2}}
+	added, err := encoder.Add(row)
+	require.NoError(t, err)
+	assert.True(t, added)
+	assert.Equal(t, `{"Name":"ntuser.dat",`+
+		`"Header":"[binary 7 bytes, hex] 7265 ...",`+
+		`"Data":"[binary 4 bytes, hex] 4d5a ...",`+
+		`"Nested":["ok","[binary 2 bytes, hex] feff"]}`, encoder.rows[0])
+
+	// The original row is not changed.
+	data, _ := row.Get("Data")
+	assert.Equal(t, []byte{0x4d, 0x5a, 0x90, 0x00}, data)
+
+	encoder = &rowEncoder{binary: binaryEncoder{encoding: BINARY_BASE64}}
+	_, err = encoder.Add(ordereddict.NewDict().Set("Data", []byte("\x00\x01")))
+	require.NoError(t, err)
+	assert.Equal(t, `{"Data":"[binary 2 bytes, base64] AAE="}`, encoder.rows[0])
+
+	encoder = &rowEncoder{binary: binaryEncoder{encoding: BINARY_OMIT}}
+	_, err = encoder.Add(ordereddict.NewDict().Set("Data", []byte("\x00\x01")))
+	require.NoError(t, err)
+	assert.Equal(t, `{"Data":"[binary 2 bytes]"}`, encoder.rows[0])
+
+	// Text is left alone.
+	assert.False(t, isBinary("Line 1\n\tCafé"))
+	assert.Error(t, validateBinaryEncoding("rot13"))
+
+	// Text hiding instructions from the analyst is cleaned.
+	assert.Equal(t, "evil.exe", cleanText("\x1b[31mevil\x1b[0m.exe"))
+	assert.Equal(t, "invoicegpj.exe", cleanText("invoice\u202egpj.exe"))
+	assert.Equal(t, "Caf\u00e9", cleanText("Cafe\u0301"))
+	assert.Equal(t, "ab", cleanText("a\x00b"))
+
+	encoder = &rowEncoder{keep_input: true}
+	_, err = encoder.Add(ordereddict.NewDict().Set("Name", "\x1b]0;title\x07x.exe"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"Name":"x.exe"}`, encoder.rows[0])
+
+	input := encoder.inputs[0].(*ordereddict.Dict)
+	name, _ := input.GetString("Name")
+	assert.Equal(t, "\x1b]0;title\x07x.exe", name)
+
+	// Long strings are truncated with a marker, without splitting
+	// a character.
+	encoder = &rowEncoder{binary: binaryEncoder{max_field_len: 6}}
+	_, err = encoder.Add(ordereddict.NewDict().
+		Set("Script", "Invoke-Expression").
+		Set("Nested", []interface{}{"aéééé"}).
+		Set("Short", "ok"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"Script":"Invoke ...[11 bytes truncated]",`+
+		`"Nested":["aéé ...[4 bytes truncated]"],"Short":"ok"}`, encoder.rows[0])
+}
+
+func TestExtractStrings(t *testing.T) {
+	data := []byte("\x00\x01short\x00plain text here\x00\xff" +
+		"C:\\Windows\\evil.dll\x00\x00" +
+		"h\x00t\x00t\x00p\x00:\x00/\x00/\x00c\x002\x00.\x00x\x00\x00\x00")
+
+	result := extractStrings(data, 6, 10)
+	require.Equal(t, 3, len(result))
+
+	// Links are ranked first, then paths and other text.
+	assert.Equal(t, "http://c2.x", result[0].String)
+	assert.Equal(t, "url", result[0].Kind)
+	assert.Equal(t, "utf16", result[0].Encoding)
+
+	assert.Equal(t, "C:\\Windows\\evil.dll", result[1].String)
+	assert.Equal(t, "path", result[1].Kind)
+
+	assert.Equal(t, "plain text here", result[2].String)
+	assert.Equal(t, int64(8), result[2].Offset)
+
+	assert.Equal(t, 1, len(extractStrings(data, 6, 1)))
+}
+
+func TestImportCapability(t *testing.T) {
+	assert.Equal(t, "injection", importCapability("kernel32.dll!WriteProcessMemory"))
+	assert.Equal(t, "execution", importCapability("kernel32.dll!CreateProcessAsUserW"))
+	assert.Equal(t, "network", importCapability("ws2_32.dll!send"))
+	assert.Equal(t, "", importCapability("user32.dll!SendMessageW"))
+	assert.Equal(t, "", importCapability("ws2_32.dll!sendto_other"))
+}
@@ -0,0 +1,277 @@
+package ollama
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/accessors"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/json"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	DEFAULT_MAX_BINARY_SIZE = 32 * 1024 * 1024
+
+	BINARY_PROMPT = "Assess the capabilities of this executable from " +
+		"its imports and strings. Base the assessment only on the " +
+		"facts below and do not assume anything which is not shown. " +
+		VERDICT_INSTRUCTIONS + " Capabilities lists what the " +
+		"executable can do with the imports or strings showing it " +
+		"and Summary describes the executable in a sentence or two."
+)
+
+var binary_assessment_schema = verdictSchema(ordereddict.NewDict().
+	Set("Capabilities", ordereddict.NewDict().
+		Set("type", "array").
+		Set("items", ordereddict.NewDict().Set("type", "string"))).
+	Set("Summary", ordereddict.NewDict().
+		Set("type", "string")),
+	"Capabilities", "Summary")
+
+type OllamaBinaryPluginArgs struct {
+	File       *accessors.OSPath `vfilter:"required,field=file,doc=The executable to analyse."`
+	Accessor   string            `vfilter:"optional,field=accessor,doc=The accessor used to read file (default auto)."`
+	Model      string            `vfilter:"optional,field=model,doc=The model to use (e.g. llama3). Defaults to AI.default_model in the config."`
+	Prompt     string            `vfilter:"optional,field=prompt,doc=Additional instructions for the assessment."`
+	MinLength  int64             `vfilter:"optional,field=min_length,doc=The shortest string extracted (default 6)."`
+	MaxStrings int64             `vfilter:"optional,field=max_strings,doc=The most strings sent to the model, highest ranked first (default 100)."`
+	MaxImports int64             `vfilter:"optional,field=max_imports,doc=The most imports sent to the model, highest ranked first (default 100)."`
+	MaxSize    int64             `vfilter:"optional,field=max_size,doc=Only this many bytes of the file are read (default 32mb)."`
+	BaseUrl    string            `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server. Several comma separated URLs, or srv://name for the servers of a DNS SRV record, spread the calls over the servers."`
+	Timeout    int64             `vfilter:"optional,field=timeout,doc=Seconds the call may take (default 3600)."`
+	Options    *ordereddict.Dict `vfilter:"optional,field=options,doc=Model options (e.g. temperature, num_ctx)."`
+	KeepAlive  string            `vfilter:"optional,field=keep_alive,doc=How long the model stays loaded after the call (e.g. 5m)."`
+}
+
+// The facts about an executable the model is shown.
+type binaryFacts struct {
+	Size      int64
+	SHA256    string
+	Truncated bool
+	Type      string
+	PDB       string
+	ImpHash   string
+	Imports   []*binaryImport
+	Exports   []string
+	Strings   []*binaryString
+}
+
+// Extracts and ranks an executable's strings and imports and asks
+// the model to assess its capabilities. The extraction happens
+// locally so only the ranked facts are sent, not the binary.
+type OllamaBinaryPlugin struct{}
+
+func (self OllamaBinaryPlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("ollama_binary", args)()
+
+		err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+		if err != nil {
+			scope.Log("ollama_binary: %v", err)
+			return
+		}
+
+		arg := &OllamaBinaryPluginArgs{}
+		err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+		if err != nil {
+			scope.Log("ollama_binary: %v", err)
+			return
+		}
+
+		if arg.MinLength <= 0 {
+			arg.MinLength = DEFAULT_MIN_STRING_LENGTH
+		}
+
+		if arg.MaxStrings <= 0 {
+			arg.MaxStrings = DEFAULT_MAX_STRINGS
+		}
+
+		if arg.MaxImports <= 0 {
+			arg.MaxImports = DEFAULT_MAX_IMPORTS
+		}
+
+		if arg.MaxSize <= 0 {
+			arg.MaxSize = DEFAULT_MAX_BINARY_SIZE
+		}
+
+		facts, err := readBinary(scope, arg)
+		if err != nil {
+			scope.Log("ollama_binary: %v", err)
+			return
+		}
+
+		client, err := NewClient(ctx, scope, arg.BaseUrl)
+		if err != nil {
+			scope.Log("ollama_binary: %v", err)
+			return
+		}
+
+		arg.Model, err = client.Model(arg.Model)
+		if err != nil {
+			scope.Log("ollama_binary: %v", err)
+			return
+		}
+
+		client = client.WithTimeouts(Timeouts{
+			Total: time.Duration(arg.Timeout) * time.Second,
+		})
+		generate := logGenerate(scope, client, client.Generate)
+
+		row := ordereddict.NewDict().
+			Set("File", arg.File).
+			Set("Size", facts.Size).
+			Set("SHA256", facts.SHA256).
+			Set("Type", facts.Type).
+			Set("ImpHash", facts.ImpHash).
+			Set("Imports", facts.Imports).
+			Set("Strings", facts.Strings)
+
+		prompt := BINARY_PROMPT
+		if arg.Prompt != "" {
+			prompt += "\n\n" + arg.Prompt
+		}
+
+		assessment, err := self.assess(ctx, scope, arg, prompt, facts, generate)
+		if err != nil {
+			scope.Log("ollama_binary: %v", err)
+			setVerdictError(row, arg.Model, prompt)
+			row.Set("Capabilities", vfilter.Null{}).
+				Set("Summary", vfilter.Null{}).
+				Set("Error", err.Error())
+
+		} else {
+			setVerdictColumns(row, assessment, arg.Model, prompt)
+			capabilities, _ := assessment.Get("Capabilities")
+			summary, _ := assessment.Get("Summary")
+			row.Set("Capabilities", capabilities).
+				Set("Summary", summary)
+		}
+
+		select {
+		case <-ctx.Done():
+		case output_chan <- row:
+		}
+	}()
+
+	return output_chan
+}
+
+func (self OllamaBinaryPlugin) assess(ctx context.Context,
+	scope vfilter.Scope, arg *OllamaBinaryPluginArgs, prompt string,
+	facts *binaryFacts, generate generateFunc) (*ordereddict.Dict, error) {
+	serialized, err := json.MarshalIndent(facts)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &GenerateRequest{
+		Model:     arg.Model,
+		Prompt:    prompt + "\n\n" + string(serialized),
+		Format:    binary_assessment_schema,
+		Options:   arg.Options,
+		KeepAlive: arg.KeepAlive,
+	}
+
+	response, err := generateText(ctx, generate, req)
+	if err != nil {
+		return nil, err
+	}
+
+	validator, err := newResponseValidator(binary_assessment_schema, true)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := validator.Repair(ctx, scope, generate, req,
+		response, nil, DEFAULT_REPAIR_ATTEMPTS)
+	if err != nil {
+		return nil, err
+	}
+
+	assessment, ok := result.Parsed.(*ordereddict.Dict)
+	if !ok || len(result.Errors) > 0 {
+		return nil, fmt.Errorf("Invalid assessment: %v",
+			strings.Join(result.Errors, "; "))
+	}
+	return assessment, nil
+}
+
+func readBinary(scope vfilter.Scope,
+	arg *OllamaBinaryPluginArgs) (*binaryFacts, error) {
+	err := vql_subsystem.CheckFilesystemAccess(scope, arg.Accessor)
+	if err != nil {
+		return nil, err
+	}
+
+	accessor, err := accessors.GetAccessor(arg.Accessor, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := accessor.OpenWithOSPath(arg.File)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open %v: %w", arg.File, err)
+	}
+	defer fd.Close()
+
+	data, err := io.ReadAll(io.LimitReader(fd, arg.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &binaryFacts{Type: "unknown"}
+	if int64(len(data)) > arg.MaxSize {
+		data = data[:arg.MaxSize]
+		result.Truncated = true
+	}
+
+	sum := sha256.Sum256(data)
+	result.Size = int64(len(data))
+	result.SHA256 = hex.EncodeToString(sum[:])
+	result.Strings = extractStrings(data, int(arg.MinLength),
+		int(arg.MaxStrings))
+
+	// Other formats are only assessed from their strings.
+	pe_file, err := parsePE(data)
+	if err == nil {
+		result.Type = "PE"
+		result.PDB = pe_file.PDB
+		result.ImpHash = pe_file.ImpHash()
+		result.Imports = rankImports(pe_file, int(arg.MaxImports))
+		result.Exports = pe_file.Exports()
+		if int64(len(result.Exports)) > arg.MaxImports {
+			result.Exports = result.Exports[:arg.MaxImports]
+		}
+	}
+
+	return result, nil
+}
+
+func (self OllamaBinaryPlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name:     "ollama_binary",
+		Doc:      "Extract and rank an executable's strings and imports and ask a model to assess its capabilities.",
+		ArgType:  type_map.AddType(scope, &OllamaBinaryPluginArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterPlugin(&OllamaBinaryPlugin{})
+}
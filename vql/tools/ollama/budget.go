@@ -0,0 +1,110 @@
+package ollama
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+// Limits the resources an agent run may consume. A zero limit means
+// unlimited.
+type Budget struct {
+	mu sync.Mutex
+
+	max_total_tokens int64
+	max_calls        int64
+	max_wall_time    time.Duration
+
+	start             time.Time
+	model_calls       int64
+	tool_calls        int64
+	prompt_tokens     int64
+	completion_tokens int64
+
+	// Set once a limit is hit.
+	exceeded string
+}
+
+func NewBudget(max_total_tokens, max_calls int64,
+	max_wall_time time.Duration) *Budget {
+	return &Budget{
+		max_total_tokens: max_total_tokens,
+		max_calls:        max_calls,
+		max_wall_time:    max_wall_time,
+		start:            utils.GetTime().Now(),
+	}
+}
+
+func (self *Budget) AddModelCall(stats *Stats) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.model_calls++
+	if stats != nil {
+		self.prompt_tokens += stats.PromptEvalCount
+		self.completion_tokens += stats.EvalCount
+	}
+
+	total := self.prompt_tokens + self.completion_tokens
+	if self.max_total_tokens > 0 && total >= self.max_total_tokens &&
+		self.exceeded == "" {
+		self.exceeded = fmt.Sprintf("max_total_tokens (%v) reached",
+			self.max_total_tokens)
+	}
+}
+
+// Reserve a tool call. Returns an error if the budget does not allow
+// it.
+func (self *Budget) StartToolCall() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.max_calls > 0 && self.tool_calls >= self.max_calls {
+		if self.exceeded == "" {
+			self.exceeded = fmt.Sprintf("max_calls (%v) reached", self.max_calls)
+		}
+		return fmt.Errorf("Budget exceeded: %v", self.exceeded)
+	}
+	self.tool_calls++
+	return nil
+}
+
+func (self *Budget) WallTimeExceeded() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.exceeded == "" {
+		self.exceeded = fmt.Sprintf("max_wall_time (%v) reached",
+			self.max_wall_time)
+	}
+}
+
+// Returns an error once any limit has been reached.
+func (self *Budget) Check() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.exceeded != "" {
+		return fmt.Errorf("Budget exceeded: %v", self.exceeded)
+	}
+	return nil
+}
+
+// The final accounting row.
+func (self *Budget) Row() *ordereddict.Dict {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return ordereddict.NewDict().
+		Set("Type", "Accounting").
+		Set("ModelCalls", self.model_calls).
+		Set("ToolCalls", self.tool_calls).
+		Set("PromptTokens", self.prompt_tokens).
+		Set("CompletionTokens", self.completion_tokens).
+		Set("TotalTokens", self.prompt_tokens+self.completion_tokens).
+		Set("Duration", utils.GetTime().Now().Sub(self.start).Seconds()).
+		Set("BudgetExceeded", self.exceeded)
+}
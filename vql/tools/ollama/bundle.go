@@ -0,0 +1,674 @@
+package ollama
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	artifacts_proto "www.velocidex.com/golang/velociraptor/artifacts/proto"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	crypto_utils "www.velocidex.com/golang/velociraptor/crypto/utils"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+const (
+	BUNDLE_VERSION = 1
+
+	BUNDLE_MANIFEST    = "manifest.json"
+	BUNDLE_SIGNATURE   = "manifest.sig"
+	BUNDLE_CERTIFICATE = "signer.pem"
+
+	DEFAULT_OLLAMA_REGISTRY  = "registry.ollama.ai"
+	DEFAULT_OLLAMA_NAMESPACE = "library"
+	DEFAULT_OLLAMA_TAG       = "latest"
+
+	OLLAMA_MEDIA_MODEL    = "application/vnd.ollama.image.model"
+	OLLAMA_MEDIA_TEMPLATE = "application/vnd.ollama.image.template"
+	OLLAMA_MEDIA_SYSTEM   = "application/vnd.ollama.image.system"
+	OLLAMA_MEDIA_PARAMS   = "application/vnd.ollama.image.params"
+	OLLAMA_MEDIA_LICENSE  = "application/vnd.ollama.image.license"
+
+	// Template, system and parameter layers are small.
+	MAX_BUNDLE_LAYER = 1024 * 1024
+)
+
+// A bundle carries models, prompts and AI artifacts from a connected
+// staging deployment to an offline one. It is a zip file holding the
+// model weights, prompt files and artifact definitions, and a
+// manifest listing the digest of each. The manifest is signed with
+// the staging server's key so the offline server can check the bundle
+// came from it and was not changed on the way.
+type BundleManifest struct {
+	Version   int            `json:"version"`
+	Created   time.Time      `json:"created"`
+	Models    []*BundleModel `json:"models,omitempty"`
+	Prompts   []*BundleFile  `json:"prompts,omitempty"`
+	Artifacts []*BundleFile  `json:"artifacts,omitempty"`
+}
+
+// A model is recreated from its weights and the settings kept in the
+// other layers of its Ollama manifest.
+type BundleModel struct {
+	Name       string            `json:"name"`
+	Weights    *BundleFile       `json:"weights"`
+	Template   string            `json:"template,omitempty"`
+	System     string            `json:"system,omitempty"`
+	Parameters *ordereddict.Dict `json:"parameters,omitempty"`
+}
+
+type BundleFile struct {
+	// The name of the file inside the bundle.
+	Name   string `json:"name"`
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+type BundleExportOptions struct {
+	// The models to export by their Ollama name (e.g. llama3:8b).
+	Models []string
+
+	// The Ollama model store holding the models (default
+	// $OLLAMA_MODELS or ~/.ollama/models).
+	ModelsDir string
+
+	// A directory of prompt files which is exported as a whole.
+	PromptsDir string
+
+	// AI artifacts exported with their definitions.
+	Artifacts []*artifacts_proto.Artifact
+}
+
+// The manifests and layers of models in the Ollama model store.
+type ollamaManifest struct {
+	Layers []*ollamaLayer `json:"layers"`
+}
+
+type ollamaLayer struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// Writes a signed bundle to out. The bundle is signed with the
+// frontend key of config_obj.
+func ExportBundle(ctx context.Context, config_obj *config_proto.Config,
+	out io.Writer, options *BundleExportOptions) (*BundleManifest, error) {
+	if config_obj.Frontend == nil || config_obj.Frontend.PrivateKey == "" {
+		return nil, errors.New("Bundles are signed with the server's key which is not in the config")
+	}
+
+	private_key, err := crypto_utils.ParseRsaPrivateKeyFromPemStr(
+		[]byte(config_obj.Frontend.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+
+	models_dir := options.ModelsDir
+	if models_dir == "" {
+		models_dir, err = defaultModelsDir()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	manifest := &BundleManifest{
+		Version: BUNDLE_VERSION,
+		Created: utils.GetTime().Now().UTC(),
+	}
+
+	writer := zip.NewWriter(out)
+
+	// Models sharing weights only carry them once.
+	blobs := make(map[string]*BundleFile)
+	for _, name := range options.Models {
+		model, err := exportModel(ctx, writer, models_dir, name, blobs)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to export %v: %w", name, err)
+		}
+		manifest.Models = append(manifest.Models, model)
+	}
+
+	if options.PromptsDir != "" {
+		manifest.Prompts, err = exportPrompts(ctx, writer, options.PromptsDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, artifact := range options.Artifacts {
+		file, err := writeBundleFile(ctx, writer,
+			path.Join("artifacts", artifact.Name+".yaml"),
+			strings.NewReader(artifact.Raw), zip.Deflate)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Artifacts = append(manifest.Artifacts, file)
+	}
+
+	serialized, err := json.MarshalIndent(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	hashed := sha256.Sum256(serialized)
+	signature, err := rsa.SignPKCS1v15(
+		rand.Reader, private_key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range []struct {
+		name string
+		data []byte
+	}{
+		{BUNDLE_MANIFEST, serialized},
+		{BUNDLE_SIGNATURE, signature},
+		{BUNDLE_CERTIFICATE, []byte(config_obj.Frontend.Certificate)},
+	} {
+		fd, err := writer.Create(member.name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = fd.Write(member.data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return manifest, writer.Close()
+}
+
+func exportModel(ctx context.Context, writer *zip.Writer,
+	models_dir, name string, blobs map[string]*BundleFile) (*BundleModel, error) {
+	manifest_path, err := modelManifestPath(models_dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(manifest_path)
+	if err != nil {
+		return nil, fmt.Errorf("Model not found in %v: %w", models_dir, err)
+	}
+
+	manifest := &ollamaManifest{}
+	err = json.Unmarshal(data, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid model manifest %v: %w", manifest_path, err)
+	}
+
+	model := &BundleModel{Name: name}
+	for _, layer := range manifest.Layers {
+		blob_path := filepath.Join(models_dir, "blobs",
+			strings.Replace(layer.Digest, ":", "-", 1))
+
+		switch layer.MediaType {
+		case OLLAMA_MEDIA_MODEL:
+			if model.Weights != nil {
+				return nil, errors.New("Models with more than one set of weights can not be bundled")
+			}
+
+			weights, pres := blobs[layer.Digest]
+			if pres {
+				model.Weights = weights
+				continue
+			}
+
+			fd, err := os.Open(blob_path)
+			if err != nil {
+				return nil, err
+			}
+
+			// Model weights do not compress well.
+			model.Weights, err = writeBundleFile(ctx, writer,
+				path.Join("blobs", path.Base(blob_path)), fd, zip.Store)
+			fd.Close()
+			if err != nil {
+				return nil, err
+			}
+
+			if model.Weights.Digest != layer.Digest {
+				return nil, fmt.Errorf("Blob %v does not match its digest", blob_path)
+			}
+			blobs[layer.Digest] = model.Weights
+
+		case OLLAMA_MEDIA_TEMPLATE, OLLAMA_MEDIA_SYSTEM, OLLAMA_MEDIA_PARAMS:
+			data, err := readLayer(blob_path)
+			if err != nil {
+				return nil, err
+			}
+
+			switch layer.MediaType {
+			case OLLAMA_MEDIA_TEMPLATE:
+				model.Template = string(data)
+			case OLLAMA_MEDIA_SYSTEM:
+				model.System = string(data)
+			default:
+				model.Parameters = ordereddict.NewDict()
+				err = json.Unmarshal(data, model.Parameters)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid parameters in %v: %w", blob_path, err)
+				}
+			}
+
+		case OLLAMA_MEDIA_LICENSE:
+			// The license stays with the staging copy.
+
+		default:
+			return nil, fmt.Errorf("Layers of type %v can not be bundled", layer.MediaType)
+		}
+	}
+
+	if model.Weights == nil {
+		return nil, errors.New("The model has no weights")
+	}
+
+	return model, nil
+}
+
+func readLayer(blob_path string) ([]byte, error) {
+	fd, err := os.Open(blob_path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	return io.ReadAll(io.LimitReader(fd, MAX_BUNDLE_LAYER))
+}
+
+func exportPrompts(ctx context.Context, writer *zip.Writer,
+	prompts_dir string) ([]*BundleFile, error) {
+	var result []*BundleFile
+
+	err := filepath.Walk(prompts_dir,
+		func(file_path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			relative, err := filepath.Rel(prompts_dir, file_path)
+			if err != nil {
+				return err
+			}
+
+			fd, err := os.Open(file_path)
+			if err != nil {
+				return err
+			}
+			defer fd.Close()
+
+			file, err := writeBundleFile(ctx, writer,
+				path.Join("prompts", filepath.ToSlash(relative)), fd, zip.Deflate)
+			if err != nil {
+				return err
+			}
+			result = append(result, file)
+			return nil
+		})
+	return result, err
+}
+
+func writeBundleFile(ctx context.Context, writer *zip.Writer,
+	name string, reader io.Reader, method uint16) (*BundleFile, error) {
+	fd, err := writer.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   method,
+		Modified: utils.GetTime().Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := sha256.New()
+	size, err := utils.Copy(ctx, io.MultiWriter(fd, hasher), reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BundleFile{
+		Name:   name,
+		Digest: "sha256:" + hex.EncodeToString(hasher.Sum(nil)),
+		Size:   int64(size),
+	}, nil
+}
+
+// Returns the manifest file of a model in the Ollama model store.
+// Names are completed the same way Ollama does: llama3 is
+// registry.ollama.ai/library/llama3:latest.
+func modelManifestPath(models_dir, name string) (string, error) {
+	tag := DEFAULT_OLLAMA_TAG
+	idx := strings.LastIndex(name, ":")
+	if idx > strings.LastIndex(name, "/") {
+		name, tag = name[:idx], name[idx+1:]
+	}
+
+	parts := strings.Split(name, "/")
+	switch len(parts) {
+	case 1:
+		parts = []string{DEFAULT_OLLAMA_REGISTRY, DEFAULT_OLLAMA_NAMESPACE, parts[0]}
+	case 2:
+		parts = []string{DEFAULT_OLLAMA_REGISTRY, parts[0], parts[1]}
+	case 3:
+	default:
+		return "", fmt.Errorf("Invalid model name %v", name)
+	}
+
+	parts = append(parts, tag)
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." ||
+			strings.ContainsAny(part, `/\`) {
+			return "", fmt.Errorf("Invalid model name %v", name)
+		}
+	}
+
+	return filepath.Join(append([]string{models_dir, "manifests"}, parts...)...), nil
+}
+
+func defaultModelsDir() (string, error) {
+	models_dir := os.Getenv("OLLAMA_MODELS")
+	if models_dir != "" {
+		return models_dir, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ollama", "models"), nil
+}
+
+// An opened bundle whose manifest has been verified. The files are
+// checked against the manifest as they are read.
+type Bundle struct {
+	Manifest *BundleManifest
+
+	// The subject of the certificate which signed the bundle.
+	Signer string
+
+	fd    *os.File
+	files map[string]*zip.File
+}
+
+// Opens a bundle and verifies its signature. The bundle must be
+// signed by trusted_pem if given, otherwise by a server certificate
+// issued by the CA of config_obj.
+func OpenBundle(config_obj *config_proto.Config,
+	filename string, trusted_pem []byte) (*Bundle, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle, err := openBundle(config_obj, fd, trusted_pem)
+	if err != nil {
+		fd.Close()
+		return nil, fmt.Errorf("Invalid bundle %v: %w", filename, err)
+	}
+	return bundle, nil
+}
+
+func openBundle(config_obj *config_proto.Config,
+	fd *os.File, trusted_pem []byte) (*Bundle, error) {
+	stat, err := fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := zip.NewReader(fd, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	self := &Bundle{
+		fd:    fd,
+		files: make(map[string]*zip.File),
+	}
+	for _, file := range reader.File {
+		self.files[file.Name] = file
+	}
+
+	serialized, err := self.readMember(BUNDLE_MANIFEST)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := self.readMember(BUNDLE_SIGNATURE)
+	if err != nil {
+		return nil, err
+	}
+
+	signer_pem, err := self.readMember(BUNDLE_CERTIFICATE)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := verifyBundleSigner(config_obj, signer_pem, trusted_pem)
+	if err != nil {
+		return nil, err
+	}
+
+	public_key, ok := signer.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("The signer's key is not an RSA key")
+	}
+
+	hashed := sha256.Sum256(serialized)
+	err = rsa.VerifyPKCS1v15(public_key, crypto.SHA256, hashed[:], signature)
+	if err != nil {
+		return nil, fmt.Errorf("Bad signature: %w", err)
+	}
+
+	self.Manifest = &BundleManifest{}
+	err = json.Unmarshal(serialized, self.Manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	if self.Manifest.Version != BUNDLE_VERSION {
+		return nil, fmt.Errorf("Unsupported bundle version %v",
+			self.Manifest.Version)
+	}
+
+	self.Signer = crypto_utils.GetSubjectName(signer)
+	return self, nil
+}
+
+func verifyBundleSigner(config_obj *config_proto.Config,
+	signer_pem, trusted_pem []byte) (*x509.Certificate, error) {
+	signer, err := crypto_utils.ParseX509CertFromPemStr(signer_pem)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(trusted_pem) > 0 {
+		trusted, err := crypto_utils.ParseX509CertFromPemStr(trusted_pem)
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(trusted.Raw, signer.Raw) {
+			return nil, fmt.Errorf("Signed by %v which is not the trusted certificate",
+				crypto_utils.GetSubjectName(signer))
+		}
+		return signer, nil
+	}
+
+	// Without a trusted certificate only the server's own
+	// certificate is accepted: it must be issued by the CA for
+	// server use and carry the pinned server name, like the
+	// certificate clients accept from the server.
+	if config_obj.Client == nil || config_obj.Client.CaCertificate == "" {
+		return nil, errors.New("No CA in the config to verify the signer with, a trusted certificate must be given")
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(config_obj.Client.CaCertificate)) {
+		return nil, errors.New("Unable to parse the CA certificate")
+	}
+
+	_, err = signer.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: utils.GetTime().Now(),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Signer %v is not trusted: %w",
+			crypto_utils.GetSubjectName(signer), err)
+	}
+
+	pinned_name := utils.GetSuperuserName(config_obj)
+	signer_name := crypto_utils.GetSubjectName(signer)
+	if signer_name != pinned_name {
+		return nil, fmt.Errorf("Signed by %v which is not the server %v, a trusted certificate must be given",
+			signer_name, pinned_name)
+	}
+	return signer, nil
+}
+
+func (self *Bundle) readMember(name string) ([]byte, error) {
+	file, pres := self.files[name]
+	if !pres {
+		return nil, fmt.Errorf("%v is missing", name)
+	}
+
+	fd, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	return io.ReadAll(io.LimitReader(fd, MAX_BUNDLE_LAYER))
+}
+
+// Opens a file listed in the manifest. The reader fails at the end if
+// the content does not match the manifest.
+func (self *Bundle) Open(file *BundleFile) (io.ReadCloser, error) {
+	member, pres := self.files[file.Name]
+	if !pres {
+		return nil, fmt.Errorf("%v is missing from the bundle", file.Name)
+	}
+
+	fd, err := member.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	return &verifyingReader{
+		ReadCloser: fd,
+		file:       file,
+		hasher:     sha256.New(),
+	}, nil
+}
+
+// Reads a small file listed in the manifest, such as a prompt.
+func (self *Bundle) ReadFile(file *BundleFile) ([]byte, error) {
+	fd, err := self.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	return io.ReadAll(fd)
+}
+
+// Writes a file listed in the manifest below dir. The name of the
+// file inside the bundle is kept without its top directory.
+func (self *Bundle) Extract(file *BundleFile, dir string) (string, error) {
+	_, relative, _ := strings.Cut(file.Name, "/")
+	if !filepath.IsLocal(relative) {
+		return "", fmt.Errorf("Invalid file name %v in bundle", file.Name)
+	}
+
+	data, err := self.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(dir, filepath.FromSlash(relative))
+	err = os.MkdirAll(filepath.Dir(target), 0700)
+	if err != nil {
+		return "", err
+	}
+
+	return target, os.WriteFile(target, data, 0600)
+}
+
+// Creates a model from the bundle on the server of client. The
+// weights are only uploaded if the server does not already have them.
+func (self *Bundle) ImportModel(ctx context.Context, client *Client,
+	model *BundleModel, cb func(resp *ProgressResponse) error) error {
+	digest := model.Weights.Digest
+
+	present, err := client.HasBlob(ctx, digest)
+	if err != nil {
+		return err
+	}
+
+	if !present {
+		fd, err := self.Open(model.Weights)
+		if err != nil {
+			return err
+		}
+
+		err = client.PushBlob(ctx, digest, fd, model.Weights.Size)
+		fd.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return client.Create(ctx, &CreateRequest{
+		Model:      model.Name,
+		Files:      map[string]string{"model.gguf": digest},
+		System:     model.System,
+		Template:   model.Template,
+		Parameters: model.Parameters,
+		Stream:     true,
+	}, cb)
+}
+
+func (self *Bundle) Close() error {
+	return self.fd.Close()
+}
+
+type verifyingReader struct {
+	io.ReadCloser
+	file   *BundleFile
+	hasher hash.Hash
+	size   int64
+}
+
+func (self *verifyingReader) Read(buf []byte) (int, error) {
+	n, err := self.ReadCloser.Read(buf)
+	self.hasher.Write(buf[:n])
+	self.size += int64(n)
+
+	if errors.Is(err, io.EOF) {
+		digest := "sha256:" + hex.EncodeToString(self.hasher.Sum(nil))
+		if digest != self.file.Digest || self.size != self.file.Size {
+			return n, fmt.Errorf("%v does not match the bundle manifest",
+				self.file.Name)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,150 @@
+package ollama
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	artifacts_proto "www.velocidex.com/golang/velociraptor/artifacts/proto"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/vtesting/assert"
+)
+
+func (self *OllamaTestSuite) TestBundle() {
+	dir := self.T().TempDir()
+	models_dir := filepath.Join(dir, "models")
+	prompts_dir := filepath.Join(dir, "prompts")
+
+	// A model in the Ollama model store.
+	layers := []*ollamaLayer{}
+	for _, layer := range []struct{ media_type, data string }{
+		{OLLAMA_MEDIA_MODEL, "GGUF weights"},
+		{OLLAMA_MEDIA_SYSTEM, "You are a DFIR analyst"},
+		{OLLAMA_MEDIA_PARAMS, `{"temperature":0.2}`},
+		{OLLAMA_MEDIA_LICENSE, "License"},
+	} {
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(layer.data)))
+		blob := filepath.Join(models_dir, "blobs", strings.Replace(digest, ":", "-", 1))
+		assert.NoError(self.T(), os.MkdirAll(filepath.Dir(blob), 0700))
+		assert.NoError(self.T(), os.WriteFile(blob, []byte(layer.data), 0600))
+		layers = append(layers, &ollamaLayer{MediaType: layer.media_type,
+			Digest: digest, Size: int64(len(layer.data))})
+	}
+
+	manifest_path, err := modelManifestPath(models_dir, "llama3")
+	assert.NoError(self.T(), err)
+	assert.NoError(self.T(), os.MkdirAll(filepath.Dir(manifest_path), 0700))
+	assert.NoError(self.T(), os.WriteFile(manifest_path,
+		[]byte(json.MustMarshalString(&ollamaManifest{Layers: layers})), 0600))
+
+	assert.NoError(self.T(), os.MkdirAll(filepath.Join(prompts_dir, "triage"), 0700))
+	assert.NoError(self.T(), os.WriteFile(
+		filepath.Join(prompts_dir, "triage", "processes.txt"),
+		[]byte("Which processes are suspicious?"), 0600))
+
+	// Export a bundle signed by the test server.
+	bundle_path := filepath.Join(dir, "bundle.zip")
+	fd, err := os.Create(bundle_path)
+	assert.NoError(self.T(), err)
+
+	_, err = ExportBundle(self.Ctx, self.ConfigObj, fd, &BundleExportOptions{
+		Models:     []string{"llama3"},
+		ModelsDir:  models_dir,
+		PromptsDir: prompts_dir,
+		Artifacts: []*artifacts_proto.Artifact{{
+			Name: "Custom.AI.Triage", Raw: "name: Custom.AI.Triage\n"}},
+	})
+	assert.NoError(self.T(), err)
+	assert.NoError(self.T(), fd.Close())
+
+	// The signer's certificate is issued by the CA.
+	bundle, err := OpenBundle(self.ConfigObj, bundle_path, nil)
+	assert.NoError(self.T(), err)
+	defer bundle.Close()
+
+	assert.Equal(self.T(), "VelociraptorServer", bundle.Signer)
+	assert.Equal(self.T(), 1, len(bundle.Manifest.Models))
+	assert.Equal(self.T(), "prompts/triage/processes.txt",
+		bundle.Manifest.Prompts[0].Name)
+
+	// Models are created from their weights and settings.
+	client := &Client{
+		base_url:      self.server.URL,
+		client:        &http.Client{},
+		settings:      &config_proto.AIConfig{},
+		circuit_state: getCircuitState(self.server.URL),
+		pins:          newPinCache(),
+	}
+
+	model := bundle.Manifest.Models[0]
+	err = bundle.ImportModel(self.Ctx, client, model,
+		func(resp *ProgressResponse) error { return nil })
+	assert.NoError(self.T(), err)
+
+	assert.Equal(self.T(), []byte("GGUF weights"), self.blobs[layers[0].Digest])
+	assert.Equal(self.T(), 1, len(self.create_requests))
+	assert.Equal(self.T(), "You are a DFIR analyst", self.create_requests[0].System)
+	assert.Equal(self.T(), layers[0].Digest,
+		self.create_requests[0].Files["model.gguf"])
+
+	temperature, _ := self.create_requests[0].Parameters.Get("temperature")
+	assert.Equal(self.T(), 0.2, temperature)
+
+	target, err := bundle.Extract(bundle.Manifest.Prompts[0],
+		filepath.Join(dir, "imported"))
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(),
+		filepath.Join(dir, "imported", "triage", "processes.txt"), target)
+
+	// Bundles signed by another certificate are refused.
+	_, err = OpenBundle(self.ConfigObj, bundle_path,
+		[]byte(self.ConfigObj.Client.CaCertificate))
+	assert.ErrorContains(self.T(), err, "not the trusted certificate")
+
+	// Without a trusted certificate the signer must be the pinned
+	// server, not just any certificate issued by the CA.
+	self.ConfigObj.Client.PinnedServerName = "OtherServer"
+	_, err = OpenBundle(self.ConfigObj, bundle_path, nil)
+	assert.ErrorContains(self.T(), err, "not the server OtherServer")
+
+	trusted, err := OpenBundle(self.ConfigObj, bundle_path,
+		[]byte(self.ConfigObj.Frontend.Certificate))
+	assert.NoError(self.T(), err)
+	trusted.Close()
+	self.ConfigObj.Client.PinnedServerName = ""
+
+	// So are bundles whose manifest was changed.
+	reader, err := zip.OpenReader(bundle_path)
+	assert.NoError(self.T(), err)
+	defer reader.Close()
+
+	tampered_path := filepath.Join(dir, "tampered.zip")
+	out, err := os.Create(tampered_path)
+	assert.NoError(self.T(), err)
+
+	writer := zip.NewWriter(out)
+	for _, file := range reader.File {
+		member, err := file.Open()
+		assert.NoError(self.T(), err)
+		data, _ := io.ReadAll(member)
+		member.Close()
+
+		if file.Name == BUNDLE_MANIFEST {
+			data = []byte(strings.Replace(string(data), "llama3", "llama4", 1))
+		}
+		w, err := writer.Create(file.Name)
+		assert.NoError(self.T(), err)
+		w.Write(data)
+	}
+	assert.NoError(self.T(), writer.Close())
+	assert.NoError(self.T(), out.Close())
+
+	_, err = OpenBundle(self.ConfigObj, tampered_path, nil)
+	assert.ErrorContains(self.T(), err, "Bad signature")
+}
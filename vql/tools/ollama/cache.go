@@ -0,0 +1,218 @@
+package ollama
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"github.com/Velocidex/ttlcache/v2"
+	"www.velocidex.com/golang/velociraptor/acls"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	DEFAULT_CACHE_TTL  = time.Hour
+	DEFAULT_CACHE_SIZE = 1000
+)
+
+var (
+	response_caches_mu sync.Mutex
+	response_caches    = make(map[string]*ResponseCache)
+)
+
+type generateFunc func(ctx context.Context, req *GenerateRequest,
+	cb func(resp *GenerateResponse) error) error
+
+type cachedResponse struct {
+	Response   string
+	Context    []int64
+	Logprobs   []*TokenLogprob
+	DoneReason string
+	Stats      Stats
+}
+
+// Caches model responses within an org so repeated enrichments of
+// the same data by any query, notebook or user are answered without
+// calling the model again.
+type ResponseCache struct {
+	mu       sync.Mutex
+	lru      *ttlcache.Cache
+	ttl      time.Duration
+	max_size int
+}
+
+func GetResponseCache(config_obj *config_proto.Config) *ResponseCache {
+	response_caches_mu.Lock()
+	defer response_caches_mu.Unlock()
+
+	result, pres := response_caches[config_obj.OrgId]
+	if !pres {
+		result = &ResponseCache{lru: ttlcache.NewCache()}
+
+		// Entries expire a fixed time after the response was made.
+		result.lru.SkipTTLExtensionOnHit(true)
+		result.Configure(DEFAULT_CACHE_TTL, DEFAULT_CACHE_SIZE)
+		response_caches[config_obj.OrgId] = result
+	}
+	return result
+}
+
+func (self *ResponseCache) Configure(ttl time.Duration, max_size int) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if ttl > 0 {
+		self.ttl = ttl
+		_ = self.lru.SetTTL(ttl)
+	}
+
+	if max_size > 0 {
+		self.max_size = max_size
+		self.lru.SetCacheSizeLimit(max_size)
+	}
+}
+
+func (self *ResponseCache) Flush() {
+	self.lru.Flush()
+}
+
+func (self *ResponseCache) Stats() *ordereddict.Dict {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	metrics := self.lru.GetMetrics()
+	return ordereddict.NewDict().
+		Set("Size", self.lru.Count()).
+		Set("MaxSize", self.max_size).
+		Set("TTL", self.ttl.Seconds()).
+		Set("Hits", metrics.Hits).
+		Set("Misses", metrics.Misses).
+		Set("Evicted", metrics.Evicted)
+}
+
+// The key covers everything which affects the response: the servers
+// and API the request is sent to, the model, prompts, options,
+// format and context.
+func requestKey(endpoint string, req *GenerateRequest) string {
+	key_req := *req
+	key_req.Stream = false
+	key_req.KeepAlive = ""
+
+	serialized, _ := json.Marshal(&key_req)
+	hash := sha256.New()
+	if endpoint != "" {
+		hash.Write([]byte(endpoint))
+		hash.Write([]byte{0})
+	}
+	hash.Write(serialized)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Answer the request from the cache, or call generate and cache its
+// response. A cached response is delivered as a single chunk with
+// the statistics of the call which made it. Responses are cached
+// for each endpoint - the base_url of the client - since different
+// servers may answer the same request differently. Hits are recorded
+// in the usage ledger of the client.
+func (self *ResponseCache) Generate(ctx context.Context,
+	client *Client, req *GenerateRequest,
+	cb func(resp *GenerateResponse) error, generate generateFunc) error {
+	key := requestKey(client.spec, req)
+
+	cached_any, err := self.lru.Get(key)
+	if err == nil {
+		cached, ok := cached_any.(*cachedResponse)
+		if ok {
+			client.usage.RecordCacheHit(ctx, req.Model)
+			return cb(&GenerateResponse{
+				Model:      req.Model,
+				Response:   cached.Response,
+				Context:    cached.Context,
+				Logprobs:   cached.Logprobs,
+				Done:       true,
+				DoneReason: cached.DoneReason,
+				Stats:      cached.Stats,
+			})
+		}
+	}
+
+	response := &strings.Builder{}
+	var logprobs []*TokenLogprob
+	return generate(ctx, req, func(chunk *GenerateResponse) error {
+		response.WriteString(chunk.Response)
+		logprobs = append(logprobs, chunk.Logprobs...)
+		if chunk.Done {
+			_ = self.lru.Set(key, &cachedResponse{
+				Response:   response.String(),
+				Context:    chunk.Context,
+				Logprobs:   logprobs,
+				DoneReason: chunk.DoneReason,
+				Stats:      chunk.Stats,
+			})
+		}
+		return cb(chunk)
+	})
+}
+
+type OllamaCacheFunctionArgs struct {
+	MaxSize int64 `vfilter:"optional,field=max_size,doc=The maximum number of cached responses (default 1000)."`
+	TTL     int64 `vfilter:"optional,field=ttl,doc=Seconds a response stays cached (default 3600)."`
+	Flush   bool  `vfilter:"optional,field=flush,doc=Remove all cached responses."`
+}
+
+type OllamaCacheFunction struct{}
+
+func (self OllamaCacheFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("ollama_cache", args)()
+
+	err := vql_subsystem.CheckAccess(scope, acls.SERVER_ADMIN)
+	if err != nil {
+		scope.Log("ollama_cache: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg := &OllamaCacheFunctionArgs{}
+	err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("ollama_cache: %v", err)
+		return vfilter.Null{}
+	}
+
+	config_obj, ok := vql_subsystem.GetServerConfig(scope)
+	if !ok {
+		scope.Log("ollama_cache: Command can only run on the server")
+		return vfilter.Null{}
+	}
+
+	cache := GetResponseCache(config_obj)
+	cache.Configure(time.Duration(arg.TTL)*time.Second, int(arg.MaxSize))
+	if arg.Flush {
+		cache.Flush()
+	}
+
+	return cache.Stats()
+}
+
+func (self OllamaCacheFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:     "ollama_cache",
+		Doc:      "Configure, flush or show statistics of the org's cache of ollama() responses.",
+		ArgType:  type_map.AddType(scope, &OllamaCacheFunctionArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.SERVER_ADMIN).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&OllamaCacheFunction{})
+}
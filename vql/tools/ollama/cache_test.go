@@ -0,0 +1,53 @@
+package ollama
+
+import (
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/vtesting/assert"
+)
+
+func (self *OllamaTestSuite) TestCache() {
+	query := `
+SELECT * FROM ollama(model="llama3", prompt="Hi", base_url=URL)`
+
+	// The second identical call is answered from the cache.
+	self.run(query)
+	rows := self.run(query)
+	assert.Equal(self.T(), 1, len(rows))
+	assert.Equal(self.T(), 1, len(self.requests))
+
+	response, _ := rows[0].GetString("Response")
+	assert.Equal(self.T(), "Hello world", response)
+
+	// The statistics of the original call are replayed.
+	eval_count, _ := rows[0].GetInt64("EvalCount")
+	assert.Equal(self.T(), int64(2), eval_count)
+
+	// So is the reason the response ended.
+	query_truncated := `
+SELECT * FROM ollama(model="llama3", prompt="Hi", max_tokens=1, base_url=URL)`
+	self.run(query_truncated)
+	rows = self.run(query_truncated)
+	assert.Equal(self.T(), 2, len(self.requests))
+	truncated, _ := rows[0].Get("ResponseTruncated")
+	assert.Equal(self.T(), true, truncated)
+
+	// The same request to another server is not answered from the
+	// cache.
+	self.run(`
+SELECT * FROM ollama(model="llama3", prompt="Hi",
+   base_url=regex_replace(source=URL, re="127.0.0.1", replace="localhost"))`)
+	assert.Equal(self.T(), 3, len(self.requests))
+
+	rows = self.run(`
+SELECT * FROM ollama(model="llama3", prompt="Hi", cache_bypass=TRUE, base_url=URL)`)
+	assert.Equal(self.T(), 1, len(rows))
+	assert.Equal(self.T(), 4, len(self.requests))
+
+	rows = self.run(`SELECT ollama_cache(flush=TRUE) AS Stats FROM scope()`)
+	stats, _ := rows[0].Get("Stats")
+	size, _ := stats.(*ordereddict.Dict).GetInt64("Size")
+	assert.Equal(self.T(), int64(0), size)
+
+	self.run(query)
+	assert.Equal(self.T(), 5, len(self.requests))
+}
@@ -0,0 +1,246 @@
+package ollama
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/json"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	// Replay recorded responses and record the rest.
+	CASSETTE_AUTO = "auto"
+
+	// Record every response, replacing the cassette.
+	CASSETTE_RECORD = "record"
+
+	// Only replay. Requests which were not recorded fail.
+	CASSETTE_REPLAY = "replay"
+
+	OLLAMA_CASSETTE_TAG = "$ollama_cassettes"
+)
+
+// A recorded exchange. The model and prompt are kept so the cassette
+// can be reviewed and diffed when prompts change.
+type cassetteEntry struct {
+	Key      string          `json:"key"`
+	Model    string          `json:"model"`
+	Prompt   string          `json:"prompt"`
+	Response string          `json:"response"`
+	Context  []int64         `json:"context,omitempty"`
+	Logprobs []*TokenLogprob `json:"logprobs,omitempty"`
+
+	Stats
+}
+
+// A file of recorded responses, one JSON object per line, keyed by
+// the hash of the request. Notebook demos and regression tests of
+// prompts replay the cassette instead of calling the model.
+type Cassette struct {
+	mu      sync.Mutex
+	path    string
+	mode    string
+	entries map[string]*cassetteEntry
+}
+
+// Cassettes are shared by all calls in the query so a cassette in
+// record mode is only replaced once.
+func OpenCassette(scope vfilter.Scope, path, mode string) (*Cassette, error) {
+	if mode == "" {
+		mode = CASSETTE_AUTO
+	}
+
+	switch mode {
+	case CASSETTE_AUTO, CASSETTE_RECORD:
+		err := vql_subsystem.CheckAccess(scope,
+			acls.FILESYSTEM_READ, acls.FILESYSTEM_WRITE)
+		if err != nil {
+			return nil, err
+		}
+
+	case CASSETTE_REPLAY:
+		err := vql_subsystem.CheckAccess(scope, acls.FILESYSTEM_READ)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf(
+			"cassette_mode should be auto, record or replay not %q", mode)
+	}
+
+	cassettes, ok := vql_subsystem.CacheGet(
+		scope, OLLAMA_CASSETTE_TAG).(map[string]*Cassette)
+	if !ok {
+		cassettes = make(map[string]*Cassette)
+		vql_subsystem.CacheSet(scope, OLLAMA_CASSETTE_TAG, cassettes)
+	}
+
+	result, pres := cassettes[path]
+	if pres {
+		if result.mode != mode {
+			return nil, fmt.Errorf(
+				"cassette %v is already open in %v mode", path, result.mode)
+		}
+		return result, nil
+	}
+
+	result = &Cassette{
+		path:    path,
+		mode:    mode,
+		entries: make(map[string]*cassetteEntry),
+	}
+
+	var err error
+	if mode == CASSETTE_RECORD {
+		err = os.WriteFile(path, nil, 0600)
+	} else {
+		err = result.load()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cassettes[path] = result
+	return result, nil
+}
+
+// Later recordings of the same request replace earlier ones.
+func (self *Cassette) load() error {
+	fd, err := os.Open(self.path)
+	if errors.Is(err, os.ErrNotExist) && self.mode == CASSETTE_AUTO {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cassette: %w", err)
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(nil, 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		entry := &cassetteEntry{}
+		err := json.Unmarshal([]byte(text), entry)
+		if err != nil {
+			return fmt.Errorf("cassette %v line %v: %w", self.path, line, err)
+		}
+		self.entries[entry.Key] = entry
+	}
+	return scanner.Err()
+}
+
+func (self *Cassette) record(entry *cassetteEntry) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	serialized, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	fd, err := os.OpenFile(self.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	_, err = fd.Write(append(serialized, '\n'))
+	if err != nil {
+		return err
+	}
+
+	self.entries[entry.Key] = entry
+	return nil
+}
+
+func (self *Cassette) get(key string) (*cassetteEntry, bool) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	entry, pres := self.entries[key]
+	return entry, pres
+}
+
+// Replay the recorded response to the request, or call generate and
+// record its response. A replayed response is delivered as a single
+// chunk, like a cached one.
+func (self *Cassette) Generate(ctx context.Context, scope vfilter.Scope,
+	req *GenerateRequest, cb func(resp *GenerateResponse) error,
+	generate generateFunc) error {
+	// Cassettes are keyed on the request only so they replay
+	// against any server.
+	key := requestKey("", req)
+
+	if self.mode != CASSETTE_RECORD {
+		entry, pres := self.get(key)
+		if pres {
+			return cb(&GenerateResponse{
+				Model:      req.Model,
+				Response:   entry.Response,
+				Context:    entry.Context,
+				Logprobs:   entry.Logprobs,
+				Done:       true,
+				DoneReason: "stop",
+				Stats:      entry.Stats,
+			})
+		}
+	}
+
+	if self.mode == CASSETTE_REPLAY {
+		return &Error{Code: ERROR_CASSETTE_MISS, Endpoint: self.path,
+			Err: fmt.Errorf("no recorded response for request %v", key)}
+	}
+
+	response := &strings.Builder{}
+	var logprobs []*TokenLogprob
+	return generate(ctx, req, func(chunk *GenerateResponse) error {
+		response.WriteString(chunk.Response)
+		logprobs = append(logprobs, chunk.Logprobs...)
+		if chunk.Done {
+			err := self.record(&cassetteEntry{
+				Key:      key,
+				Model:    req.Model,
+				Prompt:   req.Prompt,
+				Response: response.String(),
+				Context:  chunk.Context,
+				Logprobs: logprobs,
+				Stats:    chunk.Stats,
+			})
+			if err != nil {
+				scope.Log("ollama: recording to cassette %v: %v", self.path, err)
+			}
+		}
+		return cb(chunk)
+	})
+}
+
+// Wrap generate with the cassette if one is given.
+func cassetteGenerate(scope vfilter.Scope, path, mode string,
+	generate generateFunc) (generateFunc, error) {
+	if path == "" {
+		return generate, nil
+	}
+
+	cassette, err := OpenCassette(scope, path, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, req *GenerateRequest,
+		cb func(resp *GenerateResponse) error) error {
+		return cassette.Generate(ctx, scope, req, cb, generate)
+	}, nil
+}
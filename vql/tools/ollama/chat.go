@@ -0,0 +1,282 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/json"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+type OllamaChatPluginArgs struct {
+	Model      string              `vfilter:"optional,field=model,doc=The model to use (e.g. llama3). Defaults to AI.default_model in the config."`
+	Messages   []*ordereddict.Dict `vfilter:"required,field=messages,doc=The conversation so far. Each is a dict with role (system, user, assistant or tool) and content, and optionally images (base64 data or data URLs, OpenAI servers also take http URLs), tool_calls or tool_name."`
+	Examples   []*ordereddict.Dict `vfilter:"optional,field=examples,doc=Example inputs with the expected outputs, added to the conversation as earlier turns after the system messages. Each is a dict with input and output."`
+	Tools      []*ordereddict.Dict `vfilter:"optional,field=tools,doc=Tools the model may ask to call. Each is a dict with name, description and parameters (a JSON schema). Calls are returned in the ToolCalls column for the query to handle."`
+	BaseUrl    string              `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server. Several comma separated URLs, or srv://name for the servers of a DNS SRV record, spread the calls over the servers."`
+	Timeout    int64               `vfilter:"optional,field=timeout,doc=Seconds the call may take (default 3600)."`
+	MaxTokens  int64               `vfilter:"optional,field=max_tokens,doc=The most tokens the response may have. Longer responses are cut off and marked in the ResponseTruncated column."`
+	Stop       []string            `vfilter:"optional,field=stop,doc=Stop generating when the model produces one of these strings. The stop string is not included in the response."`
+	Options    *ordereddict.Dict   `vfilter:"optional,field=options,doc=Model options (e.g. temperature, num_ctx)."`
+	Format     vfilter.Any         `vfilter:"optional,field=format,doc=Either 'json' or a JSON schema to constrain the response."`
+	KeepAlive  string              `vfilter:"optional,field=keep_alive,doc=How long the model stays loaded after the call (e.g. 5m)."`
+	Guardrails []*ordereddict.Dict `vfilter:"optional,field=guardrails,doc=Rules flagging dangerous content in the response. Each is a dict with name, regex and action (annotate, block or ignore). Built in rules: shell_command, secret and url."`
+	Session    string              `vfilter:"optional,field=session,doc=A name for the conversation. When calls are spread over several servers, calls with the same session go to the same server so the model does not reprocess the conversation (default the first message)."`
+}
+
+type ChatToolArgs struct {
+	Name        string            `vfilter:"required,field=name,doc=The name of the tool."`
+	Description string            `vfilter:"optional,field=description,doc=What the tool does."`
+	Parameters  *ordereddict.Dict `vfilter:"optional,field=parameters,doc=A JSON schema of the tool's arguments."`
+}
+
+// Sends the messages as given. Unlike ollama() and ollama_agent()
+// nothing but the examples is added to the conversation so the query
+// is in full control of its structure.
+type OllamaChatPlugin struct{}
+
+func (self OllamaChatPlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("ollama_chat", args)()
+
+		err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+		if err != nil {
+			scope.Log("ollama_chat: %v", err)
+			return
+		}
+
+		arg := &OllamaChatPluginArgs{}
+		err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+		if err != nil {
+			scope.Log("ollama_chat: %v", err)
+			return
+		}
+
+		messages, err := parseChatMessages(arg.Messages)
+		if err != nil {
+			scope.Log("ollama_chat: %v", err)
+			return
+		}
+
+		examples, err := parseExamples(ctx, scope, arg.Examples)
+		if err != nil {
+			scope.Log("ollama_chat: %v", err)
+			return
+		}
+		messages = withExampleMessages(messages, examples)
+
+		tools, err := parseChatTools(ctx, scope, arg.Tools)
+		if err != nil {
+			scope.Log("ollama_chat: %v", err)
+			return
+		}
+
+		guardrails, err := parseGuardrails(ctx, scope, arg.Guardrails)
+		if err != nil {
+			scope.Log("ollama_chat: %v", err)
+			return
+		}
+
+		if len(arg.Stop) > 0 {
+			arg.Options = withOption(arg.Options, "stop", arg.Stop)
+		}
+
+		if arg.MaxTokens > 0 {
+			arg.Options = withOption(arg.Options, "num_predict", arg.MaxTokens)
+		}
+
+		client, err := NewClient(ctx, scope, arg.BaseUrl)
+		if err != nil {
+			scope.Log("ollama_chat: %v", err)
+			return
+		}
+
+		arg.Model, err = client.Model(arg.Model)
+		if err != nil {
+			scope.Log("ollama_chat: %v", err)
+			return
+		}
+
+		client = client.WithTimeouts(Timeouts{
+			Total: time.Duration(arg.Timeout) * time.Second,
+		})
+
+		// Each turn resends the conversation which starts with the
+		// same message.
+		session := arg.Session
+		if session == "" && len(messages) > 0 {
+			session = messages[0].Role + ":" + messages[0].Content
+		}
+		client = client.WithSession(session)
+
+		content := &strings.Builder{}
+		var tool_calls []*ToolCall
+		var final *ChatResponse
+		var tokens int64
+		err = client.Chat(ctx, &ChatRequest{
+			Model:     arg.Model,
+			Messages:  messages,
+			Tools:     tools,
+			Format:    arg.Format,
+			Options:   arg.Options,
+			KeepAlive: arg.KeepAlive,
+			Stream:    true,
+		}, func(chunk *ChatResponse) error {
+			if !chunk.Done && arg.MaxTokens > 0 {
+				if tokens >= arg.MaxTokens {
+					return errMaxTokens
+				}
+				tokens++
+			}
+			if chunk.Message != nil {
+				content.WriteString(chunk.Message.Content)
+				tool_calls = append(tool_calls, chunk.Message.ToolCalls...)
+			}
+			if chunk.Done {
+				final = chunk
+			}
+			return nil
+		})
+
+		// The server did not honour num_predict so the stream was cut off.
+		truncated := errors.Is(err, errMaxTokens)
+		if truncated {
+			err = nil
+		}
+
+		if err != nil {
+			scope.Log("ollama_chat: %v", err)
+
+			var llm_err *Error
+			if errors.As(err, &llm_err) {
+				select {
+				case <-ctx.Done():
+				case output_chan <- errRow(arg.Model, err):
+				}
+			}
+			return
+		}
+
+		row := ordereddict.NewDict().
+			Set("Model", arg.Model).
+			Set("Role", "assistant").
+			Set("Content", content.String()).
+			Set("ToolCalls", tool_calls)
+		guardrails.Filter(scope, "ollama_chat", row, "Content")
+
+		if final != nil {
+			final.Stats.SetColumns(row)
+			truncated = truncated || final.DoneReason == DONE_REASON_LENGTH
+		}
+		if arg.MaxTokens > 0 {
+			row.Set("ResponseTruncated", truncated)
+		}
+
+		select {
+		case <-ctx.Done():
+		case output_chan <- row:
+		}
+	}()
+
+	return output_chan
+}
+
+var valid_roles = map[string]bool{
+	"system": true, "user": true, "assistant": true, "tool": true,
+}
+
+func parseChatMessages(definitions []*ordereddict.Dict) ([]*Message, error) {
+	result := make([]*Message, 0, len(definitions))
+	for idx, definition := range definitions {
+		serialized, err := json.Marshal(definition)
+		if err != nil {
+			return nil, err
+		}
+
+		message := &Message{}
+		err = json.Unmarshal(serialized, message)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", idx, err)
+		}
+
+		if !valid_roles[message.Role] {
+			return nil, fmt.Errorf(
+				"messages[%d]: role should be system, user, assistant or tool not %q",
+				idx, message.Role)
+		}
+
+		for i, image := range message.Images {
+			message.Images[i] = imageData(image)
+		}
+		result = append(result, message)
+	}
+	return result, nil
+}
+
+// Images may be given as data URLs, which are sent as the base64 data
+// Ollama expects. OpenAI servers also take http and https URLs.
+func imageData(image string) string {
+	if strings.HasPrefix(image, "data:") {
+		header, data, found := strings.Cut(image, ",")
+		if found && strings.HasSuffix(header, ";base64") {
+			return data
+		}
+	}
+	return image
+}
+
+func isImageUrl(image string) bool {
+	lower := strings.ToLower(image)
+	return strings.HasPrefix(lower, "http://") ||
+		strings.HasPrefix(lower, "https://") ||
+		strings.HasPrefix(lower, "data:")
+}
+
+func parseChatTools(ctx context.Context, scope vfilter.Scope,
+	definitions []*ordereddict.Dict) ([]*ToolSpec, error) {
+	var result []*ToolSpec
+	for _, definition := range definitions {
+		arg := &ChatToolArgs{}
+		err := arg_parser.ExtractArgsWithContext(ctx, scope, definition, arg)
+		if err != nil {
+			return nil, fmt.Errorf("tools: %w", err)
+		}
+
+		result = append(result, &ToolSpec{
+			Type: "function",
+			Function: &ToolFunction{
+				Name:        arg.Name,
+				Description: arg.Description,
+				Parameters:  arg.Parameters,
+			},
+		})
+	}
+	return result, nil
+}
+
+func (self OllamaChatPlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name:     "ollama_chat",
+		Doc:      "Send a conversation given as a list of messages to an Ollama model.",
+		ArgType:  type_map.AddType(scope, &OllamaChatPluginArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterPlugin(&OllamaChatPlugin{})
+}
@@ -0,0 +1,87 @@
+package ollama
+
+import (
+	"fmt"
+
+	"www.velocidex.com/golang/velociraptor/vtesting/assert"
+	"www.velocidex.com/golang/vfilter"
+)
+
+func (self *OllamaTestSuite) TestChat() {
+	self.chat_responses = []string{
+		fmt.Sprintf(toolCallResponse, "lookup", 42),
+		fmt.Sprintf(finalResponse, "It is explorer.exe"),
+	}
+
+	rows := self.run(`
+LET Tools = (dict(name="lookup", description="Find a process",
+                  parameters=dict(type="object")),)
+
+SELECT * FROM ollama_chat(model="llama3", tools=Tools, base_url=URL,
+   messages=[dict(role="system", content="You are an analyst"),
+             dict(role="user", content="What is process 42?")])`)
+	assert.Equal(self.T(), 1, len(rows))
+
+	// The model asked for a tool call.
+	calls, _ := rows[0].Get("ToolCalls")
+	assert.Equal(self.T(), 1, len(calls.([]vfilter.Any)))
+
+	// The messages are sent as given.
+	messages := self.chat_requests[0].Messages
+	assert.Equal(self.T(), 2, len(messages))
+	assert.Equal(self.T(), "system", messages[0].Role)
+	assert.Equal(self.T(), "lookup", self.chat_requests[0].Tools[0].Function.Name)
+
+	// The query continues the conversation with the tool result.
+	rows = self.run(`
+SELECT * FROM ollama_chat(model="llama3", base_url=URL,
+   messages=[dict(role="user", content="What is process 42?"),
+             dict(role="assistant", content="",
+                  tool_calls=(dict(function=dict(name="lookup",
+                                                 arguments=dict(pid=42))),)),
+             dict(role="tool", tool_name="lookup", content="explorer.exe")])`)
+	content, _ := rows[0].GetString("Content")
+	assert.Equal(self.T(), "It is explorer.exe", content)
+	assert.Equal(self.T(), "lookup", self.chat_requests[1].Messages[2].ToolName)
+
+	// Invalid roles are rejected.
+	rows = self.run(`
+SELECT * FROM ollama_chat(model="llama3", base_url=URL,
+   messages=[dict(role="admin", content="Hi")])`)
+	assert.Equal(self.T(), 0, len(rows))
+}
+
+func (self *OllamaTestSuite) TestEmbed() {
+	rows := self.run(`
+SELECT * FROM ollama_embed(model="nomic-embed-text", batch_size=2,
+   input=["a", "bb", "ccc", "dddd", "eeeee"], base_url=URL)`)
+	assert.Equal(self.T(), 5, len(rows))
+
+	// Rows are emitted in order with their embedding.
+	embedding, _ := rows[4].Get("Embedding")
+	assert.Equal(self.T(), []vfilter.Any{float64(5)}, embedding)
+
+	// Five inputs need three calls.
+	assert.Equal(self.T(), 3, len(self.embed_requests))
+	assert.Equal(self.T(), 2, len(self.embed_requests[0].Input))
+	assert.Equal(self.T(), 1, len(self.embed_requests[2].Input))
+}
+
+func (self *OllamaTestSuite) TestSession() {
+	query := `
+SELECT * FROM ollama(model="llama3", prompt="Question", session="s1", base_url=URL)`
+
+	self.run(query)
+	self.run(query)
+
+	// The second call continues from the context returned by the
+	// first.
+	assert.Equal(self.T(), 2, len(self.requests))
+	assert.Equal(self.T(), 0, len(self.requests[0].Context))
+	assert.Equal(self.T(), []int64{1}, self.requests[1].Context)
+
+	// Other sessions are independent.
+	self.run(`
+SELECT * FROM ollama(model="llama3", prompt="Question", session="s2", base_url=URL)`)
+	assert.Equal(self.T(), 0, len(self.requests[2].Context))
+}
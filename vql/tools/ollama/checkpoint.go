@@ -0,0 +1,180 @@
+package ollama
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/constants"
+	"www.velocidex.com/golang/velociraptor/file_store"
+	"www.velocidex.com/golang/velociraptor/file_store/api"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/paths"
+	"www.velocidex.com/golang/velociraptor/result_sets"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+)
+
+// The state of a saved generation.
+type checkpointState struct {
+	Hash     string  `json:"hash"`
+	Response string  `json:"response"`
+	Context  []int64 `json:"context,omitempty"`
+	Done     bool    `json:"done"`
+	Time     int64   `json:"time"`
+}
+
+// Periodically saves the response of a long generation under a name
+// so it survives a server restart. When a query with the same name
+// and request runs again a completed response is reused and a
+// partial response is continued rather than starting over.
+// Checkpoints are stored for each user of the org so users can not
+// read or overwrite each other's.
+type Checkpoint struct {
+	scope      vfilter.Scope
+	config_obj *config_proto.Config
+	path       api.FSPathSpec
+
+	// Identifies the request so a changed prompt does not pick up
+	// a stale checkpoint.
+	hash string
+
+	interval time.Duration
+	last     time.Time
+}
+
+func OpenCheckpoint(scope vfilter.Scope, name string,
+	req *GenerateRequest, interval time.Duration) (*Checkpoint, error) {
+	config_obj, ok := vql_subsystem.GetServerConfig(scope)
+	if !ok {
+		return nil, errors.New("checkpoint: Only available on the server")
+	}
+
+	principal := vql_subsystem.GetPrincipal(scope)
+	if principal == "" {
+		principal = constants.PinnedServerName
+	}
+
+	serialized, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(serialized)
+
+	return &Checkpoint{
+		scope:      scope,
+		config_obj: config_obj,
+		path:       paths.LLMPathManager{}.Checkpoint(principal, name),
+		hash:       hex.EncodeToString(hash[:]),
+		interval:   interval,
+		last:       utils.GetTime().Now(),
+	}, nil
+}
+
+func (self *Checkpoint) load(ctx context.Context) *checkpointState {
+	reader, err := result_sets.NewResultSetReader(
+		file_store.GetFileStore(self.config_obj), self.path)
+	if err != nil {
+		return nil
+	}
+	defer reader.Close()
+
+	json_chan, err := reader.JSON(ctx)
+	if err != nil {
+		return nil
+	}
+
+	var result *checkpointState
+	for serialized := range json_chan {
+		state := &checkpointState{}
+		err := json.Unmarshal(serialized, state)
+		if err == nil && state.Hash == self.hash {
+			result = state
+		}
+	}
+	return result
+}
+
+func (self *Checkpoint) save(state *checkpointState) error {
+	state.Hash = self.hash
+	state.Time = utils.GetTime().Now().Unix()
+
+	serialized, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	rs_writer, err := result_sets.NewResultSetWriter(
+		file_store.GetFileStore(self.config_obj), self.path,
+		json.DefaultEncOpts(), utils.SyncCompleter, result_sets.TruncateMode)
+	if err != nil {
+		return err
+	}
+	rs_writer.WriteJSONL(append(serialized, '\n'), 1)
+	rs_writer.Close()
+	return nil
+}
+
+// Run the generation, resuming from the checkpoint if possible.
+func (self *Checkpoint) Generate(ctx context.Context, client *Client,
+	req *GenerateRequest, cb func(resp *GenerateResponse) error) error {
+	state := self.load(ctx)
+	if state != nil && state.Done {
+		self.scope.Log("ollama: Using completed response from checkpoint")
+		return cb(&GenerateResponse{
+			Model:    req.Model,
+			Response: state.Response,
+			Context:  state.Context,
+			Done:     true,
+		})
+	}
+
+	response := &strings.Builder{}
+	checkpoint_cb := func(chunk *GenerateResponse) error {
+		response.WriteString(chunk.Response)
+
+		now := utils.GetTime().Now()
+		if chunk.Done {
+			err := self.save(&checkpointState{
+				Response: response.String(),
+				Context:  chunk.Context,
+				Done:     true,
+			})
+			if err != nil {
+				self.scope.Log("ollama: checkpoint: %v", err)
+			}
+
+		} else if now.Sub(self.last) >= self.interval {
+			self.last = now
+			err := self.save(&checkpointState{Response: response.String()})
+			if err != nil {
+				self.scope.Log("ollama: checkpoint: %v", err)
+			}
+		}
+
+		return cb(chunk)
+	}
+
+	// Otherwise the model continues from where it was.
+	if state == nil || state.Response == "" || len(req.Context) > 0 {
+		return client.Generate(ctx, req, checkpoint_cb)
+	}
+
+	self.scope.Log("ollama: Continuing partial response of %v bytes from checkpoint",
+		len(state.Response))
+
+	err := checkpoint_cb(&GenerateResponse{
+		Model:    req.Model,
+		Response: state.Response,
+	})
+	if err != nil {
+		return err
+	}
+
+	return client.Continue(ctx, req, state.Response, checkpoint_cb)
+}
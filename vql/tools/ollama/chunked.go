@@ -0,0 +1,232 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+)
+
+// Send the query rows in chunks, making one model call per chunk.
+// The next chunk is collected while the model works on the current
+// one. At most one chunk is buffered ahead so a slow model does not
+// cause the whole query to be held in memory.
+func runChunked(ctx context.Context, scope vfilter.Scope,
+	arg *OllamaPluginArgs, client *Client, guardrails *Guardrails,
+	validator *responseValidator, digest string,
+	output_chan chan vfilter.Row) error {
+	sub_ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunks := make(chan *rowEncoder, 1)
+	go func() {
+		defer close(chunks)
+
+		splitChunks(sub_ctx, scope, arg, func(chunk *rowEncoder) bool {
+			select {
+			case <-sub_ctx.Done():
+				return false
+			case chunks <- chunk:
+				return true
+			}
+		})
+	}()
+
+	generate := slowCallGenerate(scope, newSlowCallThresholds(arg),
+		arg.SlowCallRows, logGenerate(scope, client,
+			maxTokensGenerate(arg.MaxTokens,
+				resumeGenerate(scope, client, arg.MaxResumes))), output_chan)
+	config_obj, ok := vql_subsystem.GetServerConfig(scope)
+	if ok && !arg.CacheBypass {
+		cache := GetResponseCache(config_obj)
+		uncached := generate
+		generate = func(ctx context.Context, req *GenerateRequest,
+			cb func(resp *GenerateResponse) error) error {
+			return cache.Generate(ctx, client, req, cb, uncached)
+		}
+	}
+
+	generate, err := cassetteGenerate(scope, arg.Cassette,
+		arg.CassetteMode, generate)
+	if err != nil {
+		return err
+	}
+
+	if arg.Debug {
+		generate = debugGenerate(client, generate, output_chan)
+	}
+
+	idx := 0
+	for encoder := range chunks {
+		rows := encoder.rows
+		req := &GenerateRequest{
+			Model:     arg.Model,
+			Prompt:    arg.Prompt,
+			System:    arg.System,
+			Format:    arg.Format,
+			Options:   arg.Options,
+			KeepAlive: arg.KeepAlive,
+			Logprobs:  arg.Confidence,
+		}
+
+		serialized := encoder.Serialize(rows)
+
+		// The chunk goes to the smallest model it fits, or is
+		// truncated to fit the largest.
+		var choice *modelChoice
+		chunk_digest := digest
+		if arg.selector != nil {
+			choice = arg.selector.Select(req, serialized)
+			if arg.Deterministic {
+				var err error
+				chunk_digest, err = arg.selector.Digest(ctx, client, req.Model)
+				if err != nil {
+					scope.Log("ollama: %v", err)
+				}
+			}
+		}
+
+		var truncated bool
+		if arg.Truncate != "" {
+			var err error
+			serialized, truncated, err = fitRows(ctx, client, req, arg.Truncate,
+				encoder.header, rows)
+			if err != nil {
+				return err
+			}
+			if truncated && arg.Strict {
+				return fmt.Errorf("Chunk %v does not fit in the context window of %v",
+					idx, req.Model)
+			}
+		}
+		req.Prompt += "\n\n" + serialized
+		scope.Log("DEBUG:ollama: Chunk %v has %v rows (%v bytes)",
+			idx, len(rows), encoder.size)
+
+		response := &strings.Builder{}
+		var final *GenerateResponse
+		confidence := &confidenceTracker{}
+		err := generate(ctx, req, func(chunk *GenerateResponse) error {
+			response.WriteString(chunk.Response)
+			confidence.Add(chunk.Logprobs)
+			if chunk.Done {
+				final = chunk
+			}
+			return nil
+		})
+
+		var validation *validationResult
+		if err == nil && validator != nil {
+			validation, err = validator.Repair(ctx, scope, generate, req,
+				response.String(), final, arg.RepairAttempts)
+		}
+		if err != nil {
+			scope.Log("ollama: %v", err)
+
+			row := errRow(req.Model, err).Set("Chunk", idx).Set("Rows", len(rows))
+			select {
+			case <-ctx.Done():
+			case output_chan <- renameColumn(row, "Response", arg.OutputColumn):
+			}
+			return nil
+		}
+
+		text := response.String()
+		if validation != nil {
+			text = validation.Response
+			final = validation.Final
+			if validation.Attempts > 1 {
+				confidence = &confidenceTracker{}
+				confidence.Add(validation.Logprobs)
+			}
+		}
+
+		row := ordereddict.NewDict().
+			Set("Model", req.Model).
+			Set("Chunk", idx).
+			Set("Rows", len(rows)).
+			Set("Truncated", truncated).
+			Set("Response", formatResponse(arg.ResponseFormat, text))
+		if final != nil {
+			final.Stats.SetColumns(row)
+		}
+		setResponseTruncated(row, arg.MaxTokens, final)
+		if arg.Confidence {
+			confidence.SetColumn(scope, row)
+		}
+		if arg.IncludeInput {
+			row.Set("Input", encoder.inputs)
+		}
+		if arg.pseudonymizer != nil {
+			row.Set("Pseudonyms", arg.pseudonymizer.Pseudonyms())
+		}
+		guardrails.Filter(scope, "ollama", row, "Response")
+		if arg.Deterministic {
+			row.Set("Digest", chunk_digest)
+		}
+		if choice != nil {
+			row.Set("ModelSelection", choice.Dict())
+		}
+		if validation != nil {
+			validation.SetColumns(scope, row, arg.Parse)
+		}
+		idx++
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case output_chan <- renameColumn(row, "Response", arg.OutputColumn):
+		}
+	}
+
+	return nil
+}
+
+// Split the query rows into chunks of at most chunk_size rows that
+// fit in max_bytes. Stops early if send returns false.
+func splitChunks(ctx context.Context, scope vfilter.Scope,
+	arg *OllamaPluginArgs, send func(chunk *rowEncoder) bool) {
+	sub_ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	send_chunk := func(chunk *rowEncoder) bool {
+		if len(chunk.rows) == 0 {
+			return true
+		}
+		return send(chunk)
+	}
+
+	encoder := newRowEncoder(arg)
+	for row := range arg.Query.Eval(sub_ctx, scope) {
+		row_dict := promptRow(sub_ctx, scope, row)
+		added, err := encoder.Add(row_dict)
+		if err != nil {
+			scope.Log("ollama: %v", err)
+			return
+		}
+
+		// The chunk is full - start a new one with this row.
+		if !added {
+			if !send_chunk(encoder) {
+				return
+			}
+			encoder = newRowEncoder(arg)
+			added, err = encoder.Add(row_dict)
+			if err != nil || !added {
+				scope.Log("ollama: Row larger than max_bytes skipped")
+				continue
+			}
+		}
+
+		if int64(len(encoder.rows)) >= arg.ChunkSize {
+			if !send_chunk(encoder) {
+				return
+			}
+			encoder = newRowEncoder(arg)
+		}
+	}
+	send_chunk(encoder)
+}
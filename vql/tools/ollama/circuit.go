@@ -0,0 +1,123 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+const (
+	DEFAULT_CIRCUIT_FAILURES = 5
+	DEFAULT_CIRCUIT_COOLDOWN = time.Minute
+)
+
+// When to stop calling a failing server. Zero means the default and
+// negative failures disables the breaker.
+type CircuitBreaker struct {
+	Failures int64
+	Cooldown time.Duration
+}
+
+// The state is shared by all queries calling the same server so a
+// dead server is only waited on a few times.
+type circuitState struct {
+	mu         sync.Mutex
+	failures   int64
+	open_until time.Time
+}
+
+var (
+	circuit_mu     sync.Mutex
+	circuit_states = make(map[string]*circuitState)
+)
+
+func getCircuitState(base_url string) *circuitState {
+	circuit_mu.Lock()
+	defer circuit_mu.Unlock()
+
+	state, pres := circuit_states[base_url]
+	if !pres {
+		state = &circuitState{}
+		circuit_states[base_url] = state
+	}
+	return state
+}
+
+func (self CircuitBreaker) settings() (int64, time.Duration) {
+	failures := self.Failures
+	if failures == 0 {
+		failures = DEFAULT_CIRCUIT_FAILURES
+	}
+
+	cooldown := self.Cooldown
+	if cooldown == 0 {
+		cooldown = DEFAULT_CIRCUIT_COOLDOWN
+	}
+	return failures, cooldown
+}
+
+// Fail fast while the circuit is open. Once the cooldown expires
+// calls are let through again - a single further failure reopens
+// the circuit.
+func (self *circuitState) Allow(breaker CircuitBreaker, endpoint string) error {
+	failures, _ := breaker.settings()
+	if failures < 0 {
+		return nil
+	}
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	now := utils.GetTime().Now()
+	if now.Before(self.open_until) {
+		return &Error{Code: ERROR_CIRCUIT_OPEN, Retryable: true,
+			Endpoint: endpoint,
+			Err: fmt.Errorf("%v consecutive calls failed, not retrying for %v",
+				self.failures, self.open_until.Sub(now).Round(time.Second))}
+	}
+	return nil
+}
+
+// Whether the circuit opened since the last successful call. Once
+// the cooldown expires calls are let through but the server has not
+// yet shown that it recovered.
+func (self *circuitState) Tripped() bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return !self.open_until.IsZero()
+}
+
+// Track the outcome of a call. Only failures of the server count - a
+// server which rejects a request is still working.
+func (self *circuitState) Record(breaker CircuitBreaker, err error) {
+	failures, cooldown := breaker.settings()
+	if failures < 0 {
+		return
+	}
+
+	// Cancelled queries say nothing about the server.
+	var llm_err *Error
+	if err != nil && (!errors.As(err, &llm_err) ||
+		errors.Is(err, context.Canceled)) {
+		return
+	}
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if err == nil || !llm_err.Retryable || llm_err.Code == ERROR_RATE_LIMITED {
+		self.failures = 0
+		self.open_until = time.Time{}
+		return
+	}
+
+	self.failures++
+	if self.failures >= failures {
+		self.open_until = utils.GetTime().Now().Add(cooldown)
+	}
+}
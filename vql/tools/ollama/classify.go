@@ -0,0 +1,236 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	CLASSIFY_PROMPT = "Classify the text into exactly one of the labels " +
+		"below. Respond with JSON whose Label is the chosen label, " +
+		"spelled exactly as it is listed."
+)
+
+type LLMClassifyFunctionArgs struct {
+	Text        string              `vfilter:"required,field=text,doc=The text to classify."`
+	Labels      []string            `vfilter:"required,field=labels,doc=The labels to choose from. The result is always one of them."`
+	Definitions *ordereddict.Dict   `vfilter:"optional,field=definitions,doc=A dict of what each label means, shown to the model."`
+	Examples    []*ordereddict.Dict `vfilter:"optional,field=examples,doc=Example texts with their label, shown to the model before the text. Each is a dict with input and output."`
+	Prompt      string              `vfilter:"optional,field=prompt,doc=Additional instructions (e.g. what kind of text it is)."`
+	Confidence  bool                `vfilter:"optional,field=confidence,doc=Return a dict with the Label and the Confidence (0 to 1) from the token log probabilities. Confidence is null if the server does not support logprobs."`
+	Retries     int64               `vfilter:"optional,field=retries,doc=How often the model is asked again when it answers with a label not in the set (default 2)."`
+	Model       string              `vfilter:"optional,field=model,doc=The model to use (e.g. llama3). Defaults to AI.default_model in the config."`
+	MaxText     int64               `vfilter:"optional,field=max_text,doc=The most bytes of the text to send (default 32kb)."`
+	BaseUrl     string              `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server. Several comma separated URLs, or srv://name for the servers of a DNS SRV record, spread the calls over the servers."`
+	Timeout     int64               `vfilter:"optional,field=timeout,doc=Seconds the call may take (default 3600)."`
+	Options     *ordereddict.Dict   `vfilter:"optional,field=options,doc=Model options (e.g. temperature, num_ctx)."`
+	KeepAlive   string              `vfilter:"optional,field=keep_alive,doc=How long the model stays loaded after the call (e.g. 5m)."`
+}
+
+// Classifies text into one of the labels given by the caller. The
+// response is constrained to the labels and the model is asked again
+// if it still answers with another one, so the query can rely on
+// the result being one of the labels or null if the model never
+// chose one.
+type LLMClassifyFunction struct{}
+
+func (self LLMClassifyFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("llm_classify", args)()
+
+	err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+	if err != nil {
+		scope.Log("llm_classify: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg := &LLMClassifyFunctionArgs{}
+	err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("llm_classify: %v", err)
+		return vfilter.Null{}
+	}
+
+	if arg.MaxText == 0 {
+		arg.MaxText = DEFAULT_MAX_EXTRACT_TEXT
+	}
+
+	if arg.Retries == 0 {
+		arg.Retries = DEFAULT_REPAIR_ATTEMPTS
+	}
+
+	prompt, err := classifyPrompt(ctx, scope, arg)
+	if err != nil {
+		scope.Log("llm_classify: %v", err)
+		return vfilter.Null{}
+	}
+
+	client, err := NewClient(ctx, scope, arg.BaseUrl)
+	if err != nil {
+		scope.Log("llm_classify: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg.Model, err = client.Model(arg.Model)
+	if err != nil {
+		scope.Log("llm_classify: %v", err)
+		return vfilter.Null{}
+	}
+
+	client = client.WithTimeouts(Timeouts{
+		Total: time.Duration(arg.Timeout) * time.Second,
+	})
+	generate := logGenerate(scope, client, client.Generate)
+
+	label, confidence, err := classify(ctx, scope, arg, prompt, generate)
+	if err != nil {
+		scope.Log("llm_classify: %v", err)
+		return vfilter.Null{}
+	}
+
+	if !arg.Confidence {
+		return label
+	}
+
+	result := ordereddict.NewDict().Set("Label", label)
+	confidence.SetColumn(scope, result)
+	return result
+}
+
+func classifyPrompt(ctx context.Context, scope vfilter.Scope,
+	arg *LLMClassifyFunctionArgs) (string, error) {
+	if len(arg.Labels) == 0 {
+		return "", errors.New("No labels to classify into")
+	}
+
+	if arg.Definitions != nil {
+		for _, k := range arg.Definitions.Keys() {
+			if !utils.InString(arg.Labels, k) {
+				return "", fmt.Errorf("definitions: %v is not one of the labels", k)
+			}
+		}
+	}
+
+	examples, err := parseExamples(ctx, scope, arg.Examples)
+	if err != nil {
+		return "", err
+	}
+
+	text := cleanText(arg.Text)
+	if int64(len(text)) > arg.MaxText {
+		text = strings.ToValidUTF8(text[:arg.MaxText], "") + " ..."
+	}
+
+	prompt := &strings.Builder{}
+	prompt.WriteString(formatExamples(examples))
+	prompt.WriteString(CLASSIFY_PROMPT)
+	if arg.Prompt != "" {
+		prompt.WriteString("\n\n" + arg.Prompt)
+	}
+
+	prompt.WriteString("\n\nLabels:\n")
+	for _, label := range arg.Labels {
+		fmt.Fprintf(prompt, "- %v", label)
+		if arg.Definitions != nil {
+			definition, pres := arg.Definitions.Get(label)
+			if pres {
+				fmt.Fprintf(prompt, ": %v", utils.ToString(definition))
+			}
+		}
+		prompt.WriteString("\n")
+	}
+	prompt.WriteString("\nText:\n" + text)
+
+	return prompt.String(), nil
+}
+
+func classify(ctx context.Context, scope vfilter.Scope,
+	arg *LLMClassifyFunctionArgs, prompt string,
+	generate generateFunc) (string, *confidenceTracker, error) {
+	schema := ordereddict.NewDict().
+		Set("type", "object").
+		Set("properties", ordereddict.NewDict().
+			Set("Label", ordereddict.NewDict().
+				Set("type", "string").
+				Set("enum", arg.Labels))).
+		Set("required", []string{"Label"})
+
+	req := &GenerateRequest{
+		Model:     arg.Model,
+		Prompt:    prompt,
+		Format:    schema,
+		Options:   arg.Options,
+		KeepAlive: arg.KeepAlive,
+		Logprobs:  arg.Confidence,
+	}
+
+	response := &strings.Builder{}
+	confidence := &confidenceTracker{}
+	err := generate(ctx, req, func(chunk *GenerateResponse) error {
+		response.WriteString(chunk.Response)
+		confidence.Add(chunk.Logprobs)
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	validator, err := newResponseValidator(schema, true)
+	if err != nil {
+		return "", nil, err
+	}
+
+	result, err := validator.Repair(ctx, scope, generate, req,
+		response.String(), nil, arg.Retries)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if result.Attempts > 1 {
+		confidence = &confidenceTracker{}
+		confidence.Add(result.Logprobs)
+	}
+
+	// Models sometimes change the case of the label which is still
+	// a clear choice.
+	var answer string
+	parsed, ok := result.Parsed.(*ordereddict.Dict)
+	if ok {
+		answer, _ = parsed.GetString("Label")
+	}
+
+	answer = strings.TrimSpace(answer)
+	for _, label := range arg.Labels {
+		if strings.EqualFold(label, answer) {
+			return label, confidence, nil
+		}
+	}
+
+	return "", nil, fmt.Errorf("The model answered %q which is not one of the labels after %v attempts",
+		answer, result.Attempts)
+}
+
+func (self LLMClassifyFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:     "llm_classify",
+		Doc:      "Classify text into one of a fixed set of labels with a model.",
+		ArgType:  type_map.AddType(scope, &LLMClassifyFunctionArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&LLMClassifyFunction{})
+}
@@ -0,0 +1,748 @@
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"www.velocidex.com/golang/velociraptor/artifacts"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/constants"
+	"www.velocidex.com/golang/velociraptor/json"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/velociraptor/vql/networking"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	OLLAMA_CLIENT_TAG = "$ollama_client_cache"
+)
+
+type Client struct {
+	base_url string
+
+	// The base_url the client was made for. It names the servers and
+	// the API they speak so their responses are cached apart.
+	spec string
+
+	client   *http.Client
+	timeouts Timeouts
+	circuit  CircuitBreaker
+
+	// Extra headers from a secret, e.g. an API key for a gateway.
+	extra_headers http.Header
+
+	// The AI settings of the org. Requests which break them are
+	// refused before they are sent.
+	settings *config_proto.AIConfig
+
+	// Shared by all clients of the same server.
+	circuit_state *circuitState
+
+	// Pinned models verified by this client and its copies.
+	pins *pinCache
+
+	// Set when calls are spread over several servers.
+	pool *endpointPool
+
+	// Calls with the same session go to the same server of a pool.
+	session string
+
+	// Records calls in the usage ledger.
+	usage *usageRecorder
+}
+
+// Clients are cached in the query scope with the AI settings they
+// were made with, so calls made for each row reuse the same
+// connections instead of handshaking and reading the settings every
+// time.
+type clientCache struct {
+	mu      sync.Mutex
+	clients map[string]*Client
+}
+
+func NewClient(ctx context.Context,
+	scope vfilter.Scope, base_url string) (*Client, error) {
+	cache, pres := vql_subsystem.CacheGet(scope, OLLAMA_CLIENT_TAG).(*clientCache)
+	if !pres {
+		cache = &clientCache{clients: make(map[string]*Client)}
+		vql_subsystem.CacheSet(scope, OLLAMA_CLIENT_TAG, cache)
+	}
+
+	cache.mu.Lock()
+	client, pres := cache.clients[base_url]
+	cache.mu.Unlock()
+	if pres {
+		return client, nil
+	}
+
+	// Resolving the servers may look up DNS records, secrets and
+	// cloud credentials so is done without holding the lock. When
+	// two calls race the first client stored is used.
+	client, err := newClient(ctx, scope, base_url)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	existing, pres := cache.clients[base_url]
+	if pres {
+		return existing, nil
+	}
+	cache.clients[base_url] = client
+	return client, nil
+}
+
+func newClient(ctx context.Context,
+	scope vfilter.Scope, base_url string) (*Client, error) {
+	settings := getSettings(scope)
+
+	// On the server, server:// is the configured model server.
+	if isServerUrl(base_url) {
+		_, ok := vql_subsystem.GetServerConfig(scope)
+		if ok {
+			base_url = ""
+		}
+	}
+
+	if base_url == "" && len(settings.Endpoints) > 0 {
+		base_url = strings.Join(settings.Endpoints, ",")
+	}
+	if base_url == "" {
+		base_url = settings.BaseUrl
+	}
+	if base_url == "" {
+		base_url = DEFAULT_BASE_URL
+	}
+
+	base_urls := splitBaseUrls(base_url)
+	base_url = strings.Join(base_urls, ",")
+
+	base_urls, err := expandSrvUrls(ctx, base_urls)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(base_urls) > 1 {
+		pool, err := newEndpointPool(ctx, scope, base_urls,
+			settings.LoadBalancing)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Client{
+			base_url:      POOL_BASE_URL,
+			spec:          base_url,
+			client:        &http.Client{Transport: pool},
+			settings:      settings,
+			circuit_state: getCircuitState(base_url),
+			pins:          newPinCache(),
+			pool:          pool,
+			usage:         newUsageRecorder(scope, settings),
+		}, nil
+	}
+
+	endpoint, err := newEndpoint(ctx, scope, base_urls[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		base_url:      endpoint.base_url,
+		spec:          base_url,
+		client:        &http.Client{Transport: endpoint.transport},
+		extra_headers: endpoint.headers,
+		settings:      settings,
+		circuit_state: endpoint.circuit_state,
+		pins:          newPinCache(),
+		usage:         newUsageRecorder(scope, settings),
+	}, nil
+}
+
+// A model server the client may call.
+type endpoint struct {
+	base_url  string
+	transport http.RoundTripper
+
+	// Extra headers from a secret, e.g. an API key for a gateway.
+	headers http.Header
+
+	// Shared by all clients of the same server.
+	circuit_state *circuitState
+}
+
+func newEndpoint(ctx context.Context,
+	scope vfilter.Scope, base_url string) (*endpoint, error) {
+	if isMockUrl(base_url) {
+		mock, err := newMockTransport(base_url)
+		if err != nil {
+			return nil, err
+		}
+
+		// The parameters configure the mock so are not part of
+		// the request URLs.
+		return &endpoint{
+			base_url:      mock.base_url,
+			transport:     mock,
+			circuit_state: getCircuitState(base_url),
+		}, nil
+	}
+
+	if isServerUrl(base_url) {
+		server, err := newServerTransport(scope)
+		if err != nil {
+			return nil, err
+		}
+
+		return &endpoint{
+			base_url:      SERVER_SCHEME,
+			transport:     server,
+			circuit_state: getCircuitState(base_url),
+		}, nil
+	}
+
+	var extra_headers http.Header
+	resolved := base_url
+	if isSecretUrl(base_url) {
+		secret, err := resolveSecretUrl(ctx, scope,
+			constants.HTTP_SECRETS, base_url)
+		if err != nil {
+			return nil, err
+		}
+		resolved = secret.base_url
+		extra_headers = secret.headers
+	}
+
+	config_obj, _ := artifacts.GetConfig(scope)
+	transport, err := networking.GetHttpTransport(config_obj, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Negotiate HTTP/2 with TLS gateways so concurrent requests
+	// share a connection.
+	transport.ForceAttemptHTTP2 = true
+
+	// Models may take a long time to load and evaluate the prompt
+	// before sending any headers.
+	transport.ResponseHeaderTimeout = 0
+
+	// Azure OpenAI and Bedrock speak the OpenAI API, authorized with
+	// the credentials of the cloud provider.
+	var upstream http.RoundTripper = transport
+	cloud, err := resolveCloudUrl(ctx, scope,
+		base_url, transport)
+	if err != nil {
+		return nil, err
+	}
+	if cloud != nil {
+		resolved = cloud.base_url
+		extra_headers = cloud.headers
+		if cloud.credentials != nil {
+			upstream = &authTransport{
+				credentials: cloud.credentials,
+				transport:   transport,
+			}
+		}
+	}
+
+	if isOpenAIUrl(resolved) {
+		openai, err := newOpenAITransport(resolved, upstream)
+		if err != nil {
+			return nil, err
+		}
+
+		return &endpoint{
+			base_url:      openai.base_url,
+			transport:     openai,
+			headers:       extra_headers,
+			circuit_state: getCircuitState(openai.base_url),
+		}, nil
+	}
+
+	// Generation can take a long time on slow hardware so the
+	// timeouts are enforced for each call rather than by the client.
+	return &endpoint{
+		base_url:      resolved,
+		transport:     transport,
+		headers:       extra_headers,
+		circuit_state: getCircuitState(resolved),
+	}, nil
+}
+
+// A client sharing the connections of this one but with different
+// timeouts.
+func (self *Client) WithTimeouts(timeouts Timeouts) *Client {
+	result := *self
+	result.timeouts = timeouts
+	return &result
+}
+
+// A client sharing the connections of this one whose calls all go to
+// the same server of a pool, chosen by the session.
+func (self *Client) WithSession(session string) *Client {
+	result := *self
+	result.session = session
+	return &result
+}
+
+// A client sharing the connections of this one but with different
+// circuit breaker settings.
+func (self *Client) WithCircuitBreaker(circuit CircuitBreaker) *Client {
+	result := *self
+	result.circuit = circuit
+	return &result
+}
+
+func (self *Client) post(ctx context.Context,
+	path string, req interface{}) (*http.Response, error) {
+	serialized, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	http_req, err := http.NewRequestWithContext(ctx, "POST",
+		self.base_url+path, bytes.NewReader(serialized))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range self.headers() {
+		http_req.Header[k] = v
+	}
+
+	return self.do(http_req, path)
+}
+
+// Headers sent with each request.
+func (self *Client) headers() http.Header {
+	result := http.Header{}
+	for k, v := range self.extra_headers {
+		result[k] = v
+	}
+	result.Set("Content-Type", "application/json")
+	return result
+}
+
+func (self *Client) get(ctx context.Context, path string) (*http.Response, error) {
+	http_req, err := http.NewRequestWithContext(ctx, "GET",
+		self.base_url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range self.extra_headers {
+		http_req.Header[k] = v
+	}
+
+	return self.do(http_req, path)
+}
+
+func (self *Client) do(http_req *http.Request, path string) (*http.Response, error) {
+	if self.session != "" {
+		http_req = http_req.WithContext(
+			withSession(http_req.Context(), self.session))
+	}
+
+	resp, err := self.client.Do(http_req)
+	if err != nil {
+		return nil, newConnectionError(self.base_url+path, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, newHttpError(self.base_url+path, resp.StatusCode, body)
+	}
+
+	return resp, nil
+}
+
+// Send a generate request. The callback receives each chunk of a
+// streaming response, or the single response when not streaming.
+func (self *Client) Generate(ctx context.Context, req *GenerateRequest,
+	cb func(resp *GenerateResponse) error) error {
+	req, err := self.checkGenerate(req)
+	if err != nil {
+		return err
+	}
+
+	req.Model, err = self.checkPin(ctx, "/api/generate", req.Model)
+	if err != nil {
+		return err
+	}
+
+	err = self.circuit_state.Allow(self.circuit, self.base_url+"/api/generate")
+	if err != nil {
+		return err
+	}
+
+	var stats *Stats
+	var cost *float64
+	start := time.Now()
+	record := instrument(req.Model, "/api/generate")
+
+	err = self.generate(ctx, req, func(resp *GenerateResponse) error {
+		if resp.Done {
+			resp.Cost = priceCall(self.settings, self.base_url,
+				req.Model, &resp.Stats)
+			stats = &resp.Stats
+			cost = resp.Cost
+		}
+		return cb(resp)
+	})
+	record(stats, err)
+	self.usage.Record(ctx, req.Model, stats, cost, time.Since(start), err)
+	self.circuit_state.Record(self.circuit, err)
+	return err
+}
+
+func (self *Client) generate(ctx context.Context, req *GenerateRequest,
+	cb func(resp *GenerateResponse) error) error {
+	path := "/api/generate"
+	ctx, watchdog := newWatchdog(ctx, self.timeouts)
+
+	resp, err := self.post(ctx, path, req)
+	if err != nil {
+		return watchdog.Close(self.base_url+path, err)
+	}
+	defer resp.Body.Close()
+
+	var done bool
+	var cb_err error
+	err = readNDJSON(resp.Body, MAX_STREAM_MESSAGE, func(line []byte) error {
+		watchdog.GotToken()
+
+		chunk := &GenerateResponse{}
+		err := json.Unmarshal(line, chunk)
+		if err != nil {
+			return self.invalidResponse(path, line, err)
+		}
+		if chunk.Error != "" {
+			return &Error{Code: ERROR_PROVIDER, ProviderError: chunk.Error,
+				Endpoint: self.base_url + path}
+		}
+		done = chunk.Done
+		cb_err = cb(chunk)
+		return cb_err
+	})
+	err = self.checkStream(path, err)
+	err = self.checkInterrupted(path, done, err, cb_err)
+	return watchdog.Close(self.base_url+path, err)
+}
+
+func (self *Client) Chat(ctx context.Context, req *ChatRequest,
+	cb func(resp *ChatResponse) error) error {
+	req, err := self.checkChat(req)
+	if err != nil {
+		return err
+	}
+
+	req.Model, err = self.checkPin(ctx, "/api/chat", req.Model)
+	if err != nil {
+		return err
+	}
+
+	err = self.circuit_state.Allow(self.circuit, self.base_url+"/api/chat")
+	if err != nil {
+		return err
+	}
+
+	var stats *Stats
+	var cost *float64
+	start := time.Now()
+	record := instrument(req.Model, "/api/chat")
+
+	err = self.chat(ctx, req, func(resp *ChatResponse) error {
+		if resp.Done {
+			resp.Cost = priceCall(self.settings, self.base_url,
+				req.Model, &resp.Stats)
+			stats = &resp.Stats
+			cost = resp.Cost
+		}
+		return cb(resp)
+	})
+	record(stats, err)
+	self.usage.Record(ctx, req.Model, stats, cost, time.Since(start), err)
+	self.circuit_state.Record(self.circuit, err)
+	return err
+}
+
+func (self *Client) chat(ctx context.Context, req *ChatRequest,
+	cb func(resp *ChatResponse) error) error {
+	path := "/api/chat"
+	ctx, watchdog := newWatchdog(ctx, self.timeouts)
+
+	resp, err := self.post(ctx, path, req)
+	if err != nil {
+		return watchdog.Close(self.base_url+path, err)
+	}
+	defer resp.Body.Close()
+
+	var done bool
+	var cb_err error
+	err = readNDJSON(resp.Body, MAX_STREAM_MESSAGE, func(line []byte) error {
+		watchdog.GotToken()
+
+		chunk := &ChatResponse{}
+		err := json.Unmarshal(line, chunk)
+		if err != nil {
+			return self.invalidResponse(path, line, err)
+		}
+		if chunk.Error != "" {
+			return &Error{Code: ERROR_PROVIDER, ProviderError: chunk.Error,
+				Endpoint: self.base_url + path}
+		}
+		done = chunk.Done
+		cb_err = cb(chunk)
+		return cb_err
+	})
+	err = self.checkStream(path, err)
+	err = self.checkInterrupted(path, done, err, cb_err)
+	return watchdog.Close(self.base_url+path, err)
+}
+
+// Continue a partial response to a generate request. The generate
+// API can not continue a response but the chat API continues a
+// trailing assistant message. This does not work with a context
+// array so requests with one start over.
+func (self *Client) Continue(ctx context.Context, req *GenerateRequest,
+	partial string, cb func(resp *GenerateResponse) error) error {
+	if len(req.Context) > 0 || partial == "" {
+		return self.Generate(ctx, req, cb)
+	}
+
+	messages := []*Message{}
+	if req.System != "" {
+		messages = append(messages, &Message{Role: "system", Content: req.System})
+	}
+	messages = append(messages,
+		&Message{Role: "user", Content: req.Prompt},
+		&Message{Role: "assistant", Content: partial})
+
+	return self.Chat(ctx, &ChatRequest{
+		Model:     req.Model,
+		Messages:  messages,
+		Format:    req.Format,
+		Options:   req.Options,
+		KeepAlive: req.KeepAlive,
+		Stream:    true,
+	}, func(resp *ChatResponse) error {
+		chunk := &GenerateResponse{
+			Model:      resp.Model,
+			Done:       resp.Done,
+			DoneReason: resp.DoneReason,
+			Stats:      resp.Stats,
+		}
+		if resp.Message != nil {
+			chunk.Response = resp.Message.Content
+		}
+		return cb(chunk)
+	})
+}
+
+// Fetch information about a model.
+func (self *Client) Show(ctx context.Context, model string) (*ShowResponse, error) {
+	path := "/api/show"
+	model, _ = splitPinnedModel(model)
+	resp, err := self.post(ctx, path, &ShowRequest{Model: model})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ShowResponse{}
+	err = json.Unmarshal(body, result)
+	if err != nil {
+		return nil, self.invalidResponse(path, body, err)
+	}
+	return result, nil
+}
+
+func (self *Client) getJSON(ctx context.Context,
+	path string, result interface{}) error {
+	resp, err := self.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(body, result)
+	if err != nil {
+		return self.invalidResponse(path, body, err)
+	}
+	return nil
+}
+
+// List the models available on the server.
+func (self *Client) List(ctx context.Context) (*ListResponse, error) {
+	result := &ListResponse{}
+	err := self.getJSON(ctx, "/api/tags", result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// List the models currently loaded in memory.
+func (self *Client) Running(ctx context.Context) (*PsResponse, error) {
+	result := &PsResponse{}
+	err := self.getJSON(ctx, "/api/ps", result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (self *Client) Version(ctx context.Context) (string, error) {
+	result := &VersionResponse{}
+	err := self.getJSON(ctx, "/api/version", result)
+	if err != nil {
+		return "", err
+	}
+	return result.Version, nil
+}
+
+// The digest of the model's weights.
+func (self *Client) ModelDigest(ctx context.Context, model string) (string, error) {
+	model, _ = splitPinnedModel(model)
+	list, err := self.List(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	item := findModel(list.Models, model)
+	if item == nil {
+		return "", fmt.Errorf("ollama: model %v not found", model)
+	}
+	return item.Digest, nil
+}
+
+// Models named without a tag refer to the latest tag.
+func findModel(models []*ModelSummary, model string) *ModelSummary {
+	if !strings.Contains(model, ":") {
+		model += ":latest"
+	}
+
+	for _, item := range models {
+		if item.Name == model || item.Model == model {
+			return item
+		}
+	}
+	return nil
+}
+
+func (self *Client) Embed(ctx context.Context,
+	req *EmbedRequest) (*EmbedResponse, error) {
+	err := self.checkModel("/api/embed", req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := self.checkPin(ctx, "/api/embed", req.Model)
+	if err != nil {
+		return nil, err
+	}
+	pinned := *req
+	pinned.Model = name
+	req = &pinned
+
+	err = self.circuit_state.Allow(self.circuit, self.base_url+"/api/embed")
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	record := instrument(req.Model, "/api/embed")
+
+	result, err := self.embed(ctx, req)
+	self.circuit_state.Record(self.circuit, err)
+	if err != nil {
+		record(nil, err)
+		self.usage.Record(ctx, req.Model, nil, nil, time.Since(start), err)
+		return nil, err
+	}
+
+	stats := &Stats{PromptEvalCount: result.PromptEvalCount}
+	record(stats, nil)
+	self.usage.Record(ctx, req.Model, stats, nil, time.Since(start), nil)
+	return result, nil
+}
+
+func (self *Client) embed(ctx context.Context,
+	req *EmbedRequest) (*EmbedResponse, error) {
+	path := "/api/embed"
+	resp, err := self.post(ctx, path, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &EmbedResponse{}
+	err = json.Unmarshal(body, result)
+	if err != nil {
+		return nil, self.invalidResponse(path, body, err)
+	}
+
+	if len(result.Embeddings) != len(req.Input) {
+		return nil, fmt.Errorf("ollama: expected %v embeddings but got %v",
+			len(req.Input), len(result.Embeddings))
+	}
+	return result, nil
+}
+
+// A stream which could not be decoded is an invalid response rather
+// than an interrupted one so is not resumed.
+func (self *Client) checkStream(path string, err error) error {
+	var stream_err *streamError
+	if errors.As(err, &stream_err) {
+		return self.invalidResponse(path, stream_err.data, stream_err.err)
+	}
+	return err
+}
+
+// The connection was lost or closed before the final chunk. Errors
+// from the callback are passed through.
+func (self *Client) checkInterrupted(path string, done bool, err, cb_err error) error {
+	if err == nil && !done {
+		err = errors.New("response ended before it was complete")
+	}
+
+	var llm_err *Error
+	if err == nil || err == cb_err || errors.As(err, &llm_err) {
+		return err
+	}
+
+	return &Error{Code: ERROR_INTERRUPTED, Retryable: true,
+		Endpoint: self.base_url + path, Err: err}
+}
+
+// The server answered but not with the expected JSON. Keep the start
+// of the body as it usually explains what went wrong.
+func (self *Client) invalidResponse(path string, body []byte, err error) error {
+	return &Error{Code: ERROR_INVALID_RESPONSE, Endpoint: self.base_url + path,
+		ResponseBody: elideBody(body),
+		Err:          fmt.Errorf("invalid response: %w", err)}
+}
@@ -0,0 +1,46 @@
+package ollama
+
+import (
+	"math"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/vfilter"
+)
+
+// Aggregates the log probabilities of the generated tokens into a
+// confidence score for the whole response.
+type confidenceTracker struct {
+	sum   float64
+	count int64
+}
+
+func (self *confidenceTracker) Add(logprobs []*TokenLogprob) {
+	for _, logprob := range logprobs {
+		self.sum += logprob.Logprob
+		self.count++
+	}
+}
+
+// The geometric mean of the token probabilities, between 0 and 1. A
+// few unlikely tokens lower the score without a long response
+// driving it towards 0 as the product of all probabilities would.
+func (self *confidenceTracker) Score() (float64, bool) {
+	if self.count == 0 {
+		return 0, false
+	}
+	return math.Exp(self.sum / float64(self.count)), true
+}
+
+// Set the Confidence column. It is Null when the server returned no
+// log probabilities, for example because it is too old to support
+// them.
+func (self *confidenceTracker) SetColumn(scope vfilter.Scope,
+	row *ordereddict.Dict) {
+	score, ok := self.Score()
+	if !ok {
+		scope.Log("DEBUG:ollama: The server returned no logprobs so the confidence is unknown")
+		row.Set("Confidence", vfilter.Null{})
+		return
+	}
+	row.Set("Confidence", score)
+}
@@ -0,0 +1,267 @@
+package ollama
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/accessors"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/json"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	DEFAULT_CREATE_TIMEOUT = 3600
+)
+
+type OllamaCreateFunctionArgs struct {
+	Model      string            `vfilter:"required,field=model,doc=The name of the model to create (e.g. llama3-offline)."`
+	File       *accessors.OSPath `vfilter:"required,field=file,doc=The GGUF file holding the model weights, e.g. a tool fetched with Generic.Utils.FetchBinary."`
+	Accessor   string            `vfilter:"optional,field=accessor,doc=The accessor used to read file (default auto)."`
+	System     string            `vfilter:"optional,field=system,doc=The system prompt of the model."`
+	Template   string            `vfilter:"optional,field=template,doc=The prompt template of the model (default the one in the GGUF file)."`
+	Parameters *ordereddict.Dict `vfilter:"optional,field=parameters,doc=Model parameters (e.g. num_ctx, temperature)."`
+	BaseUrl    string            `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server."`
+	Timeout    int64             `vfilter:"optional,field=timeout,doc=Seconds the upload and create may take (default 3600)."`
+}
+
+// Registers model weights with an Ollama server so offline and
+// endpoint deployments can be given models through the tools
+// mechanism: the server distributes the GGUF file as a tool and this
+// pushes it to the local Ollama and creates the model from it. The
+// file is only uploaded if the server does not already have it.
+type OllamaCreateFunction struct{}
+
+func (self OllamaCreateFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("ollama_create", args)()
+
+	err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+	if err != nil {
+		scope.Log("ollama_create: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg := &OllamaCreateFunctionArgs{}
+	err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("ollama_create: %v", err)
+		return vfilter.Null{}
+	}
+
+	if arg.Timeout == 0 {
+		arg.Timeout = DEFAULT_CREATE_TIMEOUT
+	}
+
+	err = vql_subsystem.CheckFilesystemAccess(scope, arg.Accessor)
+	if err != nil {
+		scope.Log("ollama_create: %v", err)
+		return vfilter.Null{}
+	}
+
+	accessor, err := accessors.GetAccessor(arg.Accessor, scope)
+	if err != nil {
+		scope.Log("ollama_create: %v", err)
+		return vfilter.Null{}
+	}
+
+	client, err := NewClient(ctx, scope, arg.BaseUrl)
+	if err != nil {
+		scope.Log("ollama_create: %v", err)
+		return vfilter.Null{}
+	}
+
+	// The blob must be on the server the model is created on.
+	if client.pool != nil {
+		scope.Log("ollama_create: base_url should be a single server")
+		return vfilter.Null{}
+	}
+
+	sub_ctx, cancel := context.WithTimeout(ctx,
+		time.Duration(arg.Timeout)*time.Second)
+	defer cancel()
+
+	result := ordereddict.NewDict().
+		Set("Model", arg.Model).
+		Set("Created", false).
+		Set("Digest", "").
+		Set("Size", int64(0)).
+		Set("Uploaded", false).
+		Set("Status", "")
+
+	err = createModel(sub_ctx, client, accessor, arg, result)
+	if err != nil {
+		scope.Log("ollama_create: %v", err)
+		return setHealthError(result, err)
+	}
+
+	return result.Update("Created", true)
+}
+
+func createModel(ctx context.Context, client *Client,
+	accessor accessors.FileSystemAccessor,
+	arg *OllamaCreateFunctionArgs, result *ordereddict.Dict) error {
+	fd, err := accessor.OpenWithOSPath(arg.File)
+	if err != nil {
+		return fmt.Errorf("Unable to open %v: %w", arg.File, err)
+	}
+	defer fd.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, fd)
+	if err != nil {
+		return err
+	}
+	digest := "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+	result.Update("Digest", digest).Update("Size", size)
+
+	present, err := client.HasBlob(ctx, digest)
+	if err != nil {
+		return err
+	}
+
+	if !present {
+		_, err = fd.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		err = client.PushBlob(ctx, digest, fd, size)
+		if err != nil {
+			return err
+		}
+		result.Update("Uploaded", true)
+	}
+
+	// The server recognizes the format of the weights by the file
+	// name, which tools do not always keep.
+	filename := arg.File.Basename()
+	if !strings.HasSuffix(strings.ToLower(filename), ".gguf") {
+		filename += ".gguf"
+	}
+
+	return client.Create(ctx, &CreateRequest{
+		Model:      arg.Model,
+		Files:      map[string]string{filename: digest},
+		System:     arg.System,
+		Template:   arg.Template,
+		Parameters: arg.Parameters,
+		Stream:     true,
+	}, func(resp *ProgressResponse) error {
+		result.Update("Status", resp.Status)
+		return nil
+	})
+}
+
+// Returns true if the server already has the blob.
+func (self *Client) HasBlob(ctx context.Context, digest string) (bool, error) {
+	path := "/api/blobs/" + digest
+	http_req, err := http.NewRequestWithContext(ctx, "HEAD",
+		self.base_url+path, nil)
+	if err != nil {
+		return false, err
+	}
+	for k, v := range self.extra_headers {
+		http_req.Header[k] = v
+	}
+
+	resp, err := self.client.Do(http_req)
+	if err != nil {
+		return false, newConnectionError(self.base_url+path, err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, newHttpError(self.base_url+path, resp.StatusCode, nil)
+	}
+}
+
+// Upload a blob to the server. The server checks it against the
+// digest.
+func (self *Client) PushBlob(ctx context.Context,
+	digest string, reader io.Reader, size int64) error {
+	path := "/api/blobs/" + digest
+	http_req, err := http.NewRequestWithContext(ctx, "POST",
+		self.base_url+path, reader)
+	if err != nil {
+		return err
+	}
+	http_req.ContentLength = size
+	for k, v := range self.extra_headers {
+		http_req.Header[k] = v
+	}
+	http_req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := self.client.Do(http_req)
+	if err != nil {
+		return newConnectionError(self.base_url+path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK &&
+		resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return newHttpError(self.base_url+path, resp.StatusCode, body)
+	}
+	return nil
+}
+
+// Create a model from blobs on the server. The callback receives each
+// status update.
+func (self *Client) Create(ctx context.Context, req *CreateRequest,
+	cb func(resp *ProgressResponse) error) error {
+	path := "/api/create"
+	err := self.checkModel(path, req.Model)
+	if err != nil {
+		return err
+	}
+
+	resp, err := self.post(ctx, path, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	err = readNDJSON(resp.Body, MAX_STREAM_MESSAGE, func(line []byte) error {
+		progress := &ProgressResponse{}
+		err := json.Unmarshal(line, progress)
+		if err != nil {
+			return self.invalidResponse(path, line, err)
+		}
+		if progress.Error != "" {
+			return &Error{Code: ERROR_PROVIDER, ProviderError: progress.Error,
+				Endpoint: self.base_url + path}
+		}
+		return cb(progress)
+	})
+	return self.checkStream(path, err)
+}
+
+func (self OllamaCreateFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:     "ollama_create",
+		Doc:      "Create a model on an Ollama server from a GGUF file, such as one distributed as a tool.",
+		ArgType:  type_map.AddType(scope, &OllamaCreateFunctionArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER, acls.FILESYSTEM_READ).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&OllamaCreateFunction{})
+}
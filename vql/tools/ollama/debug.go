@@ -0,0 +1,73 @@
+package ollama
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	// How much of the prompt is shown in debug rows.
+	DEBUG_PROMPT_BYTES = 4096
+)
+
+var (
+	secret_header_regex = regexp.MustCompile(
+		`(?i)authorization|cookie|token|secret|key|password`)
+)
+
+// Describe the request about to be sent so prompts can be tuned
+// without capturing traffic.
+func debugRow(client *Client, req *GenerateRequest) *ordereddict.Dict {
+	serialized, _ := json.Marshal(req)
+
+	return ordereddict.NewDict().
+		Set("Model", req.Model).
+		Set("Debug", ordereddict.NewDict().
+			Set("Endpoint", redactUrl(client.base_url+"/api/generate")).
+			Set("Prompt", utils.Elide(req.Prompt, DEBUG_PROMPT_BYTES)).
+			Set("System", utils.Elide(req.System, DEBUG_PROMPT_BYTES)).
+			Set("Options", req.Options).
+			Set("Format", req.Format).
+			Set("ContextTokens", len(req.Context)).
+			Set("RequestSize", len(serialized)).
+			Set("Headers", maskHeaders(client.headers())))
+}
+
+func maskHeaders(headers http.Header) *ordereddict.Dict {
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	result := ordereddict.NewDict()
+	for _, k := range names {
+		if secret_header_regex.MatchString(k) {
+			result.Set(k, "********")
+		} else {
+			result.Set(k, headers.Get(k))
+		}
+	}
+	return result
+}
+
+// Emit a debug row before each call made by generate.
+func debugGenerate(client *Client, generate generateFunc,
+	output_chan chan vfilter.Row) generateFunc {
+	return func(ctx context.Context, req *GenerateRequest,
+		cb func(resp *GenerateResponse) error) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case output_chan <- debugRow(client, req):
+		}
+		return generate(ctx, req, cb)
+	}
+}
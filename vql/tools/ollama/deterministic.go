@@ -0,0 +1,27 @@
+package ollama
+
+import (
+	"github.com/Velocidex/ordereddict"
+)
+
+const (
+	DETERMINISTIC_SEED = 42
+)
+
+// Options which make the model choose the most likely token every
+// time so the same prompt gives the same response. Other options
+// given by the caller are kept.
+func deterministicOptions(options *ordereddict.Dict) *ordereddict.Dict {
+	result := ordereddict.NewDict()
+	if options != nil {
+		for _, k := range options.Keys() {
+			v, _ := options.Get(k)
+			result.Set(k, v)
+		}
+	}
+
+	return result.
+		Set("seed", DETERMINISTIC_SEED).
+		Set("temperature", 0).
+		Set("top_k", 1)
+}
@@ -0,0 +1,102 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	SRV_SCHEME = "srv://"
+
+	SRV_LOOKUP_TIMEOUT = 10 * time.Second
+)
+
+var (
+	// Replaced in tests.
+	lookupSRV = net.DefaultResolver.LookupSRV
+)
+
+// Servers may be found through a DNS SRV record, e.g.
+// srv://_ollama._tcp.example.com, so a fleet of analysis servers
+// shares one name rather than each listing the GPU hosts. The hosts
+// are called over http unless the url ends with ?scheme=https (or
+// openai+https for servers speaking the OpenAI API).
+func isSrvUrl(base_url string) bool {
+	return strings.HasPrefix(base_url, SRV_SCHEME)
+}
+
+// Only the targets with the lowest priority are used - the others
+// are backups for when all of those are gone and the record is
+// changed.
+func resolveSrvUrl(ctx context.Context, base_url string) ([]string, error) {
+	name, query, _ := strings.Cut(strings.TrimPrefix(base_url, SRV_SCHEME), "?")
+	name = strings.TrimSuffix(name, "/")
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", base_url, err)
+	}
+
+	scheme := params.Get("scheme")
+	switch scheme {
+	case "":
+		scheme = "http"
+	case "http", "https", "openai+http", "openai+https":
+	default:
+		return nil, fmt.Errorf(
+			"%v: scheme should be http, https, openai+http or openai+https not %v",
+			base_url, scheme)
+	}
+
+	sub_ctx, cancel := context.WithTimeout(ctx, SRV_LOOKUP_TIMEOUT)
+	defer cancel()
+
+	_, records, err := lookupSRV(sub_ctx, "", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("Looking up %v: %w", base_url, err)
+	}
+
+	// Records are sorted by priority.
+	result := []string{}
+	for _, record := range records {
+		if record.Priority != records[0].Priority {
+			break
+		}
+
+		// A target of . means the service is not available.
+		target := strings.TrimSuffix(record.Target, ".")
+		if target == "" {
+			continue
+		}
+
+		result = append(result, fmt.Sprintf("%v://%v", scheme,
+			net.JoinHostPort(target, fmt.Sprintf("%d", record.Port))))
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%v has no servers", base_url)
+	}
+	return result, nil
+}
+
+// Replace srv:// urls by the servers they name.
+func expandSrvUrls(ctx context.Context, base_urls []string) ([]string, error) {
+	result := make([]string, 0, len(base_urls))
+	for _, base_url := range base_urls {
+		if !isSrvUrl(base_url) {
+			result = append(result, base_url)
+			continue
+		}
+
+		resolved, err := resolveSrvUrl(ctx, base_url)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, resolved...)
+	}
+	return result, nil
+}
@@ -0,0 +1,499 @@
+package ollama
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// Documents are read whole so this bounds the memory used.
+	MAX_DOCUMENT_SIZE = 16 * 1024 * 1024
+
+	// Compressed parts may expand a lot - stop before they exhaust
+	// memory.
+	MAX_DOCUMENT_PART = 16 * 1024 * 1024
+)
+
+var (
+	errUnsupportedDocument = errors.New(
+		"Unsupported document type: should be PDF, DOCX or XLSX")
+
+	pdf_stream_regex = regexp.MustCompile(`(?s)stream\r?\n`)
+	blank_line_regex = regexp.MustCompile(`\n[ \t]*\n(?:[ \t]*\n)+`)
+)
+
+// Extract the text of a document so a model can be asked about it
+// without including the raw file in the prompt. Only the text is
+// extracted - macros, images and formatting are ignored.
+func extractDocumentText(data []byte) (string, error) {
+	var text string
+	var err error
+
+	switch {
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		text = extractPDFText(data)
+
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		text, err = extractOfficeText(data)
+
+	default:
+		return "", errUnsupportedDocument
+	}
+	if err != nil {
+		return "", err
+	}
+
+	text = blank_line_regex.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(cleanText(text)), nil
+}
+
+// DOCX and XLSX files are zip files of XML parts.
+func extractOfficeText(data []byte) (string, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+
+	parts := make(map[string]*zip.File)
+	for _, f := range reader.File {
+		parts[f.Name] = f
+	}
+
+	document, pres := parts["word/document.xml"]
+	if pres {
+		return extractDocxText(document)
+	}
+
+	var sheets []string
+	for name := range parts {
+		if path.Dir(name) == "xl/worksheets" &&
+			strings.HasSuffix(name, ".xml") {
+			sheets = append(sheets, name)
+		}
+	}
+	if len(sheets) > 0 {
+		sort.Strings(sheets)
+		return extractXlsxText(parts, sheets)
+	}
+
+	return "", errUnsupportedDocument
+}
+
+func openPart(f *zip.File) (*xml.Decoder, func() error, error) {
+	fd, err := f.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	return xml.NewDecoder(io.LimitReader(fd, MAX_DOCUMENT_PART)), fd.Close, nil
+}
+
+// Paragraphs are on separate lines. Text is in w:t elements.
+func extractDocxText(f *zip.File) (string, error) {
+	decoder, closer, err := openPart(f)
+	if err != nil {
+		return "", err
+	}
+	defer closer()
+
+	result := &strings.Builder{}
+	in_text := false
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				in_text = true
+			case "tab":
+				result.WriteString("\t")
+			case "br", "cr":
+				result.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				in_text = false
+			case "p":
+				result.WriteString("\n")
+			}
+		case xml.CharData:
+			if in_text {
+				result.Write(t)
+			}
+		}
+	}
+	return result.String(), nil
+}
+
+// Each row of each sheet is a line with tab separated cells. Most
+// cells refer to the shared strings table.
+func extractXlsxText(parts map[string]*zip.File, sheets []string) (string, error) {
+	var shared []string
+	shared_part, pres := parts["xl/sharedStrings.xml"]
+	if pres {
+		var err error
+		shared, err = readXlsxStrings(shared_part)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	result := &strings.Builder{}
+	for _, name := range sheets {
+		fmt.Fprintf(result, "%v:\n", strings.TrimSuffix(path.Base(name), ".xml"))
+		err := readXlsxSheet(parts[name], shared, result)
+		if err != nil {
+			return "", err
+		}
+		result.WriteString("\n")
+	}
+	return result.String(), nil
+}
+
+func readXlsxStrings(f *zip.File) ([]string, error) {
+	decoder, closer, err := openPart(f)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	var result []string
+	current := &strings.Builder{}
+	in_text := false
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "t" {
+				in_text = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				in_text = false
+			case "si":
+				result = append(result, current.String())
+				current.Reset()
+			}
+		case xml.CharData:
+			if in_text {
+				current.Write(t)
+			}
+		}
+	}
+	return result, nil
+}
+
+func readXlsxSheet(f *zip.File, shared []string, result *strings.Builder) error {
+	decoder, closer, err := openPart(f)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	var cells []string
+	var cell_type string
+	value := &strings.Builder{}
+	in_value := false
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "c":
+				cell_type = ""
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "t" {
+						cell_type = attr.Value
+					}
+				}
+				value.Reset()
+			case "v", "t":
+				in_value = true
+			}
+
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v", "t":
+				in_value = false
+			case "c":
+				cell := value.String()
+				if cell_type == "s" {
+					idx, err := strconv.Atoi(cell)
+					if err == nil && idx >= 0 && idx < len(shared) {
+						cell = shared[idx]
+					}
+				}
+				cells = append(cells, cell)
+			case "row":
+				result.WriteString(strings.Join(cells, "\t") + "\n")
+				cells = nil
+			}
+
+		case xml.CharData:
+			if in_value {
+				value.Write(t)
+			}
+		}
+	}
+	return nil
+}
+
+// A best effort extraction of the text shown by the page content
+// streams. Text drawn with embedded fonts using custom encodings
+// can not be recovered without the font's character map so may be
+// missing or garbled.
+func extractPDFText(data []byte) string {
+	result := &strings.Builder{}
+	for _, match := range pdf_stream_regex.FindAllIndex(data, -1) {
+		start := match[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		stream := data[start : start+end]
+
+		// The stream's dictionary says how it is encoded.
+		dict := data[:match[0]]
+		dict_start := bytes.LastIndex(dict, []byte("<<"))
+		if dict_start >= 0 {
+			dict = dict[dict_start:]
+		}
+
+		if bytes.Contains(dict, []byte("/Image")) {
+			continue
+		}
+
+		if bytes.Contains(dict, []byte("/Filter")) {
+			if !bytes.Contains(dict, []byte("/FlateDecode")) {
+				continue
+			}
+
+			reader, err := zlib.NewReader(bytes.NewReader(stream))
+			if err != nil {
+				continue
+			}
+			// Streams are often truncated or padded so keep what
+			// was decompressed before an error.
+			stream, _ = io.ReadAll(io.LimitReader(reader, MAX_DOCUMENT_PART))
+		}
+
+		if bytes.Contains(stream, []byte("BT")) {
+			extractPDFContentText(stream, result)
+		}
+	}
+	return result.String()
+}
+
+// Parse a content stream, writing the strings shown by the text
+// operators. Other operators and their operands are skipped.
+func extractPDFContentText(content []byte, result *strings.Builder) {
+	var operands []string
+	i := 0
+	for i < len(content) {
+		c := content[i]
+		switch {
+		case isPDFSpace(c):
+			i++
+
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+
+		case c == '(':
+			var value []byte
+			value, i = readPDFLiteral(content, i+1)
+			operands = append(operands, decodePDFString(value))
+
+		case c == '<' && i+1 < len(content) && content[i+1] == '<',
+			c == '>' && i+1 < len(content) && content[i+1] == '>':
+			i += 2
+
+		case c == '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return
+			}
+			operands = append(operands,
+				decodePDFString(decodePDFHex(content[i+1:i+end])))
+			i += end + 1
+
+		case c == '[' || c == ']' || c == '{' || c == '}' || c == '>' || c == ')':
+			i++
+
+		default:
+			start := i
+			if c == '/' {
+				i++
+			}
+			for i < len(content) && !isPDFSpace(content[i]) &&
+				!isPDFDelimiter(content[i]) {
+				i++
+			}
+			token := string(content[start:i])
+			if i == start {
+				i++
+				continue
+			}
+
+			switch token {
+			case "Tj", "TJ":
+				result.WriteString(strings.Join(operands, ""))
+			case "'", "\"":
+				result.WriteString("\n" + strings.Join(operands, ""))
+			case "T*", "Td", "TD", "ET":
+				result.WriteString("\n")
+			default:
+				// Numbers and names are operands.
+				if c == '/' || strings.ContainsAny(token[:1], "+-.0123456789") {
+					continue
+				}
+			}
+			operands = nil
+		}
+	}
+}
+
+func isPDFSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0:
+		return true
+	}
+	return false
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// Read a literal string up to its closing bracket. Brackets nest
+// and may be escaped.
+func readPDFLiteral(content []byte, i int) ([]byte, int) {
+	var result []byte
+	depth := 1
+	for i < len(content) {
+		c := content[i]
+		i++
+		switch c {
+		case '\\':
+			if i >= len(content) {
+				return result, i
+			}
+			escaped := content[i]
+			i++
+			switch escaped {
+			case 'n':
+				result = append(result, '\n')
+			case 'r':
+				result = append(result, '\r')
+			case 't':
+				result = append(result, '\t')
+			case 'b':
+				result = append(result, '\b')
+			case 'f':
+				result = append(result, '\f')
+			case '\r', '\n':
+				// A line continuation.
+			default:
+				if escaped >= '0' && escaped <= '7' {
+					value := int(escaped - '0')
+					for n := 0; n < 2 && i < len(content) &&
+						content[i] >= '0' && content[i] <= '7'; n++ {
+						value = value*8 + int(content[i]-'0')
+						i++
+					}
+					result = append(result, byte(value))
+				} else {
+					result = append(result, escaped)
+				}
+			}
+		case '(':
+			depth++
+			result = append(result, c)
+		case ')':
+			depth--
+			if depth == 0 {
+				return result, i
+			}
+			result = append(result, c)
+		default:
+			result = append(result, c)
+		}
+	}
+	return result, i
+}
+
+func decodePDFHex(hex []byte) []byte {
+	var digits []byte
+	for _, c := range hex {
+		if !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	// A missing final digit is taken as 0.
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+
+	result := make([]byte, 0, len(digits)/2)
+	for i := 0; i < len(digits); i += 2 {
+		value, err := strconv.ParseUint(string(digits[i:i+2]), 16, 8)
+		if err != nil {
+			break
+		}
+		result = append(result, byte(value))
+	}
+	return result
+}
+
+// Strings are UTF16 if they start with a byte order mark and
+// otherwise close enough to Latin1 for a model to read.
+func decodePDFString(value []byte) string {
+	if bytes.HasPrefix(value, []byte{0xfe, 0xff}) {
+		value = value[2:]
+		units := make([]uint16, 0, len(value)/2)
+		for i := 0; i+1 < len(value); i += 2 {
+			units = append(units, uint16(value[i])<<8|uint16(value[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+
+	runes := make([]rune, 0, len(value))
+	for _, c := range value {
+		runes = append(runes, rune(c))
+	}
+	return string(runes)
+}
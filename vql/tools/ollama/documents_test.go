@@ -0,0 +1,70 @@
+package ollama
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeZip(t *testing.T, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	writer := zip.NewWriter(buf)
+	for name, content := range files {
+		fd, err := writer.Create(name)
+		require.NoError(t, err)
+		_, err = fd.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func TestExtractDocumentText(t *testing.T) {
+	docx := makeZip(t, map[string]string{
+		"word/document.xml": `<?xml version="1.0"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:body>
+<w:p><w:r><w:t>Enable</w:t></w:r><w:r><w:t xml:space="preserve"> editing</w:t></w:r></w:p>
+<w:p><w:r><w:t>Then</w:t><w:tab/><w:t>click &quot;Enable Content&quot;</w:t></w:r></w:p>
+</w:body></w:document>`,
+	})
+	text, err := extractDocumentText(docx)
+	require.NoError(t, err)
+	assert.Equal(t, "Enable editing\nThen\tclick \"Enable Content\"", text)
+
+	xlsx := makeZip(t, map[string]string{
+		"xl/sharedStrings.xml": `<sst><si><t>Invoice</t></si><si><r><t>Pay </t></r><r><t>now</t></r></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData>
+<row><c t="s"><v>0</v></c><c><v>42</v></c></row>
+<row><c t="s"><v>1</v></c><c t="inlineStr"><is><t>today</t></is></c></row>
+</sheetData></worksheet>`,
+	})
+	text, err = extractDocumentText(xlsx)
+	require.NoError(t, err)
+	assert.Equal(t, "sheet1:\nInvoice\t42\nPay now\ttoday", text)
+
+	content := &bytes.Buffer{}
+	writer := zlib.NewWriter(content)
+	fmt.Fprintf(writer, `BT /F1 12 Tf 72 712 Td (Your account \(ID 7\) is) Tj `+
+		`0 -14 Td [(sus) -20 (pended)] TJ T* <FEFF0050006100790020> Tj ET`)
+	require.NoError(t, writer.Close())
+
+	pdf := &bytes.Buffer{}
+	fmt.Fprintf(pdf, "%%PDF-1.4\n1 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n",
+		content.Len())
+	pdf.Write(content.Bytes())
+	fmt.Fprintf(pdf, "\nendstream\nendobj\n2 0 obj\n<< /Subtype /Image /Length 4 >>\n"+
+		"stream\nBT (x) Tj\nendstream\nendobj\n%%%%EOF\n")
+
+	text, err = extractDocumentText(pdf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, "Your account (ID 7) is\nsuspended\nPay", text)
+
+	_, err = extractDocumentText([]byte("MZ\x90\x00"))
+	assert.Error(t, err)
+}
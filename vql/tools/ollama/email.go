@@ -0,0 +1,226 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/accessors"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/json"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	DEFAULT_MAX_BODY = 16 * 1024
+
+	EMAIL_PROMPT = "Decide if this email is phishing, malware delivery " +
+		"or otherwise malicious. Base the verdict only on the facts " +
+		"below and do not assume anything which is not shown. " +
+		VERDICT_INSTRUCTIONS
+)
+
+// The response is constrained to the standard verdict so it can be
+// used by the query.
+var email_verdict_schema = verdictSchema(nil)
+
+type OllamaEmailPluginArgs struct {
+	File      *accessors.OSPath `vfilter:"required,field=file,doc=The .eml or .msg file to analyse."`
+	Accessor  string            `vfilter:"optional,field=accessor,doc=The accessor used to read file (default auto)."`
+	Model     string            `vfilter:"optional,field=model,doc=The model to use (e.g. llama3). Defaults to AI.default_model in the config."`
+	Prompt    string            `vfilter:"optional,field=prompt,doc=Additional instructions for the analysis (e.g. what the organisation's legitimate senders are)."`
+	MaxBody   int64             `vfilter:"optional,field=max_body,doc=The most bytes of the message body to include (default 16kb)."`
+	BaseUrl   string            `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server. Several comma separated URLs, or srv://name for the servers of a DNS SRV record, spread the calls over the servers."`
+	Timeout   int64             `vfilter:"optional,field=timeout,doc=Seconds the call may take (default 3600)."`
+	Options   *ordereddict.Dict `vfilter:"optional,field=options,doc=Model options (e.g. temperature, num_ctx)."`
+	KeepAlive string            `vfilter:"optional,field=keep_alive,doc=How long the model stays loaded after the call (e.g. 5m)."`
+}
+
+// Parses an email and asks the model for a verdict on it. The model
+// is only shown the parsed facts - headers, body text, links and
+// attachment hashes - rather than the raw message, so encoded parts
+// and hidden content do not confuse it.
+type OllamaEmailPlugin struct{}
+
+func (self OllamaEmailPlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("ollama_email", args)()
+
+		err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+		if err != nil {
+			scope.Log("ollama_email: %v", err)
+			return
+		}
+
+		arg := &OllamaEmailPluginArgs{}
+		err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+		if err != nil {
+			scope.Log("ollama_email: %v", err)
+			return
+		}
+
+		if arg.MaxBody == 0 {
+			arg.MaxBody = DEFAULT_MAX_BODY
+		}
+
+		email, err := readEmail(scope, arg)
+		if err != nil {
+			scope.Log("ollama_email: %v", err)
+			return
+		}
+
+		client, err := NewClient(ctx, scope, arg.BaseUrl)
+		if err != nil {
+			scope.Log("ollama_email: %v", err)
+			return
+		}
+
+		arg.Model, err = client.Model(arg.Model)
+		if err != nil {
+			scope.Log("ollama_email: %v", err)
+			return
+		}
+
+		client = client.WithTimeouts(Timeouts{
+			Total: time.Duration(arg.Timeout) * time.Second,
+		})
+		generate := logGenerate(scope, client, client.Generate)
+
+		row := ordereddict.NewDict().
+			Set("File", arg.File).
+			Set("From", email.From).
+			Set("ReplyTo", email.ReplyTo).
+			Set("To", email.To).
+			Set("Subject", email.Subject).
+			Set("Date", email.Date).
+			Set("Urls", email.Urls).
+			Set("Attachments", email.Attachments)
+
+		prompt := EMAIL_PROMPT
+		if arg.Prompt != "" {
+			prompt += "\n\n" + arg.Prompt
+		}
+
+		verdict, err := self.analyse(ctx, scope, arg, prompt, email, generate)
+		if err != nil {
+			scope.Log("ollama_email: %v", err)
+			setVerdictError(row, arg.Model, prompt)
+			row.Set("Error", err.Error())
+
+		} else {
+			setVerdictColumns(row, verdict, arg.Model, prompt)
+		}
+
+		select {
+		case <-ctx.Done():
+		case output_chan <- row:
+		}
+	}()
+
+	return output_chan
+}
+
+func (self OllamaEmailPlugin) analyse(ctx context.Context,
+	scope vfilter.Scope, arg *OllamaEmailPluginArgs, prompt string,
+	email *emailMessage, generate generateFunc) (*ordereddict.Dict, error) {
+	if int64(len(email.Body)) > arg.MaxBody {
+		email.Body = strings.ToValidUTF8(email.Body[:arg.MaxBody], "") +
+			" ..."
+	}
+
+	facts, err := json.MarshalIndent(email)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &GenerateRequest{
+		Model:     arg.Model,
+		Prompt:    prompt + "\n\n" + string(facts),
+		Format:    email_verdict_schema,
+		Options:   arg.Options,
+		KeepAlive: arg.KeepAlive,
+	}
+
+	response, err := generateText(ctx, generate, req)
+	if err != nil {
+		return nil, err
+	}
+
+	validator, err := newResponseValidator(email_verdict_schema, true)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := validator.Repair(ctx, scope, generate, req,
+		response, nil, DEFAULT_REPAIR_ATTEMPTS)
+	if err != nil {
+		return nil, err
+	}
+
+	verdict, ok := result.Parsed.(*ordereddict.Dict)
+	if !ok || len(result.Errors) > 0 {
+		return nil, fmt.Errorf("Invalid verdict: %v",
+			strings.Join(result.Errors, "; "))
+	}
+	return verdict, nil
+}
+
+func readEmail(scope vfilter.Scope,
+	arg *OllamaEmailPluginArgs) (*emailMessage, error) {
+	err := vql_subsystem.CheckFilesystemAccess(scope, arg.Accessor)
+	if err != nil {
+		return nil, err
+	}
+
+	accessor, err := accessors.GetAccessor(arg.Accessor, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := accessor.OpenWithOSPath(arg.File)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open %v: %w", arg.File, err)
+	}
+	defer fd.Close()
+
+	data, err := io.ReadAll(io.LimitReader(fd, MAX_DOCUMENT_SIZE+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > MAX_DOCUMENT_SIZE {
+		return nil, fmt.Errorf("Email %v is larger than %v bytes",
+			arg.File, MAX_DOCUMENT_SIZE)
+	}
+
+	email, err := parseEmail(data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse %v: %w", arg.File, err)
+	}
+	return email, nil
+}
+
+func (self OllamaEmailPlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name:     "ollama_email",
+		Doc:      "Parse an .eml or .msg file and ask a model for a verdict on it.",
+		ArgType:  type_map.AddType(scope, &OllamaEmailPluginArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterPlugin(&OllamaEmailPlugin{})
+}
@@ -0,0 +1,385 @@
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+
+	"www.velocidex.com/golang/oleparse"
+)
+
+const (
+	// Nested multipart messages deeper than this are not parsed.
+	MAX_EMAIL_DEPTH = 10
+
+	MAX_EMAIL_PARTS = 100
+	MAX_EMAIL_URLS  = 100
+
+	// Outlook .msg files are OLE compound files.
+	OLE_SIGNATURE = "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"
+)
+
+var (
+	email_url_regex    = regexp.MustCompile(`(?i)\bhttps?://[^\s"'<>()\[\]{}]+`)
+	email_script_regex = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+	email_tag_regex    = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+type emailAttachment struct {
+	Name        string
+	ContentType string
+	Size        int
+	MD5         string
+	SHA256      string
+}
+
+// The parts of an email relevant to triage, the same for .eml and
+// .msg files.
+type emailMessage struct {
+	From                  string
+	To                    string
+	Cc                    string
+	ReplyTo               string
+	ReturnPath            string
+	Subject               string
+	Date                  string
+	MessageId             string
+	AuthenticationResults []string
+	Received              []string
+	Body                  string
+	Urls                  []string
+	Attachments           []*emailAttachment
+
+	html string
+}
+
+func parseEmail(data []byte) (*emailMessage, error) {
+	var result *emailMessage
+	var err error
+	if bytes.HasPrefix(data, []byte(OLE_SIGNATURE)) {
+		result, err = parseMsg(data)
+	} else {
+		result, err = parseEml(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// HTML only messages are read as text.
+	if strings.TrimSpace(result.Body) == "" && result.html != "" {
+		result.Body = htmlToText(result.html)
+	}
+	result.Body = strings.TrimSpace(cleanText(result.Body))
+	result.Urls = extractUrls(result.Body, html.UnescapeString(result.html))
+	return result, nil
+}
+
+func parseEml(data []byte) (*emailMessage, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &emailMessage{}
+	result.setHeaders(msg.Header)
+
+	parts := 0
+	err = result.walkPart(textproto.MIMEHeader(msg.Header), msg.Body, 0, &parts)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (self *emailMessage) setHeaders(header mail.Header) {
+	decoder := &mime.WordDecoder{}
+	get := func(name string) string {
+		value := header.Get(name)
+		decoded, err := decoder.DecodeHeader(value)
+		if err == nil {
+			value = decoded
+		}
+		return cleanText(value)
+	}
+
+	set := func(field *string, name string) {
+		value := get(name)
+		if value != "" {
+			*field = value
+		}
+	}
+
+	set(&self.From, "From")
+	set(&self.To, "To")
+	set(&self.Cc, "Cc")
+	set(&self.ReplyTo, "Reply-To")
+	set(&self.ReturnPath, "Return-Path")
+	set(&self.Subject, "Subject")
+	set(&self.Date, "Date")
+	set(&self.MessageId, "Message-Id")
+
+	for _, value := range header["Received"] {
+		self.Received = append(self.Received, cleanText(value))
+	}
+	for _, value := range header["Authentication-Results"] {
+		self.AuthenticationResults = append(self.AuthenticationResults,
+			cleanText(value))
+	}
+}
+
+// Collect the text of the message and the attachments from the MIME
+// parts.
+func (self *emailMessage) walkPart(header textproto.MIMEHeader,
+	body io.Reader, depth int, parts *int) error {
+	*parts++
+	if depth > MAX_EMAIL_DEPTH || *parts > MAX_EMAIL_PARTS {
+		return nil
+	}
+
+	media_type, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		media_type = "text/plain"
+	}
+
+	if strings.HasPrefix(media_type, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			err = self.walkPart(part.Header, part, depth+1, parts)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, MAX_DOCUMENT_PART))
+	if err != nil {
+		return err
+	}
+
+	disposition, disposition_params, _ := mime.ParseMediaType(
+		header.Get("Content-Disposition"))
+	name := disposition_params["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+
+	switch {
+	case disposition == "attachment" || name != "":
+		self.addAttachment(name, media_type, data)
+
+	case media_type == "text/plain" && self.Body == "":
+		self.Body = string(data)
+
+	case media_type == "text/html" && self.html == "":
+		self.html = string(data)
+	}
+	return nil
+}
+
+func (self *emailMessage) addAttachment(name, content_type string, data []byte) {
+	md5_sum := md5.Sum(data)
+	sha_sum := sha256.Sum256(data)
+	self.Attachments = append(self.Attachments, &emailAttachment{
+		Name:        cleanText(name),
+		ContentType: content_type,
+		Size:        len(data),
+		MD5:         hex.EncodeToString(md5_sum[:]),
+		SHA256:      hex.EncodeToString(sha_sum[:]),
+	})
+}
+
+// Outlook stores each property of the message in a stream named for
+// the property's id and type. Attachments are storages holding their
+// own property streams. The transport headers hold the original
+// headers of a received message.
+func parseMsg(data []byte) (*emailMessage, error) {
+	ole, err := oleparse.NewOLEFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ole.Directory) == 0 {
+		return nil, errors.New("No root storage in msg file")
+	}
+
+	visited := make(map[uint32]bool)
+	root := msgProperties(ole, ole.Directory[0].Header.SidChild, visited)
+
+	result := &emailMessage{}
+	headers := root.get("007D")
+	if headers != "" {
+		reader := textproto.NewReader(bufio.NewReader(
+			strings.NewReader(strings.TrimSpace(headers) + "\r\n\r\n")))
+		header, _ := reader.ReadMIMEHeader()
+		result.setHeaders(mail.Header(header))
+	}
+
+	if result.Subject == "" {
+		result.Subject = cleanText(root.get("0037"))
+	}
+
+	if result.From == "" {
+		result.From = cleanText(root.get("0C1A"))
+		address := root.get("0C1F")
+		if address != "" {
+			result.From = strings.TrimSpace(
+				result.From + " <" + cleanText(address) + ">")
+		}
+	}
+
+	if result.To == "" {
+		result.To = cleanText(root.get("0E04"))
+	}
+
+	if result.Cc == "" {
+		result.Cc = cleanText(root.get("0E03"))
+	}
+
+	result.Body = root.get("1000")
+	result.html = root.get("1013")
+
+	for _, storage := range root.storages {
+		if !strings.HasPrefix(storage.name, "__attach_version1.0_") {
+			continue
+		}
+
+		name := storage.get("3707")
+		if name == "" {
+			name = storage.get("3704")
+		}
+		result.addAttachment(name, storage.get("370E"),
+			storage.properties["37010102"])
+	}
+
+	return result, nil
+}
+
+type msgStorage struct {
+	name       string
+	properties map[string][]byte
+	storages   []*msgStorage
+}
+
+// Get a string property by id. Strings are stored as UTF16 or in the
+// message's code page.
+func (self *msgStorage) get(id string) string {
+	value, pres := self.properties[id+"001F"]
+	if pres {
+		return decodeUTF16(value)
+	}
+
+	value, pres = self.properties[id+"001E"]
+	if pres {
+		return strings.TrimRight(string(value), "\x00")
+	}
+
+	// HTML bodies are often binary.
+	return string(self.properties[id+"0102"])
+}
+
+const (
+	OLE_NO_STREAM = 0xFFFFFFFF
+	OLE_STORAGE   = 1
+	OLE_STREAM    = 2
+)
+
+// Collect the entries under a storage. They form a tree through
+// their left and right siblings.
+func msgProperties(ole *oleparse.OLEFile, child uint32,
+	visited map[uint32]bool) *msgStorage {
+	result := &msgStorage{properties: make(map[string][]byte)}
+
+	var walk func(index uint32)
+	walk = func(index uint32) {
+		if index == OLE_NO_STREAM || int(index) >= len(ole.Directory) ||
+			visited[index] {
+			return
+		}
+		visited[index] = true
+
+		entry := ole.Directory[index]
+		switch entry.Header.Mse {
+		case OLE_STREAM:
+			if strings.HasPrefix(entry.Name, "__substg1.0_") {
+				id := strings.ToUpper(strings.TrimPrefix(entry.Name, "__substg1.0_"))
+				result.properties[id] = ole.GetStream(index)
+			}
+
+		case OLE_STORAGE:
+			storage := msgProperties(ole, entry.Header.SidChild, visited)
+			storage.name = entry.Name
+			result.storages = append(result.storages, storage)
+		}
+
+		walk(entry.Header.SidLeftSib)
+		walk(entry.Header.SidRightSib)
+	}
+	walk(child)
+
+	return result
+}
+
+func decodeUTF16(value []byte) string {
+	units := make([]uint16, 0, len(value)/2)
+	for i := 0; i+1 < len(value); i += 2 {
+		units = append(units, uint16(value[i])|uint16(value[i+1])<<8)
+	}
+	return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+}
+
+func htmlToText(value string) string {
+	value = email_script_regex.ReplaceAllString(value, "")
+	value = email_tag_regex.ReplaceAllString(value, " ")
+	return html.UnescapeString(value)
+}
+
+// The unique links in the texts, in the order they first appear.
+// Links in HTML attributes are included as they are often hidden
+// behind different text.
+func extractUrls(texts ...string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, text := range texts {
+		for _, url := range email_url_regex.FindAllString(text, -1) {
+			url = strings.TrimRight(url, ".,;:!?")
+			if seen[url] {
+				continue
+			}
+			seen[url] = true
+			result = append(result, url)
+			if len(result) >= MAX_EMAIL_URLS {
+				return result
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,164 @@
+package ollama
+
+import (
+	"context"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+type OllamaEmbedPluginArgs struct {
+	Model     string              `vfilter:"required,field=model,doc=The embedding model to use (e.g. nomic-embed-text)."`
+	Input     []string            `vfilter:"optional,field=input,doc=Strings to embed."`
+	Query     vfilter.StoredQuery `vfilter:"optional,field=query,doc=Embed a column of each row of this query. Rows are emitted with an added Embedding column."`
+	Column    string              `vfilter:"optional,field=column,doc=The column of the query to embed (default Text)."`
+	BatchSize int64               `vfilter:"optional,field=batch_size,doc=The number of strings embedded in each API call (default 32)."`
+	BaseUrl   string              `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server. Several comma separated URLs, or srv://name for the servers of a DNS SRV record, spread the calls over the servers."`
+	Options   *ordereddict.Dict   `vfilter:"optional,field=options,doc=Model options."`
+	KeepAlive string              `vfilter:"optional,field=keep_alive,doc=How long the model stays loaded after the call (e.g. 5m)."`
+}
+
+type OllamaEmbedPlugin struct{}
+
+func (self OllamaEmbedPlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("ollama_embed", args)()
+
+		err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+		if err != nil {
+			scope.Log("ollama_embed: %v", err)
+			return
+		}
+
+		arg := &OllamaEmbedPluginArgs{}
+		err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+		if err != nil {
+			scope.Log("ollama_embed: %v", err)
+			return
+		}
+
+		if arg.Column == "" {
+			arg.Column = "Text"
+		}
+
+		if arg.BatchSize <= 0 {
+			arg.BatchSize = 32
+		}
+
+		client, err := NewClient(ctx, scope, arg.BaseUrl)
+		if err != nil {
+			scope.Log("ollama_embed: %v", err)
+			return
+		}
+
+		batcher := &embedBatcher{
+			client:      client,
+			arg:         arg,
+			output_chan: output_chan,
+		}
+
+		for _, input := range arg.Input {
+			err = batcher.Add(ctx, input, ordereddict.NewDict().
+				Set("Input", input))
+			if err != nil {
+				scope.Log("ollama_embed: %v", err)
+				return
+			}
+		}
+
+		if arg.Query != nil {
+			for row := range arg.Query.Eval(ctx, scope) {
+				row_dict := vfilter.RowToDict(ctx, scope, row)
+				value, _ := row_dict.Get(arg.Column)
+				err = batcher.Add(ctx, utils.ToString(value), row_dict)
+				if err != nil {
+					scope.Log("ollama_embed: %v", err)
+					return
+				}
+			}
+		}
+
+		err = batcher.Flush(ctx)
+		if err != nil {
+			scope.Log("ollama_embed: %v", err)
+		}
+	}()
+
+	return output_chan
+}
+
+// Collects inputs until a batch is full, then embeds them with a
+// single API call.
+type embedBatcher struct {
+	client      *Client
+	arg         *OllamaEmbedPluginArgs
+	output_chan chan vfilter.Row
+
+	inputs []string
+	rows   []*ordereddict.Dict
+}
+
+func (self *embedBatcher) Add(ctx context.Context,
+	input string, row *ordereddict.Dict) error {
+	self.inputs = append(self.inputs, input)
+	self.rows = append(self.rows, row)
+
+	if int64(len(self.inputs)) >= self.arg.BatchSize {
+		return self.Flush(ctx)
+	}
+	return nil
+}
+
+func (self *embedBatcher) Flush(ctx context.Context) error {
+	if len(self.inputs) == 0 {
+		return nil
+	}
+
+	resp, err := self.client.Embed(ctx, &EmbedRequest{
+		Model:     self.arg.Model,
+		Input:     self.inputs,
+		Options:   self.arg.Options,
+		KeepAlive: self.arg.KeepAlive,
+	})
+	if err != nil {
+		return err
+	}
+
+	for idx, row := range self.rows {
+		row.Set("Embedding", resp.Embeddings[idx])
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case self.output_chan <- row:
+		}
+	}
+
+	self.inputs = nil
+	self.rows = nil
+	return nil
+}
+
+func (self OllamaEmbedPlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name:     "ollama_embed",
+		Doc:      "Calculate embeddings for strings or query rows, batching many strings into each API call.",
+		ArgType:  type_map.AddType(scope, &OllamaEmbedPluginArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterPlugin(&OllamaEmbedPlugin{})
+}
@@ -0,0 +1,161 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+const (
+	ERROR_CONNECTION       = "connection"
+	ERROR_TIMEOUT          = "timeout"
+	ERROR_BAD_REQUEST      = "bad_request"
+	ERROR_MODEL_NOT_FOUND  = "model_not_found"
+	ERROR_RATE_LIMITED     = "rate_limited"
+	ERROR_SERVER           = "server_error"
+	ERROR_HTTP             = "http_error"
+	ERROR_INVALID_RESPONSE = "invalid_response"
+	ERROR_PROVIDER         = "provider_error"
+	ERROR_INTERRUPTED      = "interrupted"
+	ERROR_CIRCUIT_OPEN     = "circuit_open"
+	ERROR_CASSETTE_MISS    = "cassette_miss"
+	ERROR_NOT_ALLOWED      = "not_allowed"
+	ERROR_DIGEST_MISMATCH  = "digest_mismatch"
+
+	// How much of an unexpected response body is kept for diagnosis.
+	MAX_ERROR_BODY = 1024
+)
+
+// A failed call to the model. The fields are emitted in error rows so
+// queries can branch on the cause of the failure.
+type Error struct {
+	Code          string
+	HttpStatus    int
+	ProviderError string
+	Retryable     bool
+	Endpoint      string
+
+	// The start of a response which was not understood, such as a
+	// gateway's HTML error page.
+	ResponseBody string
+
+	// Text received before the response was interrupted. The
+	// request may be repeated to continue it.
+	Partial string
+	Err     error
+}
+
+func (self *Error) Error() string {
+	if self.Err != nil {
+		if self.ResponseBody != "" {
+			return fmt.Sprintf("ollama: %v: %v: %v", self.Endpoint,
+				self.Err, self.ResponseBody)
+		}
+		return fmt.Sprintf("ollama: %v: %v", self.Endpoint, self.Err)
+	}
+
+	if self.ProviderError != "" {
+		if self.HttpStatus != 0 {
+			return fmt.Sprintf("ollama: %v: %v: %v", self.Endpoint,
+				http.StatusText(self.HttpStatus), self.ProviderError)
+		}
+		return fmt.Sprintf("ollama: %v: %v", self.Endpoint, self.ProviderError)
+	}
+
+	if self.ResponseBody != "" {
+		return fmt.Sprintf("ollama: %v: %v %v: %v", self.Endpoint,
+			self.HttpStatus, http.StatusText(self.HttpStatus), self.ResponseBody)
+	}
+
+	return fmt.Sprintf("ollama: %v: %v %v", self.Endpoint,
+		self.HttpStatus, http.StatusText(self.HttpStatus))
+}
+
+func (self *Error) Unwrap() error {
+	return self.Err
+}
+
+func newConnectionError(endpoint string, err error) *Error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &Error{Code: ERROR_TIMEOUT, Retryable: true,
+			Endpoint: endpoint, Err: err}
+	}
+
+	// The query was cancelled - trying again will not help.
+	if errors.Is(err, context.Canceled) {
+		return &Error{Code: ERROR_CONNECTION, Endpoint: endpoint, Err: err}
+	}
+
+	return &Error{Code: ERROR_CONNECTION, Retryable: true,
+		Endpoint: endpoint, Err: err}
+}
+
+func newHttpError(endpoint string, status int, body []byte) *Error {
+	result := &Error{
+		HttpStatus: status,
+		Endpoint:   endpoint,
+	}
+
+	// Ollama reports errors as {"error": "..."} but proxies and
+	// gateways send their own pages.
+	error_resp := &GenerateResponse{}
+	if json.Unmarshal(body, error_resp) == nil && error_resp.Error != "" {
+		result.ProviderError = error_resp.Error
+	} else {
+		result.ResponseBody = elideBody(body)
+	}
+
+	switch {
+	case status == http.StatusBadRequest:
+		result.Code = ERROR_BAD_REQUEST
+	case status == http.StatusNotFound:
+		result.Code = ERROR_MODEL_NOT_FOUND
+	case status == http.StatusTooManyRequests:
+		result.Code = ERROR_RATE_LIMITED
+		result.Retryable = true
+	case status == http.StatusRequestTimeout ||
+		status == http.StatusGatewayTimeout:
+		result.Code = ERROR_TIMEOUT
+		result.Retryable = true
+	case status >= 500:
+		result.Code = ERROR_SERVER
+		result.Retryable = true
+	default:
+		result.Code = ERROR_HTTP
+	}
+	return result
+}
+
+func elideBody(body []byte) string {
+	return utils.Elide(strings.TrimSpace(string(body)), MAX_ERROR_BODY)
+}
+
+// Describe an error as a row. Errors which did not come from the
+// model call only have the Error column.
+func errRow(model string, err error) *ordereddict.Dict {
+	row := ordereddict.NewDict().
+		Set("Model", model).
+		Set("Error", err.Error())
+
+	var llm_err *Error
+	if errors.As(err, &llm_err) {
+		row.Set("ErrorCode", llm_err.Code).
+			Set("HttpStatus", llm_err.HttpStatus).
+			Set("ProviderError", llm_err.ProviderError).
+			Set("Retryable", llm_err.Retryable).
+			Set("Endpoint", llm_err.Endpoint).
+			Set("ResponseBody", llm_err.ResponseBody)
+
+		if llm_err.Partial != "" {
+			row.Set("Response", llm_err.Partial).
+				Set("Resumable", true)
+		}
+	}
+	return row
+}
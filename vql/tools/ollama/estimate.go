@@ -0,0 +1,168 @@
+package ollama
+
+import (
+	"context"
+
+	"github.com/Velocidex/ordereddict"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+type EstimateFunctionArgs struct {
+	Query           vfilter.StoredQuery `vfilter:"optional,field=query,doc=The query whose rows would be sent to the model."`
+	Model           string              `vfilter:"optional,field=model,doc=The model the estimate is for (e.g. llama3). Defaults to AI.default_model in the config."`
+	Prompt          string              `vfilter:"optional,field=prompt,doc=The prompt that would be sent."`
+	PromptPath      vfilter.Any         `vfilter:"optional,field=prompt_path,doc=Read the prompt from this file. If prompt is also given it is added after the file's prompt."`
+	PromptAccessor  string              `vfilter:"optional,field=prompt_accessor,doc=The accessor used to read prompt_path (default auto)."`
+	System          string              `vfilter:"optional,field=system,doc=The system prompt that would be sent."`
+	MaxRows         int64               `vfilter:"optional,field=max_rows,doc=As for ollama(): the most query rows included when not chunking (default 100)."`
+	MaxBytes        int64               `vfilter:"optional,field=max_bytes,doc=As for ollama(): the most bytes of rows in each prompt (default no limit)."`
+	Sample          string              `vfilter:"optional,field=sample,doc=As for ollama(): estimate sampling the rows with head, tail, random or stratified(column)."`
+	ChunkSize       int64               `vfilter:"optional,field=chunk_size,doc=As for ollama(): estimate sending the rows in chunks of this many rows."`
+	Indent          bool                `vfilter:"optional,field=indent,doc=As for ollama(): estimate indented rows."`
+	Flatten         bool                `vfilter:"optional,field=flatten,doc=As for ollama(): estimate flattened rows."`
+	Serialization   string              `vfilter:"optional,field=serialization,doc=As for ollama(): estimate rows serialized as json (the default), csv, yaml or markdown_table."`
+	MaxFieldLen     int64               `vfilter:"optional,field=max_field_len,doc=As for ollama(): estimate truncating long strings."`
+	MaxTokens       int64               `vfilter:"optional,field=max_tokens,doc=The most tokens each response may have. Used to estimate the completion tokens."`
+	PromptPrice     float64             `vfilter:"optional,field=prompt_price,doc=The price per million prompt tokens charged by the provider. Defaults to the model's price in AI.pricing."`
+	CompletionPrice float64             `vfilter:"optional,field=completion_price,doc=The price per million completion tokens charged by the provider. Defaults to the model's price in AI.pricing."`
+	BaseUrl         string              `vfilter:"optional,field=base_url,doc=The server the call would go to, used to find the price in AI.pricing."`
+}
+
+// Preview the cost of an ollama() call without calling the model.
+// The rows are encoded and chunked exactly as ollama() would, so
+// the estimate covers the same prompts.
+type EstimateFunction struct{}
+
+func (self EstimateFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("ollama_estimate", args)()
+
+	arg := &EstimateFunctionArgs{}
+	err := arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("ollama_estimate: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg.Prompt, err = loadPrompt(ctx, scope, arg.PromptPath,
+		arg.PromptAccessor, arg.Prompt)
+	if err != nil {
+		scope.Log("ollama_estimate: %v", err)
+		return vfilter.Null{}
+	}
+
+	settings := getSettings(scope)
+	if arg.Model == "" {
+		arg.Model = settings.DefaultModel
+	}
+
+	if arg.PromptPrice == 0 && arg.CompletionPrice == 0 {
+		base_url := arg.BaseUrl
+		if base_url == "" {
+			base_url = settings.BaseUrl
+		}
+
+		price := findPrice(settings, base_url, arg.Model)
+		if price != nil {
+			arg.PromptPrice = price.PromptPrice
+			arg.CompletionPrice = price.CompletionPrice
+		}
+	}
+
+	err = validateSerialization(arg.Serialization)
+	if err != nil {
+		scope.Log("ollama_estimate: %v", err)
+		return vfilter.Null{}
+	}
+
+	if arg.MaxRows == 0 {
+		arg.MaxRows = 100
+	}
+
+	plugin_arg := &OllamaPluginArgs{
+		Query:         arg.Query,
+		MaxRows:       arg.MaxRows,
+		MaxBytes:      arg.MaxBytes,
+		ChunkSize:     arg.ChunkSize,
+		Indent:        arg.Indent,
+		Flatten:       arg.Flatten,
+		Serialization: arg.Serialization,
+		MaxFieldLen:   arg.MaxFieldLen,
+	}
+
+	// Every call sends the system prompt and the prompt.
+	template_tokens := EstimateTokens(arg.Model, arg.System) +
+		EstimateTokens(arg.Model, arg.Prompt+"\n\n")
+
+	var chunks, rows, row_tokens int64
+	var truncated bool
+	count := func(encoder *rowEncoder) {
+		chunks++
+		rows += int64(len(encoder.rows))
+		row_tokens += EstimateTokens(arg.Model, encoder.Serialize(encoder.rows))
+	}
+
+	switch {
+	case arg.Query == nil:
+		chunks = 1
+
+	case arg.ChunkSize > 0:
+		splitChunks(ctx, scope, plugin_arg, func(encoder *rowEncoder) bool {
+			count(encoder)
+			return true
+		})
+
+	default:
+		sampler, err := parseSample(arg.Sample)
+		if err != nil {
+			scope.Log("ollama_estimate: %v", err)
+			return vfilter.Null{}
+		}
+
+		encoder := newRowEncoder(plugin_arg)
+		truncated, err = collectRows(ctx, scope, arg.Query,
+			arg.MaxRows, false, sampler, encoder)
+		if err != nil {
+			scope.Log("ollama_estimate: %v", err)
+			return vfilter.Null{}
+		}
+		count(encoder)
+	}
+
+	prompt_tokens := chunks*template_tokens + row_tokens
+	completion_tokens := chunks * arg.MaxTokens
+
+	result := ordereddict.NewDict().
+		Set("Model", arg.Model).
+		Set("Rows", rows).
+		Set("Truncated", truncated).
+		Set("Chunks", chunks).
+		Set("PromptTokens", prompt_tokens).
+		Set("CompletionTokens", completion_tokens).
+		Set("Cost", vfilter.Null{})
+
+	// Local models are free so a cost is only given with a price.
+	if arg.PromptPrice > 0 || arg.CompletionPrice > 0 {
+		result.Update("Cost",
+			(float64(prompt_tokens)*arg.PromptPrice+
+				float64(completion_tokens)*arg.CompletionPrice)/1e6)
+	}
+
+	return result
+}
+
+func (self EstimateFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:    "ollama_estimate",
+		Doc:     "Estimate the prompt tokens, model calls and cost of an ollama() call without calling the model.",
+		ArgType: type_map.AddType(scope, &EstimateFunctionArgs{}),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&EstimateFunction{})
+}
@@ -0,0 +1,99 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+type ExampleArgs struct {
+	Input  vfilter.Any `vfilter:"required,field=input,doc=An example input. Dicts are shown as JSON."`
+	Output vfilter.Any `vfilter:"required,field=output,doc=The response expected for the input."`
+}
+
+// A few shot example shown to the model before the real input.
+type example struct {
+	input  string
+	output string
+}
+
+func parseExamples(ctx context.Context, scope vfilter.Scope,
+	definitions []*ordereddict.Dict) ([]*example, error) {
+	var result []*example
+	for idx, definition := range definitions {
+		arg := &ExampleArgs{}
+		err := arg_parser.ExtractArgsWithContext(ctx, scope, definition, arg)
+		if err != nil {
+			return nil, fmt.Errorf("examples[%d]: %w", idx, err)
+		}
+
+		input, err := exampleText(ctx, scope, arg.Input)
+		if err != nil {
+			return nil, fmt.Errorf("examples[%d]: %w", idx, err)
+		}
+
+		output, err := exampleText(ctx, scope, arg.Output)
+		if err != nil {
+			return nil, fmt.Errorf("examples[%d]: %w", idx, err)
+		}
+
+		result = append(result, &example{input: input, output: output})
+	}
+	return result, nil
+}
+
+// Examples are formatted the same way as query rows in the prompt.
+func exampleText(ctx context.Context,
+	scope vfilter.Scope, value vfilter.Any) (string, error) {
+	switch t := value.(type) {
+	case string:
+		return cleanText(t), nil
+	case *ordereddict.Dict:
+		value = normalizeDict(ctx, scope, t, 0)
+	}
+
+	serialized, err := json.Marshal(binaryEncoder{}.Sanitize(value))
+	if err != nil {
+		return "", err
+	}
+	return string(serialized), nil
+}
+
+// The examples as a prefix for a generate prompt.
+func formatExamples(examples []*example) string {
+	if len(examples) == 0 {
+		return ""
+	}
+
+	result := &strings.Builder{}
+	result.WriteString("Examples of inputs and the expected outputs:\n\n")
+	for _, e := range examples {
+		fmt.Fprintf(result, "Input: %s\nOutput: %s\n\n", e.input, e.output)
+	}
+	return result.String()
+}
+
+// The examples as earlier turns of a chat, after any system messages.
+func withExampleMessages(messages []*Message, examples []*example) []*Message {
+	if len(examples) == 0 {
+		return messages
+	}
+
+	idx := 0
+	for idx < len(messages) && messages[idx].Role == "system" {
+		idx++
+	}
+
+	result := append([]*Message{}, messages[:idx]...)
+	for _, e := range examples {
+		result = append(result,
+			&Message{Role: "user", Content: e.input},
+			&Message{Role: "assistant", Content: e.output})
+	}
+	return append(result, messages[idx:]...)
+}
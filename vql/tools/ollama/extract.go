@@ -0,0 +1,324 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/velociraptor/vql/functions"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	DEFAULT_MAX_EXTRACT_TEXT = 32 * 1024
+
+	EXTRACT_PROMPT = "Extract the fields listed below from the text. " +
+		"Only use facts stated in the text and set a field to null if " +
+		"the text does not give it. Respond with JSON holding every field."
+)
+
+var extract_types = []string{
+	"string", "integer", "number", "boolean", "timestamp", "array",
+}
+
+type ExtractFieldArgs struct {
+	Name        string   `vfilter:"required,field=name,doc=The name of the field in the result."`
+	Type        string   `vfilter:"optional,field=type,doc=One of string, integer, number, boolean, timestamp or array (of strings). Default string."`
+	Description string   `vfilter:"optional,field=description,doc=What the field holds, shown to the model."`
+	Enum        []string `vfilter:"optional,field=enum,doc=The only values the field may have."`
+}
+
+type LLMExtractFunctionArgs struct {
+	Text      string              `vfilter:"required,field=text,doc=The free text to extract the fields from."`
+	Fields    []*ordereddict.Dict `vfilter:"required,field=fields,doc=The fields to extract. Each is a dict with name, type, description and enum."`
+	Prompt    string              `vfilter:"optional,field=prompt,doc=Additional instructions (e.g. what kind of text it is)."`
+	Model     string              `vfilter:"optional,field=model,doc=The model to use (e.g. llama3). Defaults to AI.default_model in the config."`
+	MaxText   int64               `vfilter:"optional,field=max_text,doc=The most bytes of the text to send (default 32kb)."`
+	BaseUrl   string              `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server. Several comma separated URLs, or srv://name for the servers of a DNS SRV record, spread the calls over the servers."`
+	Timeout   int64               `vfilter:"optional,field=timeout,doc=Seconds the call may take (default 3600)."`
+	Options   *ordereddict.Dict   `vfilter:"optional,field=options,doc=Model options (e.g. temperature, num_ctx)."`
+	KeepAlive string              `vfilter:"optional,field=keep_alive,doc=How long the model stays loaded after the call (e.g. 5m)."`
+}
+
+// A field the caller wants from the text.
+type extractField struct {
+	name        string
+	field_type  string
+	description string
+	enum        []string
+}
+
+// Extracts fields described by the caller from free text, such as
+// ticket notes or ransom notes. The response is constrained to a
+// schema of the fields and repaired if it does not match it, then
+// each value is converted to the field's type so the result can be
+// used by the query like any other dict. Fields the text does not
+// give are null.
+type LLMExtractFunction struct{}
+
+func (self LLMExtractFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("llm_extract", args)()
+
+	err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+	if err != nil {
+		scope.Log("llm_extract: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg := &LLMExtractFunctionArgs{}
+	err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("llm_extract: %v", err)
+		return vfilter.Null{}
+	}
+
+	if arg.MaxText == 0 {
+		arg.MaxText = DEFAULT_MAX_EXTRACT_TEXT
+	}
+
+	fields, err := parseExtractFields(ctx, scope, arg.Fields)
+	if err != nil {
+		scope.Log("llm_extract: %v", err)
+		return vfilter.Null{}
+	}
+
+	client, err := NewClient(ctx, scope, arg.BaseUrl)
+	if err != nil {
+		scope.Log("llm_extract: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg.Model, err = client.Model(arg.Model)
+	if err != nil {
+		scope.Log("llm_extract: %v", err)
+		return vfilter.Null{}
+	}
+
+	client = client.WithTimeouts(Timeouts{
+		Total: time.Duration(arg.Timeout) * time.Second,
+	})
+	generate := logGenerate(scope, client, client.Generate)
+
+	values, err := extractFields(ctx, scope, arg, fields, generate)
+	if err != nil {
+		scope.Log("llm_extract: %v", err)
+		return vfilter.Null{}
+	}
+
+	result := ordereddict.NewDict()
+	for _, field := range fields {
+		value, err := field.convert(ctx, scope, values[field.name])
+		if err != nil {
+			scope.Log("llm_extract: %v", err)
+			value = vfilter.Null{}
+		}
+		result.Set(field.name, value)
+	}
+	return result
+}
+
+func extractFields(ctx context.Context, scope vfilter.Scope,
+	arg *LLMExtractFunctionArgs, fields []*extractField,
+	generate generateFunc) (map[string]interface{}, error) {
+	text := cleanText(arg.Text)
+	if int64(len(text)) > arg.MaxText {
+		text = strings.ToValidUTF8(text[:arg.MaxText], "") + " ..."
+	}
+
+	schema := extractSchema(fields)
+
+	prompt := &strings.Builder{}
+	prompt.WriteString(EXTRACT_PROMPT)
+	if arg.Prompt != "" {
+		prompt.WriteString("\n\n" + arg.Prompt)
+	}
+	prompt.WriteString("\n\nFields:\n")
+	for _, field := range fields {
+		fmt.Fprintf(prompt, "- %v (%v)", field.name, field.field_type)
+		if field.description != "" {
+			fmt.Fprintf(prompt, ": %v", field.description)
+		}
+		prompt.WriteString("\n")
+	}
+	prompt.WriteString("\nText:\n" + text)
+
+	req := &GenerateRequest{
+		Model:     arg.Model,
+		Prompt:    prompt.String(),
+		Format:    schema,
+		Options:   arg.Options,
+		KeepAlive: arg.KeepAlive,
+	}
+
+	response, err := generateText(ctx, generate, req)
+	if err != nil {
+		return nil, err
+	}
+
+	validator, err := newResponseValidator(schema, true)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := validator.Repair(ctx, scope, generate, req,
+		response, nil, DEFAULT_REPAIR_ATTEMPTS)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Errors) > 0 {
+		return nil, fmt.Errorf("Invalid response: %v",
+			strings.Join(result.Errors, "; "))
+	}
+
+	// Parsed again without the dict conversions so strings which
+	// look like timestamps stay strings.
+	values := make(map[string]interface{})
+	err = json.Unmarshal([]byte(stripCodeFence(result.Response)), &values)
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func parseExtractFields(ctx context.Context, scope vfilter.Scope,
+	definitions []*ordereddict.Dict) ([]*extractField, error) {
+	var result []*extractField
+	seen := make(map[string]bool)
+	for idx, definition := range definitions {
+		arg := &ExtractFieldArgs{}
+		err := arg_parser.ExtractArgsWithContext(ctx, scope, definition, arg)
+		if err != nil {
+			return nil, fmt.Errorf("fields[%d]: %w", idx, err)
+		}
+
+		if arg.Type == "" {
+			arg.Type = "string"
+		}
+
+		if !utils.InString(extract_types, arg.Type) {
+			return nil, fmt.Errorf("fields[%d]: type should be one of %v not %q",
+				idx, strings.Join(extract_types, ", "), arg.Type)
+		}
+
+		if len(arg.Enum) > 0 && arg.Type != "string" {
+			return nil, fmt.Errorf("fields[%d]: enum may only be given for string fields",
+				idx)
+		}
+
+		if seen[arg.Name] {
+			return nil, fmt.Errorf("fields[%d]: %v is given more than once",
+				idx, arg.Name)
+		}
+		seen[arg.Name] = true
+
+		result = append(result, &extractField{
+			name:        arg.Name,
+			field_type:  arg.Type,
+			description: arg.Description,
+			enum:        arg.Enum,
+		})
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("No fields to extract")
+	}
+	return result, nil
+}
+
+// Every field must be present in the response but may be null.
+func extractSchema(fields []*extractField) *ordereddict.Dict {
+	properties := ordereddict.NewDict()
+	required := []string{}
+	for _, field := range fields {
+		property := ordereddict.NewDict()
+		switch field.field_type {
+		case "timestamp":
+			property.Set("type", []string{"string", "null"})
+		case "array":
+			property.Set("type", []string{"array", "null"}).
+				Set("items", ordereddict.NewDict().Set("type", "string"))
+		default:
+			property.Set("type", []string{field.field_type, "null"})
+		}
+
+		if len(field.enum) > 0 {
+			enum := []interface{}{}
+			for _, item := range field.enum {
+				enum = append(enum, item)
+			}
+			property.Set("enum", append(enum, nil))
+		}
+
+		if field.description != "" {
+			property.Set("description", field.description)
+		}
+
+		properties.Set(field.name, property)
+		required = append(required, field.name)
+	}
+
+	return ordereddict.NewDict().
+		Set("type", "object").
+		Set("properties", properties).
+		Set("required", required)
+}
+
+// Convert a value of the validated response to the field's type.
+func (self *extractField) convert(ctx context.Context,
+	scope vfilter.Scope, value interface{}) (vfilter.Any, error) {
+	if value == nil {
+		return vfilter.Null{}, nil
+	}
+
+	switch self.field_type {
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) {
+			return nil, fmt.Errorf("%v should be an integer", self.name)
+		}
+		return int64(number), nil
+
+	case "timestamp":
+		timestamp, err := functions.TimeFromAny(ctx, scope, value)
+		if err != nil {
+			return nil, fmt.Errorf("%v should be a timestamp: %w", self.name, err)
+		}
+		return timestamp.UTC(), nil
+
+	case "array":
+		items, _ := value.([]interface{})
+		result := make([]string, 0, len(items))
+		for _, item := range items {
+			result = append(result, utils.ToString(item))
+		}
+		return result, nil
+	}
+
+	return value, nil
+}
+
+func (self LLMExtractFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:     "llm_extract",
+		Doc:      "Extract typed fields described by a schema from free text with a model.",
+		ArgType:  type_map.AddType(scope, &LLMExtractFunctionArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&LLMExtractFunction{})
+}
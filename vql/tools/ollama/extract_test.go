@@ -0,0 +1,163 @@
+package ollama
+
+import (
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/vtesting/assert"
+	"www.velocidex.com/golang/vfilter"
+)
+
+func (self *OllamaTestSuite) TestExtract() {
+	rows := self.run(`
+LET Fields <= (
+  dict(name="Wallet", description="The bitcoin wallet"),
+  dict(name="Amount", type="number", description="The ransom in BTC"),
+  dict(name="Files", type="integer"),
+  dict(name="Deadline", type="timestamp"),
+  dict(name="Contact"),
+  dict(name="Group", enum=["LockBit", "BlackCat"]),
+  dict(name="Leak", type="boolean"),
+  dict(name="Emails", type="array"))
+
+SELECT llm_extract(text="Your 1200 files are encrypted...", fields=Fields,
+   model="extract", base_url=URL) AS Extract,
+   llm_extract(text="Hello", fields=Fields, model="csv", base_url=URL) AS Invalid,
+   llm_extract(text="Hello", fields=[dict(name="Amount", type="money")],
+      model="extract", base_url=URL) AS BadType
+FROM scope()`)
+	assert.Equal(self.T(), 1, len(rows))
+
+	extract_any, _ := rows[0].Get("Extract")
+	extract := extract_any.(*ordereddict.Dict)
+
+	// Values are converted to the field types.
+	wallet, _ := extract.Get("Wallet")
+	assert.Equal(self.T(), "bc1qxy2kgdygjrsqtzq2n0yrf2493p83kkfjhx0wlh", wallet)
+	amount, _ := extract.Get("Amount")
+	assert.Equal(self.T(), 0.5, amount)
+	files, _ := extract.Get("Files")
+	assert.Equal(self.T(), int64(1200), files)
+	deadline, _ := extract.Get("Deadline")
+	assert.Equal(self.T(), time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), deadline)
+	contact, _ := extract.Get("Contact")
+	assert.Equal(self.T(), vfilter.Null{}, contact)
+	leak, _ := extract.Get("Leak")
+	assert.Equal(self.T(), true, leak)
+	emails, _ := extract.Get("Emails")
+	assert.Equal(self.T(), []string{"decrypt@example.com"}, emails)
+
+	// The response is constrained to the fields and the fields the
+	// text may not give are nullable.
+	var format map[string]interface{}
+	for _, req := range self.requests {
+		if req.Model == "extract" {
+			format, _ = req.Format.(map[string]interface{})
+		}
+	}
+	properties, _ := format["properties"].(map[string]interface{})
+	group, _ := properties["Group"].(map[string]interface{})
+	assert.Equal(self.T(), []interface{}{"string", "null"}, group["type"])
+	assert.Equal(self.T(), []interface{}{"LockBit", "BlackCat", nil}, group["enum"])
+
+	required, _ := format["required"].([]interface{})
+	assert.Equal(self.T(), 8, len(required))
+
+	// Responses which can not be repaired are not returned.
+	invalid, _ := rows[0].Get("Invalid")
+	assert.Equal(self.T(), vfilter.Null{}, invalid)
+
+	bad_type, _ := rows[0].Get("BadType")
+	assert.Equal(self.T(), vfilter.Null{}, bad_type)
+}
+
+func (self *OllamaTestSuite) TestClassify() {
+	rows := self.run(`
+LET Labels <= ("phishing", "malware", "benign")
+
+SELECT llm_classify(text="Your password expires, reset it here",
+     labels=Labels, model="classify", base_url=URL,
+     definitions=dict(phishing="Tries to steal credentials"),
+     examples=[dict(input="Invoice attached", output="malware")]) AS Label,
+   llm_classify(text="Hello", labels=Labels, model="classify",
+     base_url=URL, confidence=TRUE) AS WithConfidence,
+   llm_classify(text="Hello", labels=["malware"], model="classify",
+     base_url=URL) AS Never
+FROM scope()`)
+	assert.Equal(self.T(), 1, len(rows))
+
+	// The out of set answer is retried and the label is returned as
+	// given by the caller.
+	label, _ := rows[0].Get("Label")
+	assert.Equal(self.T(), "phishing", label)
+
+	with_confidence_any, _ := rows[0].Get("WithConfidence")
+	with_confidence := with_confidence_any.(*ordereddict.Dict)
+	label, _ = with_confidence.Get("Label")
+	assert.Equal(self.T(), "phishing", label)
+
+	// The server returned no logprobs.
+	confidence, _ := with_confidence.Get("Confidence")
+	assert.Equal(self.T(), vfilter.Null{}, confidence)
+
+	// The model never chose one of the labels.
+	never, _ := rows[0].Get("Never")
+	assert.Equal(self.T(), vfilter.Null{}, never)
+
+	// The labels and their definitions are given to the model.
+	prompt := self.requests[0].Prompt
+	assert.Contains(self.T(), prompt, "Input: Invoice attached\nOutput: malware")
+	assert.Contains(self.T(), prompt, "- phishing: Tries to steal credentials\n- malware\n")
+	assert.False(self.T(), self.requests[0].Logprobs)
+}
+
+func (self *OllamaTestSuite) TestSummarize() {
+	// Short texts are summarized in one call.
+	rows := self.run(`
+SELECT llm_summarize(text="The attacker logged in to WS1 as alice.",
+   model="llama3", base_url=URL) AS Summary
+FROM scope()`)
+	assert.Equal(self.T(), 1, len(rows))
+
+	summary, _ := rows[0].Get("Summary")
+	assert.Equal(self.T(), "Hello world", summary)
+	assert.Equal(self.T(), 1, len(self.requests))
+	assert.Contains(self.T(), self.requests[0].Prompt,
+		"Summarize the text below in at most 200 words. "+
+			audience_instructions[AUDIENCE_TECHNICAL])
+
+	// Long texts are summarized in chunks which are then combined.
+	self.requests = nil
+	rows = self.run(`
+LET Text <= '''The attacker logged in to WS1 as alice using a stolen password.
+They copied the ransomware binary to DC2 over an SMB share.
+A scheduled task on DC2 ran the binary on every domain host.
+Files on the file servers were encrypted and a note was left.'''
+
+SELECT llm_summarize(text=Text, target_words=50, audience="executive",
+   focus="ransomware", chunk_tokens=15, model="llama3", base_url=URL) AS Summary,
+   llm_summarize(text="Hello", audience="board", model="llama3",
+      base_url=URL) AS BadAudience
+FROM scope()`)
+
+	summary, _ = rows[0].Get("Summary")
+	assert.Equal(self.T(), "Hello world", summary)
+
+	// Each chunk is summarized before the final summary.
+	assert.True(self.T(), len(self.requests) > 2)
+	assert.Contains(self.T(), self.requests[0].Prompt,
+		"This is part 1 of ")
+	assert.Contains(self.T(), self.requests[0].Prompt,
+		"at most 50 words")
+	assert.Contains(self.T(), self.requests[0].Prompt,
+		"Focus on ransomware.")
+
+	final := self.requests[len(self.requests)-1].Prompt
+	assert.Contains(self.T(), final, "Combine them into one summary of the "+
+		"whole text in at most 50 words. Focus on ransomware. "+
+		audience_instructions[AUDIENCE_EXECUTIVE])
+	assert.Contains(self.T(), final, "Hello world\n\nHello world")
+
+	bad_audience, _ := rows[0].Get("BadAudience")
+	assert.Equal(self.T(), vfilter.Null{}, bad_audience)
+}
@@ -0,0 +1,131 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"www.velocidex.com/golang/velociraptor/accessors"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	DEFAULT_FILE_LENGTH = 64 * 1024
+
+	// Larger files do not fit in any model's context.
+	MAX_FILE_LENGTH = 1024 * 1024
+)
+
+// Add the content of a file to the prompt so a file can be analysed
+// directly from the VFS without collecting it first. At most length
+// bytes are read from offset. Binary files are encoded like binary
+// values in query rows, unless the text of a document is extracted.
+func appendFile(ctx context.Context, scope vfilter.Scope,
+	arg *OllamaPluginArgs) (string, error) {
+	if arg.File == nil {
+		return arg.Prompt, nil
+	}
+
+	length := arg.Length
+	if length == 0 {
+		length = DEFAULT_FILE_LENGTH
+	}
+
+	if length < 0 || length > MAX_FILE_LENGTH {
+		return "", fmt.Errorf("Invalid length %v: should be between 1 and %v",
+			length, MAX_FILE_LENGTH)
+	}
+
+	if arg.Offset < 0 {
+		return "", fmt.Errorf("Invalid offset %v: should not be negative", arg.Offset)
+	}
+
+	if arg.ExtractText && arg.Offset > 0 {
+		return "", fmt.Errorf("offset can not be used with extract_text")
+	}
+
+	err := vql_subsystem.CheckFilesystemAccess(scope, arg.Accessor)
+	if err != nil {
+		return "", err
+	}
+
+	accessor, err := accessors.GetAccessor(arg.Accessor, scope)
+	if err != nil {
+		return "", err
+	}
+
+	fd, err := accessor.OpenWithOSPath(arg.File)
+	if err != nil {
+		return "", fmt.Errorf("Unable to open %v: %w", arg.File, err)
+	}
+	defer fd.Close()
+
+	if arg.ExtractText {
+		return appendDocument(fd, arg, length)
+	}
+
+	if arg.Offset > 0 {
+		_, err = fd.Seek(arg.Offset, io.SeekStart)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// One more byte than the length shows the file was truncated.
+	data, err := io.ReadAll(io.LimitReader(fd, length+1))
+	if err != nil {
+		return "", err
+	}
+
+	note := ""
+	if int64(len(data)) > length {
+		data = data[:length]
+		note = ", truncated"
+	}
+
+	var content string
+	if utf8.Valid(data) {
+		content = cleanText(string(data))
+	} else {
+		encoder := binaryEncoder{
+			encoding:  arg.BinaryEncoding,
+			max_bytes: int(arg.MaxBinaryBytes),
+		}
+		content = encoder.encode(data)
+	}
+
+	return fmt.Sprintf("%s\n\nFile %v (%v bytes from offset %v%s):\n%s",
+		arg.Prompt, arg.File, len(data), arg.Offset, note, content), nil
+}
+
+// Documents are read whole to extract their text, of which at most
+// length bytes are included.
+func appendDocument(fd io.Reader,
+	arg *OllamaPluginArgs, length int64) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(fd, MAX_DOCUMENT_SIZE+1))
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) > MAX_DOCUMENT_SIZE {
+		return "", fmt.Errorf("Document %v is larger than %v bytes",
+			arg.File, MAX_DOCUMENT_SIZE)
+	}
+
+	text, err := extractDocumentText(data)
+	if err != nil {
+		return "", fmt.Errorf("%v: %w", arg.File, err)
+	}
+
+	note := ""
+	if int64(len(text)) > length {
+		text = strings.ToValidUTF8(text[:length], "")
+		note = ", truncated"
+	}
+
+	return fmt.Sprintf("%s\n\nText of document %v (%v bytes%s):\n%s",
+		arg.Prompt, arg.File, len(text), note, text), nil
+}
@@ -0,0 +1,157 @@
+package ollama
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+type OllamaFunctionArgs struct {
+	Model          string              `vfilter:"optional,field=model,doc=The model to use (e.g. llama3). Defaults to AI.default_model in the config."`
+	Prompt         string              `vfilter:"optional,field=prompt,doc=The prompt to send to the model. Required unless prompt_path is given."`
+	PromptPath     vfilter.Any         `vfilter:"optional,field=prompt_path,doc=Read the prompt from this file. If prompt is also given it is added after the file's prompt."`
+	PromptAccessor string              `vfilter:"optional,field=prompt_accessor,doc=The accessor used to read prompt_path (default auto)."`
+	Examples       []*ordereddict.Dict `vfilter:"optional,field=examples,doc=Example inputs with the expected outputs, shown to the model before the prompt. Each is a dict with input and output."`
+	System         string              `vfilter:"optional,field=system,doc=A system prompt."`
+	BaseUrl        string              `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server. Several comma separated URLs, or srv://name for the servers of a DNS SRV record, spread the calls over the servers."`
+	Timeout        int64               `vfilter:"optional,field=timeout,doc=Seconds the call may take (default 3600)."`
+	MaxTokens      int64               `vfilter:"optional,field=max_tokens,doc=The most tokens the response may have. Longer responses are cut off."`
+	Stop           []string            `vfilter:"optional,field=stop,doc=Stop generating when the model produces one of these strings. The stop string is not included in the response."`
+	Options        *ordereddict.Dict   `vfilter:"optional,field=options,doc=Model options (e.g. temperature, num_ctx)."`
+	Format         vfilter.Any         `vfilter:"optional,field=format,doc=Either 'json' or a JSON schema to constrain the response."`
+	KeepAlive      string              `vfilter:"optional,field=keep_alive,doc=How long the model stays loaded after the call (e.g. 5m)."`
+	MaxResumes     int64               `vfilter:"optional,field=max_resumes,doc=How many times a response interrupted by a dropped connection is continued (default 2, 0 to not continue)."`
+	CacheBypass    bool                `vfilter:"optional,field=cache_bypass,doc=Always call the model rather than reusing a cached response."`
+}
+
+// The function form of ollama() returns just the response so it can
+// enrich each row in a column expression. Use the plugin for access
+// to query rows, statistics and error details.
+type OllamaFunction struct{}
+
+func (self OllamaFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("ollama", args)()
+
+	err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+	if err != nil {
+		scope.Log("ollama: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg := &OllamaFunctionArgs{}
+	err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("ollama: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg.Prompt, err = loadPrompt(ctx, scope, arg.PromptPath,
+		arg.PromptAccessor, arg.Prompt)
+	if err != nil {
+		scope.Log("ollama: %v", err)
+		return vfilter.Null{}
+	}
+
+	if arg.Prompt == "" {
+		scope.Log("ollama: One of prompt or prompt_path must be given")
+		return vfilter.Null{}
+	}
+
+	if len(arg.Stop) > 0 {
+		arg.Options = withOption(arg.Options, "stop", arg.Stop)
+	}
+
+	if arg.MaxTokens > 0 {
+		arg.Options = withOption(arg.Options, "num_predict", arg.MaxTokens)
+	}
+
+	examples, err := parseExamples(ctx, scope, arg.Examples)
+	if err != nil {
+		scope.Log("ollama: %v", err)
+		return vfilter.Null{}
+	}
+
+	// The built in guardrails apply to the response.
+	guardrails, err := parseGuardrails(ctx, scope, nil)
+	if err != nil {
+		scope.Log("ollama: %v", err)
+		return vfilter.Null{}
+	}
+
+	client, err := NewClient(ctx, scope, arg.BaseUrl)
+	if err != nil {
+		scope.Log("ollama: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg.Model, err = client.Model(arg.Model)
+	if err != nil {
+		scope.Log("ollama: %v", err)
+		return vfilter.Null{}
+	}
+
+	client = client.WithTimeouts(Timeouts{
+		Total: time.Duration(arg.Timeout) * time.Second,
+	})
+
+	generate := logGenerate(scope, client,
+		maxTokensGenerate(arg.MaxTokens,
+			resumeGenerate(scope, client,
+				getMaxResumes(args, arg.MaxResumes))))
+	config_obj, ok := vql_subsystem.GetServerConfig(scope)
+	if ok && !arg.CacheBypass {
+		cache := GetResponseCache(config_obj)
+		uncached := generate
+		generate = func(ctx context.Context, req *GenerateRequest,
+			cb func(resp *GenerateResponse) error) error {
+			return cache.Generate(ctx, client, req, cb, uncached)
+		}
+	}
+
+	response := &strings.Builder{}
+	err = generate(ctx, &GenerateRequest{
+		Model:     arg.Model,
+		Prompt:    formatExamples(examples) + arg.Prompt,
+		System:    arg.System,
+		Format:    arg.Format,
+		Options:   arg.Options,
+		KeepAlive: arg.KeepAlive,
+		Stream:    true,
+	}, func(chunk *GenerateResponse) error {
+		response.WriteString(chunk.Response)
+		return nil
+	})
+	if err != nil {
+		scope.Log("ollama: %v", err)
+		return vfilter.Null{}
+	}
+
+	filtered, flags := guardrails.Check(response.String())
+	if len(flags) > 0 {
+		scope.Log("ollama: Response flagged by guardrails: %v",
+			strings.Join(flags, ", "))
+	}
+	return filtered
+}
+
+func (self OllamaFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:     "ollama",
+		Doc:      "Send a prompt to an Ollama model and return the response.",
+		ArgType:  type_map.AddType(scope, &OllamaFunctionArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&OllamaFunction{})
+}
@@ -0,0 +1,168 @@
+package ollama
+
+import (
+	"context"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	CAPABILITY_VISION = "vision"
+)
+
+// A GPU found on the host. VRAM is 0 when the driver does not report
+// it. Unified memory GPUs share the system memory, which is reported
+// as their VRAM.
+type GPUInfo struct {
+	Name          string `json:"Name"`
+	Vendor        string `json:"Vendor"`
+	Driver        string `json:"Driver"`
+	VRAM          uint64 `json:"VRAM"`
+	UnifiedMemory bool   `json:"UnifiedMemory"`
+}
+
+type GPUInfoFunctionArgs struct {
+	MinVRAM uint64 `vfilter:"optional,field=min_vram,doc=The bytes of VRAM a GPU needs to be Sufficient."`
+	Model   string `vfilter:"optional,field=model,doc=Report what Ollama says this model can do (e.g. vision)."`
+	BaseUrl string `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server."`
+	Timeout int64  `vfilter:"optional,field=timeout,doc=Seconds to wait for the Ollama server (default 5)."`
+}
+
+// Reports the GPUs of the host so artifacts can precondition heavy
+// model work (e.g. vision models) on machines which can run it. The
+// Ollama server is asked about its loaded models and the model's
+// capabilities when a model or base_url is given, or when running on
+// the server.
+type GPUInfoFunction struct{}
+
+func (self GPUInfoFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("gpu_info", args)()
+
+	err := vql_subsystem.CheckAccess(scope, acls.MACHINE_STATE)
+	if err != nil {
+		scope.Log("gpu_info: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg := &GPUInfoFunctionArgs{}
+	err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("gpu_info: %v", err)
+		return vfilter.Null{}
+	}
+
+	gpus, err := getGPUs()
+	if err != nil {
+		scope.Log("gpu_info: %v", err)
+	}
+
+	total := uint64(0)
+	largest := uint64(0)
+	for _, gpu := range gpus {
+		total += gpu.VRAM
+		if gpu.VRAM > largest {
+			largest = gpu.VRAM
+		}
+	}
+
+	result := ordereddict.NewDict().
+		Set("HasGPU", len(gpus) > 0).
+		Set("GPUs", gpus).
+		Set("TotalVRAM", total).
+		Set("Sufficient", len(gpus) > 0 && largest >= arg.MinVRAM)
+
+	_, on_server := vql_subsystem.GetServerConfig(scope)
+	if arg.Model == "" && arg.BaseUrl == "" && !on_server {
+		return result
+	}
+
+	ollama := ordereddict.NewDict()
+	result.Set("Ollama", ollama)
+
+	err = vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+	if err != nil {
+		return setHealthError(ollama, err)
+	}
+
+	client, err := NewClient(ctx, scope, arg.BaseUrl)
+	if err != nil {
+		return setHealthError(ollama, err)
+	}
+
+	timeout := time.Duration(arg.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = DEFAULT_HEALTH_TIMEOUT
+	}
+
+	sub_ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	getOllamaCapabilities(sub_ctx, client, arg.Model, ollama)
+	return result
+}
+
+func getOllamaCapabilities(ctx context.Context, client *Client,
+	model string, result *ordereddict.Dict) {
+	version, err := client.Version(ctx)
+	if err != nil {
+		setHealthError(result, err)
+		return
+	}
+	result.Set("Version", version)
+
+	// Models only partly in VRAM run on the CPU for the rest.
+	running, err := client.Running(ctx)
+	if err != nil {
+		setHealthError(result, err)
+		return
+	}
+
+	loaded := []*ordereddict.Dict{}
+	vram := int64(0)
+	for _, item := range running.Models {
+		vram += item.SizeVram
+		loaded = append(loaded, ordereddict.NewDict().
+			Set("Model", item.Name).
+			Set("Size", item.Size).
+			Set("SizeVRAM", item.SizeVram).
+			Set("FullyOffloaded", item.Size > 0 && item.SizeVram >= item.Size))
+	}
+	result.Set("LoadedModels", loaded).
+		Set("UsedVRAM", vram)
+
+	if model == "" {
+		return
+	}
+
+	result.Set("Model", model)
+	info, err := client.Show(ctx, model)
+	if err != nil {
+		setHealthError(result, err)
+		return
+	}
+
+	result.Set("Capabilities", info.Capabilities).
+		Set("Vision", utils.InString(info.Capabilities, CAPABILITY_VISION))
+}
+
+func (self GPUInfoFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:     "gpu_info",
+		Doc:      "Report the GPUs and VRAM of the host and what the Ollama server can run.",
+		ArgType:  type_map.AddType(scope, &GPUInfoFunctionArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.MACHINE_STATE).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&GPUInfoFunction{})
+}
@@ -0,0 +1,39 @@
+//go:build darwin
+// +build darwin
+
+package ollama
+
+import (
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Apple Silicon GPUs share the system memory, all of which Metal may
+// use. Intel Macs are not reported since Ollama does not use their
+// GPUs.
+func getGPUs() ([]*GPUInfo, error) {
+	result := []*GPUInfo{}
+	if runtime.GOARCH != "arm64" {
+		return result, nil
+	}
+
+	memory, err := unix.SysctlUint64("hw.memsize")
+	if err != nil {
+		return result, err
+	}
+
+	name, err := unix.Sysctl("machdep.cpu.brand_string")
+	if err != nil || name == "" {
+		name = "Apple Silicon"
+	}
+
+	return append(result, &GPUInfo{
+		Name:          strings.TrimSpace(name),
+		Vendor:        "Apple",
+		Driver:        "Metal",
+		VRAM:          memory,
+		UnifiedMemory: true,
+	}), nil
+}
@@ -0,0 +1,127 @@
+//go:build linux
+// +build linux
+
+package ollama
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	drm_card_regex = regexp.MustCompile(`^card[0-9]+$`)
+
+	pci_vendors = map[string]string{
+		"0x10de": "NVIDIA",
+		"0x1002": "AMD",
+		"0x8086": "Intel",
+	}
+)
+
+// GPUs are found through the DRM devices in sysfs. Only amdgpu reports
+// the VRAM there. NVIDIA compute cards may not have a DRM device so
+// the NVIDIA driver's own list is read as well.
+func getGPUs() ([]*GPUInfo, error) {
+	result := []*GPUInfo{}
+	seen := make(map[string]bool)
+
+	cards, _ := filepath.Glob("/sys/class/drm/card*")
+	for _, card := range cards {
+		if !drm_card_regex.MatchString(filepath.Base(card)) {
+			continue
+		}
+
+		device := filepath.Join(card, "device")
+		uevent := readUevent(filepath.Join(device, "uevent"))
+		slot := uevent["PCI_SLOT_NAME"]
+		if slot != "" {
+			if seen[slot] {
+				continue
+			}
+			seen[slot] = true
+		}
+
+		vendor := readSysfs(filepath.Join(device, "vendor"))
+		gpu := &GPUInfo{
+			Name:   readNvidiaModel(slot),
+			Vendor: pci_vendors[vendor],
+			Driver: uevent["DRIVER"],
+		}
+		if gpu.Vendor == "" {
+			gpu.Vendor = vendor
+		}
+		if gpu.Name == "" {
+			gpu.Name = strings.TrimSpace(gpu.Vendor + " " +
+				readSysfs(filepath.Join(device, "device")))
+		}
+
+		vram, err := strconv.ParseUint(
+			readSysfs(filepath.Join(device, "mem_info_vram_total")), 10, 64)
+		if err == nil {
+			gpu.VRAM = vram
+		}
+
+		result = append(result, gpu)
+	}
+
+	nvidia, _ := filepath.Glob("/proc/driver/nvidia/gpus/*")
+	for _, path := range nvidia {
+		slot := filepath.Base(path)
+		if seen[slot] {
+			continue
+		}
+		seen[slot] = true
+
+		result = append(result, &GPUInfo{
+			Name:   readNvidiaModel(slot),
+			Vendor: "NVIDIA",
+			Driver: "nvidia",
+		})
+	}
+
+	return result, nil
+}
+
+func readSysfs(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func readUevent(path string) map[string]string {
+	result := make(map[string]string)
+	for _, line := range strings.Split(readSysfs(path), "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 {
+			result[parts[0]] = parts[1]
+		}
+	}
+	return result
+}
+
+func readNvidiaModel(slot string) string {
+	if slot == "" {
+		return ""
+	}
+
+	fd, err := os.Open(filepath.Join("/proc/driver/nvidia/gpus", slot, "information"))
+	if err != nil {
+		return ""
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == "Model" {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !windows && !darwin
+// +build !linux,!windows,!darwin
+
+package ollama
+
+func getGPUs() ([]*GPUInfo, error) {
+	return []*GPUInfo{}, nil
+}
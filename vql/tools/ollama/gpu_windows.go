@@ -0,0 +1,116 @@
+//go:build windows
+// +build windows
+
+package ollama
+
+import (
+	"encoding/binary"
+	"regexp"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+const (
+	// The device class of display adapters.
+	DISPLAY_CLASS_KEY = `SYSTEM\CurrentControlSet\Control\Class\{4d36e968-e325-11ce-bfc1-08002be10318}`
+)
+
+var (
+	display_adapter_regex = regexp.MustCompile(`^[0-9]{4}$`)
+	pci_vendor_regex      = regexp.MustCompile(`(?i)ven_([0-9a-f]{4})`)
+
+	pci_vendors = map[string]string{
+		"10de": "NVIDIA",
+		"1002": "AMD",
+		"8086": "Intel",
+	}
+)
+
+// Display adapters are listed under their device class. The VRAM is
+// read from the driver's HardwareInformation values rather than WMI,
+// which caps it at 4GB. Adapters from Microsoft (the basic display,
+// Hyper-V and remote desktop adapters) are not GPUs.
+func getGPUs() ([]*GPUInfo, error) {
+	result := []*GPUInfo{}
+
+	class, err := registry.OpenKey(registry.LOCAL_MACHINE,
+		DISPLAY_CLASS_KEY, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return result, err
+	}
+	defer class.Close()
+
+	names, err := class.ReadSubKeyNames(-1)
+	if err != nil {
+		return result, err
+	}
+
+	for _, name := range names {
+		if !display_adapter_regex.MatchString(name) {
+			continue
+		}
+
+		key, err := registry.OpenKey(class, name, registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+
+		gpu := readDisplayAdapter(key)
+		key.Close()
+
+		if gpu != nil {
+			result = append(result, gpu)
+		}
+	}
+
+	return result, nil
+}
+
+func readDisplayAdapter(key registry.Key) *GPUInfo {
+	provider, _, _ := key.GetStringValue("ProviderName")
+	if strings.EqualFold(provider, "Microsoft") {
+		return nil
+	}
+
+	description, _, err := key.GetStringValue("DriverDesc")
+	if err != nil {
+		return nil
+	}
+
+	gpu := &GPUInfo{
+		Name:   description,
+		Vendor: provider,
+	}
+
+	device_id, _, _ := key.GetStringValue("MatchingDeviceId")
+	match := pci_vendor_regex.FindStringSubmatch(device_id)
+	if len(match) > 1 {
+		vendor, pres := pci_vendors[strings.ToLower(match[1])]
+		if pres {
+			gpu.Vendor = vendor
+		}
+	}
+
+	gpu.Driver, _, _ = key.GetStringValue("DriverVersion")
+
+	// Newer drivers report the size as a QWORD, older ones as 4 bytes.
+	vram, _, err := key.GetIntegerValue("HardwareInformation.qwMemorySize")
+	if err == nil {
+		gpu.VRAM = vram
+		return gpu
+	}
+
+	vram, _, err = key.GetIntegerValue("HardwareInformation.MemorySize")
+	if err == nil {
+		gpu.VRAM = vram
+		return gpu
+	}
+
+	data, _, err := key.GetBinaryValue("HardwareInformation.MemorySize")
+	if err == nil && len(data) >= 4 {
+		gpu.VRAM = uint64(binary.LittleEndian.Uint32(data))
+	}
+
+	return gpu
+}
@@ -0,0 +1,309 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+// What happens when a guardrail matches a model response.
+const (
+	GUARDRAIL_ANNOTATE = "annotate"
+	GUARDRAIL_BLOCK    = "block"
+	GUARDRAIL_IGNORE   = "ignore"
+)
+
+// Streamed content is held back for the longest match of a blocking
+// rule. Rules which can match any length are assumed to match at most
+// this many bytes - a longer match still blocks the final row but the
+// start of it may have been emitted.
+const GUARDRAIL_STREAM_WINDOW = 1024
+
+// The built in rules. They are applied (annotating) unless disabled
+// by a rule of the same name with action ignore.
+var defaultGuardrails = []struct {
+	name  string
+	regex string
+}{
+	{"shell_command", `(?im)(^\s*(sudo|rm\s+-rf|chmod|chown|mkfs|dd\s+if=)\s|` +
+		`(curl|wget|iwr|Invoke-WebRequest)\s[^\n|]*\|\s*(ba|z)?sh|` +
+		`powershell(\.exe)?\s[^\n]*-e(nc|ncodedcommand)?\s|` +
+		`Invoke-Expression|\biex\s*\()`},
+	{"secret", `(-----BEGIN [A-Z ]*PRIVATE KEY-----|\bAKIA[0-9A-Z]{16}\b|` +
+		`\bgh[pousr]_[0-9A-Za-z]{36}\b|` +
+		`(?i)\b(api[_-]?key|secret|passw(or)?d|token)\b["']?\s*[:=]\s*["']?[^\s"']{8,})`},
+	{"url", `(?i)\b(https?|ftp)://[^\s"'<>]+`},
+}
+
+func builtinGuardrailRegex(name string) string {
+	for _, d := range defaultGuardrails {
+		if d.name == name {
+			return d.regex
+		}
+	}
+	return ""
+}
+
+type GuardrailArgs struct {
+	Name   string `vfilter:"required,field=name,doc=The name of the rule. Using the name of a built in rule (shell_command, secret or url) changes its action."`
+	Regex  string `vfilter:"optional,field=regex,doc=A regular expression matching flagged content. May be omitted for built in rules."`
+	Action string `vfilter:"optional,field=action,doc=One of annotate (default), block or ignore."`
+}
+
+// Stops a streamed response when a blocking rule matches the part
+// received so far.
+type GuardrailError struct {
+	Response string
+	Flags    []string
+}
+
+func (self *GuardrailError) Error() string {
+	return self.Response
+}
+
+type guardrail struct {
+	name   string
+	regex  *regexp.Regexp
+	action string
+}
+
+// Scans model responses before they are emitted.
+type Guardrails struct {
+	rules []*guardrail
+
+	// The number of bytes a blocking rule can match.
+	window int
+}
+
+func parseGuardrails(ctx context.Context, scope vfilter.Scope,
+	definitions []*ordereddict.Dict) (*Guardrails, error) {
+	rules := make(map[string]*guardrail)
+	for _, d := range defaultGuardrails {
+		rules[d.name] = &guardrail{
+			name:   d.name,
+			regex:  regexp.MustCompile(d.regex),
+			action: GUARDRAIL_ANNOTATE,
+		}
+	}
+
+	for _, definition := range definitions {
+		arg := &GuardrailArgs{}
+		err := arg_parser.ExtractArgsWithContext(ctx, scope, definition, arg)
+		if err != nil {
+			return nil, fmt.Errorf("guardrails: %w", err)
+		}
+
+		switch arg.Action {
+		case "":
+			arg.Action = GUARDRAIL_ANNOTATE
+		case GUARDRAIL_ANNOTATE, GUARDRAIL_BLOCK, GUARDRAIL_IGNORE:
+		default:
+			return nil, fmt.Errorf("guardrail %v: invalid action %v",
+				arg.Name, arg.Action)
+		}
+
+		rule, pres := rules[arg.Name]
+		if !pres {
+			if arg.Regex == "" {
+				return nil, fmt.Errorf("guardrail %v: regex required", arg.Name)
+			}
+			rule = &guardrail{name: arg.Name}
+			rules[arg.Name] = rule
+		}
+
+		if arg.Regex != "" {
+			rule.regex, err = regexp.Compile(arg.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("guardrail %v: %w", arg.Name, err)
+			}
+		}
+		rule.action = arg.Action
+	}
+
+	result := &Guardrails{}
+	for _, rule := range rules {
+		if rule.action != GUARDRAIL_IGNORE {
+			result.rules = append(result.rules, rule)
+		}
+		if rule.action == GUARDRAIL_BLOCK {
+			result.window = max(result.window, matchLength(rule.regex))
+		}
+	}
+	sort.Slice(result.rules, func(i, j int) bool {
+		return result.rules[i].name < result.rules[j].name
+	})
+
+	return result, nil
+}
+
+// Check the content against all rules. Returns the content to emit
+// and the names of the matching rules. Content matching a blocking
+// rule is replaced entirely.
+func (self *Guardrails) Check(content string) (string, []string) {
+	flags := []string{}
+	blocked := []string{}
+	for _, rule := range self.rules {
+		if !rule.regex.MatchString(content) {
+			continue
+		}
+		flags = append(flags, rule.name)
+		if rule.action == GUARDRAIL_BLOCK {
+			blocked = append(blocked, rule.name)
+		}
+	}
+
+	if len(blocked) > 0 {
+		content = fmt.Sprintf("Response blocked by guardrails: %v",
+			strings.Join(blocked, ", "))
+	}
+	return content, flags
+}
+
+// Holds back a streamed response until no blocking rule can match
+// across the held back part, so no part of a blocked response is
+// emitted. Each chunk is scanned with only the window before it.
+type guardrailStream struct {
+	guardrails *Guardrails
+	response   strings.Builder
+	emitted    int
+}
+
+func (self *Guardrails) NewStream() *guardrailStream {
+	return &guardrailStream{guardrails: self}
+}
+
+// Add a chunk of the response. Returns the content which can be
+// emitted now or a GuardrailError if a blocking rule matches.
+func (self *guardrailStream) Write(chunk string) (string, error) {
+	scanned := self.response.Len()
+	self.response.WriteString(chunk)
+	text := self.response.String()
+	window := self.guardrails.window
+
+	// A new match must end in the chunk so can not start before the
+	// window.
+	start := runeStart(text, scanned-window)
+	for _, rule := range self.guardrails.rules {
+		if rule.action == GUARDRAIL_BLOCK &&
+			rule.regex.MatchString(text[start:]) {
+			filtered, flags := self.guardrails.Check(text)
+			return "", &GuardrailError{Response: filtered, Flags: flags}
+		}
+	}
+
+	end := runeStart(text, len(text)-window)
+	if end <= self.emitted {
+		return "", nil
+	}
+	result := text[self.emitted:end]
+	self.emitted = end
+	return result, nil
+}
+
+// The content held back once the response is complete.
+func (self *guardrailStream) Flush() string {
+	text := self.response.String()
+	result := text[self.emitted:]
+	self.emitted = len(text)
+	return result
+}
+
+// The start of the rune at or before offset.
+func runeStart(text string, offset int) int {
+	if offset <= 0 {
+		return 0
+	}
+	if offset >= len(text) {
+		return len(text)
+	}
+	for offset > 0 && !utf8.RuneStart(text[offset]) {
+		offset--
+	}
+	return offset
+}
+
+// The most bytes the regex can match, up to GUARDRAIL_STREAM_WINDOW.
+func matchLength(regex *regexp.Regexp) int {
+	parsed, err := syntax.Parse(regex.String(), syntax.Perl)
+	if err != nil {
+		return GUARDRAIL_STREAM_WINDOW
+	}
+	return min(maxMatchLength(parsed), GUARDRAIL_STREAM_WINDOW)
+}
+
+func maxMatchLength(re *syntax.Regexp) int {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return len(re.Rune) * utf8.UTFMax
+		}
+		return len(string(re.Rune))
+
+	// The ranges are sorted so the last is the highest rune.
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return 0
+		}
+		return utf8.RuneLen(re.Rune[len(re.Rune)-1])
+
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return utf8.UTFMax
+
+	case syntax.OpCapture, syntax.OpQuest:
+		return maxMatchLength(re.Sub[0])
+
+	case syntax.OpConcat:
+		result := 0
+		for _, sub := range re.Sub {
+			result += maxMatchLength(sub)
+			if result >= GUARDRAIL_STREAM_WINDOW {
+				return GUARDRAIL_STREAM_WINDOW
+			}
+		}
+		return result
+
+	case syntax.OpAlternate:
+		result := 0
+		for _, sub := range re.Sub {
+			result = max(result, maxMatchLength(sub))
+		}
+		return result
+
+	case syntax.OpStar, syntax.OpPlus:
+		return GUARDRAIL_STREAM_WINDOW
+
+	case syntax.OpRepeat:
+		if re.Max < 0 {
+			return GUARDRAIL_STREAM_WINDOW
+		}
+		return min(re.Max*maxMatchLength(re.Sub[0]), GUARDRAIL_STREAM_WINDOW)
+	}
+
+	// Empty matches and assertions.
+	return 0
+}
+
+// Apply the guardrails to a row's content column, adding a Flags
+// column if any rule matched.
+func (self *Guardrails) Filter(scope vfilter.Scope, name string,
+	row *ordereddict.Dict, column string) {
+	content, _ := row.GetString(column)
+	filtered, flags := self.Check(content)
+	if len(flags) == 0 {
+		return
+	}
+
+	if filtered != content {
+		scope.Log("%v: %v", name, filtered)
+	}
+	row.Update(column, filtered)
+	row.Set("Flags", flags)
+}
@@ -0,0 +1,52 @@
+package ollama
+
+import (
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// A stable embedding computed without a model, used by the mock
+// server. Each word is hashed to a dimension (the hashing trick) so
+// texts sharing words have similar embeddings, which is enough to
+// test searching and clustering end to end. Embeddings have unit
+// length so the dot product is the cosine similarity.
+func hashEmbedding(text string, dimensions int) []float64 {
+	result := make([]float64, dimensions)
+	if dimensions <= 0 {
+		return result
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	for _, word := range words {
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(word))
+		value := hash.Sum64()
+
+		// One bit of the hash is the sign so unrelated words
+		// sharing a dimension tend to cancel out.
+		sign := 1.0
+		if value&(1<<63) != 0 {
+			sign = -1.0
+		}
+		result[value%uint64(dimensions)] += sign
+	}
+
+	var norm float64
+	for _, value := range result {
+		norm += value * value
+	}
+	if norm == 0 {
+		return result
+	}
+
+	norm = math.Sqrt(norm)
+	for idx := range result {
+		result[idx] /= norm
+	}
+	return result
+}
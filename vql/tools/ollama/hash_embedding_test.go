@@ -0,0 +1,35 @@
+package ollama
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func dot(a, b []float64) float64 {
+	var result float64
+	for idx := range a {
+		result += a[idx] * b[idx]
+	}
+	return result
+}
+
+func TestHashEmbedding(t *testing.T) {
+	login := hashEmbedding("Failed login for admin", 64)
+	assert.Equal(t, 64, len(login))
+
+	// The same text always has the same embedding, regardless of
+	// case and punctuation.
+	assert.Equal(t, login, hashEmbedding("failed LOGIN, for admin!", 64))
+
+	// Embeddings have unit length.
+	assert.InDelta(t, 1.0, dot(login, login), 1e-9)
+
+	// Texts sharing words are more similar than unrelated texts.
+	similar := hashEmbedding("Failed login for user bob", 64)
+	unrelated := hashEmbedding("Scheduled task created", 64)
+	assert.Greater(t, dot(login, similar), dot(login, unrelated))
+
+	// Text without words has no direction.
+	assert.Equal(t, make([]float64, 8), hashEmbedding("...", 8))
+}
@@ -0,0 +1,140 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	DEFAULT_HEALTH_TIMEOUT = 5 * time.Second
+)
+
+type OllamaHealthFunctionArgs struct {
+	Model   string `vfilter:"optional,field=model,doc=A model which must be installed for the server to be healthy."`
+	BaseUrl string `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server. Several comma separated URLs, or srv://name for the servers of a DNS SRV record, spread the calls over the servers."`
+	Timeout int64  `vfilter:"optional,field=timeout,doc=Seconds to wait for the server (default 5)."`
+}
+
+type OllamaHealthFunction struct{}
+
+// Only cheap metadata calls are made so this can be used in an
+// artifact's precondition before doing expensive work.
+func (self OllamaHealthFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("ollama_health", args)()
+
+	err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+	if err != nil {
+		scope.Log("ollama_health: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg := &OllamaHealthFunctionArgs{}
+	err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("ollama_health: %v", err)
+		return vfilter.Null{}
+	}
+
+	client, err := NewClient(ctx, scope, arg.BaseUrl)
+	if err != nil {
+		scope.Log("ollama_health: %v", err)
+		return vfilter.Null{}
+	}
+
+	timeout := time.Duration(arg.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = DEFAULT_HEALTH_TIMEOUT
+	}
+
+	sub_ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return checkHealth(sub_ctx, client, arg.Model)
+}
+
+func checkHealth(ctx context.Context, client *Client,
+	model string) *ordereddict.Dict {
+	result := ordereddict.NewDict().
+		Set("Healthy", false).
+		Set("Endpoint", redactUrl(client.base_url)).
+		Set("Version", "").
+		Set("Latency", 0.0)
+
+	if model != "" {
+		result.Set("Model", model).
+			Set("ModelInstalled", false).
+			Set("ModelLoaded", false)
+	}
+
+	// Each endpoint of a pool is checked so dead GPU hosts are
+	// visible even while the others answer.
+	if client.pool != nil {
+		result.Set("Endpoints", client.pool.Status(ctx, client))
+	}
+
+	start := time.Now()
+	version, err := client.Version(ctx)
+	result.Update("Latency", time.Since(start).Seconds())
+	if err != nil {
+		return setHealthError(result, err)
+	}
+	result.Update("Version", version)
+
+	if model != "" {
+		list, err := client.List(ctx)
+		if err != nil {
+			return setHealthError(result, err)
+		}
+
+		if findModel(list.Models, model) == nil {
+			return result.Set("Error", "Model "+model+" is not installed")
+		}
+		result.Update("ModelInstalled", true)
+
+		// Older servers do not list running models, which does not
+		// make them unhealthy.
+		running, err := client.Running(ctx)
+		if err == nil {
+			models := make([]*ModelSummary, 0, len(running.Models))
+			for _, item := range running.Models {
+				models = append(models, &item.ModelSummary)
+			}
+			result.Update("ModelLoaded", findModel(models, model) != nil)
+		}
+	}
+
+	return result.Update("Healthy", true)
+}
+
+func setHealthError(result *ordereddict.Dict, err error) *ordereddict.Dict {
+	result.Set("Error", err.Error())
+
+	var llm_err *Error
+	if errors.As(err, &llm_err) {
+		result.Set("ErrorCode", llm_err.Code)
+	}
+	return result
+}
+
+func (self OllamaHealthFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:     "ollama_health",
+		Doc:      "Check that an Ollama server is reachable and optionally that a model is installed.",
+		ArgType:  type_map.AddType(scope, &OllamaHealthFunctionArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&OllamaHealthFunction{})
+}
@@ -0,0 +1,187 @@
+package ollama
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/vtesting/assert"
+	"www.velocidex.com/golang/vfilter"
+)
+
+func (self *OllamaTestSuite) TestFile() {
+	self.run(`
+SELECT * FROM ollama(model="llama3", prompt="Is this script malicious?",
+   file="0123456789", accessor="data", offset=2, length=5,
+   cache_bypass=TRUE, base_url=URL)`)
+
+	assert.Equal(self.T(), 1, len(self.requests))
+	assert.Equal(self.T(), "Is this script malicious?\n\n"+
+		"File 0123456789 (5 bytes from offset 2, truncated):\n23456",
+		self.requests[0].Prompt)
+
+	// Lengths are bounded.
+	rows := self.run(`
+SELECT * FROM ollama(model="llama3", prompt="Hi", file="0123",
+   accessor="data", length=10000000, base_url=URL)`)
+	assert.Equal(self.T(), 0, len(rows))
+	assert.Equal(self.T(), 1, len(self.requests))
+}
+
+func (self *OllamaTestSuite) TestOCR() {
+	rows := self.run(`
+SELECT * FROM ollama_ocr(files=["PNG image", "Too large image"],
+   accessor="data", ocr_model="llava", max_bytes=9,
+   model="llama3", prompt="Is this a phishing lure?", base_url=URL)`)
+	assert.Equal(self.T(), 2, len(rows))
+
+	// The image is sent to the vision model and its text to the
+	// analysis model.
+	assert.Equal(self.T(), 2, len(self.requests))
+	assert.Equal(self.T(), "llava", self.requests[0].Model)
+	assert.Equal(self.T(), []string{"UE5HIGltYWdl"}, self.requests[0].Images)
+	assert.Equal(self.T(), "llama3", self.requests[1].Model)
+	assert.Equal(self.T(), "Is this a phishing lure?\n\nHello world",
+		self.requests[1].Prompt)
+
+	text, _ := rows[0].GetString("Text")
+	assert.Equal(self.T(), "Hello world", text)
+	response, _ := rows[0].GetString("Response")
+	assert.Equal(self.T(), "Hello world", response)
+
+	// Large images are reported without calling the model.
+	error_message, _ := rows[1].GetString("Error")
+	assert.Equal(self.T(), "Image is larger than max_bytes of 9", error_message)
+}
+
+func (self *OllamaTestSuite) TestEmail() {
+	rows := self.run(`
+LET Email <= '''From: "IT Support" <support@examp1e.com>
+To: alice@example.com
+Subject: =?UTF-8?B?UGFzc3dvcmQgZXhwaXJ5?=
+Content-Type: multipart/mixed; boundary="b1"
+
+--b1
+Content-Type: text/html
+
+<p>Your password expires. <a href="https://login.examp1e.com/reset">Reset</a></p>
+--b1
+Content-Type: application/octet-stream
+Content-Disposition: attachment; filename="invoice.js"
+Content-Transfer-Encoding: base64
+
+YWxlcnQoMSk=
+--b1--
+'''
+
+SELECT *, Attachments[0].Name AS AttachmentName,
+       Attachments[0].SHA256 AS AttachmentHash
+FROM ollama_email(file=Email, accessor="data", model="verdict", base_url=URL)`)
+	assert.Equal(self.T(), 1, len(rows))
+
+	subject, _ := rows[0].GetString("Subject")
+	assert.Equal(self.T(), "Password expiry", subject)
+
+	urls, _ := rows[0].Get("Urls")
+	assert.Equal(self.T(), []vfilter.Any{"https://login.examp1e.com/reset"}, urls)
+
+	name, _ := rows[0].GetString("AttachmentName")
+	assert.Equal(self.T(), "invoice.js", name)
+	hash, _ := rows[0].GetString("AttachmentHash")
+	assert.Equal(self.T(), "6e11c72f7cf6bc383152dd16ddd5903aba6bb1c99d6b6639a4bb0b838185fa92",
+		hash)
+
+	// The verdict is normalized into the standard columns.
+	verdict := ordereddict.NewDict()
+	for _, column := range verdict_columns {
+		value, _ := rows[0].Get(column)
+		verdict.Set(column, value)
+	}
+	assert.Equal(self.T(), `{"Verdict":"malicious","Confidence":1,"Severity":"high",`+
+		`"Rationale":"A password reset from a look alike domain.",`+
+		`"References":["The link goes to an unrelated domain"],"Model":"verdict",`+
+		`"PromptHash":"`+promptHash(EMAIL_PROMPT)+`"}`,
+		json.MustMarshalString(verdict))
+
+	// The model is shown the parsed facts, not the raw message.
+	assert.Equal(self.T(), 1, len(self.requests))
+	assert.Contains(self.T(), self.requests[0].Prompt, `"Body": "Your password expires.  Reset"`)
+	assert.NotContains(self.T(), self.requests[0].Prompt, "YWxlcnQoMSk=")
+}
+
+func (self *OllamaTestSuite) TestBinary() {
+	path, err := filepath.Abs("../../../artifacts/testdata/files/notnbt.exe")
+	assert.NoError(self.T(), err)
+
+	rows := self.run(fmt.Sprintf(`
+SELECT *, Imports[0].Import AS FirstImport,
+       Imports[0].Capability AS FirstCapability
+FROM ollama_binary(file=%q, accessor="file",
+   model="capabilities", base_url=URL)`, path))
+	assert.Equal(self.T(), 1, len(rows))
+
+	file_type, _ := rows[0].GetString("Type")
+	assert.Equal(self.T(), "PE", file_type)
+
+	// Imports suggesting a capability are ranked first.
+	first_import, _ := rows[0].GetString("FirstImport")
+	assert.Equal(self.T(), "ADVAPI32.dll!RegOpenKeyExW", first_import)
+	capability, _ := rows[0].GetString("FirstCapability")
+	assert.Equal(self.T(), "registry", capability)
+
+	verdict, _ := rows[0].GetString("Verdict")
+	assert.Equal(self.T(), "benign", verdict)
+	confidence, _ := rows[0].Get("Confidence")
+	assert.Equal(self.T(), 0.8, confidence)
+	summary, _ := rows[0].GetString("Summary")
+	assert.Equal(self.T(), "A network statistics tool.", summary)
+
+	// The model is shown the extracted facts.
+	assert.Equal(self.T(), 1, len(self.requests))
+	assert.Contains(self.T(), self.requests[0].Prompt, `"PDB": "nbtstat.pdb"`)
+}
+
+func (self *OllamaTestSuite) TestPseudonymize() {
+	rows := self.run(`
+LET Rows = SELECT if(condition=_value = 0, then="WS01", else="ws01") AS Hostname,
+       if(condition=_value = 0, then="alice", else="bob") AS Username,
+       if(condition=_value = 0,
+          then="C:\\Users\\Alice\\malice.exe 10.1.2.3 127.0.0.1",
+          else="ssh 10.1.2.3 from 2001:db8::42.") AS CommandLine,
+       "bob@example.com" AS Email
+FROM range(end=2)
+
+LET Result <= SELECT * FROM ollama(model="llama3", prompt="Summarize",
+   query=Rows, pseudonymize=TRUE,
+   pseudonymize_columns=dict(Email="EMAIL"),
+   cache_bypass=TRUE, base_url=URL)
+
+SELECT Result[0].Pseudonyms AS Pseudonyms,
+       ollama_depseudonymize(value="USER_1 ran it on HOST_1 (IP_1), USER_9 did not",
+           pseudonyms=Result[0].Pseudonyms) AS Restored,
+       ollama_depseudonymize(value=dict(Users=["USER_2", "HOST_1"]),
+           pseudonyms=Result[0].Pseudonyms).Users AS RestoredUsers
+FROM scope()`)
+	assert.Equal(self.T(), 1, len(rows))
+
+	// The same value always gets the same placeholder, regardless of
+	// case, and is also replaced in other columns.
+	assert.Equal(self.T(), 1, len(self.requests))
+	assert.Equal(self.T(), "Summarize\n\n"+
+		`{"Hostname":"HOST_1","Username":"USER_1","CommandLine":"C:\\Users\\USER_1\\malice.exe IP_1 127.0.0.1","Email":"EMAIL_1"}`+"\n"+
+		`{"Hostname":"HOST_1","Username":"USER_2","CommandLine":"ssh IP_1 from IP_2.","Email":"EMAIL_1"}`+"\n",
+		self.requests[0].Prompt)
+
+	pseudonyms, _ := rows[0].Get("Pseudonyms")
+	assert.Equal(self.T(), `{"HOST_1":"WS01","USER_1":"alice",`+
+		`"EMAIL_1":"bob@example.com","IP_1":"10.1.2.3","USER_2":"bob",`+
+		`"IP_2":"2001:db8::42"}`,
+		json.MustMarshalString(pseudonyms))
+
+	restored, _ := rows[0].GetString("Restored")
+	assert.Equal(self.T(), "alice ran it on WS01 (10.1.2.3), USER_9 did not", restored)
+
+	users, _ := rows[0].Get("RestoredUsers")
+	assert.Equal(self.T(), []vfilter.Any{"bob", "WS01"}, users)
+}
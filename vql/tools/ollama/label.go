@@ -0,0 +1,172 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	DEFAULT_LABEL_FIELD  = "Verdict"
+	DEFAULT_LABEL_PREFIX = "ai-"
+)
+
+// Verdicts labeled by default.
+var default_label_verdicts = []string{"malicious", "suspicious"}
+
+type OllamaLabelFunctionArgs struct {
+	ClientId string            `vfilter:"required,field=client_id,doc=The client to label."`
+	Verdict  vfilter.Any       `vfilter:"required,field=verdict,doc=The verdict, either a string or the parsed response containing it."`
+	Field    string            `vfilter:"optional,field=field,doc=The field of the parsed response holding the verdict (default Verdict)."`
+	Labels   *ordereddict.Dict `vfilter:"optional,field=labels,doc=Maps verdicts to the labels they set (default ai-malicious and ai-suspicious)."`
+	Category string            `vfilter:"optional,field=category,doc=Added to the default labels (e.g. persistence gives ai-suspicious-persistence)."`
+}
+
+// Keeps a client's labels in line with the latest AI verdict so hunts
+// can target flagged machines. The verdict's label is set and the
+// labels of the other verdicts are removed, so a client found benign
+// later loses its labels. This needs the AI_LABEL_CLIENT permission
+// rather than LABEL_CLIENT so automated labeling can be granted on
+// its own.
+type OllamaLabelFunction struct{}
+
+func (self OllamaLabelFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("ollama_label", args)()
+
+	err := vql_subsystem.CheckAccess(scope, acls.AI_LABEL_CLIENT)
+	if err != nil {
+		scope.Log("ollama_label: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg := &OllamaLabelFunctionArgs{}
+	err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("ollama_label: %v", err)
+		return vfilter.Null{}
+	}
+
+	if arg.Field == "" {
+		arg.Field = DEFAULT_LABEL_FIELD
+	}
+
+	err = services.RequireFrontend()
+	if err != nil {
+		scope.Log("ollama_label: %v", err)
+		return vfilter.Null{}
+	}
+
+	config_obj, ok := vql_subsystem.GetServerConfig(scope)
+	if !ok {
+		scope.Log("ollama_label: Command can only run on the server")
+		return vfilter.Null{}
+	}
+
+	verdict := labelVerdict(scope, arg.Verdict, arg.Field)
+	labels := verdictLabels(arg.Labels, arg.Category)
+
+	var added, removed []string
+	principal := vql_subsystem.GetPrincipal(scope)
+	labeler := services.GetLabeler(config_obj)
+
+	for _, k := range labels.Keys() {
+		label, _ := labels.GetString(k)
+		is_set := labeler.IsLabelSet(ctx, config_obj, arg.ClientId, label)
+
+		switch {
+		case strings.EqualFold(k, verdict) && !is_set:
+			err = labeler.SetClientLabel(ctx, config_obj, arg.ClientId, label)
+			if err != nil {
+				scope.Log("ollama_label: %v", err)
+				return vfilter.Null{}
+			}
+			added = append(added, label)
+			services.LogAudit(ctx, config_obj, principal, "SetClientLabel",
+				ordereddict.NewDict().
+					Set("client_id", arg.ClientId).
+					Set("label", label).
+					Set("verdict", verdict))
+
+		case !strings.EqualFold(k, verdict) && is_set:
+			err = labeler.RemoveClientLabel(ctx, config_obj, arg.ClientId, label)
+			if err != nil {
+				scope.Log("ollama_label: %v", err)
+				return vfilter.Null{}
+			}
+			removed = append(removed, label)
+			services.LogAudit(ctx, config_obj, principal, "RemoveClientLabel",
+				ordereddict.NewDict().
+					Set("client_id", arg.ClientId).
+					Set("label", label).
+					Set("verdict", verdict))
+		}
+	}
+
+	return ordereddict.NewDict().
+		Set("ClientId", arg.ClientId).
+		Set("Verdict", verdict).
+		Set("Added", added).
+		Set("Removed", removed)
+}
+
+// The verdict may be given directly or as a field of the parsed
+// response.
+func labelVerdict(scope vfilter.Scope, verdict vfilter.Any, field string) string {
+	switch t := verdict.(type) {
+	case string:
+		return strings.TrimSpace(t)
+	case nil, vfilter.Null, *vfilter.Null:
+		return ""
+	}
+
+	value, pres := scope.Associative(verdict, field)
+	if !pres || utils.IsNil(value) {
+		return ""
+	}
+	return strings.TrimSpace(utils.ToString(value))
+}
+
+func verdictLabels(labels *ordereddict.Dict, category string) *ordereddict.Dict {
+	if labels != nil {
+		result := ordereddict.NewDict()
+		for _, k := range labels.Keys() {
+			v, _ := labels.Get(k)
+			result.Set(k, utils.ToString(v))
+		}
+		return result
+	}
+
+	result := ordereddict.NewDict()
+	for _, verdict := range default_label_verdicts {
+		label := DEFAULT_LABEL_PREFIX + verdict
+		if category != "" {
+			label = fmt.Sprintf("%s-%s", label, category)
+		}
+		result.Set(verdict, label)
+	}
+	return result
+}
+
+func (self OllamaLabelFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:     "ollama_label",
+		Doc:      "Set or remove client labels to match an AI verdict.",
+		ArgType:  type_map.AddType(scope, &OllamaLabelFunctionArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.AI_LABEL_CLIENT).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&OllamaLabelFunction{})
+}
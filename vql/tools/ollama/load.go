@@ -0,0 +1,117 @@
+package ollama
+
+import (
+	"context"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	DEFAULT_LOAD_KEEP_ALIVE = "30m"
+	DEFAULT_LOAD_TIMEOUT    = 300
+)
+
+type OllamaLoadFunctionArgs struct {
+	Model     string `vfilter:"optional,field=model,doc=The model to load (default AI.default_model)."`
+	KeepAlive string `vfilter:"optional,field=keep_alive,doc=How long the model stays loaded (default 30m, -1 to keep it loaded)."`
+	BaseUrl   string `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server."`
+	Timeout   int64  `vfilter:"optional,field=timeout,doc=Seconds to wait for the model to load (default 300)."`
+}
+
+// Loads a model into memory ahead of a batch job so the first real
+// request does not wait for a cold load. Ollama loads a model without
+// generating anything when it is sent an empty prompt.
+type OllamaLoadFunction struct{}
+
+func (self OllamaLoadFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("ollama_load", args)()
+
+	err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+	if err != nil {
+		scope.Log("ollama_load: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg := &OllamaLoadFunctionArgs{}
+	err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("ollama_load: %v", err)
+		return vfilter.Null{}
+	}
+
+	if arg.KeepAlive == "" {
+		arg.KeepAlive = DEFAULT_LOAD_KEEP_ALIVE
+	}
+
+	if arg.Timeout == 0 {
+		arg.Timeout = DEFAULT_LOAD_TIMEOUT
+	}
+
+	client, err := NewClient(ctx, scope, arg.BaseUrl)
+	if err != nil {
+		scope.Log("ollama_load: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg.Model, err = client.Model(arg.Model)
+	if err != nil {
+		scope.Log("ollama_load: %v", err)
+		return vfilter.Null{}
+	}
+
+	client = client.WithTimeouts(Timeouts{
+		Total: time.Duration(arg.Timeout) * time.Second,
+	})
+
+	result := ordereddict.NewDict().
+		Set("Model", arg.Model).
+		Set("Loaded", false).
+		Set("KeepAlive", arg.KeepAlive).
+		Set("LoadDuration", 0.0).
+		Set("Duration", 0.0)
+
+	// Loading is not cached since the point is to reach the backend.
+	start := time.Now()
+	var stats *Stats
+	err = logGenerate(scope, client, client.Generate)(ctx, &GenerateRequest{
+		Model:     arg.Model,
+		KeepAlive: arg.KeepAlive,
+	}, func(chunk *GenerateResponse) error {
+		if chunk.Done {
+			stats = &chunk.Stats
+		}
+		return nil
+	})
+	result.Update("Duration", time.Since(start).Seconds())
+	if err != nil {
+		scope.Log("ollama_load: %v", err)
+		return setHealthError(result, err)
+	}
+
+	if stats != nil {
+		result.Update("LoadDuration", float64(stats.LoadDuration)/1e9)
+	}
+
+	return result.Update("Loaded", true)
+}
+
+func (self OllamaLoadFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:     "ollama_load",
+		Doc:      "Load a model into memory ahead of a batch of calls.",
+		ArgType:  type_map.AddType(scope, &OllamaLoadFunctionArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&OllamaLoadFunction{})
+}
@@ -0,0 +1,71 @@
+package ollama
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"time"
+
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	// How much of the prompt is shown in the query log. The log is
+	// kept with the collection so should not duplicate the evidence.
+	LOG_PROMPT_BYTES = 200
+)
+
+var (
+	log_secret_regex = regexp.MustCompile(builtinGuardrailRegex("secret"))
+)
+
+// Prepare a prompt for the query log: secrets are masked before the
+// prompt is shortened so a secret can not be partially revealed.
+func logPrompt(prompt string) string {
+	masked := log_secret_regex.ReplaceAllString(cleanText(prompt), "********")
+	return utils.Elide(masked, LOG_PROMPT_BYTES)
+}
+
+// Remove any credentials embedded in the server URL.
+func redactUrl(endpoint string) string {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	return parsed.Redacted()
+}
+
+// Log each model call and how long it took at debug level.
+func logGenerate(scope vfilter.Scope, client *Client,
+	generate generateFunc) generateFunc {
+	endpoint := redactUrl(client.base_url + "/api/generate")
+
+	return func(ctx context.Context, req *GenerateRequest,
+		cb func(resp *GenerateResponse) error) error {
+		scope.Log("DEBUG:ollama: Calling %v with model %v and a %v byte prompt: %v",
+			endpoint, req.Model, len(req.Prompt), logPrompt(req.Prompt))
+
+		start := time.Now()
+		var final *GenerateResponse
+		err := generate(ctx, req, func(chunk *GenerateResponse) error {
+			if chunk.Done {
+				final = chunk
+			}
+			return cb(chunk)
+		})
+
+		duration := time.Since(start).Round(time.Millisecond)
+		switch {
+		case err != nil:
+			scope.Log("DEBUG:ollama: Call to %v failed after %v: %v",
+				endpoint, duration, err)
+		case final != nil:
+			scope.Log("DEBUG:ollama: Call to %v took %v (%v prompt tokens, %v response tokens)",
+				endpoint, duration, final.PromptEvalCount, final.EvalCount)
+		default:
+			scope.Log("DEBUG:ollama: Call to %v took %v", endpoint, duration)
+		}
+		return err
+	}
+}
@@ -0,0 +1,70 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+const (
+	// The done_reason of a response which was cut off at the limit.
+	DONE_REASON_LENGTH = "length"
+)
+
+var (
+	errMaxTokens = errors.New("max_tokens reached")
+)
+
+// Cut off the response once max_tokens is reached. The limit is also
+// sent to the server as num_predict, but servers and proxies do not
+// always honour it. Ollama sends a chunk per token so the chunks are
+// counted.
+func maxTokensGenerate(max_tokens int64, generate generateFunc) generateFunc {
+	if max_tokens <= 0 {
+		return generate
+	}
+
+	return func(ctx context.Context, req *GenerateRequest,
+		cb func(resp *GenerateResponse) error) error {
+		var tokens int64
+		var last *GenerateResponse
+		err := generate(ctx, req, func(chunk *GenerateResponse) error {
+			// Some servers send the last token with the final chunk.
+			if chunk.Done && chunk.Response == "" {
+				return cb(chunk)
+			}
+
+			if tokens >= max_tokens {
+				return errMaxTokens
+			}
+			tokens++
+			last = chunk
+			return cb(chunk)
+		})
+		if !errors.Is(err, errMaxTokens) {
+			return err
+		}
+
+		// Finish the response as the server would have.
+		final := &GenerateResponse{
+			Model:      req.Model,
+			Done:       true,
+			DoneReason: DONE_REASON_LENGTH,
+		}
+		if last != nil {
+			final.CreatedAt = last.CreatedAt
+		}
+		final.EvalCount = tokens
+		return cb(final)
+	}
+}
+
+// Note in the row if the response was cut off at max_tokens.
+func setResponseTruncated(row *ordereddict.Dict,
+	max_tokens int64, final *GenerateResponse) {
+	if max_tokens > 0 {
+		row.Set("ResponseTruncated",
+			final != nil && final.DoneReason == DONE_REASON_LENGTH)
+	}
+}
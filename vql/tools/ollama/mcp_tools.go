@@ -0,0 +1,110 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/velociraptor/vql/tools/mcp"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+// A tool offered by an MCP server.
+type mcpTool struct {
+	client *mcp.Client
+	tool   *mcp.Tool
+	risk   RiskLevel
+}
+
+func (self *mcpTool) Spec() *ToolSpec {
+	parameters := self.tool.InputSchema
+	if parameters == nil {
+		parameters = ordereddict.NewDict().
+			Set("type", "object").
+			Set("properties", ordereddict.NewDict())
+	}
+
+	return &ToolSpec{
+		Type: "function",
+		Function: &ToolFunction{
+			Name:        self.tool.Name,
+			Description: self.tool.Description,
+			Parameters:  parameters,
+		},
+	}
+}
+
+func (self *mcpTool) Risk() RiskLevel {
+	return self.risk
+}
+
+func (self *mcpTool) Call(ctx context.Context, scope vfilter.Scope,
+	args *ordereddict.Dict) (string, error) {
+	result, err := self.client.CallTool(ctx, self.tool.Name, args)
+	if err != nil {
+		return "", err
+	}
+
+	if result.IsError {
+		return "Error: " + result.Text(), nil
+	}
+	return result.Text(), nil
+}
+
+// Each server is described by the same args as mcp_tools() plus an
+// optional risk level applied to all its tools. Since we can not
+// know what a remote tool does the default is medium.
+func parseMCPTools(ctx context.Context, scope vfilter.Scope,
+	servers []*ordereddict.Dict) ([]AgentTool, error) {
+	result := []AgentTool{}
+	for _, server := range servers {
+		server_args := ordereddict.NewDict()
+		risk_level := ""
+		for _, k := range server.Keys() {
+			v, _ := server.Get(k)
+			if k == "risk" {
+				risk_level = utils.ToString(v)
+				continue
+			}
+			server_args.Set(k, v)
+		}
+
+		risk, err := parseRiskLevel(risk_level, RISK_MEDIUM)
+		if err != nil {
+			return nil, fmt.Errorf("mcp_servers: %w", err)
+		}
+
+		arg := &mcp.MCPServerArgs{}
+		err = arg_parser.ExtractArgsWithContext(ctx, scope, server_args, arg)
+		if err != nil {
+			return nil, fmt.Errorf("mcp_servers: %w", err)
+		}
+
+		spec := arg.Spec()
+		err = mcp.CheckServerAccess(scope, spec)
+		if err != nil {
+			return nil, err
+		}
+
+		client, err := mcp.GetClient(ctx, scope, spec)
+		if err != nil {
+			return nil, err
+		}
+
+		tools, err := client.ListTools(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tool := range tools {
+			result = append(result, &mcpTool{
+				client: client,
+				tool:   tool,
+				risk:   risk,
+			})
+		}
+	}
+	return result, nil
+}
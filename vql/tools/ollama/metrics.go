@@ -0,0 +1,72 @@
+package ollama
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	llmCallCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "llm_calls_total",
+			Help: "Number of calls to the LLM backend.",
+		},
+		[]string{"model", "endpoint"},
+	)
+
+	llmErrorCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "llm_errors_total",
+			Help: "Number of failed calls to the LLM backend by error code.",
+		},
+		[]string{"model", "endpoint", "code"},
+	)
+
+	llmTokenCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "llm_tokens_total",
+			Help: "Number of prompt (in) and response (out) tokens.",
+		},
+		[]string{"model", "direction"},
+	)
+
+	llmLatencyHistogram = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "llm_latency_seconds",
+			Help:    "Time taken by calls to the LLM backend.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+		},
+		[]string{"model", "endpoint"},
+	)
+)
+
+// Start recording a call. The endpoint is the API path rather than
+// the full URL to keep the number of series small.
+func instrument(model, path string) func(stats *Stats, err error) {
+	start := time.Now()
+	llmCallCounter.WithLabelValues(model, path).Inc()
+
+	return func(stats *Stats, err error) {
+		llmLatencyHistogram.WithLabelValues(model, path).Observe(
+			time.Since(start).Seconds())
+
+		if err != nil {
+			code := "other"
+			var llm_err *Error
+			if errors.As(err, &llm_err) {
+				code = llm_err.Code
+			}
+			llmErrorCounter.WithLabelValues(model, path, code).Inc()
+		}
+
+		if stats != nil {
+			llmTokenCounter.WithLabelValues(model, "in").Add(
+				float64(stats.PromptEvalCount))
+			llmTokenCounter.WithLabelValues(model, "out").Add(
+				float64(stats.EvalCount))
+		}
+	}
+}
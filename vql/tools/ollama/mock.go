@@ -0,0 +1,334 @@
+package ollama
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+const (
+	MOCK_SCHEME           = "mock://"
+	MOCK_DEFAULT_RESPONSE = "Mock response from {{.Model}}"
+	MOCK_DIMENSIONS       = 64
+)
+
+var (
+	// Mock responses are streamed a word at a time.
+	mock_token_regex = regexp.MustCompile(`\s*\S+|\s+$`)
+)
+
+// Answers the Ollama API without a server, for testing and demoing
+// artifacts offline. It is selected with base_url=mock:// and
+// configured with query parameters:
+//
+//   - response: a Go template of the response, given the Model,
+//     Prompt (the last user message for chats) and System.
+//   - models: comma separated models reported as installed
+//     (default mock).
+//   - error: an HTTP status returned by the model calls.
+//   - dimensions: the size of the embeddings (default 64).
+//
+// For example mock://?response=benign always responds benign.
+type mockTransport struct {
+	base_url     string
+	response     *template.Template
+	models       []string
+	error_status int
+	dimensions   int
+}
+
+func isMockUrl(base_url string) bool {
+	return strings.HasPrefix(base_url, MOCK_SCHEME)
+}
+
+func newMockTransport(base_url string) (*mockTransport, error) {
+	parsed, err := url.Parse(base_url)
+	if err != nil {
+		return nil, err
+	}
+	query := parsed.Query()
+
+	response := query.Get("response")
+	if response == "" {
+		response = MOCK_DEFAULT_RESPONSE
+	}
+
+	tmpl, err := template.New("response").Parse(response)
+	if err != nil {
+		return nil, fmt.Errorf("mock response: %w", err)
+	}
+
+	result := &mockTransport{
+		base_url:   MOCK_SCHEME + parsed.Host,
+		response:   tmpl,
+		models:     []string{"mock"},
+		dimensions: MOCK_DIMENSIONS,
+	}
+
+	models := query.Get("models")
+	if models != "" {
+		result.models = strings.Split(models, ",")
+	}
+
+	status := query.Get("error")
+	if status != "" {
+		result.error_status, err = strconv.Atoi(status)
+		if err != nil {
+			return nil, fmt.Errorf("mock error should be an HTTP status: %w", err)
+		}
+	}
+
+	dimensions := query.Get("dimensions")
+	if dimensions != "" {
+		result.dimensions, err = strconv.Atoi(dimensions)
+		if err != nil || result.dimensions <= 0 {
+			return nil, fmt.Errorf("mock dimensions should be a positive number not %q",
+				dimensions)
+		}
+	}
+
+	return result, nil
+}
+
+type mockRequest struct {
+	Model  string
+	Prompt string
+	System string
+}
+
+func (self *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := self.handle(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Request = req
+	return resp, nil
+}
+
+func (self *mockTransport) handle(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		defer req.Body.Close()
+
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch req.URL.Path {
+	case "/api/generate":
+		return self.generate(body)
+	case "/api/chat":
+		return self.chat(body)
+	case "/api/embed":
+		return self.embed(body)
+	case "/api/show":
+		return self.reply(http.StatusOK, &ShowResponse{
+			Details: ordereddict.NewDict().Set("family", "mock"),
+		})
+	case "/api/tags":
+		return self.reply(http.StatusOK, &ListResponse{Models: self.summaries()})
+	case "/api/ps":
+		result := &PsResponse{}
+		for _, summary := range self.summaries() {
+			result.Models = append(result.Models,
+				&RunningModel{ModelSummary: *summary})
+		}
+		return self.reply(http.StatusOK, result)
+	case "/api/version":
+		return self.reply(http.StatusOK, &VersionResponse{Version: "mock"})
+	}
+
+	return self.reply(http.StatusNotFound, &GenerateResponse{
+		Error: fmt.Sprintf("mock: %v is not supported", req.URL.Path)})
+}
+
+func (self *mockTransport) generate(body []byte) (*http.Response, error) {
+	req := &GenerateRequest{}
+	err := json.Unmarshal(body, req)
+	if err != nil {
+		return self.reply(http.StatusBadRequest, &GenerateResponse{Error: err.Error()})
+	}
+
+	if self.error_status != 0 {
+		return self.failed()
+	}
+
+	response, err := self.render(&mockRequest{
+		Model: req.Model, Prompt: req.Prompt, System: req.System})
+	if err != nil {
+		return self.reply(http.StatusInternalServerError,
+			&GenerateResponse{Error: err.Error()})
+	}
+
+	tokens := mock_token_regex.FindAllString(response, -1)
+	stats := self.stats(req.Model, req.System+req.Prompt, tokens)
+
+	var chunks []interface{}
+	for _, token := range tokens {
+		chunk := &GenerateResponse{Model: req.Model, Response: token}
+		if req.Logprobs {
+			chunk.Logprobs = []*TokenLogprob{{Token: token}}
+		}
+		chunks = append(chunks, chunk)
+	}
+	chunks = append(chunks, &GenerateResponse{
+		Model: req.Model, Done: true, DoneReason: "stop", Stats: stats})
+
+	return self.stream(chunks)
+}
+
+func (self *mockTransport) chat(body []byte) (*http.Response, error) {
+	req := &ChatRequest{}
+	err := json.Unmarshal(body, req)
+	if err != nil {
+		return self.reply(http.StatusBadRequest, &ChatResponse{Error: err.Error()})
+	}
+
+	if self.error_status != 0 {
+		return self.failed()
+	}
+
+	mock_req := &mockRequest{Model: req.Model}
+	var prompt string
+	for _, message := range req.Messages {
+		prompt += message.Content
+		switch message.Role {
+		case "system":
+			if mock_req.System == "" {
+				mock_req.System = message.Content
+			}
+		case "user":
+			mock_req.Prompt = message.Content
+		}
+	}
+
+	response, err := self.render(mock_req)
+	if err != nil {
+		return self.reply(http.StatusInternalServerError,
+			&ChatResponse{Error: err.Error()})
+	}
+
+	tokens := mock_token_regex.FindAllString(response, -1)
+
+	var chunks []interface{}
+	for _, token := range tokens {
+		chunks = append(chunks, &ChatResponse{Model: req.Model,
+			Message: &Message{Role: "assistant", Content: token}})
+	}
+	chunks = append(chunks, &ChatResponse{
+		Model: req.Model, Done: true, DoneReason: "stop",
+		Message: &Message{Role: "assistant"},
+		Stats:   self.stats(req.Model, prompt, tokens)})
+
+	return self.stream(chunks)
+}
+
+// Embeddings are derived from the words of the input so the same
+// input always has the same embedding.
+func (self *mockTransport) embed(body []byte) (*http.Response, error) {
+	req := &EmbedRequest{}
+	err := json.Unmarshal(body, req)
+	if err != nil {
+		return self.reply(http.StatusBadRequest, &GenerateResponse{Error: err.Error()})
+	}
+
+	if self.error_status != 0 {
+		return self.failed()
+	}
+
+	result := &EmbedResponse{Model: req.Model}
+	for _, input := range req.Input {
+		result.Embeddings = append(result.Embeddings,
+			hashEmbedding(input, self.dimensions))
+		result.PromptEvalCount += EstimateTokens(req.Model, input)
+	}
+	return self.reply(http.StatusOK, result)
+}
+
+func (self *mockTransport) render(req *mockRequest) (string, error) {
+	result := &bytes.Buffer{}
+	err := self.response.Execute(result, req)
+	if err != nil {
+		return "", fmt.Errorf("mock response: %w", err)
+	}
+	return result.String(), nil
+}
+
+func (self *mockTransport) stats(model, prompt string, tokens []string) Stats {
+	return Stats{
+		PromptEvalCount: EstimateTokens(model, prompt),
+		EvalCount:       int64(len(tokens)),
+	}
+}
+
+// The digest is derived from the name so it is stable between runs.
+func (self *mockTransport) summaries() []*ModelSummary {
+	var result []*ModelSummary
+	for _, model := range self.models {
+		model = strings.TrimSpace(model)
+		if !strings.Contains(model, ":") {
+			model += ":latest"
+		}
+		hash := sha256.Sum256([]byte(model))
+		result = append(result, &ModelSummary{
+			Name:   model,
+			Model:  model,
+			Digest: hex.EncodeToString(hash[:]),
+		})
+	}
+	return result
+}
+
+func (self *mockTransport) failed() (*http.Response, error) {
+	return self.reply(self.error_status, &GenerateResponse{
+		Error: fmt.Sprintf("mock: %v", http.StatusText(self.error_status))})
+}
+
+func (self *mockTransport) reply(status int, body interface{}) (*http.Response, error) {
+	serialized, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return newHttpResponse(status, serialized), nil
+}
+
+func (self *mockTransport) stream(chunks []interface{}) (*http.Response, error) {
+	result := &bytes.Buffer{}
+	for _, chunk := range chunks {
+		serialized, err := json.Marshal(chunk)
+		if err != nil {
+			return nil, err
+		}
+		result.Write(serialized)
+		result.WriteByte('\n')
+	}
+	return newHttpResponse(http.StatusOK, result.Bytes()), nil
+}
+
+// A response from a transport which answers the Ollama API itself.
+func newHttpResponse(status int, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/x-ndjson"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
@@ -0,0 +1,99 @@
+package ollama
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/vtesting/assert"
+)
+
+func (self *OllamaTestSuite) TestMock() {
+	rows := self.run(`
+SELECT * FROM ollama(model="llama3", prompt="Hi", cache_bypass=TRUE,
+   base_url="mock://?response=Verdict+for+{{.Model}}")`)
+
+	assert.Equal(self.T(), 1, len(rows))
+	response, _ := rows[0].GetString("Response")
+	assert.Equal(self.T(), "Verdict for llama3", response)
+
+	eval_count, _ := rows[0].GetInt64("EvalCount")
+	assert.Equal(self.T(), int64(3), eval_count)
+
+	rows = self.run(`
+SELECT * FROM ollama(model="llama3", prompt="Hi", cache_bypass=TRUE,
+   base_url="mock://?error=404")`)
+
+	assert.Equal(self.T(), 1, len(rows))
+	code, _ := rows[0].GetString("ErrorCode")
+	assert.Equal(self.T(), ERROR_MODEL_NOT_FOUND, code)
+
+	rows = self.run(`
+LET Health <= ollama_health(model="mock", base_url="mock://")
+SELECT Health.Healthy AS Healthy, Health.ModelInstalled AS ModelInstalled
+FROM scope()`)
+	assert.Equal(self.T(), `[{"Healthy":true,"ModelInstalled":true}]`,
+		json.MustMarshalString(rows))
+
+	// Nothing was sent to the server.
+	assert.Equal(self.T(), 0, len(self.requests))
+}
+
+func (self *OllamaTestSuite) TestAITestArtifact() {
+	definition, err := os.ReadFile(
+		"../../../artifacts/definitions/Server/Utils/AITest.yaml")
+	assert.NoError(self.T(), err)
+	self.LoadArtifacts(string(definition))
+
+	passed := func(base_url string) string {
+		rows := self.run(fmt.Sprintf(`
+SELECT Capability, Passed FROM Artifact.Server.Utils.AITest(BaseUrl=%q)`,
+			base_url))
+		return json.MustMarshalString(rows)
+	}
+
+	assert.Equal(self.T(), `[{"Capability":"Connectivity","Passed":true},`+
+		`{"Capability":"Authentication","Passed":true},`+
+		`{"Capability":"Generation","Passed":true},`+
+		`{"Capability":"Streaming","Passed":true},`+
+		`{"Capability":"Embeddings","Passed":true},`+
+		`{"Capability":"Quota","Passed":true}]`,
+		passed("mock://?models=llama3,nomic-embed-text"))
+
+	// A server which answers but fails the model calls is reachable
+	// and nothing else.
+	assert.Equal(self.T(), `[{"Capability":"Connectivity","Passed":true},`+
+		`{"Capability":"Authentication","Passed":false},`+
+		`{"Capability":"Generation","Passed":false},`+
+		`{"Capability":"Streaming","Passed":false},`+
+		`{"Capability":"Embeddings","Passed":false},`+
+		`{"Capability":"Quota","Passed":false}]`,
+		passed("mock://?error=500"))
+}
+
+func (self *OllamaTestSuite) TestCassette() {
+	path := filepath.Join(self.T().TempDir(), "cassette.jsonl")
+
+	// The first run records the response and the second replays it.
+	for i := 0; i < 2; i++ {
+		rows := self.run(fmt.Sprintf(`
+SELECT * FROM ollama(model="llama3", prompt="Hi", cache_bypass=TRUE,
+   cassette=%q, base_url=URL)`, path))
+
+		assert.Equal(self.T(), 1, len(rows))
+		response, _ := rows[0].GetString("Response")
+		assert.Equal(self.T(), "Hello world", response)
+	}
+	assert.Equal(self.T(), 1, len(self.requests))
+
+	// Only recorded requests can be replayed.
+	rows := self.run(fmt.Sprintf(`
+SELECT * FROM ollama(model="llama3", prompt="Bye", cache_bypass=TRUE,
+   cassette=%q, cassette_mode="replay", base_url=URL)`, path))
+
+	assert.Equal(self.T(), 1, len(rows))
+	code, _ := rows[0].GetString("ErrorCode")
+	assert.Equal(self.T(), ERROR_CASSETTE_MISS, code)
+	assert.Equal(self.T(), 1, len(self.requests))
+}
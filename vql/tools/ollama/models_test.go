@@ -0,0 +1,294 @@
+package ollama
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Velocidex/ordereddict"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/vtesting/assert"
+)
+
+func (self *OllamaTestSuite) TestBench() {
+	rows := self.run(`
+SELECT Concurrency, Requests, Errors, ResponseTokens
+FROM ollama_bench(model="mock", base_url="mock://?response=benign+activity",
+   concurrency=[1, 2], requests=3, prompt_tokens=50)`)
+
+	assert.Equal(self.T(), `[{"Concurrency":1,"Requests":3,"Errors":0,"ResponseTokens":6},`+
+		`{"Concurrency":2,"Requests":3,"Errors":0,"ResponseTokens":6}]`,
+		json.MustMarshalString(rows))
+}
+
+func (self *OllamaTestSuite) TestHealth() {
+	rows := self.run(`
+SELECT ollama_health(model="llama3", base_url=URL) AS Health FROM scope()`)
+	assert.Equal(self.T(), 1, len(rows))
+
+	health_any, _ := rows[0].Get("Health")
+	health := health_any.(*ordereddict.Dict)
+
+	healthy, _ := health.GetBool("Healthy")
+	assert.True(self.T(), healthy)
+
+	version, _ := health.GetString("Version")
+	assert.Equal(self.T(), "0.5.7", version)
+
+	loaded, _ := health.GetBool("ModelLoaded")
+	assert.True(self.T(), loaded)
+
+	// Missing models make the server unhealthy.
+	rows = self.run(`
+SELECT ollama_health(model="mistral", base_url=URL).Healthy AS Healthy FROM scope()`)
+	healthy, _ = rows[0].GetBool("Healthy")
+	assert.False(self.T(), healthy)
+
+	// So do servers which can not be reached.
+	rows = self.run(`
+SELECT ollama_health(base_url="http://127.0.0.1:1") AS Health FROM scope()`)
+	health_any, _ = rows[0].Get("Health")
+	health = health_any.(*ordereddict.Dict)
+
+	healthy, _ = health.GetBool("Healthy")
+	assert.False(self.T(), healthy)
+
+	code, _ := health.GetString("ErrorCode")
+	assert.Equal(self.T(), ERROR_CONNECTION, code)
+}
+
+func (self *OllamaTestSuite) TestLoad() {
+	rows := self.run(`
+SELECT ollama_load(model="llama3", keep_alive="1h", base_url=URL) AS Load
+FROM scope()`)
+	assert.Equal(self.T(), 1, len(rows))
+
+	load_any, _ := rows[0].Get("Load")
+	load := load_any.(*ordereddict.Dict)
+
+	loaded, _ := load.GetBool("Loaded")
+	assert.True(self.T(), loaded)
+
+	// The model is loaded with an empty prompt.
+	assert.Equal(self.T(), 1, len(self.requests))
+	assert.Equal(self.T(), "", self.requests[0].Prompt)
+	assert.Equal(self.T(), "1h", self.requests[0].KeepAlive)
+	assert.False(self.T(), self.requests[0].Stream)
+
+	// Models which can not be loaded are reported.
+	rows = self.run(`
+SELECT ollama_load(model="missing", base_url=URL) AS Load FROM scope()`)
+	load_any, _ = rows[0].Get("Load")
+	load = load_any.(*ordereddict.Dict)
+
+	loaded, _ = load.GetBool("Loaded")
+	assert.False(self.T(), loaded)
+
+	code, _ := load.GetString("ErrorCode")
+	assert.Equal(self.T(), ERROR_MODEL_NOT_FOUND, code)
+}
+
+func (self *OllamaTestSuite) TestCreate() {
+	filename := filepath.Join(self.T().TempDir(), "OllamaModel")
+	assert.NoError(self.T(), os.WriteFile(filename, []byte("GGUF weights"), 0600))
+
+	query := fmt.Sprintf(`
+SELECT ollama_create(model="llama3-offline", file=%q, accessor="file",
+   system="You are a DFIR analyst", base_url=URL) AS Create
+FROM scope()`, filename)
+
+	rows := self.run(query)
+	assert.Equal(self.T(), 1, len(rows))
+
+	create_any, _ := rows[0].Get("Create")
+	create := create_any.(*ordereddict.Dict)
+
+	created, _ := create.GetBool("Created")
+	assert.True(self.T(), created)
+
+	uploaded, _ := create.GetBool("Uploaded")
+	assert.True(self.T(), uploaded)
+
+	status, _ := create.GetString("Status")
+	assert.Equal(self.T(), "success", status)
+
+	// The blob is stored under its digest and the model refers to it.
+	digest, _ := create.GetString("Digest")
+	assert.Equal(self.T(), []byte("GGUF weights"), self.blobs[digest])
+	assert.Equal(self.T(), 1, len(self.create_requests))
+	assert.Equal(self.T(), "llama3-offline", self.create_requests[0].Model)
+	assert.Equal(self.T(), digest,
+		self.create_requests[0].Files["OllamaModel.gguf"])
+
+	// The blob is not sent again.
+	rows = self.run(query)
+	create_any, _ = rows[0].Get("Create")
+	create = create_any.(*ordereddict.Dict)
+
+	uploaded, _ = create.GetBool("Uploaded")
+	assert.False(self.T(), uploaded)
+	assert.Equal(self.T(), 2, len(self.create_requests))
+}
+
+func (self *OllamaTestSuite) TestGPUInfo() {
+	rows := self.run(`
+SELECT gpu_info(model="llama3", base_url=URL) AS GPU FROM scope()`)
+	assert.Equal(self.T(), 1, len(rows))
+
+	gpu_any, _ := rows[0].Get("GPU")
+	gpu := gpu_any.(*ordereddict.Dict)
+
+	// The host's GPUs depend on where the test runs.
+	_, pres := gpu.Get("HasGPU")
+	assert.True(self.T(), pres)
+
+	ollama_any, _ := gpu.Get("Ollama")
+	ollama := ollama_any.(*ordereddict.Dict)
+
+	version, _ := ollama.GetString("Version")
+	assert.Equal(self.T(), "0.5.7", version)
+
+	used, _ := ollama.GetInt64("UsedVRAM")
+	assert.Equal(self.T(), int64(4000000000), used)
+
+	vision, _ := ollama.GetBool("Vision")
+	assert.True(self.T(), vision)
+
+	// Unreachable servers are reported without failing the call.
+	rows = self.run(`
+SELECT gpu_info(base_url="http://127.0.0.1:1") AS GPU FROM scope()`)
+	gpu_any, _ = rows[0].Get("GPU")
+	gpu = gpu_any.(*ordereddict.Dict)
+
+	ollama_any, _ = gpu.Get("Ollama")
+	ollama = ollama_any.(*ordereddict.Dict)
+
+	code, _ := ollama.GetString("ErrorCode")
+	assert.Equal(self.T(), ERROR_CONNECTION, code)
+}
+
+func (self *OllamaTestSuite) TestVariant() {
+	self.tags_response = `{"models":[
+{"name":"llama3.1:8b-instruct-q4_K_M","size":4900000000,
+ "details":{"quantization_level":"Q4_K_M","parameter_size":"8.0B"}},
+{"name":"llama3.1:8b-instruct-q8_0","size":8500000000,
+ "details":{"quantization_level":"Q8_0","parameter_size":"8.0B"}},
+{"name":"llama3.1:70b","size":40000000000},
+{"name":"llama3:latest","size":4700000000}]}`
+
+	rows := self.run(`
+SELECT ollama_variant(family="llama3.1", memory=12000000000,
+          base_url=URL) AS Large,
+       ollama_variant(family="llama3.1", memory=8000000000,
+          base_url=URL) AS Small,
+       ollama_variant(family="llama3.1", memory=1000000000,
+          base_url=URL) AS Tiny,
+       ollama_variant(family="mistral", memory=8000000000,
+          base_url=URL) AS Missing
+FROM scope()`)
+	assert.Equal(self.T(), 1, len(rows))
+
+	get := func(column, field string) interface{} {
+		variant_any, _ := rows[0].Get(column)
+		value, _ := variant_any.(*ordereddict.Dict).Get(field)
+		return value
+	}
+
+	// q8 needs 8.5GB which fits 80% of 12GB.
+	assert.Equal(self.T(), "llama3.1:8b-instruct-q8_0", get("Large", "Model"))
+	assert.Equal(self.T(), "Q8_0", get("Large", "Quantization"))
+	assert.Equal(self.T(), VARIANT_FITS, get("Large", "Reason"))
+
+	assert.Equal(self.T(), "llama3.1:8b-instruct-q4_K_M", get("Small", "Model"))
+
+	// When nothing fits the smallest variant is used.
+	assert.Equal(self.T(), "llama3.1:8b-instruct-q4_K_M", get("Tiny", "Model"))
+	assert.Equal(self.T(), VARIANT_SMALLEST, get("Tiny", "Reason"))
+
+	assert.Equal(self.T(), ERROR_MODEL_NOT_FOUND, get("Missing", "ErrorCode"))
+}
+
+func (self *OllamaTestSuite) TestModelPool() {
+	err := SetAISettings(self.ConfigObj, &config_proto.AIConfig{
+		ModelPool: []*config_proto.AIModelConfig{{
+			Name:          "llama3",
+			ContextWindow: 1024,
+			Tasks:         []string{"classification"},
+		}, {
+			Name:          "llama3.1",
+			ContextWindow: 65536,
+		}},
+	})
+	assert.NoError(self.T(), err)
+	defer SetAISettings(self.ConfigObj, &config_proto.AIConfig{})
+
+	rows := self.run(`
+SELECT Model, ModelSelection.Reason AS Reason
+FROM chain(a={
+  SELECT * FROM ollama(task="classification", prompt="Hi", base_url=URL)
+}, b={
+  -- Too large for the classification model.
+  SELECT * FROM ollama(task="classification", prompt="Classify these",
+     query={ SELECT * FROM range(end=1000) }, max_rows=-1, base_url=URL)
+}, c={
+  SELECT * FROM ollama(task="summarization", prompt="Hi", base_url=URL)
+}, d={
+  SELECT * FROM ollama(task="classification", prompt="Hi",
+     context_window=4096, base_url=URL)
+}, e={
+  SELECT * FROM ollama(model="mistral", task="classification",
+     prompt="Hi", base_url=URL)
+})`)
+	assert.Equal(self.T(), `[{"Model":"llama3","Reason":"fits"},`+
+		`{"Model":"llama3.1","Reason":"fits"},`+
+		`{"Model":"llama3.1","Reason":"fits"},`+
+		`{"Model":"llama3.1","Reason":"fits"},`+
+		`{"Model":"mistral","Reason":null}]`,
+		json.MustMarshalString(rows))
+
+	// Picked models run with the window they were picked for.
+	num_ctx, _ := self.requests[0].Options.GetInt64("num_ctx")
+	assert.Equal(self.T(), int64(1024), num_ctx)
+	assert.Nil(self.T(), self.requests[len(self.requests)-1].Options)
+
+	// Each row is sent to the model it fits.
+	rows = self.run(`
+SELECT Model, Prompt, ModelSelection.Task AS Task
+FROM ollama(task="classification", prompt_column="Question", query={
+  SELECT * FROM foreach(row=[
+     dict(Question="Short"),
+     dict(Question=format(format="%02000d", args=1))])
+}, base_url=URL)`)
+	assert.Equal(self.T(), 2, len(rows))
+	model, _ := rows[0].GetString("Model")
+	assert.Equal(self.T(), "llama3", model)
+	model, _ = rows[1].GetString("Model")
+	assert.Equal(self.T(), "llama3.1", model)
+}
+
+func (self *OllamaTestSuite) TestPinnedModel() {
+	rows := self.run(`
+SELECT Model, Response, ErrorCode
+FROM chain(a={
+  SELECT * FROM ollama(model="llama3@sha256:365c0bd3c000", prompt="Hi",
+     cache_bypass=TRUE, base_url=URL)
+}, b={
+  SELECT * FROM ollama(model="llama3@sha256:0123456789ab", prompt="Hi",
+     cache_bypass=TRUE, base_url=URL)
+}, c={
+  SELECT * FROM ollama(model="llama3@sha256:bad", prompt="Hi",
+     cache_bypass=TRUE, base_url=URL)
+})`)
+	assert.Equal(self.T(), `[{"Model":"llama3@sha256:365c0bd3c000",`+
+		`"Response":"Hello world","ErrorCode":null},`+
+		`{"Model":"llama3@sha256:0123456789ab","Response":null,`+
+		`"ErrorCode":"digest_mismatch"},`+
+		`{"Model":"llama3@sha256:bad","Response":null,`+
+		`"ErrorCode":"bad_request"}]`,
+		json.MustMarshalString(rows))
+
+	// Only the verified model was called, by its name.
+	assert.Equal(self.T(), 1, len(self.requests))
+	assert.Equal(self.T(), "llama3", self.requests[0].Model)
+}
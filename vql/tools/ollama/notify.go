@@ -0,0 +1,233 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	NOTIFY_FORMAT_SLACK   = "slack"
+	NOTIFY_FORMAT_TEAMS   = "teams"
+	NOTIFY_FORMAT_GENERIC = "generic"
+
+	// Chat services reject very long messages.
+	MAX_NOTIFY_LENGTH = 30000
+)
+
+type OllamaNotifyPluginArgs struct {
+	Query      vfilter.StoredQuery `vfilter:"required,field=query,doc=The rows to notify about (e.g. high severity verdicts or a hunt digest)."`
+	Url        string              `vfilter:"required,field=url,doc=The chat webhook URL. Use secret://name for an endpoint stored in a Webhook Secret."`
+	Format     string              `vfilter:"optional,field=format,doc=The message format: slack, teams or generic (default slack)."`
+	Template   string              `vfilter:"optional,field=template,doc=A Go template rendering each row into a message (e.g. {{.Verdict}}: {{.Summary}}). By default all columns are listed."`
+	Title      string              `vfilter:"optional,field=title,doc=A title shown above the message."`
+	Digest     bool                `vfilter:"optional,field=digest,doc=Post all rows as one message instead of one message per row."`
+	HmacKey    string              `vfilter:"optional,field=hmac_key,doc=Sign the requests with this key, as in ollama_webhook()."`
+	Headers    *ordereddict.Dict   `vfilter:"optional,field=headers,doc=Extra headers to send."`
+	Timeout    int64               `vfilter:"optional,field=timeout,doc=Seconds each request may take (default 30)."`
+	MaxRetries int64               `vfilter:"optional,field=max_retries,doc=How many times a failed request is retried (default 3, -1 to disable)."`
+}
+
+// Posts AI summaries and verdicts into a chat channel through an
+// incoming webhook. Rows are rendered into text with the template and
+// wrapped in the payload the chat service expects:
+//
+//   - slack: {"text": "*title*\nmessage"}
+//   - teams: a MessageCard with the title and message.
+//   - generic: {"title": "title", "text": "message"}
+//
+// Delivery is retried like ollama_webhook().
+type OllamaNotifyPlugin struct{}
+
+func (self OllamaNotifyPlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("ollama_notify", args)()
+
+		err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+		if err != nil {
+			scope.Log("ollama_notify: %v", err)
+			return
+		}
+
+		arg := &OllamaNotifyPluginArgs{}
+		err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+		if err != nil {
+			scope.Log("ollama_notify: %v", err)
+			return
+		}
+
+		if arg.Format == "" {
+			arg.Format = NOTIFY_FORMAT_SLACK
+		}
+
+		switch arg.Format {
+		case NOTIFY_FORMAT_SLACK, NOTIFY_FORMAT_TEAMS, NOTIFY_FORMAT_GENERIC:
+		default:
+			scope.Log("ollama_notify: format should be slack, teams or generic not %q",
+				arg.Format)
+			return
+		}
+
+		var tmpl *template.Template
+		if arg.Template != "" {
+			tmpl, err = template.New("message").Parse(arg.Template)
+			if err != nil {
+				scope.Log("ollama_notify: template: %v", err)
+				return
+			}
+		}
+
+		webhook, err := newWebhook(ctx, scope, webhookOptions{
+			url:         arg.Url,
+			hmac_key:    arg.HmacKey,
+			headers:     arg.Headers,
+			timeout:     arg.Timeout,
+			max_retries: arg.MaxRetries,
+		})
+		if err != nil {
+			scope.Log("ollama_notify: %v", err)
+			return
+		}
+
+		send := func(message string) bool {
+			body, err := notifyPayload(arg.Format, arg.Title, message)
+			if err != nil {
+				scope.Log("ollama_notify: %v", err)
+				return true
+			}
+
+			result := webhook.Deliver(ctx, body)
+			if result.err != nil {
+				scope.Log("ollama_notify: Delivery to %v failed: %v",
+					redactUrl(webhook.url), result.err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return false
+			case output_chan <- result.Row(webhook.url).Set("Message", message):
+			}
+			return true
+		}
+
+		var messages []string
+		for row := range arg.Query.Eval(ctx, scope) {
+			message, err := notifyMessage(tmpl, promptRow(ctx, scope, row))
+			if err != nil {
+				scope.Log("ollama_notify: %v", err)
+				continue
+			}
+
+			if arg.Digest {
+				messages = append(messages, message)
+				continue
+			}
+
+			if !send(message) {
+				return
+			}
+		}
+
+		// Nothing is posted if there were no rows so a quiet night
+		// does not notify the channel.
+		if arg.Digest && len(messages) > 0 {
+			send(strings.Join(messages, "\n\n"))
+		}
+	}()
+
+	return output_chan
+}
+
+// Render the row with the template, or list its columns.
+func notifyMessage(tmpl *template.Template, row *ordereddict.Dict) (string, error) {
+	if tmpl == nil {
+		lines := make([]string, 0, row.Len())
+		for _, k := range row.Keys() {
+			v, _ := row.Get(k)
+			lines = append(lines, fmt.Sprintf("%v: %v", k, notifyValue(v)))
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+
+	// Templates can only access maps by field name.
+	data := make(map[string]interface{})
+	for _, k := range row.Keys() {
+		v, _ := row.Get(k)
+		data[k] = notifyValue(v)
+	}
+
+	result := &strings.Builder{}
+	err := tmpl.Execute(result, data)
+	if err != nil {
+		return "", fmt.Errorf("template: %w", err)
+	}
+	return result.String(), nil
+}
+
+func notifyValue(value interface{}) interface{} {
+	switch t := value.(type) {
+	case string, int64, float64, bool:
+		return t
+	case nil:
+		return ""
+	}
+	return json.AnyToString(value, json.DefaultEncOpts())
+}
+
+func notifyPayload(format, title, message string) ([]byte, error) {
+	message = utils.Elide(message, MAX_NOTIFY_LENGTH)
+
+	switch format {
+	case NOTIFY_FORMAT_SLACK:
+		text := message
+		if title != "" {
+			text = "*" + title + "*\n" + message
+		}
+		return json.Marshal(ordereddict.NewDict().Set("text", text))
+
+	case NOTIFY_FORMAT_TEAMS:
+		card := ordereddict.NewDict().
+			Set("@type", "MessageCard").
+			Set("@context", "https://schema.org/extensions")
+		if title != "" {
+			card.Set("title", title)
+		}
+		// Teams renders the text as markdown which needs two spaces
+		// to break a line.
+		card.Set("text", strings.ReplaceAll(message, "\n", "  \n"))
+		return json.Marshal(card)
+	}
+
+	return json.Marshal(ordereddict.NewDict().
+		Set("title", title).
+		Set("text", message))
+}
+
+func (self OllamaNotifyPlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name:     "ollama_notify",
+		Doc:      "Post AI summaries or verdicts to a Slack, Teams or other chat webhook, rendered with a template.",
+		ArgType:  type_map.AddType(scope, &OllamaNotifyPluginArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterPlugin(&OllamaNotifyPlugin{})
+}
@@ -0,0 +1,209 @@
+package ollama
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/accessors"
+	"www.velocidex.com/golang/velociraptor/acls"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	DEFAULT_OCR_PROMPT = "Transcribe all the text in this image exactly as " +
+		"it appears. Output only the text."
+
+	DEFAULT_MAX_IMAGE = 10 * 1024 * 1024
+)
+
+type OllamaOCRPluginArgs struct {
+	Files     []*accessors.OSPath `vfilter:"required,field=files,doc=The images to read (e.g. collected screenshots)."`
+	Accessor  string              `vfilter:"optional,field=accessor,doc=The accessor used to read files (default auto)."`
+	OCRModel  string              `vfilter:"required,field=ocr_model,doc=A vision model which reads the text in the images (e.g. llava)."`
+	OCRPrompt string              `vfilter:"optional,field=ocr_prompt,doc=The prompt asking the vision model for the text in the image."`
+	Model     string              `vfilter:"optional,field=model,doc=The model analysing the text (e.g. llama3). Defaults to AI.default_model in the config."`
+	Prompt    string              `vfilter:"optional,field=prompt,doc=The analysis prompt. The text of the image is added after it. If not given only the text is returned."`
+	System    string              `vfilter:"optional,field=system,doc=A system prompt for the analysis."`
+	MaxBytes  int64               `vfilter:"optional,field=max_bytes,doc=Images larger than this are skipped (default 10mb)."`
+	BaseUrl   string              `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server. Several comma separated URLs, or srv://name for the servers of a DNS SRV record, spread the calls over the servers."`
+	Timeout   int64               `vfilter:"optional,field=timeout,doc=Seconds each model call may take (default 3600)."`
+	Options   *ordereddict.Dict   `vfilter:"optional,field=options,doc=Model options (e.g. temperature, num_ctx)."`
+	Format    vfilter.Any         `vfilter:"optional,field=format,doc=Either 'json' or a JSON schema to constrain the analysis."`
+	KeepAlive string              `vfilter:"optional,field=keep_alive,doc=How long the models stay loaded after the call (e.g. 5m)."`
+}
+
+// Reads the text in images with a vision model and then analyses
+// the text with a second model. Small vision models read text well
+// but reason poorly so the analysis is left to a text model. A
+// failed image is reported in its row and the remaining images are
+// still processed.
+type OllamaOCRPlugin struct{}
+
+func (self OllamaOCRPlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("ollama_ocr", args)()
+
+		err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+		if err != nil {
+			scope.Log("ollama_ocr: %v", err)
+			return
+		}
+
+		arg := &OllamaOCRPluginArgs{}
+		err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+		if err != nil {
+			scope.Log("ollama_ocr: %v", err)
+			return
+		}
+
+		err = vql_subsystem.CheckFilesystemAccess(scope, arg.Accessor)
+		if err != nil {
+			scope.Log("ollama_ocr: %v", err)
+			return
+		}
+
+		accessor, err := accessors.GetAccessor(arg.Accessor, scope)
+		if err != nil {
+			scope.Log("ollama_ocr: %v", err)
+			return
+		}
+
+		if arg.OCRPrompt == "" {
+			arg.OCRPrompt = DEFAULT_OCR_PROMPT
+		}
+
+		if arg.MaxBytes == 0 {
+			arg.MaxBytes = DEFAULT_MAX_IMAGE
+		}
+
+		client, err := NewClient(ctx, scope, arg.BaseUrl)
+		if err != nil {
+			scope.Log("ollama_ocr: %v", err)
+			return
+		}
+
+		if arg.Prompt != "" {
+			arg.Model, err = client.Model(arg.Model)
+			if err != nil {
+				scope.Log("ollama_ocr: %v", err)
+				return
+			}
+		}
+
+		client = client.WithTimeouts(Timeouts{
+			Total: time.Duration(arg.Timeout) * time.Second,
+		})
+		generate := logGenerate(scope, client, client.Generate)
+
+		for _, path := range arg.Files {
+			row := ordereddict.NewDict().Set("File", path)
+
+			text, err := self.readText(ctx, accessor, arg, path, generate)
+			if err != nil {
+				scope.Log("ollama_ocr: %v: %v", path, err)
+				row.Set("Error", err.Error())
+
+			} else {
+				row.Set("OCRModel", arg.OCRModel).
+					Set("Text", text)
+
+				if arg.Prompt != "" {
+					response, err := generateText(ctx, generate,
+						&GenerateRequest{
+							Model:     arg.Model,
+							Prompt:    arg.Prompt + "\n\n" + text,
+							System:    arg.System,
+							Format:    arg.Format,
+							Options:   arg.Options,
+							KeepAlive: arg.KeepAlive,
+						})
+					row.Set("Model", arg.Model).
+						Set("Response", response)
+					if err != nil {
+						scope.Log("ollama_ocr: %v: %v", path, err)
+						row.Set("Error", err.Error())
+					}
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case output_chan <- row:
+			}
+		}
+	}()
+
+	return output_chan
+}
+
+func (self OllamaOCRPlugin) readText(ctx context.Context,
+	accessor accessors.FileSystemAccessor, arg *OllamaOCRPluginArgs,
+	path *accessors.OSPath, generate generateFunc) (string, error) {
+	fd, err := accessor.OpenWithOSPath(path)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+
+	data, err := io.ReadAll(io.LimitReader(fd, arg.MaxBytes+1))
+	if err != nil {
+		return "", err
+	}
+
+	if int64(len(data)) > arg.MaxBytes {
+		return "", fmt.Errorf("Image is larger than max_bytes of %v",
+			arg.MaxBytes)
+	}
+
+	text, err := generateText(ctx, generate, &GenerateRequest{
+		Model:     arg.OCRModel,
+		Prompt:    arg.OCRPrompt,
+		Images:    []string{base64.StdEncoding.EncodeToString(data)},
+		Options:   arg.Options,
+		KeepAlive: arg.KeepAlive,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// The model's output may contain anything written in the image.
+	return strings.TrimSpace(cleanText(text)), nil
+}
+
+func generateText(ctx context.Context, generate generateFunc,
+	req *GenerateRequest) (string, error) {
+	response := &strings.Builder{}
+	err := generate(ctx, req, func(chunk *GenerateResponse) error {
+		response.WriteString(chunk.Response)
+		return nil
+	})
+	return response.String(), err
+}
+
+func (self OllamaOCRPlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name:     "ollama_ocr",
+		Doc:      "Read the text in images with a vision model and analyse it with a prompt.",
+		ArgType:  type_map.AddType(scope, &OllamaOCRPluginArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterPlugin(&OllamaOCRPlugin{})
+}
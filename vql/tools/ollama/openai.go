@@ -0,0 +1,865 @@
+package ollama
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+const (
+	OPENAI_SCHEME = "openai+"
+
+	OPENAI_API_CHAT      = "chat"
+	OPENAI_API_RESPONSES = "responses"
+)
+
+// Ollama options and the request fields of each API they map to.
+var (
+	openai_chat_options = map[string]string{
+		"temperature":       "temperature",
+		"top_p":             "top_p",
+		"seed":              "seed",
+		"stop":              "stop",
+		"num_predict":       "max_tokens",
+		"presence_penalty":  "presence_penalty",
+		"frequency_penalty": "frequency_penalty",
+	}
+
+	openai_responses_options = map[string]string{
+		"temperature": "temperature",
+		"top_p":       "top_p",
+		"num_predict": "max_output_tokens",
+	}
+)
+
+// Hosted providers and many local servers (e.g. vLLM, llama.cpp or
+// LM Studio) speak the OpenAI API rather than Ollama's. Prefixing the
+// server's URL with openai+ (e.g. openai+https://api.openai.com/v1)
+// translates the calls the plugins make into that API. The API is
+// selected with a query parameter:
+//
+//   - api: chat (the default) for the chat completions API or
+//     responses for the responses API.
+//
+// Other parameters (e.g. the api-version of Azure OpenAI) are sent
+// with each request. The API key is usually sent as an Authorization header from the
+// extra_headers of an HTTP Secret.
+type openaiTransport struct {
+	// The URL the client calls, without the parameters.
+	base_url string
+
+	upstream  *url.URL
+	query     string
+	api       string
+	transport http.RoundTripper
+}
+
+func isOpenAIUrl(base_url string) bool {
+	return strings.HasPrefix(base_url, OPENAI_SCHEME)
+}
+
+func newOpenAITransport(base_url string,
+	transport http.RoundTripper) (*openaiTransport, error) {
+	parsed, err := url.Parse(strings.TrimPrefix(base_url, OPENAI_SCHEME))
+	if err != nil {
+		return nil, err
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf(
+			"OpenAI servers should be called over http or https not %v", base_url)
+	}
+
+	query := parsed.Query()
+	api := query.Get("api")
+	query.Del("api")
+	switch api {
+	case "":
+		api = OPENAI_API_CHAT
+	case OPENAI_API_CHAT, OPENAI_API_RESPONSES:
+	default:
+		return nil, fmt.Errorf("OpenAI api should be chat or responses not %v", api)
+	}
+
+	parsed.RawQuery = ""
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/")
+
+	return &openaiTransport{
+		base_url:  OPENAI_SCHEME + parsed.String(),
+		upstream:  parsed,
+		query:     query.Encode(),
+		api:       api,
+		transport: transport,
+	}, nil
+}
+
+// An error reported by the server, passed to the client as Ollama
+// would report it.
+type openaiStatusError struct {
+	status  int
+	message string
+}
+
+func (self *openaiStatusError) Error() string {
+	return self.message
+}
+
+func (self *openaiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		defer req.Body.Close()
+
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := self.handle(req, body)
+	if err != nil {
+		var status_err *openaiStatusError
+		if !errors.As(err, &status_err) {
+			return nil, err
+		}
+		result = &GenerateResponse{Error: status_err.message}
+		return self.reply(req, status_err.status, result)
+	}
+
+	stream, ok := result.(io.ReadCloser)
+	if ok {
+		resp := newHttpResponse(http.StatusOK, nil)
+		resp.Body = stream
+		resp.ContentLength = -1
+		resp.Request = req
+		return resp, nil
+	}
+	return self.reply(req, http.StatusOK, result)
+}
+
+func (self *openaiTransport) handle(
+	req *http.Request, body []byte) (interface{}, error) {
+	switch strings.TrimPrefix(req.URL.Path, self.upstream.Path) {
+	case "/api/chat":
+		chat_req := &ChatRequest{}
+		err := json.Unmarshal(body, chat_req)
+		if err != nil {
+			return nil, &openaiStatusError{
+				status: http.StatusBadRequest, message: err.Error()}
+		}
+		return self.chat(req, chat_req, asChatChunk)
+
+	case "/api/generate":
+		generate_req := &GenerateRequest{}
+		err := json.Unmarshal(body, generate_req)
+		if err != nil {
+			return nil, &openaiStatusError{
+				status: http.StatusBadRequest, message: err.Error()}
+		}
+		return self.chat(req, generateAsChat(generate_req), asGenerateChunk)
+
+	case "/api/embed":
+		embed_req := &EmbedRequest{}
+		err := json.Unmarshal(body, embed_req)
+		if err != nil {
+			return nil, &openaiStatusError{
+				status: http.StatusBadRequest, message: err.Error()}
+		}
+		return self.embed(req, embed_req)
+
+	case "/api/tags":
+		return self.models(req)
+
+	case "/api/version":
+		_, err := self.models(req)
+		if err != nil {
+			return nil, err
+		}
+		return &VersionResponse{Version: "openai/" + self.api}, nil
+
+	// The API does not describe the models or what is loaded.
+	case "/api/show":
+		return &ShowResponse{
+			Details: ordereddict.NewDict().Set("family", "openai"),
+		}, nil
+
+	case "/api/ps":
+		return &PsResponse{}, nil
+	}
+
+	return nil, &openaiStatusError{
+		status: http.StatusNotFound,
+		message: fmt.Sprintf("%v is not supported by OpenAI servers",
+			req.URL.Path)}
+}
+
+// The prompt of a generate request is sent as a chat.
+func generateAsChat(generate_req *GenerateRequest) *ChatRequest {
+	messages := []*Message{}
+	if generate_req.System != "" {
+		messages = append(messages,
+			&Message{Role: "system", Content: generate_req.System})
+	}
+	messages = append(messages, &Message{
+		Role:    "user",
+		Content: generate_req.Prompt,
+		Images:  generate_req.Images,
+	})
+
+	return &ChatRequest{
+		Model:    generate_req.Model,
+		Messages: messages,
+		Format:   generate_req.Format,
+		Options:  generate_req.Options,
+		Stream:   generate_req.Stream,
+	}
+}
+
+func asChatChunk(chunk *ChatResponse) interface{} {
+	return chunk
+}
+
+func asGenerateChunk(chunk *ChatResponse) interface{} {
+	result := &GenerateResponse{
+		Model:      chunk.Model,
+		Done:       chunk.Done,
+		DoneReason: chunk.DoneReason,
+		Stats:      chunk.Stats,
+	}
+	if chunk.Message != nil {
+		result.Response = chunk.Message.Content
+	}
+	return result
+}
+
+// Returns the response converted by format or, when streaming, a
+// reader of the converted chunks.
+func (self *openaiTransport) chat(req *http.Request, chat_req *ChatRequest,
+	format func(chunk *ChatResponse) interface{}) (interface{}, error) {
+	start := utils.GetTime().Now()
+	finish := func(result *ChatResponse) {
+		if result.Model == "" {
+			result.Model = chat_req.Model
+		}
+		result.Done = true
+		result.TotalDuration = int64(utils.GetTime().Now().Sub(start))
+	}
+
+	path := "/chat/completions"
+	build := buildChatCompletion
+	if self.api == OPENAI_API_RESPONSES {
+		path = "/responses"
+		build = buildResponse
+	}
+
+	names := newToolNames(chat_req.Tools)
+	request, err := build(chat_req, names)
+	if err != nil {
+		return nil, err
+	}
+
+	if chat_req.Stream {
+		return self.stream(req, path, request, names, finish, format)
+	}
+
+	var result *ChatResponse
+	if self.api == OPENAI_API_RESPONSES {
+		resp := &openaiResponsesResponse{}
+		err = self.call(req, "POST", path, request, resp)
+		if err != nil {
+			return nil, err
+		}
+		result, err = parseResponse(resp, names)
+
+	} else {
+		resp := &openaiChatResponse{}
+		err = self.call(req, "POST", path, request, resp)
+		if err != nil {
+			return nil, err
+		}
+		result, err = parseChatCompletion(resp, names)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	finish(result)
+	return format(result), nil
+}
+
+type openaiChatMessage struct {
+	Role       string                `json:"role"`
+	Content    interface{}           `json:"content"`
+	ToolCalls  []*openaiChatToolCall `json:"tool_calls,omitempty"`
+	ToolCallId string                `json:"tool_call_id,omitempty"`
+}
+
+type openaiChatToolCall struct {
+	Id       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openaiChatUsage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+}
+
+type openaiChatResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      openaiChatMessage `json:"message"`
+		FinishReason string            `json:"finish_reason"`
+	} `json:"choices"`
+	Usage openaiChatUsage `json:"usage"`
+}
+
+func buildChatCompletion(chat_req *ChatRequest,
+	names *toolNames) (*ordereddict.Dict, error) {
+	ids := newToolCallIds()
+	messages := make([]*openaiChatMessage, 0, len(chat_req.Messages))
+	for _, message := range chat_req.Messages {
+		item := &openaiChatMessage{Role: message.Role, Content: message.Content}
+		if len(message.Images) > 0 {
+			content, err := openaiContentParts(message, "text", openaiChatImage)
+			if err != nil {
+				return nil, err
+			}
+			item.Content = content
+		}
+
+		for _, call := range message.ToolCalls {
+			arguments, err := marshalToolArguments(call)
+			if err != nil {
+				return nil, err
+			}
+
+			tool_call := &openaiChatToolCall{
+				Id:   ids.Call(call.Function.Name),
+				Type: "function",
+			}
+			tool_call.Function.Name = names.Provider(call.Function.Name)
+			tool_call.Function.Arguments = string(arguments)
+			item.ToolCalls = append(item.ToolCalls, tool_call)
+		}
+
+		if message.Role == "tool" {
+			item.ToolCallId = ids.Answer(message.ToolName)
+		}
+		messages = append(messages, item)
+	}
+
+	request := ordereddict.NewDict().
+		Set("model", chat_req.Model).
+		Set("messages", messages)
+
+	if len(chat_req.Tools) > 0 {
+		tools, err := renderTools(chat_req.Tools, TOOL_SCHEMA_OPENAI_CHAT, names)
+		if err != nil {
+			return nil, err
+		}
+		request.Set("tools", tools)
+	}
+
+	switch format := chat_req.Format.(type) {
+	case nil:
+	case string:
+		request.Set("response_format", ordereddict.NewDict().
+			Set("type", "json_object"))
+	default:
+		request.Set("response_format", ordereddict.NewDict().
+			Set("type", "json_schema").
+			Set("json_schema", ordereddict.NewDict().
+				Set("name", "response").
+				Set("schema", format)))
+	}
+	setOpenAIOptions(request, chat_req.Options, openai_chat_options)
+
+	return request, nil
+}
+
+func parseChatCompletion(resp *openaiChatResponse,
+	names *toolNames) (*ChatResponse, error) {
+	if len(resp.Choices) == 0 {
+		return nil, &openaiStatusError{status: http.StatusBadGateway,
+			message: "OpenAI server returned no choices"}
+	}
+	choice := resp.Choices[0]
+
+	message := &Message{Role: "assistant"}
+	content, ok := choice.Message.Content.(string)
+	if ok {
+		message.Content = content
+	}
+
+	for _, call := range choice.Message.ToolCalls {
+		tool_call, err := parseOpenAIToolCall(
+			names.Original(call.Function.Name), call.Function.Arguments)
+		if err != nil {
+			return nil, err
+		}
+		message.ToolCalls = append(message.ToolCalls, tool_call)
+	}
+
+	return chatCompletionDone(resp.Model, choice.FinishReason,
+		resp.Usage, message), nil
+}
+
+func chatCompletionDone(model, finish_reason string,
+	usage openaiChatUsage, message *Message) *ChatResponse {
+	result := &ChatResponse{
+		Model:      model,
+		Message:    message,
+		DoneReason: "stop",
+	}
+	if finish_reason == "length" {
+		result.DoneReason = DONE_REASON_LENGTH
+	}
+	result.PromptEvalCount = usage.PromptTokens
+	result.EvalCount = usage.CompletionTokens
+	return result
+}
+
+type openaiResponsesUsage struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+}
+
+type openaiResponsesResponse struct {
+	Model             string `json:"model"`
+	Status            string `json:"status"`
+	IncompleteDetails *struct {
+		Reason string `json:"reason"`
+	} `json:"incomplete_details"`
+	Output []*openaiResponsesItem `json:"output"`
+	Usage  openaiResponsesUsage   `json:"usage"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type openaiResponsesItem struct {
+	Type    string `json:"type"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// The responses API takes the conversation as a list of input items:
+// messages, the tool calls the model made and their outputs.
+func buildResponse(chat_req *ChatRequest,
+	names *toolNames) (*ordereddict.Dict, error) {
+	ids := newToolCallIds()
+	input := []*ordereddict.Dict{}
+	var instructions []string
+
+	for _, message := range chat_req.Messages {
+		switch message.Role {
+		case "system":
+			instructions = append(instructions, message.Content)
+
+		case "tool":
+			input = append(input, ordereddict.NewDict().
+				Set("type", "function_call_output").
+				Set("call_id", ids.Answer(message.ToolName)).
+				Set("output", message.Content))
+
+		default:
+			if len(message.Images) > 0 {
+				content, err := openaiContentParts(
+					message, "input_text", openaiResponsesImage)
+				if err != nil {
+					return nil, err
+				}
+				input = append(input, ordereddict.NewDict().
+					Set("role", message.Role).
+					Set("content", content))
+
+			} else if message.Content != "" || len(message.ToolCalls) == 0 {
+				input = append(input, ordereddict.NewDict().
+					Set("role", message.Role).
+					Set("content", message.Content))
+			}
+
+			for _, call := range message.ToolCalls {
+				arguments, err := marshalToolArguments(call)
+				if err != nil {
+					return nil, err
+				}
+
+				input = append(input, ordereddict.NewDict().
+					Set("type", "function_call").
+					Set("call_id", ids.Call(call.Function.Name)).
+					Set("name", names.Provider(call.Function.Name)).
+					Set("arguments", string(arguments)))
+			}
+		}
+	}
+
+	request := ordereddict.NewDict().
+		Set("model", chat_req.Model).
+		Set("input", input).
+		Set("store", false)
+
+	if len(instructions) > 0 {
+		request.Set("instructions", strings.Join(instructions, "\n\n"))
+	}
+
+	if len(chat_req.Tools) > 0 {
+		tools, err := renderTools(chat_req.Tools,
+			TOOL_SCHEMA_OPENAI_RESPONSES, names)
+		if err != nil {
+			return nil, err
+		}
+		request.Set("tools", tools)
+	}
+
+	switch format := chat_req.Format.(type) {
+	case nil:
+	case string:
+		request.Set("text", ordereddict.NewDict().
+			Set("format", ordereddict.NewDict().Set("type", "json_object")))
+	default:
+		request.Set("text", ordereddict.NewDict().
+			Set("format", ordereddict.NewDict().
+				Set("type", "json_schema").
+				Set("name", "response").
+				Set("schema", format)))
+	}
+	setOpenAIOptions(request, chat_req.Options, openai_responses_options)
+
+	return request, nil
+}
+
+func parseResponse(resp *openaiResponsesResponse,
+	names *toolNames) (*ChatResponse, error) {
+	// Reasoning items are not part of the response.
+	message := &Message{Role: "assistant"}
+	for _, item := range resp.Output {
+		switch item.Type {
+		case "message":
+			for _, content := range item.Content {
+				if content.Type == "output_text" {
+					message.Content += content.Text
+				}
+			}
+
+		case "function_call":
+			tool_call, err := parseOpenAIToolCall(
+				names.Original(item.Name), item.Arguments)
+			if err != nil {
+				return nil, err
+			}
+			message.ToolCalls = append(message.ToolCalls, tool_call)
+		}
+	}
+
+	return responseDone(resp, message), nil
+}
+
+func responseDone(resp *openaiResponsesResponse, message *Message) *ChatResponse {
+	result := &ChatResponse{
+		Model:      resp.Model,
+		Message:    message,
+		DoneReason: "stop",
+	}
+	if resp.Status == "incomplete" && resp.IncompleteDetails != nil &&
+		resp.IncompleteDetails.Reason == "max_output_tokens" {
+		result.DoneReason = DONE_REASON_LENGTH
+	}
+	result.PromptEvalCount = resp.Usage.InputTokens
+	result.EvalCount = resp.Usage.OutputTokens
+	return result
+}
+
+func (self *openaiTransport) embed(req *http.Request,
+	embed_req *EmbedRequest) (*EmbedResponse, error) {
+	resp := &struct {
+		Model string `json:"model"`
+		Data  []struct {
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+		Usage struct {
+			PromptTokens int64 `json:"prompt_tokens"`
+		} `json:"usage"`
+	}{}
+
+	err := self.call(req, "POST", "/embeddings", ordereddict.NewDict().
+		Set("model", embed_req.Model).
+		Set("input", embed_req.Input), resp)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &EmbedResponse{
+		Model:           embed_req.Model,
+		PromptEvalCount: resp.Usage.PromptTokens,
+	}
+	for _, item := range resp.Data {
+		result.Embeddings = append(result.Embeddings, item.Embedding)
+	}
+	return result, nil
+}
+
+func (self *openaiTransport) models(req *http.Request) (*ListResponse, error) {
+	resp := &struct {
+		Data []struct {
+			Id string `json:"id"`
+		} `json:"data"`
+	}{}
+
+	err := self.call(req, "GET", "/models", nil, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ListResponse{}
+	for _, item := range resp.Data {
+		result.Models = append(result.Models,
+			&ModelSummary{Name: item.Id, Model: item.Id})
+	}
+	return result, nil
+}
+
+// Make a call to the server and decode the result.
+func (self *openaiTransport) call(req *http.Request,
+	method, path string, request, result interface{}) error {
+	out, err := self.newRequest(req, method, path, request)
+	if err != nil {
+		return err
+	}
+
+	resp, err := self.send(out)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	serialized, err := io.ReadAll(io.LimitReader(resp.Body, MAX_STREAM_MESSAGE))
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(serialized, result)
+	if err != nil {
+		return &openaiStatusError{status: http.StatusBadGateway,
+			message: fmt.Sprintf("Invalid response from OpenAI server: %v", err)}
+	}
+	return nil
+}
+
+// A request to the server, passing on the headers of the client's
+// request (e.g. the API key).
+func (self *openaiTransport) newRequest(req *http.Request,
+	method, path string, request interface{}) (*http.Request, error) {
+	var body io.Reader
+	if request != nil {
+		serialized, err := json.Marshal(request)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(serialized)
+	}
+
+	target := self.upstream.String() + path
+	if self.query != "" {
+		target += "?" + self.query
+	}
+
+	out, err := http.NewRequestWithContext(req.Context(), method, target, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range req.Header {
+		out.Header[k] = v
+	}
+	out.Header.Set("Content-Type", "application/json")
+	return out, nil
+}
+
+// Send the request, returning the errors the server reports as
+// *openaiStatusError.
+func (self *openaiTransport) send(out *http.Request) (*http.Response, error) {
+	resp, err := self.transport.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &openaiStatusError{status: resp.StatusCode,
+			message: openaiErrorMessage(resp.Status, body)}
+	}
+	return resp, nil
+}
+
+// Errors are reported as {"error": {"message": ...}} but proxies send
+// their own pages.
+func openaiErrorMessage(status string, body []byte) string {
+	error_resp := &struct {
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+
+	if json.Unmarshal(body, error_resp) == nil &&
+		error_resp.Error != nil && error_resp.Error.Message != "" {
+		return error_resp.Error.Message
+	}
+	return fmt.Sprintf("%v: %v", status, elideBody(body))
+}
+
+func (self *openaiTransport) reply(req *http.Request,
+	status int, body interface{}) (*http.Response, error) {
+	serialized, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := newHttpResponse(status, append(serialized, '\n'))
+	resp.Request = req
+	return resp, nil
+}
+
+// Images are sent as content parts after the text of the message.
+func openaiContentParts(message *Message, text_type string,
+	image_part func(url string) *ordereddict.Dict) ([]*ordereddict.Dict, error) {
+	if message.Role != "user" {
+		return nil, &openaiStatusError{status: http.StatusBadRequest,
+			message: fmt.Sprintf(
+				"OpenAI servers only take images in user messages, not %v",
+				message.Role)}
+	}
+
+	result := []*ordereddict.Dict{}
+	if message.Content != "" {
+		result = append(result, ordereddict.NewDict().
+			Set("type", text_type).
+			Set("text", message.Content))
+	}
+
+	for _, image := range message.Images {
+		image_url, err := openaiImageUrl(image)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, image_part(image_url))
+	}
+	return result, nil
+}
+
+func openaiChatImage(image_url string) *ordereddict.Dict {
+	return ordereddict.NewDict().
+		Set("type", "image_url").
+		Set("image_url", ordereddict.NewDict().Set("url", image_url))
+}
+
+func openaiResponsesImage(image_url string) *ordereddict.Dict {
+	return ordereddict.NewDict().
+		Set("type", "input_image").
+		Set("image_url", image_url)
+}
+
+// Ollama takes images as base64 but OpenAI servers as URLs. The base64
+// data is sent as a data URL with the type of the image, while images
+// given as URLs are passed on for the server to fetch.
+func openaiImageUrl(image string) (string, error) {
+	if isImageUrl(image) {
+		return image, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(image)
+	if err != nil {
+		return "", &openaiStatusError{status: http.StatusBadRequest,
+			message: fmt.Sprintf("Invalid image: %v", err)}
+	}
+	return "data:" + http.DetectContentType(data) + ";base64," + image, nil
+}
+
+// Arguments are sent as a JSON encoded string.
+func marshalToolArguments(call *ToolCall) ([]byte, error) {
+	if call.Function.Arguments == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(call.Function.Arguments)
+}
+
+func parseOpenAIToolCall(name, arguments string) (*ToolCall, error) {
+	result := &ToolCall{Function: ToolCallFunction{
+		Name:      name,
+		Arguments: ordereddict.NewDict(),
+	}}
+
+	if strings.TrimSpace(arguments) != "" {
+		err := json.Unmarshal([]byte(arguments), result.Function.Arguments)
+		if err != nil {
+			return nil, &openaiStatusError{status: http.StatusBadGateway,
+				message: fmt.Sprintf("Invalid arguments for tool %v: %v",
+					name, err)}
+		}
+	}
+	return result, nil
+}
+
+func setOpenAIOptions(request, options *ordereddict.Dict,
+	fields map[string]string) {
+	if options == nil {
+		return
+	}
+
+	for _, k := range options.Keys() {
+		field, pres := fields[k]
+		if pres {
+			v, _ := options.Get(k)
+			request.Set(field, v)
+		}
+	}
+}
+
+// Ollama identifies tool results by the tool's name but the OpenAI
+// APIs by the id of the call. Each call is given an id and a result
+// answers the earliest call of its tool not yet answered.
+type toolCallIds struct {
+	next    int
+	pending map[string][]string
+}
+
+func newToolCallIds() *toolCallIds {
+	return &toolCallIds{pending: make(map[string][]string)}
+}
+
+func (self *toolCallIds) Call(name string) string {
+	self.next++
+	id := fmt.Sprintf("call_%d", self.next)
+	self.pending[name] = append(self.pending[name], id)
+	return id
+}
+
+func (self *toolCallIds) Answer(name string) string {
+	pending := self.pending[name]
+	if len(pending) == 0 {
+		self.next++
+		return fmt.Sprintf("call_%d", self.next)
+	}
+
+	self.pending[name] = pending[1:]
+	return pending[0]
+}
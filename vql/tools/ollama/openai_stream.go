@@ -0,0 +1,375 @@
+package ollama
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/velociraptor/utils/sse"
+)
+
+const (
+	// Attempts to continue a stream which was cut off.
+	SSE_MAX_RECONNECTS = 3
+)
+
+// Turns the events of a streamed response into Ollama chunks.
+type openaiStreamDecoder interface {
+	// Returns true once the response is complete.
+	Event(event *sse.Event, cb func(chunk *ChatResponse) error) (bool, error)
+
+	// Whether the stream may end without any further events.
+	Finished() bool
+
+	// The final chunk with the tool calls and statistics.
+	Final() (*ChatResponse, error)
+}
+
+// OpenAI servers stream responses as server sent events. They are
+// relayed to the client as the NDJSON chunks Ollama would send.
+func (self *openaiTransport) stream(req *http.Request, path string,
+	request *ordereddict.Dict, names *toolNames,
+	finish func(result *ChatResponse),
+	format func(chunk *ChatResponse) interface{}) (io.ReadCloser, error) {
+	request.Set("stream", true)
+	if self.api == OPENAI_API_CHAT {
+		// Usage is only sent in a final chunk when asked for.
+		request.Set("stream_options", ordereddict.NewDict().
+			Set("include_usage", true))
+	}
+
+	out, err := self.newStreamRequest(req, path, request, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Errors before the stream starts are returned as the response
+	// status.
+	resp, err := self.send(out)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		err := self.relay(req, path, request, names, resp,
+			func(chunk *ChatResponse) error {
+				if chunk.Done {
+					finish(chunk)
+				}
+
+				serialized, err := json.Marshal(format(chunk))
+				if err != nil {
+					return err
+				}
+				_, err = writer.Write(append(serialized, '\n'))
+				return err
+			})
+
+		// Errors the server reports within the stream are passed
+		// on as Ollama reports them.
+		var status_err *openaiStatusError
+		if errors.As(err, &status_err) {
+			serialized, _ := json.Marshal(
+				&GenerateResponse{Error: status_err.message})
+			writer.Write(append(serialized, '\n'))
+			err = nil
+		}
+		writer.CloseWithError(err)
+	}()
+
+	return reader, nil
+}
+
+func (self *openaiTransport) newStreamRequest(req *http.Request,
+	path string, request *ordereddict.Dict,
+	last_event_id string) (*http.Request, error) {
+	out, err := self.newRequest(req, "POST", path, request)
+	if err != nil {
+		return nil, err
+	}
+
+	out.Header.Set("Accept", "text/event-stream")
+	if last_event_id != "" {
+		out.Header.Set("Last-Event-ID", last_event_id)
+	}
+	return out, nil
+}
+
+// Pass the events of the stream to the decoder. Servers which number
+// their events can continue a stream which was cut off after the
+// last event received.
+func (self *openaiTransport) relay(req *http.Request, path string,
+	request *ordereddict.Dict, names *toolNames, resp *http.Response,
+	cb func(chunk *ChatResponse) error) error {
+	var decoder openaiStreamDecoder = &openaiChatStream{names: names}
+	if self.api == OPENAI_API_RESPONSES {
+		decoder = &openaiResponsesStream{names: names}
+	}
+
+	reader := sse.NewReader(resp.Body, MAX_STREAM_MESSAGE)
+	reconnects := 0
+
+	for {
+		event, err := reader.Next()
+		if err == nil {
+			done, err := decoder.Event(event, cb)
+			if err != nil {
+				resp.Body.Close()
+				return err
+			}
+			if done {
+				resp.Body.Close()
+				return self.finalChunk(decoder, cb)
+			}
+			continue
+		}
+		resp.Body.Close()
+
+		var too_large *sse.MessageTooLargeError
+		if errors.As(err, &too_large) {
+			return &streamError{data: too_large.Data, err: err}
+		}
+
+		if errors.Is(err, io.EOF) {
+			if decoder.Finished() {
+				return self.finalChunk(decoder, cb)
+			}
+			err = io.ErrUnexpectedEOF
+		}
+
+		if req.Context().Err() != nil ||
+			reader.LastId() == "" || reconnects >= SSE_MAX_RECONNECTS {
+			return err
+		}
+		reconnects++
+
+		select {
+		case <-req.Context().Done():
+			return req.Context().Err()
+		case <-utils.GetTime().After(reader.Retry()):
+		}
+
+		out, err := self.newStreamRequest(req, path, request, reader.LastId())
+		if err != nil {
+			return err
+		}
+
+		resp, err = self.send(out)
+		if err != nil {
+			return err
+		}
+		reader.Reset(resp.Body)
+	}
+}
+
+func (self *openaiTransport) finalChunk(decoder openaiStreamDecoder,
+	cb func(chunk *ChatResponse) error) error {
+	final, err := decoder.Final()
+	if err != nil {
+		return err
+	}
+	return cb(final)
+}
+
+func invalidEvent(event *sse.Event, err error) error {
+	return &openaiStatusError{status: http.StatusBadGateway,
+		message: fmt.Sprintf("Invalid event from OpenAI server: %v: %v",
+			err, elideBody(event.Data))}
+}
+
+type openaiChatChunk struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content   *string `json:"content"`
+			ToolCalls []struct {
+				Index    int `json:"index"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openaiChatUsage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Chat completions stream deltas of the message. The name and
+// arguments of tool calls arrive in fragments and the stream ends
+// with a [DONE] event.
+type openaiChatStream struct {
+	names         *toolNames
+	model         string
+	finish_reason string
+	usage         openaiChatUsage
+
+	tool_names     []string
+	tool_arguments []string
+}
+
+func (self *openaiChatStream) Event(event *sse.Event,
+	cb func(chunk *ChatResponse) error) (bool, error) {
+	if string(event.Data) == "[DONE]" {
+		return true, nil
+	}
+
+	chunk := &openaiChatChunk{}
+	err := json.Unmarshal(event.Data, chunk)
+	if err != nil {
+		return false, invalidEvent(event, err)
+	}
+
+	if chunk.Error != nil {
+		return false, &openaiStatusError{status: http.StatusBadGateway,
+			message: chunk.Error.Message}
+	}
+
+	if chunk.Model != "" {
+		self.model = chunk.Model
+	}
+
+	if chunk.Usage != nil {
+		self.usage = *chunk.Usage
+	}
+
+	for _, choice := range chunk.Choices {
+		for _, call := range choice.Delta.ToolCalls {
+			if call.Index < 0 {
+				continue
+			}
+
+			for len(self.tool_names) <= call.Index {
+				self.tool_names = append(self.tool_names, "")
+				self.tool_arguments = append(self.tool_arguments, "")
+			}
+			self.tool_names[call.Index] += call.Function.Name
+			self.tool_arguments[call.Index] += call.Function.Arguments
+		}
+
+		if choice.FinishReason != nil {
+			self.finish_reason = *choice.FinishReason
+		}
+
+		if choice.Delta.Content != nil && *choice.Delta.Content != "" {
+			err := cb(&ChatResponse{Model: self.model,
+				Message: &Message{
+					Role:    "assistant",
+					Content: *choice.Delta.Content,
+				}})
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	return false, nil
+}
+
+func (self *openaiChatStream) Finished() bool {
+	return self.finish_reason != ""
+}
+
+func (self *openaiChatStream) Final() (*ChatResponse, error) {
+	message := &Message{Role: "assistant"}
+	for idx, name := range self.tool_names {
+		tool_call, err := parseOpenAIToolCall(
+			self.names.Original(name), self.tool_arguments[idx])
+		if err != nil {
+			return nil, err
+		}
+		message.ToolCalls = append(message.ToolCalls, tool_call)
+	}
+
+	return chatCompletionDone(self.model, self.finish_reason,
+		self.usage, message), nil
+}
+
+type openaiResponsesEvent struct {
+	Type     string                   `json:"type"`
+	Delta    string                   `json:"delta"`
+	Message  string                   `json:"message"`
+	Item     *openaiResponsesItem     `json:"item"`
+	Response *openaiResponsesResponse `json:"response"`
+}
+
+// The responses API streams typed events. Text arrives as deltas,
+// each tool call once it is complete and the usage with the final
+// response.
+type openaiResponsesStream struct {
+	names      *toolNames
+	tool_calls []*ToolCall
+	response   *openaiResponsesResponse
+}
+
+func (self *openaiResponsesStream) Event(event *sse.Event,
+	cb func(chunk *ChatResponse) error) (bool, error) {
+	if string(event.Data) == "[DONE]" {
+		return self.response != nil, nil
+	}
+
+	item := &openaiResponsesEvent{}
+	err := json.Unmarshal(event.Data, item)
+	if err != nil {
+		return false, invalidEvent(event, err)
+	}
+
+	switch item.Type {
+	case "response.output_text.delta":
+		if item.Delta != "" {
+			err := cb(&ChatResponse{Message: &Message{
+				Role:    "assistant",
+				Content: item.Delta,
+			}})
+			if err != nil {
+				return false, err
+			}
+		}
+
+	case "response.output_item.done":
+		if item.Item != nil && item.Item.Type == "function_call" {
+			tool_call, err := parseOpenAIToolCall(
+				self.names.Original(item.Item.Name), item.Item.Arguments)
+			if err != nil {
+				return false, err
+			}
+			self.tool_calls = append(self.tool_calls, tool_call)
+		}
+
+	case "response.completed", "response.incomplete":
+		if item.Response == nil {
+			return false, invalidEvent(event, errors.New("no response"))
+		}
+		self.response = item.Response
+		return true, nil
+
+	case "response.failed":
+		message := "The response failed"
+		if item.Response != nil && item.Response.Error != nil {
+			message = item.Response.Error.Message
+		}
+		return false, &openaiStatusError{status: http.StatusBadGateway,
+			message: message}
+
+	case "error":
+		return false, &openaiStatusError{status: http.StatusBadGateway,
+			message: item.Message}
+	}
+	return false, nil
+}
+
+func (self *openaiResponsesStream) Finished() bool {
+	return self.response != nil
+}
+
+func (self *openaiResponsesStream) Final() (*ChatResponse, error) {
+	message := &Message{Role: "assistant", ToolCalls: self.tool_calls}
+	return responseDone(self.response, message), nil
+}
@@ -0,0 +1,20 @@
+package ollama
+
+import (
+	"github.com/Velocidex/ordereddict"
+)
+
+// A copy of the model options with the option set. Options given by
+// the caller are not changed since the same dict may be used for
+// other calls.
+func withOption(options *ordereddict.Dict,
+	key string, value interface{}) *ordereddict.Dict {
+	result := ordereddict.NewDict()
+	if options != nil {
+		for _, k := range options.Keys() {
+			v, _ := options.Get(k)
+			result.Set(k, v)
+		}
+	}
+	return result.Set(key, value)
+}
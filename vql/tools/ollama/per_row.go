@@ -0,0 +1,175 @@
+package ollama
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+)
+
+// Make one model call for each query row, taking the prompt from a
+// column of the row. The other columns are included after the
+// prompt, and the prompt argument (if any) comes first as common
+// instructions. A failed call is reported in its row and the
+// remaining rows are still processed.
+func runPerRow(ctx context.Context, scope vfilter.Scope,
+	arg *OllamaPluginArgs, client *Client, guardrails *Guardrails,
+	validator *responseValidator, digest string,
+	output_chan chan vfilter.Row) error {
+	generate := slowCallGenerate(scope, newSlowCallThresholds(arg),
+		arg.SlowCallRows, logGenerate(scope, client,
+			maxTokensGenerate(arg.MaxTokens,
+				resumeGenerate(scope, client, arg.MaxResumes))), output_chan)
+	config_obj, ok := vql_subsystem.GetServerConfig(scope)
+	if ok && !arg.CacheBypass {
+		cache := GetResponseCache(config_obj)
+		uncached := generate
+		generate = func(ctx context.Context, req *GenerateRequest,
+			cb func(resp *GenerateResponse) error) error {
+			return cache.Generate(ctx, client, req, cb, uncached)
+		}
+	}
+
+	generate, err := cassetteGenerate(scope, arg.Cassette,
+		arg.CassetteMode, generate)
+	if err != nil {
+		return err
+	}
+
+	if arg.Debug {
+		generate = debugGenerate(client, generate, output_chan)
+	}
+
+	for row := range arg.Query.Eval(ctx, scope) {
+		row_dict := promptRow(ctx, scope, row)
+		question_any, pres := row_dict.Get(arg.PromptColumn)
+		question := cleanText(utils.ToString(question_any))
+		if !pres || question == "" {
+			scope.Log("ollama: Row has no %v column, skipping", arg.PromptColumn)
+			continue
+		}
+		row_dict.Delete(arg.PromptColumn)
+
+		encoder := newRowEncoder(arg)
+		added, err := encoder.Add(row_dict)
+		if err != nil {
+			return err
+		}
+		if !added {
+			scope.Log("ollama: Row larger than max_bytes left out of the prompt")
+		}
+
+		// The row's values are known once it is added so are also
+		// replaced in the question.
+		prompt_question := question
+		if arg.pseudonymizer != nil {
+			prompt_question = arg.pseudonymizer.Text(question)
+		}
+
+		prompt := []string{prompt_question}
+		if arg.Prompt != "" {
+			prompt = []string{arg.Prompt, prompt_question}
+		}
+		if added && row_dict.Len() > 0 {
+			prompt = append(prompt,
+				strings.TrimSuffix(encoder.Serialize(encoder.rows), "\n"))
+		}
+
+		req := &GenerateRequest{
+			Model:     arg.Model,
+			Prompt:    strings.Join(prompt, "\n\n"),
+			System:    arg.System,
+			Format:    arg.Format,
+			Options:   arg.Options,
+			KeepAlive: arg.KeepAlive,
+			Logprobs:  arg.Confidence,
+		}
+
+		var choice *modelChoice
+		row_digest := digest
+		if arg.selector != nil {
+			choice = arg.selector.Select(req, "")
+			if arg.Deterministic {
+				row_digest, err = arg.selector.Digest(ctx, client, req.Model)
+				if err != nil {
+					scope.Log("ollama: %v", err)
+				}
+			}
+		}
+
+		response := &strings.Builder{}
+		var final *GenerateResponse
+		confidence := &confidenceTracker{}
+		err = generate(ctx, req, func(chunk *GenerateResponse) error {
+			response.WriteString(chunk.Response)
+			confidence.Add(chunk.Logprobs)
+			if chunk.Done {
+				final = chunk
+			}
+			return nil
+		})
+
+		var validation *validationResult
+		if err == nil && validator != nil {
+			validation, err = validator.Repair(ctx, scope, generate, req,
+				response.String(), final, arg.RepairAttempts)
+		}
+
+		var result *ordereddict.Dict
+		if err != nil {
+			scope.Log("ollama: %v", err)
+			result = errRow(req.Model, err).Set("Prompt", question)
+
+		} else {
+			text := response.String()
+			if validation != nil {
+				text = validation.Response
+				final = validation.Final
+				if validation.Attempts > 1 {
+					confidence = &confidenceTracker{}
+					confidence.Add(validation.Logprobs)
+				}
+			}
+
+			result = ordereddict.NewDict().
+				Set("Model", req.Model).
+				Set("Prompt", question).
+				Set("Response", formatResponse(arg.ResponseFormat, text))
+			if final != nil {
+				final.Stats.SetColumns(result)
+			}
+			setResponseTruncated(result, arg.MaxTokens, final)
+			if arg.Confidence {
+				confidence.SetColumn(scope, result)
+			}
+			guardrails.Filter(scope, "ollama", result, "Response")
+			if validation != nil {
+				validation.SetColumns(scope, result, arg.Parse)
+			}
+		}
+
+		if arg.IncludeInput && len(encoder.inputs) > 0 {
+			result.Set("Input", encoder.inputs[0])
+		}
+		if arg.pseudonymizer != nil {
+			result.Set("Pseudonyms", arg.pseudonymizer.Pseudonyms())
+		}
+		if arg.Deterministic {
+			result.Set("Digest", row_digest)
+		}
+		if choice != nil {
+			result.Set("ModelSelection", choice.Dict())
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case output_chan <- renameColumn(result, "Response", arg.OutputColumn):
+		}
+	}
+
+	return nil
+}
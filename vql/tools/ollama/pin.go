@@ -0,0 +1,88 @@
+package ollama
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	PINNED_DIGEST_PREFIX = "@sha256:"
+
+	// The shortest digest prefix accepted, the same length as the ID
+	// shown by ollama list.
+	MIN_PINNED_DIGEST = 12
+)
+
+// Models may be pinned to a digest as name@sha256:digest so
+// conclusions can be reproduced with the same weights. The digest
+// is the one the server lists the model with (the Digest column of
+// deterministic calls) and may be shortened to the ID shown by ollama
+// list. Calls fail if the installed model has a different digest.
+type pinCache struct {
+	mu sync.Mutex
+
+	// Pinned models already checked against the server.
+	verified map[string]bool
+}
+
+func newPinCache() *pinCache {
+	return &pinCache{verified: make(map[string]bool)}
+}
+
+// Split a pinned model into its name and digest. The digest is empty
+// if the model is not pinned.
+func splitPinnedModel(model string) (name, digest string) {
+	idx := strings.Index(model, PINNED_DIGEST_PREFIX)
+	if idx < 0 {
+		return model, ""
+	}
+	return model[:idx], strings.ToLower(model[idx+len(PINNED_DIGEST_PREFIX):])
+}
+
+// Verify a pinned model and return the name to send to the server.
+// Models which are not pinned are returned as they are.
+func (self *Client) checkPin(ctx context.Context,
+	path, model string) (string, error) {
+	name, digest := splitPinnedModel(model)
+	if digest == "" {
+		return model, nil
+	}
+
+	endpoint := self.base_url + path
+	_, err := hex.DecodeString(digest)
+	if err != nil || len(digest) < MIN_PINNED_DIGEST || len(digest)%2 != 0 {
+		return "", &Error{Code: ERROR_BAD_REQUEST, Endpoint: endpoint,
+			Err: fmt.Errorf("Model %v is not pinned to a valid sha256 digest", model)}
+	}
+
+	self.pins.mu.Lock()
+	defer self.pins.mu.Unlock()
+
+	if self.pins.verified[model] {
+		return name, nil
+	}
+
+	list, err := self.List(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	item := findModel(list.Models, name)
+	if item == nil {
+		return "", &Error{Code: ERROR_MODEL_NOT_FOUND, Endpoint: endpoint,
+			Err: fmt.Errorf("Pinned model %v is not installed", name)}
+	}
+
+	installed := strings.TrimPrefix(strings.ToLower(item.Digest), "sha256:")
+	if !strings.HasPrefix(installed, digest) {
+		return "", &Error{Code: ERROR_DIGEST_MISMATCH, Endpoint: endpoint,
+			Err: fmt.Errorf("Model %v has digest %v not the pinned %v",
+				name, installed, digest)}
+	}
+
+	self.pins.verified[model] = true
+	return name, nil
+}
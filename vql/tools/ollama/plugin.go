@@ -0,0 +1,594 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/accessors"
+	"www.velocidex.com/golang/velociraptor/acls"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+type OllamaPluginArgs struct {
+	Model              string              `vfilter:"optional,field=model,doc=The model to use (e.g. llama3). Pin it to a digest with llama3@sha256:<digest> to fail unless the installed model matches. Defaults to a model picked from AI.model_pool, or AI.default_model in the config."`
+	Task               string              `vfilter:"optional,field=task,doc=The kind of task (e.g. classification or summarization). Only models in AI.model_pool handling the task are picked when model is not given."`
+	ContextWindow      int64               `vfilter:"optional,field=context_window,doc=The context window in tokens the call needs. Models in AI.model_pool with a smaller window are not picked."`
+	Prompt             string              `vfilter:"optional,field=prompt,doc=The prompt to send to the model. Required unless prompt_path or prompt_column is given."`
+	PromptPath         vfilter.Any         `vfilter:"optional,field=prompt_path,doc=Read the prompt from this file. If prompt is also given it is added after the file's prompt."`
+	PromptAccessor     string              `vfilter:"optional,field=prompt_accessor,doc=The accessor used to read prompt_path (default auto)."`
+	PromptColumn       string              `vfilter:"optional,field=prompt_column,doc=Make a call for each query row with the prompt taken from this column. The prompt argument is added before it as instructions."`
+	Examples           []*ordereddict.Dict `vfilter:"optional,field=examples,doc=Example inputs with the expected outputs, shown to the model before the prompt. Each is a dict with input and output."`
+	System             string              `vfilter:"optional,field=system,doc=A system prompt."`
+	Query              vfilter.StoredQuery `vfilter:"optional,field=query,doc=Rows from this query are appended to the prompt as JSON."`
+	File               *accessors.OSPath   `vfilter:"optional,field=file,doc=Append the content of this file to the prompt."`
+	Accessor           string              `vfilter:"optional,field=accessor,doc=The accessor used to read file (default auto)."`
+	Offset             int64               `vfilter:"optional,field=offset,doc=Read file from this offset."`
+	Length             int64               `vfilter:"optional,field=length,doc=The most bytes of file to include (default 64kb, at most 1mb)."`
+	ExtractText        bool                `vfilter:"optional,field=extract_text,doc=Include the text of a PDF, DOCX or XLSX file rather than its raw content. Documents up to 16mb are read."`
+	MaxRows            int64               `vfilter:"optional,field=max_rows,doc=The maximum number of rows to include from the query (default 100, -1 for all rows)."`
+	MaxBytes           int64               `vfilter:"optional,field=max_bytes,doc=The maximum size of the query rows included in the prompt (default no limit)."`
+	IncludeInput       bool                `vfilter:"optional,field=include_input,doc=Include the query rows in the Input column as they were before binary data, terminal escapes and bidi characters were removed for the prompt."`
+	Sample             string              `vfilter:"optional,field=sample,doc=Which rows are sent when the query returns more than max_rows: head (the first rows, the default), tail, random or stratified(column) which includes rows with each value of the column. All but head read the whole query."`
+	Strict             bool                `vfilter:"optional,field=strict,doc=Fail rather than leave out query rows which exceed max_rows, max_bytes or (with truncate) the context window."`
+	ChunkSize          int64               `vfilter:"optional,field=chunk_size,doc=Send the query rows in chunks of this many rows, with one model call and output row per chunk. All rows of the query are processed."`
+	Indent             bool                `vfilter:"optional,field=indent,doc=Indent the JSON of the query rows. This uses more tokens."`
+	Serialization      string              `vfilter:"optional,field=serialization,doc=How the query rows are written in the prompt: json (the default, one row per line), csv, yaml or markdown_table. Tables name the columns once so use far fewer tokens for wide rows."`
+	Flatten            bool                `vfilter:"optional,field=flatten,doc=Replace nested dicts and arrays in the query rows with columns named by their path (e.g. Process.Parent.Name), which smaller models follow more reliably."`
+	BinaryEncoding     string              `vfilter:"optional,field=binary_encoding,doc=How binary or non UTF8 values in the query rows are included: hex (default), base64 or omit."`
+	MaxFieldLen        int64               `vfilter:"optional,field=max_field_len,doc=Truncate strings in the query rows longer than this many bytes, marking how much was removed."`
+	MaxBinaryBytes     int64               `vfilter:"optional,field=max_binary_bytes,doc=The most bytes of each binary value to include (default 256)."`
+	Truncate           string              `vfilter:"optional,field=truncate,doc=If the prompt would exceed the model's context window, keep the query rows at the head or tail, drop rows from the middle, or summarize them."`
+	BaseUrl            string              `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server. Several comma separated URLs, or srv://name for the servers of a DNS SRV record, spread the calls over the servers."`
+	ConnectTimeout     int64               `vfilter:"optional,field=connect_timeout,doc=Seconds to wait for a connection to the server (default 10)."`
+	FirstTokenTimeout  int64               `vfilter:"optional,field=first_token_timeout,doc=Seconds to wait for the model to start responding (default 300)."`
+	Timeout            int64               `vfilter:"optional,field=timeout,doc=Seconds the whole call may take (default 3600)."`
+	CircuitFailures    int64               `vfilter:"optional,field=circuit_failures,doc=After this many consecutive failed calls to the server, fail immediately until the cooldown passes (default 5, -1 to disable)."`
+	CircuitCooldown    int64               `vfilter:"optional,field=circuit_cooldown,doc=Seconds to fail immediately once the circuit opens (default 60)."`
+	MaxTokens          int64               `vfilter:"optional,field=max_tokens,doc=The most tokens the response may have. Longer responses are cut off and marked in the ResponseTruncated column."`
+	Stop               []string            `vfilter:"optional,field=stop,doc=Stop generating when the model produces one of these strings. The stop string is not included in the response."`
+	Options            *ordereddict.Dict   `vfilter:"optional,field=options,doc=Model options (e.g. temperature, num_ctx)."`
+	Format             vfilter.Any         `vfilter:"optional,field=format,doc=Either 'json' or a JSON schema to constrain the response."`
+	Parse              bool                `vfilter:"optional,field=parse,doc=Parse the response as JSON into the Parsed column. Implies format='json' if no format is given."`
+	ResponseFormat     string              `vfilter:"optional,field=response_format,doc=How the response is post processed: text (the default), markdown (sanitized for notebooks), json (parsed into the Parsed column like parse) or csv (a row per record)."`
+	Confidence         bool                `vfilter:"optional,field=confidence,doc=Ask the server for token log probabilities and return their geometric mean (0 to 1) in the Confidence column, so low confidence responses can be reviewed. Null if the server does not support logprobs."`
+	RepairAttempts     int64               `vfilter:"optional,field=repair_attempts,doc=When the response should be JSON (or match a schema) but is not, ask the model to correct it this many times (default 2)."`
+	SlowCallSeconds    int64               `vfilter:"optional,field=slow_call_seconds,doc=Warn when a single model call takes longer than this many seconds (default 120, -1 to disable)."`
+	SlowCallTokens     int64               `vfilter:"optional,field=slow_call_tokens,doc=Warn when a single model call uses more than this many prompt and response tokens."`
+	SlowCallRows       bool                `vfilter:"optional,field=slow_call_rows,doc=Also emit a row with a SlowCall column for each slow call."`
+	Debug              bool                `vfilter:"optional,field=debug,doc=Emit a row describing each request before it is sent, with the prompt, options, endpoint, size and headers (secrets masked)."`
+	Deterministic      bool                `vfilter:"optional,field=deterministic,doc=Pin the seed, temperature and top_k so the same prompt gives the same response. The digest of the model is recorded in the output."`
+	KeepAlive          string              `vfilter:"optional,field=keep_alive,doc=How long the model stays loaded after the call (e.g. 5m)."`
+	Session            string              `vfilter:"optional,field=session,doc=The name of a conversation to continue. The exchange is stored on the server so later calls with the same session can ask follow up questions."`
+	OutputColumn       string              `vfilter:"optional,field=output_column,doc=The name of the column holding the response (default Response)."`
+	Stream             bool                `vfilter:"optional,field=stream,doc=Emit a row for each chunk of the response as it is generated."`
+	CacheBypass        bool                `vfilter:"optional,field=cache_bypass,doc=Always call the model rather than reusing a cached response."`
+	Cassette           string              `vfilter:"optional,field=cassette,doc=A file of recorded responses. Requests recorded in it are answered from the file and the rest are sent to the model and recorded, so demos and tests of prompts give the same results every run."`
+	CassetteMode       string              `vfilter:"optional,field=cassette_mode,doc=auto (the default) replays recorded responses and records the rest, record replaces the cassette and replay fails requests which were not recorded."`
+	MaxResumes         int64               `vfilter:"optional,field=max_resumes,doc=How many times a response interrupted by a dropped connection is continued (default 2, 0 to not continue)."`
+	Checkpoint         string              `vfilter:"optional,field=checkpoint,doc=A name under which the response is periodically saved for the user. When a query with the same name and request runs again a completed response is reused and a partial one continued."`
+	CheckpointInterval int64               `vfilter:"optional,field=checkpoint_interval,doc=Seconds between checkpoints (default 30)."`
+	Guardrails         []*ordereddict.Dict `vfilter:"optional,field=guardrails,doc=Rules flagging dangerous content in the response. Each is a dict with name, regex and action (annotate, block or ignore). Built in rules: shell_command, secret and url."`
+	Pseudonymize       bool                `vfilter:"optional,field=pseudonymize,doc=Replace host names, user names and IP addresses in the query rows with placeholders (e.g. HOST_1, USER_3) before they are sent. The Pseudonyms column maps them back - see ollama_depseudonymize()."`
+	PseudonymColumns   *ordereddict.Dict   `vfilter:"optional,field=pseudonymize_columns,doc=Extra columns to pseudonymize, as a dict of column name to placeholder kind (e.g. dict(Email='EMAIL'))."`
+	Pseudonyms         *ordereddict.Dict   `vfilter:"optional,field=pseudonyms,doc=The Pseudonyms column of an earlier call, so the same values get the same placeholders."`
+
+	// Shared by all the encoders of the call so values keep their
+	// placeholders across chunks.
+	pseudonymizer *pseudonymizer
+
+	// Picks the model of each call from AI.model_pool when no model
+	// is given.
+	selector *modelSelector
+}
+
+type OllamaPlugin struct{}
+
+func (self OllamaPlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("ollama", args)()
+
+		err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+		if err != nil {
+			scope.Log("ollama: %v", err)
+			return
+		}
+
+		arg := &OllamaPluginArgs{}
+		err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+		if err != nil {
+			scope.Log("ollama: %v", err)
+			return
+		}
+
+		arg.Prompt, err = loadPrompt(ctx, scope, arg.PromptPath,
+			arg.PromptAccessor, arg.Prompt)
+		if err != nil {
+			scope.Log("ollama: %v", err)
+			return
+		}
+
+		if arg.Prompt == "" && arg.PromptColumn == "" {
+			scope.Log("ollama: One of prompt, prompt_path or prompt_column must be given")
+			return
+		}
+
+		arg.Prompt, err = appendFile(ctx, scope, arg)
+		if err != nil {
+			scope.Log("ollama: %v", err)
+			return
+		}
+
+		if arg.MaxRows == 0 {
+			arg.MaxRows = 100
+		}
+
+		err = validateBinaryEncoding(arg.BinaryEncoding)
+		if err != nil {
+			scope.Log("ollama: %v", err)
+			return
+		}
+
+		err = validateSerialization(arg.Serialization)
+		if err != nil {
+			scope.Log("ollama: %v", err)
+			return
+		}
+
+		err = validateResponseFormat(arg.ResponseFormat)
+		if err != nil {
+			scope.Log("ollama: %v", err)
+			return
+		}
+
+		if arg.ResponseFormat == RESPONSE_FORMAT_JSON {
+			arg.Parse = true
+		}
+
+		// Chunks are emitted as they arrive so can not be post processed.
+		if arg.Stream && (arg.ResponseFormat == RESPONSE_FORMAT_MARKDOWN ||
+			arg.ResponseFormat == RESPONSE_FORMAT_CSV) {
+			scope.Log("ollama: response_format %v can not be used with stream",
+				arg.ResponseFormat)
+			return
+		}
+
+		if arg.Sample != "" && (arg.Strict || arg.ChunkSize > 0 ||
+			arg.PromptColumn != "") {
+			scope.Log("ollama: sample can not be used with strict, chunk_size or prompt_column")
+			return
+		}
+
+		_, err = parseSample(arg.Sample)
+		if err != nil {
+			scope.Log("ollama: %v", err)
+			return
+		}
+
+		if arg.ResponseFormat == RESPONSE_FORMAT_CSV &&
+			(arg.ChunkSize > 0 || arg.PromptColumn != "") {
+			scope.Log("ollama: response_format csv can not be used with chunk_size or prompt_column")
+			return
+		}
+
+		if arg.Pseudonymize {
+			arg.pseudonymizer, err = newPseudonymizer(
+				arg.PseudonymColumns, arg.Pseudonyms)
+			if err != nil {
+				scope.Log("ollama: %v", err)
+				return
+			}
+		}
+
+		if arg.Parse && arg.Format == nil {
+			arg.Format = "json"
+		}
+
+		arg.MaxResumes = getMaxResumes(args, arg.MaxResumes)
+
+		if arg.RepairAttempts == 0 {
+			arg.RepairAttempts = DEFAULT_REPAIR_ATTEMPTS
+		}
+
+		validator, err := newResponseValidator(arg.Format, arg.Parse)
+		if err != nil {
+			scope.Log("ollama: %v", err)
+			return
+		}
+
+		guardrails, err := parseGuardrails(ctx, scope, arg.Guardrails)
+		if err != nil {
+			scope.Log("ollama: %v", err)
+			return
+		}
+
+		examples, err := parseExamples(ctx, scope, arg.Examples)
+		if err != nil {
+			scope.Log("ollama: %v", err)
+			return
+		}
+		user_prompt := arg.Prompt
+		arg.Prompt = formatExamples(examples) + arg.Prompt +
+			response_format_hints[arg.ResponseFormat]
+
+		client, err := NewClient(ctx, scope, arg.BaseUrl)
+		if err != nil {
+			scope.Log("ollama: %v", err)
+			return
+		}
+
+		arg.selector, err = client.newModelSelector(arg.Model, arg.Task,
+			arg.ContextWindow)
+		if err != nil {
+			scope.Log("ollama: %v", err)
+			return
+		}
+
+		// Prompts are built for the largest model in the pool then
+		// each call is sent to the smallest model it fits.
+		if arg.selector != nil {
+			arg.Model = arg.selector.Largest()
+		}
+
+		arg.Model, err = client.Model(arg.Model)
+		if err != nil {
+			scope.Log("ollama: %v", err)
+			return
+		}
+
+		client = client.WithTimeouts(Timeouts{
+			Connect:    time.Duration(arg.ConnectTimeout) * time.Second,
+			FirstToken: time.Duration(arg.FirstTokenTimeout) * time.Second,
+			Total:      time.Duration(arg.Timeout) * time.Second,
+		}).WithCircuitBreaker(CircuitBreaker{
+			Failures: arg.CircuitFailures,
+			Cooldown: time.Duration(arg.CircuitCooldown) * time.Second,
+		})
+
+		// Sessions are stored for each user.
+		if arg.Session != "" {
+			client = client.WithSession(
+				vql_subsystem.GetPrincipal(scope) + "/" + arg.Session)
+		}
+
+		if len(arg.Stop) > 0 {
+			arg.Options = withOption(arg.Options, "stop", arg.Stop)
+		}
+
+		if arg.MaxTokens > 0 {
+			arg.Options = withOption(arg.Options, "num_predict", arg.MaxTokens)
+		}
+
+		// The digest shows a later review used the same model.
+		var digest string
+		if arg.Deterministic {
+			arg.Options = deterministicOptions(arg.Options)
+			digest, err = client.ModelDigest(ctx, arg.Model)
+			if err != nil {
+				scope.Log("ollama: %v", err)
+			}
+		}
+
+		if arg.PromptColumn != "" {
+			if arg.Query == nil || arg.ChunkSize > 0 ||
+				arg.Session != "" || arg.Stream {
+				scope.Log("ollama: prompt_column requires a query and can not be used with chunk_size, session or stream")
+				return
+			}
+
+			err = runPerRow(ctx, scope, arg, client, guardrails,
+				validator, digest, output_chan)
+			if err != nil {
+				scope.Log("ollama: %v", err)
+			}
+			return
+		}
+
+		if arg.ChunkSize > 0 {
+			if arg.Query == nil || arg.Session != "" || arg.Stream {
+				scope.Log("ollama: chunk_size requires a query and can not be used with session or stream")
+				return
+			}
+
+			err = runChunked(ctx, scope, arg, client, guardrails,
+				validator, digest, output_chan)
+			if err != nil {
+				scope.Log("ollama: %v", err)
+			}
+			return
+		}
+
+		var session *Session
+		req := &GenerateRequest{
+			Model:     arg.Model,
+			Prompt:    arg.Prompt,
+			System:    arg.System,
+			Format:    arg.Format,
+			Options:   arg.Options,
+			KeepAlive: arg.KeepAlive,
+			Stream:    true,
+			Logprobs:  arg.Confidence,
+		}
+
+		if arg.Session != "" {
+			session, err = LoadSession(ctx, scope, arg.Session)
+			if err != nil {
+				scope.Log("ollama: %v", err)
+				return
+			}
+			req.Context = session.Context
+		}
+
+		var truncated bool
+		var encoder *rowEncoder
+		if arg.Query != nil {
+			encoder = newRowEncoder(arg)
+			truncated, err = buildPrompt(ctx, scope, arg, client, req, encoder)
+			if err != nil {
+				scope.Log("ollama: %v", err)
+				return
+			}
+		}
+
+		var choice *modelChoice
+		if arg.selector != nil {
+			choice = arg.selector.Select(req, "")
+			arg.Model = choice.Model
+			if arg.Deterministic {
+				digest, err = arg.selector.Digest(ctx, client, arg.Model)
+				if err != nil {
+					scope.Log("ollama: %v", err)
+				}
+			}
+		}
+
+		generate := resumeGenerate(scope, client, arg.MaxResumes)
+		if arg.Checkpoint != "" {
+			if arg.CheckpointInterval == 0 {
+				arg.CheckpointInterval = 30
+			}
+
+			checkpoint, err := OpenCheckpoint(scope, arg.Checkpoint, req,
+				time.Duration(arg.CheckpointInterval)*time.Second)
+			if err != nil {
+				scope.Log("ollama: %v", err)
+				return
+			}
+
+			generate = func(ctx context.Context, req *GenerateRequest,
+				cb func(resp *GenerateResponse) error) error {
+				return checkpoint.Generate(ctx, client, req, cb)
+			}
+		}
+		generate = maxTokensGenerate(arg.MaxTokens, generate)
+		generate = slowCallGenerate(scope, newSlowCallThresholds(arg),
+			arg.SlowCallRows, logGenerate(scope, client, generate), output_chan)
+
+		// Conversations continue on the server so are not cached.
+		config_obj, ok := vql_subsystem.GetServerConfig(scope)
+		if ok && !arg.CacheBypass && session == nil {
+			cache := GetResponseCache(config_obj)
+			uncached := generate
+			generate = func(ctx context.Context, req *GenerateRequest,
+				cb func(resp *GenerateResponse) error) error {
+				return cache.Generate(ctx, client, req, cb, uncached)
+			}
+		}
+
+		generate, err = cassetteGenerate(scope, arg.Cassette,
+			arg.CassetteMode, generate)
+		if err != nil {
+			scope.Log("ollama: %v", err)
+			return
+		}
+
+		if arg.Debug {
+			generate = debugGenerate(client, generate, output_chan)
+		}
+
+		response := &strings.Builder{}
+		var final *GenerateResponse
+		confidence := &confidenceTracker{}
+		stream := guardrails.NewStream()
+
+		// The callback runs before the next chunk is read from the
+		// connection so a slow consumer of streamed rows slows down
+		// reading instead of buffering the response.
+		err = generate(ctx, req, func(chunk *GenerateResponse) error {
+			response.WriteString(chunk.Response)
+			confidence.Add(chunk.Logprobs)
+			if chunk.Done {
+				final = chunk
+				return nil
+			}
+
+			if !arg.Stream {
+				return nil
+			}
+
+			content, err := stream.Write(chunk.Response)
+			if err != nil || content == "" {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case output_chan <- renameColumn(ordereddict.NewDict().
+				Set("Model", arg.Model).
+				Set("Response", content).
+				Set("Done", false), "Response", arg.OutputColumn):
+			}
+			return nil
+		})
+
+		// A blocked stream ends with the same row as a blocked
+		// response which was not streamed.
+		var blocked *GuardrailError
+		if errors.As(err, &blocked) {
+			scope.Log("ollama: %v", blocked)
+			err = nil
+		}
+
+		// Streamed chunks were already emitted so can not be
+		// repaired, only checked.
+		var validation *validationResult
+		if err == nil && blocked == nil && validator != nil {
+			max_attempts := arg.RepairAttempts
+			if arg.Stream {
+				max_attempts = 0
+			}
+			validation, err = validator.Repair(ctx, scope, generate, req,
+				response.String(), final, max_attempts)
+		}
+
+		if err != nil {
+			scope.Log("ollama: %v", err)
+
+			// Failures of the model call are emitted so the query
+			// can see why there is no response.
+			var llm_err *Error
+			if errors.As(err, &llm_err) {
+				select {
+				case <-ctx.Done():
+				case output_chan <- renameColumn(errRow(arg.Model, err),
+					"Response", arg.OutputColumn):
+				}
+			}
+			return
+		}
+
+		text := response.String()
+		if validation != nil {
+			text = validation.Response
+			final = validation.Final
+			if validation.Attempts > 1 {
+				confidence = &confidenceTracker{}
+				confidence.Add(validation.Logprobs)
+			}
+		}
+
+		if blocked != nil {
+			text = blocked.Response
+		} else {
+			text = formatResponse(arg.ResponseFormat, text)
+		}
+
+		row := ordereddict.NewDict().
+			Set("Model", arg.Model).
+			Set("Response", text)
+		guardrails.Filter(scope, "ollama", row, "Response")
+		if blocked != nil {
+			row.Set("Flags", blocked.Flags)
+		}
+		filtered, _ := row.GetString("Response")
+
+		if final != nil {
+			final.Stats.SetColumns(row)
+		}
+		setResponseTruncated(row, arg.MaxTokens, final)
+
+		if arg.Confidence {
+			confidence.SetColumn(scope, row)
+		}
+
+		if arg.Query != nil {
+			row.Set("Truncated", truncated)
+			if arg.Sample != "" && arg.Sample != SAMPLE_HEAD {
+				row.Set("SampledRows", len(encoder.rows)).
+					Set("QueryRows", encoder.query_rows)
+			}
+			if arg.IncludeInput {
+				row.Set("Input", encoder.inputs)
+			}
+		}
+
+		if arg.pseudonymizer != nil {
+			row.Set("Pseudonyms", arg.pseudonymizer.Pseudonyms())
+		}
+
+		if arg.Deterministic {
+			row.Set("Digest", digest)
+		}
+
+		if choice != nil {
+			row.Set("ModelSelection", choice.Dict())
+		}
+
+		if validation != nil {
+			validation.SetColumns(scope, row, arg.Parse)
+		}
+
+		if arg.Stream {
+			// Earlier chunks were already emitted and the rest was
+			// held back for the guardrails.
+			if final != nil && filtered == text {
+				row.Update("Response", stream.Flush()+final.Response)
+			}
+			row.Set("Done", true)
+		}
+
+		if session != nil {
+			var llm_context []int64
+			if final != nil {
+				llm_context = final.Context
+			}
+
+			// Store the prompt the user typed rather than the
+			// expanded rows - the context array already encodes them.
+			err = session.Append(arg.Model, user_prompt, filtered, llm_context)
+			if err != nil {
+				scope.Log("ollama: storing session: %v", err)
+			}
+			row.Set("Session", arg.Session)
+		}
+
+		if arg.ResponseFormat == RESPONSE_FORMAT_CSV {
+			records, err := parseCSVResponse(filtered)
+			if err == nil {
+				for _, record := range records {
+					select {
+					case <-ctx.Done():
+						return
+					case output_chan <- record:
+					}
+				}
+				return
+			}
+
+			// Emit the response so the query can see what went wrong.
+			scope.Log("ollama: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case output_chan <- renameColumn(row, "Response", arg.OutputColumn):
+		}
+	}()
+
+	return output_chan
+}
+
+func (self OllamaPlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name:     "ollama",
+		Doc:      "Send a prompt (optionally with the rows of a query) to an Ollama model.",
+		ArgType:  type_map.AddType(scope, &OllamaPluginArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterPlugin(&OllamaPlugin{})
+}
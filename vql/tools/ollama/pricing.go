@@ -0,0 +1,73 @@
+package ollama
+
+import (
+	"strings"
+
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+)
+
+const (
+	DEFAULT_CURRENCY = "USD"
+)
+
+// Finds the price of the model in AI.pricing. An exact name is
+// preferred over a prefix, a longer prefix over a shorter one and a
+// price for the server over one for any server. Returns nil for
+// models without a price, such as local models.
+func findPrice(settings *config_proto.AIConfig,
+	base_url, model string) *config_proto.AIPrice {
+	name, _ := splitPinnedModel(model)
+
+	var result *config_proto.AIPrice
+	best := -1
+	for _, price := range settings.Pricing {
+		if price.Provider != "" && !strings.Contains(base_url, price.Provider) {
+			continue
+		}
+
+		score := 0
+		switch {
+		case price.Model == name:
+			score = 1 << 20
+
+		case strings.HasSuffix(price.Model, "*") &&
+			strings.HasPrefix(name, strings.TrimSuffix(price.Model, "*")):
+			score = len(price.Model)
+
+		default:
+			continue
+		}
+
+		score *= 2
+		if price.Provider != "" {
+			score++
+		}
+
+		if score > best {
+			best = score
+			result = price
+		}
+	}
+	return result
+}
+
+// The estimated cost of a call from its token usage, or nil when
+// the model has no price.
+func priceCall(settings *config_proto.AIConfig,
+	base_url, model string, stats *Stats) *float64 {
+	price := findPrice(settings, base_url, model)
+	if price == nil {
+		return nil
+	}
+
+	cost := (float64(stats.PromptEvalCount)*price.PromptPrice +
+		float64(stats.EvalCount)*price.CompletionPrice) / 1e6
+	return &cost
+}
+
+func currency(settings *config_proto.AIConfig) string {
+	if settings.Currency != "" {
+		return settings.Currency
+	}
+	return DEFAULT_CURRENCY
+}
@@ -0,0 +1,69 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"www.velocidex.com/golang/velociraptor/accessors"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	// Prompt files larger than this are probably the wrong file.
+	MAX_PROMPT_FILE = 1024 * 1024
+)
+
+// Read a prompt kept in a file, for example a tool's file or a file
+// uploaded to the server. The prompt argument is added after the
+// file's prompt so a query can ask a specific question of a shared
+// set of instructions.
+//
+// The path is parsed here rather than by the arg parser, which only
+// knows about an argument named accessor.
+func loadPrompt(ctx context.Context, scope vfilter.Scope,
+	path_arg vfilter.Any, accessor_name, prompt string) (string, error) {
+	if utils.IsNil(path_arg) {
+		return prompt, nil
+	}
+
+	err := vql_subsystem.CheckFilesystemAccess(scope, accessor_name)
+	if err != nil {
+		return "", err
+	}
+
+	accessor, err := accessors.GetAccessor(accessor_name, scope)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := accessors.ParseOSPath(ctx, scope, accessor, path_arg)
+	if err != nil {
+		return "", err
+	}
+
+	fd, err := accessor.OpenWithOSPath(path)
+	if err != nil {
+		return "", fmt.Errorf("Unable to open prompt %v: %w", path, err)
+	}
+	defer fd.Close()
+
+	data, err := io.ReadAll(io.LimitReader(fd, MAX_PROMPT_FILE+1))
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) > MAX_PROMPT_FILE {
+		return "", fmt.Errorf("Prompt %v is larger than %v bytes",
+			path, MAX_PROMPT_FILE)
+	}
+
+	file_prompt := strings.TrimSpace(cleanText(string(data)))
+	if prompt == "" {
+		return file_prompt, nil
+	}
+	return file_prompt + "\n\n" + prompt, nil
+}
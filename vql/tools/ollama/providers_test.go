@@ -0,0 +1,535 @@
+package ollama
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	api_proto "www.velocidex.com/golang/velociraptor/api/proto"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/constants"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/velociraptor/vql/acl_managers"
+	"www.velocidex.com/golang/velociraptor/vtesting/assert"
+)
+
+func (self *OllamaTestSuite) TestClientCache() {
+	err := SetAISettings(self.ConfigObj, &config_proto.AIConfig{
+		BaseUrl: self.server.URL,
+	})
+	assert.NoError(self.T(), err)
+	defer SetAISettings(self.ConfigObj, &config_proto.AIConfig{})
+
+	manager, err := services.GetRepositoryManager(self.ConfigObj)
+	assert.NoError(self.T(), err)
+
+	scope := manager.BuildScope(services.ScopeBuilder{
+		Config:     self.ConfigObj,
+		ACLManager: acl_managers.NullACLManager{},
+	})
+	defer scope.Close()
+
+	client, err := NewClient(self.Ctx, scope, "")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), self.server.URL, client.base_url)
+
+	// The settings are read once for the query so later calls reuse
+	// the client without reading them again.
+	err = SetAISettings(self.ConfigObj, &config_proto.AIConfig{
+		BaseUrl: "mock://",
+	})
+	assert.NoError(self.T(), err)
+
+	again, err := NewClient(self.Ctx, scope, "")
+	assert.NoError(self.T(), err)
+	assert.True(self.T(), client == again)
+
+	// Servers which can not be resolved do not leave a client behind.
+	_, err = NewClient(self.Ctx, scope, "secret://missing")
+	assert.Error(self.T(), err)
+
+	cache := vql_subsystem.CacheGet(scope, OLLAMA_CLIENT_TAG).(*clientCache)
+	assert.Equal(self.T(), 1, len(cache.clients))
+}
+
+func (self *OllamaTestSuite) TestSecretUrl() {
+	secrets, err := services.GetSecretsService(self.ConfigObj)
+	assert.NoError(self.T(), err)
+
+	err = secrets.DefineSecret(self.Ctx, &api_proto.SecretDefinition{
+		TypeName: constants.HTTP_SECRETS})
+	assert.NoError(self.T(), err)
+
+	err = secrets.AddSecret(self.Ctx, vql_subsystem.MakeScope(),
+		constants.HTTP_SECRETS, "gateway", ordereddict.NewDict().
+			Set("url", self.server.URL+"/").
+			Set("extra_headers", "Authorization: Bearer hunter2"))
+	assert.NoError(self.T(), err)
+
+	err = secrets.ModifySecret(self.Ctx, &api_proto.ModifySecretRequest{
+		TypeName: constants.HTTP_SECRETS,
+		Name:     "gateway",
+		AddUsers: []string{"analyst"},
+	})
+	assert.NoError(self.T(), err)
+
+	for _, user := range []string{"analyst", "other"} {
+		err = services.GrantRoles(self.ConfigObj, user,
+			[]string{"administrator"})
+		assert.NoError(self.T(), err)
+	}
+
+	query := `
+SELECT * FROM ollama(model="llama3", prompt="Hi", cache_bypass=TRUE,
+   base_url="secret://gateway")`
+
+	// The endpoint and headers come from the secret.
+	rows := self.runAs("analyst", query)
+	assert.Equal(self.T(), 1, len(rows))
+
+	response, _ := rows[0].GetString("Response")
+	assert.Equal(self.T(), "Hello world", response)
+	assert.Equal(self.T(), "Bearer hunter2", self.authorization)
+
+	// Users the secret is not shared with can not use it.
+	rows = self.runAs("other", query)
+	assert.Equal(self.T(), 0, len(rows))
+	assert.Equal(self.T(), 1, len(self.requests))
+}
+
+func (self *OllamaTestSuite) TestOpenAI() {
+	var mu sync.Mutex
+	requests := make(map[string]*ordereddict.Dict)
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			request := ordereddict.NewDict()
+			if len(body) > 0 {
+				assert.NoError(self.T(), json.Unmarshal(body, request))
+			}
+
+			mu.Lock()
+			requests[r.URL.Path] = request
+			mu.Unlock()
+
+			switch r.URL.Path {
+			case "/v1/models":
+				fmt.Fprintf(w, `{"data":[{"id":"gpt"}]}`)
+			case "/v1/chat/completions":
+				fmt.Fprintf(w, "data: %s\n\n", `{"model":"gpt","choices":[{"delta":{"content":"Hel"}}]}`)
+				fmt.Fprintf(w, "data: %s\n\n", `{"choices":[{"delta":{"content":"lo",`+
+					`"tool_calls":[{"index":0,"function":{"name":"lookup","arguments":"{\"pid\""}}]}}]}`)
+				fmt.Fprintf(w, ": keep-alive\n\n")
+				fmt.Fprintf(w, "data: %s\n\n", `{"choices":[{"delta":{"tool_calls":[{"index":0,`+
+					`"function":{"arguments":":42}"}}]},"finish_reason":"tool_calls"}]}`)
+				fmt.Fprintf(w, "data: %s\n\n", `{"choices":[],"usage":{"prompt_tokens":10,"completion_tokens":2}}`)
+				fmt.Fprintf(w, "data: [DONE]\n\n")
+			case "/v1/responses":
+				// The stream is cut off after the first event and
+				// continued when the client reconnects.
+				if r.Header.Get("Last-Event-ID") != "1" {
+					fmt.Fprintf(w, "retry: 10\nid: 1\nevent: response.output_text.delta\ndata: %s\n\n",
+						`{"type":"response.output_text.delta","delta":"Hel"}`)
+					return
+				}
+				fmt.Fprintf(w, "id: 2\nevent: response.output_text.delta\ndata: %s\n\n",
+					`{"type":"response.output_text.delta","delta":"lo"}`)
+				fmt.Fprintf(w, "id: 3\nevent: response.output_item.done\ndata: %s\n\n",
+					`{"type":"response.output_item.done","item":{"type":"function_call",`+
+						`"name":"lookup","arguments":"{\"pid\":42}"}}`)
+				fmt.Fprintf(w, "id: 4\nevent: response.incomplete\ndata: %s\n\n",
+					`{"type":"response.incomplete","response":{"model":"gpt","status":"incomplete",`+
+						`"incomplete_details":{"reason":"max_output_tokens"},`+
+						`"usage":{"input_tokens":10,"output_tokens":2}}}`)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprintf(w, `{"error":{"message":"no such model"}}`)
+			}
+		}))
+	defer server.Close()
+
+	query := `
+LET Messages = (dict(role="system", content="Be brief"),
+  dict(role="user", content="Check 42"),
+  dict(role="assistant", content="",
+       tool_calls=(dict(function=dict(name="lookup", arguments=dict(pid=42))),)),
+  dict(role="tool", tool_name="lookup", content="fine"))
+
+SELECT * FROM ollama_chat(model="gpt", base_url=URL, messages=Messages,
+   tools=(dict(name="lookup", parameters=dict(type="object")),))`
+
+	for _, api := range []string{"chat", "responses"} {
+		base_url := "openai+" + server.URL + "/v1?api=" + api
+		rows := self.run(strings.Replace(query, "URL", fmt.Sprintf("%q", base_url), 1))
+		assert.Equal(self.T(), 1, len(rows))
+
+		content, _ := rows[0].GetString("Content")
+		assert.Equal(self.T(), "Hello", content)
+
+		tool_calls, _ := rows[0].Get("ToolCalls")
+		assert.Equal(self.T(), `[{"function":{"name":"lookup","arguments":{"pid":42}}}]`,
+			json.MustMarshalString(tool_calls))
+
+		prompt_tokens, _ := rows[0].GetInt64("PromptEvalCount")
+		assert.Equal(self.T(), int64(10), prompt_tokens)
+	}
+
+	// The tool result answers the call made by the model.
+	chat := json.MustMarshalString(requests["/v1/chat/completions"])
+	assert.Contains(self.T(), chat, `"stream":true,"stream_options":{"include_usage":true}`)
+	assert.Contains(self.T(), chat, `"tool_calls":[{"id":"call_1","type":"function",`+
+		`"function":{"name":"lookup","arguments":"{\"pid\":42}"}}]`)
+	assert.Contains(self.T(), chat, `{"role":"tool","content":"fine","tool_call_id":"call_1"}`)
+
+	// The responses API takes the system prompt as instructions and
+	// the tool call and its output as input items.
+	responses := json.MustMarshalString(requests["/v1/responses"])
+	assert.Contains(self.T(), responses, `"instructions":"Be brief"`)
+	assert.Contains(self.T(), responses, `{"type":"function_call","call_id":"call_1",`+
+		`"name":"lookup","arguments":"{\"pid\":42}"}`)
+	assert.Contains(self.T(), responses, `{"type":"function_call_output",`+
+		`"call_id":"call_1","output":"fine"}`)
+
+	// Generate calls are sent as chats and errors are passed on.
+	rows := self.run(fmt.Sprintf(`
+SELECT * FROM ollama(model="gpt", prompt="Hi", base_url=%q)`,
+		"openai+"+server.URL+"/v2"))
+	assert.Equal(self.T(), 1, len(rows))
+
+	provider_error, _ := rows[0].GetString("ProviderError")
+	assert.Equal(self.T(), "no such model", provider_error)
+}
+
+func (self *OllamaTestSuite) TestAzureCredentials() {
+	clock := utils.NewMockClock(time.Unix(1700000000, 0))
+	defer utils.MockTime(clock)()
+
+	var mu sync.Mutex
+	var issued, refused int
+	revoked := ""
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch r.URL.Path {
+			case "/tenant/oauth2/v2.0/token":
+				assert.NoError(self.T(), r.ParseForm())
+				assert.Equal(self.T(), "client_credentials", r.Form.Get("grant_type"))
+				assert.Equal(self.T(), "https://cognitiveservices.azure.com/.default",
+					r.Form.Get("scope"))
+				issued++
+				fmt.Fprintf(w, `{"token_type":"Bearer","expires_in":3600,"access_token":"token-%d"}`,
+					issued)
+
+			case "/openai/v1/chat/completions":
+				assert.Equal(self.T(), "2024-10-21", r.URL.Query().Get("api-version"))
+				auth := r.Header.Get("Authorization")
+				if auth == revoked {
+					refused++
+					w.WriteHeader(http.StatusUnauthorized)
+					fmt.Fprintf(w, `{"error":{"message":"token revoked"}}`)
+					return
+				}
+				fmt.Fprintf(w, `{"model":"gpt","choices":[{"message":{"content":%q},`+
+					`"finish_reason":"stop"}]}`, auth)
+			}
+		}))
+	defer server.Close()
+
+	azure, err := newAzureEndpoint("test", &azureConfig{
+		url:            server.URL + "/openai/v1?api-version=2024-10-21",
+		auth:           AZURE_AUTH_CLIENT_CREDENTIALS,
+		tenant_id:      "tenant",
+		client_id:      "client",
+		client_secret:  "secret",
+		authority_host: server.URL,
+	}, http.DefaultTransport.(*http.Transport).Clone())
+	assert.NoError(self.T(), err)
+
+	openai, err := newOpenAITransport(azure.base_url, &authTransport{
+		credentials: azure.credentials,
+		transport:   http.DefaultTransport,
+	})
+	assert.NoError(self.T(), err)
+
+	client := &Client{
+		base_url:      openai.base_url,
+		client:        &http.Client{Transport: openai},
+		settings:      &config_proto.AIConfig{},
+		circuit_state: getCircuitState(openai.base_url),
+		pins:          newPinCache(),
+	}
+
+	// The model answers with the Authorization header it was sent.
+	chat := func() string {
+		result := ""
+		err := client.Chat(self.Ctx, &ChatRequest{Model: "gpt",
+			Messages: []*Message{{Role: "user", Content: "Hi"}}},
+			func(resp *ChatResponse) error {
+				if resp.Message != nil {
+					result += resp.Message.Content
+				}
+				return nil
+			})
+		assert.NoError(self.T(), err)
+		return result
+	}
+
+	// The token is reused until it is about to expire.
+	assert.Equal(self.T(), "Bearer token-1", chat())
+	assert.Equal(self.T(), "Bearer token-1", chat())
+
+	clock.Set(clock.Now().Add(56 * time.Minute))
+	assert.Equal(self.T(), "Bearer token-2", chat())
+
+	// A token the server refuses is replaced.
+	mu.Lock()
+	revoked = "Bearer token-2"
+	mu.Unlock()
+
+	assert.Equal(self.T(), "Bearer token-3", chat())
+	assert.Equal(self.T(), 3, issued)
+	assert.Equal(self.T(), 1, refused)
+}
+
+func (self *OllamaTestSuite) TestBedrockSigning() {
+	clock := utils.NewMockClock(time.Unix(1700000000, 0))
+	defer utils.MockTime(clock)()
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(self.T(), "/openai/v1/chat/completions", r.URL.Path)
+			assert.Equal(self.T(), "20231114T221320Z", r.Header.Get("X-Amz-Date"))
+			assert.Equal(self.T(), "session", r.Header.Get("X-Amz-Security-Token"))
+
+			// The model answers with the scope of the signature.
+			auth := r.Header.Get("Authorization")
+			scope := strings.TrimPrefix(strings.Split(auth, ",")[0],
+				"AWS4-HMAC-SHA256 Credential=")
+			fmt.Fprintf(w, `{"model":"gpt","choices":[{"message":{"content":%q},`+
+				`"finish_reason":"stop"}]}`, scope)
+		}))
+	defer server.Close()
+
+	bedrock, err := newBedrockEndpoint(self.Ctx, "test", &bedrockConfig{
+		url:                server.URL + "/openai/v1",
+		region:             "us-west-2",
+		credentials_key:    "AKID",
+		credentials_secret: "secret",
+		credentials_token:  "session",
+	}, http.DefaultTransport.(*http.Transport).Clone())
+	assert.NoError(self.T(), err)
+
+	openai, err := newOpenAITransport(bedrock.base_url, &authTransport{
+		credentials: bedrock.credentials,
+		transport:   http.DefaultTransport,
+	})
+	assert.NoError(self.T(), err)
+
+	client := &Client{
+		base_url:      openai.base_url,
+		client:        &http.Client{Transport: openai},
+		settings:      &config_proto.AIConfig{},
+		circuit_state: getCircuitState(openai.base_url),
+		pins:          newPinCache(),
+	}
+
+	result := ""
+	err = client.Chat(self.Ctx, &ChatRequest{Model: "gpt",
+		Messages: []*Message{{Role: "user", Content: "Hi"}}},
+		func(resp *ChatResponse) error {
+			if resp.Message != nil {
+				result += resp.Message.Content
+			}
+			return nil
+		})
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), "AKID/20231114/us-west-2/bedrock/aws4_request", result)
+}
+
+func (self *OllamaTestSuite) TestVertexServiceAccount() {
+	var mu sync.Mutex
+	var issued int
+	revoked := ""
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch r.URL.Path {
+			case "/token":
+				assert.NoError(self.T(), r.ParseForm())
+				assert.Equal(self.T(), "urn:ietf:params:oauth:grant-type:jwt-bearer",
+					r.Form.Get("grant_type"))
+				assert.NotEmpty(self.T(), r.Form.Get("assertion"))
+				issued++
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"token_type":"Bearer","expires_in":3600,"access_token":"token-%d"}`,
+					issued)
+
+			case "/v1/projects/project/locations/global/endpoints/openapi/chat/completions":
+				auth := r.Header.Get("Authorization")
+				if auth == revoked {
+					w.WriteHeader(http.StatusUnauthorized)
+					fmt.Fprintf(w, `{"error":{"message":"token revoked"}}`)
+					return
+				}
+				fmt.Fprintf(w, `{"model":"gemini","choices":[{"message":{"content":%q},`+
+					`"finish_reason":"stop"}]}`, auth)
+
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	defer server.Close()
+
+	private_key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(self.T(), err)
+
+	key_pem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(private_key)})
+
+	service_account := json.MustMarshalString(ordereddict.NewDict().
+		Set("type", "service_account").
+		Set("project_id", "project").
+		Set("private_key_id", "1").
+		Set("private_key", string(key_pem)).
+		Set("client_email", "velociraptor@project.iam.gserviceaccount.com").
+		Set("token_uri", server.URL+"/token"))
+
+	vertex, err := newVertexEndpoint("test", &vertexConfig{
+		url:              server.URL + "/v1/projects/project/locations/global/endpoints/openapi",
+		credentials_json: service_account,
+	}, http.DefaultTransport.(*http.Transport).Clone())
+	assert.NoError(self.T(), err)
+
+	openai, err := newOpenAITransport(vertex.base_url, &authTransport{
+		credentials: vertex.credentials,
+		transport:   http.DefaultTransport,
+	})
+	assert.NoError(self.T(), err)
+
+	client := &Client{
+		base_url:      openai.base_url,
+		client:        &http.Client{Transport: openai},
+		settings:      &config_proto.AIConfig{},
+		circuit_state: getCircuitState(openai.base_url),
+		pins:          newPinCache(),
+	}
+
+	chat := func() string {
+		result := ""
+		err := client.Chat(self.Ctx, &ChatRequest{Model: "gemini",
+			Messages: []*Message{{Role: "user", Content: "Hi"}}},
+			func(resp *ChatResponse) error {
+				if resp.Message != nil {
+					result += resp.Message.Content
+				}
+				return nil
+			})
+		assert.NoError(self.T(), err)
+		return result
+	}
+
+	assert.Equal(self.T(), "Bearer token-1", chat())
+	assert.Equal(self.T(), "Bearer token-1", chat())
+
+	mu.Lock()
+	revoked = "Bearer token-1"
+	mu.Unlock()
+
+	assert.Equal(self.T(), "Bearer token-2", chat())
+	assert.Equal(self.T(), 2, issued)
+}
+
+func (self *OllamaTestSuite) TestOpenAIImages() {
+	png := base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n"))
+	messages, err := parseChatMessages([]*ordereddict.Dict{
+		ordereddict.NewDict().
+			Set("role", "user").
+			Set("content", "What is shown?").
+			Set("images", []string{"data:image/png;base64," + png,
+				"https://example.com/screen.jpg"}),
+	})
+	assert.NoError(self.T(), err)
+
+	// Ollama gets the base64 data of data URLs.
+	assert.Equal(self.T(), png, messages[0].Images[0])
+
+	chat_req := &ChatRequest{Model: "gpt", Messages: messages}
+	request, err := buildChatCompletion(chat_req, newToolNames(nil))
+	assert.NoError(self.T(), err)
+	assert.Contains(self.T(), json.MustMarshalString(request),
+		`"content":[{"type":"text","text":"What is shown?"},`+
+			`{"type":"image_url","image_url":{"url":"data:image/png;base64,`+png+`"}},`+
+			`{"type":"image_url","image_url":{"url":"https://example.com/screen.jpg"}}]`)
+
+	request, err = buildResponse(chat_req, newToolNames(nil))
+	assert.NoError(self.T(), err)
+	assert.Contains(self.T(), json.MustMarshalString(request),
+		`"content":[{"type":"input_text","text":"What is shown?"},`+
+			`{"type":"input_image","image_url":"data:image/png;base64,`+png+`"},`+
+			`{"type":"input_image","image_url":"https://example.com/screen.jpg"}]`)
+
+	// Only user messages may hold images.
+	chat_req.Messages[0].Role = "assistant"
+	_, err = buildChatCompletion(chat_req, newToolNames(nil))
+	assert.Error(self.T(), err)
+}
+
+func (self *OllamaTestSuite) TestToolSchema() {
+	query := `
+LET Tools = (dict(name="Windows.System.Pslist", description="List processes",
+                  query="SELECT * FROM pslist()", risk="low"),
+             dict(name="Windows_System_Pslist",
+                  parameters=dict(properties=dict(pid=dict(type="integer")))))
+
+SELECT ollama_tool_schema(tools=Tools, schema=SCHEMA) AS Tools FROM scope()`
+
+	expected := map[string]string{
+		"ollama": `[{"type":"function","function":{"name":"Windows.System.Pslist",` +
+			`"description":"List processes","parameters":{"type":"object","properties":{}}}},` +
+			`{"type":"function","function":{"name":"Windows_System_Pslist","description":"",` +
+			`"parameters":{"type":"object","properties":{"pid":{"type":"integer"}}}}}]`,
+		"openai_responses": `[{"type":"function","name":"Windows_System_Pslist",` +
+			`"description":"List processes","parameters":{"type":"object","properties":{}}},` +
+			`{"type":"function","name":"Windows_System_Pslist_2","description":"",` +
+			`"parameters":{"type":"object","properties":{"pid":{"type":"integer"}}}}]`,
+		"anthropic": `[{"name":"Windows_System_Pslist","description":"List processes",` +
+			`"input_schema":{"type":"object","properties":{}}},` +
+			`{"name":"Windows_System_Pslist_2","description":"",` +
+			`"input_schema":{"type":"object","properties":{"pid":{"type":"integer"}}}}]`,
+	}
+
+	for schema, tools := range expected {
+		rows := self.run(strings.Replace(query, "SCHEMA", fmt.Sprintf("%q", schema), 1))
+		assert.Equal(self.T(), 1, len(rows))
+
+		value, _ := rows[0].Get("Tools")
+		assert.Equal(self.T(), tools, json.MustMarshalString(value))
+	}
+
+	// Calls to rewritten names are mapped back to the tool.
+	names := newToolNames([]*ToolSpec{{Function: &ToolFunction{
+		Name: "Windows.System.Pslist"}}})
+	assert.Equal(self.T(), "Windows.System.Pslist",
+		names.Original("Windows_System_Pslist"))
+	assert.Equal(self.T(), 64, len(providerToolName(strings.Repeat("a", 100), "_2")))
+}
@@ -0,0 +1,417 @@
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/artifacts"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	crypto_client "www.velocidex.com/golang/velociraptor/crypto/client"
+	crypto_proto "www.velocidex.com/golang/velociraptor/crypto/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/logging"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/services/writeback"
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/velociraptor/vql/acl_managers"
+	"www.velocidex.com/golang/velociraptor/vql/networking"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	SERVER_SCHEME = "server://"
+
+	// The frontend handler answering the AI requests of clients.
+	AI_PROXY_PATH = "ai"
+
+	MAX_PROXY_RESPONSE = 64 * 1024 * 1024
+)
+
+// A base_url of server:// sends the requests of a client through the
+// Velociraptor server, which calls its own model server with its AI
+// settings. Endpoints then need neither network access to the model
+// server nor its credentials. The requests are encrypted with the
+// client's keys like its other messages to the server, so only
+// enrolled clients are answered.
+//
+// On the server, server:// is the configured model server.
+func isServerUrl(base_url string) bool {
+	return strings.HasPrefix(base_url, SERVER_SCHEME)
+}
+
+// An Ollama API request forwarded through the server.
+type proxyRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Body   []byte `json:"body,omitempty"`
+}
+
+type proxyResponse struct {
+	Status int    `json:"status"`
+	Body   []byte `json:"body,omitempty"`
+}
+
+// Sends the requests of the client to the server's AI handler.
+type serverTransport struct {
+	mu sync.Mutex
+
+	ctx        context.Context
+	config_obj *config_proto.Config
+	client     *http.Client
+
+	// Created when first needed since the server's certificate must
+	// be fetched.
+	crypto_manager *crypto_client.ClientCryptoManager
+	server_name    string
+}
+
+func newServerTransport(scope vfilter.Scope) (*serverTransport, error) {
+	client_config, ok := artifacts.GetConfig(scope)
+	if !ok || len(client_config.ServerUrls) == 0 {
+		return nil, errors.New("server:// may only be used on clients")
+	}
+
+	transport, err := networking.GetHttpTransport(client_config, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// The server only answers once the model has finished.
+	transport.ResponseHeaderTimeout = 0
+
+	// The crypto manager lives as long as the query.
+	ctx, cancel := context.WithCancel(context.Background())
+	err = scope.AddDestructor(cancel)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &serverTransport{
+		ctx:        ctx,
+		config_obj: &config_proto.Config{Client: client_config},
+		client:     &http.Client{Transport: transport},
+	}, nil
+}
+
+func (self *serverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		defer req.Body.Close()
+
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	serialized, err := json.Marshal(&proxyRequest{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Body:   body,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	manager, server_name, err := self.cryptoManager(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	cipher_text, err := manager.Encrypt([][]byte{serialized},
+		crypto_proto.PackedMessageList_UNCOMPRESSED,
+		self.config_obj.Client.Nonce, server_name)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := self.send(req.Context(), "POST", AI_PROXY_PATH, cipher_text)
+	if err != nil {
+		return nil, err
+	}
+
+	message_info, err := manager.Decrypt(req.Context(), reply)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt the server's response: %w", err)
+	}
+
+	if !message_info.Authenticated || len(message_info.RawCompressed) != 1 {
+		return nil, errors.New("Invalid response from the server")
+	}
+
+	response := &proxyResponse{}
+	err = json.Unmarshal(message_info.RawCompressed[0], response)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid response from the server: %w", err)
+	}
+
+	resp := newHttpResponse(response.Status, response.Body)
+	resp.Request = req
+	return resp, nil
+}
+
+// Tries each server in turn until one answers.
+func (self *serverTransport) send(ctx context.Context,
+	method, path string, body []byte) ([]byte, error) {
+	var err error
+	for _, server_url := range self.config_obj.Client.ServerUrls {
+		// Websocket servers answer the same handlers over http.
+		switch {
+		case strings.HasPrefix(server_url, "wss://"):
+			server_url = "https://" + strings.TrimPrefix(server_url, "wss://")
+		case strings.HasPrefix(server_url, "ws://"):
+			server_url = "http://" + strings.TrimPrefix(server_url, "ws://")
+		}
+
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, method,
+			server_url+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		var resp *http.Response
+		resp, err = self.client.Do(req)
+		if err != nil {
+			continue
+		}
+
+		data, read_err := io.ReadAll(io.LimitReader(resp.Body, MAX_PROXY_RESPONSE))
+		resp.Body.Close()
+		if read_err != nil {
+			err = read_err
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Server %v refused the request: %v",
+				server_url, resp.Status)
+		}
+		return data, nil
+	}
+
+	if err == nil {
+		err = errors.New("No server URLs configured")
+	}
+	return nil, err
+}
+
+// The client's crypto manager, trusting the server's certificate
+// when it is signed by the deployment's CA.
+func (self *serverTransport) cryptoManager(ctx context.Context) (
+	*crypto_client.ClientCryptoManager, string, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.crypto_manager != nil {
+		return self.crypto_manager, self.server_name, nil
+	}
+
+	writeback_service := writeback.GetWritebackService()
+	wb, err := writeback_service.GetWriteback(self.config_obj)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if wb.PrivateKey == "" {
+		return nil, "", errors.New("The client is not enrolled")
+	}
+
+	server_pem, err := self.send(ctx, "GET", "server.pem", nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	manager, err := crypto_client.NewClientCryptoManager(self.ctx,
+		self.config_obj, []byte(wb.PrivateKey))
+	if err != nil {
+		return nil, "", err
+	}
+
+	server_name, err := manager.AddCertificate(self.config_obj, server_pem)
+	if err != nil {
+		return nil, "", fmt.Errorf("Invalid server certificate: %w", err)
+	}
+
+	self.crypto_manager = manager
+	self.server_name = server_name
+	return manager, server_name, nil
+}
+
+// Answers an AI request sent by a client through the server. The
+// server's AI settings apply to the request, including the allowed
+// models and limits. Calls are made as the server's superuser so
+// secrets used by the settings should be shared with it.
+func ProxyClientRequest(ctx context.Context, config_obj *config_proto.Config,
+	client_id string, data []byte) []byte {
+	response := proxyClientRequest(ctx, config_obj, client_id, data)
+	serialized, err := json.Marshal(response)
+	if err != nil {
+		serialized, _ = json.Marshal(proxyError(err))
+	}
+	return serialized
+}
+
+func proxyClientRequest(ctx context.Context, config_obj *config_proto.Config,
+	client_id string, data []byte) *proxyResponse {
+	settings, err := GetAISettings(config_obj)
+	if err != nil {
+		return proxyError(err)
+	}
+
+	if !settings.ClientProxy {
+		return proxyError(notAllowed(AI_PROXY_PATH,
+			errors.New("AI.client_proxy is not enabled on the server")))
+	}
+
+	release, refused := proxy_limiter.Acquire(ctx,
+		config_obj.OrgId+"/"+client_id)
+	if refused != nil {
+		return refused
+	}
+	defer release()
+
+	request := &proxyRequest{}
+	err = json.Unmarshal(data, request)
+	if err != nil {
+		return proxyStatus(http.StatusBadRequest, err)
+	}
+
+	manager, err := services.GetRepositoryManager(config_obj)
+	if err != nil {
+		return proxyError(err)
+	}
+
+	logger := logging.NewPlainLogger(config_obj, &logging.FrontendComponent)
+	scope := manager.BuildScope(services.ScopeBuilder{
+		Config: config_obj,
+		ACLManager: acl_managers.NewServerACLManager(config_obj,
+			utils.GetSuperuserName(config_obj)),
+		Logger: logger,
+		Env:    ordereddict.NewDict().Set("ClientId", client_id),
+	})
+	defer scope.Close()
+
+	client, err := NewClient(ctx, scope, "")
+	if err != nil {
+		return proxyError(err)
+	}
+	client = client.WithClientId(client_id)
+
+	switch request.Method + " " + request.Path {
+	case "POST /api/chat":
+		req := &ChatRequest{}
+		err := json.Unmarshal(request.Body, req)
+		if err != nil {
+			return proxyStatus(http.StatusBadRequest, err)
+		}
+
+		result := &bytes.Buffer{}
+		err = client.Chat(ctx, req, func(resp *ChatResponse) error {
+			return writeNDJSON(result, resp)
+		})
+		if err != nil {
+			return proxyError(err)
+		}
+		return &proxyResponse{Status: http.StatusOK, Body: result.Bytes()}
+
+	case "POST /api/generate":
+		req := &GenerateRequest{}
+		err := json.Unmarshal(request.Body, req)
+		if err != nil {
+			return proxyStatus(http.StatusBadRequest, err)
+		}
+
+		result := &bytes.Buffer{}
+		err = client.Generate(ctx, req, func(resp *GenerateResponse) error {
+			return writeNDJSON(result, resp)
+		})
+		if err != nil {
+			return proxyError(err)
+		}
+		return &proxyResponse{Status: http.StatusOK, Body: result.Bytes()}
+
+	case "POST /api/embed":
+		req := &EmbedRequest{}
+		err := json.Unmarshal(request.Body, req)
+		if err != nil {
+			return proxyStatus(http.StatusBadRequest, err)
+		}
+		return proxyReply(client.Embed(ctx, req))
+
+	case "POST /api/show":
+		req := &ShowRequest{}
+		err := json.Unmarshal(request.Body, req)
+		if err != nil {
+			return proxyStatus(http.StatusBadRequest, err)
+		}
+		return proxyReply(client.Show(ctx, req.Model))
+
+	case "GET /api/tags":
+		return proxyReply(client.List(ctx))
+
+	case "GET /api/ps":
+		return proxyReply(client.Running(ctx))
+
+	case "GET /api/version":
+		version, err := client.Version(ctx)
+		return proxyReply(&VersionResponse{Version: version}, err)
+	}
+
+	return proxyStatus(http.StatusNotFound,
+		fmt.Errorf("%v %v is not available through the server",
+			request.Method, request.Path))
+}
+
+func writeNDJSON(out *bytes.Buffer, item interface{}) error {
+	serialized, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	out.Write(serialized)
+	out.WriteByte('\n')
+	return nil
+}
+
+func proxyReply(item interface{}, err error) *proxyResponse {
+	if err != nil {
+		return proxyError(err)
+	}
+
+	serialized, err := json.Marshal(item)
+	if err != nil {
+		return proxyError(err)
+	}
+	return &proxyResponse{Status: http.StatusOK, Body: serialized}
+}
+
+// Errors are returned to the client as the model server reports
+// them so the client classifies them the same way.
+func proxyError(err error) *proxyResponse {
+	status := http.StatusBadGateway
+	ollama_err := &Error{}
+	if errors.As(err, &ollama_err) {
+		switch {
+		case ollama_err.Code == ERROR_NOT_ALLOWED:
+			status = http.StatusForbidden
+		case ollama_err.HttpStatus != 0:
+			status = ollama_err.HttpStatus
+		}
+	}
+	return proxyStatus(status, err)
+}
+
+func proxyStatus(status int, err error) *proxyResponse {
+	serialized, _ := json.Marshal(ordereddict.NewDict().
+		Set("error", err.Error()))
+	return &proxyResponse{Status: status, Body: serialized}
+}
@@ -0,0 +1,146 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// Client requests answered at the same time by the server. Model
+	// calls are slow so they have their own pool rather than taking
+	// the slots of client comms.
+	MAX_PROXY_REQUESTS = 10
+
+	// Requests of a single client answered at the same time.
+	MAX_PROXY_CLIENT_REQUESTS = 2
+
+	// Each client may send a burst of requests, then one every
+	// interval.
+	PROXY_CLIENT_BURST    = 10
+	PROXY_CLIENT_INTERVAL = 2 * time.Second
+
+	// How long a request waits for a slot in the pool.
+	PROXY_SLOT_TIMEOUT = 60 * time.Second
+)
+
+var (
+	proxy_limiter = newProxyLimiter(MAX_PROXY_REQUESTS,
+		MAX_PROXY_CLIENT_REQUESTS, PROXY_CLIENT_BURST, PROXY_CLIENT_INTERVAL)
+)
+
+type proxyClientState struct {
+	active  int
+	limiter *rate.Limiter
+}
+
+// Limits the model calls clients make through the server so a
+// single client can not use up the model server or the frontend.
+type proxyLimiter struct {
+	mu sync.Mutex
+
+	pool    chan bool
+	clients map[string]*proxyClientState
+
+	max_client_requests int
+	burst               int
+	interval            time.Duration
+
+	last_sweep time.Time
+}
+
+func newProxyLimiter(max_requests, max_client_requests, burst int,
+	interval time.Duration) *proxyLimiter {
+	return &proxyLimiter{
+		pool:                make(chan bool, max_requests),
+		clients:             make(map[string]*proxyClientState),
+		max_client_requests: max_client_requests,
+		burst:               burst,
+		interval:            interval,
+	}
+}
+
+// Wait for a slot to answer a request of the client. Requests over
+// the client's limits are refused straight away with a status the
+// client treats as rate limiting. The returned function releases the
+// slot.
+func (self *proxyLimiter) Acquire(ctx context.Context,
+	key string) (func(), *proxyResponse) {
+	self.mu.Lock()
+	self.sweep()
+
+	state, pres := self.clients[key]
+	if !pres {
+		state = &proxyClientState{
+			limiter: rate.NewLimiter(rate.Every(self.interval), self.burst),
+		}
+		self.clients[key] = state
+	}
+
+	if state.active >= self.max_client_requests {
+		self.mu.Unlock()
+		return nil, proxyStatus(http.StatusTooManyRequests, fmt.Errorf(
+			"Only %v requests of a client are answered at the same time",
+			self.max_client_requests))
+	}
+
+	if !state.limiter.Allow() {
+		self.mu.Unlock()
+		return nil, proxyStatus(http.StatusTooManyRequests,
+			fmt.Errorf("Too many requests, only one every %v is answered",
+				self.interval))
+	}
+
+	state.active++
+	self.mu.Unlock()
+
+	select {
+	case self.pool <- true:
+		return func() {
+			<-self.pool
+			self.release(key)
+		}, nil
+
+	case <-ctx.Done():
+		self.release(key)
+		return nil, proxyStatus(http.StatusServiceUnavailable, ctx.Err())
+
+	case <-time.After(PROXY_SLOT_TIMEOUT):
+		self.release(key)
+		return nil, proxyStatus(http.StatusServiceUnavailable, fmt.Errorf(
+			"The server is busy answering %v requests", cap(self.pool)))
+	}
+}
+
+func (self *proxyLimiter) release(key string) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	state, pres := self.clients[key]
+	if pres {
+		state.active--
+	}
+}
+
+// Forget idle clients which may send a full burst again, since they
+// are no different from clients which were never seen. Clients need
+// at least a burst's worth of intervals to get there so this is
+// only checked that often.
+func (self *proxyLimiter) sweep() {
+	now := time.Now()
+	if now.Sub(self.last_sweep) < self.interval*time.Duration(self.burst) {
+		return
+	}
+	self.last_sweep = now
+
+	for key, state := range self.clients {
+		if state.active == 0 &&
+			state.limiter.Tokens() >= float64(self.burst) {
+			delete(self.clients, key)
+		}
+	}
+}
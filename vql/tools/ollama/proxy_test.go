@@ -0,0 +1,98 @@
+package ollama
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/vtesting/assert"
+)
+
+func (self *OllamaTestSuite) TestClientProxy() {
+	chat := func(model string) string {
+		return json.MustMarshalString(&proxyRequest{
+			Method: "POST",
+			Path:   "/api/chat",
+			Body: []byte(json.MustMarshalString(&ChatRequest{Model: model,
+				Messages: []*Message{{Role: "user", Content: "Hi"}}})),
+		})
+	}
+
+	proxy := func(request string) *proxyResponse {
+		response := &proxyResponse{}
+		err := json.Unmarshal(ProxyClientRequest(self.Ctx, self.ConfigObj,
+			"C.1234", []byte(request)), response)
+		assert.NoError(self.T(), err)
+		return response
+	}
+
+	// The server must allow clients to use it.
+	assert.Equal(self.T(), http.StatusForbidden, proxy(chat("llama3")).Status)
+
+	err := SetAISettings(self.ConfigObj, &config_proto.AIConfig{
+		BaseUrl:       "mock://",
+		AllowedModels: []string{"llama3"},
+		ClientProxy:   true,
+	})
+	assert.NoError(self.T(), err)
+	defer SetAISettings(self.ConfigObj, &config_proto.AIConfig{})
+
+	response := proxy(chat("llama3"))
+	assert.Equal(self.T(), http.StatusOK, response.Status)
+
+	result := ""
+	err = readNDJSON(strings.NewReader(string(response.Body)), MAX_STREAM_MESSAGE,
+		func(line []byte) error {
+			chunk := &ChatResponse{}
+			err := json.Unmarshal(line, chunk)
+			if chunk.Message != nil {
+				result += chunk.Message.Content
+			}
+			return err
+		})
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), "Mock response from llama3", result)
+
+	// The server's settings apply to the calls of clients.
+	assert.Equal(self.T(), http.StatusForbidden, proxy(chat("mistral")).Status)
+
+	// Only model calls are forwarded.
+	assert.Equal(self.T(), http.StatusNotFound,
+		proxy(`{"method":"DELETE","path":"/api/delete"}`).Status)
+}
+
+func (self *OllamaTestSuite) TestClientProxyLimits() {
+	limiter := newProxyLimiter(1, 1, 2, time.Hour)
+
+	release, refused := limiter.Acquire(self.Ctx, "C.1234")
+	assert.Nil(self.T(), refused)
+
+	// Only one request of a client is answered at the same time.
+	_, refused = limiter.Acquire(self.Ctx, "C.1234")
+	assert.NotNil(self.T(), refused)
+	assert.Equal(self.T(), http.StatusTooManyRequests, refused.Status)
+
+	// Other clients wait for the slot of the pool.
+	ctx, cancel := context.WithTimeout(self.Ctx, 100*time.Millisecond)
+	defer cancel()
+	_, refused = limiter.Acquire(ctx, "C.5678")
+	assert.NotNil(self.T(), refused)
+	assert.Equal(self.T(), http.StatusServiceUnavailable, refused.Status)
+
+	release()
+	release, refused = limiter.Acquire(self.Ctx, "C.1234")
+	assert.Nil(self.T(), refused)
+	release()
+
+	// The burst is used up.
+	_, refused = limiter.Acquire(self.Ctx, "C.1234")
+	assert.NotNil(self.T(), refused)
+	assert.Equal(self.T(), http.StatusTooManyRequests, refused.Status)
+
+	release, refused = limiter.Acquire(self.Ctx, "C.5678")
+	assert.Nil(self.T(), refused)
+	release()
+}
@@ -0,0 +1,444 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	PSEUDONYM_HOST = "HOST"
+	PSEUDONYM_USER = "USER"
+	PSEUDONYM_IP   = "IP"
+
+	// Shorter values are too likely to appear by chance.
+	MIN_PSEUDONYM_LENGTH = 2
+)
+
+// Columns which usually hold identifying values, matched case
+// insensitively on the last part of the column name.
+var default_pseudonym_columns = map[string]string{
+	"hostname":        PSEUDONYM_HOST,
+	"host":            PSEUDONYM_HOST,
+	"fqdn":            PSEUDONYM_HOST,
+	"computer":        PSEUDONYM_HOST,
+	"computername":    PSEUDONYM_HOST,
+	"workstationname": PSEUDONYM_HOST,
+	"username":        PSEUDONYM_USER,
+	"user":            PSEUDONYM_USER,
+	"owner":           PSEUDONYM_USER,
+	"account":         PSEUDONYM_USER,
+	"accountname":     PSEUDONYM_USER,
+	"subjectusername": PSEUDONYM_USER,
+	"targetusername":  PSEUDONYM_USER,
+	"ip":              PSEUDONYM_IP,
+	"ipaddress":       PSEUDONYM_IP,
+}
+
+var (
+	pseudonym_ip_regex          = regexp.MustCompile(`\b\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}\b`)
+	pseudonym_ipv6_regex        = regexp.MustCompile(`[0-9A-Fa-f]*:[0-9A-Fa-f:.]*:[0-9A-Fa-f.]*`)
+	pseudonym_placeholder_regex = regexp.MustCompile(`\b([A-Z][A-Z0-9]*)_(\d+)\b`)
+	pseudonym_kind_regex        = regexp.MustCompile(`^[A-Z][A-Z0-9]*$`)
+)
+
+// Replaces identifying values in the query rows with placeholders
+// like HOST_1 and USER_3. The same value always gets the same
+// placeholder so the model can still relate rows to each other. The
+// placeholders are returned in the Pseudonyms column so the response
+// can be mapped back with ollama_depseudonymize().
+type pseudonymizer struct {
+	// Column name to placeholder kind.
+	columns map[string]string
+
+	// Lower cased value to placeholder, and placeholder to value.
+	placeholders map[string]string
+	mapping      *ordereddict.Dict
+	counts       map[string]int
+
+	// The distinct lengths of the known values, longest first, so
+	// text is matched against the map without scanning all values.
+	lengths []int
+}
+
+// Columns maps extra column names to placeholder kinds. Pseudonyms
+// from a previous call are reused so the placeholders stay the same
+// across calls.
+func newPseudonymizer(columns *ordereddict.Dict,
+	pseudonyms *ordereddict.Dict) (*pseudonymizer, error) {
+	result := &pseudonymizer{
+		columns:      make(map[string]string),
+		placeholders: make(map[string]string),
+		mapping:      ordereddict.NewDict(),
+		counts:       make(map[string]int),
+	}
+
+	for k, v := range default_pseudonym_columns {
+		result.columns[k] = v
+	}
+
+	if columns != nil {
+		for _, k := range columns.Keys() {
+			v, _ := columns.Get(k)
+			kind := strings.ToUpper(utils.ToString(v))
+			if !pseudonym_kind_regex.MatchString(kind) {
+				return nil, fmt.Errorf(
+					"pseudonymize_columns: kind for %v should be letters and digits not %q",
+					k, kind)
+			}
+			result.columns[strings.ToLower(k)] = kind
+		}
+	}
+
+	if pseudonyms != nil {
+		for _, placeholder := range pseudonyms.Keys() {
+			match := pseudonym_placeholder_regex.FindStringSubmatch(placeholder)
+			if match == nil || match[0] != placeholder {
+				return nil, fmt.Errorf("pseudonyms: invalid placeholder %q",
+					placeholder)
+			}
+
+			value, _ := pseudonyms.Get(placeholder)
+			value_str := utils.ToString(value)
+			result.mapping.Set(placeholder, value_str)
+			result.addKnown(strings.ToLower(value_str), placeholder)
+
+			count, _ := strconv.Atoi(match[2])
+			if count > result.counts[match[1]] {
+				result.counts[match[1]] = count
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// The placeholders used so far.
+func (self *pseudonymizer) Pseudonyms() *ordereddict.Dict {
+	result := ordereddict.NewDict()
+	for _, k := range self.mapping.Keys() {
+		v, _ := self.mapping.Get(k)
+		result.Set(k, v)
+	}
+	return result
+}
+
+// Returns a copy of the row with identifying values replaced. Values
+// of identifying columns are learned first so they are also replaced
+// where they appear in other columns (e.g. a user name in a path).
+func (self *pseudonymizer) Row(row *ordereddict.Dict) *ordereddict.Dict {
+	self.learn("", row, 0)
+	result, _ := self.replace(row, 0).(*ordereddict.Dict)
+	return result
+}
+
+func (self *pseudonymizer) learn(column string, value interface{}, depth int) {
+	if depth > 10 {
+		return
+	}
+
+	switch t := value.(type) {
+	case *ordereddict.Dict:
+		if t == nil {
+			return
+		}
+		for _, k := range t.Keys() {
+			v, _ := t.Get(k)
+			self.learn(k, v, depth+1)
+		}
+
+	case string:
+		// Flattened columns are named by their path.
+		name := strings.ToLower(column)
+		idx := strings.LastIndex(name, ".")
+		kind, pres := self.columns[name[idx+1:]]
+		if pres {
+			self.placeholder(kind, strings.TrimSpace(t))
+		}
+
+	case []byte:
+
+	default:
+		slice := reflect.ValueOf(value)
+		if slice.Kind() == reflect.Slice {
+			for idx := 0; idx < slice.Len(); idx++ {
+				self.learn(column, slice.Index(idx).Interface(), depth+1)
+			}
+		}
+	}
+}
+
+func (self *pseudonymizer) replace(value interface{}, depth int) interface{} {
+	if depth > 10 {
+		return value
+	}
+
+	switch t := value.(type) {
+	case *ordereddict.Dict:
+		if t == nil {
+			return value
+		}
+		result := ordereddict.NewDict()
+		for _, k := range t.Keys() {
+			v, _ := t.Get(k)
+			result.Set(k, self.replace(v, depth+1))
+		}
+		return result
+
+	case string:
+		return self.Text(t)
+
+	case []byte:
+		return value
+
+	default:
+		slice := reflect.ValueOf(value)
+		if slice.Kind() == reflect.Slice {
+			result := make([]interface{}, 0, slice.Len())
+			for idx := 0; idx < slice.Len(); idx++ {
+				result = append(result,
+					self.replace(slice.Index(idx).Interface(), depth+1))
+			}
+			return result
+		}
+	}
+	return value
+}
+
+// Replace the known values and any IP addresses in the text.
+func (self *pseudonymizer) Text(text string) string {
+	text = self.replaceKnown(text)
+
+	text = replaceWords(pseudonym_ipv6_regex, text, func(match string) string {
+		// The match may run into the punctuation ending a sentence.
+		address := strings.TrimRight(match, ".")
+		ip := net.ParseIP(address)
+		if ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+			return match
+		}
+		return self.placeholder(PSEUDONYM_IP, address) + match[len(address):]
+	})
+
+	return pseudonym_ip_regex.ReplaceAllStringFunc(text, func(match string) string {
+		ip := net.ParseIP(match)
+		if ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+			return match
+		}
+		return self.placeholder(PSEUDONYM_IP, match)
+	})
+}
+
+// Returns the value's placeholder, allocating one if needed.
+func (self *pseudonymizer) placeholder(kind, value string) string {
+	if len(value) < MIN_PSEUDONYM_LENGTH {
+		return value
+	}
+
+	key := strings.ToLower(value)
+	placeholder, pres := self.placeholders[key]
+	if pres {
+		return placeholder
+	}
+
+	self.counts[kind]++
+	placeholder = fmt.Sprintf("%s_%d", kind, self.counts[kind])
+	self.addKnown(key, placeholder)
+	self.mapping.Set(placeholder, value)
+	return placeholder
+}
+
+func (self *pseudonymizer) addKnown(key, placeholder string) {
+	self.placeholders[key] = placeholder
+
+	// Shorter values are never replaced in text.
+	if len(key) < MIN_PSEUDONYM_LENGTH {
+		return
+	}
+
+	idx := sort.Search(len(self.lengths), func(i int) bool {
+		return self.lengths[i] <= len(key)
+	})
+	if idx < len(self.lengths) && self.lengths[idx] == len(key) {
+		return
+	}
+	self.lengths = append(self.lengths, 0)
+	copy(self.lengths[idx+1:], self.lengths[idx:])
+	self.lengths[idx] = len(key)
+}
+
+// Replace known values which are not part of a longer word, so a
+// user alice does not match malice. At each word start the text is
+// looked up for each length of known value, longest first so a value
+// containing another is replaced whole. This takes time proportional
+// to the text and the number of distinct lengths rather than the
+// number of values.
+func (self *pseudonymizer) replaceKnown(text string) string {
+	if len(self.lengths) == 0 {
+		return text
+	}
+
+	result := &strings.Builder{}
+	last := 0
+	for start := 0; start < len(text); {
+		_, size := utf8.DecodeRuneInString(text[start:])
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		if isWordRune(before) {
+			start += size
+			continue
+		}
+
+		matched := 0
+		for _, length := range self.lengths {
+			end := start + length
+			if end > len(text) ||
+				(end < len(text) && !utf8.RuneStart(text[end])) {
+				continue
+			}
+
+			after, _ := utf8.DecodeRuneInString(text[end:])
+			if isWordRune(after) {
+				continue
+			}
+
+			placeholder, pres := self.placeholders[strings.ToLower(text[start:end])]
+			if pres {
+				result.WriteString(text[last:start])
+				result.WriteString(placeholder)
+				last = end
+				matched = length
+				break
+			}
+		}
+
+		if matched > 0 {
+			start += matched
+		} else {
+			start += size
+		}
+	}
+	result.WriteString(text[last:])
+	return result.String()
+}
+
+// Replace matches which are not part of a longer word.
+func replaceWords(regex *regexp.Regexp, text string,
+	replacement func(match string) string) string {
+	result := &strings.Builder{}
+	last := 0
+	for _, match := range regex.FindAllStringIndex(text, -1) {
+		start, end := match[0], match[1]
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if isWordRune(before) || isWordRune(after) {
+			continue
+		}
+
+		result.WriteString(text[last:start])
+		result.WriteString(replacement(text[start:end]))
+		last = end
+	}
+	result.WriteString(text[last:])
+	return result.String()
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Replace the placeholders in the value with the values they stand
+// for. Strings in nested dicts and lists are also restored.
+func depseudonymize(value interface{}, pseudonyms *ordereddict.Dict,
+	depth int) interface{} {
+	if depth > 10 {
+		return value
+	}
+
+	switch t := value.(type) {
+	case string:
+		return pseudonym_placeholder_regex.ReplaceAllStringFunc(t,
+			func(placeholder string) string {
+				original, pres := pseudonyms.Get(placeholder)
+				if !pres {
+					return placeholder
+				}
+				return utils.ToString(original)
+			})
+
+	case *ordereddict.Dict:
+		if t == nil {
+			return value
+		}
+		result := ordereddict.NewDict()
+		for _, k := range t.Keys() {
+			v, _ := t.Get(k)
+			result.Set(k, depseudonymize(v, pseudonyms, depth+1))
+		}
+		return result
+
+	case []byte:
+		return value
+
+	default:
+		slice := reflect.ValueOf(value)
+		if slice.Kind() == reflect.Slice {
+			result := make([]interface{}, 0, slice.Len())
+			for idx := 0; idx < slice.Len(); idx++ {
+				result = append(result, depseudonymize(
+					slice.Index(idx).Interface(), pseudonyms, depth+1))
+			}
+			return result
+		}
+	}
+	return value
+}
+
+type DepseudonymizeFunctionArgs struct {
+	Value      vfilter.Any       `vfilter:"required,field=value,doc=The response (or parsed response) to restore."`
+	Pseudonyms *ordereddict.Dict `vfilter:"required,field=pseudonyms,doc=The Pseudonyms column of the ollama() row."`
+}
+
+// Maps the placeholders in a response back to the real values for
+// the analyst. This only happens on the server so the model never
+// sees the real values.
+type DepseudonymizeFunction struct{}
+
+func (self DepseudonymizeFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("ollama_depseudonymize", args)()
+
+	arg := &DepseudonymizeFunctionArgs{}
+	err := arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("ollama_depseudonymize: %v", err)
+		return vfilter.Null{}
+	}
+
+	return depseudonymize(arg.Value, arg.Pseudonyms, 0)
+}
+
+func (self DepseudonymizeFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:    "ollama_depseudonymize",
+		Doc:     "Replace the placeholders in an ollama() response with the values they stand for.",
+		ArgType: type_map.AddType(scope, &DepseudonymizeFunctionArgs{}),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&DepseudonymizeFunction{})
+}
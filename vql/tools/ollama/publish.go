@@ -0,0 +1,188 @@
+package ollama
+
+import (
+	"context"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	FINDINGS_ARTIFACT = "Server.Internal.AIFindings"
+)
+
+// Columns of the analysis row which are not kept in Details.
+var finding_columns = append([]string{"ClientId", "FlowId"}, verdict_columns...)
+
+type OllamaPublishPluginArgs struct {
+	Query       vfilter.StoredQuery `vfilter:"required,field=query,doc=The AI analysis rows to publish (e.g. from ollama_email() or ollama_binary())."`
+	Artifact    string              `vfilter:"optional,field=artifact,doc=The server event queue to publish to (default Server.Internal.AIFindings)."`
+	MinSeverity string              `vfilter:"optional,field=min_severity,doc=Only publish findings at or above this severity (default info)."`
+	ClientId    string              `vfilter:"optional,field=client_id,doc=The client the findings are about (default the ClientId column)."`
+	FlowId      string              `vfilter:"optional,field=flow_id,doc=The flow the findings came from (default the FlowId column)."`
+}
+
+// Publishes AI verdicts to a server event queue so monitoring
+// artifacts, escalation rules and API clients can subscribe to them
+// with watch_monitoring(). Each row is published with the standard
+// verdict columns and the rest of the row in Details. Rows without a
+// verdict (e.g. failed model calls) are not published.
+type OllamaPublishPlugin struct{}
+
+func (self OllamaPublishPlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("ollama_publish", args)()
+
+		arg := &OllamaPublishPluginArgs{}
+		err := arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+		if err != nil {
+			scope.Log("ollama_publish: %v", err)
+			return
+		}
+
+		if arg.Artifact == "" {
+			arg.Artifact = FINDINGS_ARTIFACT
+		}
+
+		// Same permissions as send_event()
+		err = vql_subsystem.CheckAccess(scope, acls.SERVER_ADMIN)
+		if err != nil {
+			err = vql_subsystem.CheckAccessWithArgs(
+				scope, acls.PUBLISH, arg.Artifact)
+			if err != nil {
+				scope.Log("ollama_publish: %v", err)
+				return
+			}
+		}
+
+		min_rank := 0
+		if arg.MinSeverity != "" {
+			min_rank, err = severityRank(arg.MinSeverity)
+			if err != nil {
+				scope.Log("ollama_publish: min_severity: %v", err)
+				return
+			}
+		}
+
+		config_obj, ok := vql_subsystem.GetServerConfig(scope)
+		if !ok {
+			scope.Log("ollama_publish: Command can only run on the server")
+			return
+		}
+
+		journal, err := services.GetJournal(config_obj)
+		if err != nil {
+			scope.Log("ollama_publish: %v", err)
+			return
+		}
+
+		for row := range arg.Query.Eval(ctx, scope) {
+			finding := findingFromRow(ctx, scope,
+				vfilter.RowToDict(ctx, scope, row), arg)
+			if finding == nil {
+				continue
+			}
+
+			severity, _ := finding.GetString("Severity")
+			rank, err := severityRank(severity)
+			if err != nil || rank < min_rank {
+				continue
+			}
+
+			err = journal.PushRowsToArtifact(ctx, config_obj,
+				[]*ordereddict.Dict{finding}, arg.Artifact, "server", "")
+			if err != nil {
+				scope.Log("ollama_publish: %v", err)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case output_chan <- finding:
+			}
+		}
+	}()
+
+	return output_chan
+}
+
+// Build the finding from an analysis row, or nil if the row has no
+// verdict.
+func findingFromRow(ctx context.Context, scope vfilter.Scope,
+	row *ordereddict.Dict, arg *OllamaPublishPluginArgs) *ordereddict.Dict {
+	verdict, _ := row.GetString("Verdict")
+	if verdict == "" {
+		return nil
+	}
+
+	client_id := arg.ClientId
+	if client_id == "" {
+		client_id, _ = row.GetString("ClientId")
+	}
+
+	flow_id := arg.FlowId
+	if flow_id == "" {
+		flow_id, _ = row.GetString("FlowId")
+	}
+
+	result := ordereddict.NewDict().
+		Set("Timestamp", utils.GetTime().Now().UTC())
+
+	for _, column := range verdict_columns {
+		value, pres := row.Get(column)
+		if !pres || utils.IsNil(value) {
+			value = vfilter.Null{}
+		}
+		result.Set(column, value)
+	}
+
+	// Findings always carry a severity so consumers can filter on
+	// it.
+	if utils.IsNil(utils.GetAny(result, "Severity")) {
+		severity := verdict_severities[verdict]
+		if severity == "" {
+			return nil
+		}
+		result.Update("Severity", severity)
+	}
+
+	details := ordereddict.NewDict()
+	for _, k := range row.Keys() {
+		if utils.InString(finding_columns, k) {
+			continue
+		}
+		v, _ := row.Get(k)
+		details.Set(k, normalizeNested(ctx, scope, v, 0))
+	}
+
+	return result.
+		Set("ClientId", client_id).
+		Set("FlowId", flow_id).
+		Set("Details", details)
+}
+
+func (self OllamaPublishPlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name:     "ollama_publish",
+		Doc:      "Publish AI verdicts as findings to a server event queue.",
+		ArgType:  type_map.AddType(scope, &OllamaPublishPluginArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.SERVER_ADMIN, acls.PUBLISH).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterPlugin(&OllamaPublishPlugin{})
+}
@@ -0,0 +1,404 @@
+package ollama
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	api_proto "www.velocidex.com/golang/velociraptor/api/proto"
+	flows_proto "www.velocidex.com/golang/velociraptor/flows/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/logging"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/velociraptor/vql/acl_managers"
+	"www.velocidex.com/golang/velociraptor/vtesting/assert"
+)
+
+var (
+	recommendArtifact = `
+name: Custom.Test.Recommend
+description: |
+  Lists the files in a directory.
+
+  More details which are not sent to the model.
+parameters:
+- name: Path
+  description: The directory to list.
+  default: C:/Windows
+sources:
+- query: SELECT * FROM glob(globs="*", root=Path)
+`
+)
+
+func (self *OllamaTestSuite) TestSaveAnalysis() {
+	launcher, err := services.GetLauncher(self.ConfigObj)
+	assert.NoError(self.T(), err)
+
+	err = launcher.Storage().WriteFlow(self.Ctx, self.ConfigObj,
+		&flows_proto.ArtifactCollectorContext{
+			ClientId:  "server",
+			SessionId: "F.1234",
+		}, utils.SyncCompleter)
+	assert.NoError(self.T(), err)
+
+	rows := self.run(`
+LET _ <= ollama_save_analysis(flow_id="F.1234", model="llama3",
+   verdict="suspicious", summary="Unusual service installed",
+   details=dict(Services=["evil", "backdoor"]))
+
+LET _ <= ollama_save_analysis(flow_id="F.9999", summary="Not saved")
+
+SELECT Model, Verdict, Summary, Details FROM ollama_analyses(flow_id="F.1234")`)
+	assert.Equal(self.T(), 1, len(rows))
+	assert.Equal(self.T(), `{"Model":"llama3","Verdict":"suspicious",`+
+		`"Summary":"Unusual service installed","Details":{"Services":["evil","backdoor"]}}`,
+		json.MustMarshalString(rows[0]))
+
+	// Analyses are only attached to flows which exist.
+	rows = self.run(`SELECT * FROM ollama_analyses(flow_id="F.9999")`)
+	assert.Equal(self.T(), 0, len(rows))
+}
+
+func (self *OllamaTestSuite) TestAnnotateTimeline() {
+	self.LoadArtifacts(`
+name: Notebooks.Default
+type: NOTEBOOK
+`)
+
+	notebook_manager, err := services.GetNotebookManager(self.ConfigObj)
+	assert.NoError(self.T(), err)
+
+	notebook, err := notebook_manager.NewNotebook(self.Ctx, "admin",
+		&api_proto.NotebookMetadata{Name: "Case"})
+	assert.NoError(self.T(), err)
+
+	rows := self.run(fmt.Sprintf(`
+LET Analyses = SELECT * FROM foreach(row=[
+  dict(Timestamp="2024-01-02T10:00:00Z", FlowId="F.1234",
+       ClientId="C.123", Model="llama3", Verdict="suspicious",
+       Summary="Unusual service installed"),
+  dict(Timestamp="2024-01-02T09:00:00Z", FlowId="F.1235",
+       Response="Persistence added through a run key"),
+  dict(FlowId="F.1236", Summary="No time so skipped")])
+
+SELECT * FROM ollama_annotate_timeline(
+   notebook_id=%q, timeline="Case", query=Analyses)`, notebook.NotebookId))
+	assert.Equal(self.T(), 2, len(rows))
+
+	// The annotations are sorted into the timeline with their flows.
+	rows = self.run(fmt.Sprintf(`
+SELECT Timestamp, Message, Source, FlowId, Notes
+FROM timeline(notebook_id=%q, timeline="Case")`, notebook.NotebookId))
+	assert.Equal(self.T(), 2, len(rows))
+
+	golden := ""
+	for _, row := range rows {
+		golden += json.MustMarshalString(row) + "\n"
+	}
+	assert.Equal(self.T(), `{"Timestamp":"2024-01-02T09:00:00Z",`+
+		`"Message":"Persistence added through a run key","Source":"AI",`+
+		`"FlowId":"F.1235","Notes":"Persistence added through a run key"}
+{"Timestamp":"2024-01-02T10:00:00Z","Message":"Unusual service installed",`+
+		`"Source":"AI","FlowId":"F.1234","Notes":"Unusual service installed"}
+`, golden)
+}
+
+func (self *OllamaTestSuite) TestWebhook() {
+	old_delay := webhook_retry_delay
+	webhook_retry_delay = time.Millisecond
+	defer func() { webhook_retry_delay = old_delay }()
+
+	var mu sync.Mutex
+	var bodies []string
+	attempts := 0
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			attempts++
+			body, _ := io.ReadAll(r.Body)
+
+			// The first attempt fails and must be retried.
+			if attempts == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			expected := "sha256=" + signWebhook("key",
+				r.Header.Get(WEBHOOK_TIMESTAMP_HEADER), body)
+			if r.Header.Get(DEFAULT_SIGNATURE_HEADER) != expected ||
+				r.Header.Get("X-Api-Key") != "1234" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			bodies = append(bodies, string(body))
+			w.Write([]byte("accepted"))
+		}))
+	defer server.Close()
+
+	rows := self.run(fmt.Sprintf(`
+SELECT * FROM ollama_webhook(url=%q, hmac_key="key",
+   headers=dict(`+"`X-Api-Key`"+`="1234"),
+   query={ SELECT "malicious" AS Verdict, "F.1234" AS FlowId FROM scope() })`,
+		server.URL))
+	assert.Equal(self.T(), 1, len(rows))
+
+	attempt_count, _ := rows[0].Get("Attempts")
+	status, _ := rows[0].Get("StatusCode")
+	response, _ := rows[0].Get("Response")
+	assert.Equal(self.T(), int64(2), attempt_count)
+	assert.Equal(self.T(), 200, status)
+	assert.Equal(self.T(), "accepted", response)
+	assert.Equal(self.T(), []string{`{"Verdict":"malicious","FlowId":"F.1234"}`}, bodies)
+
+	// Requests with a bad signature are rejected and not retried.
+	rows = self.run(fmt.Sprintf(`
+SELECT * FROM ollama_webhook(url=%q, hmac_key="wrong",
+   query={ SELECT "benign" AS Verdict FROM scope() })`, server.URL))
+	assert.Equal(self.T(), 1, len(rows))
+
+	attempt_count, _ = rows[0].Get("Attempts")
+	status, _ = rows[0].Get("StatusCode")
+	error_message, _ := rows[0].Get("Error")
+	assert.Equal(self.T(), int64(1), attempt_count)
+	assert.Equal(self.T(), 401, status)
+	assert.Equal(self.T(), "Webhook returned status 401", error_message)
+}
+
+func (self *OllamaTestSuite) TestNotify() {
+	var mu sync.Mutex
+	var bodies []string
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			body, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(body))
+			w.Write([]byte("ok"))
+		}))
+	defer server.Close()
+
+	query := `
+LET Verdicts = SELECT * FROM foreach(row=[
+   dict(Host="dc01", Verdict="malicious"),
+   dict(Host="web02", Verdict="benign")])
+`
+	rows := self.run(fmt.Sprintf(query+`
+SELECT * FROM ollama_notify(url=%q, title="AI verdicts",
+   template="{{.Host}} is {{.Verdict}}",
+   query={ SELECT * FROM Verdicts WHERE Verdict = "malicious" })`,
+		server.URL))
+	assert.Equal(self.T(), 1, len(rows))
+
+	message, _ := rows[0].Get("Message")
+	assert.Equal(self.T(), "dc01 is malicious", message)
+	assert.Equal(self.T(), []string{`{"text":"*AI verdicts*\ndc01 is malicious"}`}, bodies)
+
+	// A digest posts all rows in one message.
+	bodies = nil
+	rows = self.run(fmt.Sprintf(query+`
+SELECT * FROM ollama_notify(url=%q, format="teams", digest=TRUE,
+   title="Nightly digest", query={ SELECT * FROM Verdicts })`,
+		server.URL))
+	assert.Equal(self.T(), 1, len(rows))
+	assert.Equal(self.T(), []string{`{"@type":"MessageCard",` +
+		`"@context":"https://schema.org/extensions","title":"Nightly digest",` +
+		`"text":"Host: dc01  \nVerdict: malicious  \n  \nHost: web02  \nVerdict: benign"}`},
+		bodies)
+
+	// Nothing is posted without rows.
+	bodies = nil
+	rows = self.run(fmt.Sprintf(query+`
+SELECT * FROM ollama_notify(url=%q, digest=TRUE,
+   query={ SELECT * FROM Verdicts WHERE FALSE })`, server.URL))
+	assert.Equal(self.T(), 0, len(rows))
+	assert.Equal(self.T(), 0, len(bodies))
+}
+
+func (self *OllamaTestSuite) TestAlert() {
+	rows := self.run(`
+SELECT ollama_alert(verdict=Verdict, summary=Summary,
+                    client_id="C.1234", dedup_key=Key) AS Alert
+FROM foreach(row=[
+   dict(Verdict="malicious", Summary="Beacon to a known C2", Key="test_alert_1"),
+   dict(Verdict="suspicious", Summary="Unusual parent process", Key="test_alert_2"),
+   dict(Verdict="malicious", Summary="Beacon to a known C2", Key="test_alert_1")])`)
+	assert.Equal(self.T(), 3, len(rows))
+
+	// Only the first high severity alert is raised; the suspicious
+	// verdict is below the default minimum and the last is a
+	// duplicate.
+	alert, _ := rows[0].Get("Alert")
+	event_data, _ := alert.(*ordereddict.Dict).Get("EventData")
+	assert.Equal(self.T(), `{"Severity":"high","Verdict":"malicious",`+
+		`"Summary":"Beacon to a known C2","Model":"","ClientId":"C.1234",`+
+		`"FlowId":"","DedupKey":"test_alert_1","Details":null}`,
+		json.MustMarshalString(event_data))
+
+	for _, row := range rows[1:] {
+		alert, _ := row.Get("Alert")
+		assert.True(self.T(), utils.IsNil(alert))
+	}
+
+	// An explicit severity can lower the threshold.
+	rows = self.run(`
+SELECT ollama_alert(severity="medium", min_severity="medium",
+                    summary="Unusual parent process",
+                    dedup_key="test_alert_3").EventData.Severity AS Severity
+FROM scope()`)
+	assert.Equal(self.T(), 1, len(rows))
+	severity, _ := rows[0].Get("Severity")
+	assert.Equal(self.T(), "medium", severity)
+}
+
+func (self *OllamaTestSuite) TestPublish() {
+	self.LoadArtifacts(`
+name: Server.Internal.AIFindings
+type: SERVER_EVENT
+`)
+
+	journal, err := services.GetJournal(self.ConfigObj)
+	assert.NoError(self.T(), err)
+
+	events, cancel := journal.Watch(self.Ctx, FINDINGS_ARTIFACT, "test")
+	defer cancel()
+
+	// Rows below the minimum severity or without a verdict are not
+	// published.
+	rows := self.run(`
+SELECT Verdict, Severity, ClientId, Details
+FROM ollama_publish(min_severity="medium", query={
+  SELECT * FROM foreach(row=[
+    dict(Verdict="benign", Confidence=0.9, File="notepad.exe"),
+    dict(Error="Model call failed", File="broken.exe"),
+    dict(Verdict="malicious", Confidence=0.8, ClientId="C.1234",
+         File="evil.exe")])
+})`)
+	assert.Equal(self.T(), 1, len(rows))
+	assert.Equal(self.T(), `{"Verdict":"malicious","Severity":"high",`+
+		`"ClientId":"C.1234","Details":{"File":"evil.exe"}}`,
+		json.MustMarshalString(rows[0]))
+
+	select {
+	case event := <-events:
+		verdict, _ := event.GetString("Verdict")
+		assert.Equal(self.T(), "malicious", verdict)
+
+		confidence, _ := event.Get("Confidence")
+		assert.Equal(self.T(), 0.8, confidence)
+
+	case <-time.After(10 * time.Second):
+		self.T().Fatalf("No finding published")
+	}
+}
+
+func (self *OllamaTestSuite) TestRecommend() {
+	self.LoadArtifacts(recommendArtifact)
+
+	launcher, err := services.GetLauncher(self.ConfigObj)
+	assert.NoError(self.T(), err)
+	defer utils.SetFlowIdForTests("F.Recommended")()
+
+	rows := self.run(`
+SELECT Artifact, Parameters, Reason, Status
+FROM ollama_recommend(model="recommend", client_id="C.1234",
+   artifacts=["Custom.Test.Recommend", "Generic.Client.Info"],
+   query={ SELECT "dropper.exe" AS Name FROM scope() }, base_url=URL)`)
+	assert.Equal(self.T(), 1, len(rows))
+	assert.Equal(self.T(), `{"Artifact":"Custom.Test.Recommend",`+
+		`"Parameters":{"Path":"C:/Temp"},"Reason":"The dropper wrote to C:/Temp",`+
+		`"Status":"Pending"}`, json.MustMarshalString(rows[0]))
+
+	// The model only sees the first line of the description.
+	assert.Contains(self.T(), self.requests[0].Prompt, "Lists the files in a directory.")
+	assert.NotContains(self.T(), self.requests[0].Prompt, "More details")
+	assert.Contains(self.T(), self.requests[0].Prompt, "dropper.exe")
+
+	// Nothing is collected until the recommendation is approved.
+	rows = self.run(`
+LET Pending = SELECT * FROM ollama_recommendations(status="Pending")
+SELECT ollama_approve_recommendation(id=Pending[0].RecommendationId,
+                                     reason="Looks useful").FlowId AS FlowId,
+       ollama_approve_recommendation(id=Pending[0].RecommendationId) AS Again
+FROM scope()`)
+	assert.Equal(self.T(), 1, len(rows))
+
+	flow_id, _ := rows[0].Get("FlowId")
+	again, _ := rows[0].Get("Again")
+	assert.Equal(self.T(), "F.Recommended", flow_id)
+	assert.True(self.T(), utils.IsNil(again))
+
+	flow, err := launcher.GetFlowDetails(self.Ctx, self.ConfigObj,
+		services.GetFlowOptions{}, "C.1234", "F.Recommended")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), []string{"Custom.Test.Recommend"},
+		flow.Context.Request.Artifacts)
+
+	rows = self.run(`
+SELECT Status, DecisionReason, FlowId FROM ollama_recommendations(client_id="C.1234")`)
+	assert.Equal(self.T(), 1, len(rows))
+	assert.Equal(self.T(), `{"Status":"Approved","DecisionReason":"Looks useful",`+
+		`"FlowId":"F.Recommended"}`, json.MustMarshalString(rows[0]))
+}
+
+func (self *OllamaTestSuite) TestLabel() {
+	self.CreateClient("C.1234")
+
+	rows := self.run(`
+SELECT ollama_label(client_id="C.1234", category="persistence",
+   verdict=dict(Verdict="suspicious")) AS Suspicious,
+       ollama_label(client_id="C.1234", category="persistence",
+   verdict="malicious") AS Malicious
+FROM scope()`)
+	assert.Equal(self.T(), 1, len(rows))
+
+	suspicious, _ := rows[0].Get("Suspicious")
+	malicious, _ := rows[0].Get("Malicious")
+	assert.Equal(self.T(), `{"ClientId":"C.1234","Verdict":"suspicious",`+
+		`"Added":["ai-suspicious-persistence"],"Removed":null}`,
+		json.MustMarshalString(suspicious))
+	assert.Equal(self.T(), `{"ClientId":"C.1234","Verdict":"malicious",`+
+		`"Added":["ai-malicious-persistence"],"Removed":["ai-suspicious-persistence"]}`,
+		json.MustMarshalString(malicious))
+
+	labeler := services.GetLabeler(self.ConfigObj)
+	assert.True(self.T(), labeler.IsLabelSet(self.Ctx, self.ConfigObj,
+		"C.1234", "ai-malicious-persistence"))
+
+	// A benign verdict removes the labels.
+	rows = self.run(`
+SELECT ollama_label(client_id="C.1234", category="persistence",
+                    verdict="benign").Removed AS Removed
+FROM scope()`)
+	assert.Equal(self.T(), `[{"Removed":["ai-malicious-persistence"]}]`,
+		json.MustMarshalString(rows))
+
+	// Investigators may label clients but not from AI verdicts
+	// without the dedicated permission.
+	manager, err := services.GetRepositoryManager(self.ConfigObj)
+	assert.NoError(self.T(), err)
+
+	scope := manager.BuildScope(services.ScopeBuilder{
+		Config:     self.ConfigObj,
+		ACLManager: acl_managers.NewRoleACLManager(self.ConfigObj, "investigator"),
+		Logger: logging.NewPlainLogger(self.ConfigObj,
+			&logging.FrontendComponent),
+	})
+	defer scope.Close()
+
+	result := OllamaLabelFunction{}.Call(self.Ctx, scope, ordereddict.NewDict().
+		Set("client_id", "C.1234").
+		Set("verdict", "malicious"))
+	assert.True(self.T(), utils.IsNil(result))
+	assert.False(self.T(), labeler.IsLabelSet(self.Ctx, self.ConfigObj,
+		"C.1234", "ai-malicious"))
+}
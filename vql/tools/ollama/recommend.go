@@ -0,0 +1,714 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/artifacts"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/file_store"
+	flows_proto "www.velocidex.com/golang/velociraptor/flows/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/paths"
+	"www.velocidex.com/golang/velociraptor/result_sets"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/velociraptor/vql/acl_managers"
+	"www.velocidex.com/golang/velociraptor/vql/tools/collector"
+	vql_utils "www.velocidex.com/golang/velociraptor/vql/utils"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	RECOMMENDATION_PENDING = "Pending"
+
+	DEFAULT_MAX_RECOMMENDATIONS = 5
+	DEFAULT_RECOMMEND_ROWS      = 100
+
+	RECOMMEND_PROMPT = "Recommend follow up artifacts to collect from " +
+		"the client to confirm or rule out what the evidence below " +
+		"suggests. Only recommend artifacts from the list of " +
+		"available artifacts and only use their listed parameters. " +
+		"Respond with JSON: Recommendations lists at most %d " +
+		"artifacts, most useful first, each with the Artifact name, " +
+		"the Parameters to set and the Reason it should be collected."
+)
+
+var recommendation_schema = ordereddict.NewDict().
+	Set("type", "object").
+	Set("properties", ordereddict.NewDict().
+		Set("Recommendations", ordereddict.NewDict().
+			Set("type", "array").
+			Set("items", ordereddict.NewDict().
+				Set("type", "object").
+				Set("properties", ordereddict.NewDict().
+					Set("Artifact", ordereddict.NewDict().
+						Set("type", "string")).
+					Set("Parameters", ordereddict.NewDict().
+						Set("type", "object")).
+					Set("Reason", ordereddict.NewDict().
+						Set("type", "string"))).
+				Set("required", []string{"Artifact", "Reason"})))).
+	Set("required", []string{"Recommendations"})
+
+// Recommendations are changed by appending to a log so the mutex
+// serializes decisions within the server.
+var recommendations_mu sync.Mutex
+
+type OllamaRecommendPluginArgs struct {
+	ClientId           string              `vfilter:"required,field=client_id,doc=The client to recommend collections from."`
+	Query              vfilter.StoredQuery `vfilter:"optional,field=query,doc=The evidence the recommendations are based on (e.g. the results of a flow)."`
+	Prompt             string              `vfilter:"optional,field=prompt,doc=Additional instructions (e.g. what is being investigated)."`
+	Artifacts          []string            `vfilter:"optional,field=artifacts,doc=The artifacts which may be recommended (default all client artifacts)."`
+	MaxRecommendations int64               `vfilter:"optional,field=max_recommendations,doc=The most recommendations to make (default 5)."`
+	MaxRows            int64               `vfilter:"optional,field=max_rows,doc=The most query rows sent to the model (default 100)."`
+	Model              string              `vfilter:"optional,field=model,doc=The model to use (e.g. llama3). Defaults to AI.default_model in the config."`
+	BaseUrl            string              `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server. Several comma separated URLs, or srv://name for the servers of a DNS SRV record, spread the calls over the servers."`
+	Timeout            int64               `vfilter:"optional,field=timeout,doc=Seconds the call may take (default 3600)."`
+	Options            *ordereddict.Dict   `vfilter:"optional,field=options,doc=Model options (e.g. temperature, num_ctx)."`
+	KeepAlive          string              `vfilter:"optional,field=keep_alive,doc=How long the model stays loaded after the call (e.g. 5m)."`
+}
+
+// Asks the model which artifacts to collect next from a client. The
+// recommendations are only stored as pending: nothing is collected
+// until a user approves them with ollama_approve_recommendation().
+type OllamaRecommendPlugin struct{}
+
+func (self OllamaRecommendPlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("ollama_recommend", args)()
+
+		err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+		if err != nil {
+			scope.Log("ollama_recommend: %v", err)
+			return
+		}
+
+		arg := &OllamaRecommendPluginArgs{}
+		err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+		if err != nil {
+			scope.Log("ollama_recommend: %v", err)
+			return
+		}
+
+		if arg.MaxRecommendations <= 0 {
+			arg.MaxRecommendations = DEFAULT_MAX_RECOMMENDATIONS
+		}
+
+		if arg.MaxRows <= 0 {
+			arg.MaxRows = DEFAULT_RECOMMEND_ROWS
+		}
+
+		config_obj, ok := vql_subsystem.GetServerConfig(scope)
+		if !ok {
+			scope.Log("ollama_recommend: Command can only run on the server")
+			return
+		}
+
+		repository, err := vql_utils.GetRepository(scope)
+		if err != nil {
+			scope.Log("ollama_recommend: %v", err)
+			return
+		}
+
+		candidates, err := candidateArtifacts(ctx, config_obj,
+			repository, arg.Artifacts)
+		if err != nil {
+			scope.Log("ollama_recommend: %v", err)
+			return
+		}
+
+		var evidence []*ordereddict.Dict
+		if arg.Query != nil {
+			for row := range arg.Query.Eval(ctx, scope) {
+				if int64(len(evidence)) >= arg.MaxRows {
+					break
+				}
+				evidence = append(evidence, promptRow(ctx, scope, row))
+			}
+		}
+
+		client, err := NewClient(ctx, scope, arg.BaseUrl)
+		if err != nil {
+			scope.Log("ollama_recommend: %v", err)
+			return
+		}
+
+		arg.Model, err = client.Model(arg.Model)
+		if err != nil {
+			scope.Log("ollama_recommend: %v", err)
+			return
+		}
+
+		client = client.WithTimeouts(Timeouts{
+			Total: time.Duration(arg.Timeout) * time.Second,
+		})
+		generate := logGenerate(scope, client, client.Generate)
+
+		recommendations, err := self.recommend(ctx, scope, arg,
+			candidates, evidence, generate)
+		if err != nil {
+			scope.Log("ollama_recommend: %v", err)
+			return
+		}
+
+		principal := vql_subsystem.GetPrincipal(scope)
+		for _, item := range recommendations {
+			name, _ := item.GetString("Artifact")
+			artifact, pres := candidates[name]
+			if !pres {
+				scope.Log("ollama_recommend: Ignoring recommendation of unavailable artifact %v",
+					name)
+				continue
+			}
+
+			reason, _ := item.GetString("Reason")
+			row := ordereddict.NewDict().
+				Set("RecommendationId", "R."+utils.NextId()).
+				Set("Time", utils.GetTime().Now().UTC()).
+				Set("Action", RECOMMENDATION_PENDING).
+				Set("Principal", principal).
+				Set("ClientId", arg.ClientId).
+				Set("Artifact", name).
+				Set("Parameters", recommendedParameters(scope, artifact, item)).
+				Set("Reason", reason).
+				Set("Model", arg.Model)
+
+			recommendations_mu.Lock()
+			err = appendRecommendation(config_obj, row)
+			recommendations_mu.Unlock()
+			if err != nil {
+				scope.Log("ollama_recommend: %v", err)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case output_chan <- recommendationState(row):
+			}
+		}
+	}()
+
+	return output_chan
+}
+
+func (self OllamaRecommendPlugin) recommend(ctx context.Context,
+	scope vfilter.Scope, arg *OllamaRecommendPluginArgs,
+	candidates map[string]*candidateArtifact,
+	evidence []*ordereddict.Dict,
+	generate generateFunc) ([]*ordereddict.Dict, error) {
+	names := make([]string, 0, len(candidates))
+	for name := range candidates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	available := make([]*candidateArtifact, 0, len(names))
+	for _, name := range names {
+		available = append(available, candidates[name])
+	}
+
+	serialized_available, err := json.MarshalIndent(available)
+	if err != nil {
+		return nil, err
+	}
+
+	serialized_evidence, err := json.MarshalIndent(evidence)
+	if err != nil {
+		return nil, err
+	}
+
+	prompt := fmt.Sprintf(RECOMMEND_PROMPT, arg.MaxRecommendations)
+	if arg.Prompt != "" {
+		prompt += "\n\n" + arg.Prompt
+	}
+	prompt += "\n\nAvailable artifacts:\n" + string(serialized_available) +
+		"\n\nEvidence:\n" + string(serialized_evidence)
+
+	req := &GenerateRequest{
+		Model:     arg.Model,
+		Prompt:    prompt,
+		Format:    recommendation_schema,
+		Options:   arg.Options,
+		KeepAlive: arg.KeepAlive,
+	}
+
+	response, err := generateText(ctx, generate, req)
+	if err != nil {
+		return nil, err
+	}
+
+	validator, err := newResponseValidator(recommendation_schema, true)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := validator.Repair(ctx, scope, generate, req,
+		response, nil, DEFAULT_REPAIR_ATTEMPTS)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, ok := result.Parsed.(*ordereddict.Dict)
+	if !ok || len(result.Errors) > 0 {
+		return nil, fmt.Errorf("Invalid recommendations: %v",
+			strings.Join(result.Errors, "; "))
+	}
+
+	items_any, _ := parsed.Get("Recommendations")
+	items, _ := items_any.([]interface{})
+
+	var recommendations []*ordereddict.Dict
+	for _, item := range items {
+		dict, ok := item.(*ordereddict.Dict)
+		if ok {
+			recommendations = append(recommendations, dict)
+		}
+	}
+
+	if int64(len(recommendations)) > arg.MaxRecommendations {
+		recommendations = recommendations[:arg.MaxRecommendations]
+	}
+	return recommendations, nil
+}
+
+type candidateParameter struct {
+	Name        string
+	Type        string `json:",omitempty"`
+	Default     string `json:",omitempty"`
+	Description string `json:",omitempty"`
+}
+
+// What the model is told about an artifact it may recommend.
+type candidateArtifact struct {
+	Name        string
+	Description string                `json:",omitempty"`
+	Parameters  []*candidateParameter `json:",omitempty"`
+}
+
+// Only client artifacts may be recommended.
+func candidateArtifacts(ctx context.Context,
+	config_obj *config_proto.Config,
+	repository services.Repository,
+	names []string) (map[string]*candidateArtifact, error) {
+	if len(names) == 0 {
+		all, err := repository.List(ctx, config_obj)
+		if err != nil {
+			return nil, err
+		}
+		names = all
+	}
+
+	result := make(map[string]*candidateArtifact)
+	for _, name := range names {
+		artifact, pres := repository.Get(ctx, config_obj, name)
+		if !pres {
+			return nil, fmt.Errorf("Unknown artifact %v", name)
+		}
+
+		if artifact.Type != "client" {
+			continue
+		}
+
+		// Only the first line of descriptions is kept to keep the
+		// prompt small.
+		description := strings.TrimSpace(artifact.Description)
+		description, _, _ = strings.Cut(description, "\n")
+
+		candidate := &candidateArtifact{
+			Name:        artifact.Name,
+			Description: utils.Elide(description, 200),
+		}
+		for _, parameter := range artifact.Parameters {
+			parameter_description, _, _ := strings.Cut(
+				strings.TrimSpace(parameter.Description), "\n")
+			candidate.Parameters = append(candidate.Parameters,
+				&candidateParameter{
+					Name:        parameter.Name,
+					Type:        parameter.Type,
+					Default:     utils.Elide(parameter.Default, 200),
+					Description: utils.Elide(parameter_description, 200),
+				})
+		}
+		result[artifact.Name] = candidate
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("No client artifacts are available to recommend")
+	}
+	return result, nil
+}
+
+// Keep only the parameters the artifact declares.
+func recommendedParameters(scope vfilter.Scope,
+	artifact *candidateArtifact, item *ordereddict.Dict) *ordereddict.Dict {
+	result := ordereddict.NewDict()
+
+	parameters_any, _ := item.Get("Parameters")
+	parameters, ok := parameters_any.(*ordereddict.Dict)
+	if !ok {
+		return result
+	}
+
+	for _, k := range parameters.Keys() {
+		declared := false
+		for _, parameter := range artifact.Parameters {
+			if parameter.Name == k {
+				declared = true
+				break
+			}
+		}
+
+		if !declared {
+			scope.Log("ollama_recommend: Ignoring unknown parameter %v of %v",
+				k, artifact.Name)
+			continue
+		}
+
+		v, _ := parameters.Get(k)
+		result.Set(k, utils.ToString(v))
+	}
+	return result
+}
+
+func appendRecommendation(
+	config_obj *config_proto.Config, row *ordereddict.Dict) error {
+	rs_writer, err := result_sets.NewResultSetWriter(
+		file_store.GetFileStore(config_obj),
+		paths.LLMPathManager{}.Recommendations(),
+		json.DefaultEncOpts(), utils.SyncCompleter, result_sets.AppendMode)
+	if err != nil {
+		return err
+	}
+	rs_writer.Write(row)
+	rs_writer.Close()
+	return nil
+}
+
+// Replay the log of recommendations and decisions into the current
+// state of each recommendation, oldest first.
+func loadRecommendations(ctx context.Context,
+	config_obj *config_proto.Config) ([]*ordereddict.Dict, error) {
+	reader, err := result_sets.NewResultSetReader(
+		file_store.GetFileStore(config_obj),
+		paths.LLMPathManager{}.Recommendations())
+	if err != nil {
+		// Nothing was recommended yet.
+		return nil, nil
+	}
+	defer reader.Close()
+
+	var result []*ordereddict.Dict
+	by_id := make(map[string]*ordereddict.Dict)
+
+	for row := range reader.Rows(ctx) {
+		id, _ := row.GetString("RecommendationId")
+		action, _ := row.GetString("Action")
+
+		if action == RECOMMENDATION_PENDING {
+			state := recommendationState(row)
+			by_id[id] = state
+			result = append(result, state)
+			continue
+		}
+
+		state, pres := by_id[id]
+		if !pres {
+			continue
+		}
+
+		principal, _ := row.GetString("Principal")
+		decision_time, _ := row.Get("Time")
+		decision_reason, _ := row.GetString("Reason")
+		flow_id, _ := row.GetString("FlowId")
+		state.Set("Status", action).
+			Set("DecidedBy", principal).
+			Set("DecisionTime", decision_time).
+			Set("DecisionReason", decision_reason).
+			Set("FlowId", flow_id)
+	}
+	return result, nil
+}
+
+func recommendationState(row *ordereddict.Dict) *ordereddict.Dict {
+	result := ordereddict.NewDict()
+	for _, k := range []string{"RecommendationId", "Time", "ClientId",
+		"Artifact", "Parameters", "Reason", "Model"} {
+		v, _ := row.Get(k)
+		result.Set(k, v)
+	}
+
+	principal, _ := row.Get("Principal")
+	return result.
+		Set("RecommendedBy", principal).
+		Set("Status", RECOMMENDATION_PENDING).
+		Set("DecidedBy", "").
+		Set("DecisionTime", vfilter.Null{}).
+		Set("DecisionReason", "").
+		Set("FlowId", "")
+}
+
+type OllamaRecommendationsPluginArgs struct {
+	ClientId string `vfilter:"optional,field=client_id,doc=Only show recommendations for this client."`
+	Status   string `vfilter:"optional,field=status,doc=Only show recommendations with this status (Pending, Approved or Denied)."`
+}
+
+// Shows the recommended collections and what was decided about them.
+type OllamaRecommendationsPlugin struct{}
+
+func (self OllamaRecommendationsPlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("ollama_recommendations", args)()
+
+		err := vql_subsystem.CheckAccess(scope, acls.READ_RESULTS)
+		if err != nil {
+			scope.Log("ollama_recommendations: %v", err)
+			return
+		}
+
+		arg := &OllamaRecommendationsPluginArgs{}
+		err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+		if err != nil {
+			scope.Log("ollama_recommendations: %v", err)
+			return
+		}
+
+		config_obj, ok := vql_subsystem.GetServerConfig(scope)
+		if !ok {
+			scope.Log("ollama_recommendations: Command can only run on the server")
+			return
+		}
+
+		recommendations, err := loadRecommendations(ctx, config_obj)
+		if err != nil {
+			scope.Log("ollama_recommendations: %v", err)
+			return
+		}
+
+		for _, row := range recommendations {
+			client_id, _ := row.GetString("ClientId")
+			if arg.ClientId != "" && client_id != arg.ClientId {
+				continue
+			}
+
+			status, _ := row.GetString("Status")
+			if arg.Status != "" && !strings.EqualFold(status, arg.Status) {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case output_chan <- row:
+			}
+		}
+	}()
+
+	return output_chan
+}
+
+type ApproveRecommendationFunctionArgs struct {
+	RecommendationId string `vfilter:"required,field=id,doc=The recommendation to decide on."`
+	Deny             bool   `vfilter:"optional,field=deny,doc=Deny the recommendation instead of approving it."`
+	Reason           string `vfilter:"optional,field=reason,doc=The reason for the decision."`
+}
+
+// Approving a recommendation schedules the collection as the
+// approver, so it is subject to their permissions.
+type ApproveRecommendationFunction struct{}
+
+func (self ApproveRecommendationFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("ollama_approve_recommendation", args)()
+
+	err := vql_subsystem.CheckAccess(scope, acls.COLLECT_CLIENT)
+	if err != nil {
+		scope.Log("ollama_approve_recommendation: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg := &ApproveRecommendationFunctionArgs{}
+	err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("ollama_approve_recommendation: %v", err)
+		return vfilter.Null{}
+	}
+
+	config_obj, ok := vql_subsystem.GetServerConfig(scope)
+	if !ok {
+		scope.Log("ollama_approve_recommendation: Command can only run on the server")
+		return vfilter.Null{}
+	}
+
+	recommendations_mu.Lock()
+	defer recommendations_mu.Unlock()
+
+	recommendation, err := getRecommendation(ctx, config_obj,
+		arg.RecommendationId)
+	if err != nil {
+		scope.Log("ollama_approve_recommendation: %v", err)
+		return vfilter.Null{}
+	}
+
+	principal := vql_subsystem.GetPrincipal(scope)
+	row := ordereddict.NewDict().
+		Set("RecommendationId", arg.RecommendationId).
+		Set("Time", utils.GetTime().Now().UTC()).
+		Set("Action", APPROVAL_DENIED).
+		Set("Principal", principal).
+		Set("Reason", arg.Reason).
+		Set("FlowId", "")
+
+	if !arg.Deny {
+		flow_id, err := scheduleRecommendation(ctx, scope, config_obj,
+			recommendation)
+		if err != nil {
+			scope.Log("ollama_approve_recommendation: %v", err)
+			return vfilter.Null{}
+		}
+		row.Set("Action", APPROVAL_APPROVED).Set("FlowId", flow_id)
+	}
+
+	err = appendRecommendation(config_obj, row)
+	if err != nil {
+		scope.Log("ollama_approve_recommendation: %v", err)
+		return vfilter.Null{}
+	}
+
+	err = services.LogAudit(ctx, config_obj, principal,
+		"ollama_approve_recommendation", row)
+	if err != nil {
+		scope.Log("ollama_approve_recommendation: %v", err)
+	}
+
+	return row
+}
+
+func getRecommendation(ctx context.Context, config_obj *config_proto.Config,
+	id string) (*ordereddict.Dict, error) {
+	recommendations, err := loadRecommendations(ctx, config_obj)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, recommendation := range recommendations {
+		recommendation_id, _ := recommendation.GetString("RecommendationId")
+		if recommendation_id != id {
+			continue
+		}
+
+		status, _ := recommendation.GetString("Status")
+		if status != RECOMMENDATION_PENDING {
+			return nil, fmt.Errorf("Recommendation %v was already %v",
+				id, strings.ToLower(status))
+		}
+		return recommendation, nil
+	}
+	return nil, fmt.Errorf("Recommendation %v not found", id)
+}
+
+func scheduleRecommendation(ctx context.Context, scope vfilter.Scope,
+	config_obj *config_proto.Config,
+	recommendation *ordereddict.Dict) (string, error) {
+	client_id, _ := recommendation.GetString("ClientId")
+	artifact, _ := recommendation.GetString("Artifact")
+	parameters, _ := recommendation.Get("Parameters")
+
+	acl_manager, ok := artifacts.GetACLManager(scope)
+	if !ok {
+		acl_manager = acl_managers.NullACLManager{}
+	}
+
+	repository, err := vql_utils.GetRepository(scope)
+	if err != nil {
+		return "", err
+	}
+
+	request := &flows_proto.ArtifactCollectorArgs{
+		ClientId:  client_id,
+		Artifacts: []string{artifact},
+		Creator:   vql_subsystem.GetPrincipal(scope),
+	}
+
+	err = collector.AddSpecProtobuf(ctx, config_obj, repository, scope,
+		ordereddict.NewDict().Set(artifact, parameters), request)
+	if err != nil {
+		return "", err
+	}
+
+	launcher, err := services.GetLauncher(config_obj)
+	if err != nil {
+		return "", err
+	}
+
+	// ScheduleArtifactCollection checks the approver may collect
+	// the artifact.
+	return launcher.ScheduleArtifactCollection(
+		ctx, config_obj, acl_manager, repository, request,
+		func() {
+			notifier, err := services.GetNotifier(config_obj)
+			if err == nil {
+				notifier.NotifyListener(ctx, config_obj, client_id,
+					"ollama_approve_recommendation")
+			}
+		})
+}
+
+func (self OllamaRecommendPlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name:     "ollama_recommend",
+		Doc:      "Ask a model which artifacts to collect next from a client and store them as pending recommendations.",
+		ArgType:  type_map.AddType(scope, &OllamaRecommendPluginArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func (self OllamaRecommendationsPlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name:     "ollama_recommendations",
+		Doc:      "Show the collections recommended by ollama_recommend() and the decisions about them.",
+		ArgType:  type_map.AddType(scope, &OllamaRecommendationsPluginArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.READ_RESULTS).Build(),
+	}
+}
+
+func (self ApproveRecommendationFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:     "ollama_approve_recommendation",
+		Doc:      "Approve a recommended collection, scheduling it, or deny it.",
+		ArgType:  type_map.AddType(scope, &ApproveRecommendationFunctionArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_CLIENT).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterPlugin(&OllamaRecommendPlugin{})
+	vql_subsystem.RegisterPlugin(&OllamaRecommendationsPlugin{})
+	vql_subsystem.RegisterFunction(&ApproveRecommendationFunction{})
+}
@@ -0,0 +1,189 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Strategies for dealing with tool results too large to feed back
+// into the conversation.
+const (
+	REDUCE_TRUNCATE  = "truncate"
+	REDUCE_HEAD_TAIL = "head_tail"
+	REDUCE_SUMMARIZE = "summarize"
+	REDUCE_NONE      = "none"
+)
+
+// Shrinks tool results before they are added to the conversation.
+// Results are treated as lines, which are rows for VQL tools.
+type resultReducer struct {
+	strategy  string
+	max_rows  int
+	max_bytes int
+
+	// Asks the model to summarize a chunk of the result.
+	summarize func(ctx context.Context, tool, chunk string) (string, error)
+}
+
+func newResultReducer(strategy string, max_rows, max_bytes int64) (
+	*resultReducer, error) {
+	switch strategy {
+	case "":
+		strategy = REDUCE_TRUNCATE
+	case REDUCE_TRUNCATE, REDUCE_HEAD_TAIL, REDUCE_SUMMARIZE, REDUCE_NONE:
+	default:
+		return nil, fmt.Errorf("invalid tool_result_strategy %v", strategy)
+	}
+
+	if max_rows == 0 {
+		max_rows = 100
+	}
+
+	if max_bytes == 0 {
+		max_bytes = 16 * 1024
+	}
+
+	return &resultReducer{
+		strategy:  strategy,
+		max_rows:  int(max_rows),
+		max_bytes: int(max_bytes),
+	}, nil
+}
+
+func (self *resultReducer) fits(lines []string) bool {
+	if len(lines) > self.max_rows {
+		return false
+	}
+
+	size := 0
+	for _, l := range lines {
+		size += len(l) + 1
+	}
+	return size <= self.max_bytes
+}
+
+func (self *resultReducer) Reduce(ctx context.Context,
+	tool, result string) (string, error) {
+	lines := strings.Split(strings.TrimSuffix(result, "\n"), "\n")
+	if self.strategy == REDUCE_NONE || self.fits(lines) {
+		return result, nil
+	}
+
+	switch self.strategy {
+	case REDUCE_HEAD_TAIL:
+		return self.headTail(lines), nil
+
+	case REDUCE_SUMMARIZE:
+		if self.summarize != nil {
+			return self.summarizeLines(ctx, tool, lines)
+		}
+	}
+
+	return self.truncate(lines), nil
+}
+
+// Keep as many lines from the start as fit.
+func (self *resultReducer) truncate(lines []string) string {
+	result := &strings.Builder{}
+	kept := 0
+	for _, l := range lines {
+		if kept >= self.max_rows || result.Len()+len(l)+1 > self.max_bytes {
+			break
+		}
+		result.WriteString(l)
+		result.WriteString("\n")
+		kept++
+	}
+
+	fmt.Fprintf(result, "... %d of %d rows omitted\n",
+		len(lines)-kept, len(lines))
+	return result.String()
+}
+
+// Keep lines from both the start and the end. This is useful for
+// logs where the most recent entries matter most.
+func (self *resultReducer) headTail(lines []string) string {
+	head := []string{}
+	tail := []string{}
+	size := 0
+
+	i, j := 0, len(lines)-1
+	for i <= j && len(head)+len(tail) < self.max_rows {
+		l := lines[i]
+		if len(head) > len(tail) {
+			l = lines[j]
+		}
+		if size+len(l)+1 > self.max_bytes {
+			break
+		}
+		size += len(l) + 1
+
+		if len(head) > len(tail) {
+			tail = append(tail, l)
+			j--
+		} else {
+			head = append(head, l)
+			i++
+		}
+	}
+
+	result := &strings.Builder{}
+	for _, l := range head {
+		result.WriteString(l)
+		result.WriteString("\n")
+	}
+
+	fmt.Fprintf(result, "... %d of %d rows omitted\n",
+		len(lines)-len(head)-len(tail), len(lines))
+
+	for k := len(tail) - 1; k >= 0; k-- {
+		result.WriteString(tail[k])
+		result.WriteString("\n")
+	}
+	return result.String()
+}
+
+// Summarize the result in chunks which each fit the limits, then
+// join the summaries.
+func (self *resultReducer) summarizeLines(ctx context.Context,
+	tool string, lines []string) (string, error) {
+	summaries := []string{}
+	chunk := &strings.Builder{}
+	chunk_rows := 0
+
+	flush := func() error {
+		if chunk_rows == 0 {
+			return nil
+		}
+		summary, err := self.summarize(ctx, tool, chunk.String())
+		if err != nil {
+			return err
+		}
+		summaries = append(summaries, strings.TrimSpace(summary))
+		chunk.Reset()
+		chunk_rows = 0
+		return nil
+	}
+
+	for _, l := range lines {
+		if chunk_rows >= self.max_rows ||
+			(chunk_rows > 0 && chunk.Len()+len(l)+1 > self.max_bytes) {
+			err := flush()
+			if err != nil {
+				return "", err
+			}
+		}
+		chunk.WriteString(l)
+		chunk.WriteString("\n")
+		chunk_rows++
+	}
+
+	err := flush()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Summary of %d rows:\n%s\n", len(lines),
+		strings.Join(summaries, "\n")), nil
+}
@@ -0,0 +1,73 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeRows(count int) string {
+	result := &strings.Builder{}
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(result, "{\"Row\":%d}\n", i)
+	}
+	return result.String()
+}
+
+func TestResultReducer(t *testing.T) {
+	ctx := context.Background()
+
+	// Small results are not changed.
+	reducer, err := newResultReducer("", 5, 0)
+	require.NoError(t, err)
+
+	result, err := reducer.Reduce(ctx, "tool", makeRows(5))
+	require.NoError(t, err)
+	assert.Equal(t, makeRows(5), result)
+
+	result, err = reducer.Reduce(ctx, "tool", makeRows(8))
+	require.NoError(t, err)
+	assert.Equal(t, makeRows(5)+"... 3 of 8 rows omitted\n", result)
+
+	reducer, err = newResultReducer(REDUCE_HEAD_TAIL, 4, 0)
+	require.NoError(t, err)
+
+	result, err = reducer.Reduce(ctx, "tool", makeRows(10))
+	require.NoError(t, err)
+	assert.Equal(t, `{"Row":0}
+{"Row":1}
+... 6 of 10 rows omitted
+{"Row":8}
+{"Row":9}
+`, result)
+
+	// The byte limit applies too.
+	reducer, err = newResultReducer(REDUCE_TRUNCATE, 100, 25)
+	require.NoError(t, err)
+
+	result, err = reducer.Reduce(ctx, "tool", makeRows(10))
+	require.NoError(t, err)
+	assert.Equal(t, makeRows(2)+"... 8 of 10 rows omitted\n", result)
+
+	// Summaries are made of chunks within the limits.
+	reducer, err = newResultReducer(REDUCE_SUMMARIZE, 4, 0)
+	require.NoError(t, err)
+
+	chunks := []string{}
+	reducer.summarize = func(ctx context.Context, tool, chunk string) (string, error) {
+		chunks = append(chunks, chunk)
+		return fmt.Sprintf("%d rows", strings.Count(chunk, "\n")), nil
+	}
+
+	result, err = reducer.Reduce(ctx, "tool", makeRows(10))
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(chunks))
+	assert.Equal(t, "Summary of 10 rows:\n4 rows\n4 rows\n2 rows\n", result)
+
+	_, err = newResultReducer("bogus", 0, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,113 @@
+package ollama
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/reporting"
+)
+
+const (
+	RESPONSE_FORMAT_TEXT     = "text"
+	RESPONSE_FORMAT_MARKDOWN = "markdown"
+	RESPONSE_FORMAT_JSON     = "json"
+	RESPONSE_FORMAT_CSV      = "csv"
+)
+
+// Hints added to the prompt so the model responds in a form that can
+// be post processed. JSON is constrained by the format instead.
+var response_format_hints = map[string]string{
+	RESPONSE_FORMAT_MARKDOWN: "\n\nFormat the response as markdown.",
+	RESPONSE_FORMAT_CSV: "\n\nRespond with only CSV data. The first line " +
+		"must be a header naming the columns.",
+}
+
+func validateResponseFormat(response_format string) error {
+	switch response_format {
+	case "", RESPONSE_FORMAT_TEXT, RESPONSE_FORMAT_MARKDOWN,
+		RESPONSE_FORMAT_JSON, RESPONSE_FORMAT_CSV:
+		return nil
+	}
+	return fmt.Errorf(
+		"response_format should be text, markdown, json or csv not %q",
+		response_format)
+}
+
+// Post process a response which is emitted in a single column.
+func formatResponse(response_format, response string) string {
+	if response_format == RESPONSE_FORMAT_MARKDOWN {
+		return sanitizeMarkdown(response)
+	}
+	return response
+}
+
+// The response is shown in notebooks so markdown is sanitized with
+// the same policy used when rendering notebook cells. Models sometimes
+// wrap the whole response in a markdown code block which would render
+// as code.
+func sanitizeMarkdown(response string) string {
+	trimmed := strings.TrimSpace(response)
+	if strings.HasPrefix(trimmed, "```markdown") ||
+		strings.HasPrefix(trimmed, "```md\n") {
+		response = stripFence(trimmed, "markdown", "md")
+	}
+	return reporting.NewBlueMondayPolicy().Sanitize(response)
+}
+
+// Parse a CSV response into a row per record, named by the header.
+// Records with more fields than the header name the extra columns
+// by position.
+func parseCSVResponse(response string) ([]*ordereddict.Dict, error) {
+	reader := csv.NewReader(strings.NewReader(stripFence(response, "csv")))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	headers, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Response is not valid CSV: %w", err)
+	}
+
+	var result []*ordereddict.Dict
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Response is not valid CSV: %w", err)
+		}
+
+		row := ordereddict.NewDict()
+		for idx, value := range record {
+			if idx < len(headers) {
+				row.Set(headers[idx], value)
+			} else {
+				row.Set(fmt.Sprintf("_%d", idx), value)
+			}
+		}
+		result = append(result, row)
+	}
+}
+
+// Like stripCodeFence but for a code block of any of the languages.
+func stripFence(response string, languages ...string) string {
+	response = strings.TrimSpace(response)
+	if !strings.HasPrefix(response, "```") {
+		return response
+	}
+
+	response = strings.TrimPrefix(response, "```")
+	for _, language := range languages {
+		if strings.HasPrefix(response, language) {
+			response = strings.TrimPrefix(response, language)
+			break
+		}
+	}
+	response = strings.TrimSuffix(response, "```")
+	return strings.TrimSpace(response)
+}
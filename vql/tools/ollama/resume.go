@@ -0,0 +1,69 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	DEFAULT_MAX_RESUMES = 2
+)
+
+// The max_resumes arg defaults when it is not given or negative, so
+// 0 turns resuming off.
+func getMaxResumes(args *ordereddict.Dict, max_resumes int64) int64 {
+	_, pres := args.Get("max_resumes")
+	if !pres || max_resumes < 0 {
+		return DEFAULT_MAX_RESUMES
+	}
+	return max_resumes
+}
+
+// Generate with the client, continuing the response if the
+// connection drops part way through. Long generations are not lost
+// to a flaky network or a restarted proxy. If the response can not
+// be completed the text received so far is kept in the error.
+func resumeGenerate(scope vfilter.Scope, client *Client,
+	max_resumes int64) generateFunc {
+	return func(ctx context.Context, req *GenerateRequest,
+		cb func(resp *GenerateResponse) error) error {
+		response := &strings.Builder{}
+		track := func(chunk *GenerateResponse) error {
+			response.WriteString(chunk.Response)
+			return cb(chunk)
+		}
+
+		err := client.Generate(ctx, req, track)
+		for i := int64(0); i < max_resumes; i++ {
+			var llm_err *Error
+			if !errors.As(err, &llm_err) || llm_err.Code != ERROR_INTERRUPTED ||
+				ctx.Err() != nil {
+				break
+			}
+
+			// Responses with a context can not be continued.
+			if response.Len() > 0 && len(req.Context) > 0 {
+				break
+			}
+
+			scope.Log("ollama: Response interrupted after %v bytes, continuing: %v",
+				response.Len(), err)
+
+			if response.Len() == 0 {
+				err = client.Generate(ctx, req, track)
+			} else {
+				err = client.Continue(ctx, req, response.String(), track)
+			}
+		}
+
+		var llm_err *Error
+		if errors.As(err, &llm_err) && response.Len() > 0 {
+			llm_err.Partial = response.String()
+		}
+		return err
+	}
+}
@@ -0,0 +1,333 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/vfilter"
+)
+
+// Encodes rows for inclusion in a prompt. Rows are compact JSON, one
+// per line, unless indented which is easier for some models to read
+// but uses more tokens, or rendered in another format.
+type rowEncoder struct {
+	indent    bool
+	flatten   bool
+	max_bytes int64
+	binary    binaryEncoder
+	renderer  rowRenderer
+
+	// Replaces identifying values before the rows are encoded.
+	pseudonyms *pseudonymizer
+
+	// The header of the table the rows are in, if rendered as a
+	// table. It is kept separately so it is not lost when rows are
+	// dropped to fit the context window.
+	header string
+
+	// Keep the rows as they were before they were cleaned.
+	keep_input bool
+
+	size   int64
+	rows   []string
+	inputs []interface{}
+
+	// The number of rows the query returned when all of them were
+	// read to sample them.
+	query_rows int64
+}
+
+// Add a row. Returns false if the row does not fit in max_bytes.
+func (self *rowEncoder) Add(row interface{}) (bool, error) {
+	encoded := row
+	if self.pseudonyms != nil {
+		dict, ok := row.(*ordereddict.Dict)
+		if ok {
+			encoded = self.pseudonyms.Row(dict)
+		}
+	}
+	if self.flatten {
+		dict, ok := encoded.(*ordereddict.Dict)
+		if ok {
+			encoded = flattenRow(dict)
+		}
+	}
+	sanitized := self.binary.Sanitize(encoded)
+
+	var serialized []byte
+	var err error
+	if self.indent {
+		serialized, err = json.MarshalIndent(sanitized)
+	} else {
+		serialized, err = json.Marshal(sanitized)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	line, header, columns, err := self.renderer.Render(serialized)
+	if err != nil {
+		return false, err
+	}
+
+	// A later table is separated from the previous one.
+	if header != "" && len(self.rows) > 0 {
+		line = "\n" + header + "\n" + line
+		header = ""
+	}
+
+	size := int64(len(line)) + 1
+	if header != "" {
+		size += int64(len(header)) + 1
+	}
+	if self.max_bytes > 0 && self.size+size > self.max_bytes {
+		return false, nil
+	}
+
+	if header != "" {
+		self.header = header + "\n"
+	}
+	if columns != nil {
+		self.renderer.SetColumns(columns)
+	}
+	self.size += size
+	self.rows = append(self.rows, line)
+	if self.keep_input {
+		self.inputs = append(self.inputs, row)
+	}
+	return true, nil
+}
+
+// Convert a query row for the prompt. RowToDict only evaluates the
+// top level columns so nested dicts are converted the same way,
+// keeping their key order, so the same rows always give the same
+// prompt.
+func promptRow(ctx context.Context,
+	scope vfilter.Scope, row vfilter.Row) *ordereddict.Dict {
+	return normalizeDict(ctx, scope, vfilter.RowToDict(ctx, scope, row), 0)
+}
+
+func normalizeDict(ctx context.Context, scope vfilter.Scope,
+	dict *ordereddict.Dict, depth int) *ordereddict.Dict {
+	result := ordereddict.NewDict()
+	for _, k := range dict.Keys() {
+		v, _ := dict.Get(k)
+		result.Set(k, normalizeNested(ctx, scope, v, depth))
+	}
+	return result
+}
+
+func normalizeNested(ctx context.Context, scope vfilter.Scope,
+	value interface{}, depth int) interface{} {
+	if depth > 10 {
+		return vfilter.Null{}
+	}
+
+	switch t := value.(type) {
+	case *ordereddict.Dict:
+		if t == nil {
+			return vfilter.Null{}
+		}
+		return normalizeDict(ctx, scope,
+			vfilter.RowToDict(ctx, scope, t), depth+1)
+
+	case []interface{}:
+		result := make([]interface{}, 0, len(t))
+		for _, item := range t {
+			result = append(result, normalizeNested(ctx, scope, item, depth+1))
+		}
+		return result
+	}
+
+	return value
+}
+
+// Nested values become columns named by their path (e.g. a.b.0)
+// since small models lose track of deeply nested JSON.
+func flattenRow(row *ordereddict.Dict) *ordereddict.Dict {
+	result := ordereddict.NewDict()
+	for _, k := range row.Keys() {
+		v, _ := row.Get(k)
+		flattenValue(result, k, v)
+	}
+	return result
+}
+
+func flattenValue(result *ordereddict.Dict, prefix string, value interface{}) {
+	switch t := value.(type) {
+	case *ordereddict.Dict:
+		if t != nil && t.Len() > 0 {
+			for _, k := range t.Keys() {
+				v, _ := t.Get(k)
+				flattenValue(result, prefix+"."+k, v)
+			}
+			return
+		}
+
+	case []byte:
+
+	default:
+		// Subqueries give slices of rows.
+		slice := reflect.ValueOf(value)
+		if slice.Kind() == reflect.Slice && slice.Len() > 0 {
+			for idx := 0; idx < slice.Len(); idx++ {
+				flattenValue(result, fmt.Sprintf("%s.%d", prefix, idx),
+					slice.Index(idx).Interface())
+			}
+			return
+		}
+	}
+
+	result.Set(prefix, value)
+}
+
+func newRowEncoder(arg *OllamaPluginArgs) *rowEncoder {
+	return &rowEncoder{
+		indent:     arg.Indent,
+		flatten:    arg.Flatten,
+		renderer:   rowRenderer{serialization: arg.Serialization},
+		max_bytes:  arg.MaxBytes,
+		keep_input: arg.IncludeInput,
+		pseudonyms: arg.pseudonymizer,
+		binary: binaryEncoder{
+			encoding:      arg.BinaryEncoding,
+			max_bytes:     int(arg.MaxBinaryBytes),
+			max_field_len: int(arg.MaxFieldLen),
+		},
+	}
+}
+
+// The prompt text of some of the encoder's rows.
+func (self *rowEncoder) Serialize(rows []string) string {
+	return self.header + joinRows(rows)
+}
+
+func joinRows(rows []string) string {
+	if len(rows) == 0 {
+		return ""
+	}
+	return strings.Join(rows, "\n") + "\n"
+}
+
+// Rename a column of an output row, keeping the column order.
+func renameColumn(row *ordereddict.Dict, from, to string) *ordereddict.Dict {
+	if to == "" || to == from {
+		return row
+	}
+
+	_, pres := row.Get(from)
+	if !pres {
+		return row
+	}
+
+	result := ordereddict.NewDict()
+	for _, k := range row.Keys() {
+		v, _ := row.Get(k)
+		if k == from {
+			k = to
+		}
+		result.Set(k, v)
+	}
+	return result
+}
+
+// Serialize the query's rows. Rows are encoded as they arrive and
+// the query is cancelled once either limit is reached so large
+// result sets are never held in memory, unless a sampler needs to
+// see all of them. A negative limit includes all rows. Returns if
+// rows were dropped, or an error in strict mode.
+func collectRows(ctx context.Context, scope vfilter.Scope,
+	query vfilter.StoredQuery, limit int64, strict bool,
+	sampler *rowSampler, encoder *rowEncoder) (bool, error) {
+	sub_ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if sampler != nil && limit >= 0 {
+		rows, total := sampler.Sample(sub_ctx, scope, query, limit)
+		encoder.query_rows = total
+		for _, row := range rows {
+			added, err := encoder.Add(row)
+			if err != nil {
+				return false, err
+			}
+			if !added {
+				scope.Log("ollama: Query rows truncated at %v bytes", encoder.max_bytes)
+				return true, nil
+			}
+		}
+
+		if total > int64(len(rows)) {
+			scope.Log("ollama: Sampled %v of %v query rows", len(rows), total)
+			return true, nil
+		}
+		return false, nil
+	}
+
+	for row := range query.Eval(sub_ctx, scope) {
+		// One more row than the limit shows rows were dropped.
+		if limit >= 0 && int64(len(encoder.rows)) >= limit {
+			if strict {
+				return false, fmt.Errorf(
+					"Query returned more than the limit of %v rows", limit)
+			}
+			scope.Log("ollama: Query rows truncated at %v rows", limit)
+			return true, nil
+		}
+
+		added, err := encoder.Add(promptRow(sub_ctx, scope, row))
+		if err != nil {
+			return false, err
+		}
+
+		if !added {
+			if strict {
+				return false, fmt.Errorf(
+					"Query rows exceed max_bytes of %v", encoder.max_bytes)
+			}
+			scope.Log("ollama: Query rows truncated at %v bytes", encoder.max_bytes)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Append the query rows to the request's prompt, fitting them in the
+// context window if needed. Returns if rows were left out.
+func buildPrompt(ctx context.Context, scope vfilter.Scope,
+	arg *OllamaPluginArgs, client *Client, req *GenerateRequest,
+	encoder *rowEncoder) (bool, error) {
+	sampler, err := parseSample(arg.Sample)
+	if err != nil {
+		return false, err
+	}
+
+	truncated, err := collectRows(ctx, scope, arg.Query,
+		arg.MaxRows, arg.Strict, sampler, encoder)
+	if err != nil {
+		return false, err
+	}
+
+	serialized := encoder.Serialize(encoder.rows)
+	if arg.Truncate != "" {
+		var dropped bool
+		serialized, dropped, err = fitRows(ctx, client, req, arg.Truncate,
+			encoder.header, encoder.rows)
+		if err == nil && dropped && arg.Strict {
+			err = fmt.Errorf("Query rows do not fit in the context window of %v",
+				req.Model)
+		}
+		if err != nil {
+			return false, err
+		}
+		truncated = truncated || dropped
+	}
+	req.Prompt += "\n\n" + serialized
+	scope.Log("DEBUG:ollama: Collected %v rows (%v bytes) from the query",
+		len(encoder.rows), encoder.size)
+	return truncated, nil
+}
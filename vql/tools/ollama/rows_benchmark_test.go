@@ -0,0 +1,42 @@
+package ollama
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+func makeDictRows(count int) []*ordereddict.Dict {
+	result := make([]*ordereddict.Dict, 0, count)
+	for i := 0; i < count; i++ {
+		result = append(result, ordereddict.NewDict().
+			Set("Pid", i).
+			Set("Name", fmt.Sprintf("process_%d.exe", i)).
+			Set("CommandLine", "C:\\Windows\\System32\\svchost.exe -k netsvcs -p").
+			Set("Username", "NT AUTHORITY\\SYSTEM"))
+	}
+	return result
+}
+
+func BenchmarkPromptRows(b *testing.B) {
+	for _, count := range []int{1000, 10000} {
+		rows := makeDictRows(count)
+
+		for _, indent := range []bool{false, true} {
+			b.Run(fmt.Sprintf("Rows%d/Indent=%v", count, indent), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					encoder := &rowEncoder{indent: indent}
+					for _, row := range rows {
+						_, err := encoder.Add(row)
+						if err != nil {
+							b.Fatal(err)
+						}
+					}
+					_ = joinRows(encoder.rows)
+				}
+			})
+		}
+	}
+}
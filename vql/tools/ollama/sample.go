@@ -0,0 +1,159 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sort"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	SAMPLE_HEAD       = "head"
+	SAMPLE_TAIL       = "tail"
+	SAMPLE_RANDOM     = "random"
+	SAMPLE_STRATIFIED = "stratified"
+)
+
+var stratified_regex = regexp.MustCompile(`^stratified\(\s*([^()\s]+)\s*\)$`)
+
+// Chooses which rows are sent when the query returns more than the
+// limit. Unlike the default of taking the first rows, the whole
+// query is read so the sample represents all of it.
+type rowSampler struct {
+	strategy string
+	column   string
+}
+
+func parseSample(sample string) (*rowSampler, error) {
+	switch sample {
+	case "", SAMPLE_HEAD:
+		return nil, nil
+	case SAMPLE_TAIL, SAMPLE_RANDOM:
+		return &rowSampler{strategy: sample}, nil
+	}
+
+	match := stratified_regex.FindStringSubmatch(sample)
+	if match != nil {
+		return &rowSampler{strategy: SAMPLE_STRATIFIED, column: match[1]}, nil
+	}
+
+	return nil, fmt.Errorf(
+		"sample should be head, tail, random or stratified(column) not %q",
+		sample)
+}
+
+type sampledRow struct {
+	idx int64
+	row *ordereddict.Dict
+}
+
+// A random sample of up to limit rows.
+type reservoir struct {
+	limit int64
+	seen  int64
+	rows  []sampledRow
+}
+
+func (self *reservoir) Add(row sampledRow) {
+	self.seen++
+	if int64(len(self.rows)) < self.limit {
+		self.rows = append(self.rows, row)
+		return
+	}
+
+	slot := rand.Int63n(self.seen)
+	if slot < self.limit {
+		self.rows[slot] = row
+	}
+}
+
+// Returns up to limit rows, in the order the query returned them,
+// and the number of rows the query returned.
+func (self *rowSampler) Sample(ctx context.Context, scope vfilter.Scope,
+	query vfilter.StoredQuery, limit int64) ([]*ordereddict.Dict, int64) {
+	var total int64
+	var result []sampledRow
+
+	switch self.strategy {
+	case SAMPLE_TAIL:
+		for row := range query.Eval(ctx, scope) {
+			result = append(result, sampledRow{
+				idx: total, row: promptRow(ctx, scope, row)})
+			total++
+			if int64(len(result)) > limit {
+				result = result[1:]
+			}
+		}
+
+	case SAMPLE_RANDOM:
+		sample := &reservoir{limit: limit}
+		for row := range query.Eval(ctx, scope) {
+			sample.Add(sampledRow{idx: total, row: promptRow(ctx, scope, row)})
+			total++
+		}
+		result = sample.rows
+
+	case SAMPLE_STRATIFIED:
+		result, total = self.stratify(ctx, scope, query, limit)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].idx < result[j].idx
+	})
+
+	rows := make([]*ordereddict.Dict, 0, len(result))
+	for _, item := range result {
+		rows = append(rows, item.row)
+	}
+	return rows, total
+}
+
+// Each value of the column is a stratum with a random sample of its
+// rows. Rows are taken from each stratum in turn so rare values are
+// included as well as common ones. Only the first limit values can
+// be included so later values are not kept.
+func (self *rowSampler) stratify(ctx context.Context, scope vfilter.Scope,
+	query vfilter.StoredQuery, limit int64) ([]sampledRow, int64) {
+	var total int64
+	var values []string
+	strata := make(map[string]*reservoir)
+
+	for row := range query.Eval(ctx, scope) {
+		row_dict := promptRow(ctx, scope, row)
+		value, _ := row_dict.Get(self.column)
+		key := utils.ToString(value)
+		total++
+
+		stratum, pres := strata[key]
+		if !pres {
+			if int64(len(values)) >= limit {
+				continue
+			}
+			stratum = &reservoir{limit: limit}
+			strata[key] = stratum
+			values = append(values, key)
+		}
+		stratum.Add(sampledRow{idx: total - 1, row: row_dict})
+	}
+
+	var result []sampledRow
+	for depth := 0; int64(len(result)) < limit; depth++ {
+		added := false
+		for _, key := range values {
+			stratum := strata[key]
+			if depth < len(stratum.rows) && int64(len(result)) < limit {
+				result = append(result, stratum.rows[depth])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	return result, total
+}
@@ -0,0 +1,286 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/constants"
+	"www.velocidex.com/golang/velociraptor/file_store"
+	"www.velocidex.com/golang/velociraptor/file_store/api"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/paths"
+	"www.velocidex.com/golang/velociraptor/result_sets"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	NOTE_PLAN    = "plan"
+	NOTE_FINDING = "finding"
+)
+
+// An investigation keeps the transcript and scratchpad of an agent
+// run on the server. A run with the same investigation id resumes
+// from where the previous one stopped. Investigations are stored per
+// user so users can not read or continue each other's.
+type Investigation struct {
+	mu sync.Mutex
+
+	config_obj      *config_proto.Config
+	transcript_path api.FSPathSpec
+	scratchpad_path api.FSPathSpec
+}
+
+func OpenInvestigation(scope vfilter.Scope, id string) (*Investigation, error) {
+	config_obj, ok := vql_subsystem.GetServerConfig(scope)
+	if !ok {
+		return nil, errors.New("Investigations are only available on the server")
+	}
+
+	principal := vql_subsystem.GetPrincipal(scope)
+	if principal == "" {
+		principal = constants.PinnedServerName
+	}
+
+	path_manager := paths.LLMPathManager{}
+	return &Investigation{
+		config_obj:      config_obj,
+		transcript_path: path_manager.Transcript(principal, id),
+		scratchpad_path: path_manager.Scratchpad(principal, id),
+	}, nil
+}
+
+func (self *Investigation) LoadTranscript(ctx context.Context) ([]*Message, error) {
+	reader, err := result_sets.NewResultSetReader(
+		file_store.GetFileStore(self.config_obj), self.transcript_path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	json_chan, err := reader.JSON(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*Message{}
+	for serialized := range json_chan {
+		message := &Message{}
+		err := json.Unmarshal(serialized, message)
+		if err != nil {
+			continue
+		}
+		result = append(result, message)
+	}
+	return result, nil
+}
+
+func (self *Investigation) AppendMessage(message *Message) error {
+	serialized, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	return self.write(self.transcript_path, result_sets.AppendMode,
+		func(w result_sets.ResultSetWriter) {
+			w.WriteJSONL(append(serialized, '\n'), 1)
+		})
+}
+
+// Replace the transcript, e.g. after dropping an interrupted step.
+func (self *Investigation) SetTranscript(messages []*Message) error {
+	serialized, err := json.MarshalJsonl(messages)
+	if err != nil {
+		return err
+	}
+
+	return self.write(self.transcript_path, result_sets.TruncateMode,
+		func(w result_sets.ResultSetWriter) {
+			w.WriteJSONL(serialized, uint64(len(messages)))
+		})
+}
+
+func (self *Investigation) AddNote(kind, content string) error {
+	return self.write(self.scratchpad_path, result_sets.AppendMode,
+		func(w result_sets.ResultSetWriter) {
+			w.Write(ordereddict.NewDict().
+				Set("Time", utils.GetTime().Now().Unix()).
+				Set("Type", kind).
+				Set("Content", content))
+		})
+}
+
+func (self *Investigation) write(path api.FSPathSpec,
+	mode result_sets.WriteMode,
+	cb func(w result_sets.ResultSetWriter)) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	rs_writer, err := result_sets.NewResultSetWriter(
+		file_store.GetFileStore(self.config_obj), path,
+		json.DefaultEncOpts(), utils.SyncCompleter, mode)
+	if err != nil {
+		return err
+	}
+	cb(rs_writer)
+	rs_writer.Close()
+	return nil
+}
+
+// Drop a trailing step which was interrupted before all its tool
+// results were recorded. The model will decide again what to do.
+func trimIncompleteStep(messages []*Message) []*Message {
+	for i := len(messages) - 1; i >= 0; i-- {
+		message := messages[i]
+		if message.Role != "assistant" {
+			continue
+		}
+
+		tool_results := len(messages) - i - 1
+		if tool_results < len(message.ToolCalls) {
+			return messages[:i]
+		}
+		return messages
+	}
+	return messages
+}
+
+// Lets the agent record its plan and findings in the scratchpad.
+type scratchpadTool struct {
+	investigation *Investigation
+	kind          string
+	name          string
+	description   string
+}
+
+func (self *scratchpadTool) Spec() *ToolSpec {
+	return &ToolSpec{
+		Type: "function",
+		Function: &ToolFunction{
+			Name:        self.name,
+			Description: self.description,
+			Parameters: ordereddict.NewDict().
+				Set("type", "object").
+				Set("properties", ordereddict.NewDict().
+					Set("text", ordereddict.NewDict().
+						Set("type", "string"))).
+				Set("required", []string{"text"}),
+		},
+	}
+}
+
+func (self *scratchpadTool) Risk() RiskLevel {
+	return RISK_LOW
+}
+
+func (self *scratchpadTool) Call(ctx context.Context, scope vfilter.Scope,
+	args *ordereddict.Dict) (string, error) {
+	text := ""
+	if args != nil {
+		text, _ = args.GetString("text")
+	}
+
+	err := self.investigation.AddNote(self.kind, text)
+	if err != nil {
+		return "", err
+	}
+	return "Recorded.", nil
+}
+
+func scratchpadTools(investigation *Investigation) []AgentTool {
+	return []AgentTool{
+		&scratchpadTool{
+			investigation: investigation,
+			kind:          NOTE_PLAN,
+			name:          "update_plan",
+			description:   "Record your current plan for the investigation. Call this before starting and whenever the plan changes.",
+		},
+		&scratchpadTool{
+			investigation: investigation,
+			kind:          NOTE_FINDING,
+			name:          "record_finding",
+			description:   "Record an important finding so it is kept for later review.",
+		},
+	}
+}
+
+type AgentScratchpadPluginArgs struct {
+	Investigation string `vfilter:"required,field=investigation,doc=The investigation id passed to ollama_agent()."`
+	Transcript    bool   `vfilter:"optional,field=transcript,doc=Return the full conversation instead of the plan and findings."`
+}
+
+type AgentScratchpadPlugin struct{}
+
+func (self AgentScratchpadPlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("agent_scratchpad", args)()
+
+		err := vql_subsystem.CheckAccess(scope, acls.READ_RESULTS)
+		if err != nil {
+			scope.Log("agent_scratchpad: %v", err)
+			return
+		}
+
+		arg := &AgentScratchpadPluginArgs{}
+		err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+		if err != nil {
+			scope.Log("agent_scratchpad: %v", err)
+			return
+		}
+
+		investigation, err := OpenInvestigation(scope, arg.Investigation)
+		if err != nil {
+			scope.Log("agent_scratchpad: %v", err)
+			return
+		}
+
+		path := investigation.scratchpad_path
+		if arg.Transcript {
+			path = investigation.transcript_path
+		}
+
+		reader, err := result_sets.NewResultSetReader(
+			file_store.GetFileStore(investigation.config_obj), path)
+		if err != nil {
+			scope.Log("agent_scratchpad: %v", err)
+			return
+		}
+		defer reader.Close()
+
+		for row := range reader.Rows(ctx) {
+			select {
+			case <-ctx.Done():
+				return
+			case output_chan <- row:
+			}
+		}
+	}()
+
+	return output_chan
+}
+
+func (self AgentScratchpadPlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name:     "agent_scratchpad",
+		Doc:      "Show the plan, findings or transcript of an ollama_agent() investigation.",
+		ArgType:  type_map.AddType(scope, &AgentScratchpadPluginArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.READ_RESULTS).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterPlugin(&AgentScratchpadPlugin{})
+}
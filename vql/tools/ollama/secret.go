@@ -0,0 +1,101 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	"www.velocidex.com/golang/velociraptor/services"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+)
+
+// A base_url of secret://name reads the endpoint from a managed
+// secret so API keys for gateways in front of the server are not
+// written in queries.
+func isSecretUrl(base_url string) bool {
+	return strings.HasPrefix(base_url, "secret://")
+}
+
+// The endpoint and headers stored in an HTTP Secret. The same fields
+// as http_client() are used so one secret works for both. Webhook
+// Secrets also hold the key used to sign the requests.
+type secretEndpoint struct {
+	base_url string
+	headers  http.Header
+	hmac_key string
+}
+
+func resolveSecretUrl(ctx context.Context, scope vfilter.Scope,
+	secret_type, base_url string) (*secretEndpoint, error) {
+	name := strings.TrimSuffix(strings.TrimPrefix(base_url, "secret://"), "/")
+	secret, err := getSecret(ctx, scope, secret_type, name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &secretEndpoint{
+		base_url: vql_subsystem.GetStringFromRow(scope, secret.Data, "url"),
+		headers:  http.Header{},
+		hmac_key: vql_subsystem.GetStringFromRow(scope, secret.Data, "hmac_key"),
+	}
+	if result.base_url == "" {
+		return nil, fmt.Errorf("Secret %v has no url", name)
+	}
+
+	url_regex := vql_subsystem.GetStringFromRow(scope, secret.Data, "url_regex")
+	if url_regex != "" {
+		re, err := regexp.Compile(url_regex)
+		if err != nil {
+			return nil, fmt.Errorf("Secret %v has invalid URL regex: %v: %w",
+				name, url_regex, err)
+		}
+
+		if !re.MatchString(result.base_url) {
+			return nil, fmt.Errorf("Secret %v URL regex %v forbids connection to %v",
+				name, url_regex, redactUrl(result.base_url))
+		}
+	}
+
+	// Headers are stored as a YAML object.
+	extra_headers := vql_subsystem.GetStringFromRow(
+		scope, secret.Data, "extra_headers")
+	if extra_headers != "" {
+		headers := make(map[string]string)
+		err := yaml.Unmarshal([]byte(extra_headers), headers)
+		if err != nil {
+			return nil, fmt.Errorf("Secret %v has invalid extra_headers: %w",
+				name, err)
+		}
+		for k, v := range headers {
+			if v != "" {
+				result.headers.Set(k, v)
+			}
+		}
+	}
+
+	result.base_url = strings.TrimSuffix(result.base_url, "/")
+	return result, nil
+}
+
+// Secrets are only available on the server, to the principal of the
+// query.
+func getSecret(ctx context.Context, scope vfilter.Scope,
+	secret_type, name string) (*services.Secret, error) {
+	config_obj, ok := vql_subsystem.GetServerConfig(scope)
+	if !ok {
+		return nil, errors.New("Secrets may only be used on the server")
+	}
+
+	secrets_service, err := services.GetSecretsService(config_obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return secrets_service.GetSecret(ctx,
+		vql_subsystem.GetPrincipal(scope), secret_type, name)
+}
@@ -0,0 +1,158 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Velocidex/ordereddict"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+const (
+	SELECTED_FITS    = "fits"
+	SELECTED_LARGEST = "largest"
+)
+
+// Picks a model from AI.model_pool for each call when the query does
+// not name one. The pool is narrowed to the models handling the task
+// and each call goes to the smallest model whose context window fits
+// the prompt, the response and the context the query asked for.
+type modelSelector struct {
+	task        string
+	min_context int64
+
+	// Models handling the task, smallest context window first.
+	candidates []*config_proto.AIModelConfig
+
+	mu sync.Mutex
+
+	// The digests of the picked models for deterministic calls.
+	digests map[string]string
+}
+
+// How a model was picked, recorded in the ModelSelection column.
+type modelChoice struct {
+	Model         string
+	Task          string
+	PromptTokens  int64
+	ContextWindow int64
+	Reason        string
+}
+
+func (self *modelChoice) Dict() *ordereddict.Dict {
+	return ordereddict.NewDict().
+		Set("Task", self.Task).
+		Set("PromptTokens", self.PromptTokens).
+		Set("ContextWindow", self.ContextWindow).
+		Set("Reason", self.Reason)
+}
+
+// Returns nil when the model is named or there is no pool to choose
+// from.
+func (self *Client) newModelSelector(model, task string,
+	min_context int64) (*modelSelector, error) {
+	if model != "" || len(self.settings.ModelPool) == 0 {
+		return nil, nil
+	}
+
+	result := &modelSelector{
+		task:        task,
+		min_context: min_context,
+		digests:     make(map[string]string),
+	}
+
+	for _, item := range self.settings.ModelPool {
+		if task == "" || len(item.Tasks) == 0 ||
+			utils.InString(item.Tasks, task) {
+			result.candidates = append(result.candidates, item)
+		}
+	}
+
+	if len(result.candidates) == 0 {
+		return nil, fmt.Errorf("No model in AI.model_pool handles task %v", task)
+	}
+
+	sort.SliceStable(result.candidates, func(i, j int) bool {
+		return poolContextWindow(result.candidates[i]) <
+			poolContextWindow(result.candidates[j])
+	})
+
+	return result, nil
+}
+
+// The model with the largest context window, used to build prompts
+// before their size is known.
+func (self *modelSelector) Largest() string {
+	return self.candidates[len(self.candidates)-1].Name
+}
+
+// Set the request's model to the smallest one which fits it. Rows
+// not yet in the prompt are counted too. When nothing fits the
+// largest model is used so the call fails or is truncated the same
+// way as a named model.
+func (self *modelSelector) Select(req *GenerateRequest, rows string) *modelChoice {
+	var result *modelChoice
+	for _, item := range self.candidates {
+		window := poolContextWindow(item)
+		tokens := EstimateTokens(item.Name, req.System) +
+			EstimateTokens(item.Name, req.Prompt) +
+			EstimateTokens(item.Name, rows) + int64(len(req.Context))
+
+		result = &modelChoice{
+			Model:         item.Name,
+			Task:          self.task,
+			PromptTokens:  tokens,
+			ContextWindow: window,
+			Reason:        SELECTED_LARGEST,
+		}
+
+		if tokens+responseReserve(req.Options) <= window &&
+			self.min_context <= window {
+			result.Reason = SELECTED_FITS
+			break
+		}
+	}
+
+	req.Model = result.Model
+
+	// The model is run with the window it was picked for unless the
+	// query sets its own.
+	num_ctx := int64(0)
+	if req.Options != nil {
+		num_ctx, _ = req.Options.GetInt64("num_ctx")
+	}
+	if num_ctx <= 0 {
+		req.Options = withOption(req.Options, "num_ctx", result.ContextWindow)
+	}
+
+	return result
+}
+
+// The digest of a picked model, looked up once per query.
+func (self *modelSelector) Digest(ctx context.Context,
+	client *Client, model string) (string, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	digest, pres := self.digests[model]
+	if pres {
+		return digest, nil
+	}
+
+	digest, err := client.ModelDigest(ctx, model)
+	if err != nil {
+		return "", err
+	}
+	self.digests[model] = digest
+	return digest, nil
+}
+
+func poolContextWindow(item *config_proto.AIModelConfig) int64 {
+	if item.ContextWindow > 0 {
+		return item.ContextWindow
+	}
+	return DEFAULT_NUM_CTX
+}
@@ -0,0 +1,137 @@
+package ollama
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	"github.com/Velocidex/yaml/v2"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+const (
+	SERIALIZATION_JSON           = "json"
+	SERIALIZATION_CSV            = "csv"
+	SERIALIZATION_YAML           = "yaml"
+	SERIALIZATION_MARKDOWN_TABLE = "markdown_table"
+)
+
+func validateSerialization(serialization string) error {
+	switch serialization {
+	case "", SERIALIZATION_JSON, SERIALIZATION_CSV, SERIALIZATION_YAML,
+		SERIALIZATION_MARKDOWN_TABLE:
+		return nil
+	}
+	return fmt.Errorf(
+		"serialization should be json, csv, yaml or markdown_table not %q",
+		serialization)
+}
+
+// Renders a row serialized as JSON in another format. Tables only
+// name the columns once, which saves many tokens for wide rows. The
+// header comes from the first row - a row with different columns
+// starts a new table.
+type rowRenderer struct {
+	serialization string
+	columns       []string
+}
+
+// Returns the rendered row and, if the row starts a table, its
+// header with the table's columns. The renderer is not changed so a
+// row which is left out does not start a table - call SetColumns
+// once the row is used.
+func (self *rowRenderer) Render(serialized []byte) (
+	line string, header string, columns []string, err error) {
+	if self.serialization == "" || self.serialization == SERIALIZATION_JSON {
+		return string(serialized), "", nil, nil
+	}
+
+	row, err := utils.ParseJsonToObject(serialized)
+	if err != nil {
+		// Not an object so can not be a table.
+		return string(serialized), "", nil, nil
+	}
+
+	if self.serialization == SERIALIZATION_YAML {
+		line, err = renderYaml(row)
+		return line, "", nil, err
+	}
+
+	columns = row.Keys()
+	if !utils.StringSliceEq(columns, self.columns) {
+		header = self.renderCells(columns)
+		if self.serialization == SERIALIZATION_MARKDOWN_TABLE {
+			separators := make([]string, 0, len(columns))
+			for range columns {
+				separators = append(separators, "---")
+			}
+			header += "\n" + self.renderCells(separators)
+		}
+	}
+
+	cells := make([]string, 0, len(columns))
+	for _, k := range columns {
+		v, _ := row.Get(k)
+		cells = append(cells, renderCell(v))
+	}
+	return self.renderCells(cells), header, columns, nil
+}
+
+func (self *rowRenderer) SetColumns(columns []string) {
+	self.columns = columns
+}
+
+func (self *rowRenderer) renderCells(cells []string) string {
+	if self.serialization == SERIALIZATION_MARKDOWN_TABLE {
+		escaped := make([]string, 0, len(cells))
+		for _, cell := range cells {
+			cell = strings.ReplaceAll(cell, "|", "\\|")
+			cell = strings.ReplaceAll(cell, "\r\n", "<br>")
+			escaped = append(escaped, strings.ReplaceAll(cell, "\n", "<br>"))
+		}
+		return "| " + strings.Join(escaped, " | ") + " |"
+	}
+
+	buf := &bytes.Buffer{}
+	writer := csv.NewWriter(buf)
+	_ = writer.Write(cells)
+	writer.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// Strings are included as is and other values as JSON.
+func renderCell(value interface{}) string {
+	switch t := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	}
+
+	serialized, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(serialized)
+}
+
+// Each row is an item of a YAML list.
+func renderYaml(row *ordereddict.Dict) (string, error) {
+	serialized, err := yaml.Marshal(row)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(serialized), "\n"), "\n")
+	for idx := range lines {
+		if idx == 0 {
+			lines[idx] = "- " + lines[idx]
+		} else {
+			lines[idx] = "  " + lines[idx]
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
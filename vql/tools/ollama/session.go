@@ -0,0 +1,132 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Velocidex/ordereddict"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/constants"
+	"www.velocidex.com/golang/velociraptor/file_store"
+	"www.velocidex.com/golang/velociraptor/file_store/api"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/paths"
+	"www.velocidex.com/golang/velociraptor/result_sets"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+)
+
+var (
+	// Serialize appends so the turns of concurrent calls on the same
+	// session are not interleaved.
+	session_mu sync.Mutex
+)
+
+// Each turn of the conversation is stored as a row in a result set.
+type sessionEntry struct {
+	Role    string  `json:"role"`
+	Content string  `json:"content"`
+	Model   string  `json:"model,omitempty"`
+	Context []int64 `json:"context,omitempty"`
+	Time    int64   `json:"time"`
+}
+
+// A session persists the conversation across calls so follow up
+// questions can refer to earlier answers without resending the
+// original rows.
+type Session struct {
+	config_obj *config_proto.Config
+	path       api.FSPathSpec
+
+	Messages []*Message
+
+	// The context array returned by the last generate call. This
+	// encodes the conversation so far.
+	Context []int64
+}
+
+func LoadSession(ctx context.Context,
+	scope vfilter.Scope, name string) (*Session, error) {
+	config_obj, ok := vql_subsystem.GetServerConfig(scope)
+	if !ok {
+		return nil, errors.New("Sessions are only available on the server")
+	}
+
+	principal := vql_subsystem.GetPrincipal(scope)
+	if principal == "" {
+		principal = constants.PinnedServerName
+	}
+
+	result := &Session{
+		config_obj: config_obj,
+		path:       paths.LLMPathManager{}.Session(principal, name),
+	}
+
+	reader, err := result_sets.NewResultSetReader(
+		file_store.GetFileStore(config_obj), result.path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	for row := range reader.Rows(ctx) {
+		entry := &sessionEntry{}
+		serialized, err := json.Marshal(row)
+		if err != nil {
+			continue
+		}
+
+		err = json.Unmarshal(serialized, entry)
+		if err != nil {
+			continue
+		}
+
+		result.Messages = append(result.Messages, &Message{
+			Role:    entry.Role,
+			Content: entry.Content,
+		})
+
+		if len(entry.Context) > 0 {
+			result.Context = entry.Context
+		}
+	}
+
+	return result, nil
+}
+
+// Record a completed exchange.
+func (self *Session) Append(model string,
+	prompt, response string, llm_context []int64) error {
+	session_mu.Lock()
+	defer session_mu.Unlock()
+
+	rs_writer, err := result_sets.NewResultSetWriter(
+		file_store.GetFileStore(self.config_obj), self.path,
+		json.DefaultEncOpts(), utils.SyncCompleter, result_sets.AppendMode)
+	if err != nil {
+		return err
+	}
+	defer rs_writer.Close()
+
+	now := utils.GetTime().Now().Unix()
+	rs_writer.Write(ordereddict.NewDict().
+		Set("role", "user").
+		Set("content", prompt).
+		Set("model", model).
+		Set("time", now))
+	rs_writer.Write(ordereddict.NewDict().
+		Set("role", "assistant").
+		Set("content", response).
+		Set("model", model).
+		Set("context", llm_context).
+		Set("time", now))
+
+	self.Messages = append(self.Messages,
+		&Message{Role: "user", Content: prompt},
+		&Message{Role: "assistant", Content: response})
+	self.Context = llm_context
+
+	return nil
+}
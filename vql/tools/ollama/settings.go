@@ -0,0 +1,175 @@
+package ollama
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/config"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/datastore"
+	"www.velocidex.com/golang/velociraptor/paths"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+)
+
+// The AI settings of the org the query runs in. Queries on clients
+// have no settings so nothing is enforced there.
+func getSettings(scope vfilter.Scope) *config_proto.AIConfig {
+	config_obj, ok := vql_subsystem.GetServerConfig(scope)
+	if !ok {
+		return &config_proto.AIConfig{}
+	}
+
+	settings, err := GetAISettings(config_obj)
+	if err != nil {
+		scope.Log("ollama: Unable to read AI settings: %v", err)
+		return &config_proto.AIConfig{}
+	}
+	return settings
+}
+
+// Settings stored in the datastore by the GUI take precedence over
+// the AI section of the config file.
+func GetAISettings(
+	config_obj *config_proto.Config) (*config_proto.AIConfig, error) {
+	db, err := datastore.GetDB(config_obj)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &config_proto.AIConfig{}
+	err = db.GetSubject(config_obj, paths.AISettings, result)
+	if err == nil {
+		return result, nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if config_obj.AI != nil {
+		return config_obj.AI, nil
+	}
+	return result, nil
+}
+
+func SetAISettings(config_obj *config_proto.Config,
+	settings *config_proto.AIConfig) error {
+	err := config.ValidateAIConfig(settings)
+	if err != nil {
+		return err
+	}
+
+	db, err := datastore.GetDB(config_obj)
+	if err != nil {
+		return err
+	}
+
+	return db.SetSubject(config_obj, paths.AISettings, settings)
+}
+
+func notAllowed(endpoint string, err error) error {
+	return &Error{Code: ERROR_NOT_ALLOWED, Endpoint: endpoint, Err: err}
+}
+
+// The model to use when the query does not name one.
+func (self *Client) Model(model string) (string, error) {
+	if model == "" {
+		model = self.settings.DefaultModel
+	}
+	if model == "" {
+		return "", errors.New("model must be set when there is no AI.default_model")
+	}
+	return model, nil
+}
+
+func (self *Client) checkModel(path, model string) error {
+	// Pinned models are allowed by their name.
+	name, _ := splitPinnedModel(model)
+	allowed := self.settings.AllowedModels
+	if len(allowed) > 0 && !utils.InString(allowed, model) &&
+		!utils.InString(allowed, name) {
+		return notAllowed(self.base_url+path,
+			fmt.Errorf("Model %v is not in AI.allowed_models", model))
+	}
+	return nil
+}
+
+func (self *Client) checkPrompt(path, model string, prompts ...string) error {
+	limit := self.settings.MaxPromptTokens
+	if limit <= 0 {
+		return nil
+	}
+
+	var tokens int64
+	for _, prompt := range prompts {
+		tokens += EstimateTokens(model, prompt)
+	}
+	if tokens > limit {
+		return notAllowed(self.base_url+path, fmt.Errorf(
+			"Prompt of about %v tokens is over AI.max_prompt_tokens (%v)",
+			tokens, limit))
+	}
+	return nil
+}
+
+// Lower num_predict to the configured limit.
+func (self *Client) capResponse(options *ordereddict.Dict) *ordereddict.Dict {
+	limit := self.settings.MaxResponseTokens
+	if limit <= 0 {
+		return options
+	}
+
+	if options != nil {
+		value, pres := options.Get("num_predict")
+		if pres {
+			num_predict, ok := utils.ToInt64(value)
+			if ok && num_predict > 0 && num_predict <= limit {
+				return options
+			}
+		}
+	}
+	return withOption(options, "num_predict", limit)
+}
+
+// Returns the request to send after applying the settings.
+func (self *Client) checkGenerate(req *GenerateRequest) (*GenerateRequest, error) {
+	path := "/api/generate"
+	err := self.checkModel(path, req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	err = self.checkPrompt(path, req.Model, req.System, req.Prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	result := *req
+	result.Options = self.capResponse(req.Options)
+	return &result, nil
+}
+
+func (self *Client) checkChat(req *ChatRequest) (*ChatRequest, error) {
+	path := "/api/chat"
+	err := self.checkModel(path, req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	prompts := make([]string, 0, len(req.Messages))
+	for _, message := range req.Messages {
+		prompts = append(prompts, message.Content)
+	}
+	err = self.checkPrompt(path, req.Model, prompts...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := *req
+	result.Options = self.capResponse(req.Options)
+	return &result, nil
+}
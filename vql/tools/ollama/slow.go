@@ -0,0 +1,101 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	DEFAULT_SLOW_CALL_SECONDS = 120
+)
+
+// When a single call is considered slow. A negative value disables
+// the check.
+type slowCallThresholds struct {
+	duration time.Duration
+	tokens   int64
+}
+
+func newSlowCallThresholds(arg *OllamaPluginArgs) slowCallThresholds {
+	seconds := arg.SlowCallSeconds
+	if seconds == 0 {
+		seconds = DEFAULT_SLOW_CALL_SECONDS
+	}
+
+	return slowCallThresholds{
+		duration: time.Duration(seconds) * time.Second,
+		tokens:   arg.SlowCallTokens,
+	}
+}
+
+// Describe which thresholds the call exceeded, if any.
+func (self slowCallThresholds) exceeded(duration time.Duration,
+	stats *Stats) []string {
+	var reasons []string
+	if self.duration > 0 && duration > self.duration {
+		reasons = append(reasons, fmt.Sprintf("took %v (threshold %v)",
+			duration.Round(time.Second), self.duration))
+	}
+
+	if self.tokens > 0 && stats != nil {
+		tokens := stats.PromptEvalCount + stats.EvalCount
+		if tokens > self.tokens {
+			reasons = append(reasons, fmt.Sprintf("used %v tokens (threshold %v)",
+				tokens, self.tokens))
+		}
+	}
+	return reasons
+}
+
+// Warn about calls exceeding the thresholds. Such calls hold up the
+// query so the artifact may be better off using chunk_size or an
+// async collection. If emit_row is set a row describing the call is
+// also sent to the output.
+func slowCallGenerate(scope vfilter.Scope, thresholds slowCallThresholds,
+	emit_row bool, generate generateFunc,
+	output_chan chan vfilter.Row) generateFunc {
+	return func(ctx context.Context, req *GenerateRequest,
+		cb func(resp *GenerateResponse) error) error {
+		start := time.Now()
+
+		var stats *Stats
+		err := generate(ctx, req, func(chunk *GenerateResponse) error {
+			if chunk.Done {
+				stats = &chunk.Stats
+			}
+			return cb(chunk)
+		})
+
+		duration := time.Since(start)
+		reasons := thresholds.exceeded(duration, stats)
+		if len(reasons) == 0 {
+			return err
+		}
+
+		scope.Log("WARN:ollama: Slow call to model %v: %v. Consider using chunk_size or a smaller prompt.",
+			req.Model, strings.Join(reasons, ", "))
+
+		if emit_row {
+			slow_call := ordereddict.NewDict().
+				Set("Duration", duration.Seconds()).
+				Set("Reasons", reasons)
+			if stats != nil {
+				slow_call.Set("PromptEvalCount", stats.PromptEvalCount).
+					Set("EvalCount", stats.EvalCount)
+			}
+
+			select {
+			case <-ctx.Done():
+			case output_chan <- ordereddict.NewDict().
+				Set("Model", req.Model).
+				Set("SlowCall", slow_call):
+			}
+		}
+		return err
+	}
+}
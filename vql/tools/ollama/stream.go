@@ -0,0 +1,146 @@
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"www.velocidex.com/golang/velociraptor/utils/sse"
+)
+
+const (
+	// The largest message accepted from a stream. Chunks usually
+	// hold a single token but the final chunk also holds the context,
+	// which grows with the size of the context window.
+	MAX_STREAM_MESSAGE = 16 * 1024 * 1024
+)
+
+var (
+	// Lines gateways and proxies send to keep idle connections open.
+	keep_alive_lines = map[string]bool{
+		"ping":       true,
+		"keep-alive": true,
+		"keepalive":  true,
+		"[DONE]":     true,
+	}
+)
+
+// The stream could not be decoded. The start of the offending data
+// is kept for the error message.
+type streamError struct {
+	data []byte
+	err  error
+}
+
+func (self *streamError) Error() string {
+	return self.err.Error()
+}
+
+func (self *streamError) Unwrap() error {
+	return self.err
+}
+
+// Ollama streams responses as newline delimited JSON objects, but the
+// gateways and proxies in front of it do not always pass them through
+// unchanged. Keep-alive lines and server sent event framing are
+// skipped, objects split across lines are joined and objects sharing
+// a line are separated. Each message is limited to max_message bytes
+// so a misbehaving server can not exhaust memory.
+//
+// Errors from the callback and the reader are passed through, a
+// stream which is not JSON returns a *streamError.
+func readNDJSON(reader io.Reader, max_message int,
+	cb func(message []byte) error) error {
+	buf := bufio.NewReader(reader)
+
+	// An incomplete object waiting for the rest of its lines.
+	var pending []byte
+	for {
+		line, err := sse.ReadLine(buf, max_message-len(pending))
+		if errors.Is(err, sse.ErrMessageTooLarge) {
+			return &streamError{data: append(pending, line...),
+				err: fmt.Errorf("%w (the limit is %v bytes)", err, max_message)}
+		}
+
+		if len(pending) > 0 {
+			// The object continues on this line.
+			pending = append(pending, bytes.TrimRight(line, "\r\n")...)
+
+		} else {
+			line = trimStreamFraming(bytes.TrimSpace(line))
+			if len(line) > 0 && !keep_alive_lines[string(line)] {
+				if line[0] != '{' {
+					return &streamError{data: line,
+						err: errors.New("expected a JSON object")}
+				}
+				pending = line
+			}
+		}
+
+		var decode_err error
+		pending, decode_err = decodeMessages(pending, cb)
+		if decode_err != nil {
+			return decode_err
+		}
+
+		if errors.Is(err, io.EOF) {
+			if len(pending) > 0 {
+				return &streamError{data: pending,
+					err: errors.New("stream ended within a message")}
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Some gateways relay the stream as server sent events.
+func trimStreamFraming(line []byte) []byte {
+	switch {
+	case bytes.HasPrefix(line, []byte(":")),
+		bytes.HasPrefix(line, []byte("event:")),
+		bytes.HasPrefix(line, []byte("id:")),
+		bytes.HasPrefix(line, []byte("retry:")):
+		return nil
+
+	case bytes.HasPrefix(line, []byte("data:")):
+		return bytes.TrimSpace(line[len("data:"):])
+	}
+	return line
+}
+
+// Pass each complete object in data to the callback, returning
+// whatever follows the last complete object. Errors from the
+// callback are passed through.
+func decodeMessages(data []byte,
+	cb func(message []byte) error) ([]byte, error) {
+	for len(data) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+
+		var message json.RawMessage
+		err := decoder.Decode(&message)
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return data, nil
+		}
+		if err != nil {
+			return nil, &streamError{data: data, err: err}
+		}
+
+		if len(message) == 0 || message[0] != '{' {
+			return nil, &streamError{data: data,
+				err: errors.New("expected a JSON object")}
+		}
+
+		err = cb(message)
+		if err != nil {
+			return nil, err
+		}
+		data = bytes.TrimSpace(data[decoder.InputOffset():])
+	}
+	return nil, nil
+}
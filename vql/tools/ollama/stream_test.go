@@ -0,0 +1,109 @@
+package ollama
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeStream(stream string, max_message int) ([]string, error) {
+	var messages []string
+	err := readNDJSON(strings.NewReader(stream), max_message,
+		func(message []byte) error {
+			messages = append(messages, string(message))
+			return nil
+		})
+	return messages, err
+}
+
+func TestReadNDJSON(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		stream   string
+		expected []string
+		err      string
+	}{
+		{name: "plain",
+			stream:   "{\"a\":1}\n{\"a\":2}\n",
+			expected: []string{`{"a":1}`, `{"a":2}`}},
+		{name: "no final newline",
+			stream:   "{\"a\":1}\n{\"a\":2}",
+			expected: []string{`{"a":1}`, `{"a":2}`}},
+		{name: "keep alives",
+			stream:   "\n{\"a\":1}\r\n\nping\n: comment\nkeep-alive\n{\"a\":2}\n",
+			expected: []string{`{"a":1}`, `{"a":2}`}},
+		{name: "server sent events",
+			stream:   "event: message\ndata: {\"a\":1}\n\ndata: [DONE]\n",
+			expected: []string{`{"a":1}`}},
+		{name: "split across lines",
+			stream:   "{\"a\":\n\"hello world\",\n\"b\":2}\n{\"a\":3}\n",
+			expected: []string{`{"a":"hello world","b":2}`, `{"a":3}`}},
+		{name: "sharing a line",
+			stream:   "{\"a\":1}{\"a\":2} {\"a\":3}\n",
+			expected: []string{`{"a":1}`, `{"a":2}`, `{"a":3}`}},
+		{name: "html error page",
+			stream: "<html><body>Bad Gateway</body></html>\n",
+			err:    "expected a JSON object"},
+		{name: "malformed",
+			stream:   "{\"a\":1}\n{\"a\":]\n",
+			expected: []string{`{"a":1}`},
+			err:      "invalid character"},
+		{name: "truncated",
+			stream:   "{\"a\":1}\n{\"a\":\"hel",
+			expected: []string{`{"a":1}`},
+			err:      "stream ended within a message"},
+		{name: "too large",
+			stream:   "{\"a\":1}\n{\"a\":\"" + strings.Repeat("x", 100) + "\"}\n",
+			expected: []string{`{"a":1}`},
+			err:      "message too large"},
+		{name: "too large across lines",
+			stream: "{\"a\":\n" + strings.Repeat("1", 50) + "\n" + strings.Repeat("2", 50) + "}\n",
+			err:    "message too large"},
+	} {
+		messages, err := decodeStream(test.stream, 64)
+		assert.Equal(t, test.expected, messages, test.name)
+
+		if test.err == "" {
+			assert.NoError(t, err, test.name)
+			continue
+		}
+
+		var stream_err *streamError
+		assert.True(t, errors.As(err, &stream_err), test.name)
+		assert.ErrorContains(t, err, test.err, test.name)
+	}
+}
+
+func TestReadNDJSONCallbackError(t *testing.T) {
+	stop := errors.New("stop")
+	err := readNDJSON(strings.NewReader("{\"a\":1}\n{\"a\":2}\n"), 64,
+		func(message []byte) error {
+			return stop
+		})
+	assert.Equal(t, stop, err)
+}
+
+// Whatever the server sends, only JSON objects within the limit reach
+// the callback and the decoder does not panic.
+func FuzzReadNDJSON(f *testing.F) {
+	f.Add([]byte("{\"response\":\"Hello \",\"done\":false}\n{\"done\":true}\n"))
+	f.Add([]byte("data: {\"a\":1}\n\nping\n{\"a\":\n2}{\"b\":3}\n"))
+	f.Add([]byte("{\"a\":\"\\u00\n"))
+	f.Add([]byte("<html>\n"))
+
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		_ = readNDJSON(bytes.NewReader(stream), 256, func(message []byte) error {
+			if len(message) > 256 {
+				t.Fatalf("message of %v bytes is over the limit", len(message))
+			}
+			if len(message) == 0 || message[0] != '{' || !json.Valid(message) {
+				t.Fatalf("invalid message %q", message)
+			}
+			return nil
+		})
+	})
+}
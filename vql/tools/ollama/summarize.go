@@ -0,0 +1,267 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	AUDIENCE_TECHNICAL = "technical"
+	AUDIENCE_EXECUTIVE = "executive"
+
+	DEFAULT_TARGET_WORDS = 200
+
+	// Tokens of the context window kept for the instructions around
+	// each chunk.
+	SUMMARIZE_PROMPT_RESERVE = 256
+
+	// Each level summarizes the summaries of the previous one.
+	MAX_SUMMARY_LEVELS = 4
+)
+
+var audience_instructions = map[string]string{
+	AUDIENCE_TECHNICAL: "Write for incident responders. Keep technical " +
+		"details such as hosts, users, paths, hashes, timestamps and " +
+		"commands.",
+	AUDIENCE_EXECUTIVE: "Write for executives in plain language. Explain " +
+		"the impact and risk to the business and any decisions needed, " +
+		"without technical details such as paths, hashes or commands.",
+}
+
+type LLMSummarizeFunctionArgs struct {
+	Text        string            `vfilter:"required,field=text,doc=The text to summarize. Long texts are summarized in chunks which are then combined."`
+	TargetWords int64             `vfilter:"optional,field=target_words,doc=The most words the summary should have (default 200)."`
+	Audience    string            `vfilter:"optional,field=audience,doc=Who the summary is for: technical (default) or executive."`
+	Focus       string            `vfilter:"optional,field=focus,doc=What the summary should concentrate on (e.g. lateral movement)."`
+	ChunkTokens int64             `vfilter:"optional,field=chunk_tokens,doc=The most tokens of text sent in each call (default what fits in the model's context window)."`
+	Model       string            `vfilter:"optional,field=model,doc=The model to use (e.g. llama3). Defaults to AI.default_model in the config."`
+	BaseUrl     string            `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server. Several comma separated URLs, or srv://name for the servers of a DNS SRV record, spread the calls over the servers."`
+	Timeout     int64             `vfilter:"optional,field=timeout,doc=Seconds the call may take (default 3600)."`
+	Options     *ordereddict.Dict `vfilter:"optional,field=options,doc=Model options (e.g. temperature, num_ctx)."`
+	KeepAlive   string            `vfilter:"optional,field=keep_alive,doc=How long the model stays loaded after the call (e.g. 5m)."`
+}
+
+// Summarizes text of any length for an audience. Text which does not
+// fit in the context window is split into chunks which are each
+// summarized, then the summaries are combined into the final one. If
+// the summaries are still too long they are summarized again the
+// same way.
+type LLMSummarizeFunction struct{}
+
+func (self LLMSummarizeFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("llm_summarize", args)()
+
+	err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+	if err != nil {
+		scope.Log("llm_summarize: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg := &LLMSummarizeFunctionArgs{}
+	err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("llm_summarize: %v", err)
+		return vfilter.Null{}
+	}
+
+	if arg.TargetWords <= 0 {
+		arg.TargetWords = DEFAULT_TARGET_WORDS
+	}
+
+	if arg.Audience == "" {
+		arg.Audience = AUDIENCE_TECHNICAL
+	}
+
+	audience, pres := audience_instructions[arg.Audience]
+	if !pres {
+		scope.Log("llm_summarize: audience should be technical or executive not %q",
+			arg.Audience)
+		return vfilter.Null{}
+	}
+
+	text := strings.TrimSpace(cleanText(arg.Text))
+	if text == "" {
+		scope.Log("llm_summarize: Nothing to summarize")
+		return vfilter.Null{}
+	}
+
+	client, err := NewClient(ctx, scope, arg.BaseUrl)
+	if err != nil {
+		scope.Log("llm_summarize: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg.Model, err = client.Model(arg.Model)
+	if err != nil {
+		scope.Log("llm_summarize: %v", err)
+		return vfilter.Null{}
+	}
+
+	client = client.WithTimeouts(Timeouts{
+		Total: time.Duration(arg.Timeout) * time.Second,
+	})
+
+	if arg.ChunkTokens <= 0 {
+		num_ctx, err := client.ContextWindow(ctx, arg.Model, arg.Options)
+		if err != nil {
+			scope.Log("llm_summarize: %v", err)
+			return vfilter.Null{}
+		}
+		arg.ChunkTokens = num_ctx - responseReserve(arg.Options) -
+			SUMMARIZE_PROMPT_RESERVE
+		if arg.ChunkTokens <= 0 {
+			scope.Log("llm_summarize: The context window of %v is too small", arg.Model)
+			return vfilter.Null{}
+		}
+	}
+
+	summarizer := &textSummarizer{
+		arg:      arg,
+		audience: audience,
+		generate: logGenerate(scope, client, client.Generate),
+	}
+
+	summary, err := summarizer.Summarize(ctx, scope, text)
+	if err != nil {
+		scope.Log("llm_summarize: %v", err)
+		return vfilter.Null{}
+	}
+	return summary
+}
+
+type textSummarizer struct {
+	arg      *LLMSummarizeFunctionArgs
+	audience string
+	generate generateFunc
+}
+
+func (self *textSummarizer) Summarize(ctx context.Context,
+	scope vfilter.Scope, text string) (string, error) {
+	for level := 0; ; level++ {
+		chunks := splitTextChunks(self.arg.Model, text, self.arg.ChunkTokens)
+		if len(chunks) == 1 {
+			return self.call(ctx, self.finalPrompt(level > 0), chunks[0])
+		}
+
+		if level >= MAX_SUMMARY_LEVELS {
+			return "", errors.New("The summaries do not fit in the context window, try a smaller target_words")
+		}
+
+		scope.Log("DEBUG:llm_summarize: Summarizing %v chunks", len(chunks))
+
+		summaries := make([]string, 0, len(chunks))
+		for idx, chunk := range chunks {
+			summary, err := self.call(ctx,
+				self.partPrompt(level > 0, idx, len(chunks)), chunk)
+			if err != nil {
+				return "", err
+			}
+			summaries = append(summaries, summary)
+		}
+		text = strings.Join(summaries, "\n\n")
+	}
+}
+
+// Each part gets the same word budget as the final summary so
+// nothing the final summary needs is lost.
+func (self *textSummarizer) partPrompt(combining bool, idx, total int) string {
+	prompt := fmt.Sprintf("This is part %d of %d of a longer text. "+
+		"Summarize it in at most %d words, keeping the facts a summary "+
+		"of the whole text would need.", idx+1, total, self.arg.TargetWords)
+	if combining {
+		prompt = fmt.Sprintf("These are summaries of consecutive parts "+
+			"of a longer text (group %d of %d). Combine them into one "+
+			"summary of at most %d words.", idx+1, total, self.arg.TargetWords)
+	}
+	return self.withFocus(prompt)
+}
+
+func (self *textSummarizer) finalPrompt(combining bool) string {
+	prompt := fmt.Sprintf("Summarize the text below in at most %d words.",
+		self.arg.TargetWords)
+	if combining {
+		prompt = fmt.Sprintf("These are summaries of consecutive parts "+
+			"of a longer text. Combine them into one summary of the whole "+
+			"text in at most %d words.", self.arg.TargetWords)
+	}
+	return self.withFocus(prompt) + " " + self.audience +
+		" Respond with only the summary."
+}
+
+func (self *textSummarizer) withFocus(prompt string) string {
+	if self.arg.Focus != "" {
+		prompt += " Focus on " + self.arg.Focus + "."
+	}
+	return prompt
+}
+
+func (self *textSummarizer) call(ctx context.Context,
+	prompt, text string) (string, error) {
+	response, err := generateText(ctx, self.generate, &GenerateRequest{
+		Model:     self.arg.Model,
+		Prompt:    prompt + "\n\n" + text,
+		Options:   self.arg.Options,
+		KeepAlive: self.arg.KeepAlive,
+	})
+	return strings.TrimSpace(response), err
+}
+
+// Split text into chunks of at most max_tokens, between lines where
+// possible and otherwise between words.
+func splitTextChunks(model, text string, max_tokens int64) []string {
+	var chunks []string
+	chunk := &strings.Builder{}
+	chunk_tokens := int64(0)
+
+	add := func(piece string, tokens int64) {
+		if chunk_tokens > 0 && chunk_tokens+tokens > max_tokens {
+			chunks = append(chunks, chunk.String())
+			chunk.Reset()
+			chunk_tokens = 0
+		}
+		chunk.WriteString(piece)
+		chunk_tokens += tokens
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		tokens := EstimateTokens(model, line)
+		if tokens <= max_tokens {
+			add(line, tokens)
+			continue
+		}
+
+		for _, word := range strings.SplitAfter(line, " ") {
+			add(word, EstimateTokens(model, word))
+		}
+	}
+
+	if chunk.Len() > 0 {
+		chunks = append(chunks, chunk.String())
+	}
+	return chunks
+}
+
+func (self LLMSummarizeFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:     "llm_summarize",
+		Doc:      "Summarize text of any length for a technical or executive audience with a model.",
+		ArgType:  type_map.AddType(scope, &LLMSummarizeFunctionArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&LLMSummarizeFunction{})
+}
@@ -0,0 +1,59 @@
+package ollama
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	// Terminal escape sequences: CSI (colors, cursor movement), OSC
+	// (window titles, hyperlinks) and two character escapes.
+	ansi_regex = regexp.MustCompile(
+		`\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[@-Z\\-_])`)
+)
+
+// Attackers hide instructions to the model in file names and other
+// strings using terminal escapes, NULs and bidi overrides, which also
+// make the text differ from what an analyst sees. These are removed
+// and the text normalized to NFC before it is placed in a prompt.
+func cleanText(value string) string {
+	if !needsCleaning(value) {
+		return value
+	}
+
+	value = ansi_regex.ReplaceAllString(value, "")
+	value = strings.Map(func(r rune) rune {
+		if r == 0 || isBidiControl(r) {
+			return -1
+		}
+		return r
+	}, value)
+
+	return norm.NFC.String(value)
+}
+
+// Most values are plain ASCII text which needs no changes.
+func needsCleaning(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c >= 0x80 || c == 0 || c == 0x1b {
+			return utf8.ValidString(value)
+		}
+	}
+	return false
+}
+
+func isBidiControl(r rune) bool {
+	switch {
+	case r == '\u061c', r == '\u200e', r == '\u200f':
+		return true
+	case r >= '\u202a' && r <= '\u202e':
+		return true
+	case r >= '\u2066' && r <= '\u2069':
+		return true
+	}
+	return false
+}
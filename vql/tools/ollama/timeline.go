@@ -0,0 +1,197 @@
+package ollama
+
+import (
+	"context"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/velociraptor/vql/functions"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	DEFAULT_ANNOTATION_TIMESTAMP = "Timestamp"
+	ANNOTATION_SOURCE            = "AI"
+)
+
+// Columns tried in order for the annotation message.
+var default_annotation_columns = []string{"Summary", "Response"}
+
+// Columns of the analysis rows kept in the annotation event.
+var annotation_columns = []string{"ClientId", "Model", "Verdict"}
+
+type OllamaAnnotateTimelinePluginArgs struct {
+	Query           vfilter.StoredQuery `vfilter:"required,field=query,doc=The AI analysis rows to add as annotations (e.g. from ollama_analyses())."`
+	Timeline        string              `vfilter:"required,field=timeline,doc=The super timeline to annotate. It is created if it does not exist."`
+	NotebookId      string              `vfilter:"optional,field=notebook_id,doc=The notebook the super timeline is stored in (default the current notebook)."`
+	TimestampColumn string              `vfilter:"optional,field=timestamp_column,doc=The column holding the time of the event (default Timestamp)."`
+	MessageColumn   string              `vfilter:"optional,field=message_column,doc=The column holding the note (default Summary or Response)."`
+	FlowId          string              `vfilter:"optional,field=flow_id,doc=The flow the analysis came from (default the FlowId column)."`
+}
+
+// Writes AI analyses into a super timeline as annotations so the
+// narrative sits next to the evidence it describes. Each row becomes
+// an annotation event with its time, the note and the flow it came
+// from, the same as annotations added in the GUI.
+type OllamaAnnotateTimelinePlugin struct{}
+
+func (self OllamaAnnotateTimelinePlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("ollama_annotate_timeline", args)()
+
+		err := vql_subsystem.CheckAccess(scope, acls.NOTEBOOK_EDITOR)
+		if err != nil {
+			scope.Log("ollama_annotate_timeline: %v", err)
+			return
+		}
+
+		arg := &OllamaAnnotateTimelinePluginArgs{}
+		err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+		if err != nil {
+			scope.Log("ollama_annotate_timeline: %v", err)
+			return
+		}
+
+		if arg.TimestampColumn == "" {
+			arg.TimestampColumn = DEFAULT_ANNOTATION_TIMESTAMP
+		}
+
+		if arg.NotebookId == "" {
+			arg.NotebookId = vql_subsystem.GetStringFromRow(
+				scope, scope, "NotebookId")
+		}
+
+		if arg.NotebookId == "" {
+			scope.Log("ollama_annotate_timeline: Notebook ID must be specified")
+			return
+		}
+
+		err = services.RequireFrontend()
+		if err != nil {
+			scope.Log("ollama_annotate_timeline: %v", err)
+			return
+		}
+
+		config_obj, ok := vql_subsystem.GetServerConfig(scope)
+		if !ok {
+			scope.Log("ollama_annotate_timeline: Command can only run on the server")
+			return
+		}
+
+		notebook_manager, err := services.GetNotebookManager(config_obj)
+		if err != nil {
+			scope.Log("ollama_annotate_timeline: %v", err)
+			return
+		}
+
+		_, err = notebook_manager.GetNotebook(ctx, arg.NotebookId,
+			services.DO_NOT_INCLUDE_UPLOADS)
+		if err != nil {
+			scope.Log("ollama_annotate_timeline: %v: %v", arg.NotebookId, err)
+			return
+		}
+
+		principal := vql_subsystem.GetPrincipal(scope)
+
+		for row := range arg.Query.Eval(ctx, scope) {
+			row_dict := vfilter.RowToDict(ctx, scope, row)
+
+			ts_any, _ := row_dict.Get(arg.TimestampColumn)
+			if utils.IsNil(ts_any) {
+				scope.Log("ollama_annotate_timeline: Row has no %v column",
+					arg.TimestampColumn)
+				continue
+			}
+
+			timestamp, err := functions.TimeFromAny(ctx, scope, ts_any)
+			if err != nil {
+				scope.Log("ollama_annotate_timeline: %v: %v",
+					arg.TimestampColumn, err)
+				continue
+			}
+
+			message := annotationMessage(row_dict, arg.MessageColumn)
+			if message == "" {
+				continue
+			}
+
+			flow_id := arg.FlowId
+			if flow_id == "" {
+				flow_id, _ = row_dict.GetString("FlowId")
+			}
+
+			event := ordereddict.NewDict().
+				Set("Message", message).
+				Set("Source", ANNOTATION_SOURCE).
+				Set("FlowId", flow_id)
+			for _, column := range annotation_columns {
+				value, pres := row_dict.Get(column)
+				if pres && !utils.IsNil(value) {
+					event.Set(column, value)
+				}
+			}
+
+			err = notebook_manager.AnnotateTimeline(ctx, scope,
+				arg.NotebookId, arg.Timeline, message, principal,
+				timestamp, event)
+			if err != nil {
+				scope.Log("ollama_annotate_timeline: %v", err)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case output_chan <- ordereddict.NewDict().
+				Set("Timestamp", timestamp.UTC()).
+				Set("Message", message).
+				Set("FlowId", flow_id).
+				Set("Timeline", arg.Timeline):
+			}
+		}
+	}()
+
+	return output_chan
+}
+
+func annotationMessage(row *ordereddict.Dict, column string) string {
+	if column != "" {
+		value, pres := row.Get(column)
+		if !pres || utils.IsNil(value) {
+			return ""
+		}
+		return utils.ToString(value)
+	}
+
+	for _, name := range default_annotation_columns {
+		value, pres := row.GetString(name)
+		if pres && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (self OllamaAnnotateTimelinePlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name:     "ollama_annotate_timeline",
+		Doc:      "Add AI analyses to a super timeline as annotation events.",
+		ArgType:  type_map.AddType(scope, &OllamaAnnotateTimelinePluginArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.NOTEBOOK_EDITOR).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterPlugin(&OllamaAnnotateTimelinePlugin{})
+}
@@ -0,0 +1,136 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_CONNECT_TIMEOUT     = 10 * time.Second
+	DEFAULT_FIRST_TOKEN_TIMEOUT = 5 * time.Minute
+	DEFAULT_TOTAL_TIMEOUT       = time.Hour
+)
+
+// Separate limits on each stage of a call. A gateway which does not
+// accept the connection or never starts answering is detected
+// quickly, while a slow but working generation may take as long as
+// the total timeout. Zero means the default.
+type Timeouts struct {
+	Connect    time.Duration
+	FirstToken time.Duration
+	Total      time.Duration
+}
+
+type timeoutError struct {
+	stage   string
+	timeout time.Duration
+}
+
+func (self *timeoutError) Error() string {
+	return fmt.Sprintf("%v timeout after %v", self.stage, self.timeout)
+}
+
+// Enforces the timeouts for a single call by cancelling its context.
+type watchdog struct {
+	mu           sync.Mutex
+	ctx          context.Context
+	cancel       context.CancelCauseFunc
+	total_cancel context.CancelFunc
+	timer        *time.Timer
+	first_token  bool
+}
+
+func newWatchdog(ctx context.Context, timeouts Timeouts) (context.Context, *watchdog) {
+	if timeouts.Connect == 0 {
+		timeouts.Connect = DEFAULT_CONNECT_TIMEOUT
+	}
+	if timeouts.FirstToken == 0 {
+		timeouts.FirstToken = DEFAULT_FIRST_TOKEN_TIMEOUT
+	}
+	if timeouts.Total == 0 {
+		timeouts.Total = DEFAULT_TOTAL_TIMEOUT
+	}
+
+	sub_ctx, cancel := context.WithCancelCause(ctx)
+	total_ctx, total_cancel := context.WithTimeoutCause(sub_ctx, timeouts.Total,
+		&timeoutError{stage: "total", timeout: timeouts.Total})
+
+	self := &watchdog{
+		ctx:          total_ctx,
+		cancel:       cancel,
+		total_cancel: total_cancel,
+	}
+	self.expireAfter("connect", timeouts.Connect)
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			self.stopTimer()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			self.mu.Lock()
+			first_token := self.first_token
+			self.mu.Unlock()
+
+			if !first_token {
+				self.expireAfter("first token", timeouts.FirstToken)
+			}
+		},
+	}
+
+	return httptrace.WithClientTrace(total_ctx, trace), self
+}
+
+// Replace the current stage timer.
+func (self *watchdog) expireAfter(stage string, timeout time.Duration) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.timer != nil {
+		self.timer.Stop()
+	}
+
+	cause := &timeoutError{stage: stage, timeout: timeout}
+	self.timer = time.AfterFunc(timeout, func() { self.cancel(cause) })
+}
+
+func (self *watchdog) stopTimer() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.timer != nil {
+		self.timer.Stop()
+		self.timer = nil
+	}
+}
+
+// Called as each chunk arrives. Once the model starts answering only
+// the total timeout applies.
+func (self *watchdog) GotToken() {
+	self.mu.Lock()
+	first_token := self.first_token
+	self.first_token = true
+	self.mu.Unlock()
+
+	if !first_token {
+		self.stopTimer()
+	}
+}
+
+// Describe the failure of the call, reporting a timeout if one of
+// the timers expired.
+func (self *watchdog) Close(endpoint string, err error) error {
+	self.stopTimer()
+
+	var timeout_err *timeoutError
+	if err != nil && errors.As(context.Cause(self.ctx), &timeout_err) {
+		err = &Error{Code: ERROR_TIMEOUT, Retryable: true,
+			Endpoint: endpoint, Err: timeout_err}
+	}
+	self.total_cancel()
+	self.cancel(nil)
+	return err
+}
@@ -0,0 +1,192 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	// Tokens are fetched again this long before they expire so calls
+	// in flight do not fail.
+	TOKEN_REFRESH_MARGIN = 5 * time.Minute
+)
+
+// Adds the credentials of a cloud provider to the requests sent to
+// it.
+type credentials interface {
+	Authorize(req *http.Request) error
+
+	// The server refused the credentials so they should be fetched
+	// again.
+	Invalidate()
+}
+
+// The endpoint of a cloud provider speaking the OpenAI API. Calls are
+// translated by the OpenAI transport and authorized with the
+// credentials.
+type cloudEndpoint struct {
+	base_url    string
+	headers     http.Header
+	credentials credentials
+}
+
+// The OpenAI transport's URL for the endpoint of the provider.
+func cloudBaseUrl(endpoint, api string) string {
+	base_url := OPENAI_SCHEME + strings.TrimSuffix(endpoint, "/")
+	if api != "" {
+		separator := "?"
+		if strings.Contains(base_url, "?") {
+			separator = "&"
+		}
+		base_url += separator + "api=" + url.QueryEscape(api)
+	}
+	return base_url
+}
+
+// Resolves the azure://, bedrock:// and vertex:// URLs, returning nil
+// for other servers.
+func resolveCloudUrl(ctx context.Context, scope vfilter.Scope,
+	base_url string, transport *http.Transport) (*cloudEndpoint, error) {
+	switch {
+	case isAzureUrl(base_url):
+		return resolveAzureUrl(ctx, scope, base_url, transport)
+
+	case isBedrockUrl(base_url):
+		return resolveBedrockUrl(ctx, scope, base_url, transport)
+
+	case isVertexUrl(base_url):
+		return resolveVertexUrl(ctx, scope, base_url, transport)
+	}
+	return nil, nil
+}
+
+// Authorizes each request and, when the server refuses the
+// credentials, fetches new ones and tries once more.
+type authTransport struct {
+	credentials credentials
+	transport   http.RoundTripper
+}
+
+func (self *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Each attempt sends a copy of the body.
+	if req.Body != nil && req.GetBody != nil {
+		defer req.Body.Close()
+	}
+
+	resp, err := self.send(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized ||
+		(req.Body != nil && req.GetBody == nil) {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	self.credentials.Invalidate()
+	return self.send(req)
+}
+
+func (self *authTransport) send(req *http.Request) (*http.Response, error) {
+	out := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		out.Body = body
+	}
+
+	// Reported as the server would report refused credentials.
+	err := self.credentials.Authorize(out)
+	if err != nil {
+		return nil, &openaiStatusError{status: http.StatusUnauthorized,
+			message: fmt.Sprintf("Unable to get credentials: %v", err)}
+	}
+	return self.transport.RoundTrip(out)
+}
+
+type accessToken struct {
+	token   string
+	expires time.Time
+}
+
+// Fetches a token from the identity provider.
+type tokenFetcher func(ctx context.Context) (*accessToken, error)
+
+// Bearer tokens shared by all clients using the same identity. A
+// token is fetched when first needed and again shortly before it
+// expires.
+type tokenCredentials struct {
+	mu    sync.Mutex
+	fetch tokenFetcher
+	token *accessToken
+}
+
+var (
+	token_mu          sync.Mutex
+	token_credentials = make(map[string]*tokenCredentials)
+)
+
+// Returns the credentials of the identity, sharing its tokens with
+// other clients using it.
+func getTokenCredentials(key string, fetch tokenFetcher) *tokenCredentials {
+	token_mu.Lock()
+	defer token_mu.Unlock()
+
+	result, pres := token_credentials[key]
+	if !pres {
+		result = &tokenCredentials{}
+		token_credentials[key] = result
+	}
+
+	// The identity's secret may have been updated.
+	result.mu.Lock()
+	result.fetch = fetch
+	result.mu.Unlock()
+
+	return result
+}
+
+func (self *tokenCredentials) Token(ctx context.Context) (string, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	now := utils.GetTime().Now()
+	if self.token != nil && now.Add(TOKEN_REFRESH_MARGIN).Before(self.token.expires) {
+		return self.token.token, nil
+	}
+
+	token, err := self.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if token.token == "" {
+		return "", errors.New("the identity provider returned no token")
+	}
+	self.token = token
+	return token.token, nil
+}
+
+func (self *tokenCredentials) Authorize(req *http.Request) error {
+	token, err := self.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (self *tokenCredentials) Invalidate() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.token = nil
+}
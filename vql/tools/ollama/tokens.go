@@ -0,0 +1,114 @@
+package ollama
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/json"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+// Splits text into the pieces a BPE tokenizer merges within. This is
+// the pre-tokenizer pattern used by tiktoken style vocabularies.
+var pretokenizer = regexp.MustCompile(
+	`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}|` +
+		` ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+// The average number of characters a token covers within a word for
+// a model family. Larger vocabularies merge more characters.
+var charsPerToken = []struct {
+	prefix string
+	chars  float64
+}{
+	{"llama2", 3.2},
+	{"mistral", 3.2},
+	{"mixtral", 3.2},
+	{"codellama", 3.2},
+	{"llama", 4.2},
+	{"gpt", 4.2},
+	{"qwen", 4.0},
+	{"gemma", 4.0},
+	{"phi", 3.5},
+}
+
+const DEFAULT_CHARS_PER_TOKEN = 3.5
+
+func tokenRatio(model string) float64 {
+	model = strings.ToLower(model)
+	for _, family := range charsPerToken {
+		if strings.HasPrefix(model, family.prefix) {
+			return family.chars
+		}
+	}
+	return DEFAULT_CHARS_PER_TOKEN
+}
+
+// Estimate how many tokens the text uses for the model. Every piece
+// is at least one token and long pieces are split according to the
+// model family's ratio.
+func EstimateTokens(model, text string) int64 {
+	ratio := tokenRatio(model)
+	result := int64(0)
+	for _, piece := range pretokenizer.FindAllString(text, -1) {
+		length := float64(utf8.RuneCountInString(piece))
+		tokens := int64(length/ratio + 0.5)
+		if tokens < 1 {
+			tokens = 1
+		}
+		result += tokens
+	}
+	return result
+}
+
+type CountTokensFunctionArgs struct {
+	Text  string              `vfilter:"optional,field=text,doc=The text to count."`
+	Query vfilter.StoredQuery `vfilter:"optional,field=query,doc=Count the rows of this query serialized as JSON lines, as they would be sent by ollama()."`
+	Model string              `vfilter:"optional,field=model,doc=The model the estimate is for (e.g. llama3). Determines the tokenizer family."`
+}
+
+type CountTokensFunction struct{}
+
+func (self CountTokensFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("count_tokens", args)()
+
+	arg := &CountTokensFunctionArgs{}
+	err := arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("count_tokens: %v", err)
+		return vfilter.Null{}
+	}
+
+	result := EstimateTokens(arg.Model, arg.Text)
+	if arg.Query != nil {
+		for row := range arg.Query.Eval(ctx, scope) {
+			serialized, err := json.Marshal(promptRow(ctx, scope, row))
+			if err != nil {
+				scope.Log("count_tokens: %v", err)
+				return vfilter.Null{}
+			}
+			result += EstimateTokens(arg.Model, string(serialized)+"\n")
+		}
+	}
+
+	return result
+}
+
+func (self CountTokensFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:    "count_tokens",
+		Doc:     "Estimate the number of tokens text or query rows use for a model.",
+		ArgType: type_map.AddType(scope, &CountTokensFunctionArgs{}),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&CountTokensFunction{})
+}
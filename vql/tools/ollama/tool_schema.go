@@ -0,0 +1,240 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/Velocidex/ordereddict"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+// The tool schemas of the model APIs.
+const (
+	TOOL_SCHEMA_OLLAMA           = "ollama"
+	TOOL_SCHEMA_OPENAI_CHAT      = "openai_chat"
+	TOOL_SCHEMA_OPENAI_RESPONSES = "openai_responses"
+	TOOL_SCHEMA_ANTHROPIC        = "anthropic"
+)
+
+const (
+	// The longest tool name the OpenAI and Anthropic APIs accept.
+	MAX_PROVIDER_TOOL_NAME = 64
+)
+
+var (
+	invalid_tool_name_regex = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+)
+
+// Tools are defined once as Ollama tool specs and rendered into the
+// schema of the API the call goes to. Ollama takes the spec as is,
+// the OpenAI chat completions API wraps the function the same way,
+// the responses API flattens it and Anthropic calls the parameters
+// the input_schema.
+func renderTools(tools []*ToolSpec, schema string,
+	names *toolNames) ([]*ordereddict.Dict, error) {
+	result := make([]*ordereddict.Dict, 0, len(tools))
+	for _, tool := range tools {
+		if tool.Function == nil {
+			return nil, fmt.Errorf("tool without a function")
+		}
+
+		name := tool.Function.Name
+		if names != nil {
+			name = names.Provider(name)
+		}
+		parameters := toolParameters(tool.Function.Parameters)
+
+		switch schema {
+		case TOOL_SCHEMA_OLLAMA, TOOL_SCHEMA_OPENAI_CHAT:
+			result = append(result, ordereddict.NewDict().
+				Set("type", "function").
+				Set("function", ordereddict.NewDict().
+					Set("name", name).
+					Set("description", tool.Function.Description).
+					Set("parameters", parameters)))
+
+		case TOOL_SCHEMA_OPENAI_RESPONSES:
+			result = append(result, ordereddict.NewDict().
+				Set("type", "function").
+				Set("name", name).
+				Set("description", tool.Function.Description).
+				Set("parameters", parameters))
+
+		case TOOL_SCHEMA_ANTHROPIC:
+			result = append(result, ordereddict.NewDict().
+				Set("name", name).
+				Set("description", tool.Function.Description).
+				Set("input_schema", parameters))
+
+		default:
+			return nil, fmt.Errorf("invalid tool schema %v", schema)
+		}
+	}
+	return result, nil
+}
+
+// The OpenAI and Anthropic APIs require the parameters to be an
+// object schema, even for tools without arguments.
+func toolParameters(parameters *ordereddict.Dict) *ordereddict.Dict {
+	if parameters == nil {
+		return ordereddict.NewDict().
+			Set("type", "object").
+			Set("properties", ordereddict.NewDict())
+	}
+
+	_, pres := parameters.Get("type")
+	if pres {
+		return parameters
+	}
+
+	result := ordereddict.NewDict().Set("type", "object")
+	for _, k := range parameters.Keys() {
+		v, _ := parameters.Get(k)
+		result.Set(k, v)
+	}
+	return result
+}
+
+// Ollama accepts any tool name but the OpenAI and Anthropic APIs only
+// letters, digits, _ and -. VQL tools are often named after artifacts
+// (e.g. Windows.System.Pslist) so names are rewritten for those APIs
+// and the tool calls they return are mapped back.
+type toolNames struct {
+	to_provider   map[string]string
+	from_provider map[string]string
+}
+
+func newToolNames(tools []*ToolSpec) *toolNames {
+	result := &toolNames{
+		to_provider:   make(map[string]string),
+		from_provider: make(map[string]string),
+	}
+
+	for _, tool := range tools {
+		if tool.Function != nil {
+			result.add(tool.Function.Name)
+		}
+	}
+	return result
+}
+
+func (self *toolNames) add(name string) string {
+	provider, pres := self.to_provider[name]
+	if pres {
+		return provider
+	}
+
+	provider = providerToolName(name, "")
+
+	// Different names may be rewritten to the same name.
+	for i := 2; ; i++ {
+		_, pres := self.from_provider[provider]
+		if !pres {
+			break
+		}
+		provider = providerToolName(name, fmt.Sprintf("_%d", i))
+	}
+
+	self.to_provider[name] = provider
+	self.from_provider[provider] = name
+	return provider
+}
+
+// The name the provider knows the tool by. Tools which were not
+// declared (e.g. in calls from an earlier turn) are added.
+func (self *toolNames) Provider(name string) string {
+	return self.add(name)
+}
+
+// The name of the tool the provider called.
+func (self *toolNames) Original(name string) string {
+	original, pres := self.from_provider[name]
+	if pres {
+		return original
+	}
+	return name
+}
+
+func providerToolName(name, suffix string) string {
+	result := invalid_tool_name_regex.ReplaceAllString(name, "_")
+	if result == "" {
+		result = "tool"
+	}
+
+	if len(result)+len(suffix) > MAX_PROVIDER_TOOL_NAME {
+		result = result[:MAX_PROVIDER_TOOL_NAME-len(suffix)]
+	}
+	return result + suffix
+}
+
+type OllamaToolSchemaFunctionArgs struct {
+	Tools  []*ordereddict.Dict `vfilter:"required,field=tools,doc=The tools as given to ollama_chat() or ollama_agent(). Each is a dict with name, description and parameters (a JSON schema), other fields are ignored."`
+	Schema string              `vfilter:"optional,field=schema,doc=The schema to render: ollama (the default), openai_chat, openai_responses or anthropic."`
+}
+
+// Shows the tool definitions as they are sent to each API.
+type OllamaToolSchemaFunction struct{}
+
+func (self OllamaToolSchemaFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("ollama_tool_schema", args)()
+
+	arg := &OllamaToolSchemaFunctionArgs{}
+	err := arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("ollama_tool_schema: %v", err)
+		return vfilter.Null{}
+	}
+
+	if arg.Schema == "" {
+		arg.Schema = TOOL_SCHEMA_OLLAMA
+	}
+
+	// Agent tools also carry the query and risk.
+	definitions := make([]*ordereddict.Dict, 0, len(arg.Tools))
+	for _, tool := range arg.Tools {
+		definition := ordereddict.NewDict()
+		for _, k := range []string{"name", "description", "parameters"} {
+			v, pres := tool.Get(k)
+			if pres {
+				definition.Set(k, v)
+			}
+		}
+		definitions = append(definitions, definition)
+	}
+
+	specs, err := parseChatTools(ctx, scope, definitions)
+	if err != nil {
+		scope.Log("ollama_tool_schema: %v", err)
+		return vfilter.Null{}
+	}
+
+	var names *toolNames
+	if arg.Schema != TOOL_SCHEMA_OLLAMA {
+		names = newToolNames(specs)
+	}
+
+	result, err := renderTools(specs, arg.Schema, names)
+	if err != nil {
+		scope.Log("ollama_tool_schema: %v", err)
+		return vfilter.Null{}
+	}
+	return result
+}
+
+func (self OllamaToolSchemaFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:    "ollama_tool_schema",
+		Doc:     "Render tool definitions into the tool schema of Ollama, the OpenAI chat completions or responses APIs or Anthropic.",
+		ArgType: type_map.AddType(scope, &OllamaToolSchemaFunctionArgs{}),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&OllamaToolSchemaFunction{})
+}
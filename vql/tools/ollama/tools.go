@@ -0,0 +1,197 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/constants"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+// A tool the agent may call.
+type AgentTool interface {
+	Spec() *ToolSpec
+	Risk() RiskLevel
+
+	// Returns the tool's output to be fed back into the conversation.
+	Call(ctx context.Context, scope vfilter.Scope,
+		args *ordereddict.Dict) (string, error)
+}
+
+type VQLToolArgs struct {
+	Name        string            `vfilter:"required,field=name,doc=The name of the tool presented to the model."`
+	Description string            `vfilter:"optional,field=description,doc=Describes to the model what the tool does."`
+	Parameters  *ordereddict.Dict `vfilter:"optional,field=parameters,doc=A JSON schema describing the tool's arguments."`
+	Query       vfilter.Any       `vfilter:"required,field=query,doc=The VQL query to run. The tool's arguments are available as scope variables."`
+	Risk        string            `vfilter:"optional,field=risk,doc=One of low, medium or high (default low)."`
+}
+
+// A tool implemented by a VQL query.
+type vqlTool struct {
+	name        string
+	description string
+	parameters  *ordereddict.Dict
+	query       vfilter.Any
+	risk        RiskLevel
+}
+
+func (self *vqlTool) Spec() *ToolSpec {
+	parameters := self.parameters
+	if parameters == nil {
+		parameters = ordereddict.NewDict().
+			Set("type", "object").
+			Set("properties", ordereddict.NewDict())
+	}
+
+	return &ToolSpec{
+		Type: "function",
+		Function: &ToolFunction{
+			Name:        self.name,
+			Description: self.description,
+			Parameters:  parameters,
+		},
+	}
+}
+
+func (self *vqlTool) Risk() RiskLevel {
+	return self.risk
+}
+
+func (self *vqlTool) Call(ctx context.Context, scope vfilter.Scope,
+	args *ordereddict.Dict) (string, error) {
+	subscope := scope.Copy().AppendVars(self.scopeVars(args))
+	defer subscope.Close()
+
+	result := &strings.Builder{}
+	emit := func(row vfilter.Row) error {
+		serialized, err := json.Marshal(vfilter.RowToDict(ctx, subscope, row))
+		if err != nil {
+			return err
+		}
+		result.Write(serialized)
+		result.WriteString("\n")
+		return nil
+	}
+
+	switch t := self.query.(type) {
+	case string:
+		statements, err := vfilter.MultiParse(t)
+		if err != nil {
+			return "", err
+		}
+
+		for _, vql := range statements {
+			for row := range vql.Eval(ctx, subscope) {
+				err := emit(row)
+				if err != nil {
+					return "", err
+				}
+			}
+		}
+
+	case vfilter.StoredQuery:
+		for row := range t.Eval(ctx, subscope) {
+			err := emit(row)
+			if err != nil {
+				return "", err
+			}
+		}
+
+	default:
+		return "", fmt.Errorf("tool %v: query should be a string or subquery",
+			self.name)
+	}
+
+	return result.String(), nil
+}
+
+// The arguments come from the model so must not be able to replace
+// sensitive scope variables (e.g. the ACL manager). When the tool
+// declares its parameters only those are passed.
+func (self *vqlTool) scopeVars(args *ordereddict.Dict) *ordereddict.Dict {
+	result := ordereddict.NewDict()
+	if args == nil {
+		return result
+	}
+
+	var declared *ordereddict.Dict
+	if self.parameters != nil {
+		declared, _ = ordereddict.GetMap(self.parameters, "properties")
+	}
+
+	for _, k := range args.Keys() {
+		if strings.HasPrefix(k, "$") ||
+			k == constants.SCOPE_CONFIG ||
+			k == constants.SCOPE_SERVER_CONFIG ||
+			k == constants.SCOPE_RESPONDER_CONTEXT {
+			continue
+		}
+
+		if declared != nil {
+			_, pres := declared.Get(k)
+			if !pres {
+				continue
+			}
+		}
+
+		v, _ := args.Get(k)
+		result.Set(k, v)
+	}
+	return result
+}
+
+func parseVQLTools(ctx context.Context, scope vfilter.Scope,
+	definitions []*ordereddict.Dict) ([]AgentTool, error) {
+	result := []AgentTool{}
+	for _, definition := range definitions {
+		arg := &VQLToolArgs{}
+		err := arg_parser.ExtractArgsWithContext(ctx, scope, definition, arg)
+		if err != nil {
+			return nil, fmt.Errorf("tools: %w", err)
+		}
+
+		risk, err := parseRiskLevel(arg.Risk, RISK_LOW)
+		if err != nil {
+			return nil, fmt.Errorf("tool %v: %w", arg.Name, err)
+		}
+
+		result = append(result, &vqlTool{
+			name:        arg.Name,
+			description: arg.Description,
+			parameters:  arg.Parameters,
+			query:       arg.Query,
+			risk:        risk,
+		})
+	}
+	return result, nil
+}
+
+type toolSet struct {
+	tools map[string]AgentTool
+	specs []*ToolSpec
+}
+
+func newToolSet(tools []AgentTool) (*toolSet, error) {
+	result := &toolSet{tools: make(map[string]AgentTool)}
+	for _, tool := range tools {
+		spec := tool.Spec()
+		name := spec.Function.Name
+		_, pres := result.tools[name]
+		if pres {
+			return nil, errors.New("duplicate tool name " + name)
+		}
+		result.tools[name] = tool
+		result.specs = append(result.specs, spec)
+	}
+	return result, nil
+}
+
+func (self *toolSet) Get(name string) (AgentTool, bool) {
+	tool, pres := self.tools[name]
+	return tool, pres
+}
@@ -0,0 +1,138 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/constants"
+	"www.velocidex.com/golang/velociraptor/services"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	USAGE_ARTIFACT = "Server.Internal.LLMUsage"
+)
+
+// Records each call in the usage ledger so admins can see who is
+// using the backend. The ledger is an event artifact so it is kept in
+// the datastore and can be read with source() after a restart.
+// Every call made by a client is recorded, whichever plugin made it,
+// as are responses served from the cache with CacheHit set. Calls to
+// models in AI.pricing are recorded with their estimated cost.
+type usageRecorder struct {
+	config_obj *config_proto.Config
+	scope      vfilter.Scope
+	principal  string
+	flow_id    string
+	artifact   string
+	currency   string
+
+	// Set for requests proxied for a client.
+	client_id string
+}
+
+// Returns nil (which records nothing) outside the server.
+func newUsageRecorder(scope vfilter.Scope,
+	settings *config_proto.AIConfig) *usageRecorder {
+	config_obj, ok := vql_subsystem.GetServerConfig(scope)
+	if !ok {
+		return nil
+	}
+
+	result := &usageRecorder{
+		config_obj: config_obj,
+		scope:      scope,
+		principal:  vql_subsystem.GetPrincipal(scope),
+		flow_id:    vql_subsystem.GetStringFromRow(scope, scope, "_SessionId"),
+		currency:   currency(settings),
+	}
+
+	query_name, ok := scope.GetContext(constants.SCOPE_QUERY_NAME)
+	if ok {
+		result.artifact, _ = query_name.(string)
+	}
+	return result
+}
+
+func (self *usageRecorder) Record(ctx context.Context, model string,
+	stats *Stats, cost *float64, duration time.Duration, err error) {
+	if self == nil {
+		return
+	}
+
+	row := self.newRow(model, duration)
+	if stats != nil {
+		row.Update("PromptTokens", stats.PromptEvalCount).
+			Update("ResponseTokens", stats.EvalCount)
+	}
+	if cost != nil {
+		row.Update("Cost", *cost)
+	}
+
+	if err != nil {
+		code := "other"
+		var llm_err *Error
+		if errors.As(err, &llm_err) {
+			code = llm_err.Code
+		}
+		row.Update("ErrorCode", code)
+	}
+	self.push(ctx, row)
+}
+
+// Cached responses carry the statistics of the call which made them
+// but did not use the model again.
+func (self *usageRecorder) RecordCacheHit(ctx context.Context, model string) {
+	if self == nil {
+		return
+	}
+
+	self.push(ctx, self.newRow(model, 0).Update("CacheHit", true))
+}
+
+func (self *usageRecorder) newRow(
+	model string, duration time.Duration) *ordereddict.Dict {
+	return ordereddict.NewDict().
+		Set("Principal", self.principal).
+		Set("OrgId", self.config_obj.OrgId).
+		Set("Model", model).
+		Set("Artifact", self.artifact).
+		Set("FlowId", self.flow_id).
+		Set("ClientId", self.client_id).
+		Set("PromptTokens", int64(0)).
+		Set("ResponseTokens", int64(0)).
+		Set("Duration", duration.Seconds()).
+		Set("CacheHit", false).
+		Set("Cost", float64(0)).
+		Set("Currency", self.currency).
+		Set("ErrorCode", "")
+}
+
+// The ledger is best effort - a failure to record usage should not
+// fail the query.
+func (self *usageRecorder) push(ctx context.Context, row *ordereddict.Dict) {
+	journal, err := services.GetJournal(self.config_obj)
+	if err == nil {
+		err = journal.PushRowsToArtifact(ctx, self.config_obj,
+			[]*ordereddict.Dict{row}, USAGE_ARTIFACT, "server", "")
+	}
+	if err != nil {
+		self.scope.Log("ollama: recording usage: %v", err)
+	}
+}
+
+// A client sharing the connections of this one whose calls are
+// recorded for the client the request was proxied for.
+func (self *Client) WithClientId(client_id string) *Client {
+	result := *self
+	if self.usage != nil {
+		usage := *self.usage
+		usage.client_id = client_id
+		result.usage = &usage
+	}
+	return &result
+}
@@ -0,0 +1,302 @@
+package ollama
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/vtesting/assert"
+	"www.velocidex.com/golang/vfilter"
+)
+
+var (
+	usageArtifact = `
+name: Server.Internal.LLMUsage
+type: SERVER_EVENT
+`
+)
+
+func (self *OllamaTestSuite) TestMetrics() {
+	calls := llmCallCounter.WithLabelValues("llama3", "/api/generate")
+	errors := llmErrorCounter.WithLabelValues("missing", "/api/generate",
+		ERROR_MODEL_NOT_FOUND)
+	calls_before := testutil.ToFloat64(calls)
+	errors_before := testutil.ToFloat64(errors)
+
+	self.run(`
+SELECT * FROM ollama(model="llama3", prompt="Hi", base_url=URL)
+SELECT * FROM ollama(model="missing", prompt="Hi", base_url=URL)`)
+
+	assert.Equal(self.T(), calls_before+1, testutil.ToFloat64(calls))
+	assert.Equal(self.T(), errors_before+1, testutil.ToFloat64(errors))
+}
+
+func (self *OllamaTestSuite) TestUsage() {
+	self.LoadArtifacts(usageArtifact)
+
+	journal, err := services.GetJournal(self.ConfigObj)
+	assert.NoError(self.T(), err)
+
+	events, cancel := journal.Watch(self.Ctx, USAGE_ARTIFACT, "test")
+	defer cancel()
+
+	self.run(`
+SELECT * FROM ollama(model="llama3", prompt="Hi", cache_bypass=TRUE, base_url=URL)`)
+
+	select {
+	case event := <-events:
+		model, _ := event.GetString("Model")
+		assert.Equal(self.T(), "llama3", model)
+
+		tokens, _ := event.GetInt64("ResponseTokens")
+		assert.Equal(self.T(), int64(2), tokens)
+
+		code, _ := event.GetString("ErrorCode")
+		assert.Equal(self.T(), "", code)
+
+	case <-time.After(10 * time.Second):
+		self.T().Fatalf("No usage recorded")
+	}
+
+	// Cached responses are recorded too but use no tokens.
+	self.run(`
+SELECT * FROM ollama(model="llama3", prompt="Cached", base_url=URL)
+SELECT * FROM ollama(model="llama3", prompt="Cached", base_url=URL)`)
+
+	for _, expected := range []bool{false, true} {
+		select {
+		case event := <-events:
+			cache_hit, _ := event.GetBool("CacheHit")
+			assert.Equal(self.T(), expected, cache_hit)
+
+			tokens, _ := event.GetInt64("ResponseTokens")
+			if expected {
+				assert.Equal(self.T(), int64(0), tokens)
+			} else {
+				assert.Equal(self.T(), int64(2), tokens)
+			}
+
+		case <-time.After(10 * time.Second):
+			self.T().Fatalf("No usage recorded")
+		}
+	}
+}
+
+func (self *OllamaTestSuite) TestUsageOfAllCalls() {
+	self.LoadArtifacts(usageArtifact)
+
+	journal, err := services.GetJournal(self.ConfigObj)
+	assert.NoError(self.T(), err)
+
+	events, cancel := journal.Watch(self.Ctx, USAGE_ARTIFACT, "test")
+	defer cancel()
+
+	next := func() *ordereddict.Dict {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(10 * time.Second):
+			self.T().Fatalf("No usage recorded")
+		}
+		return nil
+	}
+
+	// Conversations and embeddings are recorded like generated
+	// responses.
+	self.chat_responses = []string{fmt.Sprintf(finalResponse, "Hello")}
+	self.run(`
+SELECT * FROM ollama_chat(model="llama3", base_url=URL,
+   messages=[dict(role="user", content="Hi")])`)
+
+	model, _ := next().GetString("Model")
+	assert.Equal(self.T(), "llama3", model)
+
+	self.run(`
+SELECT * FROM ollama_embed(model="nomic-embed-text", input="Hi", base_url=URL)`)
+
+	model, _ = next().GetString("Model")
+	assert.Equal(self.T(), "nomic-embed-text", model)
+
+	// Requests of clients are recorded for the client.
+	err = SetAISettings(self.ConfigObj, &config_proto.AIConfig{
+		BaseUrl:     "mock://",
+		ClientProxy: true,
+	})
+	assert.NoError(self.T(), err)
+	defer SetAISettings(self.ConfigObj, &config_proto.AIConfig{})
+
+	response := &proxyResponse{}
+	err = json.Unmarshal(ProxyClientRequest(self.Ctx, self.ConfigObj, "C.1234",
+		[]byte(json.MustMarshalString(&proxyRequest{
+			Method: "POST",
+			Path:   "/api/generate",
+			Body: []byte(json.MustMarshalString(&GenerateRequest{
+				Model: "llama3", Prompt: "Hi"})),
+		}))), response)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), http.StatusOK, response.Status)
+
+	client_id, _ := next().GetString("ClientId")
+	assert.Equal(self.T(), "C.1234", client_id)
+}
+
+func (self *OllamaTestSuite) TestPricing() {
+	self.LoadArtifacts(usageArtifact)
+
+	err := SetAISettings(self.ConfigObj, &config_proto.AIConfig{
+		Currency: "EUR",
+		Pricing: []*config_proto.AIPrice{{
+			Model:           "llama*",
+			PromptPrice:     2,
+			CompletionPrice: 10,
+		}, {
+			Model:       "llama3",
+			Provider:    "api.openai.com",
+			PromptPrice: 100,
+		}},
+	})
+	assert.NoError(self.T(), err)
+	defer SetAISettings(self.ConfigObj, &config_proto.AIConfig{})
+
+	journal, err := services.GetJournal(self.ConfigObj)
+	assert.NoError(self.T(), err)
+
+	events, cancel := journal.Watch(self.Ctx, USAGE_ARTIFACT, "test")
+	defer cancel()
+
+	// 5 prompt and 2 response tokens.
+	rows := self.run(`
+SELECT * FROM ollama(model="llama3", prompt="Hi", cache_bypass=TRUE, base_url=URL)`)
+	assert.Equal(self.T(), 1, len(rows))
+
+	cost, _ := rows[0].Get("Cost")
+	assert.Equal(self.T(), 3e-05, cost)
+
+	select {
+	case event := <-events:
+		cost, _ := event.Get("Cost")
+		assert.Equal(self.T(), 3e-05, cost)
+
+		currency, _ := event.GetString("Currency")
+		assert.Equal(self.T(), "EUR", currency)
+
+	case <-time.After(10 * time.Second):
+		self.T().Fatalf("No usage recorded")
+	}
+
+	// The price for the provider wins on its server.
+	settings, err := GetAISettings(self.ConfigObj)
+	assert.NoError(self.T(), err)
+
+	price := findPrice(settings, "openai+https://api.openai.com/v1", "llama3")
+	assert.Equal(self.T(), float64(100), price.PromptPrice)
+	assert.Nil(self.T(), findPrice(settings, self.server.URL, "mistral"))
+}
+
+func (self *OllamaTestSuite) TestDebug() {
+	rows := self.run(`
+SELECT * FROM ollama(model="llama3", prompt="Hi", debug=TRUE, base_url=URL)`)
+	assert.Equal(self.T(), 2, len(rows))
+
+	debug_any, _ := rows[0].Get("Debug")
+	debug := debug_any.(*ordereddict.Dict)
+
+	endpoint, _ := debug.GetString("Endpoint")
+	assert.Equal(self.T(), self.server.URL+"/api/generate", endpoint)
+
+	prompt, _ := debug.GetString("Prompt")
+	assert.Equal(self.T(), "Hi", prompt)
+
+	response, _ := rows[1].GetString("Response")
+	assert.Equal(self.T(), "Hello world", response)
+
+	headers := maskHeaders(http.Header{
+		"Authorization": []string{"Bearer secret"},
+		"Content-Type":  []string{"application/json"},
+	})
+	assert.Equal(self.T(), `{"Authorization":"********","Content-Type":"application/json"}`,
+		json.MustMarshalString(headers))
+}
+
+func (self *OllamaTestSuite) TestCircuitBreaker() {
+	rows := self.run(`
+SELECT * FROM foreach(row={ SELECT * FROM range(end=3) }, query={
+   SELECT * FROM ollama(model="gateway", prompt="Hi", circuit_failures=2,
+      cache_bypass=TRUE, base_url=URL)
+})`)
+	assert.Equal(self.T(), 3, len(rows))
+
+	// The third call fails without reaching the server.
+	assert.Equal(self.T(), 2, len(self.requests))
+
+	code, _ := rows[1].GetString("ErrorCode")
+	assert.Equal(self.T(), ERROR_SERVER, code)
+
+	code, _ = rows[2].GetString("ErrorCode")
+	assert.Equal(self.T(), ERROR_CIRCUIT_OPEN, code)
+
+	// A working call closes the circuit again.
+	state := getCircuitState(self.server.URL)
+	state.Record(CircuitBreaker{}, nil)
+	assert.NoError(self.T(), state.Allow(CircuitBreaker{}, self.server.URL))
+}
+
+func (self *OllamaTestSuite) TestCountTokens() {
+	rows := self.run(`
+SELECT count_tokens(text="Hello world, it's 12345 processes!",
+                    model="llama3") AS Text,
+       count_tokens(query={ SELECT * FROM range(end=2) }) AS Rows
+FROM scope()`)
+	assert.Equal(self.T(), 1, len(rows))
+
+	text, _ := rows[0].GetInt64("Text")
+	assert.Equal(self.T(), int64(11), text)
+
+	// Each row is {"_value":0} and a newline.
+	count, _ := rows[0].GetInt64("Rows")
+	assert.Equal(self.T(), int64(10), count)
+}
+
+func (self *OllamaTestSuite) TestEstimate() {
+	rows := self.run(`
+SELECT ollama_estimate(query={ SELECT * FROM range(end=4) },
+          prompt="Hi", model="llama3", chunk_size=3, max_tokens=100,
+          prompt_price=1, completion_price=2) AS Chunked,
+       ollama_estimate(query={ SELECT * FROM range(end=4) },
+          prompt="Hi", model="llama3", max_rows=2) AS Truncated
+FROM scope()`)
+	assert.Equal(self.T(), 1, len(rows))
+
+	// Two calls each send the prompt (2 tokens) with 3 and 1 rows
+	// of 5 tokens.
+	chunked, _ := rows[0].Get("Chunked")
+	estimate := chunked.(*ordereddict.Dict)
+	value, _ := estimate.GetInt64("Rows")
+	assert.Equal(self.T(), int64(4), value)
+	value, _ = estimate.GetInt64("Chunks")
+	assert.Equal(self.T(), int64(2), value)
+	value, _ = estimate.GetInt64("PromptTokens")
+	assert.Equal(self.T(), int64(24), value)
+	value, _ = estimate.GetInt64("CompletionTokens")
+	assert.Equal(self.T(), int64(200), value)
+	cost, _ := estimate.Get("Cost")
+	assert.Equal(self.T(), 0.000424, cost)
+
+	truncated, _ := rows[0].Get("Truncated")
+	estimate = truncated.(*ordereddict.Dict)
+	value, _ = estimate.GetInt64("Rows")
+	assert.Equal(self.T(), int64(2), value)
+	value, _ = estimate.GetInt64("Chunks")
+	assert.Equal(self.T(), int64(1), value)
+	value, _ = estimate.GetInt64("PromptTokens")
+	assert.Equal(self.T(), int64(12), value)
+	is_truncated, _ := estimate.GetBool("Truncated")
+	assert.True(self.T(), is_truncated)
+	cost, _ = estimate.Get("Cost")
+	assert.Equal(self.T(), vfilter.Null{}, cost)
+}
@@ -0,0 +1,269 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	DEFAULT_REPAIR_ATTEMPTS = 2
+)
+
+// Checks that a response is JSON and matches the schema given as the
+// format. Only the commonly used parts of JSON schema are supported:
+// type, enum, required, properties and items.
+type responseValidator struct {
+	schema map[string]interface{}
+}
+
+// Returns nil if the response does not need to be validated.
+func newResponseValidator(format vfilter.Any, parse bool) (*responseValidator, error) {
+	switch t := format.(type) {
+	case nil:
+		if !parse {
+			return nil, nil
+		}
+		return &responseValidator{}, nil
+
+	case string:
+		if t == "" && !parse {
+			return nil, nil
+		}
+		return &responseValidator{}, nil
+	}
+
+	serialized, err := json.Marshal(format)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := make(map[string]interface{})
+	err = json.Unmarshal(serialized, &schema)
+	if err != nil {
+		return nil, fmt.Errorf("format should be 'json' or a JSON schema: %w", err)
+	}
+	return &responseValidator{schema: schema}, nil
+}
+
+type validationResult struct {
+	Response string
+	Final    *GenerateResponse
+	Parsed   interface{}
+	Attempts int64
+	Errors   []string
+
+	// The log probabilities of the last repaired response.
+	Logprobs []*TokenLogprob
+}
+
+// Sets the Attempts column and either the Parsed column or the
+// problems which remain after the last attempt.
+func (self *validationResult) SetColumns(scope vfilter.Scope,
+	row *ordereddict.Dict, parse bool) {
+	row.Set("Attempts", self.Attempts)
+	if len(self.Errors) > 0 {
+		scope.Log("ollama: Response is not valid after %v attempts",
+			self.Attempts)
+		row.Set("ValidationErrors", self.Errors)
+	} else if parse {
+		row.Set("Parsed", self.Parsed)
+	}
+}
+
+// Parse the response, returning the problems with it if any.
+func (self *responseValidator) Validate(response string) (interface{}, []string) {
+	serialized := []byte(stripCodeFence(response))
+
+	var value interface{}
+	err := json.Unmarshal(serialized, &value)
+	if err != nil {
+		return nil, []string{fmt.Sprintf("Response is not valid JSON: %v", err)}
+	}
+
+	var errs []string
+	if self.schema != nil {
+		errs = validateSchema(self.schema, value, "$")
+	}
+
+	// Objects keep their key order for the query.
+	parsed, err := utils.ParseJsonToObject(serialized)
+	if err == nil {
+		return parsed, errs
+	}
+	return value, errs
+}
+
+// Ask the model to fix an invalid response, up to max_attempts
+// times. The model sees its previous response and what was wrong
+// with it.
+func (self *responseValidator) Repair(ctx context.Context,
+	scope vfilter.Scope, generate generateFunc, req *GenerateRequest,
+	response string, final *GenerateResponse,
+	max_attempts int64) (*validationResult, error) {
+	result := &validationResult{
+		Response: response,
+		Final:    final,
+		Attempts: 1,
+	}
+
+	for {
+		result.Parsed, result.Errors = self.Validate(result.Response)
+		if len(result.Errors) == 0 || result.Attempts > max_attempts {
+			return result, nil
+		}
+
+		scope.Log("ollama: Response is not valid, asking the model to repair it: %v",
+			strings.Join(result.Errors, "; "))
+
+		repair_req := *req
+		repair_req.Prompt = req.Prompt + "\n\nYour previous response was:\n" +
+			result.Response + "\n\nIt is not valid because:\n- " +
+			strings.Join(result.Errors, "\n- ") +
+			"\n\nRespond again with only the corrected JSON."
+
+		response := &strings.Builder{}
+		result.Logprobs = nil
+		err := generate(ctx, &repair_req, func(chunk *GenerateResponse) error {
+			response.WriteString(chunk.Response)
+			result.Logprobs = append(result.Logprobs, chunk.Logprobs...)
+			if chunk.Done {
+				result.Final = chunk
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		result.Response = response.String()
+		result.Attempts++
+	}
+}
+
+// Models often wrap JSON in a markdown code block.
+func stripCodeFence(response string) string {
+	response = strings.TrimSpace(response)
+	if !strings.HasPrefix(response, "```") {
+		return response
+	}
+
+	response = strings.TrimPrefix(response, "```")
+	response = strings.TrimPrefix(response, "json")
+	response = strings.TrimSuffix(response, "```")
+	return strings.TrimSpace(response)
+}
+
+func validateSchema(schema map[string]interface{},
+	value interface{}, path string) []string {
+	var errs []string
+
+	switch t := schema["type"].(type) {
+	case string:
+		if !matchesType(t, value) {
+			return []string{fmt.Sprintf("%v should be of type %v", path, t)}
+		}
+	case []interface{}:
+		matched := false
+		for _, item := range t {
+			name, _ := item.(string)
+			if matchesType(name, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return []string{fmt.Sprintf("%v should be one of the types %v", path, t)}
+		}
+	}
+
+	enum, ok := schema["enum"].([]interface{})
+	if ok {
+		matched := false
+		for _, item := range enum {
+			if reflect.DeepEqual(item, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			errs = append(errs, fmt.Sprintf("%v should be one of %v",
+				path, json.MustMarshalString(enum)))
+		}
+	}
+
+	switch t := value.(type) {
+	case map[string]interface{}:
+		required, _ := schema["required"].([]interface{})
+		for _, item := range required {
+			name, _ := item.(string)
+			_, pres := t[name]
+			if !pres {
+				errs = append(errs, fmt.Sprintf("%v is missing required field %v",
+					path, name))
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]interface{})
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			property, ok := properties[name].(map[string]interface{})
+			item, pres := t[name]
+			if ok && pres {
+				errs = append(errs, validateSchema(property, item, path+"."+name)...)
+			}
+		}
+
+	case []interface{}:
+		items, ok := schema["items"].(map[string]interface{})
+		if ok {
+			for idx, item := range t {
+				errs = append(errs, validateSchema(items, item,
+					fmt.Sprintf("%v[%d]", path, idx))...)
+			}
+		}
+	}
+
+	return errs
+}
+
+func matchesType(name string, value interface{}) bool {
+	switch name {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+
+	// Unknown types are not checked.
+	return true
+}
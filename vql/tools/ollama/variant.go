@@ -0,0 +1,192 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	// The part of the memory kept for the context and KV cache.
+	DEFAULT_VARIANT_HEADROOM = 0.2
+
+	VARIANT_FITS     = "fits"
+	VARIANT_SMALLEST = "smallest"
+)
+
+type OllamaVariantFunctionArgs struct {
+	Family   string  `vfilter:"required,field=family,doc=The base model (e.g. llama3.1) whose installed tags are the variants."`
+	Memory   uint64  `vfilter:"optional,field=memory,doc=The bytes of memory the model may use (default the VRAM of the largest GPU of this host)."`
+	Headroom float64 `vfilter:"optional,field=headroom,doc=The fraction of the memory kept for the context (default 0.2)."`
+	BaseUrl  string  `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server."`
+	Timeout  int64   `vfilter:"optional,field=timeout,doc=Seconds to wait for the server (default 5)."`
+}
+
+// Picks the variant of a model family which suits the endpoint so
+// artifacts do not need to name q4 or q8 tags for each deployment. The
+// largest installed variant which fits in memory is used, or the
+// smallest one if none do.
+type OllamaVariantFunction struct{}
+
+func (self OllamaVariantFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("ollama_variant", args)()
+
+	err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+	if err != nil {
+		scope.Log("ollama_variant: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg := &OllamaVariantFunctionArgs{}
+	err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("ollama_variant: %v", err)
+		return vfilter.Null{}
+	}
+
+	if arg.Headroom == 0 {
+		arg.Headroom = DEFAULT_VARIANT_HEADROOM
+	}
+
+	if arg.Headroom < 0 || arg.Headroom >= 1 {
+		scope.Log("ollama_variant: headroom should be between 0 and 1")
+		return vfilter.Null{}
+	}
+
+	// The memory is only looked up on the host when not given.
+	if arg.Memory == 0 {
+		err := vql_subsystem.CheckAccess(scope, acls.MACHINE_STATE)
+		if err != nil {
+			scope.Log("ollama_variant: %v", err)
+			return vfilter.Null{}
+		}
+
+		gpus, err := getGPUs()
+		if err != nil {
+			scope.Log("ollama_variant: %v", err)
+		}
+
+		for _, gpu := range gpus {
+			if gpu.VRAM > arg.Memory {
+				arg.Memory = gpu.VRAM
+			}
+		}
+	}
+
+	client, err := NewClient(ctx, scope, arg.BaseUrl)
+	if err != nil {
+		scope.Log("ollama_variant: %v", err)
+		return vfilter.Null{}
+	}
+
+	timeout := time.Duration(arg.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = DEFAULT_HEALTH_TIMEOUT
+	}
+
+	sub_ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result := ordereddict.NewDict().
+		Set("Family", arg.Family).
+		Set("Model", "").
+		Set("Memory", arg.Memory)
+
+	list, err := client.List(sub_ctx)
+	if err != nil {
+		scope.Log("ollama_variant: %v", err)
+		return setHealthError(result, err)
+	}
+
+	variants := findVariants(list.Models, arg.Family)
+	if len(variants) == 0 {
+		err := &Error{Code: ERROR_MODEL_NOT_FOUND,
+			Endpoint: client.base_url + "/api/tags",
+			Err:      fmt.Errorf("No variant of %v is installed", arg.Family)}
+		scope.Log("ollama_variant: %v", err)
+		return setHealthError(result, err)
+	}
+
+	// The largest variant which fits, else the smallest.
+	usable := uint64(float64(arg.Memory) * (1 - arg.Headroom))
+	selected := variants[len(variants)-1]
+	reason := VARIANT_SMALLEST
+
+	summaries := make([]*ordereddict.Dict, 0, len(variants))
+	for _, item := range variants {
+		fits := uint64(item.Size) <= usable
+		if fits && reason != VARIANT_FITS {
+			selected = item
+			reason = VARIANT_FITS
+		}
+		summaries = append(summaries, variantSummary(item).Set("Fits", fits))
+	}
+
+	return result.
+		Update("Model", selected.Name).
+		Set("Size", selected.Size).
+		Set("Quantization", detailString(selected, "quantization_level")).
+		Set("ParameterSize", detailString(selected, "parameter_size")).
+		Set("Reason", reason).
+		Set("Variants", summaries)
+}
+
+// The installed tags of the family, largest first.
+func findVariants(models []*ModelSummary, family string) []*ModelSummary {
+	result := []*ModelSummary{}
+	for _, item := range models {
+		name := item.Name
+		if name == "" {
+			name = item.Model
+		}
+		base, _, _ := strings.Cut(name, ":")
+		if base == family {
+			result = append(result, item)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Size > result[j].Size
+	})
+	return result
+}
+
+func variantSummary(item *ModelSummary) *ordereddict.Dict {
+	return ordereddict.NewDict().
+		Set("Model", item.Name).
+		Set("Size", item.Size).
+		Set("Quantization", detailString(item, "quantization_level")).
+		Set("ParameterSize", detailString(item, "parameter_size"))
+}
+
+func detailString(item *ModelSummary, field string) string {
+	if item.Details == nil {
+		return ""
+	}
+	value, _ := item.Details.GetString(field)
+	return value
+}
+
+func (self OllamaVariantFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:     "ollama_variant",
+		Doc:      "Select the largest installed variant of a model which fits in the endpoint's memory.",
+		ArgType:  type_map.AddType(scope, &OllamaVariantFunctionArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&OllamaVariantFunction{})
+}
@@ -0,0 +1,137 @@
+package ollama
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/vfilter"
+)
+
+// Every AI analysis plugin reports its finding in the same columns so
+// dashboards and queries can treat them alike:
+//
+//   - Verdict: malicious, suspicious or benign.
+//   - Confidence: how sure the model is, from 0 to 1.
+//   - Severity: info, low, medium, high or critical.
+//   - Rationale: why the model reached the verdict.
+//   - References: the facts supporting the verdict (e.g. links or
+//     imports).
+//   - Model: the model which made the verdict.
+//   - PromptHash: the SHA256 of the instructions given to the model,
+//     so verdicts made with the same prompt can be grouped.
+//
+// Plugins may add their own columns after these.
+const VERDICT_INSTRUCTIONS = "Respond with JSON: Verdict is malicious, " +
+	"suspicious or benign, Confidence is how sure you are from 0 to 1, " +
+	"Severity is info, low, medium, high or critical, Rationale " +
+	"explains the verdict in a sentence or two and References lists " +
+	"the facts supporting it."
+
+var verdict_values = []string{"malicious", "suspicious", "benign"}
+
+var verdict_columns = []string{
+	"Verdict", "Confidence", "Severity", "Rationale", "References",
+	"Model", "PromptHash",
+}
+
+// The schema of the standard verdict with the plugin's own
+// properties added.
+func verdictSchema(properties *ordereddict.Dict,
+	required ...string) *ordereddict.Dict {
+	all_properties := ordereddict.NewDict().
+		Set("Verdict", ordereddict.NewDict().
+			Set("type", "string").
+			Set("enum", verdict_values)).
+		Set("Confidence", ordereddict.NewDict().
+			Set("type", "number")).
+		Set("Severity", ordereddict.NewDict().
+			Set("type", "string").
+			Set("enum", alert_severities)).
+		Set("Rationale", ordereddict.NewDict().
+			Set("type", "string")).
+		Set("References", ordereddict.NewDict().
+			Set("type", "array").
+			Set("items", ordereddict.NewDict().Set("type", "string")))
+
+	if properties != nil {
+		for _, k := range properties.Keys() {
+			v, _ := properties.Get(k)
+			all_properties.Set(k, v)
+		}
+	}
+
+	return ordereddict.NewDict().
+		Set("type", "object").
+		Set("properties", all_properties).
+		Set("required", append([]string{
+			"Verdict", "Confidence", "Severity", "Rationale", "References",
+		}, required...))
+}
+
+func promptHash(prompt string) string {
+	hash := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(hash[:])
+}
+
+// Set the standard verdict columns of the row from the parsed
+// response. Values the model got wrong are normalized: the
+// confidence is kept between 0 and 1 and an unknown severity is
+// derived from the verdict.
+func setVerdictColumns(row, verdict *ordereddict.Dict, model, prompt string) {
+	value, _ := verdict.GetString("Verdict")
+	value = strings.ToLower(strings.TrimSpace(value))
+	if !utils.InString(verdict_values, value) {
+		row.Set("Verdict", vfilter.Null{})
+	} else {
+		row.Set("Verdict", value)
+	}
+
+	confidence_any, _ := verdict.Get("Confidence")
+	switch t := confidence_any.(type) {
+	case float64:
+		row.Set("Confidence", min(max(t, 0), 1))
+	case int64:
+		row.Set("Confidence", min(max(float64(t), 0), 1))
+	default:
+		row.Set("Confidence", vfilter.Null{})
+	}
+
+	severity, _ := verdict.GetString("Severity")
+	severity = strings.ToLower(strings.TrimSpace(severity))
+	if !utils.InString(alert_severities, severity) {
+		severity = verdict_severities[value]
+	}
+	if severity == "" {
+		row.Set("Severity", vfilter.Null{})
+	} else {
+		row.Set("Severity", severity)
+	}
+
+	rationale, _ := verdict.GetString("Rationale")
+	row.Set("Rationale", rationale)
+
+	references := []string{}
+	references_any, _ := verdict.Get("References")
+	if items, ok := references_any.([]interface{}); ok {
+		for _, item := range items {
+			references = append(references, utils.ToString(item))
+		}
+	}
+	row.Set("References", references)
+
+	row.Set("Model", model).
+		Set("PromptHash", promptHash(prompt))
+}
+
+// Set the standard verdict columns when the model call failed so
+// rows always have the same columns.
+func setVerdictError(row *ordereddict.Dict, model, prompt string) {
+	for _, column := range verdict_columns {
+		row.Set(column, vfilter.Null{})
+	}
+	row.Set("Model", model).
+		Set("PromptHash", promptHash(prompt))
+}
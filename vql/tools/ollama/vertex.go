@@ -0,0 +1,141 @@
+package ollama
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"www.velocidex.com/golang/velociraptor/constants"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+)
+
+const (
+	VERTEX_SCHEME = "vertex://"
+
+	// Tokens for Vertex AI are issued for this scope.
+	VERTEX_SCOPE = "https://www.googleapis.com/auth/cloud-platform"
+
+	DEFAULT_VERTEX_LOCATION = "global"
+)
+
+// A base_url of vertex://name calls the Vertex AI project stored in
+// the GCP Vertex Creds secret called name. The secret may hold the
+// JSON key of a service account or the configuration of a workload
+// identity pool. Without one the server's application default
+// credentials are used, such as the workload identity of a GKE pod
+// or the service account of the VM.
+func isVertexUrl(base_url string) bool {
+	return strings.HasPrefix(base_url, VERTEX_SCHEME)
+}
+
+type vertexConfig struct {
+	// Defaults to the OpenAI compatible endpoint of the project.
+	url string
+
+	// chat or responses
+	api string
+
+	project  string
+	location string
+
+	// A service_account or external_account JSON credentials file.
+	credentials_json string
+}
+
+func resolveVertexUrl(ctx context.Context, scope vfilter.Scope,
+	base_url string, transport *http.Transport) (*cloudEndpoint, error) {
+	name := strings.TrimSuffix(strings.TrimPrefix(base_url, VERTEX_SCHEME), "/")
+	secret, err := getSecret(ctx, scope, constants.GCP_VERTEX_CREDS, name)
+	if err != nil {
+		return nil, err
+	}
+
+	get := func(field string) string {
+		return strings.TrimSpace(
+			vql_subsystem.GetStringFromRow(scope, secret.Data, field))
+	}
+
+	return newVertexEndpoint(name, &vertexConfig{
+		url:              get("url"),
+		api:              get("api"),
+		project:          get("project"),
+		location:         get("location"),
+		credentials_json: get("credentials_json"),
+	}, transport)
+}
+
+func newVertexEndpoint(name string, config *vertexConfig,
+	transport *http.Transport) (*cloudEndpoint, error) {
+	endpoint := config.url
+	if endpoint == "" {
+		if config.project == "" {
+			return nil, fmt.Errorf("Secret %v needs a project or url", name)
+		}
+
+		location := config.location
+		if location == "" {
+			location = DEFAULT_VERTEX_LOCATION
+		}
+
+		host := "aiplatform.googleapis.com"
+		if location != DEFAULT_VERTEX_LOCATION {
+			host = location + "-" + host
+		}
+
+		endpoint = fmt.Sprintf(
+			"https://%v/v1/projects/%v/locations/%v/endpoints/openapi",
+			host, config.project, location)
+	}
+
+	// Identity providers are called with the server's proxy settings.
+	client := &http.Client{Transport: transport}
+	data := []byte(config.credentials_json)
+
+	// Tokens are shared by the clients of the same identity, keyed
+	// by a hash of its credentials.
+	key := "vertex/default"
+	if len(data) > 0 {
+		hash := sha256.Sum256(data)
+		key = "vertex/" + hex.EncodeToString(hash[:])
+	}
+
+	return &cloudEndpoint{
+		base_url: cloudBaseUrl(endpoint, config.api),
+		headers:  http.Header{},
+		credentials: getTokenCredentials(key,
+			func(ctx context.Context) (*accessToken, error) {
+				return vertexToken(ctx, client, data)
+			}),
+	}, nil
+}
+
+// Fetches a new token each time rather than using the caching token
+// source of the credentials, so refused tokens are really replaced.
+func vertexToken(ctx context.Context, client *http.Client,
+	data []byte) (*accessToken, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+
+	var creds *google.Credentials
+	var err error
+	if len(data) > 0 {
+		creds, err = google.CredentialsFromJSON(ctx, data, VERTEX_SCOPE)
+	} else {
+		creds, err = google.FindDefaultCredentials(ctx, VERTEX_SCOPE)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := creds.TokenSource.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	return &accessToken{token: token.AccessToken, expires: token.Expiry}, nil
+}
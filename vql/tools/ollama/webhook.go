@@ -0,0 +1,303 @@
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	"www.velocidex.com/golang/velociraptor/artifacts"
+	"www.velocidex.com/golang/velociraptor/constants"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/velociraptor/vql/networking"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+const (
+	DEFAULT_SIGNATURE_HEADER = "X-Velociraptor-Signature"
+	WEBHOOK_TIMESTAMP_HEADER = "X-Velociraptor-Timestamp"
+
+	DEFAULT_WEBHOOK_TIMEOUT = 30
+	DEFAULT_WEBHOOK_RETRIES = 3
+
+	// Only the start of the receiver's response is kept.
+	MAX_WEBHOOK_RESPONSE = 1024
+)
+
+// The delay before the first retry. It doubles for each retry.
+var webhook_retry_delay = time.Second
+
+type OllamaWebhookPluginArgs struct {
+	Query           vfilter.StoredQuery `vfilter:"required,field=query,doc=The rows to deliver (e.g. verdicts from ollama_email()). Each row is posted as a JSON object."`
+	Url             string              `vfilter:"required,field=url,doc=The URL to post to. Use secret://name for an endpoint and signing key stored in a Webhook Secret."`
+	HmacKey         string              `vfilter:"optional,field=hmac_key,doc=Sign the requests with this key. Prefer storing the key in a Webhook Secret so it is not visible in the query."`
+	SignatureHeader string              `vfilter:"optional,field=signature_header,doc=The header holding the signature (default X-Velociraptor-Signature)."`
+	Headers         *ordereddict.Dict   `vfilter:"optional,field=headers,doc=Extra headers to send (e.g. the receiver's API key)."`
+	Timeout         int64               `vfilter:"optional,field=timeout,doc=Seconds each request may take (default 30)."`
+	MaxRetries      int64               `vfilter:"optional,field=max_retries,doc=How many times a request which failed or was rejected with a 429 or 5xx status is retried (default 3, -1 to disable)."`
+}
+
+// Posts AI verdicts to an external system such as a SOAR or
+// ticketing system. When a key is given each request is signed with
+// HMAC-SHA256 over the timestamp header, a dot and the body, so the
+// receiver can check the request came from this server and is not a
+// replay:
+//
+//	X-Velociraptor-Timestamp: 1700000000
+//	X-Velociraptor-Signature: sha256=<hex digest>
+type OllamaWebhookPlugin struct{}
+
+func (self OllamaWebhookPlugin) Call(
+	ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) <-chan vfilter.Row {
+	output_chan := make(chan vfilter.Row)
+
+	go func() {
+		defer close(output_chan)
+		defer vql_subsystem.RegisterMonitor("ollama_webhook", args)()
+
+		err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+		if err != nil {
+			scope.Log("ollama_webhook: %v", err)
+			return
+		}
+
+		arg := &OllamaWebhookPluginArgs{}
+		err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+		if err != nil {
+			scope.Log("ollama_webhook: %v", err)
+			return
+		}
+
+		webhook, err := newWebhook(ctx, scope, webhookOptions{
+			url:              arg.Url,
+			hmac_key:         arg.HmacKey,
+			signature_header: arg.SignatureHeader,
+			headers:          arg.Headers,
+			timeout:          arg.Timeout,
+			max_retries:      arg.MaxRetries,
+		})
+		if err != nil {
+			scope.Log("ollama_webhook: %v", err)
+			return
+		}
+
+		for row := range arg.Query.Eval(ctx, scope) {
+			body, err := json.Marshal(promptRow(ctx, scope, row))
+			if err != nil {
+				scope.Log("ollama_webhook: %v", err)
+				continue
+			}
+
+			result := webhook.Deliver(ctx, body)
+			if result.err != nil {
+				scope.Log("ollama_webhook: Delivery to %v failed: %v",
+					redactUrl(webhook.url), result.err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case output_chan <- result.Row(webhook.url):
+			}
+		}
+	}()
+
+	return output_chan
+}
+
+type webhook struct {
+	url      string
+	headers  http.Header
+	hmac_key string
+	header   string
+	retries  int64
+	client   *http.Client
+}
+
+type webhookOptions struct {
+	url              string
+	hmac_key         string
+	signature_header string
+	headers          *ordereddict.Dict
+	timeout          int64
+	max_retries      int64
+}
+
+func newWebhook(ctx context.Context, scope vfilter.Scope,
+	options webhookOptions) (*webhook, error) {
+	if options.signature_header == "" {
+		options.signature_header = DEFAULT_SIGNATURE_HEADER
+	}
+
+	if options.timeout <= 0 {
+		options.timeout = DEFAULT_WEBHOOK_TIMEOUT
+	}
+
+	if options.max_retries == 0 {
+		options.max_retries = DEFAULT_WEBHOOK_RETRIES
+	} else if options.max_retries < 0 {
+		options.max_retries = 0
+	}
+
+	result := &webhook{
+		url:      options.url,
+		headers:  http.Header{},
+		hmac_key: options.hmac_key,
+		header:   options.signature_header,
+		retries:  options.max_retries,
+	}
+
+	if isSecretUrl(options.url) {
+		secret, err := resolveSecretUrl(ctx, scope,
+			constants.WEBHOOK_SECRETS, options.url)
+		if err != nil {
+			return nil, err
+		}
+		result.url = secret.base_url
+		result.headers = secret.headers
+		if secret.hmac_key != "" {
+			result.hmac_key = secret.hmac_key
+		}
+	}
+
+	if options.headers != nil {
+		for _, k := range options.headers.Keys() {
+			v, _ := options.headers.Get(k)
+			result.headers.Set(k, utils.ToString(v))
+		}
+	}
+
+	config_obj, _ := artifacts.GetConfig(scope)
+	transport, err := networking.GetHttpTransport(config_obj, "")
+	if err != nil {
+		return nil, err
+	}
+
+	result.client = &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(options.timeout) * time.Second,
+	}
+	return result, nil
+}
+
+type webhookResult struct {
+	status   int
+	response string
+	attempts int64
+	err      error
+}
+
+func (self *webhookResult) Row(url string) *ordereddict.Dict {
+	row := ordereddict.NewDict().
+		Set("Url", redactUrl(url)).
+		Set("StatusCode", self.status).
+		Set("Response", self.response).
+		Set("Attempts", self.attempts)
+	if self.err != nil {
+		row.Set("Error", self.err.Error())
+	}
+	return row
+}
+
+// Post the body, retrying failures which may be temporary.
+func (self *webhook) Deliver(ctx context.Context, body []byte) *webhookResult {
+	result := &webhookResult{}
+	delay := webhook_retry_delay
+
+	for {
+		result.attempts++
+		result.status, result.response, result.err = self.post(ctx, body)
+		if !webhookRetryable(result.status, result.err) ||
+			result.attempts > self.retries {
+			return result
+		}
+
+		select {
+		case <-ctx.Done():
+			result.err = ctx.Err()
+			return result
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (self *webhook) post(ctx context.Context, body []byte) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		self.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+
+	for k, v := range self.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if self.hmac_key != "" {
+		// Signed each attempt so retries have a fresh timestamp.
+		timestamp := strconv.FormatInt(utils.GetTime().Now().Unix(), 10)
+		req.Header.Set(WEBHOOK_TIMESTAMP_HEADER, timestamp)
+		req.Header.Set(self.header,
+			"sha256="+signWebhook(self.hmac_key, timestamp, body))
+	}
+
+	resp, err := self.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, MAX_WEBHOOK_RESPONSE))
+	response := cleanText(string(data))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, response, fmt.Errorf(
+			"Webhook returned status %v", resp.StatusCode)
+	}
+	return resp.StatusCode, response, nil
+}
+
+func signWebhook(key, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Other client errors will fail again so are not retried.
+func webhookRetryable(status int, err error) bool {
+	if err == nil {
+		return false
+	}
+	return status == 0 || status == http.StatusTooManyRequests ||
+		status >= 500
+}
+
+func (self OllamaWebhookPlugin) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.PluginInfo {
+	return &vfilter.PluginInfo{
+		Name:     "ollama_webhook",
+		Doc:      "Post rows such as AI verdicts to a webhook as JSON, optionally signed with HMAC-SHA256.",
+		ArgType:  type_map.AddType(scope, &OllamaWebhookPluginArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterPlugin(&OllamaWebhookPlugin{})
+}
@@ -0,0 +1,337 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/acls"
+	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
+	"www.velocidex.com/golang/vfilter"
+	"www.velocidex.com/golang/vfilter/arg_parser"
+)
+
+// Ways to shrink query rows which do not fit in the context window.
+const (
+	TRUNCATE_HEAD      = "head"
+	TRUNCATE_TAIL      = "tail"
+	TRUNCATE_MIDDLE    = "middle"
+	TRUNCATE_SUMMARIZE = "summarize"
+)
+
+const (
+	// Ollama's context size when neither the request nor the
+	// model's parameters set num_ctx.
+	DEFAULT_NUM_CTX = 2048
+
+	// Tokens left free for the response unless num_predict is set.
+	DEFAULT_RESPONSE_RESERVE = 512
+)
+
+// The number of tokens the server will actually use for the model.
+func (self *Client) ContextWindow(ctx context.Context,
+	model string, options *ordereddict.Dict) (int64, error) {
+	if options != nil {
+		num_ctx, pres := options.GetInt64("num_ctx")
+		if pres && num_ctx > 0 {
+			return num_ctx, nil
+		}
+	}
+
+	info, err := self.Show(ctx, model)
+	if err != nil {
+		return 0, err
+	}
+	return info.ContextWindow(), nil
+}
+
+func (self *ShowResponse) ContextWindow() int64 {
+	// Parameters are lines like "num_ctx    8192"
+	for _, line := range strings.Split(self.Parameters, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "num_ctx" {
+			num_ctx, err := strconv.ParseInt(fields[1], 10, 64)
+			if err == nil && num_ctx > 0 {
+				return num_ctx
+			}
+		}
+	}
+
+	return DEFAULT_NUM_CTX
+}
+
+func responseReserve(options *ordereddict.Dict) int64 {
+	if options != nil {
+		num_predict, pres := options.GetInt64("num_predict")
+		if pres && num_predict > 0 {
+			return num_predict
+		}
+	}
+	return DEFAULT_RESPONSE_RESERVE
+}
+
+// Trims JSONL rows to a token budget. The head strategy keeps the
+// first rows, tail the last rows and middle drops rows from the
+// middle. Summarize replaces the rows with model summaries of chunks
+// which each fit the budget.
+type promptFitter struct {
+	strategy   string
+	model      string
+	max_tokens int64
+
+	summarize func(ctx context.Context, chunk string) (string, error)
+}
+
+func newPromptFitter(strategy, model string, max_tokens int64) (
+	*promptFitter, error) {
+	switch strategy {
+	case TRUNCATE_HEAD, TRUNCATE_TAIL, TRUNCATE_MIDDLE, TRUNCATE_SUMMARIZE:
+	default:
+		return nil, fmt.Errorf("invalid truncate strategy %v", strategy)
+	}
+
+	if max_tokens <= 0 {
+		return nil, fmt.Errorf(
+			"prompt does not fit in the context window (%v tokens over)",
+			-max_tokens)
+	}
+
+	return &promptFitter{
+		strategy:   strategy,
+		model:      model,
+		max_tokens: max_tokens,
+	}, nil
+}
+
+// Returns the rows which fit and if any rows were left out or
+// replaced by summaries.
+func (self *promptFitter) Fit(ctx context.Context,
+	lines []string) (string, bool, error) {
+	serialized := joinRows(lines)
+	if EstimateTokens(self.model, serialized) <= self.max_tokens {
+		return serialized, false, nil
+	}
+
+	switch self.strategy {
+	case TRUNCATE_TAIL:
+		return self.keep(lines, 0, len(lines)), true, nil
+
+	case TRUNCATE_MIDDLE:
+		return self.keep(lines, len(lines), len(lines)), true, nil
+
+	case TRUNCATE_SUMMARIZE:
+		if self.summarize != nil {
+			summary, err := self.summarizeLines(ctx, lines)
+			return summary, true, err
+		}
+	}
+
+	return self.keep(lines, len(lines), 0), true, nil
+}
+
+// Keep up to max_head rows from the start and max_tail rows from the
+// end, alternating between them while they fit.
+func (self *promptFitter) keep(lines []string, max_head, max_tail int) string {
+	marker := fmt.Sprintf("... %d of %d rows omitted\n", len(lines), len(lines))
+	budget := self.max_tokens - EstimateTokens(self.model, marker)
+
+	head := []string{}
+	tail := []string{}
+	i, j := 0, len(lines)-1
+	for i <= j {
+		from_tail := len(head) >= max_head ||
+			(len(tail) < max_tail && len(head) > len(tail))
+		if from_tail && len(tail) >= max_tail {
+			break
+		}
+
+		l := lines[i]
+		if from_tail {
+			l = lines[j]
+		}
+
+		tokens := EstimateTokens(self.model, l+"\n")
+		if tokens > budget {
+			break
+		}
+		budget -= tokens
+
+		if from_tail {
+			tail = append(tail, l)
+			j--
+		} else {
+			head = append(head, l)
+			i++
+		}
+	}
+
+	result := &strings.Builder{}
+	for _, l := range head {
+		result.WriteString(l)
+		result.WriteString("\n")
+	}
+
+	fmt.Fprintf(result, "... %d of %d rows omitted\n",
+		len(lines)-len(head)-len(tail), len(lines))
+
+	for k := len(tail) - 1; k >= 0; k-- {
+		result.WriteString(tail[k])
+		result.WriteString("\n")
+	}
+	return result.String()
+}
+
+func (self *promptFitter) summarizeLines(ctx context.Context,
+	lines []string) (string, error) {
+	summaries := []string{}
+	chunk := &strings.Builder{}
+	chunk_tokens := int64(0)
+
+	flush := func() error {
+		if chunk.Len() == 0 {
+			return nil
+		}
+		summary, err := self.summarize(ctx, chunk.String())
+		if err != nil {
+			return err
+		}
+		summaries = append(summaries, strings.TrimSpace(summary))
+		chunk.Reset()
+		chunk_tokens = 0
+		return nil
+	}
+
+	for _, l := range lines {
+		tokens := EstimateTokens(self.model, l+"\n")
+		if chunk_tokens > 0 && chunk_tokens+tokens > self.max_tokens {
+			err := flush()
+			if err != nil {
+				return "", err
+			}
+		}
+		chunk.WriteString(l)
+		chunk.WriteString("\n")
+		chunk_tokens += tokens
+	}
+
+	err := flush()
+	if err != nil {
+		return "", err
+	}
+
+	// The summaries themselves may still be too large.
+	result := fmt.Sprintf("Summary of %d rows:\n%s\n", len(lines),
+		strings.Join(summaries, "\n"))
+	if EstimateTokens(self.model, result) > self.max_tokens {
+		return self.keep(strings.Split(strings.TrimSuffix(result, "\n"), "\n"),
+			len(lines), 0), nil
+	}
+	return result, nil
+}
+
+// Shrink the query rows so the request fits in the context window,
+// leaving room for the response. The header of tabular rows is
+// always kept. Returns if any rows were left out or summarized.
+func fitRows(ctx context.Context, client *Client,
+	req *GenerateRequest, strategy, header string,
+	rows []string) (string, bool, error) {
+	num_ctx, err := client.ContextWindow(ctx, req.Model, req.Options)
+	if err != nil {
+		return "", false, err
+	}
+
+	used := EstimateTokens(req.Model, req.System) +
+		EstimateTokens(req.Model, req.Prompt+"\n\n"+header) +
+		int64(len(req.Context)) + responseReserve(req.Options)
+
+	fitter, err := newPromptFitter(strategy, req.Model, num_ctx-used)
+	if err != nil {
+		return "", false, err
+	}
+
+	fitter.summarize = func(ctx context.Context, chunk string) (string, error) {
+		summary_req := &GenerateRequest{
+			Model: req.Model,
+			Prompt: fmt.Sprintf("The following rows are part of the data "+
+				"for this question:\n%v\n\nSummarize the rows, keeping all "+
+				"details relevant to the question.\n\n%v", req.Prompt, chunk),
+			Options:   req.Options,
+			KeepAlive: req.KeepAlive,
+		}
+
+		result := &strings.Builder{}
+		err := client.Generate(ctx, summary_req, func(resp *GenerateResponse) error {
+			result.WriteString(resp.Response)
+			return nil
+		})
+		return result.String(), err
+	}
+
+	fitted, dropped, err := fitter.Fit(ctx, rows)
+	if err != nil {
+		return "", false, err
+	}
+	return header + fitted, dropped, nil
+}
+
+type OllamaShowFunctionArgs struct {
+	Model   string `vfilter:"required,field=model,doc=The model to describe."`
+	BaseUrl string `vfilter:"optional,field=base_url,doc=The URL of the Ollama server (default http://localhost:11434). Use secret://name for an endpoint stored in an HTTP Secret or mock:// for canned responses without a server. On clients server:// sends the calls through the Velociraptor server. Several comma separated URLs, or srv://name for the servers of a DNS SRV record, spread the calls over the servers."`
+}
+
+type OllamaShowFunction struct{}
+
+func (self OllamaShowFunction) Call(ctx context.Context,
+	scope vfilter.Scope,
+	args *ordereddict.Dict) vfilter.Any {
+	defer vql_subsystem.RegisterMonitor("ollama_show", args)()
+
+	err := vql_subsystem.CheckAccess(scope, acls.COLLECT_SERVER)
+	if err != nil {
+		scope.Log("ollama_show: %v", err)
+		return vfilter.Null{}
+	}
+
+	arg := &OllamaShowFunctionArgs{}
+	err = arg_parser.ExtractArgsWithContext(ctx, scope, args, arg)
+	if err != nil {
+		scope.Log("ollama_show: %v", err)
+		return vfilter.Null{}
+	}
+
+	client, err := NewClient(ctx, scope, arg.BaseUrl)
+	if err != nil {
+		scope.Log("ollama_show: %v", err)
+		return vfilter.Null{}
+	}
+
+	info, err := client.Show(ctx, arg.Model)
+	if err != nil {
+		scope.Log("ollama_show: %v", err)
+		return vfilter.Null{}
+	}
+
+	return ordereddict.NewDict().
+		Set("Model", arg.Model).
+		Set("ContextWindow", info.ContextWindow()).
+		Set("Parameters", info.Parameters).
+		Set("Template", info.Template).
+		Set("Details", info.Details).
+		Set("ModelInfo", info.ModelInfo)
+}
+
+func (self OllamaShowFunction) Info(
+	scope vfilter.Scope, type_map *vfilter.TypeMap) *vfilter.FunctionInfo {
+	return &vfilter.FunctionInfo{
+		Name:     "ollama_show",
+		Doc:      "Show information about an Ollama model, including the context window the server uses for it.",
+		ArgType:  type_map.AddType(scope, &OllamaShowFunctionArgs{}),
+		Metadata: vql_subsystem.VQLMetadata().Permissions(acls.COLLECT_SERVER).Build(),
+	}
+}
+
+func init() {
+	vql_subsystem.RegisterFunction(&OllamaShowFunction{})
+}
@@ -0,0 +1,78 @@
+package ollama
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeRowList(count int) []string {
+	return strings.Split(strings.TrimSuffix(makeRows(count), "\n"), "\n")
+}
+
+func TestPromptFitter(t *testing.T) {
+	ctx := context.Background()
+
+	// Rows which fit are not changed.
+	fitter, err := newPromptFitter(TRUNCATE_HEAD, "", 60)
+	require.NoError(t, err)
+
+	result, dropped, err := fitter.Fit(ctx, makeRowList(10))
+	require.NoError(t, err)
+	assert.Equal(t, makeRows(10), result)
+	assert.False(t, dropped)
+
+	fitter, err = newPromptFitter(TRUNCATE_HEAD, "", 30)
+	require.NoError(t, err)
+
+	result, dropped, err = fitter.Fit(ctx, makeRowList(10))
+	require.NoError(t, err)
+	assert.True(t, dropped)
+	assert.Equal(t, makeRows(4)+"... 6 of 10 rows omitted\n", result)
+
+	fitter, err = newPromptFitter(TRUNCATE_TAIL, "", 30)
+	require.NoError(t, err)
+
+	result, dropped, err = fitter.Fit(ctx, makeRowList(10))
+	require.NoError(t, err)
+	assert.True(t, dropped)
+	assert.Equal(t, `... 6 of 10 rows omitted
+{"Row":6}
+{"Row":7}
+{"Row":8}
+{"Row":9}
+`, result)
+
+	fitter, err = newPromptFitter(TRUNCATE_MIDDLE, "", 30)
+	require.NoError(t, err)
+
+	result, dropped, err = fitter.Fit(ctx, makeRowList(10))
+	require.NoError(t, err)
+	assert.True(t, dropped)
+	assert.Equal(t, `{"Row":0}
+{"Row":1}
+... 6 of 10 rows omitted
+{"Row":8}
+{"Row":9}
+`, result)
+
+	fitter, err = newPromptFitter(TRUNCATE_SUMMARIZE, "", 30)
+	require.NoError(t, err)
+
+	fitter.summarize = func(ctx context.Context, chunk string) (string, error) {
+		return fmt.Sprintf("%d rows", strings.Count(chunk, "\n")), nil
+	}
+
+	result, dropped, err = fitter.Fit(ctx, makeRowList(10))
+	require.NoError(t, err)
+	assert.True(t, dropped)
+	assert.Equal(t, "Summary of 10 rows:\n6 rows\n4 rows\n", result)
+
+	// The rest of the prompt is already too large.
+	_, err = newPromptFitter(TRUNCATE_HEAD, "", -5)
+	assert.Error(t, err)
+}
@@ -45,6 +45,8 @@ import (
 	_ "www.velocidex.com/golang/velociraptor/vql/tools/collector"
 	_ "www.velocidex.com/golang/velociraptor/vql/tools/dns"
 	_ "www.velocidex.com/golang/velociraptor/vql/tools/logscale"
+	_ "www.velocidex.com/golang/velociraptor/vql/tools/mcp"
+	_ "www.velocidex.com/golang/velociraptor/vql/tools/ollama"
 	_ "www.velocidex.com/golang/velociraptor/vql/tools/process"
 	_ "www.velocidex.com/golang/velociraptor/vql/tools/rsyslog"
 )